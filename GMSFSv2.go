@@ -1,15 +1,18 @@
 package GMSFS
 
 import (
-	"fmt"
+	"context"
+	"errors"
 	cmap "github.com/orcaman/concurrent-map/v2"
-	"io"
 	"os"
 	"path/filepath"
+	"reflect"
 	"runtime"
 	"sort"
 	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 )
 
@@ -22,6 +25,7 @@ type FileInfo struct {
 	IsDir        bool
 	Contents     []FileInfo // Names of files for directories
 	Name         string
+	IsSymlink    bool // Only set by Lstat and ReadDir, which do not follow links
 }
 
 const timeFlat = "20060102_1504"
@@ -32,55 +36,166 @@ var CachedFiles = cmap.New[[]byte]()
 type FileHandleInstance struct {
 	File  *os.File
 	Timer *time.Timer
+	mu    sync.Mutex
 }
 
 func errorPrinter(log string, object string) {
-	if _, err := os.Stat("GMSFS.Debug"); err != nil {
-		if os.IsNotExist(err) {
-			return
-		}
+	recordError(log, object, "")
+	logPrinter(LevelError, log, object, "")
+}
+
+// errorPrinterCtx is errorPrinter tagging the entry with the context's correlation ID
+func errorPrinterCtx(ctx context.Context, log string, object string) {
+	id := CorrelationID(ctx)
+	recordError(log, object, id)
+	logPrinter(LevelError, log, object, id)
+}
+
+func warnPrinter(log string, object string) {
+	logPrinter(LevelWarn, log, object, "")
+}
+
+func infoPrinter(log string, object string) {
+	logPrinter(LevelInfo, log, object, "")
+}
+
+// pkgPrefix starts the names of this package's functions
+var pkgPrefix = reflect.TypeOf(FileInfo{}).PkgPath() + "."
+
+func logPrinter(level Level, log string, object string, correlationID string) {
+	logger := currentLogger.Load().logger
+	if logger == nil || level < Level(minLogLevel.Load()) {
+		return
 	}
 
+	// The first function outside the package, so wrappers such as ReadFile around
+	// ReadFileContext don't hide the caller; on the package's own goroutines the function
+	// 2 levels above the one that logged
 	stack := ""
-	pc, _, _, ok := runtime.Caller(2) // 2 level up the call stack
-	if ok {
-		fn := runtime.FuncForPC(pc)
-		if fn != nil {
-			file, line := fn.FileLine(fn.Entry())
-			stack = " (2):" + fn.Name() + " file: " + file + " line: " + strconv.Itoa(line)
+	pcs := make([]uintptr, 32)
+	frames := runtime.CallersFrames(pcs[:runtime.Callers(4, pcs)])
+	var first runtime.Frame
+	for {
+		frame, more := frames.Next()
+		if first.Entry == 0 {
+			first = frame
+		}
+		if !strings.HasPrefix(frame.Function, pkgPrefix) {
+			first = frame
+			break
+		}
+		if !more {
+			break
 		}
 	}
+	if fn := runtime.FuncForPC(first.Entry); fn != nil {
+		file, line := fn.FileLine(fn.Entry())
+		stack = fn.Name() + " file: " + file + " line: " + strconv.Itoa(line)
+	}
+
+	logger.Log(LogEntry{
+		Time:          time.Now(),
+		Level:         level,
+		Message:       log,
+		Path:          object,
+		Caller:        stack,
+		CorrelationID: correlationID,
+	})
+}
+
+var stripColonPrefix atomic.Bool
 
-	AppendStringToFile("GMSFS."+time.Now().Format(timeFlat)+".log", log+" stacktrace: "+stack+"\r\n")
+// SetStripColonPrefix makes the package drop a "prefix:" from the paths it is given, so
+// "data:/var/x" means "/var/x", as earlier versions always did. Windows drive letters and UNC
+// volumes are kept either way, and registered backend schemes take precedence. Disabled by
+// default.
+func SetStripColonPrefix(enabled bool) {
+	stripColonPrefix.Store(enabled)
+}
+
+// StripColonPrefix reports whether colon prefixes are stripped
+func StripColonPrefix() bool {
+	return stripColonPrefix.Load()
 }
 
 func cleanPath(path string) string {
+	// Paths on registered backends keep their scheme
+	if scheme, _, rest, ok := splitScheme(path); ok {
+		return scheme + ":" + rest
+	}
+
 	path = filepath.Clean(path)
-	fs := strings.SplitN(path, ":", 2)
-	if len(fs) == 2 {
-		path = fs[1]
+	if stripColonPrefix.Load() {
+		path = filepath.Clean(stripColon(path))
 	}
+	path = normalizePath(path)
 
-	return path
+	// Deep trees on Windows need the \\?\ prefix to get past MAX_PATH
+	return longPath(path)
+}
+
+// stripColon drops every "prefix:" after the volume name, so "C:\data\x" and
+// "\\server\share\x" stay whole while "tag:C:\data\x" becomes "C:\data\x". Stripping
+// until none is left keeps cleanPath idempotent for names like "a:b:c".
+func stripColon(path string) string {
+	for {
+		vol := filepath.VolumeName(path)
+		_, rest, ok := strings.Cut(path[len(vol):], ":")
+		if !ok {
+			return path
+		}
+		path = rest
+	}
 }
 
 func OpenFile(name string, flag int, perm os.FileMode) (*os.File, error) {
+	if err := requireLocal("open", name); err != nil {
+		errorPrinter("OpenFile: "+err.Error(), name)
+		return nil, opError("open", name, "", err)
+	}
+
+	if flag&os.O_TRUNC == 0 {
+		if err := retrieveIfStub(context.Background(), name); err != nil {
+			return nil, opError("open", name, "", err)
+		}
+	}
+	delta, err := quotaOpening(name, flag)
+	if err != nil {
+		errorPrinter("OpenFile: "+err.Error(), name)
+		return nil, opError("open", name, "", err)
+	}
+
+	simulateOp()
 	file, err := os.OpenFile(name, flag, perm)
 	if err != nil {
+		quotaRelease(name, delta)
 		errorPrinter("OpenFile: "+err.Error(), name)
-		return nil, err
+		return nil, opError("open", name, "", err)
+	}
+	if flagWrites(flag) {
+		invalidateStat(name)
+		trackWriter(name, file)
 	}
 	return file, nil
 }
 
 func Open(name string) (*os.File, error) {
 	name = cleanPath(name)
+	if err := requireLocal("open", name); err != nil {
+		errorPrinter("Open: "+err.Error(), name)
+		return nil, opError("open", name, "", err)
+	}
+	if err := retrieveIfStub(context.Background(), name); err != nil {
+		return nil, opError("open", name, "", err)
+	}
+
+	simulateOp()
 
 	// Open the file using os.Open
 	file, err := os.Open(name)
 	if err != nil {
 		errorPrinter("Open: "+err.Error(), name)
-		return nil, err
+		return nil, opError("open", name, "", err)
 	}
 
 	return file, nil
@@ -88,100 +203,133 @@ func Open(name string) (*os.File, error) {
 
 func Create(name string) (*os.File, error) {
 	name = cleanPath(name)
-
-	file, err := os.Create(name)
-	if err != nil {
+	if err := requireLocal("open", name); err != nil {
 		errorPrinter("Create: "+err.Error(), name)
-		return nil, err
+		return nil, opError("create", name, "", err)
 	}
 
-	return file, nil
-}
-
-func CopyDir(src string, dst string) error {
-	src = cleanPath(src)
-	dst = cleanPath(dst)
-
-	si, err := os.Stat(src) // Directly use os.Stat
+	delta, err := quotaOpening(name, os.O_RDWR|os.O_CREATE|os.O_TRUNC)
 	if err != nil {
-		errorPrinter("CopyDir (os.Stat): "+err.Error(), src)
-		return err
-	}
-	if !si.IsDir() {
-		return fmt.Errorf("source is not a directory")
-	}
-
-	if _, err := os.Stat(dst); !os.IsNotExist(err) {
-		errorPrinter("CopyDir: File already exist", dst)
-		return fmt.Errorf("destination already exists")
+		errorPrinter("Create: "+err.Error(), name)
+		return nil, opError("create", name, "", err)
 	}
 
-	err = os.MkdirAll(dst, si.Mode())
-	if err != nil {
-		errorPrinter("CopyDir (os.MkdirAll): "+err.Error(), dst)
-		return err
-	}
+	simulateOp()
 
-	entries, err := os.ReadDir(src) // Directly use os.ReadDir
+	file, err := os.Create(name)
 	if err != nil {
-		errorPrinter("CopyDir (os.ReadDir): "+err.Error(), src)
-		return err
+		quotaRelease(name, delta)
+		errorPrinter("Create: "+err.Error(), name)
+		return nil, opError("create", name, "", err)
 	}
+	invalidateStat(name)
+	trackWriter(name, file)
 
-	for _, entry := range entries {
-		srcPath := filepath.Join(src, entry.Name())
-		dstPath := filepath.Join(dst, entry.Name())
-
-		if entry.IsDir() {
-			err = CopyDir(srcPath, dstPath)
-			if err != nil {
-				errorPrinter("CopyDir (CopyDir-1): "+err.Error(), srcPath)
-				errorPrinter("CopyDir (CopyDir-2): "+err.Error(), dstPath)
-				return err
-			}
-		} else {
-			// Skip symlinks
-			if entry.Type()&os.ModeSymlink != 0 {
-				continue
-			}
+	return file, nil
+}
 
-			err = CopyFile(srcPath, dstPath)
-			if err != nil {
-				errorPrinter("CopyDir (CopyFile-1): "+err.Error(), srcPath)
-				errorPrinter("CopyDir (CopyFile-2): "+err.Error(), dstPath)
-				return err
-			}
-		}
+// CreateAll is Create making the missing parent directories of name first, with perm as
+// MkdirAll does
+func CreateAll(name string, perm os.FileMode) (*os.File, error) {
+	name = cleanPath(name)
+	if err := MkdirAll(parentDir(name), perm); err != nil {
+		errorPrinter("CreateAll: "+err.Error(), name)
+		return nil, err
 	}
+	return Create(name)
+}
 
-	return nil
+// CopyDir copies the tree at src to dst, which must not exist
+//
+// Deprecated: use CopyDirWithOptions, which this calls with no options.
+func CopyDir(src string, dst string) error {
+	legacyCall("CopyDir", "CopyDirWithOptions")
+	return CopyDirWithOptions(src, dst, CopyOptions{})
 }
 
 func Delete(name string) error {
+	return DeleteContext(context.Background(), name)
+}
+
+// DeleteContext is Delete tagging its log entries with the correlation ID of ctx
+func DeleteContext(ctx context.Context, name string) error {
+	if err := ctx.Err(); err != nil {
+		return opError("remove", name, "", err)
+	}
+	closeAppendHandle(name)
+	simulateOp()
+
 	// Remove the file from the filesystem
-	err := os.Remove(name) // Use original case for filesystem operations
+	b, p := backendFor(name)
+	size := quotaRemoving(b, p, name)
+	err := removeFile(b, p, name) // Use original case for filesystem operations
 	if err != nil {
-		errorPrinter("Delete: "+err.Error(), name)
-		return err
+		errorPrinterCtx(ctx, "Delete: "+err.Error(), name)
+		return opError("remove", name, "", err)
 	}
+	quotaRelease(name, size)
+	invalidateStat(name)
+	dropTags(name)
+	forgetTemp(name)
 
 	return nil
 }
 
 func ReadFile(name string) ([]byte, error) {
+	return ReadFileContext(context.Background(), name)
+}
+
+// ReadFileContext is ReadFile tagging its log entries with the correlation ID of ctx
+func ReadFileContext(ctx context.Context, name string) ([]byte, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, opError("read", name, "", err)
+	}
+	if err := guardSpecialFile("read", name); err != nil {
+		errorPrinterCtx(ctx, "ReadFile: "+err.Error(), name)
+		return nil, opError("read", name, "", err)
+	}
+
 	// Read the file contents
-	content, err := os.ReadFile(name) // Use the original case for filesystem operations
+	profile := profileFor(name)
+	b, p := backendFor(name)
+	var content []byte
+	err := profile.retry(func() (err error) {
+		simulateOp()
+		content, err = readFile(b, p) // Use the original case for filesystem operations
+		return err
+	})
 	if err != nil {
-		errorPrinter("ReadFile: "+err.Error(), name)
-		return nil, err
+		errorPrinterCtx(ctx, "ReadFile: "+err.Error(), name)
+		return nil, opError("read", name, "", err)
 	}
 
+	// A file moved away by tiering is materialized by its retriever, or else read from its
+	// archived copy when a tiering rule covers it
+	if stub, ok, _ := parseStub(content); ok {
+		if fn, ok := retrieverFor(stub.Location); ok {
+			err = retrieveStub(ctx, name, fn)
+			if err == nil {
+				content, err = readFile(b, p)
+			}
+		} else if loc, ok, terr := stubTarget(name, stub); terr != nil {
+			errorPrinterCtx(ctx, "ReadFile (stub): "+terr.Error(), name)
+			err = terr
+		} else if ok {
+			content, err = readStubContent(ctx, name, stub, loc)
+		}
+		if err != nil {
+			return nil, opError("read", name, "", err)
+		}
+	}
+	simulateRead(len(content))
+	profile.throttleRead(len(content))
+
 	return content, nil
 }
 
 func FileExists(name string) bool {
-	_, err := os.Stat(name)
-	if os.IsNotExist(err) {
+	_, err := Stat(name)
+	if errors.Is(err, os.ErrNotExist) {
 		return false
 	} else if err == nil {
 		return true
@@ -191,11 +339,14 @@ func FileExists(name string) bool {
 
 func Mkdir(name string, perm os.FileMode) error {
 	name = cleanPath(name) // Preserve original name for file operation
-	err := os.Mkdir(name, perm)
+	simulateOp()
+	b, p := backendFor(name)
+	err := b.Mkdir(p, perm)
 	if err != nil {
 		errorPrinter("Mkdir: "+err.Error(), name)
-		return err
+		return opError("mkdir", name, "", err)
 	}
+	invalidateStat(name)
 
 	return nil
 }
@@ -207,19 +358,68 @@ func MkdirAll(path string, perm os.FileMode) error {
 		return nil
 	}
 
-	err := os.MkdirAll(path, perm)
+	simulateOp()
+	b, p := backendFor(path)
+	err := b.MkdirAll(p, perm)
 	if err != nil {
-		return err
+		return opError("mkdir", path, "", err)
 	}
+	invalidateStat(path)
 
 	return nil
 }
 
 func Append(name string, content []byte) error {
+	return AppendContext(context.Background(), name, content)
+}
+
+// AppendContext is Append tagging its log entries with the correlation ID of ctx
+func AppendContext(ctx context.Context, name string, content []byte) (err error) {
+	defer func() { err = opError("append", name, "", err) }()
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
 	var file *os.File
-	var err error
 
-	// If not, open the file and store the handle in the map
+	delta, err := quotaAppending(name, int64(len(content)))
+	if err != nil {
+		errorPrinterCtx(ctx, "Append: "+err.Error(), name)
+		return err
+	}
+	defer func() {
+		if err != nil {
+			quotaRelease(name, delta)
+		}
+	}()
+
+	simulateOp()
+	simulateWrite(len(content))
+	profile := profileFor(name)
+	profile.throttleWrite(len(content))
+
+	if !isLocal(name) {
+		err = profile.retry(func() error { return appendBackend(name, content, profile) })
+		invalidateStat(name)
+		if err != nil {
+			errorPrinterCtx(ctx, "Append: "+err.Error(), name)
+		}
+		return err
+	}
+
+	// Reuse a pooled handle unless pooling is switched off or would exceed the fd budget
+	if idle := AppendIdleTimeout(); idle > 0 {
+		err = profile.retry(func() error { return appendPooled(ctx, name, content, idle, profile) })
+		if err != errFDBudget {
+			invalidateStat(name)
+			return err
+		}
+	}
+
+	// The debug logger appends too, so this must not wait for the budget
+	takeFDs(1)
+	defer releaseFDs(1)
+
 	file, err = os.OpenFile(name, os.O_APPEND|os.O_WRONLY|os.O_CREATE, 0644)
 	if err != nil {
 		return err
@@ -228,133 +428,316 @@ func Append(name string, content []byte) error {
 
 	// Write the content to the file
 	_, err = file.Write(content)
+	if err == nil && profile != nil && profile.Durable {
+		err = file.Sync()
+	}
+	invalidateStat(name)
 	if err != nil {
-		errorPrinter("Append: "+err.Error(), name)
+		errorPrinterCtx(ctx, "Append: "+err.Error(), name)
 		return err
 	}
 
 	return nil
 }
 
+// AppendStringToFile appends content to the file name
+//
+// Deprecated: use Append.
 func AppendStringToFile(name string, content string) error {
+	legacyCall("AppendStringToFile", "Append")
 	return Append(name, []byte(content))
 }
 
 func WriteFile(name string, content []byte, perm os.FileMode) error {
+	return WriteFileContext(context.Background(), name, content, perm)
+}
+
+// WriteFileContext is WriteFile for callers passing a request context, which is checked
+// before writing
+func WriteFileContext(ctx context.Context, name string, content []byte, perm os.FileMode) error {
+	if err := ctx.Err(); err != nil {
+		return opError("write", name, "", err)
+	}
 	name = cleanPath(name)
 
 	// Write the new content to the file
-	err := os.WriteFile(name, content, perm)
+	profile := profileFor(name)
+	simulateWrite(len(content))
+	profile.throttleWrite(len(content))
+	b, p := backendFor(name)
+	delta, err := quotaReplace(b, p, name, int64(len(content)))
+	if err != nil {
+		errorPrinterCtx(ctx, "WriteFile: "+err.Error(), name)
+		return opError("write", name, "", err)
+	}
+	durable := profile != nil && profile.Durable
+	err = profile.retry(func() error {
+		simulateOp()
+		return writeFile(b, p, content, perm, durable)
+	})
+	invalidateStat(name)
 
 	if err != nil {
-		return err
+		quotaRelease(name, delta)
+		return opError("write", name, "", err)
 	}
 
 	return nil
 }
 
+// WriteFileAll is WriteFile making the missing parent directories of name first, with dirPerm
+// as MkdirAll does
+func WriteFileAll(name string, content []byte, perm os.FileMode, dirPerm os.FileMode) error {
+	name = cleanPath(name)
+	if err := MkdirAll(parentDir(name), dirPerm); err != nil {
+		errorPrinter("WriteFileAll: "+err.Error(), name)
+		return err
+	}
+	return WriteFile(name, content, perm)
+}
+
 func FileSize(name string) (int64, error) {
-	// If not in cache, get file size from the filesystem
-	stat, err := os.Stat(name) // Original name for filesystem operation
+	// Served from the stat cache when possible
+	stat, err := Stat(name) // Original name for filesystem operation
 	if err != nil {
 		errorPrinter("FileSize: "+err.Error(), name)
 		return 0, err // File does not exist or other error occurred
 	}
 
-	return stat.Size(), nil
+	return stat.Size, nil
 }
 
+// FileSizeZeroOnError returns the size of name, or 0 when it cannot be read
+//
+// Deprecated: use FileSize, which reports errors.
 func FileSizeZeroOnError(name string) int64 {
-	// If not in cache, get file size from the filesystem
-	stat, err := os.Stat(name) // Original name for filesystem operation
+	legacyCall("FileSizeZeroOnError", "FileSize")
+	// Served from the stat cache when possible
+	stat, err := Stat(name) // Original name for filesystem operation
 	if err != nil {
 		return 0 // Return 0 if file does not exist or other error occurred
 	}
 
-	return stat.Size()
+	return stat.Size
 }
 
 func Rename(oldName, newName string) error {
+	return RenameContext(context.Background(), oldName, newName)
+}
+
+// RenameContext is Rename tagging its log entries with the correlation ID of ctx
+func RenameContext(ctx context.Context, oldName string, newName string) error {
+	if err := ctx.Err(); err != nil {
+		return opError("rename", oldName, newName, err)
+	}
 	if oldName == newName {
 		return nil
 	}
 
-	err := os.Rename(oldName, newName)
+	m := quotaMoving(oldName, newName)
+	if err := renameEntry(ctx, oldName, newName); err != nil {
+		return opError("rename", oldName, newName, err)
+	}
+	m.done()
+	moveTags(oldName, newName)
+	return nil
+}
+
+// renameEntry is RenameContext leaving tags alone, for replacing a file's content by renaming
+// a temporary file over it
+func renameEntry(ctx context.Context, oldName string, newName string) error {
+	closeAppendHandlesUnder(oldName)
+	closeAppendHandlesUnder(newName)
+
+	simulateOp()
+	err := renameBackend(oldName, newName)
 	if err != nil {
-		errorPrinter("Rename: "+err.Error(), oldName)
-		errorPrinter("Rename: "+err.Error(), newName)
+		errorPrinterCtx(ctx, "Rename: "+err.Error(), oldName)
+		errorPrinterCtx(ctx, "Rename: "+err.Error(), newName)
 		return err
 	}
+	invalidateStatTree(oldName)
+	invalidateStatTree(newName)
 
 	return nil
 }
 
 func CopyFile(src, dst string) (err error) {
+	return CopyFileContext(context.Background(), src, dst)
+}
+
+// CopyFileContext is CopyFile as a job: it shows up in Jobs, tags its log entries with the
+// correlation ID of ctx and checks ctx between chunks, so cancelling it or pausing it through
+// PauseJob or a JobControl takes effect within a large file. A JobControl attached with
+// WithJobControl receives progress.
+func CopyFileContext(ctx context.Context, src string, dst string) (err error) {
+	defer func() { err = opError("copy", src, dst, err) }()
+	if err := ctx.Err(); err != nil {
+		return err
+	}
 	src = cleanPath(src)
 	dst = cleanPath(dst)
 
-	in, err := os.Open(src)
+	if err := guardSpecialFile("copy", src); err != nil {
+		errorPrinterCtx(ctx, "CopyFile: "+err.Error(), src)
+		return err
+	}
+
+	ctx, j := startJob(ctx, "CopyFile", src, dst)
+	defer j.done()
+
+	var progress *copyProgress
+	if j.ctl.Progress != nil {
+		var size int64
+		b, p := backendFor(src)
+		if info, err := b.Stat(p); err == nil {
+			size = info.Size()
+		}
+		j.setTotal(size)
+		progress = newCopyProgress(j.ctl.Progress, size)
+	}
+	delta, err := quotaCopying(src, dst)
+	if err != nil {
+		errorPrinterCtx(ctx, "CopyFile: "+err.Error(), dst)
+		return err
+	}
+	err = profileFor(dst).retry(func() error { return copyFile(ctx, src, dst, progress) })
+	if err != nil {
+		quotaRelease(dst, delta)
+	}
+	if err == nil {
+		copyTags(src, dst)
+		progress.done(src, 0)
+		j.add(1, 0)
+	}
+	return err
+}
+
+func copyFile(ctx context.Context, src string, dst string, progress *copyProgress) (err error) {
+	// Large files between the local filesystem and S3 go in parallel parts
+	if ok, err := copyMultipart(ctx, src, dst, progress); ok {
+		if err != nil {
+			errorPrinterCtx(ctx, "CopyFile (multipart): "+err.Error(), src)
+		}
+		return err
+	}
+
+	simulateOp()
+	acquireFDs(2)
+	defer releaseFDs(2)
+
+	sb, sp := backendFor(src)
+	db, dp := backendFor(dst)
+
+	in, err := sb.Open(sp)
 	if err != nil {
-		errorPrinter("CopyFile (os.Open): "+err.Error(), src)
+		errorPrinterCtx(ctx, "CopyFile (os.Open): "+err.Error(), src)
 		return
 	}
 	defer in.Close()
 
-	out, err := os.Create(dst)
+	out, err := db.Create(dp)
 	if err != nil {
-		errorPrinter("CopyFile (os.Create): "+err.Error(), dst)
+		errorPrinterCtx(ctx, "CopyFile (os.Create): "+err.Error(), dst)
 		return
 	}
+	defer invalidateStat(dst)
 	defer func() {
 		if e := out.Close(); e != nil {
 			err = e
 		}
 	}()
 
-	_, err = io.Copy(out, in)
+	r := profileFor(src).reader(simulatedReader(progress.reader(src, in)))
+	dstProfile := profileFor(dst)
+	_, err = copyChunks(ctx, dstProfile.writer(simulatedWriter(out)), r, true)
 	if err != nil {
-		errorPrinter("CopyFile (io.Copy): "+err.Error(), "")
+		errorPrinterCtx(ctx, "CopyFile (io.Copy): "+err.Error(), "")
 		return
 	}
 
 	err = out.Sync()
 	if err != nil {
-		errorPrinter("CopyFile (out.Sync): "+err.Error(), "")
+		errorPrinterCtx(ctx, "CopyFile (out.Sync): "+err.Error(), "")
 		return
 	}
 
-	si, err := os.Stat(src)
+	si, err := sb.Stat(sp)
 	if err != nil {
-		errorPrinter("CopyFile (os.Stat): "+err.Error(), "")
+		errorPrinterCtx(ctx, "CopyFile (os.Stat): "+err.Error(), "")
 		return
 	}
-	err = os.Chmod(dst, si.Mode())
+	// Object stores have no modes
+	err = db.Chmod(dp, si.Mode())
+	if errors.Is(err, errors.ErrUnsupported) {
+		err = nil
+	}
 	if err != nil {
-		errorPrinter("CopyFile (os.Chmod): "+err.Error(), "")
+		errorPrinterCtx(ctx, "CopyFile (os.Chmod): "+err.Error(), "")
 		return
 	}
 
+	if dstProfile != nil && dstProfile.Durable && isLocal(dst) {
+		err = syncDir(filepath.Dir(dst))
+		if err != nil {
+			errorPrinterCtx(ctx, "CopyFile (syncDir): "+err.Error(), dst)
+			return
+		}
+	}
+
 	return
 }
 
 func Remove(name string) error {
-	err := os.Remove(name)
+	closeAppendHandle(name)
+	simulateOp()
+
+	b, p := backendFor(name)
+	size := quotaRemoving(b, p, name)
+	err := removeFile(b, p, name)
 	if err != nil {
 		errorPrinter("Remove: "+err.Error(), name)
-		return err
+		return opError("remove", name, "", err)
 	}
+	quotaRelease(name, size)
+	invalidateStat(name)
+	dropTags(name)
+	forgetTemp(name)
 
 	return nil
 }
 
 func RemoveAll(path string) error {
+	return RemoveAllContext(context.Background(), path)
+}
+
+// RemoveAllContext is RemoveAll for callers passing a request context, which is checked
+// before removing
+func RemoveAllContext(ctx context.Context, path string) error {
+	if err := ctx.Err(); err != nil {
+		return opError("remove", path, "", err)
+	}
 	path = cleanPath(path)
-	oserr := os.RemoveAll(path)
+	simulateOp()
+	closeAppendHandlesUnder(path)
+	b, p := backendFor(path)
+	oserr := b.RemoveAll(p)
+	invalidateStatTree(path)
+	quotaRecount(path)
+	if oserr == nil {
+		dropTags(path)
+		forgetTemp(path)
+	}
 
-	return oserr
+	return opError("remove", path, "", oserr)
 }
 
+// ListFS returns the names in the directory path, directories prefixed with "*", and nothing
+// on errors
+//
+// Deprecated: use ReadDir, which reports errors.
 func ListFS(path string) []string {
+	legacyCall("ListFS", "ReadDir")
 	var sysSlices []string
 
 	// First, check if the path is a directory
@@ -383,7 +766,12 @@ func ListFS(path string) []string {
 	return sysSlices
 }
 
+// RecurseFS returns every path below path, directories prefixed with "*", skipping what
+// cannot be read
+//
+// Deprecated: use Walk, which reports errors.
 func RecurseFS(path string) (sysSlices []string) {
+	legacyCall("RecurseFS", "Walk")
 	//	temp, ok := FileCache.Get(lowerCasePath)
 	var files []FileInfo
 
@@ -422,7 +810,12 @@ func RecurseFS(path string) (sysSlices []string) {
 	return sysSlices
 }
 
+// FileAgeInSec returns how long ago filename was modified. Despite the name it is a
+// time.Duration, not seconds.
+//
+// Deprecated: use Stat and time.Since(info.LastModified).
 func FileAgeInSec(filename string) (age time.Duration, err error) {
+	legacyCall("FileAgeInSec", "Stat and time.Since")
 	// If not in cache, get file info from the filesystem and update the cache
 	var stat FileInfo
 	stat, err = Stat(filename)
@@ -434,80 +827,42 @@ func FileAgeInSec(filename string) (age time.Duration, err error) {
 	return time.Now().Sub(stat.LastModified), nil
 }
 
+// CopyDirFilesGlob copies the files in src matching fileMatch into dst, replacing existing ones
+//
+// Deprecated: use CopyDirFilesGlobWithOptions with OverwriteFiles and SkipTransforms.
 func CopyDirFilesGlob(src string, dst string, fileMatch string) (err error) {
-	src = cleanPath(src)
-	dst = cleanPath(dst)
-
-	// Check if source is a directory
-	srcInfo, err := Stat(src) // Use cached Stat
-	if err != nil {
-		errorPrinter("CopyDirFilesGlob: "+err.Error(), src)
-		return fmt.Errorf("source is not a directory or does not exist")
-	}
-	if !srcInfo.IsDir {
-		return fmt.Errorf("source is not a directory or does not exist")
-	}
-
-	// Create destination directory if it doesn't exist
-	if !FileExists(dst) {
-		err = MkdirAll(dst, srcInfo.Mode) // Use cached MkdirAll
-		if err != nil {
-			errorPrinter("CopyDirFilesGlob (MkdirAll): "+err.Error(), dst)
-			return
-		}
-	}
-
-	// Use CachedGlob to match files
-	matches, err := Glob(src + "/" + fileMatch)
-	if err != nil {
-		errorPrinter("CopyDirFilesGlob (Glob): "+err.Error(), src+"/"+fileMatch)
-		return err
-	}
-
-	for _, item := range matches {
-		itemBaseName := filepath.Base(item)
-		err = CopyFile(item, filepath.Join(dst, itemBaseName)) // Use cached CopyFile
-		if err != nil {
-			errorPrinter("CopyDirFilesGlob (CopyFile-1): "+err.Error(), item)
-			errorPrinter("CopyDirFilesGlob (CopyFile-2): "+err.Error(), filepath.Join(dst, itemBaseName))
-			return
-		}
-	}
-
-	return nil
+	legacyCall("CopyDirFilesGlob", "CopyDirFilesGlobWithOptions")
+	return CopyDirFilesGlobWithOptions(src, dst, fileMatch, CopyOptions{OverwriteFiles: true, SkipTransforms: true})
 }
 
+// FindFilesInDir returns the entries of dir matching pattern
+//
+// Deprecated: use FindFilesInDirWithOptions.
 func FindFilesInDir(dir string, pattern string) ([]string, error) {
-	entries, err := os.ReadDir(dir)
-	if err != nil {
-		return nil, err
-	}
-
-	var matches []string
-	for _, entry := range entries {
-		if matched, err := filepath.Match(pattern, entry.Name()); err != nil {
-			return nil, err
-		} else if matched {
-			matches = append(matches, filepath.Join(dir, entry.Name()))
-		}
-	}
-
-	return matches, nil
+	legacyCall("FindFilesInDir", "FindFilesInDirWithOptions")
+	return FindFilesInDirWithOptions(dir, pattern, GlobOptions{CaseInsensitive: caseInsensitive.Load()})
 }
 
 func Glob(pattern string) ([]string, error) {
-	matches, err := filepath.Glob(pattern)
-	if err != nil {
-		return nil, err
-	}
-
-	return matches, nil
+	return glob(pattern, caseInsensitive.Load())
 }
 
 func Stat(name string) (FileInfo, error) {
-	stat, err := os.Stat(name)
+	if info, ok := statCacheGet(name); ok {
+		return info, nil
+	}
+	simulateOp()
+
+	b, p := backendFor(name)
+	stat, err := b.Stat(p)
+	if err != nil && os.IsNotExist(err) && caseInsensitive.Load() && isLocal(name) {
+		var resolved string
+		if resolved, err = resolveName(p, true); err == nil {
+			stat, err = b.Stat(resolved)
+		}
+	}
 	if err != nil {
-		return FileInfo{}, err
+		return FileInfo{}, opError("stat", name, "", err)
 	}
 
 	dirNameOnly := filepath.Base(name)
@@ -519,22 +874,19 @@ func Stat(name string) (FileInfo, error) {
 		IsDir:        stat.IsDir(),
 		Name:         dirNameOnly,
 	}
+	statCachePut(name, info)
 
 	return info, nil
 }
 
 func ReadDir(dirName string) ([]FileInfo, error) {
-	// Open the directory
-	f, err := os.Open(dirName)
-	if err != nil {
-		return nil, err
-	}
-	defer f.Close()
+	simulateOp()
 
 	// Read the directory entries
-	dirs, err := f.ReadDir(-1)
+	b, p := backendFor(dirName)
+	dirs, err := b.ReadDir(p)
 	if err != nil {
-		return nil, err
+		return nil, opError("readdir", dirName, "", err)
 	}
 
 	// Sort the directory entries by name
@@ -543,18 +895,9 @@ func ReadDir(dirName string) ([]FileInfo, error) {
 	// Convert the directory entries to FileInfo objects
 	var fileInfos []FileInfo
 	for _, entry := range dirs {
-		entryStat, err := entry.Info()
+		fileInfo, err := dirEntryInfo(entry)
 		if err != nil {
-			return nil, err
-		}
-
-		fileInfo := FileInfo{
-			Exists:       true,
-			Size:         entryStat.Size(),
-			Mode:         entryStat.Mode(),
-			LastModified: entryStat.ModTime(),
-			IsDir:        entryStat.IsDir(),
-			Name:         entryStat.Name(),
+			return nil, opError("readdir", filepath.Join(dirName, entry.Name()), "", err)
 		}
 
 		fileInfos = append(fileInfos, fileInfo)
@@ -0,0 +1,283 @@
+package GMSFS
+
+import (
+	"bytes"
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// AgentServer exposes GMSFS operations on a set of named roots over HTTP+JSON so a
+// controller can manage files on another machine. Every request must carry
+// "Authorization: Bearer <Token>"; an empty Token rejects all requests. Serve it over
+// TLS (http.Server.ServeTLS) when it leaves the host. Request paths are resolved like Root
+// names: symlinks are followed only as long as they stay inside the root.
+type AgentServer struct {
+	Roots map[string]string // Root name -> local directory
+	Token string
+}
+
+// AgentRequest addresses one operation; Dest fields are only used by copies, the purge fields
+// only by purges, which take either OlderThan or Keep
+type AgentRequest struct {
+	Root     string `json:"root"`
+	Path     string `json:"path"`
+	DestRoot string `json:"destRoot,omitempty"`
+	Dest     string `json:"dest,omitempty"`
+
+	Pattern   string        `json:"pattern,omitempty"`
+	OlderThan time.Duration `json:"olderThan,omitempty"`
+	Keep      *int          `json:"keep,omitempty"`
+}
+
+// AgentResponse carries the result of an operation
+type AgentResponse struct {
+	Error   string     `json:"error,omitempty"`
+	Info    *FileInfo  `json:"info,omitempty"`
+	Entries []FileInfo `json:"entries,omitempty"`
+	Deleted []string   `json:"deleted,omitempty"` // Slash-separated paths inside the root
+}
+
+const agentMaxRequest = 1 << 20
+
+// NewAgentServer serves roots to clients presenting token
+func NewAgentServer(roots map[string]string, token string) *AgentServer {
+	return &AgentServer{Roots: roots, Token: token}
+}
+
+func (a *AgentServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !a.authorized(r) {
+		agentWrite(w, http.StatusUnauthorized, AgentResponse{Error: "unauthorized"})
+		return
+	}
+	if r.Method != http.MethodPost {
+		agentWrite(w, http.StatusMethodNotAllowed, AgentResponse{Error: "method not allowed"})
+		return
+	}
+
+	op, ok := strings.CutPrefix(r.URL.Path, "/v1/")
+	if !ok {
+		agentWrite(w, http.StatusNotFound, AgentResponse{Error: "unknown operation"})
+		return
+	}
+
+	var req AgentRequest
+	if err := json.NewDecoder(io.LimitReader(r.Body, agentMaxRequest)).Decode(&req); err != nil {
+		agentWrite(w, http.StatusBadRequest, AgentResponse{Error: "invalid request: " + err.Error()})
+		return
+	}
+
+	root, err := a.root(req.Root)
+	if err != nil {
+		agentWrite(w, http.StatusBadRequest, AgentResponse{Error: err.Error()})
+		return
+	}
+	// Removals act on a link rather than where it points, and never on the root itself
+	var name string
+	if op == "removeall" {
+		name, err = root.resolveChange("removeall", agentPath(req.Path))
+	} else {
+		name, err = root.Path(agentPath(req.Path))
+	}
+	if err != nil {
+		agentWrite(w, http.StatusBadRequest, AgentResponse{Error: err.Error()})
+		return
+	}
+
+	var resp AgentResponse
+	switch op {
+	case "stat":
+		var info FileInfo
+		info, err = Stat(name)
+		if err == nil {
+			resp.Info = &info
+		}
+	case "readdir":
+		resp.Entries, err = ReadDir(name)
+	case "copydir", "syncdir":
+		var dest string
+		dest, err = a.resolve(req.DestRoot, req.Dest)
+		if err == nil && op == "syncdir" {
+			err = SyncDir(name, dest)
+		} else if err == nil {
+			err = CopyDirWithOptions(name, dest, CopyOptions{})
+		}
+	case "removeall":
+		err = RemoveAll(name)
+	case "purge":
+		var deleted []string
+		switch {
+		case (req.OlderThan > 0) == (req.Keep != nil):
+			err = fmt.Errorf("purge needs either olderThan or keep")
+		case req.OlderThan > 0:
+			deleted, err = DeleteOlderThan(name, req.Pattern, req.OlderThan)
+		default:
+			deleted, err = Prune(name, *req.Keep, req.Pattern)
+		}
+		for _, d := range deleted {
+			if rel, ok := relWithin(root.Name(), d); ok {
+				resp.Deleted = append(resp.Deleted, filepath.ToSlash(rel))
+			}
+		}
+	default:
+		agentWrite(w, http.StatusNotFound, AgentResponse{Error: "unknown operation"})
+		return
+	}
+
+	if err != nil {
+		errorPrinter("AgentServer ("+r.URL.Path+"): "+err.Error(), name)
+		agentWrite(w, http.StatusUnprocessableEntity, AgentResponse{Error: err.Error()})
+		return
+	}
+	agentWrite(w, http.StatusOK, resp)
+}
+
+func (a *AgentServer) authorized(r *http.Request) bool {
+	if a.Token == "" {
+		return false
+	}
+
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	return ok && subtle.ConstantTimeCompare([]byte(token), []byte(a.Token)) == 1
+}
+
+func (a *AgentServer) root(name string) (*Root, error) {
+	dir, ok := a.Roots[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown root %q", name)
+	}
+	return NewRoot(dir)
+}
+
+func (a *AgentServer) resolve(root string, rel string) (string, error) {
+	r, err := a.root(root)
+	if err != nil {
+		return "", err
+	}
+	return r.Path(agentPath(rel))
+}
+
+// agentPath turns a request path into a Root name; requests address the top of a root as
+// "/" or ""
+func agentPath(rel string) string {
+	rel = strings.TrimLeft(rel, "/")
+	if rel == "" {
+		return "."
+	}
+	return rel
+}
+
+func agentWrite(w http.ResponseWriter, status int, resp AgentResponse) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(resp)
+}
+
+// AgentClient calls an AgentServer on a remote host
+type AgentClient struct {
+	BaseURL    string // e.g. "https://host:7070"
+	Token      string
+	HTTPClient *http.Client // Defaults to http.DefaultClient
+}
+
+// NewAgentClient creates a client for the agent at baseURL
+func NewAgentClient(baseURL string, token string) *AgentClient {
+	return &AgentClient{BaseURL: strings.TrimSuffix(baseURL, "/"), Token: token}
+}
+
+// Stat returns metadata for path inside the remote root
+func (c *AgentClient) Stat(root string, path string) (FileInfo, error) {
+	resp, err := c.call("stat", AgentRequest{Root: root, Path: path})
+	if err != nil {
+		return FileInfo{}, err
+	}
+	if resp.Info == nil {
+		return FileInfo{}, fmt.Errorf("agent: empty stat response")
+	}
+	return *resp.Info, nil
+}
+
+// ReadDir lists a directory inside the remote root
+func (c *AgentClient) ReadDir(root string, path string) ([]FileInfo, error) {
+	resp, err := c.call("readdir", AgentRequest{Root: root, Path: path})
+	if err != nil {
+		return nil, err
+	}
+	return resp.Entries, nil
+}
+
+// CopyDir copies a directory between (or within) roots on the remote host
+func (c *AgentClient) CopyDir(srcRoot string, src string, dstRoot string, dst string) error {
+	_, err := c.call("copydir", AgentRequest{Root: srcRoot, Path: src, DestRoot: dstRoot, Dest: dst})
+	return err
+}
+
+// SyncDir updates a directory from another (or the same) root on the remote host
+func (c *AgentClient) SyncDir(srcRoot string, src string, dstRoot string, dst string) error {
+	_, err := c.call("syncdir", AgentRequest{Root: srcRoot, Path: src, DestRoot: dstRoot, Dest: dst})
+	return err
+}
+
+// RemoveAll deletes path and its contents inside the remote root
+func (c *AgentClient) RemoveAll(root string, path string) error {
+	_, err := c.call("removeall", AgentRequest{Root: root, Path: path})
+	return err
+}
+
+// DeleteOlderThan runs DeleteOlderThan on a directory inside the remote root and returns the
+// deleted paths, relative to the root
+func (c *AgentClient) DeleteOlderThan(root string, dir string, pattern string, age time.Duration) ([]string, error) {
+	if age <= 0 {
+		return nil, fmt.Errorf("agent: purge: age must be positive")
+	}
+	resp, err := c.call("purge", AgentRequest{Root: root, Path: dir, Pattern: pattern, OlderThan: age})
+	return resp.Deleted, err
+}
+
+// Prune runs Prune on a directory inside the remote root and returns the deleted paths,
+// relative to the root
+func (c *AgentClient) Prune(root string, dir string, keep int, pattern string) ([]string, error) {
+	resp, err := c.call("purge", AgentRequest{Root: root, Path: dir, Pattern: pattern, Keep: &keep})
+	return resp.Deleted, err
+}
+
+func (c *AgentClient) call(op string, req AgentRequest) (AgentResponse, error) {
+	var resp AgentResponse
+
+	body, err := json.Marshal(req)
+	if err != nil {
+		return resp, err
+	}
+
+	httpReq, err := http.NewRequest(http.MethodPost, c.BaseURL+"/v1/"+op, bytes.NewReader(body))
+	if err != nil {
+		return resp, err
+	}
+	httpReq.Header.Set("Content-Type", "application/json")
+	httpReq.Header.Set("Authorization", "Bearer "+c.Token)
+
+	client := c.HTTPClient
+	if client == nil {
+		client = http.DefaultClient
+	}
+	httpResp, err := client.Do(httpReq)
+	if err != nil {
+		errorPrinter("AgentClient ("+op+"): "+err.Error(), req.Path)
+		return resp, err
+	}
+	defer httpResp.Body.Close()
+
+	if err := json.NewDecoder(io.LimitReader(httpResp.Body, 64<<20)).Decode(&resp); err != nil {
+		return resp, fmt.Errorf("agent: %s: invalid response (%s)", op, httpResp.Status)
+	}
+	if resp.Error != "" {
+		return resp, fmt.Errorf("agent: %s: %s", op, resp.Error)
+	}
+
+	return resp, nil
+}
@@ -0,0 +1,232 @@
+package GMSFS
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+// newTestAgent serves the roots "a" and "b", each holding a file and a directory, to a
+// client; "outside" is a directory next to them the agent must not reach
+func newTestAgent(t *testing.T) (client *AgentClient, a string, b string, outside string) {
+	t.Helper()
+	base := t.TempDir()
+	a = filepath.Join(base, "a")
+	b = filepath.Join(base, "b")
+	outside = filepath.Join(base, "outside")
+	for _, dir := range []string{filepath.Join(a, "dir"), filepath.Join(b, "dir"), outside} {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, name := range []string{filepath.Join(a, "dir", "one.txt"), filepath.Join(a, "top.txt"), filepath.Join(outside, "secret.txt")} {
+		if err := os.WriteFile(name, []byte(filepath.Base(name)), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	srv := httptest.NewServer(NewAgentServer(map[string]string{"a": a, "b": b}, "token"))
+	t.Cleanup(srv.Close)
+	return NewAgentClient(srv.URL, "token"), a, b, outside
+}
+
+func TestAgentStat(t *testing.T) {
+	c, _, _, _ := newTestAgent(t)
+
+	info, err := c.Stat("a", "/top.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !info.Exists || info.Size != int64(len("top.txt")) {
+		t.Errorf("Stat = %+v", info)
+	}
+
+	if _, err := c.Stat("nope", "top.txt"); err == nil {
+		t.Error("Stat of an unknown root succeeded")
+	}
+}
+
+func TestAgentReadDir(t *testing.T) {
+	c, _, _, _ := newTestAgent(t)
+
+	entries, err := c.ReadDir("a", "/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, e := range entries {
+		names = append(names, e.Name)
+	}
+	sort.Strings(names)
+	if strings.Join(names, ",") != "dir,top.txt" {
+		t.Errorf("ReadDir = %v", names)
+	}
+}
+
+func TestAgentCopyDir(t *testing.T) {
+	c, _, b, _ := newTestAgent(t)
+
+	if err := c.CopyDir("a", "dir", "b", "copy"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(b, "copy", "one.txt")); err != nil {
+		t.Error(err)
+	}
+
+	// Unlike a sync, a copy does not go into an existing directory
+	if err := c.CopyDir("a", "dir", "b", "dir"); err == nil {
+		t.Error("CopyDir into an existing directory succeeded")
+	}
+}
+
+func TestAgentSyncDir(t *testing.T) {
+	c, _, b, _ := newTestAgent(t)
+	if err := os.WriteFile(filepath.Join(b, "dir", "kept.txt"), nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := c.SyncDir("a", "dir", "b", "dir"); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"one.txt", "kept.txt"} {
+		if _, err := os.Stat(filepath.Join(b, "dir", name)); err != nil {
+			t.Error(err)
+		}
+	}
+}
+
+func TestAgentRemoveAll(t *testing.T) {
+	c, a, _, _ := newTestAgent(t)
+
+	if err := c.RemoveAll("a", "dir"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(a, "dir")); !os.IsNotExist(err) {
+		t.Errorf("dir still exists: %v", err)
+	}
+
+	for _, p := range []string{"", "/", ".", "top.txt/.."} {
+		if err := c.RemoveAll("a", p); err == nil {
+			t.Errorf("RemoveAll(%q) of the root succeeded", p)
+		}
+	}
+	if _, err := os.Stat(a); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestAgentPurge(t *testing.T) {
+	c, a, _, _ := newTestAgent(t)
+	old := time.Now().Add(-48 * time.Hour)
+	for i, name := range []string{"1.log", "2.log", "3.log"} {
+		p := filepath.Join(a, "dir", name)
+		if err := os.WriteFile(p, nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+		mtime := old.Add(time.Duration(i) * time.Hour)
+		if name == "3.log" {
+			mtime = time.Now()
+		}
+		if err := os.Chtimes(p, mtime, mtime); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	deleted, err := c.DeleteOlderThan("a", "dir", "*.log", 24*time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Join(deleted, ",") != "dir/1.log,dir/2.log" {
+		t.Errorf("DeleteOlderThan = %v", deleted)
+	}
+
+	deleted, err = c.Prune("a", "dir", 0, "*.log")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Join(deleted, ",") != "dir/3.log" {
+		t.Errorf("Prune = %v", deleted)
+	}
+	if _, err := os.Stat(filepath.Join(a, "dir", "one.txt")); err != nil {
+		t.Errorf("Prune deleted a file not matching its pattern: %v", err)
+	}
+
+	// A purge needs exactly one of its limits
+	if _, err := c.call("purge", AgentRequest{Root: "a", Path: "dir"}); err == nil {
+		t.Error("purge without a limit succeeded")
+	}
+}
+
+func TestAgentRefusesEscapes(t *testing.T) {
+	c, a, _, outside := newTestAgent(t)
+	if err := os.Symlink(outside, filepath.Join(a, "link")); err != nil {
+		t.Skip(err)
+	}
+
+	for _, p := range []string{"../outside/secret.txt", "link/secret.txt", "/../outside"} {
+		if _, err := c.Stat("a", p); err == nil {
+			t.Errorf("Stat(%q) reached outside the root", p)
+		}
+	}
+	if _, err := c.ReadDir("a", "link"); err == nil {
+		t.Error("ReadDir through a link leaving the root succeeded")
+	}
+	if err := c.CopyDir("a", "dir", "a", "link/copy"); err == nil {
+		t.Error("CopyDir through a link leaving the root succeeded")
+	}
+	if _, err := os.Stat(filepath.Join(outside, "copy")); !os.IsNotExist(err) {
+		t.Errorf("copy landed outside the root: %v", err)
+	}
+	if _, err := c.Prune("a", "link", 0, ""); err == nil {
+		t.Error("Prune through a link leaving the root succeeded")
+	}
+
+	// Removing the link removes the link, not what it points to
+	if err := c.RemoveAll("a", "link"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(outside, "secret.txt")); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestAgentRejectsBadRequests(t *testing.T) {
+	c, _, _, _ := newTestAgent(t)
+
+	tests := []struct {
+		name   string
+		path   string
+		token  string
+		status int
+	}{
+		{"no token", "/v1/stat", "", http.StatusUnauthorized},
+		{"wrong token", "/v1/stat", "wrong", http.StatusUnauthorized},
+		{"unknown op", "/v1/chmod", "token", http.StatusNotFound},
+		{"no version", "/stat", "token", http.StatusNotFound},
+		{"op without version", "/syncdir", "token", http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req, err := http.NewRequest(http.MethodPost, c.BaseURL+tt.path, strings.NewReader(`{"root":"a","path":"top.txt"}`))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tt.token != "" {
+				req.Header.Set("Authorization", "Bearer "+tt.token)
+			}
+			resp, err := http.DefaultClient.Do(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			resp.Body.Close()
+			if resp.StatusCode != tt.status {
+				t.Errorf("status = %d, want %d", resp.StatusCode, tt.status)
+			}
+		})
+	}
+}
@@ -0,0 +1,70 @@
+package GMSFS
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// writeAtomic streams content produced by fill into a temporary file next to name and renames
+// it into place once it is complete and synced, so readers never see a partial file
+func writeAtomic(name string, perm os.FileMode, fill func(w io.Writer) error) error {
+	return writeAtomicFile(name, perm, true, fill)
+}
+
+// writeAtomicFile is writeAtomic, changing the usage of the quotas containing name only when
+// counted is set
+func writeAtomicFile(name string, perm os.FileMode, counted bool, fill func(w io.Writer) error) error {
+	name = cleanPath(name)
+
+	tmp, err := os.CreateTemp(filepath.Dir(name), "."+filepath.Base(name)+".tmp*")
+	if err != nil {
+		errorPrinter("writeAtomic (os.CreateTemp): "+err.Error(), name)
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	err = fill(tmp)
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Chmod(tmp.Name(), perm)
+	}
+	if err != nil {
+		errorPrinter("writeAtomic: "+err.Error(), name)
+		return err
+	}
+
+	var m *quotaMove
+	if counted {
+		m = quotaReplacing(tmp.Name(), name)
+	}
+	if err := renameEntry(context.Background(), tmp.Name(), name); err != nil {
+		return err
+	}
+	m.done()
+	return nil
+}
+
+// writeFileAtomic is WriteFile through a temporary file and rename
+func writeFileAtomic(name string, content []byte, perm os.FileMode) error {
+	return writeAtomic(name, perm, func(w io.Writer) error {
+		_, err := io.Copy(w, bytes.NewReader(content))
+		return err
+	})
+}
+
+// writeUncountedFile is writeFileAtomic for the package's own metadata files, which quotas
+// leave out
+func writeUncountedFile(name string, content []byte, perm os.FileMode) error {
+	return writeAtomicFile(name, perm, false, func(w io.Writer) error {
+		_, err := io.Copy(w, bytes.NewReader(content))
+		return err
+	})
+}
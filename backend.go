@@ -0,0 +1,296 @@
+package GMSFS
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// File is an open file on a Backend. *os.File implements it.
+type File interface {
+	io.Reader
+	io.Writer
+	io.Seeker
+	io.Closer
+	Stat() (os.FileInfo, error)
+	Sync() error
+}
+
+// Backend is the storage the package operations run on. Paths given to a backend are
+// slash-separated and cleaned; errors should be *os.PathError wrapping the usual os errors
+// (os.ErrNotExist, os.ErrExist, ...) so callers can test them with os.IsNotExist and friends.
+type Backend interface {
+	Open(name string) (File, error)
+	Create(name string) (File, error)
+	OpenFile(name string, flag int, perm os.FileMode) (File, error)
+	Stat(name string) (os.FileInfo, error)
+	Lstat(name string) (os.FileInfo, error)
+	ReadDir(name string) ([]os.DirEntry, error)
+	Mkdir(name string, perm os.FileMode) error
+	MkdirAll(name string, perm os.FileMode) error
+	Remove(name string) error
+	RemoveAll(name string) error
+	Rename(oldName string, newName string) error
+	Chmod(name string, mode os.FileMode) error
+	Chtimes(name string, atime time.Time, mtime time.Time) error
+}
+
+// LocalBackend is the default backend: the operating system's filesystem through package os.
+// It receives native paths rather than slash-separated ones.
+type LocalBackend struct{}
+
+func (LocalBackend) Open(name string) (File, error) { return os.Open(name) }
+
+func (LocalBackend) Create(name string) (File, error) { return os.Create(name) }
+
+func (LocalBackend) OpenFile(name string, flag int, perm os.FileMode) (File, error) {
+	return os.OpenFile(name, flag, perm)
+}
+
+func (LocalBackend) Stat(name string) (os.FileInfo, error)      { return os.Stat(name) }
+func (LocalBackend) Lstat(name string) (os.FileInfo, error)     { return os.Lstat(name) }
+func (LocalBackend) ReadDir(name string) ([]os.DirEntry, error) { return os.ReadDir(name) }
+func (LocalBackend) Mkdir(name string, perm os.FileMode) error  { return os.Mkdir(name, perm) }
+func (LocalBackend) MkdirAll(name string, perm os.FileMode) error {
+	return os.MkdirAll(name, perm)
+}
+func (LocalBackend) Remove(name string) error                    { return os.Remove(name) }
+func (LocalBackend) RemoveAll(name string) error                 { return os.RemoveAll(name) }
+func (LocalBackend) Rename(oldName string, newName string) error { return os.Rename(oldName, newName) }
+func (LocalBackend) Chmod(name string, mode os.FileMode) error   { return os.Chmod(name, mode) }
+func (LocalBackend) Chtimes(name string, atime time.Time, mtime time.Time) error {
+	return os.Chtimes(name, atime, mtime)
+}
+
+// ReadFile lets readFile skip the generic open and read loop
+func (LocalBackend) ReadFile(name string) ([]byte, error) { return os.ReadFile(name) }
+
+// errNotLocal is returned by operations that only work on the local filesystem
+var errNotLocal = fmt.Errorf("path is on a registered backend, not the local filesystem: %w", errors.ErrUnsupported)
+
+var (
+	backendsMu sync.Mutex
+	backends   atomic.Pointer[map[string]Backend]
+)
+
+// RegisterBackend routes every path starting with "scheme:" to b, e.g. after
+// RegisterBackend("mem", b) ReadFile("mem:/config.json") reads "/config.json" from b.
+// Schemes are at least two characters, so Windows drive letters never match one. Paths
+// without a registered scheme keep using the local filesystem.
+func RegisterBackend(scheme string, b Backend) error {
+	if !validScheme(scheme) {
+		return fmt.Errorf("RegisterBackend: invalid scheme %q", scheme)
+	}
+	if b == nil {
+		return fmt.Errorf("RegisterBackend: nil backend for %q", scheme)
+	}
+
+	backendsMu.Lock()
+	defer backendsMu.Unlock()
+
+	m := map[string]Backend{scheme: b}
+	if cur := backends.Load(); cur != nil {
+		for k, v := range *cur {
+			if k != scheme {
+				m[k] = v
+			}
+		}
+	}
+	backends.Store(&m)
+	FlushStatCache()
+	return nil
+}
+
+// UnregisterBackend stops routing scheme to its backend
+func UnregisterBackend(scheme string) {
+	backendsMu.Lock()
+	defer backendsMu.Unlock()
+
+	cur := backends.Load()
+	if cur == nil {
+		return
+	}
+	m := map[string]Backend{}
+	for k, v := range *cur {
+		if k != scheme {
+			m[k] = v
+		}
+	}
+	if len(m) == 0 {
+		backends.Store(nil)
+	} else {
+		backends.Store(&m)
+	}
+	FlushStatCache()
+}
+
+// BackendFor returns the backend serving name and the path name has on it
+func BackendFor(name string) (Backend, string) {
+	return backendFor(name)
+}
+
+// backendFor splits a registered scheme off name. Local names come back unchanged but for
+// SetUnicodeNormalization, which also covers the operations that skip cleanPath.
+func backendFor(name string) (Backend, string) {
+	if _, b, rest, ok := splitScheme(name); ok {
+		return b, rest
+	}
+	return LocalBackend{}, normalizePath(name)
+}
+
+// splitScheme returns the scheme, backend and cleaned slash path of a name on a registered
+// backend
+func splitScheme(name string) (string, Backend, string, bool) {
+	m := backends.Load()
+	if m == nil {
+		return "", nil, "", false
+	}
+
+	scheme, rest, ok := strings.Cut(name, ":")
+	if !ok {
+		return "", nil, "", false
+	}
+	b, ok := (*m)[scheme]
+	if !ok {
+		return "", nil, "", false
+	}
+
+	return scheme, b, path.Clean("/" + filepath.ToSlash(rest)), true
+}
+
+// isLocal reports whether name is on the local filesystem rather than a registered backend
+func isLocal(name string) bool {
+	_, _, _, ok := splitScheme(name)
+	return !ok
+}
+
+// requireLocal fails operations that only work on the local filesystem when one of names
+// belongs to a registered backend
+func requireLocal(op string, names ...string) error {
+	for _, name := range names {
+		if !isLocal(name) {
+			return &os.PathError{Op: op, Path: name, Err: errNotLocal}
+		}
+	}
+	return nil
+}
+
+func validScheme(scheme string) bool {
+	if len(scheme) < 2 {
+		return false
+	}
+	for i, c := range scheme {
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z':
+		case i > 0 && (c >= '0' && c <= '9' || c == '+' || c == '-' || c == '.'):
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// readFile reads a whole file from b, using its own ReadFile when it has one
+func readFile(b Backend, name string) ([]byte, error) {
+	if rf, ok := b.(interface{ ReadFile(string) ([]byte, error) }); ok {
+		return rf.ReadFile(name)
+	}
+
+	f, err := b.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	return io.ReadAll(f)
+}
+
+// writeFile replaces the content of name on b. When durable is set the file is synced first,
+// and on the local filesystem its directory entry too.
+func writeFile(b Backend, name string, content []byte, perm os.FileMode, durable bool) error {
+	f, err := b.OpenFile(name, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, perm)
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(content)
+	if err == nil && durable {
+		err = f.Sync()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil || !durable {
+		return err
+	}
+
+	if _, ok := b.(LocalBackend); ok {
+		return syncDir(filepath.Dir(name))
+	}
+	return nil
+}
+
+// appendBackend appends to a file on a registered backend, which has no handle pool
+func appendBackend(name string, content []byte, profile *profileEntry) error {
+	b, p := backendFor(name)
+	f, err := b.OpenFile(p, os.O_APPEND|os.O_WRONLY|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(content)
+	if err == nil && profile != nil && profile.Durable {
+		err = f.Sync()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// errCrossBackend is returned when renaming between different backends
+var errCrossBackend = errors.New("rename across backends")
+
+// renameBackend renames within one backend
+func renameBackend(oldName string, newName string) error {
+	oldScheme, ob, op, _ := splitScheme(oldName)
+	newScheme, _, np, _ := splitScheme(newName)
+	if oldScheme != newScheme {
+		return &os.LinkError{Op: "rename", Old: oldName, New: newName, Err: errCrossBackend}
+	}
+	if ob == nil {
+		return os.Rename(oldName, newName)
+	}
+	return ob.Rename(op, np)
+}
+
+// parentDir returns the directory holding name, which may be on a backend
+func parentDir(name string) string {
+	if isLocal(name) {
+		return filepath.Dir(name)
+	}
+	return path.Dir(name)
+}
+
+// OpenHandle opens name on whichever backend serves it, like OpenFile does for local files.
+// Use it instead of Open, Create and OpenFile, which return *os.File and therefore only
+// accept local paths.
+func OpenHandle(name string, flag int, perm os.FileMode) (File, error) {
+	simulateOp()
+	b, p := backendFor(name)
+	f, err := b.OpenFile(p, flag, perm)
+	if err != nil {
+		errorPrinter("OpenHandle: "+err.Error(), name)
+		return nil, err
+	}
+	if flagWrites(flag) {
+		invalidateStat(name)
+		return statFile{File: f, name: name}, nil
+	}
+	return f, nil
+}
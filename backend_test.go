@@ -0,0 +1,123 @@
+package GMSFS
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// registerTestBackend registers a new MemBackend as scheme for the rest of the test
+func registerTestBackend(t *testing.T, scheme string) *MemBackend {
+	t.Helper()
+	m := NewMemBackend()
+	if err := RegisterBackend(scheme, m); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { UnregisterBackend(scheme) })
+	return m
+}
+
+func TestRegisterBackendSchemes(t *testing.T) {
+	for _, scheme := range []string{"", "m", "1mem", "me m", "m:x", "ä"} {
+		if err := RegisterBackend(scheme, NewMemBackend()); err == nil {
+			UnregisterBackend(scheme)
+			t.Errorf("RegisterBackend(%q) succeeded", scheme)
+		}
+	}
+	if err := RegisterBackend("mem", nil); err == nil {
+		t.Error("RegisterBackend with a nil backend succeeded")
+	}
+
+	registerTestBackend(t, "b1")
+	for name, local := range map[string]bool{"b1:/x": false, "b1:x": false, "b2:/x": true, `C:\x`: true, "/b1:/x": true} {
+		if got := isLocal(name); got != local {
+			t.Errorf("isLocal(%q) = %v", name, got)
+		}
+	}
+	if _, p := BackendFor("b1:a/../b//c"); p != "/b/c" {
+		t.Errorf("BackendFor path = %q, want /b/c", p)
+	}
+
+	UnregisterBackend("b1")
+	if !isLocal("b1:/x") {
+		t.Error("unregistered scheme still routed")
+	}
+}
+
+func TestBackendOperations(t *testing.T) {
+	registerTestBackend(t, "bt")
+
+	if err := MkdirAll("bt:/dir/sub", 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := WriteFile("bt:/dir/a.txt", []byte("alpha"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := Append("bt:/dir/a.txt", []byte("+")); err != nil {
+		t.Fatal(err)
+	}
+	if data, err := ReadFile("bt:/dir/a.txt"); err != nil || string(data) != "alpha+" {
+		t.Errorf("ReadFile = %q, %v", data, err)
+	}
+	if info, err := Stat("bt:/dir/a.txt"); err != nil || info.Size != 6 || info.IsDir {
+		t.Errorf("Stat = %+v, %v", info, err)
+	}
+	if entries, err := ReadDir("bt:/dir"); err != nil || len(entries) != 2 {
+		t.Errorf("ReadDir = %v, %v", entries, err)
+	}
+
+	f, err := OpenHandle("bt:/dir/sub/h.txt", os.O_WRONLY|os.O_CREATE, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Write([]byte("handle"))
+	f.Close()
+	if info, err := Stat("bt:/dir/sub/h.txt"); err != nil || info.Size != 6 {
+		t.Errorf("Stat after writing through a handle = %+v, %v", info, err)
+	}
+
+	if err := Rename("bt:/dir/a.txt", "bt:/dir/b.txt"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Stat("bt:/dir/a.txt"); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("Stat after Rename = %v", err)
+	}
+	if err := Remove("bt:/dir/b.txt"); err != nil {
+		t.Fatal(err)
+	}
+	if err := RemoveAll("bt:/dir"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Stat("bt:/dir"); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("Stat after RemoveAll = %v", err)
+	}
+
+	if _, err := ReadFile("bt:/missing"); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("ReadFile missing = %v", err)
+	}
+	if err := WriteFile("bt:/no/such/dir/x", nil, 0644); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("WriteFile without the directory = %v", err)
+	}
+}
+
+func TestBackendBoundaries(t *testing.T) {
+	registerTestBackend(t, "bt")
+	if err := WriteFile("bt:/a.txt", []byte("a"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	local := filepath.Join(t.TempDir(), "a.txt")
+
+	var linkErr *os.LinkError
+	if err := Rename("bt:/a.txt", local); !errors.As(err, &linkErr) || !errors.Is(err, errCrossBackend) {
+		t.Errorf("Rename across backends = %v", err)
+	}
+
+	// Operations only the local filesystem supports say so
+	if err := Tag("bt:/a.txt", "k", "v"); !errors.Is(err, errors.ErrUnsupported) {
+		t.Errorf("Tag on a backend = %v", err)
+	}
+	if _, err := Open("bt:/a.txt"); !errors.Is(err, errors.ErrUnsupported) {
+		t.Errorf("Open on a backend = %v", err)
+	}
+}
@@ -0,0 +1,208 @@
+package GMSFS
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"reflect"
+	"sync"
+	"time"
+)
+
+// BackendConfig is a remote backend registered under its scheme by ApplyConfig. Keys are never
+// written in the config; Credentials says where the backend gets them.
+type BackendConfig struct {
+	Type           string            `json:"type" yaml:"type"` // s3
+	Endpoint       string            `json:"endpoint" yaml:"endpoint"`
+	Region         string            `json:"region" yaml:"region"`
+	PathStyle      bool              `json:"path_style" yaml:"path_style"`
+	Credentials    CredentialsConfig `json:"credentials" yaml:"credentials"`
+	ConnectTimeout Duration          `json:"connect_timeout" yaml:"connect_timeout"` // Dialing and the TLS handshake; 0 is the net/http default
+	RequestTimeout Duration          `json:"request_timeout" yaml:"request_timeout"` // Wait for response headers; 0 waits as long as the request's context allows
+	TLS            TLSConfig         `json:"tls" yaml:"tls"`
+}
+
+// CredentialsConfig selects the CredentialsProvider of a backend
+type CredentialsConfig struct {
+	Source  string `json:"source" yaml:"source"`   // env (the default), file, iam or none
+	File    string `json:"file" yaml:"file"`       // For file; see FileCredentials
+	Profile string `json:"profile" yaml:"profile"` // For file; see FileCredentials
+}
+
+// TLSConfig is the TLS setup of a backend's connections
+type TLSConfig struct {
+	CAFile             string `json:"ca_file" yaml:"ca_file"`     // PEM certificates trusted besides the system ones
+	CertFile           string `json:"cert_file" yaml:"cert_file"` // Client certificate, with KeyFile
+	KeyFile            string `json:"key_file" yaml:"key_file"`
+	ServerName         string `json:"server_name" yaml:"server_name"`
+	MinVersion         string `json:"min_version" yaml:"min_version"` // 1.2 or 1.3; empty is 1.2
+	InsecureSkipVerify bool   `json:"insecure_skip_verify" yaml:"insecure_skip_verify"`
+}
+
+// configBackends holds the backends registered by the last ApplyConfig
+var configBackends = struct {
+	mu  sync.Mutex
+	cfg map[string]BackendConfig
+}{}
+
+// problems lists what is wrong with b, for Config.Validate
+func (b BackendConfig) problems() []string {
+	var p []string
+	if b.Type != "s3" {
+		p = append(p, fmt.Sprintf("type must be s3, not %q", b.Type))
+	}
+	switch b.Credentials.Source {
+	case "", "env", "file", "iam", "none":
+	default:
+		p = append(p, fmt.Sprintf("credentials.source must be env, file, iam or none, not %q", b.Credentials.Source))
+	}
+	if b.ConnectTimeout < 0 || b.RequestTimeout < 0 {
+		p = append(p, "timeouts must not be negative")
+	}
+	if (b.TLS.CertFile == "") != (b.TLS.KeyFile == "") {
+		p = append(p, "tls.cert_file and tls.key_file go together")
+	}
+	if _, err := tlsVersion(b.TLS.MinVersion); err != nil {
+		p = append(p, "tls."+err.Error())
+	}
+	return p
+}
+
+func tlsVersion(v string) (uint16, error) {
+	switch v {
+	case "", "1.2":
+		return tls.VersionTLS12, nil
+	case "1.3":
+		return tls.VersionTLS13, nil
+	}
+	return 0, fmt.Errorf("min_version must be 1.2 or 1.3, not %q", v)
+}
+
+// newBackend builds the backend b describes
+func (b BackendConfig) newBackend() (Backend, error) {
+	client, err := b.httpClient()
+	if err != nil {
+		return nil, err
+	}
+
+	var creds CredentialsProvider
+	switch b.Credentials.Source {
+	case "", "env":
+		creds = EnvCredentials()
+	case "file":
+		creds = FileCredentials(b.Credentials.File, b.Credentials.Profile)
+	case "iam":
+		creds = IAMCredentials()
+	case "none":
+		creds = StaticCredentials(Credentials{})
+	}
+
+	return NewS3Backend(S3Options{
+		Endpoint:    b.Endpoint,
+		Region:      b.Region,
+		PathStyle:   b.PathStyle,
+		Client:      client,
+		Credentials: creds,
+	})
+}
+
+// httpClient returns a client with the timeouts and TLS settings of b
+func (b BackendConfig) httpClient() (*http.Client, error) {
+	minVersion, err := tlsVersion(b.TLS.MinVersion)
+	if err != nil {
+		return nil, err
+	}
+	tlsConfig := &tls.Config{
+		MinVersion:         minVersion,
+		ServerName:         b.TLS.ServerName,
+		InsecureSkipVerify: b.TLS.InsecureSkipVerify,
+	}
+
+	if b.TLS.CAFile != "" {
+		pem, err := os.ReadFile(b.TLS.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("tls.ca_file: %w", err)
+		}
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			pool = x509.NewCertPool()
+		}
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("tls.ca_file: %s: no certificates", b.TLS.CAFile)
+		}
+		tlsConfig.RootCAs = pool
+	}
+	if b.TLS.CertFile != "" {
+		cert, err := tls.LoadX509KeyPair(b.TLS.CertFile, b.TLS.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("tls.cert_file: %w", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = tlsConfig
+	if b.ConnectTimeout > 0 {
+		dialer := &net.Dialer{Timeout: time.Duration(b.ConnectTimeout), KeepAlive: 30 * time.Second}
+		transport.DialContext = dialer.DialContext
+		transport.TLSHandshakeTimeout = time.Duration(b.ConnectTimeout)
+	}
+	transport.ResponseHeaderTimeout = time.Duration(b.RequestTimeout)
+
+	return &http.Client{Transport: transport}, nil
+}
+
+// applyBackends registers the backends in cfg, replacing those whose settings changed, and
+// unregisters the ones an earlier config registered that cfg no longer has. Every backend is
+// built before any is registered, so a bad CA file or key pair changes nothing.
+func applyBackends(cfg map[string]BackendConfig) error {
+	configBackends.mu.Lock()
+	defer configBackends.mu.Unlock()
+
+	built := map[string]Backend{}
+	for scheme, b := range cfg {
+		if old, ok := configBackends.cfg[scheme]; ok && reflect.DeepEqual(old, b) {
+			continue
+		}
+		backend, err := b.newBackend()
+		if err != nil {
+			return fmt.Errorf("config: backends.%s: %w", scheme, err)
+		}
+		built[scheme] = backend
+	}
+
+	for scheme := range configBackends.cfg {
+		if _, ok := cfg[scheme]; !ok {
+			UnregisterBackend(scheme)
+		}
+	}
+	for scheme, backend := range built {
+		if err := RegisterBackend(scheme, backend); err != nil {
+			return err
+		}
+	}
+
+	configBackends.cfg = make(map[string]BackendConfig, len(cfg))
+	for scheme, b := range cfg {
+		configBackends.cfg[scheme] = b
+	}
+	return nil
+}
+
+// appliedBackends returns the backends registered by the last ApplyConfig, for CurrentConfig
+func appliedBackends() map[string]BackendConfig {
+	configBackends.mu.Lock()
+	defer configBackends.mu.Unlock()
+
+	if len(configBackends.cfg) == 0 {
+		return nil
+	}
+	m := make(map[string]BackendConfig, len(configBackends.cfg))
+	for scheme, b := range configBackends.cfg {
+		m[scheme] = b
+	}
+	return m
+}
@@ -0,0 +1,225 @@
+package GMSFS
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	cmap "github.com/orcaman/concurrent-map/v2"
+)
+
+// DefaultStatCacheTTL is how long a Stat result is served from memory before the OS is asked
+// again. The cache is off by default: changes made by other processes, or through handles of
+// other packages, are only seen once an entry expires.
+const DefaultStatCacheTTL = time.Duration(0)
+
+// statCacheEntry stores a Stat result together with the moment it stops being valid
+type statCacheEntry struct {
+	info    FileInfo
+	expires time.Time
+}
+
+var fileCache = cmap.New[statCacheEntry]()
+
+var statCacheTTL atomic.Int64
+
+// Lookups answered from the cache and ones that went to the filesystem
+var statCacheHits, statCacheMisses atomic.Uint64
+
+func init() {
+	statCacheTTL.Store(int64(DefaultStatCacheTTL))
+}
+
+// SetStatCacheTTL changes how long Stat results are cached. A ttl of 0 or less disables the cache.
+func SetStatCacheTTL(ttl time.Duration) {
+	if ttl < 0 {
+		ttl = 0
+	}
+	statCacheTTL.Store(int64(ttl))
+	if ttl == 0 {
+		fileCache.Clear()
+	}
+}
+
+// StatCacheTTL returns the current Stat cache lifetime
+func StatCacheTTL() time.Duration {
+	return time.Duration(statCacheTTL.Load())
+}
+
+// InvalidateStat drops the cached metadata for name, for callers that change files outside the package
+func InvalidateStat(name string) {
+	invalidateStat(name)
+}
+
+// CacheStats counts Stat cache use since the program started
+type CacheStats struct {
+	Entries int    `json:"entries"`
+	Hits    uint64 `json:"hits"`
+	Misses  uint64 `json:"misses"` // Lookups of uncached or expired entries; not counted while the cache is off
+}
+
+// StatCacheStats returns the size and hit counts of the Stat cache
+func StatCacheStats() CacheStats {
+	return CacheStats{Entries: fileCache.Count(), Hits: statCacheHits.Load(), Misses: statCacheMisses.Load()}
+}
+
+// FlushStatCache drops all cached metadata
+func FlushStatCache() {
+	fileCache.Clear()
+}
+
+// statCacheKey is the absolute path of a local name, so entries survive changes of the
+// working directory
+func statCacheKey(name string) string {
+	if isLocal(name) {
+		if abs, err := filepath.Abs(name); err == nil {
+			return abs
+		}
+	}
+	return filepath.Clean(name)
+}
+
+// statWriters holds the writable *os.File handles Create and OpenFile returned, by cache key.
+// Their writes and Close can't be seen, so the cache is bypassed for a path while one is open.
+// Handles of OpenHandle invalidate the cache themselves, see statFile.
+var statWriters = struct {
+	sync.Mutex
+	files map[string][]*os.File
+}{files: map[string][]*os.File{}}
+
+var statWriterCount atomic.Int64
+
+// trackWriter keeps name out of the Stat cache until f is closed
+func trackWriter(name string, f *os.File) {
+	// Nothing to keep out while the cache is off; holding f would also keep it from being
+	// closed by the garbage collector
+	if profileFor(name).statTTL() <= 0 {
+		return
+	}
+
+	key := statCacheKey(name)
+	statWriters.Lock()
+	statWriters.files[key] = append(statWriters.files[key], f)
+	statWriters.Unlock()
+	statWriterCount.Add(1)
+	fileCache.Remove(key)
+}
+
+// writerOpen reports whether a handle trackWriter got for key is still open, forgetting the
+// closed ones
+func writerOpen(key string) bool {
+	if statWriterCount.Load() == 0 {
+		return false
+	}
+
+	statWriters.Lock()
+	defer statWriters.Unlock()
+	files := statWriters.files[key]
+	open := files[:0]
+	for _, f := range files {
+		// Stat fails with ErrClosed once the handle is closed
+		if _, err := f.Stat(); !errors.Is(err, os.ErrClosed) {
+			open = append(open, f)
+		}
+	}
+	statWriterCount.Add(int64(len(open) - len(files)))
+	if len(open) == 0 {
+		delete(statWriters.files, key)
+		return false
+	}
+	statWriters.files[key] = open
+	return true
+}
+
+func statCacheGet(name string) (FileInfo, bool) {
+	if profileFor(name).statTTL() <= 0 {
+		return FileInfo{}, false
+	}
+
+	key := statCacheKey(name)
+	if writerOpen(key) {
+		statCacheMisses.Add(1)
+		return FileInfo{}, false
+	}
+	entry, ok := fileCache.Get(key)
+	if !ok {
+		statCacheMisses.Add(1)
+		return FileInfo{}, false
+	}
+	if time.Now().After(entry.expires) {
+		fileCache.Remove(key)
+		statCacheMisses.Add(1)
+		return FileInfo{}, false
+	}
+
+	statCacheHits.Add(1)
+	return entry.info, true
+}
+
+func statCachePut(name string, info FileInfo) {
+	ttl := profileFor(name).statTTL()
+	if ttl <= 0 {
+		return
+	}
+
+	key := statCacheKey(name)
+	if writerOpen(key) {
+		return
+	}
+	fileCache.Set(key, statCacheEntry{info: info, expires: time.Now().Add(ttl)})
+}
+
+// invalidateStat drops name and its parent directory, whose size and mtime change with its entries
+func invalidateStat(name string) {
+	if fileCache.IsEmpty() {
+		return
+	}
+
+	key := statCacheKey(name)
+	fileCache.Remove(key)
+	fileCache.Remove(filepath.Dir(key))
+}
+
+// invalidateStatTree drops path and everything cached below it
+func invalidateStatTree(path string) {
+	if fileCache.IsEmpty() {
+		return
+	}
+
+	key := statCacheKey(path)
+	prefix := key + string(os.PathSeparator)
+	for _, k := range fileCache.Keys() {
+		if k == key || strings.HasPrefix(k, prefix) {
+			fileCache.Remove(k)
+		}
+	}
+	fileCache.Remove(filepath.Dir(key))
+}
+
+// flagWrites reports whether an OpenFile flag combination can modify the file
+func flagWrites(flag int) bool {
+	return flag&(os.O_WRONLY|os.O_RDWR|os.O_APPEND|os.O_CREATE|os.O_TRUNC) != 0
+}
+
+// statFile is a writable File of OpenHandle dropping the cached metadata of its path as it
+// is written and closed
+type statFile struct {
+	File
+	name string
+}
+
+func (f statFile) Write(p []byte) (int, error) {
+	n, err := f.File.Write(p)
+	invalidateStat(f.name)
+	return n, err
+}
+
+func (f statFile) Close() error {
+	err := f.File.Close()
+	invalidateStat(f.name)
+	return err
+}
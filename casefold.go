@@ -0,0 +1,156 @@
+package GMSFS
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync/atomic"
+)
+
+var caseInsensitive atomic.Bool
+
+// ErrCaseCollision is returned when a name matches several entries that differ only by case,
+// or by Unicode normalization, see SetUnicodeNormalization
+var ErrCaseCollision = errors.New("names differ only by case or normalization")
+
+// CaseCollisionError lists the entries a case-insensitive lookup could not choose between
+type CaseCollisionError struct {
+	Path  string   // The name looked up
+	Names []string // The entries of one directory that match it
+}
+
+func (e *CaseCollisionError) Error() string {
+	return fmt.Sprintf("%s: %v in %s", ErrCaseCollision, e.Names, e.Path)
+}
+
+func (e *CaseCollisionError) Unwrap() error {
+	return ErrCaseCollision
+}
+
+// SetCaseInsensitive makes Stat, FileExists, Glob and FindFilesInDir match local names
+// regardless of case, for applications migrated from Windows or macOS. An exact match is
+// always used first; otherwise each path element is looked up case-insensitively, and a
+// name matching several entries that differ only by case fails with a *CaseCollisionError.
+// Disabled by default.
+func SetCaseInsensitive(enabled bool) {
+	if caseInsensitive.Swap(enabled) != enabled {
+		FlushStatCache()
+	}
+}
+
+// CaseInsensitive reports whether case-insensitive matching is enabled
+func CaseInsensitive() bool {
+	return caseInsensitive.Load()
+}
+
+// ResolveCase returns the local path name matches case-insensitively, whether or not
+// SetCaseInsensitive is enabled
+func ResolveCase(name string) (string, error) {
+	name = cleanPath(name)
+	if err := requireLocal("resolve", name); err != nil {
+		return "", err
+	}
+	resolved, err := resolveName(name, true)
+	if err != nil {
+		return "", err
+	}
+	return resolved, nil
+}
+
+// resolveName looks name up one element at a time, preferring exact matches, and otherwise
+// accepting entries equal under the normalization option and regardless of case when fold
+// is set. When an element does not exist the error comes with the name resolved up to it.
+func resolveName(name string, fold bool) (string, error) {
+	if _, err := os.Lstat(name); err == nil {
+		return name, nil
+	}
+
+	volume := filepath.VolumeName(name)
+	rest := name[len(volume):]
+	dir := volume + "."
+	if volume != "" {
+		dir = volume
+	}
+	if rest != "" && os.IsPathSeparator(rest[0]) {
+		dir = volume + string(filepath.Separator)
+	}
+
+	parts := strings.FieldsFunc(rest, func(r rune) bool { return r < 0x80 && os.IsPathSeparator(uint8(r)) })
+	for i, part := range parts {
+		exact := filepath.Join(dir, part)
+		if _, err := os.Lstat(exact); err == nil || part == "." || part == ".." {
+			dir = exact
+			continue
+		}
+
+		entries, err := os.ReadDir(dir)
+		if err != nil {
+			return "", err
+		}
+		var matches []string
+		for _, entry := range entries {
+			if sameName(entry.Name(), part, fold) {
+				matches = append(matches, entry.Name())
+			}
+		}
+
+		switch len(matches) {
+		case 0:
+			return filepath.Join(append([]string{dir}, parts[i:]...)...), &os.PathError{Op: "stat", Path: name, Err: os.ErrNotExist}
+		case 1:
+			dir = filepath.Join(dir, matches[0])
+		default:
+			err := &CaseCollisionError{Path: exact, Names: matches}
+			warnPrinter("resolveName: "+err.Error(), name)
+			return "", err
+		}
+	}
+	return dir, nil
+}
+
+// FindCaseCollisions lists the groups of entries below root whose names differ only by
+// case, which a case-insensitive filesystem or SetCaseInsensitive cannot tell apart. Run it
+// before migrating a tree.
+func FindCaseCollisions(root string) ([][]string, error) {
+	root = cleanPath(root)
+	if err := requireLocal("walk", root); err != nil {
+		return nil, err
+	}
+
+	var collisions [][]string
+	err := filepath.WalkDir(root, func(name string, d os.DirEntry, err error) error {
+		if err != nil || !d.IsDir() {
+			return err
+		}
+
+		entries, err := os.ReadDir(name)
+		if err != nil {
+			return err
+		}
+		groups := map[string][]string{}
+		for _, entry := range entries {
+			key := entry.Name()
+			if f := unicodeForm.Load(); f != nil {
+				key = f.String(key)
+			}
+			key = strings.ToLower(key)
+			groups[key] = append(groups[key], filepath.Join(name, entry.Name()))
+		}
+		for _, group := range groups {
+			if len(group) > 1 {
+				collisions = append(collisions, group)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		errorPrinter("FindCaseCollisions: "+err.Error(), root)
+		return nil, err
+	}
+
+	sort.Slice(collisions, func(i, j int) bool { return collisions[i][0] < collisions[j][0] })
+	return collisions, nil
+}
@@ -0,0 +1,184 @@
+package GMSFS
+
+import (
+	"context"
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"hash/crc32"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// ChecksumAlgo names a hash function supported by FileChecksum and DirChecksum
+type ChecksumAlgo string
+
+const (
+	ChecksumSHA256 ChecksumAlgo = "sha256"
+	ChecksumSHA1   ChecksumAlgo = "sha1"
+	ChecksumMD5    ChecksumAlgo = "md5"
+	ChecksumCRC32  ChecksumAlgo = "crc32"
+)
+
+func newChecksumHash(algo ChecksumAlgo) (hash.Hash, error) {
+	switch ChecksumAlgo(strings.ToLower(string(algo))) {
+	case ChecksumSHA256:
+		return sha256.New(), nil
+	case ChecksumSHA1:
+		return sha1.New(), nil
+	case ChecksumMD5:
+		return md5.New(), nil
+	case ChecksumCRC32:
+		return crc32.NewIEEE(), nil
+	}
+	return nil, fmt.Errorf("unsupported checksum algorithm %q", algo)
+}
+
+// FileChecksum returns the hex digest of a file's content, streaming it through the hash
+func FileChecksum(name string, algo ChecksumAlgo) (string, error) {
+	return FileChecksumContext(context.Background(), name, algo)
+}
+
+// FileChecksumContext is FileChecksum as a job: it shows up in Jobs, stops when ctx is done
+// and can be paused, also in the middle of a large file. A JobControl attached with
+// WithJobControl receives progress.
+func FileChecksumContext(ctx context.Context, name string, algo ChecksumAlgo) (string, error) {
+	if err := ctx.Err(); err != nil {
+		return "", err
+	}
+	name = cleanPath(name)
+
+	ctx, j := startJob(ctx, "FileChecksum", name, "")
+	defer j.done()
+
+	sum, err := fileChecksum(ctx, name, algo, j)
+	if err == nil {
+		j.add(1, 0)
+	}
+	return sum, err
+}
+
+// fileChecksum hashes name, checking ctx between chunks. own is the job started for the
+// checksum itself, which gets its bytes and progress; a copy verifying its files passes nil.
+func fileChecksum(ctx context.Context, name string, algo ChecksumAlgo, own *job) (string, error) {
+	h, err := newChecksumHash(algo)
+	if err != nil {
+		return "", err
+	}
+
+	acquireFDs(1)
+	defer releaseFDs(1)
+
+	file, err := os.Open(name)
+	if err != nil {
+		errorPrinterCtx(ctx, "FileChecksum (os.Open): "+err.Error(), name)
+		return "", err
+	}
+	defer file.Close()
+
+	var progress *copyProgress
+	if own != nil {
+		if info, err := file.Stat(); err == nil {
+			own.setTotal(info.Size())
+			progress = newCopyProgress(own.ctl.Progress, info.Size())
+		}
+	}
+
+	if _, err := copyChunks(ctx, h, progress.reader(name, file), own != nil); err != nil {
+		errorPrinterCtx(ctx, "FileChecksum (io.Copy): "+err.Error(), name)
+		return "", err
+	}
+	progress.done(name, 0)
+
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// DirChecksum returns a digest over the relative paths of all directories and regular files
+// below path and the checksums of the files. Symlinks and special files are ignored, the same
+// way CopyDir skips them, so a source tree and its copy produce the same digest.
+func DirChecksum(path string, algo ChecksumAlgo) (string, error) {
+	path = cleanPath(path)
+
+	h, err := newChecksumHash(algo)
+	if err != nil {
+		return "", err
+	}
+
+	err = Walk(path, func(name string, info FileInfo) error {
+		rel, err := filepath.Rel(path, name)
+		if err != nil {
+			return err
+		}
+		rel = filepath.ToSlash(rel)
+
+		switch {
+		case info.IsDir:
+			fmt.Fprintf(h, "%s/\n", rel)
+		case info.Mode.IsRegular():
+			sum, err := FileChecksum(name, algo)
+			if err != nil {
+				return err
+			}
+			fmt.Fprintf(h, "%s\x00%s\n", rel, sum)
+		}
+		return nil
+	})
+	if err != nil {
+		errorPrinter("DirChecksum: "+err.Error(), path)
+		return "", err
+	}
+
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// ChecksumMismatchError reports a copy whose destination does not hash like its source
+type ChecksumMismatchError struct {
+	Src    string
+	Dst    string
+	Algo   ChecksumAlgo
+	SrcSum string
+	DstSum string
+}
+
+func (e *ChecksumMismatchError) Error() string {
+	return fmt.Sprintf("%s checksum mismatch: %s (%s) != %s (%s)", e.Algo, e.Src, e.SrcSum, e.Dst, e.DstSum)
+}
+
+// CopyFileVerify copies src to dst and then hashes both, returning a *ChecksumMismatchError
+// when they differ
+func CopyFileVerify(src string, dst string, algo ChecksumAlgo) error {
+	if _, err := newChecksumHash(algo); err != nil {
+		return err
+	}
+	if err := CopyFile(src, dst); err != nil {
+		return err
+	}
+	return verifyCopy(context.Background(), cleanPath(src), cleanPath(dst), algo)
+}
+
+// CopyDirVerify is CopyDir with every copied file verified using algo
+func CopyDirVerify(src string, dst string, algo ChecksumAlgo) error {
+	return CopyDirWithOptions(src, dst, CopyOptions{Verify: algo})
+}
+
+func verifyCopy(ctx context.Context, src string, dst string, algo ChecksumAlgo) error {
+	srcSum, err := fileChecksum(ctx, src, algo, nil)
+	if err != nil {
+		return err
+	}
+	dstSum, err := fileChecksum(ctx, dst, algo, nil)
+	if err != nil {
+		return err
+	}
+
+	if srcSum != dstSum {
+		err := &ChecksumMismatchError{Src: src, Dst: dst, Algo: algo, SrcSum: srcSum, DstSum: dstSum}
+		errorPrinter("verifyCopy: "+err.Error(), dst)
+		return err
+	}
+	return nil
+}
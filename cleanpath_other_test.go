@@ -0,0 +1,16 @@
+//go:build !windows
+
+package GMSFS
+
+// Backslashes are no separators and drive letters no volumes here, so stripping colon
+// prefixes takes Windows names apart; that is why it is off by default
+var cleanPathOSTests = []cleanPathTest{
+	{`C:\data\x`, false, `C:\data\x`},
+	{`C:\data\x`, true, `\data\x`},
+	{"C:/data/../x", false, "C:/x"},
+	{"C:/data/../x", true, "/x"},
+	{`\\server\share\x`, false, `\\server\share\x`},
+	{`\\server\share\x`, true, `\\server\share\x`},
+	{`\\?\C:\data\x`, false, `\\?\C:\data\x`},
+	{`\\?\C:\data\x`, true, `\data\x`},
+}
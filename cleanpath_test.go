@@ -0,0 +1,50 @@
+package GMSFS
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+// cleanPathTest is a cleanPath case; the cases for drive letters, UNC and \\?\ names differ
+// between platforms and are in cleanPathOSTests
+type cleanPathTest struct {
+	path  string
+	strip bool // SetStripColonPrefix
+	want  string
+}
+
+func TestCleanPath(t *testing.T) {
+	if err := RegisterBackend("cleanpathtest", NewMemBackend()); err != nil {
+		t.Fatal(err)
+	}
+	strip := StripColonPrefix()
+	t.Cleanup(func() {
+		UnregisterBackend("cleanpathtest")
+		SetStripColonPrefix(strip)
+	})
+
+	tests := []cleanPathTest{
+		{"a/b/../c", false, filepath.FromSlash("a/c")},
+		{"a/b/../c", true, filepath.FromSlash("a/c")},
+		{"/var/data/", false, filepath.FromSlash("/var/data")},
+		{"data:/var/x", false, filepath.FromSlash("data:/var/x")},
+		{"data:/var/x", true, filepath.FromSlash("/var/x")},
+		{"tag:data:/var/x", true, filepath.FromSlash("/var/x")},
+		{"a:b:c", true, "c"},
+
+		// Registered schemes are kept whether prefixes are stripped or not
+		{"cleanpathtest:/a/../b", false, "cleanpathtest:/b"},
+		{"cleanpathtest:/a/../b", true, "cleanpathtest:/b"},
+		{"cleanpathtest:a", true, "cleanpathtest:/a"},
+	}
+	for _, tt := range append(tests, cleanPathOSTests...) {
+		SetStripColonPrefix(tt.strip)
+		if got := cleanPath(tt.path); got != tt.want {
+			t.Errorf("cleanPath(%q) with strip %v = %q, want %q", tt.path, tt.strip, got, tt.want)
+		}
+		// Cleaning is idempotent, so names cleaned twice stay the same
+		if got := cleanPath(cleanPath(tt.path)); got != tt.want {
+			t.Errorf("cleanPath(cleanPath(%q)) with strip %v = %q, want %q", tt.path, tt.strip, got, tt.want)
+		}
+	}
+}
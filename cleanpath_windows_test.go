@@ -0,0 +1,19 @@
+//go:build windows
+
+package GMSFS
+
+// Drive letters and UNC and \\?\ volumes survive stripping; only prefixes in front of them go
+var cleanPathOSTests = []cleanPathTest{
+	{`C:\data\..\x`, false, `C:\x`},
+	{`C:\data\..\x`, true, `C:\x`},
+	{`c:/data/x`, false, `c:\data\x`},
+	{`c:/data/x`, true, `c:\data\x`},
+	{`tag:C:\data\x`, false, `tag:C:\data\x`},
+	{`tag:C:\data\x`, true, `C:\data\x`},
+	{`\\server\share\dir\..\x`, false, `\\server\share\x`},
+	{`\\server\share\dir\..\x`, true, `\\server\share\x`},
+	{`\\?\C:\data\x`, false, `\\?\C:\data\x`},
+	{`\\?\C:\data\x`, true, `\\?\C:\data\x`},
+	{`\\?\UNC\server\share\x`, false, `\\?\UNC\server\share\x`},
+	{`\\?\UNC\server\share\x`, true, `\\?\UNC\server\share\x`},
+}
@@ -0,0 +1,46 @@
+package GMSFS
+
+import (
+	"errors"
+	"io"
+	"sync"
+)
+
+var (
+	closersMu sync.Mutex
+	closers   = map[io.Closer]struct{}{}
+)
+
+// Close stops everything the package runs in the background and releases the files it holds
+// open, e.g. heartbeats and pooled append handles. The package stays usable afterwards.
+func Close() error {
+	closersMu.Lock()
+	pending := make([]io.Closer, 0, len(closers))
+	for c := range closers {
+		pending = append(pending, c)
+	}
+	closersMu.Unlock()
+
+	var errs []error
+	for _, c := range pending {
+		if err := c.Close(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	CloseAppendHandles()
+	return errors.Join(errs...)
+}
+
+// registerCloser makes Close release c; c unregisters itself when closed directly
+func registerCloser(c io.Closer) {
+	closersMu.Lock()
+	closers[c] = struct{}{}
+	closersMu.Unlock()
+}
+
+func unregisterCloser(c io.Closer) {
+	closersMu.Lock()
+	delete(closers, c)
+	closersMu.Unlock()
+}
@@ -0,0 +1,651 @@
+// Command gmsfs exposes the GMSFS package operations on the command line.
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"sort"
+	"strings"
+	"time"
+
+	GMSFS "github.com/inpadi/GMSFSv2"
+	"github.com/inpadi/GMSFSv2/fusefs"
+)
+
+type command struct {
+	usage string
+	run   func(args []string) error
+}
+
+var commands = map[string]command{
+	"ls":      {usage: "ls [-l] [path]", run: cmdLs},
+	"tree":    {usage: "tree [path]", run: cmdTree},
+	"cp":      {usage: "cp [-merge] [-overwrite|-skip-existing|-update] [-continue] [-p] [-j workers] [-adaptive] [-symlinks skip|link|follow] src dst", run: cmdCp},
+	"rm":      {usage: "rm [-r] path...", run: cmdRm},
+	"sync":    {usage: "sync [-p] [-lock none|wait|fail] [-report text|json|csv] src dst", run: cmdSync},
+	"diff":    {usage: "diff [-format text|json|csv] a b", run: cmdDiff},
+	"du":      {usage: "du [-exclude glob]... [-j workers] path...", run: cmdDu},
+	"df":      {usage: "df path...", run: cmdDf},
+	"quota":   {usage: "quota [-max-bytes n] [-max-files n] dir", run: cmdQuota},
+	"tag":     {usage: "tag [-d] path [key[=value]]... | tag -find key[=value] dir", run: cmdTag},
+	"purge":   {usage: "purge [-pattern glob] -older 720h|-keep n dir", run: cmdPurge},
+	"hash":    {usage: "hash [-a sha256|sha1|md5|crc32] path...", run: cmdHash},
+	"verify":  {usage: "verify [-a algo] src dst", run: cmdVerify},
+	"watch":   {usage: "watch [-r] [-pattern glob] [-debounce 200ms] [-poll 2s [-poll-max 1m]] path", run: cmdWatch},
+	"zip":     {usage: "zip [-include glob]... [-exclude glob]... [-level 1-9|-store] dir archive.zip", run: cmdZip},
+	"unzip":   {usage: "unzip [-include glob]... [-exclude glob]... [-overwrite] archive.zip dir", run: cmdUnzip},
+	"tar":     {usage: "tar [-include glob]... [-exclude glob]... [-z none|gzip|zstd] [-level n] dir archive.tar[.gz|.zst]", run: cmdTar},
+	"untar":   {usage: "untar [-include glob]... [-exclude glob]... [-overwrite] archive dir", run: cmdUntar},
+	"restore": {usage: "restore [-path glob]... [-conflict fail|skip|overwrite|keep-both] [-n] archive dir", run: cmdRestore},
+	"mount":   {usage: "mount [-ro] [-allow-other] [-attr-timeout 1s] [-debug] path dir", run: cmdMount},
+}
+
+// errUsage makes main print the command's usage line instead of an error
+var errUsage = fmt.Errorf("usage")
+
+func main() {
+	if len(os.Args) < 2 {
+		usage()
+		os.Exit(2)
+	}
+
+	cmd, ok := commands[os.Args[1]]
+	if !ok {
+		fmt.Fprintf(os.Stderr, "gmsfs: unknown command %q\n", os.Args[1])
+		usage()
+		os.Exit(2)
+	}
+
+	if err := cmd.run(os.Args[2:]); err != nil {
+		if err == errUsage {
+			fmt.Fprintln(os.Stderr, "usage: gmsfs "+cmd.usage)
+			os.Exit(2)
+		}
+		fmt.Fprintln(os.Stderr, "gmsfs "+os.Args[1]+": "+err.Error())
+		os.Exit(1)
+	}
+}
+
+func usage() {
+	names := make([]string, 0, len(commands))
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	fmt.Fprintln(os.Stderr, "usage: gmsfs <command> [arguments]")
+	fmt.Fprintln(os.Stderr, "commands:")
+	for _, name := range names {
+		fmt.Fprintln(os.Stderr, "  "+commands[name].usage)
+	}
+}
+
+// parse runs a subcommand's flag set and checks the number of positional arguments
+func parse(fs *flag.FlagSet, args []string, minArgs int, maxArgs int) ([]string, error) {
+	fs.Usage = func() {}
+	if err := fs.Parse(args); err != nil {
+		return nil, errUsage
+	}
+	if fs.NArg() < minArgs || (maxArgs >= 0 && fs.NArg() > maxArgs) {
+		return nil, errUsage
+	}
+	return fs.Args(), nil
+}
+
+func pathArg(args []string) string {
+	if len(args) == 0 {
+		return "."
+	}
+	return args[0]
+}
+
+func cmdLs(args []string) error {
+	fs := flag.NewFlagSet("ls", flag.ContinueOnError)
+	long := fs.Bool("l", false, "long listing")
+	args, err := parse(fs, args, 0, 1)
+	if err != nil {
+		return err
+	}
+
+	entries, err := GMSFS.ReadDir(pathArg(args))
+	if err != nil {
+		return err
+	}
+
+	for _, entry := range entries {
+		name := entry.Name
+		if entry.IsDir {
+			name += "/"
+		}
+		if *long {
+			fmt.Printf("%s %12d %s %s\n", entry.Mode, entry.Size, entry.LastModified.Format("2006-01-02 15:04"), name)
+		} else {
+			fmt.Println(name)
+		}
+	}
+	return nil
+}
+
+func cmdTree(args []string) error {
+	args, err := parse(flag.NewFlagSet("tree", flag.ContinueOnError), args, 0, 1)
+	if err != nil {
+		return err
+	}
+
+	root := pathArg(args)
+	return GMSFS.Walk(root, func(name string, info GMSFS.FileInfo) error {
+		switch {
+		case name == root:
+		case info.IsDir:
+			fmt.Println(name + "/")
+		default:
+			fmt.Println(name)
+		}
+		return nil
+	})
+}
+
+func cmdCp(args []string) error {
+	var opts GMSFS.CopyOptions
+	fs := flag.NewFlagSet("cp", flag.ContinueOnError)
+	fs.BoolVar(&opts.MergeExisting, "merge", false, "copy into an existing directory")
+	fs.BoolVar(&opts.OverwriteFiles, "overwrite", false, "replace existing files")
+	fs.BoolVar(&opts.SkipExisting, "skip-existing", false, "keep existing files")
+	fs.BoolVar(&opts.UpdateOnly, "update", false, "replace existing files only when the source is newer")
+	fs.BoolVar(&opts.ContinueOnError, "continue", false, "keep copying after a failure and report all errors")
+	fs.BoolVar(&opts.PreserveTimes, "p", false, "preserve modification times, and owners when run as root")
+	fs.IntVar(&opts.Workers, "j", 0, "number of files to copy at once")
+	fs.BoolVar(&opts.AdaptiveWorkers, "adaptive", false, "tune the number of parallel copies up to -j (or 32) automatically")
+	symlinks := fs.String("symlinks", "skip", "skip links, recreate them (link) or copy their targets (follow)")
+	args, err := parse(fs, args, 2, 2)
+	if err != nil {
+		return err
+	}
+	switch *symlinks {
+	case "skip":
+	case "link":
+		opts.Symlinks = GMSFS.SymlinkCopyAsLink
+	case "follow":
+		opts.Symlinks = GMSFS.SymlinkFollowAndCopy
+	default:
+		return errUsage
+	}
+	opts.PreserveOwner = opts.PreserveTimes
+
+	info, err := GMSFS.Stat(args[0])
+	if err != nil {
+		return err
+	}
+	if info.IsDir {
+		return GMSFS.CopyDirWithOptions(args[0], args[1], opts)
+	}
+	return GMSFS.CopyFileWithOptions(args[0], args[1], opts)
+}
+
+// cmdRm removes files, and whole trees with -r, printing what -r removed
+func cmdRm(args []string) error {
+	fs := flag.NewFlagSet("rm", flag.ContinueOnError)
+	recursive := fs.Bool("r", false, "remove directories and their contents")
+	args, err := parse(fs, args, 1, -1)
+	if err != nil {
+		return err
+	}
+
+	var errs []error
+	for _, name := range args {
+		if !*recursive {
+			if err := GMSFS.Remove(name); err != nil {
+				errs = append(errs, err)
+			}
+			continue
+		}
+		stats, err := GMSFS.RemoveAllWithStats(name)
+		if err != nil {
+			errs = append(errs, err)
+		}
+		fmt.Printf("%d files\t%d dirs\t%d\t%s\n", stats.Files, stats.Dirs, stats.Bytes, name)
+	}
+	return errors.Join(errs...)
+}
+
+func cmdSync(args []string) error {
+	var opts GMSFS.CopyOptions
+	fs := flag.NewFlagSet("sync", flag.ContinueOnError)
+	fs.BoolVar(&opts.PreserveTimes, "p", false, "preserve modification times and compare files by size and time")
+	lock := fs.String("lock", "none", "when another sync writes to dst: ignore it (none), queue (wait) or fail")
+	report := fs.String("report", "", "print what was copied and skipped as text, json or csv")
+	args, err := parse(fs, args, 2, 2)
+	if err != nil {
+		return err
+	}
+	switch *lock {
+	case "none":
+	case "wait":
+		opts.DestinationLock = GMSFS.DestLockWait
+	case "fail":
+		opts.DestinationLock = GMSFS.DestLockFail
+	default:
+		return errUsage
+	}
+
+	if *report == "" {
+		_, err = GMSFS.SyncDirWithOptions(args[0], args[1], opts)
+		return err
+	}
+
+	r, err := GMSFS.SyncDirWithReport(args[0], args[1], opts)
+	if werr := r.Write(os.Stdout, GMSFS.ReportFormat(*report)); err == nil {
+		err = werr
+	}
+	return err
+}
+
+// cmdDiff prints the differences between two trees and fails when there are any, like diff
+func cmdDiff(args []string) error {
+	fs := flag.NewFlagSet("diff", flag.ContinueOnError)
+	format := fs.String("format", "text", "text, json or csv")
+	args, err := parse(fs, args, 2, 2)
+	if err != nil {
+		return err
+	}
+
+	r, err := GMSFS.DiffDirs(args[0], args[1])
+	if err != nil {
+		return err
+	}
+	if err := r.Write(os.Stdout, GMSFS.ReportFormat(*format)); err != nil {
+		return err
+	}
+	if r.Changed() {
+		return fmt.Errorf("trees differ")
+	}
+	return nil
+}
+
+func cmdDu(args []string) error {
+	var opts GMSFS.DirSizeOptions
+	fs := flag.NewFlagSet("du", flag.ContinueOnError)
+	fs.Var((*globList)(&opts.Exclude), "exclude", "skip files and directories matching this glob")
+	fs.IntVar(&opts.Workers, "j", 0, "directories read at once")
+	args, err := parse(fs, args, 1, -1)
+	if err != nil {
+		return err
+	}
+
+	var errs []error
+	for _, name := range args {
+		usage, err := GMSFS.DirSizeWithOptions(name, opts)
+		if err != nil {
+			errs = append(errs, err)
+		}
+		fmt.Printf("%d\t%d files\t%d dirs\t%s\n", usage.Bytes, usage.Files, usage.Dirs, name)
+	}
+	return errors.Join(errs...)
+}
+
+func cmdDf(args []string) error {
+	fs := flag.NewFlagSet("df", flag.ContinueOnError)
+	args, err := parse(fs, args, 1, -1)
+	if err != nil {
+		return err
+	}
+
+	var errs []error
+	for _, name := range args {
+		space, err := GMSFS.DiskFree(name)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		fmt.Printf("%d\t%d\t%d\t%.0f%%\t%s\n", space.Total, space.Free, space.Available, space.Used()*100, name)
+	}
+	return errors.Join(errs...)
+}
+
+// cmdQuota counts dir as a quota does, leaving out soft deleted files and tag sidecars, and
+// fails when it holds more than the limits given
+func cmdQuota(args []string) error {
+	var q GMSFS.Quota
+	fs := flag.NewFlagSet("quota", flag.ContinueOnError)
+	fs.Int64Var(&q.MaxBytes, "max-bytes", 0, "fail when dir holds more bytes than this")
+	fs.Int64Var(&q.MaxFiles, "max-files", 0, "fail when dir holds more files than this")
+	args, err := parse(fs, args, 1, 1)
+	if err != nil {
+		return err
+	}
+	if q.MaxBytes < 0 || q.MaxFiles < 0 {
+		return errUsage
+	}
+
+	if err := GMSFS.TrackUsage(args[0]); err != nil {
+		return err
+	}
+	usage, _ := GMSFS.Usage(args[0])
+	fmt.Printf("%d\t%d files\t%s\n", usage.Bytes, usage.Files, args[0])
+
+	if q.MaxBytes > 0 && usage.Bytes > q.MaxBytes || q.MaxFiles > 0 && usage.Files > q.MaxFiles {
+		return GMSFS.ErrQuotaExceeded
+	}
+	return nil
+}
+
+// cmdTag prints the tags of path, sets those given as key=value, removes the keys given with
+// -d, or with -find lists the paths below dir carrying a tag
+func cmdTag(args []string) error {
+	fs := flag.NewFlagSet("tag", flag.ContinueOnError)
+	remove := fs.Bool("d", false, "remove the tags named")
+	find := fs.String("find", "", "list paths below dir with this tag, or tag value")
+	args, err := parse(fs, args, 1, -1)
+	if err != nil {
+		return err
+	}
+
+	if *find != "" {
+		if *remove || len(args) != 1 {
+			return errUsage
+		}
+		key, value, _ := strings.Cut(*find, "=")
+		found, err := GMSFS.FindByTag(args[0], key, value)
+		for _, name := range found {
+			fmt.Println(name)
+		}
+		return err
+	}
+
+	name, keys := args[0], args[1:]
+	if len(keys) == 0 {
+		if *remove {
+			return errUsage
+		}
+		tags, err := GMSFS.GetTags(name)
+		if err != nil {
+			return err
+		}
+		names := make([]string, 0, len(tags))
+		for key := range tags {
+			names = append(names, key)
+		}
+		sort.Strings(names)
+		for _, key := range names {
+			fmt.Println(key + "=" + tags[key])
+		}
+		return nil
+	}
+
+	for _, arg := range keys {
+		if *remove {
+			err = GMSFS.Untag(name, arg)
+		} else {
+			key, value, _ := strings.Cut(arg, "=")
+			err = GMSFS.Tag(name, key, value)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func cmdPurge(args []string) error {
+	fs := flag.NewFlagSet("purge", flag.ContinueOnError)
+	pattern := fs.String("pattern", "*", "only files matching this glob")
+	older := fs.Duration("older", 0, "delete files modified longer ago than this")
+	keep := fs.Int("keep", -1, "keep this many of the newest files")
+	args, err := parse(fs, args, 1, 1)
+	if err != nil {
+		return err
+	}
+	if (*older > 0) == (*keep >= 0) {
+		return errUsage
+	}
+
+	var deleted []string
+	if *older > 0 {
+		deleted, err = GMSFS.DeleteOlderThan(args[0], *pattern, *older)
+	} else {
+		deleted, err = GMSFS.Prune(args[0], *keep, *pattern)
+	}
+	for _, name := range deleted {
+		fmt.Println(name)
+	}
+	return err
+}
+
+func cmdHash(args []string) error {
+	fs := flag.NewFlagSet("hash", flag.ContinueOnError)
+	algo := fs.String("a", string(GMSFS.ChecksumSHA256), "checksum algorithm")
+	args, err := parse(fs, args, 1, -1)
+	if err != nil {
+		return err
+	}
+
+	for _, name := range args {
+		info, err := GMSFS.Stat(name)
+		if err != nil {
+			return err
+		}
+
+		var sum string
+		if info.IsDir {
+			sum, err = GMSFS.DirChecksum(name, GMSFS.ChecksumAlgo(*algo))
+		} else {
+			sum, err = GMSFS.FileChecksum(name, GMSFS.ChecksumAlgo(*algo))
+		}
+		if err != nil {
+			return err
+		}
+		fmt.Println(sum + "  " + name)
+	}
+	return nil
+}
+
+// cmdVerify compares two files or trees by checksum
+func cmdVerify(args []string) error {
+	fs := flag.NewFlagSet("verify", flag.ContinueOnError)
+	algo := fs.String("a", string(GMSFS.ChecksumSHA256), "checksum algorithm")
+	args, err := parse(fs, args, 2, 2)
+	if err != nil {
+		return err
+	}
+
+	sums := make([]string, 2)
+	for i, name := range args {
+		info, err := GMSFS.Stat(name)
+		if err != nil {
+			return err
+		}
+		if info.IsDir {
+			sums[i], err = GMSFS.DirChecksum(name, GMSFS.ChecksumAlgo(*algo))
+		} else {
+			sums[i], err = GMSFS.FileChecksum(name, GMSFS.ChecksumAlgo(*algo))
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	if sums[0] != sums[1] {
+		return &GMSFS.ChecksumMismatchError{Src: args[0], Dst: args[1], Algo: GMSFS.ChecksumAlgo(*algo), SrcSum: sums[0], DstSum: sums[1]}
+	}
+	fmt.Println("OK " + sums[0])
+	return nil
+}
+
+// globList collects a repeatable glob flag
+type globList []string
+
+func (g *globList) String() string { return strings.Join(*g, ",") }
+
+func (g *globList) Set(v string) error {
+	*g = append(*g, v)
+	return nil
+}
+
+func cmdZip(args []string) error {
+	var opts GMSFS.ZipOptions
+	fs := flag.NewFlagSet("zip", flag.ContinueOnError)
+	fs.Var((*globList)(&opts.Include), "include", "only archive files matching this glob")
+	fs.Var((*globList)(&opts.Exclude), "exclude", "skip files and directories matching this glob")
+	fs.IntVar(&opts.Level, "level", 0, "deflate level, 1 (fastest) to 9 (smallest)")
+	fs.BoolVar(&opts.Store, "store", false, "store files without compression")
+	args, err := parse(fs, args, 2, 2)
+	if err != nil {
+		return err
+	}
+
+	return GMSFS.ZipDir(args[0], args[1], opts)
+}
+
+func cmdUnzip(args []string) error {
+	var opts GMSFS.ZipOptions
+	fs := flag.NewFlagSet("unzip", flag.ContinueOnError)
+	fs.Var((*globList)(&opts.Include), "include", "only extract files matching this glob")
+	fs.Var((*globList)(&opts.Exclude), "exclude", "skip files and directories matching this glob")
+	fs.BoolVar(&opts.Overwrite, "overwrite", false, "replace existing files")
+	args, err := parse(fs, args, 2, 2)
+	if err != nil {
+		return err
+	}
+
+	return GMSFS.Unzip(args[0], args[1], opts)
+}
+
+func cmdTar(args []string) error {
+	var opts GMSFS.TarOptions
+	fs := flag.NewFlagSet("tar", flag.ContinueOnError)
+	fs.Var((*globList)(&opts.Include), "include", "only archive files matching this glob")
+	fs.Var((*globList)(&opts.Exclude), "exclude", "skip files and directories matching this glob")
+	compression := fs.String("z", "", "compression, guessed from the archive name by default")
+	fs.IntVar(&opts.Level, "level", 0, "compression level")
+	args, err := parse(fs, args, 2, 2)
+	if err != nil {
+		return err
+	}
+	opts.Compression = GMSFS.Compression(*compression)
+
+	return GMSFS.TarDir(args[0], args[1], opts)
+}
+
+func cmdUntar(args []string) error {
+	var opts GMSFS.TarOptions
+	fs := flag.NewFlagSet("untar", flag.ContinueOnError)
+	fs.Var((*globList)(&opts.Include), "include", "only extract files matching this glob")
+	fs.Var((*globList)(&opts.Exclude), "exclude", "skip files and directories matching this glob")
+	fs.BoolVar(&opts.Overwrite, "overwrite", false, "replace existing files")
+	args, err := parse(fs, args, 2, 2)
+	if err != nil {
+		return err
+	}
+
+	return GMSFS.UntarDir(args[0], args[1], opts)
+}
+
+func cmdWatch(args []string) error {
+	var opts GMSFS.WatchOptions
+	fs := flag.NewFlagSet("watch", flag.ContinueOnError)
+	fs.BoolVar(&opts.Recursive, "r", false, "watch subdirectories")
+	fs.StringVar(&opts.Pattern, "pattern", "", "only report names matching this glob")
+	fs.DurationVar(&opts.Debounce, "debounce", 0, "merge events within this window")
+	fs.DurationVar(&opts.PollInterval, "poll", 0, "poll at this interval instead of using OS notifications")
+	fs.DurationVar(&opts.MaxPollInterval, "poll-max", 0, "back off polling of quiet directories up to this interval")
+	args, err := parse(fs, args, 1, 1)
+	if err != nil {
+		return err
+	}
+	opts.Poll = opts.PollInterval > 0
+
+	w, err := GMSFS.Watch(args[0], opts)
+	if err != nil {
+		return err
+	}
+	defer w.Close()
+
+	interrupt := make(chan os.Signal, 1)
+	signal.Notify(interrupt, os.Interrupt)
+
+	for {
+		select {
+		case ev := <-w.Events:
+			fmt.Println(ev.Time.Format(time.RFC3339) + " " + ev.Op.String() + " " + ev.Path)
+		case err := <-w.Errors:
+			fmt.Fprintln(os.Stderr, "gmsfs watch: "+err.Error())
+		case <-interrupt:
+			return nil
+		}
+	}
+}
+
+func cmdRestore(args []string) error {
+	var policy GMSFS.RestorePolicy
+	fs := flag.NewFlagSet("restore", flag.ContinueOnError)
+	fs.Var((*globList)(&policy.Paths), "path", "only restore entries matching this glob, or below a matching directory")
+	conflict := fs.String("conflict", "fail", "for files in the way: restore nothing (fail), keep them (skip), replace them (overwrite) or restore next to them (keep-both)")
+	fs.BoolVar(&policy.DryRun, "n", false, "only print what would be restored")
+	args, err := parse(fs, args, 2, 2)
+	if err != nil {
+		return err
+	}
+	switch *conflict {
+	case "fail":
+	case "skip":
+		policy.Conflict = GMSFS.RestoreSkip
+	case "overwrite":
+		policy.Conflict = GMSFS.RestoreOverwrite
+	case "keep-both":
+		policy.Conflict = GMSFS.RestoreKeepBoth
+	default:
+		return errUsage
+	}
+
+	report, err := GMSFS.RestoreArchive(args[0], args[1], policy)
+	for _, name := range report.Conflicts {
+		if to, ok := report.Renamed[name]; ok {
+			fmt.Println("conflict " + name + " -> " + to)
+		} else {
+			fmt.Println("conflict " + name)
+		}
+	}
+	for _, name := range report.Restored {
+		fmt.Println("restore " + name)
+	}
+	for _, name := range report.Missing {
+		fmt.Println("missing " + name)
+	}
+	return err
+}
+
+func cmdMount(args []string) error {
+	var opts fusefs.Options
+	fs := flag.NewFlagSet("mount", flag.ContinueOnError)
+	fs.BoolVar(&opts.ReadOnly, "ro", false, "mount read-only")
+	fs.BoolVar(&opts.AllowOther, "allow-other", false, "let other users access the mount")
+	fs.DurationVar(&opts.AttrTimeout, "attr-timeout", 0, "let the kernel cache names and attributes this long")
+	fs.BoolVar(&opts.Debug, "debug", false, "log every FUSE request")
+	args, err := parse(fs, args, 2, 2)
+	if err != nil {
+		return err
+	}
+
+	srv, err := fusefs.Mount(args[1], args[0], opts)
+	if err != nil {
+		return err
+	}
+
+	interrupt := make(chan os.Signal, 1)
+	signal.Notify(interrupt, os.Interrupt)
+	unmounted := make(chan struct{})
+	go func() {
+		srv.Wait()
+		close(unmounted)
+	}()
+
+	select {
+	case <-unmounted:
+		return nil
+	case <-interrupt:
+		return srv.Unmount()
+	}
+}
@@ -0,0 +1,194 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// TestMain runs main instead of the tests when the test binary is started by gmsfs below
+func TestMain(m *testing.M) {
+	if os.Getenv("GMSFS_TEST_MAIN") == "1" {
+		main()
+		os.Exit(0)
+	}
+	os.Exit(m.Run())
+}
+
+// gmsfs runs the command line args in dir and returns its output and exit code
+func gmsfs(t *testing.T, dir string, args ...string) (stdout string, stderr string, code int) {
+	t.Helper()
+	cmd := exec.Command(os.Args[0], args...)
+	cmd.Dir = dir
+	cmd.Env = append(os.Environ(), "GMSFS_TEST_MAIN=1")
+	var out, errOut bytes.Buffer
+	cmd.Stdout, cmd.Stderr = &out, &errOut
+
+	err := cmd.Run()
+	var exit *exec.ExitError
+	if err != nil && !errors.As(err, &exit) {
+		t.Fatal(err)
+	}
+	return out.String(), errOut.String(), cmd.ProcessState.ExitCode()
+}
+
+// writeTree creates files below dir from a map of slash-separated names to contents
+func writeTree(t *testing.T, dir string, files map[string]string) {
+	t.Helper()
+	for name, content := range files {
+		p := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(p, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestUsage(t *testing.T) {
+	dir := t.TempDir()
+	tests := []struct {
+		args   []string
+		stderr string
+	}{
+		{nil, "usage: gmsfs <command>"},
+		{[]string{"frobnicate"}, `unknown command "frobnicate"`},
+		{[]string{"cp", "only-one"}, "usage: gmsfs cp"},
+		{[]string{"ls", "-x"}, "usage: gmsfs ls"},
+		{[]string{"tag", "-d", "a.txt"}, "usage: gmsfs tag"},
+		{[]string{"quota", "-max-bytes", "-1", "."}, "usage: gmsfs quota"},
+	}
+	for _, tt := range tests {
+		_, stderr, code := gmsfs(t, dir, tt.args...)
+		if code != 2 || !strings.Contains(stderr, tt.stderr) {
+			t.Errorf("gmsfs %v = %d %q, want 2 and %q", tt.args, code, stderr, tt.stderr)
+		}
+	}
+}
+
+func TestLs(t *testing.T) {
+	dir := t.TempDir()
+	writeTree(t, dir, map[string]string{"b.txt": "b", "a.txt": "a", "sub/c.txt": "c"})
+
+	if stdout, _, code := gmsfs(t, dir, "ls"); code != 0 || stdout != "a.txt\nb.txt\nsub/\n" {
+		t.Errorf("ls = %d %q", code, stdout)
+	}
+	if stdout, _, code := gmsfs(t, dir, "ls", "-l", "sub"); code != 0 || !strings.Contains(stdout, "1 ") || !strings.HasSuffix(stdout, " c.txt\n") {
+		t.Errorf("ls -l sub = %d %q", code, stdout)
+	}
+	if _, stderr, code := gmsfs(t, dir, "ls", "missing"); code != 1 || !strings.HasPrefix(stderr, "gmsfs ls: ") {
+		t.Errorf("ls missing = %d %q", code, stderr)
+	}
+}
+
+func TestCp(t *testing.T) {
+	dir := t.TempDir()
+	writeTree(t, dir, map[string]string{"src/a.txt": "a", "src/sub/b.txt": "b", "single.txt": "s"})
+
+	if _, stderr, code := gmsfs(t, dir, "cp", "src", "dst"); code != 0 {
+		t.Fatalf("cp = %d %q", code, stderr)
+	}
+	for name, want := range map[string]string{"dst/a.txt": "a", "dst/sub/b.txt": "b"} {
+		if data, err := os.ReadFile(filepath.Join(dir, name)); err != nil || string(data) != want {
+			t.Errorf("%s = %q, %v", name, data, err)
+		}
+	}
+	if _, _, code := gmsfs(t, dir, "cp", "single.txt", "dst/single.txt"); code != 0 {
+		t.Errorf("cp of a file = %d", code)
+	}
+
+	if _, _, code := gmsfs(t, dir, "cp", "src", "dst"); code != 1 {
+		t.Errorf("cp over an existing directory = %d, want 1", code)
+	}
+	if _, stderr, code := gmsfs(t, dir, "cp", "-merge", "-overwrite", "src", "dst"); code != 0 {
+		t.Errorf("cp -merge -overwrite = %d %q", code, stderr)
+	}
+	if _, _, code := gmsfs(t, dir, "cp", "-symlinks", "sideways", "src", "dst"); code != 2 {
+		t.Errorf("cp with a bad -symlinks = %d, want 2", code)
+	}
+	if _, _, code := gmsfs(t, dir, "cp", "missing", "x"); code != 1 {
+		t.Errorf("cp of a missing source = %d, want 1", code)
+	}
+}
+
+func TestRm(t *testing.T) {
+	dir := t.TempDir()
+	writeTree(t, dir, map[string]string{"a.txt": "a", "tree/b.txt": "bb", "tree/sub/c.txt": "ccc"})
+
+	if _, _, code := gmsfs(t, dir, "rm", "a.txt"); code != 0 {
+		t.Errorf("rm = %d", code)
+	}
+	if _, _, code := gmsfs(t, dir, "rm", "tree"); code != 1 {
+		t.Errorf("rm of a directory without -r = %d, want 1", code)
+	}
+	if stdout, _, code := gmsfs(t, dir, "rm", "-r", "tree"); code != 0 || stdout != "2 files\t2 dirs\t5\ttree\n" {
+		t.Errorf("rm -r = %d %q", code, stdout)
+	}
+	if entries, _ := os.ReadDir(dir); len(entries) != 0 {
+		t.Errorf("left behind: %v", entries)
+	}
+	if _, stderr, code := gmsfs(t, dir, "rm", "missing"); code != 1 || !strings.HasPrefix(stderr, "gmsfs rm: ") {
+		t.Errorf("rm missing = %d %q", code, stderr)
+	}
+}
+
+func TestTag(t *testing.T) {
+	dir := t.TempDir()
+	writeTree(t, dir, map[string]string{"a.txt": "a", "sub/b.txt": "b"})
+
+	if _, stderr, code := gmsfs(t, dir, "tag", "a.txt", "owner=ops", "tier=hot"); code != 0 {
+		t.Fatalf("tag = %d %q", code, stderr)
+	}
+	if _, _, code := gmsfs(t, dir, "tag", "sub/b.txt", "tier=cold"); code != 0 {
+		t.Fatalf("tag = %d", code)
+	}
+	if stdout, _, code := gmsfs(t, dir, "tag", "a.txt"); code != 0 || stdout != "owner=ops\ntier=hot\n" {
+		t.Errorf("tag listing = %d %q", code, stdout)
+	}
+	if stdout, _, code := gmsfs(t, dir, "tag", "-find", "tier", "."); code != 0 || stdout != "a.txt\nsub/b.txt\n" {
+		t.Errorf("tag -find tier = %d %q", code, stdout)
+	}
+	if stdout, _, code := gmsfs(t, dir, "tag", "-find", "tier=cold", "."); code != 0 || stdout != "sub/b.txt\n" {
+		t.Errorf("tag -find tier=cold = %d %q", code, stdout)
+	}
+
+	if _, _, code := gmsfs(t, dir, "tag", "-d", "a.txt", "tier"); code != 0 {
+		t.Errorf("tag -d = %d", code)
+	}
+	if stdout, _, _ := gmsfs(t, dir, "tag", "a.txt"); stdout != "owner=ops\n" {
+		t.Errorf("tags after -d = %q", stdout)
+	}
+	if _, _, code := gmsfs(t, dir, "tag", "missing", "k=v"); code != 1 {
+		t.Errorf("tag of a missing file = %d, want 1", code)
+	}
+	if _, _, code := gmsfs(t, dir, "tag", "a.txt", "=v"); code != 1 {
+		t.Errorf("tag with an empty key = %d, want 1", code)
+	}
+}
+
+func TestQuota(t *testing.T) {
+	dir := t.TempDir()
+	writeTree(t, dir, map[string]string{"data/a": "12345", "data/b": "123"})
+	if _, _, code := gmsfs(t, dir, "tag", "data/a", "k=v"); code != 0 {
+		t.Fatalf("tag = %d", code)
+	}
+
+	// The tag sidecar is not counted
+	if stdout, _, code := gmsfs(t, dir, "quota", "data"); code != 0 || stdout != "8\t2 files\tdata\n" {
+		t.Errorf("quota = %d %q", code, stdout)
+	}
+	if _, _, code := gmsfs(t, dir, "quota", "-max-bytes", "8", "-max-files", "2", "data"); code != 0 {
+		t.Errorf("quota within the limits = %d", code)
+	}
+	for _, limit := range [][]string{{"-max-bytes", "7"}, {"-max-files", "1"}} {
+		args := append(append([]string{"quota"}, limit...), "data")
+		if _, stderr, code := gmsfs(t, dir, args...); code != 1 || !strings.Contains(stderr, "quota exceeded") {
+			t.Errorf("gmsfs %v = %d %q", args, code, stderr)
+		}
+	}
+}
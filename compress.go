@@ -0,0 +1,261 @@
+package GMSFS
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// Compression selects a stream compression format
+type Compression string
+
+const (
+	CompressionNone Compression = "none"
+	CompressionGzip Compression = "gzip"
+	CompressionZstd Compression = "zstd"
+)
+
+var (
+	gzipMagic = []byte{0x1f, 0x8b}
+	zstdMagic = []byte{0x28, 0xb5, 0x2f, 0xfd}
+)
+
+// CompressFile replaces name with a compressed copy named name.gz or name.zst, keeping its
+// mode and mtime, and returns the new name. A pooled Append handle on name is closed first,
+// so later appends start a fresh file; this makes it suitable for compacting rotated logs.
+func CompressFile(name string, algo Compression) (string, error) {
+	name = cleanPath(name)
+	if err := requireLocal("compress", name); err != nil {
+		errorPrinter("CompressFile: "+err.Error(), name)
+		return "", err
+	}
+
+	var dst string
+	switch algo {
+	case CompressionGzip:
+		dst = name + ".gz"
+	case CompressionZstd:
+		dst = name + ".zst"
+	default:
+		return "", fmt.Errorf("CompressFile: unsupported compression %q", algo)
+	}
+
+	closeAppendHandle(name)
+	err := recodeFile(name, dst, func(in io.Reader, out io.Writer) error {
+		cw, err := compressWriter(out, algo, 0)
+		if err != nil {
+			return err
+		}
+		_, err = io.Copy(cw, in)
+		if cerr := cw.Close(); err == nil {
+			err = cerr
+		}
+		return err
+	})
+	if err != nil {
+		errorPrinter("CompressFile: "+err.Error(), name)
+		return "", err
+	}
+
+	return dst, nil
+}
+
+// DecompressFile replaces a gzip or zstd file with its decompressed content, detected from
+// the file itself, and returns the new name: name without its .gz, .zst or .zstd extension
+// (.tgz and .tzst become .tar).
+func DecompressFile(name string) (string, error) {
+	name = cleanPath(name)
+	if err := requireLocal("decompress", name); err != nil {
+		errorPrinter("DecompressFile: "+err.Error(), name)
+		return "", err
+	}
+
+	dst, ok := decompressedName(name)
+	if !ok {
+		err := fmt.Errorf("DecompressFile: %s has no compressed file extension", name)
+		errorPrinter(err.Error(), name)
+		return "", err
+	}
+
+	err := recodeFile(name, dst, func(in io.Reader, out io.Writer) error {
+		br := bufio.NewReader(in)
+		if head, _ := br.Peek(4); !bytes.HasPrefix(head, gzipMagic) && !bytes.HasPrefix(head, zstdMagic) {
+			return fmt.Errorf("%s is not gzip or zstd data", name)
+		}
+
+		dr, err := decompressReader(br)
+		if err != nil {
+			return err
+		}
+		defer dr.Close()
+
+		_, err = io.Copy(out, dr)
+		return err
+	})
+	if err != nil {
+		errorPrinter("DecompressFile: "+err.Error(), name)
+		return "", err
+	}
+
+	return dst, nil
+}
+
+// ReadFileCompressed is ReadFile that transparently decompresses gzip and zstd content,
+// detected by its magic bytes rather than the file name. Other files are returned as they are.
+func ReadFileCompressed(name string) ([]byte, error) {
+	content, err := ReadFile(cleanPath(name))
+	if err != nil {
+		return nil, err
+	}
+	if !bytes.HasPrefix(content, gzipMagic) && !bytes.HasPrefix(content, zstdMagic) {
+		return content, nil
+	}
+
+	dr, err := decompressReader(bytes.NewReader(content))
+	if err != nil {
+		errorPrinter("ReadFileCompressed: "+err.Error(), name)
+		return nil, err
+	}
+	defer dr.Close()
+
+	content, err = io.ReadAll(dr)
+	if err != nil {
+		errorPrinter("ReadFileCompressed: "+err.Error(), name)
+		return nil, err
+	}
+	return content, nil
+}
+
+// recodeFile writes src through fn to dst atomically, copies src's mtime and removes src
+func recodeFile(src string, dst string, fn func(in io.Reader, out io.Writer) error) error {
+	info, err := os.Stat(src)
+	if err != nil {
+		return err
+	}
+	if !info.Mode().IsRegular() {
+		return fmt.Errorf("%s: %w", src, ErrSpecialFile)
+	}
+	if _, err := os.Lstat(dst); err == nil {
+		return &os.PathError{Op: "recode", Path: dst, Err: os.ErrExist}
+	}
+
+	err = func() error {
+		acquireFDs(2)
+		defer releaseFDs(2)
+
+		simulateOp()
+		in, err := os.Open(src)
+		if err != nil {
+			return err
+		}
+		defer in.Close()
+
+		return writeAtomic(dst, info.Mode().Perm(), func(w io.Writer) error {
+			return fn(simulatedReader(in), simulatedWriter(w))
+		})
+	}()
+	if err != nil {
+		return err
+	}
+
+	if err := os.Chtimes(dst, info.ModTime(), info.ModTime()); err != nil {
+		return err
+	}
+	invalidateStat(dst)
+	return Delete(src)
+}
+
+// decompressedName strips a compressed file extension
+func decompressedName(name string) (string, bool) {
+	lower := strings.ToLower(name)
+	for _, ext := range []string{".gz", ".zst", ".zstd"} {
+		if strings.HasSuffix(lower, ext) && len(name) > len(ext) {
+			return name[:len(name)-len(ext)], true
+		}
+	}
+	for _, ext := range []string{".tgz", ".tzst"} {
+		if strings.HasSuffix(lower, ext) && len(name) > len(ext) {
+			return name[:len(name)-len(ext)] + ".tar", true
+		}
+	}
+	return "", false
+}
+
+// compressionForName guesses the compression from a file extension
+func compressionForName(name string) Compression {
+	lower := strings.ToLower(name)
+	switch {
+	case strings.HasSuffix(lower, ".gz"), strings.HasSuffix(lower, ".tgz"):
+		return CompressionGzip
+	case strings.HasSuffix(lower, ".zst"), strings.HasSuffix(lower, ".zstd"), strings.HasSuffix(lower, ".tzst"):
+		return CompressionZstd
+	}
+	return CompressionNone
+}
+
+// compressWriter wraps w so that everything written is compressed. Level 0 uses the
+// format's default; gzip accepts 1-9 and zstd 1-22.
+func compressWriter(w io.Writer, c Compression, level int) (io.WriteCloser, error) {
+	switch c {
+	case CompressionNone, "":
+		return nopWriteCloser{w}, nil
+
+	case CompressionGzip:
+		if level == 0 {
+			level = gzip.DefaultCompression
+		} else if level < gzip.BestSpeed || level > gzip.BestCompression {
+			return nil, fmt.Errorf("gzip: compression level %d out of range 1-9", level)
+		}
+		return gzip.NewWriterLevel(w, level)
+
+	case CompressionZstd:
+		var opts []zstd.EOption
+		if level != 0 {
+			if level < 1 || level > 22 {
+				return nil, fmt.Errorf("zstd: compression level %d out of range 1-22", level)
+			}
+			opts = append(opts, zstd.WithEncoderLevel(zstd.EncoderLevelFromZstd(level)))
+		}
+		return zstd.NewWriter(w, opts...)
+	}
+
+	return nil, fmt.Errorf("unknown compression %q", c)
+}
+
+// decompressReader detects gzip or zstd from the stream's first bytes and decompresses it;
+// anything else is passed through unchanged
+func decompressReader(r io.Reader) (io.ReadCloser, error) {
+	br := bufio.NewReader(r)
+	head, _ := br.Peek(4)
+
+	switch {
+	case bytes.HasPrefix(head, gzipMagic):
+		return gzip.NewReader(br)
+
+	case bytes.HasPrefix(head, zstdMagic):
+		d, err := zstd.NewReader(br)
+		if err != nil {
+			return nil, err
+		}
+		return zstdReadCloser{d}, nil
+	}
+
+	return io.NopCloser(br), nil
+}
+
+type nopWriteCloser struct{ io.Writer }
+
+func (nopWriteCloser) Close() error { return nil }
+
+type zstdReadCloser struct{ *zstd.Decoder }
+
+func (z zstdReadCloser) Close() error {
+	z.Decoder.Close()
+	return nil
+}
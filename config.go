@@ -0,0 +1,361 @@
+package GMSFS
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Config is the package configuration as read by LoadConfig. Fields missing from a file
+// keep the values of DefaultConfig.
+type Config struct {
+	StatCacheTTL         Duration                 `json:"stat_cache_ttl" yaml:"stat_cache_ttl"`           // 0 disables the Stat cache
+	AppendIdleTimeout    Duration                 `json:"append_idle_timeout" yaml:"append_idle_timeout"` // 0 disables append handle reuse
+	FDBudget             int                      `json:"fd_budget" yaml:"fd_budget"`                     // 0 derives it from the process limit
+	SpecialFileGuard     bool                     `json:"special_file_guard" yaml:"special_file_guard"`
+	StripColonPrefix     bool                     `json:"strip_colon_prefix" yaml:"strip_colon_prefix"`       // See SetStripColonPrefix
+	CaseInsensitive      bool                     `json:"case_insensitive" yaml:"case_insensitive"`           // See SetCaseInsensitive
+	UnicodeNormalization UnicodeForm              `json:"unicode_normalization" yaml:"unicode_normalization"` // nfc, nfd or empty; see SetUnicodeNormalization
+	RecentErrors         int                      `json:"recent_errors" yaml:"recent_errors"`                 // Errors kept for RecentErrors; 0 keeps none
+	ReportLegacyCalls    bool                     `json:"report_legacy_calls" yaml:"report_legacy_calls"`     // See SetReportLegacyCalls
+	Log                  LogConfig                `json:"log" yaml:"log"`
+	Profiles             map[string]ProfileConfig `json:"profiles" yaml:"profiles"` // Keyed by path prefix
+	SlowDisk             SlowDiskConfig           `json:"slow_disk" yaml:"slow_disk"`
+	Backends             map[string]BackendConfig `json:"backends" yaml:"backends"` // Keyed by scheme
+}
+
+// LogConfig selects where package log output goes
+type LogConfig struct {
+	Level  string `json:"level" yaml:"level"`   // debug, info, warn or error
+	Output string `json:"output" yaml:"output"` // debug-file (the GMSFS.Debug switch), stderr, stdout, none or a file path
+	Format string `json:"format" yaml:"format"` // text or json, for every output but debug-file
+}
+
+// ProfileConfig is a Profile as written in a config file
+type ProfileConfig struct {
+	Retries          int      `json:"retries" yaml:"retries"`
+	Durable          bool     `json:"durable" yaml:"durable"`
+	StatCacheTTL     Duration `json:"stat_cache_ttl" yaml:"stat_cache_ttl"`
+	NoStatCache      bool     `json:"no_stat_cache" yaml:"no_stat_cache"`
+	ReadBytesPerSec  int64    `json:"read_bytes_per_sec" yaml:"read_bytes_per_sec"`
+	WriteBytesPerSec int64    `json:"write_bytes_per_sec" yaml:"write_bytes_per_sec"`
+}
+
+// SlowDiskConfig is SlowDiskOptions as written in a config file
+type SlowDiskConfig struct {
+	Latency          Duration `json:"latency" yaml:"latency"`
+	Jitter           Duration `json:"jitter" yaml:"jitter"`
+	ReadBytesPerSec  int64    `json:"read_bytes_per_sec" yaml:"read_bytes_per_sec"`
+	WriteBytesPerSec int64    `json:"write_bytes_per_sec" yaml:"write_bytes_per_sec"`
+}
+
+// Duration is a time.Duration written as "500ms" or "2m" in config files
+type Duration time.Duration
+
+func (d Duration) String() string {
+	return time.Duration(d).String()
+}
+
+func (d Duration) MarshalText() ([]byte, error) {
+	return []byte(time.Duration(d).String()), nil
+}
+
+func (d *Duration) UnmarshalText(text []byte) error {
+	v, err := time.ParseDuration(string(text))
+	if err != nil {
+		return err
+	}
+	*d = Duration(v)
+	return nil
+}
+
+// DefaultConfig returns the settings the package starts with
+func DefaultConfig() Config {
+	return Config{
+		StatCacheTTL:      Duration(DefaultStatCacheTTL),
+		AppendIdleTimeout: Duration(DefaultAppendIdleTimeout),
+		RecentErrors:      DefaultRecentErrors,
+		Log:               LogConfig{Level: "debug", Output: "debug-file", Format: "text"},
+	}
+}
+
+// LoadConfig reads a JSON or YAML config file (by extension; anything but .yaml and .yml is
+// JSON), validates it and applies it to the package. Unknown keys are an error, so typos
+// don't silently fall back to defaults.
+func LoadConfig(path string) (Config, error) {
+	path = cleanPath(path)
+
+	data, err := ReadFile(path)
+	if err != nil {
+		return Config{}, err
+	}
+
+	format := "json"
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		format = "yaml"
+	}
+
+	cfg, err := ParseConfig(data, format)
+	if err != nil {
+		err = fmt.Errorf("%s: %w", path, err)
+		errorPrinter("LoadConfig: "+err.Error(), path)
+		return Config{}, err
+	}
+	if err := ApplyConfig(cfg); err != nil {
+		errorPrinter("LoadConfig: "+err.Error(), path)
+		return Config{}, err
+	}
+
+	return cfg, nil
+}
+
+// ParseConfig decodes and validates a "json" or "yaml" config without applying it
+func ParseConfig(data []byte, format string) (Config, error) {
+	cfg := DefaultConfig()
+
+	switch format {
+	case "json":
+		dec := json.NewDecoder(bytes.NewReader(data))
+		dec.DisallowUnknownFields()
+		if err := dec.Decode(&cfg); err != nil {
+			return Config{}, fmt.Errorf("config: %w", err)
+		}
+	case "yaml":
+		dec := yaml.NewDecoder(bytes.NewReader(data))
+		dec.KnownFields(true)
+		if err := dec.Decode(&cfg); err != nil && err != io.EOF {
+			return Config{}, fmt.Errorf("config: %w", err)
+		}
+	default:
+		return Config{}, fmt.Errorf("config: unknown format %q", format)
+	}
+
+	if err := cfg.Validate(); err != nil {
+		return Config{}, err
+	}
+	return cfg, nil
+}
+
+// Validate reports every invalid setting in c
+func (c Config) Validate() error {
+	var errs []error
+	invalid := func(format string, args ...any) {
+		errs = append(errs, fmt.Errorf("config: "+format, args...))
+	}
+
+	if c.StatCacheTTL < 0 {
+		invalid("stat_cache_ttl must not be negative")
+	}
+	if c.AppendIdleTimeout < 0 {
+		invalid("append_idle_timeout must not be negative")
+	}
+	if c.FDBudget < 0 {
+		invalid("fd_budget must not be negative")
+	}
+	if c.RecentErrors < 0 {
+		invalid("recent_errors must not be negative")
+	}
+
+	switch c.UnicodeNormalization {
+	case FormNone, FormNFC, FormNFD:
+	default:
+		invalid("unicode_normalization must be nfc or nfd, not %q", c.UnicodeNormalization)
+	}
+
+	if _, err := parseLevel(c.Log.Level); err != nil {
+		invalid("log.level: %v", err)
+	}
+	switch c.Log.Format {
+	case "", "text", "json":
+	default:
+		invalid("log.format must be text or json, not %q", c.Log.Format)
+	}
+
+	for prefix, p := range c.Profiles {
+		if prefix == "" {
+			invalid("profiles: empty prefix")
+		}
+		if p.Retries < 0 || p.StatCacheTTL < 0 || p.ReadBytesPerSec < 0 || p.WriteBytesPerSec < 0 {
+			invalid("profiles.%s: values must not be negative", prefix)
+		}
+	}
+
+	s := c.SlowDisk
+	if s.Latency < 0 || s.Jitter < 0 || s.ReadBytesPerSec < 0 || s.WriteBytesPerSec < 0 {
+		invalid("slow_disk: values must not be negative")
+	}
+
+	for scheme, b := range c.Backends {
+		if !validScheme(scheme) {
+			invalid("backends: invalid scheme %q", scheme)
+		}
+		for _, p := range b.problems() {
+			invalid("backends.%s: %s", scheme, p)
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+var configLog = struct {
+	mu   sync.Mutex
+	cfg  LogConfig // Applied by the last ApplyConfig
+	file *os.File  // Log file opened by the last ApplyConfig
+}{cfg: DefaultConfig().Log}
+
+// configMu serializes ApplyConfig and Reconfigure
+var configMu sync.Mutex
+
+// ApplyConfig validates c and makes it the package configuration. Profiles not in c are
+// removed, and so are backends registered by an earlier ApplyConfig that c no longer has.
+func ApplyConfig(c Config) error {
+	configMu.Lock()
+	defer configMu.Unlock()
+
+	return applyConfig(c)
+}
+
+func applyConfig(c Config) error {
+	if err := c.Validate(); err != nil {
+		return err
+	}
+	if err := applyBackends(c.Backends); err != nil {
+		return err
+	}
+	if err := applyLogConfig(c.Log); err != nil {
+		return err
+	}
+
+	SetStatCacheTTL(time.Duration(c.StatCacheTTL))
+	SetAppendIdleTimeout(time.Duration(c.AppendIdleTimeout))
+	SetFDBudget(c.FDBudget)
+	SetSpecialFileGuard(c.SpecialFileGuard)
+	SetStripColonPrefix(c.StripColonPrefix)
+	SetCaseInsensitive(c.CaseInsensitive)
+	if err := SetUnicodeNormalization(c.UnicodeNormalization); err != nil {
+		return err
+	}
+	SetRecentErrors(c.RecentErrors)
+	SetReportLegacyCalls(c.ReportLegacyCalls)
+	SetSlowDisk(SlowDiskOptions{
+		Latency:          time.Duration(c.SlowDisk.Latency),
+		Jitter:           time.Duration(c.SlowDisk.Jitter),
+		ReadBytesPerSec:  c.SlowDisk.ReadBytesPerSec,
+		WriteBytesPerSec: c.SlowDisk.WriteBytesPerSec,
+	})
+
+	for _, prefix := range profilePrefixes() {
+		if _, ok := c.Profiles[prefix]; !ok {
+			RemoveProfile(prefix)
+		}
+	}
+	for prefix, p := range c.Profiles {
+		SetProfile(prefix, Profile{
+			Retries:          p.Retries,
+			Durable:          p.Durable,
+			StatCacheTTL:     time.Duration(p.StatCacheTTL),
+			NoStatCache:      p.NoStatCache,
+			ReadBytesPerSec:  p.ReadBytesPerSec,
+			WriteBytesPerSec: p.WriteBytesPerSec,
+		})
+	}
+
+	return nil
+}
+
+// applyLogConfig switches the logger, closing a log file opened by an earlier config. An
+// unchanged LogConfig leaves the logger alone, including one set with SetLogger.
+func applyLogConfig(c LogConfig) error {
+	c = c.normalized()
+	level, _ := parseLevel(c.Level)
+
+	configLog.mu.Lock()
+	unchanged := c == configLog.cfg
+	configLog.mu.Unlock()
+	if unchanged {
+		return nil
+	}
+
+	var logger Logger
+	var file *os.File
+	switch c.Output {
+	case "", "debug-file":
+		logger = DebugFileLogger{}
+	case "none":
+	case "stderr":
+		logger = NewSlogLogger(slog.New(logHandler(os.Stderr, c.Format)))
+	case "stdout":
+		logger = NewSlogLogger(slog.New(logHandler(os.Stdout, c.Format)))
+	default:
+		var err error
+		file, err = os.OpenFile(c.Output, os.O_APPEND|os.O_WRONLY|os.O_CREATE, 0644)
+		if err != nil {
+			return fmt.Errorf("config: log.output: %w", err)
+		}
+		logger = NewSlogLogger(slog.New(logHandler(file, c.Format)))
+	}
+
+	configLog.mu.Lock()
+	old := configLog.file
+	configLog.cfg = c
+	configLog.file = file
+	configLog.mu.Unlock()
+
+	SetLogger(logger)
+	SetLogLevel(level)
+
+	// The log file stays open across Close so logging keeps working
+	if old != nil {
+		old.Close()
+	}
+	return nil
+}
+
+// normalized fills in the defaults for empty fields
+func (c LogConfig) normalized() LogConfig {
+	if c.Level == "" {
+		c.Level = "debug"
+	}
+	c.Level = strings.ToLower(c.Level)
+	if c.Output == "" {
+		c.Output = "debug-file"
+	}
+	if c.Format == "" {
+		c.Format = "text"
+	}
+	return c
+}
+
+func logHandler(w io.Writer, format string) slog.Handler {
+	opts := &slog.HandlerOptions{Level: slog.LevelDebug}
+	if format == "json" {
+		return slog.NewJSONHandler(w, opts)
+	}
+	return slog.NewTextHandler(w, opts)
+}
+
+// parseLevel maps a level name to a Level
+func parseLevel(name string) (Level, error) {
+	switch strings.ToLower(name) {
+	case "debug", "":
+		return LevelDebug, nil
+	case "info":
+		return LevelInfo, nil
+	case "warn", "warning":
+		return LevelWarn, nil
+	case "error":
+		return LevelError, nil
+	}
+	return 0, fmt.Errorf("unknown level %q", name)
+}
@@ -0,0 +1,171 @@
+package GMSFS
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v3"
+)
+
+// ConfigWatcher keeps an application config loaded by WatchConfig in step with its file
+// until it is closed
+type ConfigWatcher struct {
+	name     string
+	v        reflect.Value // The pointer given to WatchConfig
+	defaults reflect.Value // *v before the first load
+	onChange func()
+	watcher  *Watcher
+	last     []byte // Content last decoded, so touching the file reloads nothing
+
+	mu   sync.RWMutex // Held for writing while v is replaced
+	done chan struct{}
+	once sync.Once
+}
+
+// WatchConfig decodes the config file name into v, a non-nil pointer, and then reloads it
+// whenever the file changes, calling onChange (which may be nil) after each reload. The format
+// follows the extension: .yaml and .yml are YAML, .toml is TOML and anything else is JSON.
+//
+// Settings missing from the file keep the values *v had when WatchConfig was called, on
+// reloads too. A reload decodes into a fresh value and replaces *v only when that succeeds,
+// so a file saved half-edited or broken is logged and leaves the previous config in place.
+// Code reading *v while reloads may happen does so between RLock and RUnlock:
+//
+//	var cfg AppConfig
+//	cw, err := GMSFS.WatchConfig("app.yaml", &cfg, func() { log.Print("config reloaded") })
+//	...
+//	cw.RLock()
+//	port := cfg.Port
+//	cw.RUnlock()
+//
+// The directory of name is watched rather than the file, so editors that save by writing a
+// new file and renaming it over the old one are followed too.
+func WatchConfig(name string, v any, onChange func()) (*ConfigWatcher, error) {
+	name = cleanPath(name)
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Pointer || rv.IsNil() {
+		return nil, fmt.Errorf("WatchConfig: v must be a non-nil pointer, not %T", v)
+	}
+
+	defaults := reflect.New(rv.Elem().Type())
+	defaults.Elem().Set(rv.Elem())
+
+	data, err := ReadFile(name)
+	if err != nil {
+		return nil, err
+	}
+	if err := decodeConfigFile(name, data, v); err != nil {
+		err = fmt.Errorf("%s: %w", name, err)
+		errorPrinter("WatchConfig: "+err.Error(), name)
+		return nil, err
+	}
+
+	w, err := Watch(filepath.Dir(name), WatchOptions{Debounce: 100 * time.Millisecond})
+	if err != nil {
+		errorPrinter("WatchConfig: "+err.Error(), name)
+		return nil, err
+	}
+
+	cw := &ConfigWatcher{name: name, v: rv, defaults: defaults, onChange: onChange, watcher: w, last: data, done: make(chan struct{})}
+	registerCloser(cw)
+	go cw.run()
+	return cw, nil
+}
+
+// RLock holds off reloads until RUnlock, so the config can be read consistently
+func (cw *ConfigWatcher) RLock() {
+	cw.mu.RLock()
+}
+
+// RUnlock lets reloads go on
+func (cw *ConfigWatcher) RUnlock() {
+	cw.mu.RUnlock()
+}
+
+// Name returns the watched config file
+func (cw *ConfigWatcher) Name() string {
+	return cw.name
+}
+
+func (cw *ConfigWatcher) run() {
+	defer close(cw.done)
+
+	for {
+		select {
+		case ev, ok := <-cw.watcher.Events:
+			if !ok {
+				return
+			}
+			if cleanPath(ev.Path) == cw.name && !ev.Op.Has(EventRemove) {
+				cw.reload()
+			}
+		case err, ok := <-cw.watcher.Errors:
+			if ok {
+				warnPrinter("WatchConfig: "+err.Error(), cw.name)
+			}
+		}
+	}
+}
+
+// reload decodes the file again and swaps it in when it changed and is valid
+func (cw *ConfigWatcher) reload() {
+	data, err := ReadFile(cw.name)
+	if err != nil {
+		return // Mid-replacement; the rename that completes it brings another event
+	}
+	if bytes.Equal(data, cw.last) {
+		return
+	}
+
+	fresh := reflect.New(cw.v.Elem().Type())
+	fresh.Elem().Set(cw.defaults.Elem())
+	if err := decodeConfigFile(cw.name, data, fresh.Interface()); err != nil {
+		errorPrinter("WatchConfig (keeping the previous config): "+cw.name+": "+err.Error(), cw.name)
+		return
+	}
+
+	cw.mu.Lock()
+	cw.v.Elem().Set(fresh.Elem())
+	cw.mu.Unlock()
+	cw.last = data
+
+	infoPrinter("WatchConfig: reloaded", cw.name)
+	if cw.onChange != nil {
+		cw.onChange()
+	}
+}
+
+// Close stops watching; the config keeps its last loaded value
+func (cw *ConfigWatcher) Close() error {
+	var err error
+	cw.once.Do(func() {
+		unregisterCloser(cw)
+		err = cw.watcher.Close()
+		<-cw.done
+	})
+	return err
+}
+
+// decodeConfigFile decodes data into v in the format the extension of name selects
+func decodeConfigFile(name string, data []byte, v any) error {
+	switch strings.ToLower(filepath.Ext(name)) {
+	case ".yaml", ".yml":
+		if err := yaml.NewDecoder(bytes.NewReader(data)).Decode(v); err != nil && err != io.EOF {
+			return err
+		}
+		return nil
+	case ".toml":
+		_, err := toml.Decode(string(data), v)
+		return err
+	default:
+		return json.Unmarshal(data, v)
+	}
+}
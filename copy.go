@@ -0,0 +1,895 @@
+package GMSFS
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// CopyOptions controls how directory copies treat an existing destination
+type CopyOptions struct {
+	MergeExisting  bool         // Copy into a destination directory that already exists
+	OverwriteFiles bool         // Replace files that already exist in the destination
+	SkipExisting   bool         // Leave files that already exist in the destination untouched
+	UpdateOnly     bool         // Replace existing files only when the source is newer
+	QuickCheck     bool         // Replace existing files whose size or modification time differ from the source, like rsync; takes precedence over UpdateOnly
+	SkipTransforms bool         // Copy bytes verbatim, ignoring hooks added with RegisterTransform
+	Verify         ChecksumAlgo // Hash source and destination after each plain copy; empty disables
+
+	// ContinueOnError copies everything that can be copied and returns all failures joined
+	// with errors.Join, one *CopyError per path, instead of stopping at the first one
+	ContinueOnError bool
+
+	// Progress is called periodically during the copy and after every file
+	Progress ProgressFunc
+
+	// AllowSpecialFiles copies device files, named pipes and sockets despite SetSpecialFileGuard
+	AllowSpecialFiles bool
+
+	PreserveTimes bool // Give copied files and directories the source modification time
+	PreserveOwner bool // Give copies the source uid and gid; Unix only, and only when running as root
+
+	// Workers copies up to this many files at once; 0 or 1 copies one file at a time.
+	// Open files stay within the budget set with SetFDBudget.
+	Workers int
+
+	// AdaptiveWorkers tunes the number of files copied at once between 1 and Workers (or
+	// DefaultAdaptiveWorkers) from the observed latency and errors, so one setting suits both
+	// local disks and slow network shares
+	AdaptiveWorkers bool
+
+	Symlinks SymlinkPolicy // What directory copies do with symbolic links; skipped by default
+
+	// PreserveHardLinks links files that are hard links to each other in the source to a
+	// single copy instead of duplicating their content
+	PreserveHardLinks bool
+
+	// Events receives a JobEvent for every directory, file and failure of a directory copy.
+	// Sends block, so the channel must be drained while the copy runs; it is not closed.
+	Events chan<- JobEvent
+
+	// Retries is how often a file copy that failed for lack of resources or a timeout is tried
+	// again, with a growing pause in between
+	Retries int
+
+	// DestinationLock makes directory copies and syncs hold a lease on the destination, so
+	// two of them writing to the same tree, from different processes or hosts, queue or fail
+	// fast instead of interfering. The lease is a ".<name>.gmsfs-lock" file next to the
+	// destination, renewed while the copy runs; a copy that loses it stops with ErrLeaseLost.
+	DestinationLock  DestinationLockMode
+	DestinationLease time.Duration // How long a lease lasts without renewal; 0 means DefaultDestinationLease
+}
+
+// SymlinkPolicy decides how directory copies treat symbolic links
+type SymlinkPolicy int
+
+const (
+	SymlinkSkip          SymlinkPolicy = iota // Leave links out of the copy
+	SymlinkCopyAsLink                         // Recreate the link with the same target
+	SymlinkFollowAndCopy                      // Copy what the link points to; a link back into its own ancestry is an error
+)
+
+// CopyError is a failure to copy one path of a directory copy made with ContinueOnError
+type CopyError struct {
+	Src string
+	Dst string
+	Err error
+}
+
+func (e *CopyError) Error() string {
+	return opError("copy", e.Src, e.Dst, e.Err).Error()
+}
+
+func (e *CopyError) Unwrap() error {
+	return e.Err
+}
+
+// As lets errors.As find the failure as an *OpError, like the errors of other operations
+func (e *CopyError) As(target any) bool {
+	t, ok := target.(**OpError)
+	return ok && errors.As(opError("copy", e.Src, e.Dst, e.Err), t)
+}
+
+// CopyDirWithOptions copies the tree at src to dst. Without options it behaves like CopyDir
+// and fails when dst exists; MergeExisting allows copying into an existing tree, where the
+// file options decide what happens to files present on both sides.
+func CopyDirWithOptions(src string, dst string, opts CopyOptions) error {
+	_, err := CopyDirWithStats(src, dst, opts)
+	return err
+}
+
+// CopyDirWithStats is CopyDirWithOptions returning a summary of the work done, which is
+// filled in as far as the copy got when it fails
+func CopyDirWithStats(src string, dst string, opts CopyOptions) (Stats, error) {
+	return CopyDirContext(context.Background(), src, dst, opts)
+}
+
+// CopyDirContext is CopyDirWithStats tagging its log entries and job with the correlation ID
+// of ctx. Cancelling ctx stops the copy before the next file and returns ctx.Err().
+func CopyDirContext(ctx context.Context, src string, dst string, opts CopyOptions) (_ Stats, err error) {
+	defer func() { err = opError("copy", src, dst, err) }()
+	if err := ctx.Err(); err != nil {
+		return Stats{}, err
+	}
+	src = cleanPath(src)
+	dst = cleanPath(dst)
+	if err := requireLocal("copy", src, dst); err != nil {
+		errorPrinterCtx(ctx, "CopyDirWithStats: "+err.Error(), src)
+		return Stats{}, err
+	}
+
+	if opts.SkipExisting && (opts.OverwriteFiles || opts.UpdateOnly || opts.QuickCheck) {
+		return Stats{}, fmt.Errorf("conflicting copy options: SkipExisting with OverwriteFiles, UpdateOnly or QuickCheck")
+	}
+	if opts.Verify != "" {
+		if _, err := newChecksumHash(opts.Verify); err != nil {
+			return Stats{}, err
+		}
+	}
+
+	lease, ctx, err := acquireDestLease(ctx, "CopyDir", src, dst, opts.DestinationLock, opts.DestinationLease)
+	if err != nil {
+		errorPrinterCtx(ctx, "CopyDir (lease): "+err.Error(), dst)
+		return Stats{}, err
+	}
+	defer lease.release()
+
+	si, err := os.Stat(src) // Directly use os.Stat
+	if err != nil {
+		errorPrinterCtx(ctx, "CopyDir (os.Stat): "+err.Error(), src)
+		return Stats{}, err
+	}
+	if !si.IsDir() {
+		return Stats{}, fmt.Errorf("source is not a directory")
+	}
+
+	if di, err := os.Stat(dst); !os.IsNotExist(err) {
+		if !opts.MergeExisting {
+			errorPrinterCtx(ctx, "CopyDir: File already exist", dst)
+			return Stats{}, fmt.Errorf("destination already exists")
+		}
+		if err != nil {
+			errorPrinterCtx(ctx, "CopyDir (os.Stat): "+err.Error(), dst)
+			return Stats{}, err
+		}
+		if !di.IsDir() {
+			return Stats{}, fmt.Errorf("destination is not a directory")
+		}
+	}
+
+	c := newDirCopy(ctx, "CopyDir", src, dst, opts)
+	if opts.Progress != nil {
+		total := treeSize(src, opts.Symlinks == SymlinkFollowAndCopy)
+		c.progress = newCopyProgress(opts.Progress, total)
+		c.job.setTotal(total)
+	}
+	c.tree(src, dst, si, nil)
+	err = c.finish()
+	return c.stats, err
+}
+
+// SyncDir brings dst up to date with src: missing files are copied and existing ones are
+// replaced when the source is newer. Files that only exist in dst are left alone.
+func SyncDir(src string, dst string) error {
+	_, err := SyncDirWithStats(src, dst)
+	return err
+}
+
+// SyncDirWithStats is SyncDir returning a summary; files that were already up to date count as skipped
+func SyncDirWithStats(src string, dst string) (Stats, error) {
+	return SyncDirWithOptions(src, dst, CopyOptions{})
+}
+
+// SyncDirWithOptions is SyncDirWithStats with copy options; dst may always exist. With
+// PreserveTimes the copies carry the source modification times exactly, so make and other
+// tools comparing timestamps only see the files that really changed, and existing files are
+// compared by size and time (QuickCheck) rather than replaced only when the source is newer,
+// which also picks up sources restored to an older version. Setting OverwriteFiles,
+// SkipExisting, UpdateOnly or QuickCheck chooses the comparison explicitly.
+func SyncDirWithOptions(src string, dst string, opts CopyOptions) (Stats, error) {
+	opts.MergeExisting = true
+	if !opts.OverwriteFiles && !opts.SkipExisting && !opts.UpdateOnly && !opts.QuickCheck {
+		if opts.PreserveTimes {
+			opts.QuickCheck = true
+		} else {
+			opts.UpdateOnly = true
+		}
+	}
+	return CopyDirWithStats(src, dst, opts)
+}
+
+// CopyDirFilesGlobWithOptions copies the files in src whose names match fileMatch into dst,
+// creating dst when needed. Files present on both sides follow SkipExisting or UpdateOnly and
+// are replaced otherwise, and registered transforms apply unless SkipTransforms is set.
+func CopyDirFilesGlobWithOptions(src string, dst string, fileMatch string, opts CopyOptions) (err error) {
+	defer func() { err = opError("copy", src, dst, err) }()
+	src = cleanPath(src)
+	dst = cleanPath(dst)
+	if err := requireLocal("copy", src, dst); err != nil {
+		errorPrinter("CopyDirFilesGlobWithOptions: "+err.Error(), src)
+		return err
+	}
+
+	opts, err = fileCopyOptions(opts)
+	if err != nil {
+		return err
+	}
+
+	// Check if source is a directory
+	srcInfo, err := Stat(src) // Use cached Stat
+	if err != nil {
+		errorPrinter("CopyDirFilesGlob: "+err.Error(), src)
+		return fmt.Errorf("source is not a directory or does not exist")
+	}
+	if !srcInfo.IsDir {
+		return fmt.Errorf("source is not a directory or does not exist")
+	}
+
+	// Create destination directory if it doesn't exist
+	if !FileExists(dst) {
+		err = MkdirAll(dst, srcInfo.Mode) // Use cached MkdirAll
+		if err != nil {
+			errorPrinter("CopyDirFilesGlob (MkdirAll): "+err.Error(), dst)
+			return err
+		}
+	}
+
+	matches, err := Glob(src + "/" + fileMatch)
+	if err != nil {
+		errorPrinter("CopyDirFilesGlob (Glob): "+err.Error(), src+"/"+fileMatch)
+		return err
+	}
+
+	c := newDirCopy(context.Background(), "CopyDirFilesGlob", src, dst, opts)
+	if opts.Progress != nil {
+		var total int64
+		for _, item := range matches {
+			if info, err := os.Stat(item); err == nil && info.Mode().IsRegular() {
+				total += info.Size()
+			}
+		}
+		c.progress = newCopyProgress(opts.Progress, total)
+		c.job.setTotal(total)
+	}
+
+	for _, item := range matches {
+		if c.stopped() {
+			break
+		}
+		c.file(item, filepath.Join(dst, filepath.Base(item)))
+	}
+	return c.finish()
+}
+
+// dirCopy is a directory copy in progress. Files are copied by up to Workers goroutines while
+// the tree is walked; directory attributes are applied once every file is in place.
+type dirCopy struct {
+	ctx      context.Context
+	name     string // Function name for logging
+	opts     CopyOptions
+	progress *copyProgress
+
+	workers *workerLimit // nil copies on the walking goroutine
+	wg      sync.WaitGroup
+
+	mu    sync.Mutex
+	errs  []error
+	first error
+	stop  bool
+	dirs  [][2]string // Copied directories in walk order, as source and destination
+	stats Stats
+	start time.Time
+	job   *job // Listed by Jobs until finish
+
+	// With PreserveHardLinks: the first copy of every linked source file, and the links to
+	// make to it once all copies are done
+	linked map[fileID]string
+	links  []pendingLink
+}
+
+type pendingLink struct {
+	src, target, dst string
+}
+
+func newDirCopy(ctx context.Context, name string, src string, dst string, opts CopyOptions) *dirCopy {
+	c := &dirCopy{name: name, opts: opts, start: time.Now()}
+	c.ctx, c.job = startJob(ctx, name, src, dst)
+	switch {
+	case opts.AdaptiveWorkers && opts.Workers > 0:
+		c.workers = newWorkerLimit(opts.Workers, true)
+	case opts.AdaptiveWorkers:
+		c.workers = newWorkerLimit(DefaultAdaptiveWorkers, true)
+	case opts.Workers > 1:
+		c.workers = newWorkerLimit(opts.Workers, false)
+	}
+	return c
+}
+
+// tree copies the directory src to dst; ancestors are the directories above it, to detect
+// symlink cycles
+func (c *dirCopy) tree(src string, dst string, info os.FileInfo, ancestors []os.FileInfo) {
+	for _, a := range ancestors {
+		if os.SameFile(a, info) {
+			err := fmt.Errorf("symlink cycle: %s leads back to a parent directory", src)
+			errorPrinterCtx(c.ctx, c.name+": "+err.Error(), src)
+			c.fail(src, dst, err)
+			return
+		}
+	}
+	ancestors = append(ancestors, info)
+
+	err := os.MkdirAll(dst, info.Mode())
+	if err != nil {
+		errorPrinterCtx(c.ctx, c.name+" (os.MkdirAll): "+err.Error(), dst)
+		c.fail(src, dst, err)
+		return
+	}
+	invalidateStat(dst)
+
+	c.mu.Lock()
+	c.dirs = append(c.dirs, [2]string{src, dst})
+	c.stats.Dirs++
+	c.mu.Unlock()
+	c.emit(JobEvent{Type: JobDirEntered, Path: src, Dst: dst})
+
+	simulateOp()
+	entries, err := os.ReadDir(src) // Directly use os.ReadDir
+	if err != nil {
+		errorPrinterCtx(c.ctx, c.name+" (os.ReadDir): "+err.Error(), src)
+		c.fail(src, dst, err)
+		return
+	}
+
+	for _, entry := range entries {
+		if c.stopped() {
+			return
+		}
+
+		srcPath := filepath.Join(src, entry.Name())
+		dstPath := filepath.Join(dst, entry.Name())
+
+		switch {
+		case entry.IsDir():
+			info, err := entry.Info()
+			if err != nil {
+				c.fail(srcPath, dstPath, err)
+				continue
+			}
+			c.tree(srcPath, dstPath, info, ancestors)
+
+		case entry.Type()&os.ModeSymlink != 0:
+			c.symlink(srcPath, dstPath, ancestors)
+
+		default:
+			if c.opts.PreserveHardLinks && c.hardLink(srcPath, dstPath, entry) {
+				continue
+			}
+			c.file(srcPath, dstPath)
+		}
+	}
+}
+
+// hardLink remembers the copy of a file with several links and reports whether src is another
+// link to a file already copied, which is then linked in finish
+func (c *dirCopy) hardLink(src string, dst string, entry os.DirEntry) bool {
+	info, err := entry.Info()
+	if err != nil {
+		return false
+	}
+	id, links, err := fileIdentity(src, info)
+	if err != nil || links < 2 {
+		return false
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.linked == nil {
+		c.linked = map[fileID]string{}
+	}
+	if target, ok := c.linked[id]; ok {
+		c.links = append(c.links, pendingLink{src: src, target: target, dst: dst})
+		return true
+	}
+	c.linked[id] = dst
+	return false
+}
+
+// symlink handles a link found in the tree according to the symlink policy
+func (c *dirCopy) symlink(src string, dst string, ancestors []os.FileInfo) {
+	switch c.opts.Symlinks {
+	case SymlinkCopyAsLink:
+		created, err := copySymlink(src, dst, c.opts)
+		if err != nil {
+			errorPrinterCtx(c.ctx, c.name+" (copySymlink): "+err.Error(), src)
+			c.fail(src, dst, err)
+			return
+		}
+		c.count(src, dst, created, 0)
+
+	case SymlinkFollowAndCopy:
+		info, err := os.Stat(src)
+		if err != nil {
+			errorPrinterCtx(c.ctx, c.name+" (os.Stat): "+err.Error(), src)
+			c.fail(src, dst, err)
+			return
+		}
+		if info.IsDir() {
+			c.tree(src, dst, info, ancestors)
+		} else {
+			c.file(src, dst)
+		}
+	}
+}
+
+// file copies one file, on a worker when the copy is parallel
+func (c *dirCopy) file(src string, dst string) {
+	if c.workers == nil {
+		c.copyFile(src, dst)
+		return
+	}
+
+	c.workers.acquire()
+	c.wg.Add(1)
+	go func() {
+		defer c.wg.Done()
+
+		if c.stopped() {
+			c.workers.release(0, 0, nil, false)
+			return
+		}
+		start := time.Now()
+		copied, size, err := c.copyFile(src, dst)
+		c.workers.release(time.Since(start), size, err, copied)
+	}()
+}
+
+func (c *dirCopy) copyFile(src string, dst string) (bool, int64, error) {
+	c.emit(JobEvent{Type: JobFileStarted, Path: src, Dst: dst})
+
+	// Without an explicit retry count the destination's profile decides
+	retries := c.opts.Retries
+	if retries == 0 {
+		if p := profileFor(dst); p != nil {
+			retries = p.Retries
+		}
+	}
+
+	copied, err := copyDirFile(c.ctx, src, dst, c.opts, c.progress)
+	for attempt := 1; err != nil && attempt <= retries && congestionError(err); attempt++ {
+		c.emit(JobEvent{Type: JobRetry, Path: src, Dst: dst, Attempt: attempt, Err: err})
+		timer := time.NewTimer(retryDelay(attempt))
+		select {
+		case <-c.ctx.Done():
+			timer.Stop()
+			err = c.ctx.Err()
+		case <-timer.C:
+			copied, err = copyDirFile(c.ctx, src, dst, c.opts, c.progress)
+		}
+	}
+	if err != nil {
+		errorPrinterCtx(c.ctx, c.name+" (CopyFile-1): "+err.Error(), src)
+		errorPrinterCtx(c.ctx, c.name+" (CopyFile-2): "+err.Error(), dst)
+		c.fail(src, dst, err)
+		return copied, 0, err
+	}
+
+	size := fileSizeOf(src)
+	c.count(src, dst, copied, size)
+	return copied, size, nil
+}
+
+// count adds a copied or skipped file to the stats
+func (c *dirCopy) count(src string, dst string, copied bool, size int64) {
+	c.mu.Lock()
+	if copied {
+		c.stats.Files++
+		c.stats.Bytes += size
+		c.job.add(1, size)
+	} else {
+		c.stats.Skipped++
+	}
+	c.mu.Unlock()
+
+	c.emit(JobEvent{Type: JobFileDone, Path: src, Dst: dst, Bytes: size, Skipped: !copied})
+}
+
+// emit sends ev to the Events channel, if there is one
+func (c *dirCopy) emit(ev JobEvent) {
+	if c.opts.Events == nil {
+		return
+	}
+	ev.Time = time.Now()
+	c.opts.Events <- ev
+}
+
+// retryDelay is the pause before a retry: 100ms doubling per attempt, at most 5s
+func retryDelay(attempt int) time.Duration {
+	if attempt > 6 {
+		return 5 * time.Second
+	}
+	return min(100*time.Millisecond<<(attempt-1), 5*time.Second)
+}
+
+// fail records an error; without ContinueOnError the first one ends the copy
+func (c *dirCopy) fail(src string, dst string, err error) {
+	c.emit(JobEvent{Type: JobError, Path: src, Dst: dst, Err: err})
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.stats.Errors++
+	if c.opts.ContinueOnError {
+		c.errs = append(c.errs, &CopyError{Src: src, Dst: dst, Err: err})
+		return
+	}
+	if c.first == nil {
+		c.first = opError("copy", src, dst, err)
+	}
+	c.stop = true
+}
+
+func (c *dirCopy) stopped() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.stop || c.ctx.Err() != nil
+}
+
+// finish waits for the workers, applies directory attributes deepest first, as copying entries
+// changes them, and returns the outcome
+func (c *dirCopy) finish() error {
+	c.wg.Wait()
+	defer c.job.done()
+
+	for _, l := range c.links {
+		if c.stopped() {
+			break
+		}
+		linked, err := linkCopy(l.src, l.target, l.dst, c.opts)
+		if err != nil {
+			errorPrinterCtx(c.ctx, c.name+" (linkCopy): "+err.Error(), l.dst)
+			c.fail(l.src, l.dst, err)
+			continue
+		}
+		c.count(l.src, l.dst, linked, 0)
+		c.progress.done(l.src, fileSizeOf(l.src))
+	}
+
+	if !c.stopped() {
+		for i := len(c.dirs) - 1; i >= 0; i-- {
+			src, dst := c.dirs[i][0], c.dirs[i][1]
+			if err := preserveAttrs(src, dst, c.opts); err != nil {
+				errorPrinterCtx(c.ctx, c.name+" (preserveAttrs): "+err.Error(), dst)
+				c.fail(src, dst, err)
+				if c.stopped() {
+					break
+				}
+			}
+		}
+	}
+
+	c.mu.Lock()
+	c.stats.Duration = time.Since(c.start)
+	c.mu.Unlock()
+
+	if c.ctx.Err() != nil {
+		return context.Cause(c.ctx)
+	}
+	if c.opts.ContinueOnError {
+		return errors.Join(c.errs...)
+	}
+	return c.first
+}
+
+// CopyFileWithOptions copies one file like CopyFile. An existing dst follows SkipExisting or
+// UpdateOnly and is replaced otherwise; Verify, Progress and registered transforms apply as
+// they do for CopyDirWithOptions.
+func CopyFileWithOptions(src string, dst string, opts CopyOptions) (err error) {
+	defer func() { err = opError("copy", src, dst, err) }()
+	src = cleanPath(src)
+	dst = cleanPath(dst)
+	if err := requireLocal("copy", src, dst); err != nil {
+		errorPrinter("CopyFileWithOptions: "+err.Error(), src)
+		return err
+	}
+
+	opts, err = fileCopyOptions(opts)
+	if err != nil {
+		return err
+	}
+
+	var progress *copyProgress
+	if opts.Progress != nil {
+		si, err := os.Stat(src)
+		if err != nil {
+			errorPrinter("CopyFileWithOptions (os.Stat): "+err.Error(), src)
+			return err
+		}
+		progress = newCopyProgress(opts.Progress, si.Size())
+	}
+
+	copied, err := copyDirFile(context.Background(), src, dst, opts, progress)
+	if err != nil {
+		errorPrinter("CopyFileWithOptions: "+err.Error(), src)
+	} else if copied {
+		copyTags(src, dst)
+	}
+	return err
+}
+
+// fileCopyOptions validates opts for copies into a destination that may exist, where files
+// present on both sides are replaced unless SkipExisting or UpdateOnly says otherwise
+func fileCopyOptions(opts CopyOptions) (CopyOptions, error) {
+	if opts.SkipExisting && (opts.OverwriteFiles || opts.UpdateOnly || opts.QuickCheck) {
+		return opts, fmt.Errorf("conflicting copy options: SkipExisting with OverwriteFiles, UpdateOnly or QuickCheck")
+	}
+	if opts.Verify != "" {
+		if _, err := newChecksumHash(opts.Verify); err != nil {
+			return opts, err
+		}
+	}
+
+	opts.MergeExisting = true
+	if !opts.SkipExisting && !opts.UpdateOnly && !opts.QuickCheck {
+		opts.OverwriteFiles = true
+	}
+	return opts, nil
+}
+
+// copyDirFile copies one file of a tree, applying the existing-file options, and reports
+// whether it was copied rather than skipped
+func copyDirFile(ctx context.Context, src string, dst string, opts CopyOptions, progress *copyProgress) (copied bool, err error) {
+	if !opts.AllowSpecialFiles {
+		if err := guardSpecialFile("copy", src); err != nil {
+			return false, err
+		}
+	}
+
+	if opts.MergeExisting {
+		if di, err := os.Stat(dst); err == nil {
+			switch {
+			case opts.SkipExisting:
+				progress.done(src, fileSizeOf(src))
+				return false, nil
+			case opts.QuickCheck:
+				si, err := os.Stat(src)
+				if err != nil {
+					return false, err
+				}
+				if sameSizeAndTime(si, di) {
+					progress.done(src, si.Size())
+					return false, nil
+				}
+			case opts.UpdateOnly:
+				si, err := os.Stat(src)
+				if err != nil {
+					return false, err
+				}
+				if !si.ModTime().After(di.ModTime()) {
+					progress.done(src, si.Size())
+					return false, nil
+				}
+			case opts.OverwriteFiles:
+			default:
+				return false, fmt.Errorf("destination file already exists")
+			}
+		}
+	}
+
+	delta, err := quotaCopying(src, dst)
+	if err != nil {
+		return false, err
+	}
+	defer func() {
+		if err != nil {
+			quotaRelease(dst, delta)
+		}
+	}()
+
+	if !opts.SkipTransforms {
+		if fns := transformsFor(src); len(fns) > 0 {
+			// Transformed content differs from the source by design, so it is not verified
+			err := transformFile(src, dst, fns, progress)
+			if err == nil {
+				err = preserveAttrs(src, dst, opts)
+			}
+			if err == nil {
+				progress.done(src, 0)
+			}
+			return true, err
+		}
+	}
+
+	// The attributes from before the copy, so a source changing meanwhile keeps a time older
+	// than its content and is copied again by the next sync
+	var si os.FileInfo
+	if opts.PreserveTimes || opts.PreserveOwner {
+		var err error
+		if si, err = os.Stat(src); err != nil {
+			return false, err
+		}
+	}
+
+	if err := copyFile(ctx, src, dst, progress); err != nil {
+		return true, err
+	}
+	if opts.Verify != "" {
+		if err := verifyCopy(ctx, src, dst, opts.Verify); err != nil {
+			return true, err
+		}
+	}
+	if err := preserveAttrsFrom(si, dst, opts); err != nil {
+		return true, err
+	}
+	progress.done(src, 0)
+	return true, nil
+}
+
+// copySymlink recreates the link src at dst, applying the existing-file options to dst, and
+// reports whether the link was created
+func copySymlink(src string, dst string, opts CopyOptions) (bool, error) {
+	target, err := os.Readlink(src)
+	if err != nil {
+		return false, err
+	}
+
+	// A link counts as a file without bytes, as DirSize counts it
+	usage := DirUsage{Files: 1}
+	delta, err := quotaPlacing(dst, usage)
+	if err != nil {
+		return false, err
+	}
+	if ok, err := clearDestination(src, dst, opts); !ok {
+		quotaRelease(dst, delta)
+		return false, err
+	}
+	if err := os.Symlink(target, dst); err != nil {
+		// What was in the way is gone either way
+		quotaRelease(dst, usage)
+		return false, err
+	}
+	invalidateStat(dst)
+
+	if opts.PreserveOwner {
+		si, err := os.Lstat(src)
+		if err != nil {
+			return true, err
+		}
+		if _, err := chownLike(dst, si); err != nil {
+			return true, err
+		}
+	}
+	return true, nil
+}
+
+// linkCopy makes dst a hard link to target, the copy of another link to the same file as src,
+// and reports whether the link was created
+func linkCopy(src string, target string, dst string, opts CopyOptions) (bool, error) {
+	// Counted like a copy, as DirSize counts every link
+	usage, _ := quotaStat(LocalBackend{}, target)
+	delta, err := quotaPlacing(dst, usage)
+	if err != nil {
+		return false, err
+	}
+	if ok, err := clearDestination(src, dst, opts); !ok {
+		quotaRelease(dst, delta)
+		return false, err
+	}
+	if err := os.Link(target, dst); err != nil {
+		quotaRelease(dst, usage)
+		return false, err
+	}
+	invalidateStat(target)
+	invalidateStat(dst)
+	return true, nil
+}
+
+// clearDestination applies the existing-file options to dst before a link is created there,
+// removing what is in the way, and reports whether to go ahead
+func clearDestination(src string, dst string, opts CopyOptions) (bool, error) {
+	di, err := os.Lstat(dst)
+	if os.IsNotExist(err) {
+		return true, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	switch {
+	case !opts.MergeExisting:
+		return false, fmt.Errorf("destination file already exists")
+	case opts.SkipExisting:
+		return false, nil
+	case opts.QuickCheck:
+		si, err := os.Lstat(src)
+		if err != nil {
+			return false, err
+		}
+		// Link times are not preserved, so links compare by target
+		if si.Mode()&os.ModeSymlink != 0 && di.Mode()&os.ModeSymlink != 0 {
+			target, err := os.Readlink(src)
+			if err != nil {
+				return false, err
+			}
+			if current, err := os.Readlink(dst); err == nil && current == target {
+				return false, nil
+			}
+		} else if si.Mode().Type() == di.Mode().Type() && sameSizeAndTime(si, di) {
+			return false, nil
+		}
+	case opts.UpdateOnly:
+		si, err := os.Lstat(src)
+		if err != nil {
+			return false, err
+		}
+		if !si.ModTime().After(di.ModTime()) {
+			return false, nil
+		}
+	case !opts.OverwriteFiles:
+		return false, fmt.Errorf("destination file already exists")
+	}
+
+	if di.IsDir() {
+		return false, fmt.Errorf("destination is a directory")
+	}
+	if err := os.Remove(dst); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// preserveAttrs gives dst the owner and modification time of src as far as opts ask for
+func preserveAttrs(src string, dst string, opts CopyOptions) error {
+	if !opts.PreserveTimes && !opts.PreserveOwner {
+		return nil
+	}
+
+	si, err := os.Stat(src)
+	if err != nil {
+		return err
+	}
+	return preserveAttrsFrom(si, dst, opts)
+}
+
+// preserveAttrsFrom is preserveAttrs with the source attributes already at hand
+func preserveAttrsFrom(si os.FileInfo, dst string, opts CopyOptions) error {
+	if !opts.PreserveTimes && !opts.PreserveOwner {
+		return nil
+	}
+	defer invalidateStat(dst)
+
+	if opts.PreserveOwner {
+		changed, err := chownLike(dst, si)
+		if err != nil {
+			return err
+		}
+		// Changing the owner clears setuid and setgid
+		if changed && si.Mode()&(os.ModeSetuid|os.ModeSetgid) != 0 {
+			if err := os.Chmod(dst, si.Mode()); err != nil {
+				return err
+			}
+		}
+	}
+
+	if opts.PreserveTimes {
+		// A zero access time is left unchanged
+		return os.Chtimes(dst, time.Time{}, si.ModTime())
+	}
+	return nil
+}
+
+// sameSizeAndTime is the quick check of QuickCheck: equal sizes and modification times
+func sameSizeAndTime(a os.FileInfo, b os.FileInfo) bool {
+	return a.Size() == b.Size() && a.ModTime().Equal(b.ModTime())
+}
+
+func fileSizeOf(name string) int64 {
+	if info, err := os.Stat(name); err == nil {
+		return info.Size()
+	}
+	return 0
+}
@@ -0,0 +1,85 @@
+package GMSFS
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestCopyDirRetryStopsOnCancel(t *testing.T) {
+	src := t.TempDir()
+	writeTestTree(t, src, map[string]string{"a.busy": "a"})
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	attempts := 0
+	RegisterTransform(".busy", func(string, io.Reader, io.Writer) error {
+		attempts++
+		cancel()
+		return syscall.EBUSY
+	})
+	defer UnregisterTransforms(".busy")
+
+	start := time.Now()
+	_, err := CopyDirContext(ctx, src, filepath.Join(t.TempDir(), "dst"), CopyOptions{Retries: 5})
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("CopyDirContext = %v, want context.Canceled", err)
+	}
+	if attempts != 1 || time.Since(start) > time.Second {
+		t.Errorf("%d attempts in %s after the context was cancelled", attempts, time.Since(start))
+	}
+}
+
+func TestCopyDirQuotaLinks(t *testing.T) {
+	requireSymlinks(t)
+	src := t.TempDir()
+	writeTestTree(t, src, map[string]string{"a": "0123456789"})
+	if err := os.Link(filepath.Join(src, "a"), filepath.Join(src, "b")); err != nil {
+		t.Skip(err)
+	}
+	if err := os.Symlink("a", filepath.Join(src, "l")); err != nil {
+		t.Fatal(err)
+	}
+
+	dir := t.TempDir()
+	setTestQuota(t, dir, Quota{MaxBytes: 100})
+	opts := CopyOptions{Symlinks: SymlinkCopyAsLink, PreserveHardLinks: true}
+	if err := CopyDirWithOptions(src, filepath.Join(dir, "dst"), opts); err != nil {
+		t.Fatal(err)
+	}
+
+	// Links count as DirSize counts them: the hard link like a copy, the symlink as a file
+	want := DirUsage{Bytes: 20, Files: 3}
+	checkUsage(t, dir, want)
+	if err := RecountQuota(dir); err != nil {
+		t.Fatal(err)
+	}
+	checkUsage(t, dir, want)
+
+	// Copying the links over themselves replaces them without counting them twice
+	opts.MergeExisting, opts.OverwriteFiles = true, true
+	if err := CopyDirWithOptions(src, filepath.Join(dir, "dst"), opts); err != nil {
+		t.Fatal(err)
+	}
+	checkUsage(t, dir, want)
+
+	// A link that doesn't fit is refused
+	full := t.TempDir()
+	setTestQuota(t, full, Quota{MaxBytes: 100, MaxFiles: 2})
+	if err := CopyDirWithOptions(src, filepath.Join(full, "dst"), opts); !errors.Is(err, ErrQuotaExceeded) {
+		t.Errorf("copy over MaxFiles = %v, want ErrQuotaExceeded", err)
+	}
+	got, _ := Usage(full)
+	if err := RecountQuota(full); err != nil {
+		t.Fatal(err)
+	}
+	checkUsage(t, full, got)
+	if got.Files != 2 {
+		t.Errorf("usage after the refused link = %+v", got)
+	}
+}
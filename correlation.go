@@ -0,0 +1,42 @@
+package GMSFS
+
+import (
+	"context"
+	"sync/atomic"
+)
+
+type correlationKey struct{}
+
+var correlationFunc atomic.Pointer[func(context.Context) string]
+
+// WithCorrelationID returns a copy of ctx carrying id. Log entries, recent errors and jobs
+// of operations given the context, e.g. ReadFileContext, carry the id, so file activity can
+// be traced back to the request that caused it.
+func WithCorrelationID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, correlationKey{}, id)
+}
+
+// CorrelationID returns the id set on ctx with WithCorrelationID, or else the one found by
+// the function passed to SetCorrelationIDFunc
+func CorrelationID(ctx context.Context) string {
+	if ctx == nil {
+		return ""
+	}
+	if id, ok := ctx.Value(correlationKey{}).(string); ok {
+		return id
+	}
+	if fn := correlationFunc.Load(); fn != nil {
+		return (*fn)(ctx)
+	}
+	return ""
+}
+
+// SetCorrelationIDFunc makes CorrelationID fall back to fn, to pick up the request ids an
+// existing middleware or tracer already stores in contexts. nil removes it.
+func SetCorrelationIDFunc(fn func(ctx context.Context) string) {
+	if fn == nil {
+		correlationFunc.Store(nil)
+		return
+	}
+	correlationFunc.Store(&fn)
+}
@@ -0,0 +1,272 @@
+package GMSFS
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// credentialRefresh is how long before they expire credentials are fetched again
+const credentialRefresh = 5 * time.Minute
+
+// credentialRecheck is how often credentials that do not expire are read again from a file
+const credentialRecheck = time.Minute
+
+// Credentials are the keys requests to a remote backend are signed with
+type Credentials struct {
+	AccessKeyID     string
+	SecretAccessKey string
+	SessionToken    string
+	Expires         time.Time // Zero when they do not expire
+}
+
+// CredentialsProvider supplies Credentials. Backends ask again shortly before the ones they
+// have expire, and when the remote rejects them as expired.
+type CredentialsProvider interface {
+	Retrieve(ctx context.Context) (Credentials, error)
+}
+
+// CredentialsFunc turns a function into a CredentialsProvider
+type CredentialsFunc func(ctx context.Context) (Credentials, error)
+
+func (f CredentialsFunc) Retrieve(ctx context.Context) (Credentials, error) {
+	return f(ctx)
+}
+
+// StaticCredentials always supplies c
+func StaticCredentials(c Credentials) CredentialsProvider {
+	return CredentialsFunc(func(context.Context) (Credentials, error) { return c, nil })
+}
+
+// EnvCredentials reads AWS_ACCESS_KEY_ID, AWS_SECRET_ACCESS_KEY and AWS_SESSION_TOKEN
+func EnvCredentials() CredentialsProvider {
+	return CredentialsFunc(func(context.Context) (Credentials, error) {
+		return Credentials{
+			AccessKeyID:     os.Getenv("AWS_ACCESS_KEY_ID"),
+			SecretAccessKey: os.Getenv("AWS_SECRET_ACCESS_KEY"),
+			SessionToken:    os.Getenv("AWS_SESSION_TOKEN"),
+		}, nil
+	})
+}
+
+// FileCredentials reads profile from an AWS shared credentials file. An empty file stands
+// for AWS_SHARED_CREDENTIALS_FILE, then ~/.aws/credentials, and an empty profile for
+// AWS_PROFILE, then "default". The file is read again every minute, so rotated keys are
+// picked up.
+func FileCredentials(file string, profile string) CredentialsProvider {
+	return CredentialsFunc(func(context.Context) (Credentials, error) {
+		name, section := file, profile
+		if name == "" {
+			name = os.Getenv("AWS_SHARED_CREDENTIALS_FILE")
+		}
+		if name == "" {
+			home, err := os.UserHomeDir()
+			if err != nil {
+				return Credentials{}, err
+			}
+			name = filepath.Join(home, ".aws", "credentials")
+		}
+		if section == "" {
+			section = os.Getenv("AWS_PROFILE")
+		}
+		if section == "" {
+			section = "default"
+		}
+
+		c, err := readCredentialsFile(name, section)
+		if err != nil {
+			return Credentials{}, err
+		}
+		// Keys from a file do not expire, but this makes the cache read it again
+		c.Expires = time.Now().Add(credentialRefresh + credentialRecheck)
+		return c, nil
+	})
+}
+
+// readCredentialsFile returns the keys of section in an INI credentials file
+func readCredentialsFile(name string, section string) (Credentials, error) {
+	data, err := os.ReadFile(name)
+	if err != nil {
+		return Credentials{}, err
+	}
+
+	var c Credentials
+	found := false
+	current := ""
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		switch {
+		case line == "" || line[0] == '#' || line[0] == ';':
+		case line[0] == '[' && line[len(line)-1] == ']':
+			current = strings.TrimSpace(line[1 : len(line)-1])
+			found = found || current == section
+		case current == section:
+			key, value, _ := strings.Cut(line, "=")
+			switch strings.ToLower(strings.TrimSpace(key)) {
+			case "aws_access_key_id":
+				c.AccessKeyID = strings.TrimSpace(value)
+			case "aws_secret_access_key":
+				c.SecretAccessKey = strings.TrimSpace(value)
+			case "aws_session_token":
+				c.SessionToken = strings.TrimSpace(value)
+			}
+		}
+	}
+	if !found {
+		return Credentials{}, fmt.Errorf("%s: no profile %q", name, section)
+	}
+	if c.AccessKeyID == "" || c.SecretAccessKey == "" {
+		return Credentials{}, fmt.Errorf("%s: profile %q has no aws_access_key_id or aws_secret_access_key", name, section)
+	}
+	return c, nil
+}
+
+// IAMCredentials fetches the temporary credentials of the role the process runs under: from
+// the ECS container endpoint when AWS_CONTAINER_CREDENTIALS_RELATIVE_URI or _FULL_URI is set,
+// and from the EC2 instance metadata service (IMDSv2) otherwise.
+// AWS_EC2_METADATA_SERVICE_ENDPOINT overrides the metadata service address.
+func IAMCredentials() CredentialsProvider {
+	client := &http.Client{Timeout: 5 * time.Second}
+	return CredentialsFunc(func(ctx context.Context) (Credentials, error) {
+		if rel := os.Getenv("AWS_CONTAINER_CREDENTIALS_RELATIVE_URI"); rel != "" {
+			return containerCredentials(ctx, client, "http://169.254.170.2"+rel)
+		}
+		if full := os.Getenv("AWS_CONTAINER_CREDENTIALS_FULL_URI"); full != "" {
+			return containerCredentials(ctx, client, full)
+		}
+		return instanceCredentials(ctx, client)
+	})
+}
+
+// iamCredentials is the JSON both credential endpoints answer with
+type iamCredentials struct {
+	AccessKeyID     string    `json:"AccessKeyId"`
+	SecretAccessKey string    `json:"SecretAccessKey"`
+	Token           string    `json:"Token"`
+	Expiration      time.Time `json:"Expiration"`
+}
+
+func containerCredentials(ctx context.Context, client *http.Client, url string) (Credentials, error) {
+	header := http.Header{}
+	if token := os.Getenv("AWS_CONTAINER_AUTHORIZATION_TOKEN"); token != "" {
+		header.Set("Authorization", token)
+	}
+	data, err := credentialRequest(ctx, client, http.MethodGet, url, header)
+	if err != nil {
+		return Credentials{}, err
+	}
+	return decodeIAMCredentials(data)
+}
+
+func instanceCredentials(ctx context.Context, client *http.Client) (Credentials, error) {
+	base := os.Getenv("AWS_EC2_METADATA_SERVICE_ENDPOINT")
+	if base == "" {
+		base = "http://169.254.169.254"
+	}
+	base = strings.TrimSuffix(base, "/")
+
+	token, err := credentialRequest(ctx, client, http.MethodPut, base+"/latest/api/token",
+		http.Header{"X-Aws-Ec2-Metadata-Token-Ttl-Seconds": {"21600"}})
+	if err != nil {
+		return Credentials{}, err
+	}
+	header := http.Header{"X-Aws-Ec2-Metadata-Token": {string(token)}}
+
+	roles, err := credentialRequest(ctx, client, http.MethodGet, base+"/latest/meta-data/iam/security-credentials/", header)
+	if err != nil {
+		return Credentials{}, err
+	}
+	role, _, _ := strings.Cut(strings.TrimSpace(string(roles)), "\n")
+	if role == "" {
+		return Credentials{}, fmt.Errorf("iam credentials: the instance has no role")
+	}
+
+	data, err := credentialRequest(ctx, client, http.MethodGet, base+"/latest/meta-data/iam/security-credentials/"+role, header)
+	if err != nil {
+		return Credentials{}, err
+	}
+	return decodeIAMCredentials(data)
+}
+
+func credentialRequest(ctx context.Context, client *http.Client, method string, url string, header http.Header) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, method, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("iam credentials: %w", err)
+	}
+	defer resp.Body.Close()
+
+	data, err := io.ReadAll(io.LimitReader(resp.Body, 64<<10))
+	if err != nil {
+		return nil, fmt.Errorf("iam credentials: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("iam credentials: %s: %s", url, resp.Status)
+	}
+	return data, nil
+}
+
+func decodeIAMCredentials(data []byte) (Credentials, error) {
+	var c iamCredentials
+	if err := json.Unmarshal(data, &c); err != nil {
+		return Credentials{}, fmt.Errorf("iam credentials: %w", err)
+	}
+	if c.AccessKeyID == "" || c.SecretAccessKey == "" {
+		return Credentials{}, fmt.Errorf("iam credentials: response has no keys")
+	}
+	return Credentials{AccessKeyID: c.AccessKeyID, SecretAccessKey: c.SecretAccessKey, SessionToken: c.Token, Expires: c.Expiration}, nil
+}
+
+// credentialCache holds the credentials of a backend between refreshes
+type credentialCache struct {
+	provider CredentialsProvider
+
+	mu    sync.Mutex
+	creds Credentials
+	valid bool
+}
+
+// get returns the cached credentials, fetching new ones when there are none or they are
+// about to expire. Credentials that failed to refresh are used until they actually expire.
+func (c *credentialCache) get(ctx context.Context) (Credentials, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.valid && (c.creds.Expires.IsZero() || time.Until(c.creds.Expires) > credentialRefresh) {
+		return c.creds, nil
+	}
+
+	creds, err := c.provider.Retrieve(ctx)
+	if err != nil {
+		if c.valid && time.Now().Before(c.creds.Expires) {
+			warnPrinter("credentials: refresh failed, using the current ones: "+err.Error(), "")
+			return c.creds, nil
+		}
+		return Credentials{}, err
+	}
+	c.creds, c.valid = creds, true
+	return creds, nil
+}
+
+// expire makes the next get fetch new credentials
+func (c *credentialCache) expire() {
+	c.mu.Lock()
+	c.valid = false
+	c.mu.Unlock()
+}
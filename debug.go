@@ -0,0 +1,163 @@
+package GMSFS
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DebugSnapshot is the package state returned by DebugReport. It marshals to JSON for admin
+// endpoints, see DebugHandler.
+type DebugSnapshot struct {
+	Time          time.Time     `json:"time"`
+	OpenFDs       int           `json:"open_fds"`
+	FDBudget      int           `json:"fd_budget"`
+	AppendHandles []string      `json:"append_handles"` // Files held open by the Append pool
+	StatCache     CacheStats    `json:"stat_cache"`
+	CachedFiles   int           `json:"cached_files"` // Entries in CachedFiles
+	Jobs          []JobInfo     `json:"jobs"`
+	Watchers      []WatcherInfo `json:"watchers"`
+	RecentErrors  []ErrorRecord `json:"recent_errors"` // Oldest first
+	LegacyCalls   []LegacyCall  `json:"legacy_calls"`
+	Config        Config        `json:"config"`
+}
+
+// ErrorRecord is a failure logged by the package
+type ErrorRecord struct {
+	Time time.Time `json:"time"`
+	Op   string    `json:"op"` // The function that failed, e.g. "CopyDir (os.MkdirAll)"
+	Path string    `json:"path"`
+	Err  string    `json:"error"`
+
+	CorrelationID string `json:"correlation_id,omitempty"`
+}
+
+// DefaultRecentErrors is how many errors RecentErrors keeps unless changed with SetRecentErrors
+const DefaultRecentErrors = 100
+
+var recentErrors = struct {
+	mu   sync.Mutex
+	size int
+	buf  []ErrorRecord
+	next int // Slot for the next record once buf is full
+}{size: DefaultRecentErrors}
+
+// DebugReport returns a snapshot of open handles, cache use, running jobs, open watchers, the
+// last errors and recorded legacy calls, regardless of the log level and logger in use
+func DebugReport() DebugSnapshot {
+	handles := appendHandles.Keys()
+	sort.Strings(handles)
+
+	return DebugSnapshot{
+		Time:          time.Now(),
+		OpenFDs:       OpenFDs(),
+		FDBudget:      FDBudget(),
+		AppendHandles: handles,
+		StatCache:     StatCacheStats(),
+		CachedFiles:   CachedFiles.Count(),
+		Jobs:          Jobs(),
+		Watchers:      activeWatchers(),
+		RecentErrors:  RecentErrors(),
+		LegacyCalls:   LegacyCalls(),
+		Config:        CurrentConfig(),
+	}
+}
+
+// DebugHandler serves DebugReport as JSON. It exposes paths and configuration, so mount it
+// on an admin listener or behind authentication.
+func DebugHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			w.Header().Set("Allow", "GET, HEAD")
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		body, err := json.MarshalIndent(DebugReport(), "", "  ")
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Cache-Control", "no-store")
+		if r.Method == http.MethodGet {
+			w.Write(append(body, '\n'))
+		}
+	})
+}
+
+// RecentErrors returns the last errors the package logged, oldest first. They are kept
+// whatever the log level and logger, so failures can be checked without reading log files.
+func RecentErrors() []ErrorRecord {
+	recentErrors.mu.Lock()
+	defer recentErrors.mu.Unlock()
+
+	return recentErrorList()
+}
+
+// SetRecentErrors changes how many errors RecentErrors keeps, dropping the oldest ones when
+// shrinking. 0 stops keeping errors.
+func SetRecentErrors(n int) {
+	if n < 0 {
+		n = 0
+	}
+
+	recentErrors.mu.Lock()
+	defer recentErrors.mu.Unlock()
+
+	list := recentErrorList()
+	if len(list) > n {
+		list = list[len(list)-n:]
+	}
+	recentErrors.size = n
+	recentErrors.buf = list
+	recentErrors.next = 0
+}
+
+// RecentErrorsSize returns how many errors RecentErrors keeps
+func RecentErrorsSize() int {
+	recentErrors.mu.Lock()
+	defer recentErrors.mu.Unlock()
+
+	return recentErrors.size
+}
+
+// ClearRecentErrors forgets the kept errors
+func ClearRecentErrors() {
+	recentErrors.mu.Lock()
+	defer recentErrors.mu.Unlock()
+
+	recentErrors.buf = nil
+	recentErrors.next = 0
+}
+
+// recordError keeps a logged error for RecentErrors. Messages look like "Op: error".
+func recordError(log string, object string, correlationID string) {
+	rec := ErrorRecord{Time: time.Now(), Path: object, Err: log, CorrelationID: correlationID}
+	if op, msg, ok := strings.Cut(log, ": "); ok {
+		rec.Op, rec.Err = op, msg
+	}
+
+	recentErrors.mu.Lock()
+	defer recentErrors.mu.Unlock()
+
+	switch {
+	case recentErrors.size == 0:
+	case len(recentErrors.buf) < recentErrors.size:
+		recentErrors.buf = append(recentErrors.buf, rec)
+	default:
+		recentErrors.buf[recentErrors.next] = rec
+		recentErrors.next = (recentErrors.next + 1) % recentErrors.size
+	}
+}
+
+// recentErrorList copies the kept errors, oldest first. Callers hold recentErrors.mu.
+func recentErrorList() []ErrorRecord {
+	list := make([]ErrorRecord, 0, len(recentErrors.buf))
+	list = append(list, recentErrors.buf[recentErrors.next:]...)
+	return append(list, recentErrors.buf[:recentErrors.next]...)
+}
@@ -0,0 +1,376 @@
+package GMSFS
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// derivation is a registered derived-file generator
+type derivation struct {
+	pattern string // Glob on source base names
+	target  string // Slash-separated path template, relative to the source directory
+	fn      TransformFunc
+}
+
+var (
+	derivationsMu sync.Mutex
+	derivations   atomic.Pointer[[]derivation]
+)
+
+// RegisterDerived makes fn the generator of a derived file, such as a thumbnail, for every
+// source whose base name matches pattern (a glob like "*.{jpg,png}"). target names the derived
+// file in a subdirectory of the source's directory, with <name> standing for the source name
+// without its extension, <base> for the whole name and <ext> for the extension without its
+// dot, e.g. ".thumbs/<name>.webp". fn reads the source from r and writes the derived content
+// to w.
+//
+// UpdateDerived brings the derived files of a tree up to date and WatchDerived keeps them so.
+// The directory holding derived files belongs to them: files in it that look like derived
+// files but have no source are removed.
+func RegisterDerived(pattern string, target string, fn TransformFunc) error {
+	patterns, err := expandBraces(pattern)
+	if err != nil {
+		return fmt.Errorf("RegisterDerived: %w", err)
+	}
+	for _, p := range patterns {
+		if _, err := matchName(p, "", false); err != nil {
+			return fmt.Errorf("RegisterDerived: %w", err)
+		}
+	}
+	target = filepath.ToSlash(target)
+	if !strings.Contains(target, "<name>") && !strings.Contains(target, "<base>") {
+		return fmt.Errorf("RegisterDerived: target %q does not contain <name> or <base>", target)
+	}
+	if pathDir(target) == "." || strings.HasPrefix(target, "/") || strings.HasPrefix(target, "../") || strings.Contains(target, "/../") {
+		return fmt.Errorf("RegisterDerived: target %q must be in a subdirectory of the source directory", target)
+	}
+
+	derivationsMu.Lock()
+	defer derivationsMu.Unlock()
+
+	var list []derivation
+	if cur := derivations.Load(); cur != nil {
+		for _, d := range *cur {
+			if d.pattern != pattern || d.target != target {
+				list = append(list, d)
+			}
+		}
+	}
+	list = append(list, derivation{pattern: pattern, target: target, fn: fn})
+	derivations.Store(&list)
+	return nil
+}
+
+// UnregisterDerived removes the generators registered for pattern. Derived files already
+// written stay in place.
+func UnregisterDerived(pattern string) {
+	derivationsMu.Lock()
+	defer derivationsMu.Unlock()
+
+	cur := derivations.Load()
+	if cur == nil {
+		return
+	}
+	var list []derivation
+	for _, d := range *cur {
+		if d.pattern != pattern {
+			list = append(list, d)
+		}
+	}
+	if len(list) == 0 {
+		derivations.Store(nil)
+	} else {
+		derivations.Store(&list)
+	}
+}
+
+// derivationList returns the registered generators
+func derivationList() []derivation {
+	if list := derivations.Load(); list != nil {
+		return *list
+	}
+	return nil
+}
+
+// matches reports whether src is a source of d; RegisterDerived checked the pattern
+func (d derivation) matches(src string) bool {
+	patterns, _ := expandBraces(d.pattern)
+	return matchAny(patterns, filepath.Base(src))
+}
+
+// derivedName returns the derived file of src
+func (d derivation) derivedName(src string) string {
+	base := filepath.Base(src)
+	ext := filepath.Ext(base)
+	name := strings.NewReplacer(
+		"<name>", strings.TrimSuffix(base, ext),
+		"<base>", base,
+		"<ext>", strings.TrimPrefix(ext, "."),
+	).Replace(d.target)
+	return filepath.Join(filepath.Dir(src), filepath.FromSlash(name))
+}
+
+// targetGlob is the glob matching the base names of derived files
+func (d derivation) targetGlob() string {
+	return strings.NewReplacer("<name>", "*", "<base>", "*", "<ext>", "*").Replace(pathBase(d.target))
+}
+
+// isDerived reports whether p is in the place and of the shape of a derived file of d, so
+// derived files are never taken for sources
+func (d derivation) isDerived(p string) bool {
+	if matched, _ := matchName(d.targetGlob(), filepath.Base(p), false); !matched {
+		return false
+	}
+	sub := filepath.FromSlash(pathDir(d.target))
+	dir := filepath.Dir(p)
+	return dir == sub || strings.HasSuffix(dir, string(filepath.Separator)+sub)
+}
+
+// pathDir is the directory of a slash path
+func pathDir(p string) string {
+	if i := strings.LastIndexByte(p, '/'); i >= 0 {
+		return p[:i]
+	}
+	return "."
+}
+
+// derivedFile reports whether p is a derived file of any generator
+func derivedFile(p string) bool {
+	for _, d := range derivationList() {
+		if d.isDerived(p) {
+			return true
+		}
+	}
+	return false
+}
+
+// GenerateDerived writes the derived files of src that are missing or older than src, and
+// returns how many it wrote
+func GenerateDerived(src string) (int, error) {
+	return generateDerived(context.Background(), cleanPath(src))
+}
+
+func generateDerived(ctx context.Context, src string) (int, error) {
+	if derivedFile(src) {
+		return 0, nil
+	}
+	si, err := os.Stat(src)
+	if err != nil {
+		return 0, err
+	}
+	if !si.Mode().IsRegular() {
+		return 0, nil
+	}
+
+	written := 0
+	var errs []error
+	for _, d := range derivationList() {
+		if !d.matches(src) {
+			continue
+		}
+		dst := d.derivedName(src)
+		if di, err := os.Stat(dst); err == nil && !di.ModTime().Before(si.ModTime()) {
+			continue
+		}
+
+		if err := derive(ctx, d, src, dst); err != nil {
+			errorPrinterCtx(ctx, "GenerateDerived: "+err.Error(), src)
+			errs = append(errs, err)
+			continue
+		}
+		written++
+	}
+	return written, errors.Join(errs...)
+}
+
+// derive runs the generator of d on src as a job and atomically writes dst
+func derive(ctx context.Context, d derivation, src string, dst string) error {
+	ctx, j := startJob(ctx, "Derive", src, dst)
+	defer j.done()
+
+	if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+		return err
+	}
+
+	acquireFDs(1)
+	defer releaseFDs(1)
+	f, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	err = writeAtomic(dst, 0644, func(w io.Writer) error {
+		if err := checkpoint(ctx); err != nil {
+			return err
+		}
+		return d.fn(src, f, w)
+	})
+	if err == nil {
+		j.add(1, 0)
+	}
+	return err
+}
+
+// removeDerived deletes the derived files of a source that is gone
+func removeDerived(src string) int {
+	removed := 0
+	for _, d := range derivationList() {
+		if !d.matches(src) {
+			continue
+		}
+		dst := d.derivedName(src)
+		if err := os.Remove(dst); err == nil {
+			invalidateStat(dst)
+			removed++
+		} else if !os.IsNotExist(err) {
+			warnPrinter("Derived (remove): "+err.Error(), dst)
+		}
+	}
+	return removed
+}
+
+// DerivedStats is what UpdateDerived did
+type DerivedStats struct {
+	Generated int // Derived files written
+	Removed   int // Derived files whose source was gone
+}
+
+// UpdateDerived walks root, writing the derived files that are missing or older than their
+// source and removing the ones whose source is gone. It runs as a job, see Jobs.
+func UpdateDerived(root string) (DerivedStats, error) {
+	return UpdateDerivedContext(context.Background(), root)
+}
+
+// UpdateDerivedContext is UpdateDerived stopping when ctx ends
+func UpdateDerivedContext(ctx context.Context, root string) (DerivedStats, error) {
+	root = cleanPath(root)
+	if err := requireLocal("derive", root); err != nil {
+		errorPrinter("UpdateDerived: "+err.Error(), root)
+		return DerivedStats{}, err
+	}
+
+	ctx, j := startJob(ctx, "UpdateDerived", root, "")
+	defer j.done()
+
+	var stats DerivedStats
+	var errs []error
+	expected := map[string]bool{}
+	err := Walk(root, func(p string, info FileInfo) error {
+		if err := checkpoint(ctx); err != nil {
+			return err
+		}
+		if info.IsDir || derivedFile(p) {
+			return nil
+		}
+		for _, d := range derivationList() {
+			if d.matches(p) {
+				expected[d.derivedName(p)] = true
+			}
+		}
+		n, err := generateDerived(ctx, p)
+		stats.Generated += n
+		if err != nil {
+			errs = append(errs, err)
+		}
+		return nil
+	})
+	if err != nil {
+		errorPrinter("UpdateDerived: "+err.Error(), root)
+		return stats, err
+	}
+
+	// Orphans: derived-looking files in derived directories that no source produced
+	err = Walk(root, func(p string, info FileInfo) error {
+		if info.IsDir || expected[p] || !derivedFile(p) {
+			return nil
+		}
+		if err := os.Remove(p); err != nil {
+			errs = append(errs, err)
+			return nil
+		}
+		invalidateStat(p)
+		stats.Removed++
+		return nil
+	})
+	if err != nil {
+		errs = append(errs, err)
+	}
+	return stats, errors.Join(errs...)
+}
+
+// DerivedWatcher keeps the derived files of a tree up to date until it is closed
+type DerivedWatcher struct {
+	root    string
+	watcher *Watcher
+	cancel  context.CancelFunc
+	done    chan struct{}
+	once    sync.Once
+}
+
+// WatchDerived runs UpdateDerived on root and then follows changes below it, regenerating
+// the derived files of sources that are written and removing those of sources that are
+// deleted or renamed away
+func WatchDerived(root string) (*DerivedWatcher, error) {
+	root = cleanPath(root)
+	w, err := Watch(root, WatchOptions{Recursive: true, Debounce: 200 * time.Millisecond})
+	if err != nil {
+		errorPrinter("WatchDerived: "+err.Error(), root)
+		return nil, err
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	dw := &DerivedWatcher{root: root, watcher: w, cancel: cancel, done: make(chan struct{})}
+	if _, err := UpdateDerivedContext(ctx, root); err != nil {
+		warnPrinter("WatchDerived: "+err.Error(), root)
+	}
+
+	registerCloser(dw)
+	go dw.run(ctx)
+	return dw, nil
+}
+
+func (dw *DerivedWatcher) run(ctx context.Context) {
+	defer close(dw.done)
+
+	for {
+		select {
+		case ev, ok := <-dw.watcher.Events:
+			if !ok {
+				return
+			}
+			if derivedFile(ev.Path) {
+				continue
+			}
+			if _, err := os.Stat(ev.Path); os.IsNotExist(err) {
+				removeDerived(ev.Path)
+				continue
+			}
+			generateDerived(ctx, ev.Path)
+		case err, ok := <-dw.watcher.Errors:
+			if ok {
+				warnPrinter("WatchDerived: "+err.Error(), dw.root)
+			}
+		}
+	}
+}
+
+// Close stops following changes, cancelling a generator that is running
+func (dw *DerivedWatcher) Close() error {
+	var err error
+	dw.once.Do(func() {
+		unregisterCloser(dw)
+		dw.cancel()
+		err = dw.watcher.Close()
+		<-dw.done
+	})
+	return err
+}
@@ -0,0 +1,147 @@
+package GMSFS
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path"
+	"path/filepath"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"syscall"
+)
+
+// DirSizeOptions tunes DirSizeWithOptions
+type DirSizeOptions struct {
+	Exclude []string // Skip files and directories whose relative path or base name matches one of these globs
+	Workers int      // Directories read at once; defaults to GOMAXPROCS
+}
+
+// DirUsage is what DirSize found below a directory
+type DirUsage struct {
+	Bytes int64 // Sum of the sizes of the regular files
+	Files int64 // Entries that are not directories, symlinks and other special files included
+	Dirs  int64 // Subdirectories, not counting the directory itself
+}
+
+// DirSize returns the total size and the number of files and subdirectories below path. Each
+// entry costs one directory read and, for regular files, the size from the entry itself, so
+// it is about half the calls of Stat on every entry. Symlinks are counted but not followed.
+func DirSize(path string) (DirUsage, error) {
+	return DirSizeContext(context.Background(), path, DirSizeOptions{})
+}
+
+// DirSizeWithOptions is DirSize skipping what opts.Exclude matches
+func DirSizeWithOptions(path string, opts DirSizeOptions) (DirUsage, error) {
+	return DirSizeContext(context.Background(), path, opts)
+}
+
+// DirSizeContext is DirSizeWithOptions stopping with ctx.Err() when ctx ends. Directories that
+// cannot be read are left out of the totals and their errors returned, joined, with the totals
+// of the rest.
+func DirSizeContext(ctx context.Context, root string, opts DirSizeOptions) (DirUsage, error) {
+	root = cleanPath(root)
+	for _, pattern := range opts.Exclude {
+		patterns, err := expandBraces(pattern)
+		if err != nil {
+			return DirUsage{}, fmt.Errorf("DirSize: bad pattern %q: %w", pattern, err)
+		}
+		for _, p := range patterns {
+			if _, err := path.Match(p, ""); err != nil {
+				return DirUsage{}, fmt.Errorf("DirSize: bad pattern %q: %w", pattern, err)
+			}
+		}
+	}
+
+	b, p := backendFor(root)
+	info, err := b.Stat(p)
+	if err != nil {
+		errorPrinter("DirSize: "+err.Error(), root)
+		return DirUsage{}, opError("dirsize", root, "", err)
+	}
+	if !info.IsDir() {
+		return DirUsage{}, opError("dirsize", root, "", syscall.ENOTDIR)
+	}
+
+	workers := opts.Workers
+	if workers <= 0 {
+		workers = runtime.GOMAXPROCS(0)
+	}
+	s := &dirSizer{ctx: ctx, exclude: opts.Exclude, slots: make(chan struct{}, workers-1)}
+	s.dir(root, ".")
+	s.wg.Wait()
+
+	usage := DirUsage{Bytes: s.bytes.Load(), Files: s.files.Load(), Dirs: s.dirs.Load()}
+	if err := ctx.Err(); err != nil {
+		return usage, opError("dirsize", root, "", err)
+	}
+	err = errors.Join(s.errs...)
+	if err != nil {
+		errorPrinter("DirSize: "+err.Error(), root)
+	}
+	return usage, err
+}
+
+// dirSizer sums a tree, reading a subdirectory in a new goroutine while a slot is free and in
+// the current one otherwise
+type dirSizer struct {
+	ctx     context.Context
+	exclude []string
+	slots   chan struct{}
+	wg      sync.WaitGroup
+
+	bytes, files, dirs atomic.Int64
+
+	mu   sync.Mutex
+	errs []error
+}
+
+func (s *dirSizer) dir(name string, rel string) {
+	if s.ctx.Err() != nil {
+		return
+	}
+
+	simulateOp()
+	acquireFDs(1)
+	b, p := backendFor(name)
+	entries, err := b.ReadDir(p)
+	releaseFDs(1)
+	if err != nil {
+		s.mu.Lock()
+		s.errs = append(s.errs, opError("readdir", name, "", err))
+		s.mu.Unlock()
+		return
+	}
+
+	for _, entry := range entries {
+		childRel := path.Join(rel, entry.Name())
+		if len(s.exclude) > 0 && archiveMatch(childRel, s.exclude) {
+			continue
+		}
+		child := filepath.Join(name, entry.Name())
+
+		if entry.IsDir() {
+			s.dirs.Add(1)
+			select {
+			case s.slots <- struct{}{}:
+				s.wg.Add(1)
+				go func() {
+					defer s.wg.Done()
+					defer func() { <-s.slots }()
+					s.dir(child, childRel)
+				}()
+			default:
+				s.dir(child, childRel)
+			}
+			continue
+		}
+
+		s.files.Add(1)
+		if entry.Type().IsRegular() {
+			if info, err := entry.Info(); err == nil {
+				s.bytes.Add(info.Size())
+			}
+		}
+	}
+}
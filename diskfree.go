@@ -0,0 +1,37 @@
+package GMSFS
+
+// DiskSpace is the size and free space of the volume holding a path
+type DiskSpace struct {
+	Total     int64 // Size of the volume
+	Free      int64 // Free bytes, including those reserved for the superuser
+	Available int64 // Free bytes this process may use; what writes should be checked against
+}
+
+// Used returns the fraction of the volume in use, from 0 to 1, counting space reserved for
+// the superuser as used, like df does
+func (d DiskSpace) Used() float64 {
+	used := d.Total - d.Free
+	if used+d.Available <= 0 {
+		return 0
+	}
+	return float64(used) / float64(used+d.Available)
+}
+
+// DiskFree returns the size and free space of the volume path is on, so a service can refuse
+// writes before the volume fills up. It uses statfs on Unix and GetDiskFreeSpaceEx on Windows,
+// and works on local paths only.
+func DiskFree(path string) (DiskSpace, error) {
+	path = cleanPath(path)
+	if err := requireLocal("diskfree", path); err != nil {
+		errorPrinter("DiskFree: "+err.Error(), path)
+		return DiskSpace{}, opError("diskfree", path, "", err)
+	}
+
+	simulateOp()
+	space, err := diskFree(path)
+	if err != nil {
+		errorPrinter("DiskFree: "+err.Error(), path)
+		return DiskSpace{}, opError("diskfree", path, "", err)
+	}
+	return space, nil
+}
@@ -0,0 +1,14 @@
+//go:build !darwin && !dragonfly && !freebsd && !linux && !windows
+
+package GMSFS
+
+import (
+	"errors"
+	"os"
+)
+
+// DiskFree is not implemented here and fails with errors.ErrUnsupported
+
+func diskFree(path string) (DiskSpace, error) {
+	return DiskSpace{}, &os.PathError{Op: "diskfree", Path: path, Err: errors.ErrUnsupported}
+}
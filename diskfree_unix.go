@@ -0,0 +1,27 @@
+//go:build darwin || dragonfly || freebsd || linux
+
+package GMSFS
+
+import (
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+func diskFree(path string) (DiskSpace, error) {
+	var st unix.Statfs_t
+	if err := unix.Statfs(path, &st); err != nil {
+		return DiskSpace{}, &os.PathError{Op: "statfs", Path: path, Err: err}
+	}
+
+	bsize := int64(st.Bsize)
+	avail := int64(st.Bavail) // Signed on the BSDs, where it goes negative inside the reserve
+	if avail < 0 {
+		avail = 0
+	}
+	return DiskSpace{
+		Total:     int64(st.Blocks) * bsize,
+		Free:      int64(st.Bfree) * bsize,
+		Available: avail * bsize,
+	}, nil
+}
@@ -0,0 +1,32 @@
+//go:build windows
+
+package GMSFS
+
+import (
+	"os"
+	"path/filepath"
+
+	"golang.org/x/sys/windows"
+)
+
+func diskFree(path string) (DiskSpace, error) {
+	// GetDiskFreeSpaceEx wants a directory
+	info, err := os.Stat(longPath(path))
+	if err != nil {
+		return DiskSpace{}, err
+	}
+	dir := path
+	if !info.IsDir() {
+		dir = filepath.Dir(path)
+	}
+
+	p, err := windows.UTF16PtrFromString(longPath(dir))
+	if err != nil {
+		return DiskSpace{}, &os.PathError{Op: "diskfree", Path: path, Err: err}
+	}
+	var avail, total, free uint64
+	if err := windows.GetDiskFreeSpaceEx(p, &avail, &total, &free); err != nil {
+		return DiskSpace{}, &os.PathError{Op: "GetDiskFreeSpaceEx", Path: path, Err: err}
+	}
+	return DiskSpace{Total: int64(total), Free: int64(free), Available: int64(avail)}, nil
+}
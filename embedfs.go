@@ -0,0 +1,126 @@
+package GMSFS
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+)
+
+// ExtractEmbedFS writes every file of src (typically an embed.FS) below dstDir, replacing
+// files that already exist. Files are created 0644 and directories 0755 so the result is a
+// writable tree even though embedded files are read-only.
+func ExtractEmbedFS(src fs.FS, dstDir string) error {
+	return extractFS(src, dstDir, false)
+}
+
+// SyncEmbedFS is ExtractEmbedFS that only writes files whose content differs from what is
+// already on disk, leaving unchanged files (and their timestamps) alone
+func SyncEmbedFS(src fs.FS, dstDir string) error {
+	return extractFS(src, dstDir, true)
+}
+
+func extractFS(src fs.FS, dstDir string, onlyChanged bool) error {
+	dstDir = cleanPath(dstDir)
+
+	err := fs.WalkDir(src, ".", func(name string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(dstDir, filepath.FromSlash(name))
+
+		if entry.IsDir() {
+			return MkdirAll(target, 0755)
+		}
+		if !entry.Type().IsRegular() {
+			return nil
+		}
+
+		if onlyChanged {
+			same, err := sameContent(src, name, target)
+			if err != nil {
+				return err
+			}
+			if same {
+				return nil
+			}
+		}
+
+		return extractFile(src, name, target)
+	})
+	if err != nil {
+		errorPrinter("ExtractEmbedFS: "+err.Error(), dstDir)
+	}
+	return err
+}
+
+func extractFile(src fs.FS, name string, target string) error {
+	in, err := src.Open(name)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := OpenFile(target, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+
+	_, err = io.Copy(out, in)
+	if cerr := out.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// sameContent compares an fs.FS file with a file on disk, size first and then byte by byte
+func sameContent(src fs.FS, name string, target string) (bool, error) {
+	targetInfo, err := Stat(target)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return false, nil
+		}
+		return false, err
+	}
+
+	srcInfo, err := fs.Stat(src, name)
+	if err != nil {
+		return false, err
+	}
+	if targetInfo.IsDir || srcInfo.Size() != targetInfo.Size {
+		return false, nil
+	}
+
+	a, err := src.Open(name)
+	if err != nil {
+		return false, err
+	}
+	defer a.Close()
+
+	b, err := os.Open(target)
+	if err != nil {
+		return false, err
+	}
+	defer b.Close()
+
+	bufA := make([]byte, 32*1024)
+	bufB := make([]byte, 32*1024)
+	for {
+		na, errA := io.ReadFull(a, bufA)
+		nb, errB := io.ReadFull(b, bufB)
+		if !bytes.Equal(bufA[:na], bufB[:nb]) {
+			return false, nil
+		}
+		if errA == io.EOF || errA == io.ErrUnexpectedEOF {
+			return errB == io.EOF || errB == io.ErrUnexpectedEOF, nil
+		}
+		if errA != nil {
+			return false, errA
+		}
+		if errB != nil {
+			return false, nil
+		}
+	}
+}
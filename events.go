@@ -0,0 +1,44 @@
+package GMSFS
+
+import (
+	"time"
+)
+
+// JobEventType is the kind of a JobEvent
+type JobEventType int
+
+const (
+	JobDirEntered  JobEventType = iota // A directory was created (or found) and is being copied
+	JobFileStarted                     // A file copy is starting
+	JobFileDone                        // A file was copied, linked or skipped (Skipped)
+	JobError                           // An operation failed; Err is set
+	JobRetry                           // A failed file copy is tried again; Attempt counts from 1
+)
+
+func (t JobEventType) String() string {
+	switch t {
+	case JobDirEntered:
+		return "DirEntered"
+	case JobFileStarted:
+		return "FileStarted"
+	case JobFileDone:
+		return "FileDone"
+	case JobError:
+		return "Error"
+	case JobRetry:
+		return "Retry"
+	}
+	return "Unknown"
+}
+
+// JobEvent is one step of a long operation, sent to CopyOptions.Events
+type JobEvent struct {
+	Type    JobEventType
+	Time    time.Time
+	Path    string // Source path
+	Dst     string // Destination path
+	Bytes   int64  // Size of a copied file
+	Skipped bool   // FileDone for a file that was left alone
+	Attempt int    // Retry number
+	Err     error
+}
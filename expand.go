@@ -0,0 +1,100 @@
+package GMSFS
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// ReadFileExpanded reads a file and replaces ${VAR} placeholders with environment variables.
+// Only variables matching an entry of allow (exact names or globs such as "APP_*") are
+// expanded; other placeholders are left as they are. See ExpandEnv for the syntax.
+func ReadFileExpanded(name string, allow ...string) ([]byte, error) {
+	content, err := ReadFile(cleanPath(name))
+	if err != nil {
+		return nil, err
+	}
+
+	return ExpandEnv(content, allow...), nil
+}
+
+// ExpandEnv replaces ${VAR} and ${VAR:-default} in data with allowed environment variables.
+// An allowed variable that is unset or empty becomes its default (or nothing), and "$${" is
+// written out as a literal "${".
+func ExpandEnv(data []byte, allow ...string) []byte {
+	if !bytes.Contains(data, []byte("${")) {
+		return data
+	}
+
+	var out bytes.Buffer
+	for {
+		i := bytes.Index(data, []byte("${"))
+		if i < 0 {
+			out.Write(data)
+			return out.Bytes()
+		}
+
+		// "$${" escapes a placeholder
+		if i > 0 && data[i-1] == '$' {
+			out.Write(data[:i-1])
+			out.WriteString("${")
+			data = data[i+2:]
+			continue
+		}
+
+		end := bytes.IndexByte(data[i+2:], '}')
+		if end < 0 {
+			out.Write(data)
+			return out.Bytes()
+		}
+
+		out.Write(data[:i])
+		placeholder := data[i : i+2+end+1]
+		expr := string(data[i+2 : i+2+end])
+		data = data[i+2+end+1:]
+
+		name, def, hasDefault := expr, "", false
+		if j := strings.Index(expr, ":-"); j >= 0 {
+			name, def, hasDefault = expr[:j], expr[j+2:], true
+		}
+
+		if !validEnvName(name) || !envAllowed(name, allow) {
+			out.Write(placeholder)
+			continue
+		}
+
+		value := os.Getenv(name)
+		if value == "" && hasDefault {
+			value = def
+		}
+		out.WriteString(value)
+	}
+}
+
+func envAllowed(name string, allow []string) bool {
+	for _, pattern := range allow {
+		if pattern == name {
+			return true
+		}
+		if matched, _ := filepath.Match(pattern, name); matched {
+			return true
+		}
+	}
+	return false
+}
+
+func validEnvName(name string) bool {
+	if name == "" {
+		return false
+	}
+	for i, c := range name {
+		switch {
+		case c == '_', c >= 'A' && c <= 'Z', c >= 'a' && c <= 'z':
+		case c >= '0' && c <= '9' && i > 0:
+		default:
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,97 @@
+package GMSFS
+
+import (
+	"errors"
+	"sync"
+)
+
+// The package keeps its own open files within a budget derived from the process limit
+// (RLIMIT_NOFILE on Unix), so parallel copies and the append pool queue for a file descriptor
+// instead of failing with EMFILE halfway through a job
+var fdBudget = struct {
+	mu     sync.Mutex
+	cond   *sync.Cond
+	budget int
+	inUse  int
+}{}
+
+// errFDBudget makes the append pool fall back to a short-lived handle
+var errFDBudget = errors.New("file descriptor budget exhausted")
+
+func init() {
+	fdBudget.cond = sync.NewCond(&fdBudget.mu)
+	fdBudget.budget = defaultFDBudget()
+}
+
+// defaultFDBudget leaves a quarter of the process limit to the rest of the program
+func defaultFDBudget() int {
+	return max(fileLimit()*3/4, 16)
+}
+
+// SetFDBudget sets how many files the package may hold open at once; n <= 0 restores the
+// default of three quarters of the process limit. Lowering it does not close open files,
+// new opens just wait until enough are released.
+func SetFDBudget(n int) {
+	if n <= 0 {
+		n = defaultFDBudget()
+	}
+
+	fdBudget.mu.Lock()
+	fdBudget.budget = n
+	fdBudget.mu.Unlock()
+	fdBudget.cond.Broadcast()
+}
+
+// FDBudget returns the number of files the package may hold open at once
+func FDBudget() int {
+	fdBudget.mu.Lock()
+	defer fdBudget.mu.Unlock()
+	return fdBudget.budget
+}
+
+// OpenFDs returns the number of files the package currently holds open for copies, checksums
+// and appends
+func OpenFDs() int {
+	fdBudget.mu.Lock()
+	defer fdBudget.mu.Unlock()
+	return fdBudget.inUse
+}
+
+// acquireFDs waits until n more descriptors fit in the budget
+func acquireFDs(n int) {
+	fdBudget.mu.Lock()
+	defer fdBudget.mu.Unlock()
+
+	// A single request larger than the budget would never be served
+	for fdBudget.inUse > 0 && fdBudget.inUse+n > fdBudget.budget {
+		fdBudget.cond.Wait()
+	}
+	fdBudget.inUse += n
+}
+
+// takeFDs counts n descriptors without waiting, for short-lived handles opened while logging,
+// where waiting could deadlock against a copy that logs while holding its own descriptors
+func takeFDs(n int) {
+	fdBudget.mu.Lock()
+	fdBudget.inUse += n
+	fdBudget.mu.Unlock()
+}
+
+// tryAcquireFDs takes n descriptors if they fit without waiting
+func tryAcquireFDs(n int) bool {
+	fdBudget.mu.Lock()
+	defer fdBudget.mu.Unlock()
+
+	if fdBudget.inUse+n > fdBudget.budget {
+		return false
+	}
+	fdBudget.inUse += n
+	return true
+}
+
+func releaseFDs(n int) {
+	fdBudget.mu.Lock()
+	fdBudget.inUse -= n
+	fdBudget.mu.Unlock()
+	fdBudget.cond.Broadcast()
+}
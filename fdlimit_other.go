@@ -0,0 +1,8 @@
+//go:build !unix
+
+package GMSFS
+
+// fileLimit returns a fixed budget base where handles have no small per-process limit
+func fileLimit() int {
+	return 8192
+}
@@ -0,0 +1,18 @@
+//go:build unix
+
+package GMSFS
+
+import "syscall"
+
+// fileLimit returns the soft limit on open files, which Go raises to the hard limit at startup
+func fileLimit() int {
+	var rl syscall.Rlimit
+	if err := syscall.Getrlimit(syscall.RLIMIT_NOFILE, &rl); err != nil || rl.Cur == 0 {
+		return 1024
+	}
+	// RLIM_INFINITY and other huge values
+	if rl.Cur > 1<<20 {
+		return 1 << 20
+	}
+	return int(rl.Cur)
+}
@@ -0,0 +1,46 @@
+package GMSFS
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"syscall"
+	"time"
+)
+
+// IsFifo reports whether name is a named pipe
+func IsFifo(name string) bool {
+	info, err := os.Stat(cleanPath(name))
+	return err == nil && info.Mode()&os.ModeNamedPipe != 0
+}
+
+// SocketPathInUse reports whether a process accepts connections on the Unix domain socket at
+// name. A missing path and a stale socket file left behind by a dead process both report false,
+// so the path can be removed and listened on again. A path that is not a socket is an error.
+func SocketPathInUse(name string) (bool, error) {
+	name = cleanPath(name)
+
+	info, err := os.Lstat(name)
+	if os.IsNotExist(err) {
+		return false, nil
+	}
+	if err != nil {
+		errorPrinter("SocketPathInUse (os.Lstat): "+err.Error(), name)
+		return false, err
+	}
+	// Windows may report AF_UNIX socket files as irregular reparse points
+	if info.Mode()&os.ModeSocket == 0 && info.Mode()&os.ModeIrregular == 0 {
+		return false, fmt.Errorf("%s is not a socket", name)
+	}
+
+	conn, err := net.DialTimeout("unix", name, time.Second)
+	if err == nil {
+		conn.Close()
+		return true, nil
+	}
+	if errors.Is(err, syscall.ECONNREFUSED) || errors.Is(err, os.ErrNotExist) {
+		return false, nil
+	}
+	return false, err
+}
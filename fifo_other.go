@@ -0,0 +1,17 @@
+//go:build !unix
+
+package GMSFS
+
+import (
+	"errors"
+	"os"
+)
+
+// Mkfifo creates a named pipe. Named pipes on Windows live in their own namespace (\\.\pipe\)
+// rather than the filesystem, so this always fails with errors.ErrUnsupported there.
+func Mkfifo(name string, perm os.FileMode) error {
+	name = cleanPath(name)
+	err := &os.PathError{Op: "mkfifo", Path: name, Err: errors.ErrUnsupported}
+	errorPrinter("Mkfifo: "+err.Error(), name)
+	return err
+}
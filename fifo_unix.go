@@ -0,0 +1,23 @@
+//go:build unix
+
+package GMSFS
+
+import (
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+// Mkfifo creates a named pipe; perm is subject to the umask like Mkdir
+func Mkfifo(name string, perm os.FileMode) error {
+	name = cleanPath(name)
+	err := unix.Mkfifo(name, uint32(perm.Perm()))
+	if err != nil {
+		err = &os.PathError{Op: "mkfifo", Path: name, Err: err}
+		errorPrinter("Mkfifo: "+err.Error(), name)
+		return err
+	}
+	invalidateStat(name)
+
+	return nil
+}
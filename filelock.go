@@ -0,0 +1,199 @@
+package GMSFS
+
+import (
+	"context"
+	"errors"
+	"os"
+	"time"
+)
+
+// ErrLockTimeout is returned by FileLock.LockTimeout when the lock stayed taken
+var ErrLockTimeout = errors.New("timed out waiting for file lock")
+
+// errLocked is returned by tryLockFile when another holder has the lock
+var errLocked = errors.New("file is locked")
+
+// FileLock is an exclusive advisory lock on a local file, taken with flock on Unix and
+// LockFileEx on Windows, so cooperating processes (and goroutines) can serialize work on
+// shared files:
+//
+//	l := GMSFS.NewFileLock("/var/lib/app/state.lock")
+//	if err := l.LockTimeout(5 * time.Second); err != nil {
+//		return err
+//	}
+//	defer l.Unlock()
+//
+// The file is created when missing and left in place afterwards. Advisory means only
+// processes asking for the lock are held off; plain reads and writes go through. The lock
+// goes away with the process, so a crash never leaves it stuck.
+type FileLock struct {
+	name string
+	held chan struct{} // Buffered 1; full while a goroutine of this process holds the lock
+	f    *os.File
+}
+
+// NewFileLock returns an unlocked FileLock on name
+func NewFileLock(name string) *FileLock {
+	return &FileLock{name: cleanPath(name), held: make(chan struct{}, 1)}
+}
+
+// Name returns the locked file
+func (l *FileLock) Name() string {
+	return l.name
+}
+
+// Lock waits until the lock is free and takes it
+func (l *FileLock) Lock() error {
+	return l.LockContext(context.Background())
+}
+
+// LockTimeout is Lock giving up with ErrLockTimeout after d
+func (l *FileLock) LockTimeout(d time.Duration) error {
+	ctx, cancel := context.WithTimeout(context.Background(), d)
+	defer cancel()
+
+	err := l.LockContext(ctx)
+	if errors.Is(err, context.DeadlineExceeded) {
+		err = &os.PathError{Op: "lock", Path: l.name, Err: ErrLockTimeout}
+	}
+	return err
+}
+
+// LockContext is Lock giving up with ctx.Err() when ctx ends first
+func (l *FileLock) LockContext(ctx context.Context) error {
+	select {
+	case l.held <- struct{}{}:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+
+	// Other processes are polled for, as a blocking flock could not be cancelled
+	for delay := time.Millisecond; ; delay = min(delay*2, 100*time.Millisecond) {
+		err := l.tryLock()
+		if err == nil {
+			return nil
+		}
+		if err != errLocked {
+			<-l.held
+			return err
+		}
+
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+			<-l.held
+			return ctx.Err()
+		}
+	}
+}
+
+// TryLock takes the lock if it is free and reports whether it did
+func (l *FileLock) TryLock() (bool, error) {
+	select {
+	case l.held <- struct{}{}:
+	default:
+		return false, nil
+	}
+
+	err := l.tryLock()
+	if err != nil {
+		<-l.held
+		if err == errLocked {
+			return false, nil
+		}
+		return false, err
+	}
+	return true, nil
+}
+
+// tryLock opens the file and locks it without waiting; the caller holds l.held
+func (l *FileLock) tryLock() error {
+	if err := requireLocal("lock", l.name); err != nil {
+		return err
+	}
+
+	acquireFDs(1)
+	f, err := os.OpenFile(l.name, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		releaseFDs(1)
+		errorPrinter("FileLock: "+err.Error(), l.name)
+		return err
+	}
+	if err := tryLockFile(f); err != nil {
+		f.Close()
+		releaseFDs(1)
+		if err != errLocked {
+			err = &os.PathError{Op: "lock", Path: l.name, Err: err}
+			errorPrinter("FileLock: "+err.Error(), l.name)
+		}
+		return err
+	}
+
+	l.f = f
+	return nil
+}
+
+// Unlock releases the lock
+func (l *FileLock) Unlock() error {
+	if l.f == nil {
+		return &os.PathError{Op: "unlock", Path: l.name, Err: os.ErrClosed}
+	}
+
+	f := l.f
+	l.f = nil
+	err := unlockFile(f)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	releaseFDs(1)
+	<-l.held
+
+	if err != nil {
+		err = &os.PathError{Op: "unlock", Path: l.name, Err: err}
+		errorPrinter("FileLock: "+err.Error(), l.name)
+	}
+	return err
+}
+
+// AppendLocked is Append holding an exclusive lock on name for the write, so several
+// processes appending to one log with AppendLocked never interleave parts of their lines.
+// The file is opened for each call; the handle pool of Append is not used.
+func AppendLocked(name string, content []byte) error {
+	name = cleanPath(name)
+	if err := requireLocal("append", name); err != nil {
+		errorPrinter("AppendLocked: "+err.Error(), name)
+		return err
+	}
+
+	simulateOp()
+	simulateWrite(len(content))
+	profile := profileFor(name)
+	profile.throttleWrite(len(content))
+
+	acquireFDs(1)
+	defer releaseFDs(1)
+
+	err := profile.retry(func() error {
+		f, err := os.OpenFile(name, os.O_APPEND|os.O_WRONLY|os.O_CREATE, 0644)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+
+		if err := lockFile(f); err != nil {
+			return &os.PathError{Op: "lock", Path: name, Err: err}
+		}
+		defer unlockFile(f)
+
+		_, err = f.Write(content)
+		if err == nil && profile != nil && profile.Durable {
+			err = f.Sync()
+		}
+		return err
+	})
+	invalidateStat(name)
+	if err != nil {
+		errorPrinter("AppendLocked: "+err.Error(), name)
+	}
+	return err
+}
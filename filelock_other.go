@@ -0,0 +1,23 @@
+//go:build !darwin && !dragonfly && !freebsd && !linux && !netbsd && !openbsd && !windows
+
+package GMSFS
+
+import (
+	"errors"
+	"os"
+)
+
+// File locks are not implemented here; FileLock and AppendLocked fail with
+// errors.ErrUnsupported
+
+func lockFile(f *os.File) error {
+	return errors.ErrUnsupported
+}
+
+func tryLockFile(f *os.File) error {
+	return errors.ErrUnsupported
+}
+
+func unlockFile(f *os.File) error {
+	return errors.ErrUnsupported
+}
@@ -0,0 +1,246 @@
+package GMSFS
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+// DefaultFollowInterval is how often a Follower looks for new content once it reached the end
+const DefaultFollowInterval = 250 * time.Millisecond
+
+// maxFollowLine caps the bytes held for a line without a newline; longer lines are split
+const maxFollowLine = 1 << 20
+
+// Follower delivers the lines appended to a file, like tail -F, until it is closed
+type Follower struct {
+	Lines  <-chan string // Lines without their line ending
+	Errors <-chan error  // Read errors; the follower keeps retrying
+
+	name   string
+	lines  chan string
+	errors chan error
+
+	f       *os.File
+	info    os.FileInfo
+	offset  int64
+	pending []byte
+
+	done chan struct{}
+	once sync.Once
+	wg   sync.WaitGroup
+}
+
+// Follow starts reading the lines of name as they are written, from the start of the file or,
+// with fromEnd, only the ones appended from now on. It keeps going when the file is truncated
+// (reading again from its start) or rotated away, e.g. by a RotatingWriter (finishing the old
+// file and then reading the new one from its start), and it waits for a file that does not
+// exist yet.
+func Follow(name string, fromEnd bool) (*Follower, error) {
+	name = cleanPath(name)
+	if err := requireLocal("follow", name); err != nil {
+		errorPrinter("Follow: "+err.Error(), name)
+		return nil, err
+	}
+
+	fl := &Follower{
+		name:   name,
+		lines:  make(chan string, 64),
+		errors: make(chan error, 1),
+		done:   make(chan struct{}),
+	}
+	fl.Lines = fl.lines
+	fl.Errors = fl.errors
+
+	if err := fl.open(); err != nil && !os.IsNotExist(err) {
+		errorPrinter("Follow: "+err.Error(), name)
+		return nil, err
+	}
+	if fromEnd && fl.f != nil {
+		fl.offset = fl.info.Size()
+	}
+
+	registerCloser(fl)
+	fl.wg.Add(1)
+	go fl.run()
+	return fl, nil
+}
+
+// Name returns the followed file
+func (fl *Follower) Name() string {
+	return fl.name
+}
+
+// Close stops following and closes the channels
+func (fl *Follower) Close() error {
+	fl.once.Do(func() {
+		unregisterCloser(fl)
+		close(fl.done)
+		fl.wg.Wait()
+		fl.closeFile()
+		close(fl.lines)
+		close(fl.errors)
+	})
+	return nil
+}
+
+func (fl *Follower) run() {
+	defer fl.wg.Done()
+
+	for {
+		if !fl.poll() {
+			return
+		}
+		select {
+		case <-fl.done:
+			return
+		case <-time.After(DefaultFollowInterval):
+		}
+	}
+}
+
+// poll reads what was added since the last call and checks for truncation and rotation;
+// it returns false once the follower is closed
+func (fl *Follower) poll() bool {
+	if fl.f == nil {
+		if err := fl.open(); err != nil {
+			if !os.IsNotExist(err) {
+				fl.sendError(err)
+			}
+			return true
+		}
+	}
+
+	if !fl.drain() {
+		return false
+	}
+
+	// At the end of the open file: see whether the name still refers to it
+	current, err := os.Stat(fl.name)
+	switch {
+	case err == nil && os.SameFile(current, fl.info) && current.Size() < fl.offset:
+		// Truncated in place
+		if !fl.flush() {
+			return false
+		}
+		fl.offset = 0
+		fl.info = current
+		return fl.drain()
+
+	case err == nil && !os.SameFile(current, fl.info), os.IsNotExist(err):
+		// Rotated or removed: finish the old file, then pick up the new one on the next poll
+		if !fl.drain() || !fl.flush() {
+			return false
+		}
+		fl.closeFile()
+		fl.offset = 0
+
+	case err != nil:
+		fl.sendError(err)
+	}
+	return true
+}
+
+// drain reads the open file from the offset to its end and sends the complete lines
+func (fl *Follower) drain() bool {
+	buf := make([]byte, 32*1024)
+	for {
+		n, err := fl.f.ReadAt(buf, fl.offset)
+		if n > 0 {
+			fl.offset += int64(n)
+			fl.pending = append(fl.pending, buf[:n]...)
+			if !fl.sendLines() {
+				return false
+			}
+		}
+		if err == io.EOF {
+			return true
+		}
+		if err != nil {
+			fl.sendError(err)
+			return true
+		}
+	}
+}
+
+// sendLines sends the complete lines of pending, and a line grown past maxFollowLine as is
+func (fl *Follower) sendLines() bool {
+	for {
+		i := bytes.IndexByte(fl.pending, '\n')
+		if i < 0 {
+			if len(fl.pending) < maxFollowLine {
+				return true
+			}
+			i = len(fl.pending)
+		}
+
+		line := fl.pending[:i]
+		fl.pending = fl.pending[min(i+1, len(fl.pending)):]
+		if !fl.send(string(bytes.TrimSuffix(line, []byte("\r")))) {
+			return false
+		}
+	}
+}
+
+// flush sends a last line that has no newline, before the file it came from goes away
+func (fl *Follower) flush() bool {
+	if len(fl.pending) == 0 {
+		return true
+	}
+	line := string(bytes.TrimSuffix(fl.pending, []byte("\r")))
+	fl.pending = nil
+	return fl.send(line)
+}
+
+func (fl *Follower) send(line string) bool {
+	select {
+	case fl.lines <- line:
+		return true
+	case <-fl.done:
+		return false
+	}
+}
+
+func (fl *Follower) sendError(err error) {
+	errorPrinter("Follow: "+err.Error(), fl.name)
+	select {
+	case fl.errors <- err:
+	default:
+		// Nobody is reading errors; they are logged above
+	}
+}
+
+func (fl *Follower) open() error {
+	acquireFDs(1)
+	f, err := os.Open(fl.name)
+	if err != nil {
+		releaseFDs(1)
+		return err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		releaseFDs(1)
+		return err
+	}
+	if info.IsDir() {
+		f.Close()
+		releaseFDs(1)
+		return &os.PathError{Op: "follow", Path: fl.name, Err: errors.New("is a directory")}
+	}
+
+	fl.f = f
+	fl.info = info
+	return nil
+}
+
+func (fl *Follower) closeFile() {
+	if fl.f != nil {
+		fl.f.Close()
+		fl.f = nil
+		releaseFDs(1)
+	}
+}
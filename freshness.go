@@ -0,0 +1,126 @@
+package GMSFS
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// FreshnessFunc is told when a monitored file goes stale and when it is updated again.
+// age is -1 while the file does not exist.
+type FreshnessFunc func(path string, age time.Duration, stale bool)
+
+// FreshnessMonitor watches the modification time of one file, see MonitorFreshness
+type FreshnessMonitor struct {
+	path   string
+	maxAge time.Duration
+	fn     FreshnessFunc
+
+	mu    sync.Mutex
+	stale bool
+	age   time.Duration
+
+	done chan struct{}
+	once sync.Once
+	wg   sync.WaitGroup
+}
+
+// MonitorFreshness calls fn once when path has not been modified for longer than maxAge (or is
+// missing), and once more when it is updated again. It checks when the file is due to go stale
+// and, while it is stale, every quarter of maxAge up to a minute. It stops on Stop or Close.
+func MonitorFreshness(path string, maxAge time.Duration, fn FreshnessFunc) (*FreshnessMonitor, error) {
+	path = cleanPath(path)
+	if maxAge <= 0 {
+		return nil, fmt.Errorf("freshness max age must be positive")
+	}
+	if fn == nil {
+		return nil, fmt.Errorf("freshness callback is nil")
+	}
+
+	m := &FreshnessMonitor{
+		path:   path,
+		maxAge: maxAge,
+		fn:     fn,
+		done:   make(chan struct{}),
+	}
+
+	registerCloser(m)
+	m.wg.Add(1)
+	go m.run()
+	return m, nil
+}
+
+// Stale reports whether the file was stale at the last check
+func (m *FreshnessMonitor) Stale() bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.stale
+}
+
+// Age returns the file's age at the last check, or -1 if it was missing
+func (m *FreshnessMonitor) Age() time.Duration {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.age
+}
+
+// Stop ends monitoring without further callbacks
+func (m *FreshnessMonitor) Stop() {
+	m.once.Do(func() {
+		close(m.done)
+		m.wg.Wait()
+		unregisterCloser(m)
+	})
+}
+
+// Close is Stop, so a FreshnessMonitor can be released together with other io.Closers
+func (m *FreshnessMonitor) Close() error {
+	m.Stop()
+	return nil
+}
+
+func (m *FreshnessMonitor) run() {
+	defer m.wg.Done()
+
+	timer := time.NewTimer(0)
+	defer timer.Stop()
+
+	for {
+		select {
+		case <-m.done:
+			return
+		case <-timer.C:
+			timer.Reset(m.check())
+		}
+	}
+}
+
+// check updates the state, reports a change and returns the delay until the next check
+func (m *FreshnessMonitor) check() time.Duration {
+	// Updates by other processes must not be hidden by the stat cache
+	invalidateStat(m.path)
+
+	age := time.Duration(-1)
+	if FileExists(m.path) {
+		if a, err := FileAgeInSec(m.path); err == nil {
+			age = a
+		}
+	}
+	stale := age < 0 || age > m.maxAge
+
+	m.mu.Lock()
+	changed := stale != m.stale
+	m.stale = stale
+	m.age = age
+	m.mu.Unlock()
+
+	if changed {
+		m.fn(m.path, age, stale)
+	}
+
+	if stale {
+		return min(max(m.maxAge/4, 10*time.Millisecond), time.Minute)
+	}
+	// Wake up just after the file would go stale
+	return m.maxAge - age + time.Millisecond
+}
@@ -0,0 +1,59 @@
+// Package fusefs mounts a GMSFS tree as a real filesystem through FUSE, so tools that only
+// know files can reach registered backends (overlays, memory, S3, caching remotes) and the
+// package's policies apply to what they do: quotas, soft delete, stat cache invalidation and
+// path profiles all see the requests like any other caller's.
+//
+//	srv, err := fusefs.Mount("/mnt/assets", "assets:/", fusefs.Options{ReadOnly: true})
+//	if err != nil {
+//		return err
+//	}
+//	defer srv.Unmount()
+//
+// Mounting works on Linux and macOS (with macFUSE); elsewhere Mount fails with
+// errors.ErrUnsupported.
+package fusefs
+
+import (
+	"errors"
+	"os"
+	"syscall"
+	"time"
+
+	GMSFS "github.com/inpadi/GMSFSv2"
+)
+
+// Options adjusts Mount
+type Options struct {
+	ReadOnly   bool   // Mount read-only
+	AllowOther bool   // Let other users in; needs user_allow_other in /etc/fuse.conf
+	FSName     string // Source shown in the mount table; defaults to the mounted name
+	Debug      bool   // Log every FUSE request
+
+	// AttrTimeout is how long the kernel may keep names and attributes without asking again;
+	// 0 asks every time, which suits backends changed by others
+	AttrTimeout time.Duration
+}
+
+// errno converts an error of the package to the errno FUSE returns
+func errno(err error) syscall.Errno {
+	var e syscall.Errno
+	switch {
+	case err == nil:
+		return 0
+	case errors.As(err, &e):
+		return e
+	case errors.Is(err, os.ErrNotExist):
+		return syscall.ENOENT
+	case errors.Is(err, os.ErrExist):
+		return syscall.EEXIST
+	case errors.Is(err, os.ErrPermission), errors.Is(err, GMSFS.ErrPathEscapes):
+		return syscall.EACCES
+	case errors.Is(err, GMSFS.ErrQuotaExceeded):
+		return syscall.EDQUOT
+	case errors.Is(err, errors.ErrUnsupported), errors.Is(err, GMSFS.ErrSpecialFile):
+		return syscall.ENOTSUP
+	case errors.Is(err, os.ErrInvalid):
+		return syscall.EINVAL
+	}
+	return syscall.EIO
+}
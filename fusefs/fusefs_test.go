@@ -0,0 +1,37 @@
+package fusefs
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"syscall"
+	"testing"
+
+	GMSFS "github.com/inpadi/GMSFSv2"
+)
+
+func TestErrno(t *testing.T) {
+	tests := []struct {
+		err  error
+		want syscall.Errno
+	}{
+		{nil, 0},
+		{syscall.EROFS, syscall.EROFS},
+		{&fs.PathError{Op: "open", Path: "x", Err: syscall.ENOTEMPTY}, syscall.ENOTEMPTY},
+		{fmt.Errorf("wrapped: %w", os.ErrNotExist), syscall.ENOENT},
+		{os.ErrExist, syscall.EEXIST},
+		{os.ErrPermission, syscall.EACCES},
+		{fmt.Errorf("open x: %w", GMSFS.ErrPathEscapes), syscall.EACCES},
+		{GMSFS.ErrQuotaExceeded, syscall.EDQUOT},
+		{GMSFS.ErrSpecialFile, syscall.ENOTSUP},
+		{errors.ErrUnsupported, syscall.ENOTSUP},
+		{os.ErrInvalid, syscall.EINVAL},
+		{errors.New("other"), syscall.EIO},
+	}
+	for _, tt := range tests {
+		if got := errno(tt.err); got != tt.want {
+			t.Errorf("errno(%v) = %v, want %v", tt.err, got, tt.want)
+		}
+	}
+}
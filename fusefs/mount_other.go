@@ -0,0 +1,22 @@
+//go:build !linux && !darwin
+
+package fusefs
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Server is a mounted GMSFS tree
+type Server struct{}
+
+// Mount is not available on this platform
+func Mount(dir string, name string, opts Options) (*Server, error) {
+	return nil, fmt.Errorf("fusefs: mounting %s: %w", name, errors.ErrUnsupported)
+}
+
+// Unmount unmounts the tree
+func (s *Server) Unmount() error { return nil }
+
+// Wait blocks until the tree is unmounted
+func (s *Server) Wait() {}
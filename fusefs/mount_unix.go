@@ -0,0 +1,460 @@
+//go:build linux || darwin
+
+package fusefs
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/hanwen/go-fuse/v2/fs"
+	"github.com/hanwen/go-fuse/v2/fuse"
+	GMSFS "github.com/inpadi/GMSFSv2"
+)
+
+// Server is a mounted GMSFS tree
+type Server struct {
+	srv *fuse.Server
+}
+
+// Mount mounts the GMSFS directory name, a local path or one on a registered backend such as
+// "mem:/data", at the local directory dir. Every request runs through the package-level
+// operations on the name below name, so what they refuse is refused to FUSE callers too,
+// with the matching errno. Symbolic links and truncating to a size other than 0 only work
+// for local trees.
+func Mount(dir string, name string, opts Options) (*Server, error) {
+	info, err := GMSFS.Stat(name)
+	if err != nil {
+		return nil, err
+	}
+	if !info.IsDir {
+		return nil, fmt.Errorf("fusefs: %s is not a directory", name)
+	}
+
+	b, _ := GMSFS.BackendFor(name)
+	_, local := b.(GMSFS.LocalBackend)
+	root := &node{tree: &tree{root: name, local: local}}
+
+	fsName := opts.FSName
+	if fsName == "" {
+		fsName = name
+	}
+	mo := fuse.MountOptions{
+		AllowOther: opts.AllowOther,
+		FsName:     fsName,
+		Name:       "gmsfs",
+		Debug:      opts.Debug,
+	}
+	if opts.ReadOnly {
+		mo.Options = append(mo.Options, "ro")
+	}
+	timeout := opts.AttrTimeout
+	srv, err := fs.Mount(dir, root, &fs.Options{
+		MountOptions:    mo,
+		EntryTimeout:    &timeout,
+		AttrTimeout:     &timeout,
+		NegativeTimeout: &timeout,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &Server{srv: srv}, nil
+}
+
+// Unmount unmounts the tree; it fails while files in it are open
+func (s *Server) Unmount() error {
+	return s.srv.Unmount()
+}
+
+// Wait blocks until the tree is unmounted
+func (s *Server) Wait() {
+	s.srv.Wait()
+}
+
+// tree is what all nodes of a mount share
+type tree struct {
+	root  string // The mounted GMSFS name
+	local bool   // root is on the local filesystem rather than a backend
+}
+
+// node is a file or directory of a mounted tree. Its name is derived from where the kernel
+// has it in the tree, so renames don't leave it stale.
+type node struct {
+	fs.Inode
+	tree *tree
+}
+
+var (
+	_ fs.NodeLookuper   = (*node)(nil)
+	_ fs.NodeGetattrer  = (*node)(nil)
+	_ fs.NodeSetattrer  = (*node)(nil)
+	_ fs.NodeReaddirer  = (*node)(nil)
+	_ fs.NodeOpener     = (*node)(nil)
+	_ fs.NodeCreater    = (*node)(nil)
+	_ fs.NodeMkdirer    = (*node)(nil)
+	_ fs.NodeUnlinker   = (*node)(nil)
+	_ fs.NodeRmdirer    = (*node)(nil)
+	_ fs.NodeRenamer    = (*node)(nil)
+	_ fs.NodeReadlinker = (*node)(nil)
+	_ fs.NodeSymlinker  = (*node)(nil)
+	_ fs.NodeStatfser   = (*node)(nil)
+)
+
+// name is the GMSFS name of the node
+func (n *node) name() string {
+	return n.tree.join(n.tree.root, n.Path(nil))
+}
+
+// child is the GMSFS name of the entry called base in the directory n
+func (n *node) child(base string) string {
+	return n.tree.join(n.name(), base)
+}
+
+func (t *tree) join(dir string, rel string) string {
+	if rel == "" {
+		return dir
+	}
+	if t.local {
+		return filepath.Join(dir, rel)
+	}
+	return path.Join(dir, rel)
+}
+
+// newChild returns the inode for the entry at name, filling out from info
+func (n *node) newChild(ctx context.Context, info GMSFS.FileInfo, out *fuse.EntryOut) *fs.Inode {
+	fillAttr(&out.Attr, info)
+	return n.NewInode(ctx, &node{tree: n.tree}, fs.StableAttr{Mode: unixMode(info) & syscall.S_IFMT})
+}
+
+func (n *node) Lookup(ctx context.Context, base string, out *fuse.EntryOut) (*fs.Inode, syscall.Errno) {
+	info, err := GMSFS.Lstat(n.child(base))
+	if err != nil {
+		return nil, errno(err)
+	}
+	return n.newChild(ctx, info, out), 0
+}
+
+func (n *node) Getattr(ctx context.Context, fh fs.FileHandle, out *fuse.AttrOut) syscall.Errno {
+	// An open handle knows about writes the backend may not show yet
+	if h, ok := fh.(*handle); ok {
+		h.mu.Lock()
+		stat, err := h.f.Stat()
+		h.mu.Unlock()
+		if err == nil {
+			info, lerr := GMSFS.Lstat(n.name())
+			if lerr != nil {
+				info = GMSFS.FileInfo{Exists: true, Mode: stat.Mode(), IsDir: stat.IsDir()}
+			}
+			info.Size, info.LastModified = stat.Size(), stat.ModTime()
+			fillAttr(&out.Attr, info)
+			return 0
+		}
+	}
+
+	info, err := GMSFS.Lstat(n.name())
+	if err != nil {
+		return errno(err)
+	}
+	fillAttr(&out.Attr, info)
+	return 0
+}
+
+func (n *node) Setattr(ctx context.Context, fh fs.FileHandle, in *fuse.SetAttrIn, out *fuse.AttrOut) syscall.Errno {
+	name := n.name()
+	if mode, ok := in.GetMode(); ok {
+		if err := GMSFS.Chmod(name, fileMode(mode)); err != nil {
+			return errno(err)
+		}
+	}
+
+	uid, uok := in.GetUID()
+	gid, gok := in.GetGID()
+	if uok || gok {
+		u, g := -1, -1
+		if uok {
+			u = int(uid)
+		}
+		if gok {
+			g = int(gid)
+		}
+		if err := GMSFS.Chown(name, u, g); err != nil {
+			return errno(err)
+		}
+	}
+
+	if size, ok := in.GetSize(); ok {
+		if err := n.truncate(fh, name, int64(size)); err != nil {
+			return errno(err)
+		}
+	}
+
+	atime, aok := in.GetATime()
+	mtime, mok := in.GetMTime()
+	if aok || mok {
+		// A zero time is left unchanged by Chtimes
+		if err := GMSFS.Chtimes(name, atime, mtime); err != nil {
+			return errno(err)
+		}
+	}
+
+	return n.Getattr(ctx, fh, out)
+}
+
+// truncate changes the size of the file name, through its open handle when it has one
+func (n *node) truncate(fh fs.FileHandle, name string, size int64) error {
+	if h, ok := fh.(*handle); ok {
+		if t, ok := h.f.(interface{ Truncate(int64) error }); ok {
+			h.mu.Lock()
+			defer h.mu.Unlock()
+			return t.Truncate(size)
+		}
+	}
+	if n.tree.local || size != 0 {
+		return GMSFS.Truncate(name, size)
+	}
+
+	// Backends can't truncate, but can replace a file with an empty one
+	f, err := GMSFS.OpenHandle(name, os.O_WRONLY|os.O_TRUNC, 0)
+	if err != nil {
+		return err
+	}
+	return f.Close()
+}
+
+func (n *node) Readdir(ctx context.Context) (fs.DirStream, syscall.Errno) {
+	infos, err := GMSFS.ReadDirFiltered(n.name(), GMSFS.ReadDirOptions{NamesOnly: true, Sort: GMSFS.SortNone})
+	if err != nil {
+		return nil, errno(err)
+	}
+
+	entries := make([]fuse.DirEntry, 0, len(infos))
+	for _, info := range infos {
+		entries = append(entries, fuse.DirEntry{Name: info.Name, Mode: unixMode(info)})
+	}
+	return fs.NewListDirStream(entries), 0
+}
+
+func (n *node) Open(ctx context.Context, flags uint32) (fs.FileHandle, uint32, syscall.Errno) {
+	name := n.name()
+	f, err := GMSFS.OpenHandle(name, openFlags(flags), 0)
+	if err != nil {
+		return nil, 0, errno(err)
+	}
+	return &handle{f: f}, 0, 0
+}
+
+func (n *node) Create(ctx context.Context, base string, flags uint32, mode uint32, out *fuse.EntryOut) (*fs.Inode, fs.FileHandle, uint32, syscall.Errno) {
+	name := n.child(base)
+	f, err := GMSFS.OpenHandle(name, openFlags(flags)|os.O_CREATE, fileMode(mode))
+	if err != nil {
+		return nil, nil, 0, errno(err)
+	}
+	info, err := GMSFS.Lstat(name)
+	if err != nil {
+		f.Close()
+		return nil, nil, 0, errno(err)
+	}
+	return n.newChild(ctx, info, out), &handle{f: f}, 0, 0
+}
+
+func (n *node) Mkdir(ctx context.Context, base string, mode uint32, out *fuse.EntryOut) (*fs.Inode, syscall.Errno) {
+	name := n.child(base)
+	if err := GMSFS.Mkdir(name, fileMode(mode)); err != nil {
+		return nil, errno(err)
+	}
+	info, err := GMSFS.Lstat(name)
+	if err != nil {
+		return nil, errno(err)
+	}
+	return n.newChild(ctx, info, out), 0
+}
+
+func (n *node) Unlink(ctx context.Context, base string) syscall.Errno {
+	return errno(GMSFS.Remove(n.child(base)))
+}
+
+func (n *node) Rmdir(ctx context.Context, base string) syscall.Errno {
+	return errno(GMSFS.Remove(n.child(base)))
+}
+
+func (n *node) Rename(ctx context.Context, base string, newParent fs.InodeEmbedder, newBase string, flags uint32) syscall.Errno {
+	// RENAME_NOREPLACE and RENAME_EXCHANGE have no GMSFS counterpart
+	if flags != 0 {
+		return syscall.ENOTSUP
+	}
+	dst, ok := newParent.(*node)
+	if !ok {
+		return syscall.EXDEV
+	}
+	return errno(GMSFS.Rename(n.child(base), dst.child(newBase)))
+}
+
+func (n *node) Readlink(ctx context.Context) ([]byte, syscall.Errno) {
+	target, err := GMSFS.Readlink(n.name())
+	if err != nil {
+		return nil, errno(err)
+	}
+	return []byte(target), 0
+}
+
+func (n *node) Symlink(ctx context.Context, target string, base string, out *fuse.EntryOut) (*fs.Inode, syscall.Errno) {
+	name := n.child(base)
+	if err := GMSFS.Symlink(target, name); err != nil {
+		return nil, errno(err)
+	}
+	info, err := GMSFS.Lstat(name)
+	if err != nil {
+		return nil, errno(err)
+	}
+	return n.newChild(ctx, info, out), 0
+}
+
+func (n *node) Statfs(ctx context.Context, out *fuse.StatfsOut) syscall.Errno {
+	// Backends have no notion of free space and report an empty volume
+	if !n.tree.local {
+		return 0
+	}
+	space, err := GMSFS.DiskFree(n.tree.root)
+	if err != nil {
+		return errno(err)
+	}
+	const block = 4096
+	out.Bsize = block
+	out.Frsize = block
+	out.Blocks = uint64(space.Total / block)
+	out.Bfree = uint64(space.Free / block)
+	out.Bavail = uint64(space.Available / block)
+	out.NameLen = 255
+	return 0
+}
+
+// handle is an open file of a mounted tree. Reads and writes at offsets are serialised, as
+// backend files only have a single position.
+type handle struct {
+	mu sync.Mutex
+	f  GMSFS.File
+}
+
+var (
+	_ fs.FileReader   = (*handle)(nil)
+	_ fs.FileWriter   = (*handle)(nil)
+	_ fs.FileFsyncer  = (*handle)(nil)
+	_ fs.FileReleaser = (*handle)(nil)
+)
+
+func (h *handle) Read(ctx context.Context, dest []byte, off int64) (fuse.ReadResult, syscall.Errno) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	var n int
+	var err error
+	if ra, ok := h.f.(io.ReaderAt); ok {
+		n, err = ra.ReadAt(dest, off)
+	} else if _, err = h.f.Seek(off, io.SeekStart); err == nil {
+		n, err = io.ReadFull(h.f, dest)
+	}
+	if err != nil && !errors.Is(err, io.EOF) && !errors.Is(err, io.ErrUnexpectedEOF) {
+		return nil, errno(err)
+	}
+	return fuse.ReadResultData(dest[:n]), 0
+}
+
+func (h *handle) Write(ctx context.Context, data []byte, off int64) (uint32, syscall.Errno) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	// Files opened with O_APPEND write at their end whatever the position
+	if _, err := h.f.Seek(off, io.SeekStart); err != nil {
+		return 0, errno(err)
+	}
+	n, err := h.f.Write(data)
+	if err != nil {
+		return uint32(n), errno(err)
+	}
+	return uint32(n), 0
+}
+
+func (h *handle) Fsync(ctx context.Context, flags uint32) syscall.Errno {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return errno(h.f.Sync())
+}
+
+func (h *handle) Release(ctx context.Context) syscall.Errno {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return errno(h.f.Close())
+}
+
+// openFlags keeps the flags of a FUSE open that OpenHandle understands
+func openFlags(flags uint32) int {
+	return int(flags) & (os.O_RDONLY | os.O_WRONLY | os.O_RDWR | os.O_APPEND | os.O_TRUNC | os.O_EXCL)
+}
+
+// fileMode converts the permission bits of a FUSE mode
+func fileMode(mode uint32) os.FileMode {
+	m := os.FileMode(mode & 0777)
+	if mode&syscall.S_ISUID != 0 {
+		m |= os.ModeSetuid
+	}
+	if mode&syscall.S_ISGID != 0 {
+		m |= os.ModeSetgid
+	}
+	if mode&syscall.S_ISVTX != 0 {
+		m |= os.ModeSticky
+	}
+	return m
+}
+
+// unixMode is the st_mode of info
+func unixMode(info GMSFS.FileInfo) uint32 {
+	mode := uint32(info.Mode.Perm())
+	if info.Mode&os.ModeSetuid != 0 {
+		mode |= syscall.S_ISUID
+	}
+	if info.Mode&os.ModeSetgid != 0 {
+		mode |= syscall.S_ISGID
+	}
+	if info.Mode&os.ModeSticky != 0 {
+		mode |= syscall.S_ISVTX
+	}
+
+	switch {
+	case info.IsDir:
+		mode |= syscall.S_IFDIR
+	case info.IsSymlink || info.Mode&os.ModeSymlink != 0:
+		mode |= syscall.S_IFLNK
+	case info.Mode&os.ModeNamedPipe != 0:
+		mode |= syscall.S_IFIFO
+	case info.Mode&os.ModeSocket != 0:
+		mode |= syscall.S_IFSOCK
+	case info.Mode&os.ModeDevice != 0 && info.Mode&os.ModeCharDevice != 0:
+		mode |= syscall.S_IFCHR
+	case info.Mode&os.ModeDevice != 0:
+		mode |= syscall.S_IFBLK
+	default:
+		mode |= syscall.S_IFREG
+	}
+	return mode
+}
+
+// fillAttr fills the attributes FUSE reports for info, owned by the user running the mount
+func fillAttr(out *fuse.Attr, info GMSFS.FileInfo) {
+	out.Mode = unixMode(info)
+	out.Size = uint64(info.Size)
+	out.Blocks = (out.Size + 511) / 512
+	out.Nlink = 1
+	mtime := info.LastModified
+	if mtime.IsZero() {
+		mtime = time.Now()
+	}
+	out.SetTimes(&mtime, &mtime, &mtime)
+	out.Owner = *fuse.CurrentOwner()
+}
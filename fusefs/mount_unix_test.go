@@ -0,0 +1,180 @@
+//go:build linux || darwin
+
+package fusefs
+
+import (
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+
+	GMSFS "github.com/inpadi/GMSFSv2"
+)
+
+func TestModes(t *testing.T) {
+	tests := []struct {
+		info GMSFS.FileInfo
+		want uint32
+	}{
+		{GMSFS.FileInfo{Mode: 0644}, syscall.S_IFREG | 0644},
+		{GMSFS.FileInfo{Mode: os.ModeDir | 0755, IsDir: true}, syscall.S_IFDIR | 0755},
+		{GMSFS.FileInfo{Mode: os.ModeSymlink | 0777}, syscall.S_IFLNK | 0777},
+		{GMSFS.FileInfo{Mode: 0777, IsSymlink: true}, syscall.S_IFLNK | 0777},
+		{GMSFS.FileInfo{Mode: os.ModeNamedPipe | 0600}, syscall.S_IFIFO | 0600},
+		{GMSFS.FileInfo{Mode: os.ModeDevice | os.ModeCharDevice | 0666}, syscall.S_IFCHR | 0666},
+		{GMSFS.FileInfo{Mode: os.ModeSetuid | os.ModeSticky | 0755}, syscall.S_IFREG | syscall.S_ISUID | syscall.S_ISVTX | 0755},
+	}
+	for _, tt := range tests {
+		if got := unixMode(tt.info); got != tt.want {
+			t.Errorf("unixMode(%v) = %o, want %o", tt.info.Mode, got, tt.want)
+		}
+	}
+
+	if got := fileMode(syscall.S_IFREG | syscall.S_ISGID | 0750); got != os.ModeSetgid|0750 {
+		t.Errorf("fileMode = %v", got)
+	}
+}
+
+func TestOpenFlags(t *testing.T) {
+	in := uint32(os.O_RDWR | os.O_APPEND | syscall.O_NONBLOCK)
+	if got := openFlags(in); got != os.O_RDWR|os.O_APPEND {
+		t.Errorf("openFlags(%#x) = %#x", in, got)
+	}
+}
+
+func TestMountRejectsFiles(t *testing.T) {
+	name := filepath.Join(t.TempDir(), "file")
+	if err := os.WriteFile(name, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Mount(t.TempDir(), name, Options{}); err == nil {
+		t.Error("Mount of a file succeeded")
+	}
+	if _, err := Mount(t.TempDir(), filepath.Join(name, "missing"), Options{}); err == nil {
+		t.Error("Mount of a missing directory succeeded")
+	}
+}
+
+// mount mounts src at a new directory, skipping the test where FUSE is unavailable
+func mount(t *testing.T, src string, opts Options) string {
+	t.Helper()
+	dir := t.TempDir()
+	srv, err := Mount(dir, src, opts)
+	if err != nil {
+		t.Skipf("FUSE unavailable: %v", err)
+	}
+	t.Cleanup(func() {
+		if err := srv.Unmount(); err != nil {
+			t.Error(err)
+		}
+	})
+	return dir
+}
+
+func TestMount(t *testing.T) {
+	src := t.TempDir()
+	if err := os.WriteFile(filepath.Join(src, "a.txt"), []byte("hello"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	dir := mount(t, src, Options{})
+
+	data, err := os.ReadFile(filepath.Join(dir, "a.txt"))
+	if err != nil || string(data) != "hello" {
+		t.Fatalf("ReadFile = %q, %v", data, err)
+	}
+
+	if err := os.WriteFile(filepath.Join(dir, "b.txt"), []byte("written"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if data, err := os.ReadFile(filepath.Join(src, "b.txt")); err != nil || string(data) != "written" {
+		t.Errorf("source b.txt = %q, %v", data, err)
+	}
+
+	if err := os.Mkdir(filepath.Join(dir, "sub"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Rename(filepath.Join(dir, "b.txt"), filepath.Join(dir, "sub", "c.txt")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(src, "sub", "c.txt")); err != nil {
+		t.Error(err)
+	}
+	if err := os.Truncate(filepath.Join(dir, "sub", "c.txt"), 3); err != nil {
+		t.Fatal(err)
+	}
+	if info, err := os.Stat(filepath.Join(dir, "sub", "c.txt")); err != nil || info.Size() != 3 {
+		t.Errorf("size after truncate = %v, %v", info, err)
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil || len(entries) != 2 {
+		t.Errorf("ReadDir = %v, %v", entries, err)
+	}
+
+	if err := os.Remove(filepath.Join(dir, "sub")); err == nil {
+		t.Error("Remove of a non-empty directory succeeded")
+	}
+	if err := os.Remove(filepath.Join(dir, "sub", "c.txt")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Remove(filepath.Join(dir, "sub")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(src, "sub")); !os.IsNotExist(err) {
+		t.Errorf("sub still exists: %v", err)
+	}
+}
+
+func TestMountReadOnly(t *testing.T) {
+	src := t.TempDir()
+	dir := mount(t, src, Options{ReadOnly: true})
+
+	if err := os.WriteFile(filepath.Join(dir, "x"), nil, 0644); err == nil {
+		t.Error("write to a read-only mount succeeded")
+	}
+	if _, err := os.Stat(filepath.Join(src, "x")); !os.IsNotExist(err) {
+		t.Errorf("x exists in the source: %v", err)
+	}
+}
+
+func TestMountSymlink(t *testing.T) {
+	src := t.TempDir()
+	if err := os.WriteFile(filepath.Join(src, "target"), []byte("x"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	dir := mount(t, src, Options{})
+
+	if err := os.Symlink("target", filepath.Join(dir, "link")); err != nil {
+		t.Fatal(err)
+	}
+	if target, err := os.Readlink(filepath.Join(src, "link")); err != nil || target != "target" {
+		t.Errorf("source link = %q, %v", target, err)
+	}
+	if info, err := os.Lstat(filepath.Join(dir, "link")); err != nil || info.Mode()&os.ModeSymlink == 0 {
+		t.Errorf("Lstat = %v, %v", info, err)
+	}
+}
+
+func TestMountBackend(t *testing.T) {
+	if err := GMSFS.RegisterBackend("fusetest", GMSFS.NewMemBackend()); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { GMSFS.UnregisterBackend("fusetest") })
+	if err := GMSFS.MkdirAll("fusetest:/data", 0755); err != nil {
+		t.Fatal(err)
+	}
+	dir := mount(t, "fusetest:/data", Options{})
+
+	if err := os.WriteFile(filepath.Join(dir, "a.txt"), []byte("in memory"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if data, err := GMSFS.ReadFile("fusetest:/data/a.txt"); err != nil || string(data) != "in memory" {
+		t.Errorf("backend a.txt = %q, %v", data, err)
+	}
+	if err := os.Truncate(filepath.Join(dir, "a.txt"), 0); err != nil {
+		t.Fatal(err)
+	}
+	if data, err := os.ReadFile(filepath.Join(dir, "a.txt")); err != nil || len(data) != 0 {
+		t.Errorf("a.txt after truncate = %q, %v", data, err)
+	}
+}
@@ -0,0 +1,241 @@
+package GMSFS
+
+import (
+	"context"
+	"math"
+	"math/rand/v2"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"time"
+)
+
+// TreeSpec describes a tree for GenerateTree
+type TreeSpec struct {
+	Depth            int              // Directory levels below the root
+	DirsPerDir       int              // Subdirectories of each directory above the deepest level; 0 means 1, a single deep chain
+	FilesPerDir      int              // Files in every directory, the root included
+	SizeDistribution SizeDistribution // File sizes; nil makes empty files
+	NameCharset      NameCharset      // Empty means NamesASCII
+	NameLength       int              // Characters per name; 0 means 8. Most filesystems allow 255 bytes of UTF-8
+	Seed             uint64           // The same spec and seed give the same tree
+}
+
+// SizeDistribution picks the size of each generated file
+type SizeDistribution func(r *rand.Rand) int64
+
+// FixedSize makes every file n bytes
+func FixedSize(n int64) SizeDistribution {
+	return func(*rand.Rand) int64 { return n }
+}
+
+// UniformSizes spreads file sizes evenly between min and max bytes
+func UniformSizes(min int64, max int64) SizeDistribution {
+	return func(r *rand.Rand) int64 {
+		if max <= min {
+			return min
+		}
+		return min + r.Int64N(max-min+1)
+	}
+}
+
+// LogUniformSizes spreads file sizes evenly over the orders of magnitude between min and max,
+// so most files are small and a few are large, as on real disks. min is at least 1.
+func LogUniformSizes(min int64, max int64) SizeDistribution {
+	if min < 1 {
+		min = 1
+	}
+	return func(r *rand.Rand) int64 {
+		if max <= min {
+			return min
+		}
+		lo, hi := math.Log(float64(min)), math.Log(float64(max))
+		return int64(math.Exp(lo + r.Float64()*(hi-lo)))
+	}
+}
+
+// NameCharset is the alphabet GenerateTree draws file and directory names from. Besides the
+// predefined ones any string works as its own alphabet. Characters the platform does not
+// allow in names are left out.
+type NameCharset string
+
+const (
+	NamesASCII   NameCharset = "abcdefghijklmnopqrstuvwxyz0123456789"
+	NamesUnicode NameCharset = "aäöüßéñçøåÆœ漢字日本語한국어Ωπλжщשלוםمرحبا🙂🚀́"
+	NamesTricky  NameCharset = "aA b-._~[]{}*?!#$%&'()+,;=@^`"
+)
+
+// GenerateTree creates a synthetic tree below root, for benchmarking CopyDir, Walk and
+// SyncDir on realistic trees and for reproducing edge cases such as deep nesting and unusual
+// names. Paths beyond the Windows MAX_PATH limit work. Content is pseudo-random, so it does
+// not compress.
+func GenerateTree(root string, spec TreeSpec) (Stats, error) {
+	root = cleanPath(root)
+	if err := requireLocal("generate", root); err != nil {
+		errorPrinter("GenerateTree: "+err.Error(), root)
+		return Stats{}, err
+	}
+	// Absolute paths get the long path treatment from package os as they grow
+	if abs, err := filepath.Abs(root); err == nil {
+		root = abs
+	}
+
+	g := &treeGen{
+		spec:     spec,
+		rand:     rand.New(rand.NewPCG(spec.Seed, spec.Seed^0x9e3779b97f4a7c15)),
+		alphabet: nameAlphabet(spec.NameCharset),
+		start:    time.Now(),
+	}
+	if g.spec.DirsPerDir <= 0 {
+		g.spec.DirsPerDir = 1
+	}
+	if g.spec.NameLength <= 0 {
+		g.spec.NameLength = 8
+	}
+
+	_, g.job = startJob(context.Background(), "GenerateTree", root, "")
+	defer g.job.done()
+	defer invalidateStatTree(root)
+
+	err := os.MkdirAll(root, 0755)
+	if err == nil {
+		err = g.dir(root, 0)
+	}
+	g.stats.Duration = time.Since(g.start)
+	if err != nil {
+		g.stats.Errors++
+		errorPrinter("GenerateTree: "+err.Error(), root)
+	}
+	return g.stats, err
+}
+
+type treeGen struct {
+	spec     TreeSpec
+	rand     *rand.Rand
+	alphabet []rune
+	start    time.Time
+	stats    Stats
+	job      *job
+	buf      []byte
+}
+
+// dir fills dir, which sits level levels below the root
+func (g *treeGen) dir(dir string, level int) error {
+	used := map[string]bool{}
+
+	for i := 0; i < g.spec.FilesPerDir; i++ {
+		var size int64
+		if g.spec.SizeDistribution != nil {
+			size = max(g.spec.SizeDistribution(g.rand), 0)
+		}
+		if err := g.file(filepath.Join(dir, g.name(used)), size); err != nil {
+			return err
+		}
+	}
+
+	if level >= g.spec.Depth {
+		return nil
+	}
+	for i := 0; i < g.spec.DirsPerDir; i++ {
+		sub := filepath.Join(dir, g.name(used))
+		if err := os.Mkdir(sub, 0755); err != nil {
+			return err
+		}
+		g.stats.Dirs++
+		if err := g.dir(sub, level+1); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (g *treeGen) file(name string, size int64) (err error) {
+	acquireFDs(1)
+	defer releaseFDs(1)
+
+	simulateOp()
+	f, err := os.OpenFile(name, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if cerr := f.Close(); err == nil {
+			err = cerr
+		}
+	}()
+
+	if g.buf == nil {
+		g.buf = make([]byte, 64<<10)
+	}
+	w := simulatedWriter(f)
+	for left := size; left > 0; {
+		chunk := g.buf[:min(left, int64(len(g.buf)))]
+		for i := 0; i < len(chunk); i += 8 {
+			v := g.rand.Uint64()
+			for k := i; k < i+8 && k < len(chunk); k++ {
+				chunk[k] = byte(v)
+				v >>= 8
+			}
+		}
+		if _, err := w.Write(chunk); err != nil {
+			return err
+		}
+		left -= int64(len(chunk))
+	}
+
+	g.stats.Files++
+	g.stats.Bytes += size
+	g.job.add(1, size)
+	return nil
+}
+
+// name draws a name not yet used in the directory. Names differing only in case count as
+// used, so the tree also fits on case-insensitive filesystems. Names grow when the short ones
+// run out.
+func (g *treeGen) name(used map[string]bool) string {
+	for attempt := 0; ; attempt++ {
+		var b strings.Builder
+		for i := 0; i < g.spec.NameLength+attempt/100; i++ {
+			b.WriteRune(g.alphabet[g.rand.IntN(len(g.alphabet))])
+		}
+		name := b.String()
+
+		// Windows drops trailing spaces and dots, and "." and ".." are taken everywhere
+		if runtime.GOOS == "windows" {
+			name = strings.TrimRight(name, " .")
+		}
+		if name == "" || strings.Trim(name, ".") == "" {
+			continue
+		}
+
+		key := strings.ToLower(name)
+		if !used[key] {
+			used[key] = true
+			return name
+		}
+	}
+}
+
+// nameAlphabet turns a charset into the runes allowed in names on this platform
+func nameAlphabet(charset NameCharset) []rune {
+	if charset == "" {
+		charset = NamesASCII
+	}
+
+	invalid := "/\x00"
+	if runtime.GOOS == "windows" {
+		invalid += `\<>:"|?*`
+	}
+
+	var alphabet []rune
+	for _, r := range string(charset) {
+		if r >= 0x20 && !strings.ContainsRune(invalid, r) {
+			alphabet = append(alphabet, r)
+		}
+	}
+	if len(alphabet) == 0 {
+		return []rune(string(NamesASCII))
+	}
+	return alphabet
+}
@@ -0,0 +1,308 @@
+package GMSFS
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+)
+
+// GlobOptions adjusts how GlobWithOptions and FindFilesInDirWithOptions match names
+type GlobOptions struct {
+	CaseInsensitive bool // Match every path component regardless of case, e.g. trees produced on Windows
+	Canonicalize    bool // Return real paths with all symlinks resolved
+	Dedupe          bool // Drop matches that resolve to the same real path as an earlier match
+}
+
+// GlobWithOptions is Glob with matching options, which take the place of the global
+// SetCaseInsensitive setting
+func GlobWithOptions(pattern string, opts GlobOptions) ([]string, error) {
+	matches, err := glob(pattern, opts.CaseInsensitive)
+	if err != nil {
+		return nil, err
+	}
+	return canonicalMatches(matches, opts), nil
+}
+
+// glob is Glob matching case-insensitively when fold is set
+func glob(pattern string, fold bool) ([]string, error) {
+	// The volume of a \\?\ path is exempt from matching, so its "?" stays literal
+	pattern = longPath(pattern)
+	if fold || (unicodeForm.Load() != nil && !isASCII(pattern)) {
+		return globExpanded(pattern, func(p string) ([]string, error) { return globFold(p, fold) })
+	}
+	if !hasBraces(pattern) {
+		return filepath.Glob(pattern)
+	}
+	return globExpanded(pattern, filepath.Glob)
+}
+
+// globExpanded expands the {a,b} alternatives of pattern and merges what match finds for
+// each, in pattern order
+func globExpanded(pattern string, match func(pattern string) ([]string, error)) ([]string, error) {
+	patterns, err := expandBraces(pattern)
+	if err != nil {
+		errorPrinter("Glob: "+err.Error(), pattern)
+		return nil, err
+	}
+
+	var matches []string
+	seen := map[string]bool{}
+	for _, p := range patterns {
+		found, err := match(p)
+		if err != nil {
+			errorPrinter("Glob: "+err.Error(), p)
+			return nil, err
+		}
+		for _, m := range found {
+			if !seen[m] {
+				seen[m] = true
+				matches = append(matches, m)
+			}
+		}
+	}
+	return matches, nil
+}
+
+// FindFilesInDirWithOptions is FindFilesInDir with matching options
+func FindFilesInDirWithOptions(dir string, pattern string, opts GlobOptions) ([]string, error) {
+	entries, err := ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	patterns, err := expandBraces(pattern)
+	if err != nil {
+		return nil, err
+	}
+
+	var matches []string
+	for _, entry := range entries {
+		for _, p := range patterns {
+			if matched, err := matchName(p, entry.Name, opts.CaseInsensitive); err != nil {
+				return nil, err
+			} else if matched {
+				matches = append(matches, filepath.Join(dir, entry.Name))
+				break
+			}
+		}
+	}
+
+	return canonicalMatches(matches, opts), nil
+}
+
+// canonicalMatches applies the Canonicalize and Dedupe options
+func canonicalMatches(matches []string, opts GlobOptions) []string {
+	if !opts.Canonicalize && !opts.Dedupe {
+		return matches
+	}
+
+	result := matches[:0]
+	seen := map[string]bool{}
+	for _, match := range matches {
+		real := realMatchPath(match)
+
+		if opts.Dedupe {
+			if seen[real] {
+				continue
+			}
+			seen[real] = true
+		}
+		if opts.Canonicalize {
+			match = real
+		}
+		result = append(result, match)
+	}
+
+	return result
+}
+
+// realMatchPath resolves all symlinks in match; for a dangling link only its directory is resolved
+func realMatchPath(match string) string {
+	resolved, err := filepath.EvalSymlinks(match)
+	if err != nil {
+		dir, derr := filepath.EvalSymlinks(filepath.Dir(match))
+		if derr != nil {
+			return match
+		}
+		resolved = filepath.Join(dir, filepath.Base(match))
+	}
+
+	if abs, err := filepath.Abs(resolved); err == nil {
+		return abs
+	}
+	return resolved
+}
+
+func matchName(pattern string, name string, fold bool) (bool, error) {
+	if f := unicodeForm.Load(); f != nil {
+		pattern, name = f.String(pattern), f.String(name)
+	}
+	if fold {
+		return filepath.Match(strings.ToLower(pattern), strings.ToLower(name))
+	}
+	return filepath.Match(pattern, name)
+}
+
+// globFold resolves pattern one component at a time, matching every component (literal or
+// not) against the directory listing under the normalization option, and case-insensitively
+// when fold is set
+func globFold(pattern string, fold bool) ([]string, error) {
+	if _, err := filepath.Match(pattern, ""); err != nil {
+		return nil, err
+	}
+
+	volume := filepath.VolumeName(pattern)
+	rest := pattern[len(volume):]
+	base := "."
+	if volume != "" {
+		base = volume
+	}
+	if rest != "" && os.IsPathSeparator(rest[0]) {
+		base = volume + string(filepath.Separator)
+	}
+
+	parts := strings.FieldsFunc(rest, func(r rune) bool { return r < 0x80 && os.IsPathSeparator(uint8(r)) })
+	candidates := []string{base}
+	for i, part := range parts {
+		last := i == len(parts)-1
+
+		var next []string
+		for _, dir := range candidates {
+			if part == "." || part == ".." {
+				next = append(next, filepath.Join(dir, part))
+				continue
+			}
+
+			entries, err := os.ReadDir(dir)
+			if err != nil {
+				continue
+			}
+			for _, entry := range entries {
+				if matched, _ := matchName(part, entry.Name(), fold); !matched {
+					continue
+				}
+
+				full := filepath.Join(dir, entry.Name())
+				if !last && !entry.IsDir() {
+					// Intermediate components must be directories, possibly via a symlink
+					if info, err := os.Stat(full); err != nil || !info.IsDir() {
+						continue
+					}
+				}
+				next = append(next, full)
+			}
+		}
+
+		candidates = next
+		if len(candidates) == 0 {
+			return nil, nil
+		}
+	}
+
+	return candidates, nil
+}
+
+// maxBraceExpansions bounds the patterns one pattern's braces expand to, as each group
+// multiplies them: "{a,b}{c,d}..." doubles with every group
+const maxBraceExpansions = 1024
+
+// expandBraces expands shell-style alternatives, so "*.{log,txt}" becomes "*.log" and "*.txt".
+// Braces may nest; a brace group without a comma is kept literally, as in the shell. A pattern
+// expanding to more than maxBraceExpansions patterns fails with filepath.ErrBadPattern.
+func expandBraces(pattern string) ([]string, error) {
+	expanded, ok := appendBraces(nil, pattern)
+	if !ok {
+		return nil, fmt.Errorf("%w: %q expands to more than %d patterns", filepath.ErrBadPattern, pattern, maxBraceExpansions)
+	}
+	return expanded, nil
+}
+
+// appendBraces appends the expansions of pattern to expanded, stopping as soon as there would
+// be more than maxBraceExpansions
+func appendBraces(expanded []string, pattern string) ([]string, bool) {
+	open, close, ok := findBraceGroup(pattern, 0)
+	for ok {
+		alternatives := splitBraceGroup(pattern[open+1 : close])
+		if len(alternatives) > 1 {
+			prefix, suffix := pattern[:open], pattern[close+1:]
+			for _, alt := range alternatives {
+				if expanded, ok = appendBraces(expanded, prefix+alt+suffix); !ok {
+					return nil, false
+				}
+			}
+			return expanded, true
+		}
+		open, close, ok = findBraceGroup(pattern, close+1)
+	}
+
+	if len(expanded) >= maxBraceExpansions {
+		return nil, false
+	}
+	return append(expanded, pattern), true
+}
+
+// findBraceGroup returns the positions of the first balanced {...} at or after start
+func findBraceGroup(pattern string, start int) (int, int, bool) {
+	depth := 0
+	open := -1
+	for i := start; i < len(pattern); i++ {
+		switch pattern[i] {
+		case '\\':
+			if braceEscapes() {
+				i++
+			}
+		case '{':
+			if depth == 0 {
+				open = i
+			}
+			depth++
+		case '}':
+			if depth == 0 {
+				continue
+			}
+			depth--
+			if depth == 0 {
+				return open, i, true
+			}
+		}
+	}
+	return -1, -1, false
+}
+
+// splitBraceGroup splits the inside of a brace group at its top-level commas
+func splitBraceGroup(group string) []string {
+	var parts []string
+	depth := 0
+	last := 0
+	for i := 0; i < len(group); i++ {
+		switch group[i] {
+		case '\\':
+			if braceEscapes() {
+				i++
+			}
+		case '{':
+			depth++
+		case '}':
+			depth--
+		case ',':
+			if depth == 0 {
+				parts = append(parts, group[last:i])
+				last = i + 1
+			}
+		}
+	}
+	return append(parts, group[last:])
+}
+
+// braceEscapes reports whether a backslash escapes the next character, which
+// filepath.Match only does outside Windows where it is the path separator
+func braceEscapes() bool {
+	return runtime.GOOS != "windows"
+}
+
+// hasBraces is a cheap check to keep the common case free of allocations
+func hasBraces(pattern string) bool {
+	return strings.ContainsRune(pattern, '{')
+}
@@ -0,0 +1,136 @@
+package GMSFS
+
+import (
+	"errors"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestExpandBraces(t *testing.T) {
+	tests := []struct {
+		pattern string
+		want    []string
+	}{
+		{"*.log", []string{"*.log"}},
+		{"*.{log,txt}", []string{"*.log", "*.txt"}},
+		{"{a,b{c,d}}x", []string{"ax", "bcx", "bdx"}},
+		{"{a}.txt", []string{"{a}.txt"}},
+		{strings.Repeat("{a,b}", 10), nil}, // Exactly maxBraceExpansions
+	}
+	for _, tt := range tests {
+		got, err := expandBraces(tt.pattern)
+		if err != nil {
+			t.Errorf("expandBraces(%q): %v", tt.pattern, err)
+			continue
+		}
+		if tt.want == nil {
+			if len(got) != maxBraceExpansions {
+				t.Errorf("expandBraces(%q) = %d patterns, want %d", tt.pattern, len(got), maxBraceExpansions)
+			}
+			continue
+		}
+		if strings.Join(got, " ") != strings.Join(tt.want, " ") {
+			t.Errorf("expandBraces(%q) = %q, want %q", tt.pattern, got, tt.want)
+		}
+	}
+}
+
+func TestExpandBracesLimit(t *testing.T) {
+	for _, pattern := range []string{
+		strings.Repeat("{a,b}", 11),
+		strings.Repeat("{a,b,c,d}", 40),
+		"{" + strings.Repeat("x,", maxBraceExpansions) + "x}",
+	} {
+		if _, err := expandBraces(pattern); !errors.Is(err, filepath.ErrBadPattern) {
+			t.Errorf("expandBraces(%.20q...) = %v, want ErrBadPattern", pattern, err)
+		}
+	}
+
+	if _, err := Glob(filepath.Join(t.TempDir(), strings.Repeat("{a,b}", 11))); !errors.Is(err, filepath.ErrBadPattern) {
+		t.Errorf("Glob = %v, want ErrBadPattern", err)
+	}
+	if _, err := ReadDirFiltered(t.TempDir(), ReadDirOptions{Pattern: strings.Repeat("{a,b}", 11)}); !errors.Is(err, filepath.ErrBadPattern) {
+		t.Errorf("ReadDirFiltered = %v, want ErrBadPattern", err)
+	}
+}
+
+func TestGlobWithOptions(t *testing.T) {
+	dir := t.TempDir()
+	writeTestTree(t, dir, map[string]string{"Report.TXT": "r", "notes.txt": "n", "sub/Data.log": "d"})
+	join := func(names []string) string {
+		for i, name := range names {
+			names[i], _ = filepath.Rel(dir, name)
+		}
+		return strings.Join(names, ",")
+	}
+
+	tests := []struct {
+		pattern string
+		opts    GlobOptions
+		want    string
+	}{
+		{"*.txt", GlobOptions{}, "notes.txt"},
+		{"*.txt", GlobOptions{CaseInsensitive: true}, "Report.TXT,notes.txt"},
+		{"{report,notes}.txt", GlobOptions{CaseInsensitive: true}, "Report.TXT,notes.txt"},
+		{"SUB/*.LOG", GlobOptions{CaseInsensitive: true}, filepath.Join("sub", "Data.log")},
+		{"SUB/*.LOG", GlobOptions{}, ""},
+	}
+	check := func() {
+		t.Helper()
+		for _, tt := range tests {
+			matches, err := GlobWithOptions(filepath.Join(dir, tt.pattern), tt.opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := join(matches); got != tt.want {
+				t.Errorf("GlobWithOptions(%q, %+v) = %s, want %s", tt.pattern, tt.opts, got, tt.want)
+			}
+		}
+	}
+	check()
+
+	// The options, not the global setting, decide
+	SetCaseInsensitive(true)
+	defer SetCaseInsensitive(false)
+	check()
+	if matches, _ := Glob(filepath.Join(dir, "*.txt")); join(matches) != "Report.TXT,notes.txt" {
+		t.Errorf("Glob with SetCaseInsensitive = %v", matches)
+	}
+
+	if _, err := GlobWithOptions(filepath.Join(dir, "[x"), GlobOptions{}); err == nil {
+		t.Error("GlobWithOptions with a bad pattern succeeded")
+	}
+}
+
+func TestFindFilesInDirWithOptions(t *testing.T) {
+	dir := t.TempDir()
+	writeTestTree(t, dir, map[string]string{"a.LOG": "a", "b.log": "b", "c.txt": "c"})
+
+	matches, err := FindFilesInDirWithOptions(dir, "*.{log,txt}", GlobOptions{})
+	if err != nil || len(matches) != 2 {
+		t.Errorf("FindFilesInDirWithOptions = %v, %v", matches, err)
+	}
+	matches, err = FindFilesInDirWithOptions(dir, "*.log", GlobOptions{CaseInsensitive: true})
+	if err != nil || len(matches) != 2 || matches[0] != filepath.Join(dir, "a.LOG") {
+		t.Errorf("case-insensitive FindFilesInDirWithOptions = %v, %v", matches, err)
+	}
+
+	// Through a backend, as the package ReadDir lists it
+	if err := RegisterBackend("globtest", NewMemBackend()); err != nil {
+		t.Fatal(err)
+	}
+	defer UnregisterBackend("globtest")
+	if err := MkdirAll("globtest:/d", 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := WriteFile("globtest:/d/x.log", []byte("x"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if matches, err := FindFilesInDirWithOptions("globtest:/d", "*.log", GlobOptions{}); err != nil || len(matches) != 1 {
+		t.Errorf("FindFilesInDirWithOptions on a backend = %v, %v", matches, err)
+	}
+	if _, err := FindFilesInDirWithOptions(filepath.Join(dir, "missing"), "*", GlobOptions{}); err == nil {
+		t.Error("FindFilesInDirWithOptions on a missing directory succeeded")
+	}
+}
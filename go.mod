@@ -2,4 +2,13 @@ module github.com/inpadi/GMSFSv2
 
 go 1.23.0
 
-require github.com/orcaman/concurrent-map/v2 v2.0.1
+require (
+	github.com/BurntSushi/toml v1.5.0
+	github.com/fsnotify/fsnotify v1.10.1
+	github.com/hanwen/go-fuse/v2 v2.7.2
+	github.com/klauspost/compress v1.18.0
+	github.com/orcaman/concurrent-map/v2 v2.0.1
+	golang.org/x/sys v0.13.0
+	golang.org/x/text v0.21.0
+	gopkg.in/yaml.v3 v3.0.1
+)
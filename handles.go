@@ -0,0 +1,202 @@
+package GMSFS
+
+import (
+	"context"
+	"errors"
+	"os"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	cmap "github.com/orcaman/concurrent-map/v2"
+)
+
+// DefaultAppendIdleTimeout is how long an append handle stays open after its last write: 0,
+// so Append opens and closes the file every time unless SetAppendIdleTimeout turns pooling on
+const DefaultAppendIdleTimeout = time.Duration(0)
+
+var appendHandles = cmap.New[*FileHandleInstance]()
+
+var appendIdleTimeout atomic.Int64
+
+func init() {
+	appendIdleTimeout.Store(int64(DefaultAppendIdleTimeout))
+}
+
+// SetAppendIdleTimeout changes how long Append keeps a file open between writes. Each write
+// checks that the path still names the open file, so files rotated by other processes are
+// reopened. A timeout of 0 or less disables handle reuse and closes all pooled handles.
+func SetAppendIdleTimeout(idle time.Duration) {
+	if idle < 0 {
+		idle = 0
+	}
+	appendIdleTimeout.Store(int64(idle))
+	if idle == 0 {
+		CloseAppendHandles()
+	}
+}
+
+// AppendIdleTimeout returns the current idle period for pooled append handles
+func AppendIdleTimeout() time.Duration {
+	return time.Duration(appendIdleTimeout.Load())
+}
+
+// CloseAppendHandles closes every pooled append handle, e.g. before shutdown
+func CloseAppendHandles() {
+	for _, key := range appendHandles.Keys() {
+		if h, ok := appendHandles.Get(key); ok {
+			h.close(key)
+		}
+	}
+}
+
+// OpenAppendHandles returns the number of files currently held open by the append pool
+func OpenAppendHandles() int {
+	return appendHandles.Count()
+}
+
+// appendKey is the pool key of name, the stat cache's, so relative and absolute names of a
+// file share a handle and closing either closes it
+func appendKey(name string) string {
+	return statCacheKey(name)
+}
+
+// appendAttempts bounds how often appendPooled looks up or reopens a handle for one write
+const appendAttempts = 3
+
+var errAppendHandle = errors.New("append handle keeps closing or changing file")
+
+// appendPooled writes content through a shared handle, reopening it if the idle timer closed it
+// meanwhile or the file at name changed. It gives up with errAppendHandle after appendAttempts.
+func appendPooled(ctx context.Context, name string, content []byte, idle time.Duration, profile *profileEntry) error {
+	key := appendKey(name)
+
+	for attempt := 0; ; attempt++ {
+		if attempt == appendAttempts {
+			errorPrinterCtx(ctx, "Append: "+errAppendHandle.Error(), name)
+			return errAppendHandle
+		}
+
+		h, err := appendHandle(key, name, idle)
+		if err != nil {
+			return err
+		}
+
+		h.mu.Lock()
+		if h.File == nil {
+			// Closed between lookup and lock
+			h.mu.Unlock()
+			continue
+		}
+		if !h.current(name) {
+			// Renamed or removed by another process, e.g. logrotate; the writes belong in
+			// the file now at name
+			h.mu.Unlock()
+			h.close(key)
+			continue
+		}
+		_, err = h.File.Write(content)
+		if err == nil && profile != nil && profile.Durable {
+			err = h.File.Sync()
+		}
+		h.Timer.Reset(idle)
+		h.mu.Unlock()
+
+		if err != nil {
+			errorPrinterCtx(ctx, "Append: "+err.Error(), name)
+		}
+		return err
+	}
+}
+
+func appendHandle(key string, name string, idle time.Duration) (*FileHandleInstance, error) {
+	if h, ok := appendHandles.Get(key); ok {
+		return h, nil
+	}
+
+	// Pooled handles stay open, so they only take descriptors the budget can spare
+	if !tryAcquireFDs(1) {
+		return nil, errFDBudget
+	}
+
+	file, err := os.OpenFile(name, os.O_APPEND|os.O_WRONLY|os.O_CREATE, 0644)
+	if err != nil {
+		releaseFDs(1)
+		return nil, err
+	}
+
+	h := &FileHandleInstance{File: file}
+	h.Timer = time.AfterFunc(idle, func() { h.close(key) })
+
+	if !appendHandles.SetIfAbsent(key, h) {
+		// Another writer opened the same path first
+		h.Timer.Stop()
+		file.Close()
+		releaseFDs(1)
+		if existing, ok := appendHandles.Get(key); ok {
+			return existing, nil
+		}
+		return appendHandle(key, name, idle)
+	}
+
+	return h, nil
+}
+
+// current reports whether the handle is still open on the file at name. Called with h.mu held.
+func (h *FileHandleInstance) current(name string) bool {
+	pathInfo, err := os.Stat(name)
+	if err != nil {
+		return false
+	}
+	fileInfo, err := h.File.Stat()
+	return err == nil && os.SameFile(pathInfo, fileInfo)
+}
+
+// close releases the handle and removes it from the pool if it is still the registered one
+func (h *FileHandleInstance) close(key string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	appendHandles.RemoveCb(key, func(_ string, v *FileHandleInstance, exists bool) bool {
+		return exists && v == h
+	})
+	if h.Timer != nil {
+		h.Timer.Stop()
+	}
+	if h.File != nil {
+		if err := h.File.Close(); err != nil {
+			errorPrinter("Append (Close): "+err.Error(), key)
+		}
+		h.File = nil
+		releaseFDs(1)
+	}
+}
+
+// closeAppendHandle closes a pooled handle before the file is removed or renamed
+func closeAppendHandle(name string) {
+	if appendHandles.IsEmpty() {
+		return
+	}
+
+	key := appendKey(name)
+	if h, ok := appendHandles.Get(key); ok {
+		h.close(key)
+	}
+}
+
+// closeAppendHandlesUnder closes pooled handles for path and everything below it
+func closeAppendHandlesUnder(path string) {
+	if appendHandles.IsEmpty() {
+		return
+	}
+
+	key := appendKey(path)
+	prefix := key + string(os.PathSeparator)
+	for _, k := range appendHandles.Keys() {
+		if k == key || strings.HasPrefix(k, prefix) {
+			if h, ok := appendHandles.Get(k); ok {
+				h.close(k)
+			}
+		}
+	}
+}
@@ -0,0 +1,69 @@
+package GMSFS
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestAppendPool(t *testing.T) {
+	dir := t.TempDir()
+	chdir(t, dir)
+	SetAppendIdleTimeout(time.Minute)
+	t.Cleanup(func() { SetAppendIdleTimeout(0) })
+	name := filepath.Join(dir, "app.log")
+
+	// Relative and absolute names share a handle
+	for _, n := range []string{"app.log", name, "./app.log"} {
+		if err := Append(n, []byte("a")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if n := OpenAppendHandles(); n != 1 {
+		t.Errorf("%d handles open, want 1", n)
+	}
+
+	// A rotated file is left alone and the next write goes to a new one
+	if err := os.Rename(name, name+".1"); err != nil {
+		t.Fatal(err)
+	}
+	if err := Append("app.log", []byte("b")); err != nil {
+		t.Fatal(err)
+	}
+	for n, want := range map[string]string{name: "b", name + ".1": "aaa"} {
+		if data, err := os.ReadFile(n); err != nil || string(data) != want {
+			t.Errorf("%s = %q, %v, want %q", n, data, err, want)
+		}
+	}
+
+	if err := Remove(name); err != nil {
+		t.Fatal(err)
+	}
+	if n := OpenAppendHandles(); n != 0 {
+		t.Errorf("%d handles open after Remove, want 0", n)
+	}
+}
+
+func TestAppendPoolGivesUp(t *testing.T) {
+	SetAppendIdleTimeout(time.Minute)
+	t.Cleanup(func() { SetAppendIdleTimeout(0) })
+	name := filepath.Join(t.TempDir(), "app.log")
+
+	// A registered handle that is never open can't be written through
+	key := appendKey(name)
+	appendHandles.Set(key, &FileHandleInstance{})
+	t.Cleanup(func() { appendHandles.Remove(key) })
+
+	done := make(chan error, 1)
+	go func() { done <- Append(name, []byte("a")) }()
+	select {
+	case err := <-done:
+		if !errors.Is(err, errAppendHandle) {
+			t.Errorf("Append = %v, want errAppendHandle", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Append keeps retrying")
+	}
+}
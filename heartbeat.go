@@ -0,0 +1,126 @@
+package GMSFS
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+)
+
+// Heartbeat rewrites a small status file at a fixed interval until it is stopped, so external
+// watchdogs can check its freshness with FileAgeInSec
+type Heartbeat struct {
+	name     string
+	interval time.Duration
+	started  time.Time
+
+	mu     sync.Mutex
+	fields map[string]string
+
+	done chan struct{}
+	once sync.Once
+	wg   sync.WaitGroup
+}
+
+// heartbeatStatus is the JSON content of a heartbeat file
+type heartbeatStatus struct {
+	Time    time.Time         `json:"time"`
+	Started time.Time         `json:"started"`
+	PID     int               `json:"pid"`
+	Fields  map[string]string `json:"fields,omitempty"`
+}
+
+// WriteHeartbeat writes the status file name now and then every interval, atomically, with the
+// current time, the process id and any fields added with Set. It stops on Stop or Close; the
+// file is left in place so a watchdog sees it go stale.
+func WriteHeartbeat(name string, interval time.Duration) (*Heartbeat, error) {
+	name = cleanPath(name)
+	if interval <= 0 {
+		return nil, fmt.Errorf("heartbeat interval must be positive")
+	}
+
+	h := &Heartbeat{
+		name:     name,
+		interval: interval,
+		started:  time.Now(),
+		fields:   map[string]string{},
+		done:     make(chan struct{}),
+	}
+
+	if err := h.write(); err != nil {
+		errorPrinter("WriteHeartbeat: "+err.Error(), name)
+		return nil, err
+	}
+
+	registerCloser(h)
+	h.wg.Add(1)
+	go h.run()
+	return h, nil
+}
+
+// Set adds or replaces a custom field, written with the next beat
+func (h *Heartbeat) Set(key string, value string) {
+	h.mu.Lock()
+	h.fields[key] = value
+	h.mu.Unlock()
+}
+
+// Delete removes a custom field
+func (h *Heartbeat) Delete(key string) {
+	h.mu.Lock()
+	delete(h.fields, key)
+	h.mu.Unlock()
+}
+
+// Stop ends the heartbeat; the status file keeps its last content
+func (h *Heartbeat) Stop() {
+	h.once.Do(func() {
+		close(h.done)
+		h.wg.Wait()
+		unregisterCloser(h)
+	})
+}
+
+// Close is Stop, so a Heartbeat can be released together with other io.Closers
+func (h *Heartbeat) Close() error {
+	h.Stop()
+	return nil
+}
+
+func (h *Heartbeat) run() {
+	defer h.wg.Done()
+
+	ticker := time.NewTicker(h.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-h.done:
+			return
+		case <-ticker.C:
+			if err := h.write(); err != nil {
+				errorPrinter("Heartbeat: "+err.Error(), h.name)
+			}
+		}
+	}
+}
+
+func (h *Heartbeat) write() error {
+	status := heartbeatStatus{Time: time.Now(), Started: h.started, PID: os.Getpid()}
+
+	h.mu.Lock()
+	if len(h.fields) > 0 {
+		status.Fields = make(map[string]string, len(h.fields))
+		for k, v := range h.fields {
+			status.Fields[k] = v
+		}
+	}
+	h.mu.Unlock()
+
+	content, err := json.MarshalIndent(status, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(h.name, append(content, '\n'), 0644)
+}
@@ -0,0 +1,160 @@
+package GMSFS
+
+import (
+	"fmt"
+	"html"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+)
+
+// HTTPOptions controls how Handler serves a directory tree
+type HTTPOptions struct {
+	Listing      bool   // Render directory listings when no index file exists
+	IndexFile    string // File served for a directory request, e.g. "index.html"
+	HideDotFiles bool   // Refuse to serve or list names starting with "."
+	CacheControl string // Value for the Cache-Control header on files, if set
+}
+
+type httpHandler struct {
+	root string
+	opts HTTPOptions
+}
+
+// Handler serves files below root with Range, ETag and conditional request support.
+// Symlinks are followed as long as they stay below root.
+func Handler(root string, opts HTTPOptions) http.Handler {
+	return &httpHandler{root: cleanPath(root), opts: opts}
+}
+
+func (h *httpHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.Header().Set("Allow", "GET, HEAD")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	urlPath := r.URL.Path
+	if h.opts.HideDotFiles && hasDotSegment(urlPath) {
+		http.NotFound(w, r)
+		return
+	}
+
+	root, err := NewRoot(h.root)
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	name, err := root.Path(strings.TrimPrefix(urlPath, "/"))
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	info, err := Stat(name)
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	if info.IsDir {
+		if !strings.HasSuffix(urlPath, "/") {
+			// Relative, like http.FileServer, so the handler works below http.StripPrefix
+			target := path.Base(urlPath) + "/"
+			if r.URL.RawQuery != "" {
+				target += "?" + r.URL.RawQuery
+			}
+			w.Header().Set("Location", target)
+			w.WriteHeader(http.StatusMovedPermanently)
+			return
+		}
+
+		if h.opts.IndexFile != "" {
+			index, err := root.Path(strings.TrimPrefix(urlPath, "/") + h.opts.IndexFile)
+			if err == nil {
+				if indexInfo, err := Stat(index); err == nil && indexInfo.Mode.IsRegular() {
+					h.serveFile(w, r, index, indexInfo)
+					return
+				}
+			}
+		}
+
+		if !h.opts.Listing {
+			http.Error(w, "forbidden", http.StatusForbidden)
+			return
+		}
+		h.serveListing(w, r, name, urlPath)
+		return
+	}
+
+	if !info.Mode.IsRegular() {
+		http.NotFound(w, r)
+		return
+	}
+	h.serveFile(w, r, name, info)
+}
+
+func (h *httpHandler) serveFile(w http.ResponseWriter, r *http.Request, name string, info FileInfo) {
+	file, err := Open(name)
+	if err != nil {
+		http.Error(w, "internal server error", http.StatusInternalServerError)
+		return
+	}
+	defer file.Close()
+
+	w.Header().Set("ETag", fileETag(info))
+	if h.opts.CacheControl != "" {
+		w.Header().Set("Cache-Control", h.opts.CacheControl)
+	}
+	http.ServeContent(w, r, info.Name, info.LastModified, file)
+}
+
+func (h *httpHandler) serveListing(w http.ResponseWriter, r *http.Request, name string, urlPath string) {
+	entries, err := ReadDir(name)
+	if err != nil {
+		errorPrinter("Handler (ReadDir): "+err.Error(), name)
+		http.Error(w, "internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if r.Method == http.MethodHead {
+		return
+	}
+
+	title := html.EscapeString(urlPath)
+	var b strings.Builder
+	fmt.Fprintf(&b, "<!doctype html>\n<html><head><meta charset=\"utf-8\"><title>Index of %s</title></head><body>\n", title)
+	fmt.Fprintf(&b, "<h1>Index of %s</h1>\n<table>\n", title)
+	if urlPath != "/" {
+		b.WriteString("<tr><td><a href=\"../\">../</a></td><td></td><td></td></tr>\n")
+	}
+	for _, entry := range entries {
+		if h.opts.HideDotFiles && strings.HasPrefix(entry.Name, ".") {
+			continue
+		}
+
+		display := entry.Name
+		size := fmt.Sprint(entry.Size)
+		if entry.IsDir {
+			display += "/"
+			size = "-"
+		}
+		link := (&url.URL{Path: display}).String()
+		fmt.Fprintf(&b, "<tr><td><a href=\"%s\">%s</a></td><td>%s</td><td>%s</td></tr>\n",
+			html.EscapeString(link), html.EscapeString(display), size, entry.LastModified.Format("2006-01-02 15:04:05"))
+	}
+	b.WriteString("</table>\n</body></html>\n")
+
+	w.Write([]byte(b.String()))
+}
+
+func hasDotSegment(urlPath string) bool {
+	for _, segment := range strings.Split(urlPath, "/") {
+		if strings.HasPrefix(segment, ".") && segment != "." && segment != ".." {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,138 @@
+package GMSFS
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// newTestHandler serves a small tree with opts; outside is a directory next to it the handler
+// must not reach
+func newTestHandler(t *testing.T, opts HTTPOptions) (h http.Handler, root string, outside string) {
+	t.Helper()
+	base := t.TempDir()
+	root = filepath.Join(base, "root")
+	outside = filepath.Join(base, "outside")
+	writeTestTree(t, root, map[string]string{"a.txt": "alpha", "docs/index.html": "index", "docs/b.txt": "b", "empty/.keep": "", ".hidden": "h"})
+	writeTestTree(t, outside, map[string]string{"secret.txt": "secret"})
+	return Handler(root, opts), root, outside
+}
+
+// serve runs a request against h and returns the recorded response
+func serve(h http.Handler, method string, target string, header map[string]string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, target, nil)
+	for k, v := range header {
+		req.Header.Set(k, v)
+	}
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestHandlerServesFiles(t *testing.T) {
+	h, _, _ := newTestHandler(t, HTTPOptions{CacheControl: "max-age=60"})
+
+	rec := serve(h, http.MethodGet, "/a.txt", nil)
+	if rec.Code != http.StatusOK || rec.Body.String() != "alpha" {
+		t.Fatalf("GET = %d %q", rec.Code, rec.Body)
+	}
+	if rec.Header().Get("Cache-Control") != "max-age=60" {
+		t.Errorf("Cache-Control = %q", rec.Header().Get("Cache-Control"))
+	}
+	etag := rec.Header().Get("ETag")
+	if etag == "" {
+		t.Fatal("no ETag")
+	}
+
+	if rec := serve(h, http.MethodGet, "/a.txt", map[string]string{"If-None-Match": etag}); rec.Code != http.StatusNotModified {
+		t.Errorf("conditional GET = %d, want 304", rec.Code)
+	}
+	if rec := serve(h, http.MethodGet, "/a.txt", map[string]string{"Range": "bytes=1-3"}); rec.Code != http.StatusPartialContent || rec.Body.String() != "lph" {
+		t.Errorf("range GET = %d %q", rec.Code, rec.Body)
+	}
+	if rec := serve(h, http.MethodHead, "/a.txt", nil); rec.Code != http.StatusOK || rec.Body.Len() != 0 {
+		t.Errorf("HEAD = %d %q", rec.Code, rec.Body)
+	}
+	if rec := serve(h, http.MethodPost, "/a.txt", nil); rec.Code != http.StatusMethodNotAllowed || rec.Header().Get("Allow") != "GET, HEAD" {
+		t.Errorf("POST = %d, Allow %q", rec.Code, rec.Header().Get("Allow"))
+	}
+	if rec := serve(h, http.MethodGet, "/missing", nil); rec.Code != http.StatusNotFound {
+		t.Errorf("GET missing = %d", rec.Code)
+	}
+}
+
+func TestHandlerDirectories(t *testing.T) {
+	h, _, _ := newTestHandler(t, HTTPOptions{IndexFile: "index.html"})
+
+	if rec := serve(h, http.MethodGet, "/docs/", nil); rec.Code != http.StatusOK || rec.Body.String() != "index" {
+		t.Errorf("GET /docs/ = %d %q", rec.Code, rec.Body)
+	}
+	if rec := serve(h, http.MethodGet, "/empty/", nil); rec.Code != http.StatusForbidden {
+		t.Errorf("GET without listing = %d, want 403", rec.Code)
+	}
+
+	h, _, _ = newTestHandler(t, HTTPOptions{Listing: true, HideDotFiles: true})
+	rec := serve(h, http.MethodGet, "/", nil)
+	if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), `href="docs/"`) || strings.Contains(rec.Body.String(), ".hidden") {
+		t.Errorf("listing = %d %s", rec.Code, rec.Body)
+	}
+	if rec := serve(h, http.MethodGet, "/.hidden", nil); rec.Code != http.StatusNotFound {
+		t.Errorf("GET dot file = %d, want 404", rec.Code)
+	}
+}
+
+func TestHandlerRedirectsRelative(t *testing.T) {
+	h, _, _ := newTestHandler(t, HTTPOptions{Listing: true})
+
+	rec := serve(h, http.MethodGet, "/docs?x=1", nil)
+	if rec.Code != http.StatusMovedPermanently || rec.Header().Get("Location") != "docs/?x=1" {
+		t.Errorf("redirect = %d %q", rec.Code, rec.Header().Get("Location"))
+	}
+
+	// Below StripPrefix the redirect must keep the prefix, which a relative one does
+	mux := http.NewServeMux()
+	mux.Handle("/files/", http.StripPrefix("/files", h))
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	resp, err := http.Get(srv.URL + "/files/docs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || resp.Request.URL.Path != "/files/docs/" || !strings.Contains(string(body), "b.txt") {
+		t.Errorf("GET /files/docs = %d at %s", resp.StatusCode, resp.Request.URL.Path)
+	}
+}
+
+func TestHandlerRefusesSymlinkEscapes(t *testing.T) {
+	h, root, outside := newTestHandler(t, HTTPOptions{Listing: true, IndexFile: "index.html"})
+	requireSymlinks(t)
+	for link, target := range map[string]string{
+		"out":              outside,
+		"secret.txt":       filepath.Join(outside, "secret.txt"),
+		"alias.txt":        "a.txt",
+		"empty/index.html": filepath.Join(outside, "secret.txt"),
+	} {
+		if err := os.Symlink(target, filepath.Join(root, filepath.FromSlash(link))); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, target := range []string{"/out/secret.txt", "/secret.txt", "/../outside/secret.txt", "/out/"} {
+		if rec := serve(h, http.MethodGet, target, nil); rec.Code != http.StatusNotFound {
+			t.Errorf("GET %s = %d, want 404", target, rec.Code)
+		}
+	}
+	if rec := serve(h, http.MethodGet, "/empty/", nil); strings.Contains(rec.Body.String(), "secret") {
+		t.Errorf("index file through a link leaving the root served: %q", rec.Body)
+	}
+	if rec := serve(h, http.MethodGet, "/alias.txt", nil); rec.Code != http.StatusOK || rec.Body.String() != "alpha" {
+		t.Errorf("GET through a link inside the root = %d %q", rec.Code, rec.Body)
+	}
+}
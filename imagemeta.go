@@ -0,0 +1,172 @@
+package GMSFS
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+)
+
+var (
+	jpegMagic = []byte{0xFF, 0xD8}
+	pngMagic  = []byte{0x89, 'P', 'N', 'G', '\r', '\n', 0x1A, '\n'}
+)
+
+// PNG chunks that carry EXIF, free text or timestamps
+var pngMetadataChunks = map[string]bool{"eXIf": true, "tEXt": true, "zTXt": true, "iTXt": true, "tIME": true}
+
+// StripImageMetadataTransform removes EXIF, XMP, IPTC and comment metadata (including GPS
+// positions) from JPEG and PNG content and passes any other content through unchanged.
+// Register it for copies with RegisterTransform("image/jpeg", StripImageMetadataTransform).
+// Stripping EXIF also drops the orientation tag.
+func StripImageMetadataTransform(src string, r io.Reader, w io.Writer) error {
+	br := bufio.NewReader(r)
+	head, _ := br.Peek(len(pngMagic))
+
+	switch {
+	case bytes.HasPrefix(head, pngMagic):
+		return stripPNGMetadata(br, w)
+	case bytes.HasPrefix(head, jpegMagic):
+		return stripJPEGMetadata(br, w)
+	}
+
+	_, err := io.Copy(w, br)
+	return err
+}
+
+// StripImageMetadata rewrites a JPEG or PNG file in place without its metadata
+func StripImageMetadata(name string) error {
+	name = cleanPath(name)
+
+	in, err := os.Open(name)
+	if err != nil {
+		errorPrinter("StripImageMetadata (os.Open): "+err.Error(), name)
+		return err
+	}
+	defer in.Close()
+
+	si, err := in.Stat()
+	if err != nil {
+		return err
+	}
+
+	err = writeAtomic(name, si.Mode(), func(w io.Writer) error {
+		err := StripImageMetadataTransform(name, in, w)
+		// Windows cannot replace a file that is still open
+		in.Close()
+		return err
+	})
+	if err != nil {
+		errorPrinter("StripImageMetadata: "+err.Error(), name)
+	}
+	return err
+}
+
+func stripJPEGMetadata(r *bufio.Reader, w io.Writer) error {
+	bw := bufio.NewWriter(w)
+
+	soi := make([]byte, 2)
+	if _, err := io.ReadFull(r, soi); err != nil {
+		return err
+	}
+	bw.Write(soi)
+
+	for {
+		b, err := r.ReadByte()
+		if err != nil {
+			return fmt.Errorf("jpeg: unexpected end before image data")
+		}
+		if b != 0xFF {
+			return fmt.Errorf("jpeg: invalid marker")
+		}
+
+		marker, err := r.ReadByte()
+		for err == nil && marker == 0xFF {
+			// Fill bytes between segments
+			marker, err = r.ReadByte()
+		}
+		if err != nil {
+			return fmt.Errorf("jpeg: unexpected end before image data")
+		}
+
+		// Markers without a length field
+		if marker == 0x01 || (marker >= 0xD0 && marker <= 0xD9) {
+			bw.Write([]byte{0xFF, marker})
+			if marker == 0xD9 {
+				return bw.Flush()
+			}
+			continue
+		}
+
+		var size [2]byte
+		if _, err := io.ReadFull(r, size[:]); err != nil {
+			return err
+		}
+		length := int64(binary.BigEndian.Uint16(size[:]))
+		if length < 2 {
+			return fmt.Errorf("jpeg: invalid segment length")
+		}
+
+		// APP1 (EXIF/XMP), APP13 (IPTC) and COM segments are dropped
+		if marker == 0xE1 || marker == 0xED || marker == 0xFE {
+			if _, err := io.CopyN(io.Discard, r, length-2); err != nil {
+				return err
+			}
+			continue
+		}
+
+		bw.Write([]byte{0xFF, marker})
+		bw.Write(size[:])
+		if _, err := io.CopyN(bw, r, length-2); err != nil {
+			return err
+		}
+
+		// Start of scan: the rest is entropy-coded image data
+		if marker == 0xDA {
+			if _, err := io.Copy(bw, r); err != nil {
+				return err
+			}
+			return bw.Flush()
+		}
+	}
+}
+
+func stripPNGMetadata(r *bufio.Reader, w io.Writer) error {
+	bw := bufio.NewWriter(w)
+
+	sig := make([]byte, len(pngMagic))
+	if _, err := io.ReadFull(r, sig); err != nil {
+		return err
+	}
+	bw.Write(sig)
+
+	var header [8]byte
+	for {
+		if _, err := io.ReadFull(r, header[:]); err != nil {
+			if err == io.EOF {
+				return bw.Flush()
+			}
+			return err
+		}
+		length := int64(binary.BigEndian.Uint32(header[:4]))
+		chunk := string(header[4:8])
+
+		// Chunk data plus its CRC
+		if pngMetadataChunks[chunk] {
+			if _, err := io.CopyN(io.Discard, r, length+4); err != nil {
+				return err
+			}
+			continue
+		}
+
+		bw.Write(header[:])
+		if _, err := io.CopyN(bw, r, length+4); err != nil {
+			return err
+		}
+		if chunk == "IEND" {
+			return bw.Flush()
+		}
+	}
+}
@@ -0,0 +1,488 @@
+package GMSFS
+
+import (
+	"cmp"
+	"compress/gzip"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultIndexSaveInterval is how often an index with changes is saved
+const DefaultIndexSaveInterval = time.Minute
+
+// indexVersion is bumped when the saved format changes, which makes OpenIndex rebuild
+const indexVersion = 1
+
+// ErrNoIndex is returned by index functions for a path no open index covers
+var ErrNoIndex = errors.New("no index open for path")
+
+// IndexOptions configures OpenIndex
+type IndexOptions struct {
+	// File is where the index is saved between runs. It defaults to a file named after root
+	// in the user cache directory, outside the tree so saving it is no change to index.
+	File         string
+	SaveInterval time.Duration // Save changes this often; defaults to DefaultIndexSaveInterval
+	Rebuild      bool          // Walk root even when a saved index exists
+	Repair       bool          // After loading a saved index, run RebuildIndex in the background
+	Poll         bool          // Follow changes by polling, see WatchOptions
+}
+
+// IndexEntry is a file or directory found by QueryIndex
+type IndexEntry struct {
+	Path    string
+	Size    int64
+	ModTime time.Time
+	IsDir   bool
+}
+
+// IndexQuery selects entries of an index. Zero fields match everything; set fields must all
+// match.
+type IndexQuery struct {
+	Name           string    // Substring of the base name, compared case-insensitively
+	Ext            string    // Extension such as ".jpg", compared case-insensitively
+	MinSize        int64     // Smallest size in bytes
+	MaxSize        int64     // Largest size in bytes; 0 is no limit
+	ModifiedAfter  time.Time // Only entries modified after this time
+	ModifiedBefore time.Time // Only entries modified before this time
+	Dirs           bool      // Return directories instead of files
+	Limit          int       // Return at most this many entries, the first ones by path; 0 is no limit
+}
+
+// index is the in-memory index of a tree, keyed by slash-separated path relative to root
+type index struct {
+	root string
+	file string
+
+	mu      sync.RWMutex
+	entries map[string]*indexEntry
+	built   time.Time
+	dirty   bool
+
+	watcher *Watcher
+	stop    chan struct{}
+	done    chan struct{}
+	once    sync.Once
+}
+
+// indexEntry is kept small, as an index may hold millions
+type indexEntry struct {
+	name  string // Lower-cased base name
+	size  int64
+	mtime int64 // Unix nanoseconds
+	dir   bool
+}
+
+// indexFile is the saved form of an index
+type indexFile struct {
+	Version int
+	Root    string
+	Built   time.Time
+	Records []indexRecord
+}
+
+type indexRecord struct {
+	Path  string
+	Size  int64
+	Mtime int64
+	Dir   bool
+}
+
+var (
+	indexesMu sync.Mutex
+	indexes   = map[string]*index{}
+)
+
+// OpenIndex indexes the tree at root, so QueryIndex can search it by name, extension, size
+// and modification time without walking it. The index is loaded from its file when one was
+// saved, or built by walking root, and then follows changes through a Watcher until
+// CloseIndex. Changes made while no index was open are only seen after RebuildIndex, which
+// the Repair option runs in the background after loading.
+func OpenIndex(root string, opts IndexOptions) error {
+	root, err := filepath.Abs(cleanPath(root))
+	if err != nil {
+		return err
+	}
+	if err := requireLocal("index", root); err != nil {
+		errorPrinter("OpenIndex: "+err.Error(), root)
+		return err
+	}
+
+	if indexed(root) {
+		return fmt.Errorf("OpenIndex: %s is already indexed", root)
+	}
+
+	x := &index{root: root, file: opts.File, stop: make(chan struct{}), done: make(chan struct{})}
+	if x.file == "" {
+		if x.file, err = defaultIndexFile(root); err != nil {
+			errorPrinter("OpenIndex: "+err.Error(), root)
+			return err
+		}
+	}
+	x.file = cleanPath(x.file)
+
+	// Watch first, so changes made while loading or building queue up instead of being missed
+	x.watcher, err = Watch(root, WatchOptions{Recursive: true, Debounce: 100 * time.Millisecond, Poll: opts.Poll})
+	if err != nil {
+		errorPrinter("OpenIndex: "+err.Error(), root)
+		return err
+	}
+
+	loaded := false
+	if !opts.Rebuild {
+		loaded, err = x.load()
+		if err != nil {
+			warnPrinter("OpenIndex: rebuilding unreadable index: "+err.Error(), x.file)
+		}
+	}
+	if !loaded {
+		if err := x.build(); err != nil {
+			x.watcher.Close()
+			errorPrinter("OpenIndex: "+err.Error(), root)
+			return err
+		}
+	}
+
+	indexesMu.Lock()
+	if _, ok := indexes[root]; ok {
+		indexesMu.Unlock()
+		x.watcher.Close()
+		return fmt.Errorf("OpenIndex: %s is already indexed", root)
+	}
+	indexes[root] = x
+	indexesMu.Unlock()
+	registerCloser(x)
+	go x.run(cmp.Or(opts.SaveInterval, DefaultIndexSaveInterval))
+
+	if loaded && opts.Repair {
+		go func() {
+			if stats, err := x.reconcile(".", true); err != nil {
+				warnPrinter("OpenIndex (repair): "+err.Error(), root)
+			} else if stats.Drift() > 0 {
+				infoPrinter("OpenIndex: repaired "+stats.String(), root)
+			}
+		}()
+	}
+	return nil
+}
+
+func indexed(root string) bool {
+	indexesMu.Lock()
+	defer indexesMu.Unlock()
+	_, ok := indexes[root]
+	return ok
+}
+
+// CloseIndex stops following changes under root and saves its index
+func CloseIndex(root string) error {
+	root, err := filepath.Abs(cleanPath(root))
+	if err != nil {
+		return err
+	}
+
+	indexesMu.Lock()
+	x, ok := indexes[root]
+	indexesMu.Unlock()
+	if !ok {
+		return &os.PathError{Op: "index", Path: root, Err: ErrNoIndex}
+	}
+	return x.Close()
+}
+
+// QueryIndex returns the entries below name matching q, sorted by path, from the open index
+// covering name
+func QueryIndex(name string, q IndexQuery) ([]IndexEntry, error) {
+	x, rel, err := indexFor(name)
+	if err != nil {
+		errorPrinter("QueryIndex: "+err.Error(), name)
+		return nil, err
+	}
+
+	sub := strings.ToLower(q.Name)
+	ext := strings.ToLower(q.Ext)
+	if ext != "" && !strings.HasPrefix(ext, ".") {
+		ext = "." + ext
+	}
+	var after, before int64
+	if !q.ModifiedAfter.IsZero() {
+		after = q.ModifiedAfter.UnixNano()
+	}
+	if !q.ModifiedBefore.IsZero() {
+		before = q.ModifiedBefore.UnixNano()
+	}
+	prefix := ""
+	if rel != "." {
+		prefix = rel + "/"
+	}
+
+	x.mu.RLock()
+	var keys []string
+	for key, e := range x.entries {
+		switch {
+		case e.dir != q.Dirs,
+			prefix != "" && !strings.HasPrefix(key, prefix),
+			e.size < q.MinSize,
+			q.MaxSize > 0 && e.size > q.MaxSize,
+			after != 0 && e.mtime <= after,
+			before != 0 && e.mtime >= before,
+			ext != "" && filepath.Ext(e.name) != ext,
+			sub != "" && !strings.Contains(e.name, sub):
+			continue
+		}
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	if q.Limit > 0 && len(keys) > q.Limit {
+		keys = keys[:q.Limit]
+	}
+
+	found := make([]IndexEntry, len(keys))
+	for i, key := range keys {
+		e := x.entries[key]
+		found[i] = IndexEntry{
+			Path:    filepath.Join(x.root, filepath.FromSlash(key)),
+			Size:    e.size,
+			ModTime: time.Unix(0, e.mtime),
+			IsDir:   e.dir,
+		}
+	}
+	x.mu.RUnlock()
+	return found, nil
+}
+
+// indexFor returns the open index with the longest root containing name and the slash path
+// of name below it
+func indexFor(name string) (*index, string, error) {
+	abs, err := filepath.Abs(cleanPath(name))
+	if err != nil {
+		return nil, "", err
+	}
+
+	indexesMu.Lock()
+	defer indexesMu.Unlock()
+
+	var best *index
+	var bestRel string
+	for root, x := range indexes {
+		if rel, ok := relWithin(root, abs); ok && (best == nil || len(root) > len(best.root)) {
+			best, bestRel = x, filepath.ToSlash(rel)
+		}
+	}
+	if best == nil {
+		return nil, "", &os.PathError{Op: "index", Path: name, Err: ErrNoIndex}
+	}
+	return best, bestRel, nil
+}
+
+// defaultIndexFile names the saved index of root in the user cache directory
+func defaultIndexFile(root string) (string, error) {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		dir = os.TempDir()
+	}
+	dir = filepath.Join(dir, "gmsfs", "index")
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return "", err
+	}
+
+	h := fnv.New64a()
+	io.WriteString(h, root)
+	return filepath.Join(dir, fmt.Sprintf("%s-%016x.idx", filepath.Base(root), h.Sum64())), nil
+}
+
+// build walks root into a new set of entries
+func (x *index) build() error {
+	entries := map[string]*indexEntry{}
+	err := Walk(x.root, func(p string, info FileInfo) error {
+		if p == x.root || x.skip(p) {
+			return nil
+		}
+		rel, _ := relWithin(x.root, p)
+		entries[filepath.ToSlash(rel)] = newIndexEntry(info.Name, info.Size, info.LastModified, info.IsDir)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	x.mu.Lock()
+	x.entries = entries
+	x.built = time.Now()
+	x.dirty = true
+	x.mu.Unlock()
+	return nil
+}
+
+func newIndexEntry(name string, size int64, mtime time.Time, dir bool) *indexEntry {
+	if dir {
+		size = 0
+	}
+	return &indexEntry{name: strings.ToLower(name), size: size, mtime: mtime.UnixNano(), dir: dir}
+}
+
+// skip reports whether p is the saved index itself or one of its temporary files, for an
+// index file kept inside the tree
+func (x *index) skip(p string) bool {
+	if filepath.Dir(p) != filepath.Dir(x.file) {
+		return false
+	}
+	base := filepath.Base(p)
+	return base == filepath.Base(x.file) || strings.HasPrefix(base, "."+filepath.Base(x.file)+".tmp")
+}
+
+// load reads the saved index, reporting false when there is none for this root
+func (x *index) load() (bool, error) {
+	f, err := os.Open(x.file)
+	if os.IsNotExist(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	defer f.Close()
+
+	zr, err := gzip.NewReader(f)
+	if err != nil {
+		return false, err
+	}
+	var saved indexFile
+	if err := gob.NewDecoder(zr).Decode(&saved); err != nil {
+		return false, err
+	}
+	if saved.Version != indexVersion || saved.Root != x.root {
+		return false, nil
+	}
+
+	entries := make(map[string]*indexEntry, len(saved.Records))
+	for _, r := range saved.Records {
+		entries[r.Path] = &indexEntry{name: strings.ToLower(pathBase(r.Path)), size: r.Size, mtime: r.Mtime, dir: r.Dir}
+	}
+
+	x.mu.Lock()
+	x.entries = entries
+	x.built = saved.Built
+	x.mu.Unlock()
+	return true, nil
+}
+
+// pathBase is the last element of a slash path
+func pathBase(p string) string {
+	return p[strings.LastIndexByte(p, '/')+1:]
+}
+
+// save writes the index to its file if it changed
+func (x *index) save() error {
+	x.mu.Lock()
+	if !x.dirty {
+		x.mu.Unlock()
+		return nil
+	}
+	saved := indexFile{Version: indexVersion, Root: x.root, Built: x.built, Records: make([]indexRecord, 0, len(x.entries))}
+	for key, e := range x.entries {
+		saved.Records = append(saved.Records, indexRecord{Path: key, Size: e.size, Mtime: e.mtime, Dir: e.dir})
+	}
+	x.dirty = false
+	x.mu.Unlock()
+
+	err := writeAtomic(x.file, 0644, func(w io.Writer) error {
+		zw, err := gzip.NewWriterLevel(w, gzip.BestSpeed)
+		if err != nil {
+			return err
+		}
+		if err := gob.NewEncoder(zw).Encode(saved); err != nil {
+			return err
+		}
+		return zw.Close()
+	})
+	if err != nil {
+		x.mu.Lock()
+		x.dirty = true
+		x.mu.Unlock()
+		errorPrinter("Index (save): "+err.Error(), x.file)
+	}
+	return err
+}
+
+// run applies watcher events and saves the index periodically until Close
+func (x *index) run(interval time.Duration) {
+	defer close(x.done)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-x.stop:
+			return
+		case ev, ok := <-x.watcher.Events:
+			if !ok {
+				return
+			}
+			x.update(ev.Path)
+			if ev.Op.Has(EventCreate | EventRemove | EventRename) {
+				// Entries coming and going change the directory's modification time
+				x.update(filepath.Dir(ev.Path))
+			}
+		case err, ok := <-x.watcher.Errors:
+			if ok {
+				warnPrinter("Index: "+err.Error(), x.root)
+			}
+		case <-ticker.C:
+			x.save()
+		}
+	}
+}
+
+// update brings the entry of p in line with the disk
+func (x *index) update(p string) {
+	rel, ok := relWithin(x.root, p)
+	if !ok || rel == "." || x.skip(p) {
+		return
+	}
+	key := filepath.ToSlash(rel)
+	info, err := os.Lstat(p)
+
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	x.dirty = true
+
+	if err == nil {
+		x.entries[key] = newIndexEntry(info.Name(), info.Size(), info.ModTime(), info.IsDir())
+		return
+	}
+
+	old, ok := x.entries[key]
+	delete(x.entries, key)
+	if ok && old.dir {
+		prefix := key + "/"
+		for k := range x.entries {
+			if strings.HasPrefix(k, prefix) {
+				delete(x.entries, k)
+			}
+		}
+	}
+}
+
+// Close stops following changes and saves the index
+func (x *index) Close() error {
+	var err error
+	x.once.Do(func() {
+		indexesMu.Lock()
+		delete(indexes, x.root)
+		indexesMu.Unlock()
+		unregisterCloser(x)
+
+		close(x.stop)
+		<-x.done
+		x.watcher.Close()
+		err = x.save()
+	})
+	return err
+}
@@ -0,0 +1,149 @@
+package GMSFS
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// IndexStats is what CheckIndex found, or RebuildIndex found and repaired
+type IndexStats struct {
+	Checked  int           // Entries on disk compared with the index
+	Added    int           // On disk but missing from the index
+	Removed  int           // In the index but gone from disk
+	Updated  int           // In both, with a different size, modification time or type
+	Duration time.Duration // Time taken
+}
+
+// Drift returns the number of entries the index had wrong
+func (s IndexStats) Drift() int {
+	return s.Added + s.Removed + s.Updated
+}
+
+func (s IndexStats) String() string {
+	return fmt.Sprintf("%d checked, %d added, %d removed, %d updated in %s", s.Checked, s.Added, s.Removed, s.Updated, s.Duration.Round(time.Millisecond))
+}
+
+// CheckIndex compares the open index covering root with the tree below root on disk and
+// reports the drift, e.g. from changes made while the index was closed or missed by its
+// watcher, without changing the index
+func CheckIndex(root string) (IndexStats, error) {
+	x, rel, err := indexFor(root)
+	if err != nil {
+		errorPrinter("CheckIndex: "+err.Error(), root)
+		return IndexStats{}, err
+	}
+
+	stats, err := x.reconcile(rel, false)
+	if err != nil {
+		errorPrinter("CheckIndex: "+err.Error(), root)
+	}
+	return stats, err
+}
+
+// RebuildIndex is CheckIndex repairing the drift as it goes. Only the entries that differ
+// are replaced, so queries keep being answered during a rebuild of a large tree.
+func RebuildIndex(root string) (IndexStats, error) {
+	x, rel, err := indexFor(root)
+	if err != nil {
+		errorPrinter("RebuildIndex: "+err.Error(), root)
+		return IndexStats{}, err
+	}
+
+	stats, err := x.reconcile(rel, true)
+	if err != nil {
+		errorPrinter("RebuildIndex: "+err.Error(), root)
+		return stats, err
+	}
+	if rel == "." {
+		x.mu.Lock()
+		x.built = time.Now()
+		x.mu.Unlock()
+	}
+	if stats.Drift() > 0 {
+		infoPrinter("RebuildIndex: "+stats.String(), root)
+	}
+	return stats, nil
+}
+
+// reconcile walks the slash path rel below the index root, counting the entries that differ
+// from the index and fixing them when repair is set
+func (x *index) reconcile(rel string, repair bool) (IndexStats, error) {
+	start := time.Now()
+	var stats IndexStats
+
+	prefix := ""
+	if rel != "." {
+		prefix = rel + "/"
+	}
+	top := filepath.Join(x.root, filepath.FromSlash(rel))
+	seen := map[string]struct{}{}
+
+	_, err := os.Lstat(top)
+	if err == nil {
+		err = Walk(top, func(p string, info FileInfo) error {
+			if p == x.root || x.skip(p) {
+				return nil
+			}
+			r, _ := relWithin(x.root, p)
+			key := filepath.ToSlash(r)
+			seen[key] = struct{}{}
+			stats.Checked++
+
+			disk := newIndexEntry(info.Name, info.Size, info.LastModified, info.IsDir)
+			x.mu.RLock()
+			e, ok := x.entries[key]
+			x.mu.RUnlock()
+			switch {
+			case !ok:
+				stats.Added++
+			case *e != *disk:
+				stats.Updated++
+			default:
+				return nil
+			}
+
+			if repair {
+				x.mu.Lock()
+				x.entries[key] = disk
+				x.dirty = true
+				x.mu.Unlock()
+			}
+			return nil
+		})
+	} else if os.IsNotExist(err) {
+		err = nil // Everything indexed below top is gone
+	}
+	if err != nil {
+		return stats, err
+	}
+
+	if repair {
+		x.mu.Lock()
+	} else {
+		x.mu.RLock()
+	}
+	for key := range x.entries {
+		if key != rel && !strings.HasPrefix(key, prefix) {
+			continue
+		}
+		if _, ok := seen[key]; ok {
+			continue
+		}
+		stats.Removed++
+		if repair {
+			delete(x.entries, key)
+			x.dirty = true
+		}
+	}
+	if repair {
+		x.mu.Unlock()
+	} else {
+		x.mu.RUnlock()
+	}
+
+	stats.Duration = time.Since(start)
+	return stats, nil
+}
@@ -0,0 +1,119 @@
+package GMSFS
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// IOFS exposes a directory through the io/fs interfaces (fs.FS, fs.ReadDirFS, fs.StatFS,
+// fs.GlobFS and fs.ReadFileFS), backed by the package's own operations and stat cache.
+type IOFS struct {
+	root string
+}
+
+// NewIOFS returns an fs.FS rooted at root
+func NewIOFS(root string) *IOFS {
+	return &IOFS{root: cleanPath(root)}
+}
+
+// fsFileInfo adapts FileInfo to fs.FileInfo, whose method names clash with FileInfo's fields
+type fsFileInfo struct {
+	info FileInfo
+}
+
+func (fi fsFileInfo) Name() string       { return fi.info.Name }
+func (fi fsFileInfo) Size() int64        { return fi.info.Size }
+func (fi fsFileInfo) Mode() fs.FileMode  { return fi.info.Mode }
+func (fi fsFileInfo) ModTime() time.Time { return fi.info.LastModified }
+func (fi fsFileInfo) IsDir() bool        { return fi.info.IsDir }
+func (fi fsFileInfo) Sys() any           { return nil }
+
+func (f *IOFS) path(op string, name string) (string, error) {
+	if !fs.ValidPath(name) {
+		return "", &fs.PathError{Op: op, Path: name, Err: fs.ErrInvalid}
+	}
+	return filepath.Join(f.root, filepath.FromSlash(name)), nil
+}
+
+// fsError rewrites an error so it reports the fs-relative name instead of the local path
+func fsError(op string, name string, err error) error {
+	var pathErr *fs.PathError
+	if errors.As(err, &pathErr) {
+		err = pathErr.Err
+	}
+	return &fs.PathError{Op: op, Path: name, Err: err}
+}
+
+func (f *IOFS) Open(name string) (fs.File, error) {
+	full, err := f.path("open", name)
+	if err != nil {
+		return nil, err
+	}
+
+	file, err := OpenHandle(full, os.O_RDONLY, 0)
+	if err != nil {
+		return nil, fsError("open", name, err)
+	}
+	return file, nil
+}
+
+func (f *IOFS) ReadDir(name string) ([]fs.DirEntry, error) {
+	full, err := f.path("readdir", name)
+	if err != nil {
+		return nil, err
+	}
+
+	infos, err := ReadDir(full)
+	if err != nil {
+		return nil, fsError("readdir", name, err)
+	}
+
+	entries := make([]fs.DirEntry, 0, len(infos))
+	for _, info := range infos {
+		entries = append(entries, fs.FileInfoToDirEntry(fsFileInfo{info: info}))
+	}
+	return entries, nil
+}
+
+func (f *IOFS) Stat(name string) (fs.FileInfo, error) {
+	full, err := f.path("stat", name)
+	if err != nil {
+		return nil, err
+	}
+
+	info, err := Stat(full)
+	if err != nil {
+		return nil, fsError("stat", name, err)
+	}
+	if name == "." {
+		info.Name = "."
+	}
+	return fsFileInfo{info: info}, nil
+}
+
+func (f *IOFS) ReadFile(name string) ([]byte, error) {
+	full, err := f.path("readfile", name)
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := ReadFile(full)
+	if err != nil {
+		return nil, fsError("readfile", name, err)
+	}
+	return data, nil
+}
+
+// ioFSWalker hides IOFS's Glob so fs.Glob walks it with ReadDir instead of calling back
+type ioFSWalker struct {
+	fs.ReadDirFS
+}
+
+// Glob matches with path.Match semantics, as fs.GlobFS requires, rather than the package
+// Glob's brace and case options
+func (f *IOFS) Glob(pattern string) ([]string, error) {
+	return fs.Glob(ioFSWalker{f}, pattern)
+}
@@ -0,0 +1,68 @@
+package GMSFS
+
+import (
+	"errors"
+	"io/fs"
+	"path"
+	"strings"
+	"testing"
+	"testing/fstest"
+)
+
+func TestIOFS(t *testing.T) {
+	dir := t.TempDir()
+	writeTestTree(t, dir, map[string]string{"a.txt": "alpha", "b.log": "b", "sub/c.txt": "c", "{a,b}.txt": "brace"})
+	fsys := NewIOFS(dir)
+	if err := fstest.TestFS(fsys, "a.txt", "b.log", "sub/c.txt", "{a,b}.txt"); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		pattern string
+		want    string
+	}{
+		{"*.txt", "a.txt,{a,b}.txt"},
+		{"{a,b}.txt", "{a,b}.txt"},
+		{"A.TXT", ""},
+		{"sub/*", "sub/c.txt"},
+	}
+	for _, tt := range tests {
+		matches, err := fs.Glob(fsys, tt.pattern)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := strings.Join(matches, ","); got != tt.want {
+			t.Errorf("Glob(%q) = %s, want %s", tt.pattern, got, tt.want)
+		}
+	}
+	if _, err := fsys.Glob("[x"); !errors.Is(err, path.ErrBadPattern) {
+		t.Errorf("Glob with a bad pattern = %v", err)
+	}
+
+	for _, name := range []string{"../x", "/a.txt", "missing"} {
+		if _, err := fsys.Open(name); err == nil {
+			t.Errorf("Open(%q) succeeded", name)
+		}
+	}
+	if _, err := fsys.Open("missing"); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("Open missing = %v", err)
+	}
+}
+
+func TestIOFSBackend(t *testing.T) {
+	if err := RegisterBackend("iofstest", NewMemBackend()); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { UnregisterBackend("iofstest") })
+	if err := MkdirAll("iofstest:/data", 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := WriteFile("iofstest:/data/a.txt", []byte("in memory"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := fs.ReadFile(struct{ fs.FS }{NewIOFS("iofstest:/data")}, "a.txt")
+	if err != nil || string(data) != "in memory" {
+		t.Errorf("Open through a backend = %q, %v", data, err)
+	}
+}
@@ -0,0 +1,320 @@
+package GMSFS
+
+import (
+	"context"
+	"io"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// JobInfo describes a directory job in progress: a tree copy, removal or archive operation
+type JobInfo struct {
+	ID      uint64    `json:"id"`
+	Op      string    `json:"op"`
+	Src     string    `json:"src"`
+	Dst     string    `json:"dst,omitempty"`
+	Started time.Time `json:"started"`
+	Files   int64     `json:"files"`           // Files handled so far, where the job counts them
+	Bytes   int64     `json:"bytes"`           // Includes the part of files still being copied
+	Total   int64     `json:"total,omitempty"` // Bytes to process, where the job knows them
+	Paused  bool      `json:"paused,omitempty"`
+
+	CorrelationID string `json:"correlation_id,omitempty"` // From the context the job was started with
+}
+
+// job is a registered JobInfo with live counters
+type job struct {
+	info    JobInfo
+	ctl     *JobControl
+	files   atomic.Int64
+	bytes   atomic.Int64
+	partial atomic.Int64 // Bytes of files still in progress
+	total   atomic.Int64
+}
+
+// JobControl pauses and resumes a long running operation. Attach it to the context passed to
+// CopyFileContext, CopyDirContext or FileChecksumContext with WithJobControl; jobs without
+// one get their own, reachable through PauseJob and ResumeJob. The zero value is ready to use.
+//
+// Pausing takes effect between chunks of a file, so even a single huge file stops within a
+// few megabytes.
+type JobControl struct {
+	Progress ProgressFunc // Optional, reports single file copies and checksums as they run
+
+	mu     sync.Mutex
+	resume chan struct{} // Open while paused
+}
+
+type jobControlKey struct{}
+
+type jobKey struct{}
+
+var (
+	jobsMu      sync.Mutex
+	jobs        = map[uint64]*job{}
+	jobSeq      atomic.Uint64
+	jobsChanged = make(chan struct{}) // Closed and replaced when a job ends, under jobsMu
+
+	pauseAll JobControl // Holds every job, see PauseAll
+	holdNew  JobControl // Holds jobs that have not started yet, see Drain
+)
+
+// Jobs lists the directory jobs currently running, oldest first
+func Jobs() []JobInfo {
+	jobsMu.Lock()
+	list := make([]JobInfo, 0, len(jobs))
+	for _, j := range jobs {
+		list = append(list, j.snapshot())
+	}
+	jobsMu.Unlock()
+
+	sort.Slice(list, func(i, k int) bool { return list[i].ID < list[k].ID })
+	return list
+}
+
+// PauseAll pauses every job: running ones at their next checkpoint, which for copies and
+// checksums is within a few megabytes, and new ones before they start. Archive and removal
+// jobs only wait before they start. ResumeAll undoes it.
+func PauseAll() {
+	pauseAll.Pause()
+}
+
+// ResumeAll lets the jobs held by PauseAll or Drain continue. Jobs paused one by one with
+// PauseJob or their JobControl stay paused.
+func ResumeAll() {
+	pauseAll.Resume()
+	holdNew.Resume()
+}
+
+// Drain quiesces the job engine before maintenance: jobs that have not started yet wait until
+// ResumeAll, while running ones, including those held by PauseAll, go on until they finish.
+// It returns once no job is running, or with ctx's error when ctx ends first; new jobs are
+// held either way. A job paused with PauseJob or its JobControl keeps Drain waiting.
+func Drain(ctx context.Context) error {
+	holdNew.Pause()
+	pauseAll.Resume()
+
+	for {
+		jobsMu.Lock()
+		running, changed := len(jobs), jobsChanged
+		jobsMu.Unlock()
+
+		if running == 0 {
+			return nil
+		}
+		select {
+		case <-changed:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// PauseJob pauses the running job with the given ID, see JobControl. It reports whether the
+// job was found.
+func PauseJob(id uint64) bool {
+	j := findJob(id)
+	if j == nil {
+		return false
+	}
+	j.ctl.Pause()
+	return true
+}
+
+// ResumeJob resumes a job paused with PauseJob or its JobControl
+func ResumeJob(id uint64) bool {
+	j := findJob(id)
+	if j == nil {
+		return false
+	}
+	j.ctl.Resume()
+	return true
+}
+
+func findJob(id uint64) *job {
+	jobsMu.Lock()
+	defer jobsMu.Unlock()
+
+	return jobs[id]
+}
+
+// WithJobControl returns a context whose operations can be paused through c
+func WithJobControl(ctx context.Context, c *JobControl) context.Context {
+	return context.WithValue(ctx, jobControlKey{}, c)
+}
+
+// Pause stops the operation at its next checkpoint until Resume is called or its context ends
+func (c *JobControl) Pause() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.resume == nil {
+		c.resume = make(chan struct{})
+	}
+}
+
+// Resume lets a paused operation continue
+func (c *JobControl) Resume() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.resume != nil {
+		close(c.resume)
+		c.resume = nil
+	}
+}
+
+// Paused reports whether Pause was called without a Resume since
+func (c *JobControl) Paused() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.resume != nil
+}
+
+// wait blocks while c is paused
+func (c *JobControl) wait(ctx context.Context) error {
+	c.mu.Lock()
+	resume := c.resume
+	c.mu.Unlock()
+
+	if resume == nil {
+		return nil
+	}
+	select {
+	case <-resume:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// startJob registers a job until done is called. The returned context carries the job, so
+// checkpoint and copyChunks further down can find it. While PauseAll or Drain hold new jobs it
+// waits; if ctx ends meanwhile, the job's first checkpoint returns the error.
+func startJob(ctx context.Context, op string, src string, dst string) (context.Context, *job) {
+	// Drain lifts PauseAll while holding new jobs, so check both again after each wait
+	for (holdNew.Paused() || pauseAll.Paused()) && ctx.Err() == nil {
+		holdNew.wait(ctx)
+		pauseAll.wait(ctx)
+	}
+
+	j := &job{info: JobInfo{
+		ID:            jobSeq.Add(1),
+		Op:            op,
+		Src:           src,
+		Dst:           dst,
+		Started:       time.Now(),
+		CorrelationID: CorrelationID(ctx),
+	}}
+	j.ctl, _ = ctx.Value(jobControlKey{}).(*JobControl)
+	if j.ctl == nil {
+		j.ctl = &JobControl{}
+	}
+
+	jobsMu.Lock()
+	jobs[j.info.ID] = j
+	jobsMu.Unlock()
+	return context.WithValue(ctx, jobKey{}, j), j
+}
+
+func jobFrom(ctx context.Context) *job {
+	j, _ := ctx.Value(jobKey{}).(*job)
+	return j
+}
+
+// add counts finished work
+func (j *job) add(files int64, bytes int64) {
+	if j != nil {
+		j.files.Add(files)
+		j.bytes.Add(bytes)
+	}
+}
+
+// setTotal records the bytes the job is going to process
+func (j *job) setTotal(n int64) {
+	if j != nil {
+		j.total.Store(n)
+	}
+}
+
+func (j *job) done() {
+	if j == nil {
+		return
+	}
+	jobsMu.Lock()
+	delete(jobs, j.info.ID)
+	close(jobsChanged)
+	jobsChanged = make(chan struct{})
+	jobsMu.Unlock()
+}
+
+func (j *job) snapshot() JobInfo {
+	info := j.info
+	info.Files = j.files.Load()
+	info.Bytes = j.bytes.Load() + j.partial.Load()
+	info.Total = j.total.Load()
+	info.Paused = j.ctl.Paused() || pauseAll.Paused()
+	return info
+}
+
+// yieldChunk is how much of a file is copied or hashed between checkpoints
+const yieldChunk = 4 << 20
+
+// checkpoint returns the context's error, first waiting while the job or JobControl of ctx is
+// paused or PauseAll is in effect. Long loops call it between units of work.
+func checkpoint(ctx context.Context) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if err := pauseAll.wait(ctx); err != nil {
+		return err
+	}
+
+	c, _ := ctx.Value(jobControlKey{}).(*JobControl)
+	if j := jobFrom(ctx); j != nil {
+		c = j.ctl
+	}
+	if c != nil {
+		if err := c.wait(ctx); err != nil {
+			return err
+		}
+	}
+	return ctx.Err()
+}
+
+// copyChunks is io.Copy in yieldChunk pieces with a checkpoint before each, counting the bytes
+// towards the job of ctx as they go when count is set. io.CopyN keeps the copy_file_range and
+// sendfile paths of *os.File. Without a job or a cancelable context it is plain io.Copy.
+func copyChunks(ctx context.Context, dst io.Writer, src io.Reader, count bool) (written int64, err error) {
+	j := jobFrom(ctx)
+	if j == nil && ctx.Done() == nil && ctx.Value(jobControlKey{}) == nil {
+		return io.Copy(dst, src)
+	}
+	if !count {
+		j = nil
+	}
+	if j != nil {
+		defer func() { j.partial.Add(-written) }()
+	}
+
+	for {
+		if err = checkpoint(ctx); err != nil {
+			return
+		}
+		var n int64
+		n, err = io.CopyN(dst, src, yieldChunk)
+		written += n
+		if j != nil {
+			j.partial.Add(n)
+		}
+		if err == io.EOF {
+			return written, nil
+		}
+		if err != nil {
+			return
+		}
+	}
+}
@@ -0,0 +1,89 @@
+package GMSFS
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+)
+
+// JSONOptions tunes ReadJSONWithOptions and WriteJSONWithOptions
+type JSONOptions struct {
+	Compact               bool   // Write everything on one line instead of indenting
+	Indent                string // Indentation of pretty output; defaults to two spaces
+	EscapeHTML            bool   // Escape <, > and & as encoding/json.Marshal does
+	DisallowUnknownFields bool   // Reading fails on object keys with no matching struct field
+}
+
+// ReadJSON decodes the JSON file name into v, logging and returning errors that name the file
+func ReadJSON(name string, v any) error {
+	return ReadJSONWithOptions(name, v, JSONOptions{})
+}
+
+// ReadJSONWithOptions is ReadJSON with decoding options. Content after the JSON value, other
+// than white space, is an error.
+func ReadJSONWithOptions(name string, v any, opts JSONOptions) error {
+	name = cleanPath(name)
+
+	data, err := ReadFile(name)
+	if err != nil {
+		return err
+	}
+
+	dec := json.NewDecoder(bytes.NewReader(data))
+	if opts.DisallowUnknownFields {
+		dec.DisallowUnknownFields()
+	}
+	err = dec.Decode(v)
+	if err == nil {
+		if _, terr := dec.Token(); terr != io.EOF {
+			err = fmt.Errorf("invalid character after top-level value")
+		}
+	}
+	if err != nil {
+		err = fmt.Errorf("%s: %w", name, err)
+		errorPrinter("ReadJSON: "+err.Error(), name)
+		return err
+	}
+	return nil
+}
+
+// WriteJSON encodes v as indented JSON and replaces name with it atomically, so readers see
+// the old or the new content but never a partial file
+func WriteJSON(name string, v any, perm os.FileMode) error {
+	return WriteJSONWithOptions(name, v, perm, JSONOptions{})
+}
+
+// WriteJSONWithOptions is WriteJSON with encoding options
+func WriteJSONWithOptions(name string, v any, perm os.FileMode, opts JSONOptions) error {
+	name = cleanPath(name)
+
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(opts.EscapeHTML)
+	if !opts.Compact {
+		indent := opts.Indent
+		if indent == "" {
+			indent = "  "
+		}
+		enc.SetIndent("", indent)
+	}
+	if err := enc.Encode(v); err != nil {
+		err = fmt.Errorf("%s: %w", name, err)
+		errorPrinter("WriteJSON: "+err.Error(), name)
+		return err
+	}
+
+	var err error
+	if isLocal(name) {
+		err = writeFileAtomic(name, buf.Bytes(), perm)
+	} else {
+		// Backends replace objects whole
+		err = WriteFile(name, buf.Bytes(), perm)
+	}
+	if err != nil {
+		errorPrinter("WriteJSON: "+err.Error(), name)
+	}
+	return err
+}
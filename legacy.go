@@ -0,0 +1,101 @@
+package GMSFS
+
+import (
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// LegacyCall is a call site still using a function that has a replacement, as recorded after
+// SetReportLegacyCalls(true)
+type LegacyCall struct {
+	Function    string    `json:"function"`    // e.g. "CopyDir"
+	Replacement string    `json:"replacement"` // What to call instead
+	Caller      string    `json:"caller"`      // file:line of the call
+	Count       int64     `json:"count"`
+	First       time.Time `json:"first"`
+	Last        time.Time `json:"last"`
+}
+
+var reportLegacyCalls atomic.Bool
+
+var legacyCalls = struct {
+	mu    sync.Mutex
+	sites map[string]*LegacyCall // Keyed by function and caller
+}{sites: map[string]*LegacyCall{}}
+
+// SetReportLegacyCalls makes the functions marked Deprecated record where they are called from,
+// so a large codebase can see what is left to migrate with LegacyCalls. The first call from
+// each site is logged as a warning naming the replacement. Disabled by default; when disabled
+// the check costs one atomic load per call.
+func SetReportLegacyCalls(enabled bool) {
+	reportLegacyCalls.Store(enabled)
+}
+
+// ReportLegacyCalls reports whether legacy calls are being recorded
+func ReportLegacyCalls() bool {
+	return reportLegacyCalls.Load()
+}
+
+// LegacyCalls returns the call sites recorded since reporting was enabled or
+// ClearLegacyCalls, sorted by function and caller
+func LegacyCalls() []LegacyCall {
+	legacyCalls.mu.Lock()
+	defer legacyCalls.mu.Unlock()
+
+	list := make([]LegacyCall, 0, len(legacyCalls.sites))
+	for _, c := range legacyCalls.sites {
+		list = append(list, *c)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].Function != list[j].Function {
+			return list[i].Function < list[j].Function
+		}
+		return list[i].Caller < list[j].Caller
+	})
+	return list
+}
+
+// ClearLegacyCalls forgets the recorded call sites, e.g. after a release that migrated some
+func ClearLegacyCalls() {
+	legacyCalls.mu.Lock()
+	defer legacyCalls.mu.Unlock()
+
+	legacyCalls.sites = map[string]*LegacyCall{}
+}
+
+// legacyCall records the caller of the legacy function that calls it. Calls from within the
+// package, such as one legacy function built on another, are left out.
+func legacyCall(function string, replacement string) {
+	if !reportLegacyCalls.Load() {
+		return
+	}
+
+	pc, file, line, ok := runtime.Caller(2)
+	if !ok {
+		return
+	}
+	if fn := runtime.FuncForPC(pc); fn != nil && strings.HasPrefix(fn.Name(), pkgPrefix) {
+		return
+	}
+	caller := file + ":" + strconv.Itoa(line)
+	now := time.Now()
+
+	legacyCalls.mu.Lock()
+	c, seen := legacyCalls.sites[function+" "+caller]
+	if !seen {
+		c = &LegacyCall{Function: function, Replacement: replacement, Caller: caller, First: now}
+		legacyCalls.sites[function+" "+caller] = c
+	}
+	c.Count++
+	c.Last = now
+	legacyCalls.mu.Unlock()
+
+	if !seen {
+		warnPrinter("Legacy call: "+function+" from "+caller+"; use "+replacement, "")
+	}
+}
@@ -0,0 +1,232 @@
+package GMSFS
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"io"
+	"iter"
+	"strings"
+)
+
+// lineBlock is the read size of the line utilities
+const lineBlock = 64 * 1024
+
+// HeadLines returns the first n lines of name without their line endings, reading no further
+// than needed
+func HeadLines(name string, n int) ([]string, error) {
+	lines := []string{}
+	err := withLineFile("HeadLines", name, func(f File) error {
+		if n <= 0 {
+			return nil
+		}
+		r := bufio.NewReaderSize(profileFor(name).reader(f), lineBlock)
+		for len(lines) < n {
+			line, err := r.ReadString('\n')
+			if line != "" {
+				lines = append(lines, trimLineEnding(line))
+			}
+			if err == io.EOF {
+				return nil
+			}
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return lines, nil
+}
+
+// TailLines returns the last n lines of name without their line endings. It reads the file
+// backwards from its end in blocks, so only the tail is read however large the file is.
+func TailLines(name string, n int) ([]string, error) {
+	lines := []string{}
+	err := withLineFile("TailLines", name, func(f File) error {
+		if n <= 0 {
+			return nil
+		}
+		end, err := f.Seek(0, io.SeekEnd)
+		if err != nil {
+			return err
+		}
+
+		// Collect blocks from the end until they hold n line breaks before the last line
+		var tail []byte
+		breaks := 0
+		profile := profileFor(name)
+		for pos := end; pos > 0; {
+			size := min(int64(lineBlock), pos)
+			pos -= size
+			if _, err := f.Seek(pos, io.SeekStart); err != nil {
+				return err
+			}
+			block := make([]byte, size)
+			if _, err := io.ReadFull(f, block); err != nil {
+				return err
+			}
+			profile.throttleRead(len(block))
+			tail = append(block, tail...)
+
+			breaks += bytes.Count(block, []byte("\n"))
+			if pos+size == end && bytes.HasSuffix(block, []byte("\n")) {
+				breaks-- // The break ending the last line
+			}
+			if breaks >= n {
+				break
+			}
+		}
+
+		tail = bytes.TrimSuffix(tail, []byte("\n"))
+		if len(tail) == 0 && end == 0 {
+			return nil
+		}
+		for i := 0; i < n; i++ {
+			j := bytes.LastIndexByte(tail, '\n')
+			lines = append(lines, trimLineEnding(string(tail[j+1:])))
+			if j < 0 {
+				break
+			}
+			tail = tail[:j]
+		}
+		for i, j := 0, len(lines)-1; i < j; i, j = i+1, j-1 {
+			lines[i], lines[j] = lines[j], lines[i]
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return lines, nil
+}
+
+// CountLines returns the number of lines in name, counting a last line without a line
+// ending, in constant memory
+func CountLines(name string) (int64, error) {
+	var count int64
+	err := withLineFile("CountLines", name, func(f File) error {
+		r := profileFor(name).reader(f)
+		buf := make([]byte, lineBlock)
+		last := byte('\n')
+		for {
+			n, err := r.Read(buf)
+			if n > 0 {
+				count += int64(bytes.Count(buf[:n], []byte("\n")))
+				last = buf[n-1]
+			}
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				return err
+			}
+		}
+		if last != '\n' {
+			count++
+		}
+		return nil
+	})
+	return count, err
+}
+
+// ProcessLines calls fn with every line of name, without its line ending, reading the file as
+// a stream so its size does not matter. An error from fn stops the reading and is returned,
+// except SkipAll, which stops it without an error.
+func ProcessLines(name string, fn func(line string) error) error {
+	return ProcessLinesContext(context.Background(), name, fn)
+}
+
+// ProcessLinesContext is ProcessLines stopping with ctx.Err() when ctx ends
+func ProcessLinesContext(ctx context.Context, name string, fn func(line string) error) error {
+	return withLineFile("ProcessLines", name, func(f File) error {
+		return scanLines(ctx, name, f, fn)
+	})
+}
+
+// ReadLinesIter returns an iterator over the lines of name without their line endings, for
+// range loops. The file is read as the loop goes and closed when it ends; a read error is
+// yielded once, with an empty line, as the last element.
+//
+//	for line, err := range GMSFS.ReadLinesIter("app.log") {
+//		if err != nil {
+//			return err
+//		}
+//		...
+//	}
+func ReadLinesIter(name string) iter.Seq2[string, error] {
+	return ReadLinesIterContext(context.Background(), name)
+}
+
+// ReadLinesIterContext is ReadLinesIter ending with ctx.Err() when ctx ends
+func ReadLinesIterContext(ctx context.Context, name string) iter.Seq2[string, error] {
+	return func(yield func(string, error) bool) {
+		err := withLineFile("ReadLinesIter", name, func(f File) error {
+			return scanLines(ctx, name, f, func(line string) error {
+				if !yield(line, nil) {
+					return SkipAll
+				}
+				return nil
+			})
+		})
+		if err != nil {
+			yield("", err)
+		}
+	}
+}
+
+// scanLines reads f line by line, lines of any length included, and hands them to fn
+func scanLines(ctx context.Context, name string, f File, fn func(line string) error) error {
+	r := bufio.NewReaderSize(profileFor(name).reader(f), lineBlock)
+	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		line, err := r.ReadString('\n')
+		if line != "" {
+			if ferr := fn(trimLineEnding(line)); ferr != nil {
+				return ferr
+			}
+		}
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// withLineFile opens name on its backend for fn, logging failures under op
+func withLineFile(op string, name string, fn func(f File) error) error {
+	if err := guardSpecialFile("read", name); err != nil {
+		errorPrinter(op+": "+err.Error(), name)
+		return err
+	}
+
+	simulateOp()
+	acquireFDs(1)
+	defer releaseFDs(1)
+
+	b, p := backendFor(name)
+	f, err := b.Open(p)
+	if err != nil {
+		errorPrinter(op+": "+err.Error(), name)
+		return err
+	}
+	defer f.Close()
+
+	if err := fn(f); err != nil && err != SkipAll {
+		errorPrinter(op+": "+err.Error(), name)
+		return err
+	}
+	return nil
+}
+
+// trimLineEnding drops a trailing "\n" or "\r\n"
+func trimLineEnding(line string) string {
+	line = strings.TrimSuffix(line, "\n")
+	return strings.TrimSuffix(line, "\r")
+}
@@ -0,0 +1,82 @@
+package GMSFS
+
+import (
+	"os"
+)
+
+// ExtendedFileInfo adds filesystem identity to FileInfo. Two paths with the same Device and
+// Inode are hard links to the same file.
+type ExtendedFileInfo struct {
+	FileInfo
+	Links  uint64 // Number of hard links; 0 where the platform does not report it
+	Device uint64
+	Inode  uint64
+}
+
+// Link creates newName as a hard link to oldName
+func Link(oldName string, newName string) error {
+	oldName = cleanPath(oldName)
+	newName = cleanPath(newName)
+	if err := requireLocal("link", oldName, newName); err != nil {
+		errorPrinter("Link: "+err.Error(), newName)
+		return opError("link", oldName, newName, err)
+	}
+
+	delta, err := quotaLinking(oldName, newName)
+	if err != nil {
+		errorPrinter("Link: "+err.Error(), newName)
+		return opError("link", oldName, newName, err)
+	}
+
+	err = os.Link(oldName, newName)
+	if err != nil {
+		quotaRelease(newName, delta)
+		errorPrinter("Link: "+err.Error(), newName)
+		return opError("link", oldName, newName, err)
+	}
+	// The link count of oldName changed as well
+	invalidateStat(oldName)
+	invalidateStat(newName)
+
+	return nil
+}
+
+// StatExtended is Stat plus the hard link count and file identity. Results are not cached.
+func StatExtended(name string) (ExtendedFileInfo, error) {
+	name = cleanPath(name)
+	if err := requireLocal("stat", name); err != nil {
+		errorPrinter("StatExtended: "+err.Error(), name)
+		return ExtendedFileInfo{}, opError("stat", name, "", err)
+	}
+
+	stat, err := os.Stat(name)
+	if err != nil {
+		return ExtendedFileInfo{}, opError("stat", name, "", err)
+	}
+
+	id, links, err := fileIdentity(name, stat)
+	if err != nil {
+		errorPrinter("StatExtended: "+err.Error(), name)
+		return ExtendedFileInfo{}, opError("stat", name, "", err)
+	}
+
+	return ExtendedFileInfo{
+		FileInfo: FileInfo{
+			Exists:       true,
+			Size:         stat.Size(),
+			Mode:         stat.Mode(),
+			LastModified: stat.ModTime(),
+			IsDir:        stat.IsDir(),
+			Name:         stat.Name(),
+		},
+		Links:  links,
+		Device: id.device,
+		Inode:  id.inode,
+	}, nil
+}
+
+// fileID identifies a file across its hard links
+type fileID struct {
+	device uint64
+	inode  uint64
+}
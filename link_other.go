@@ -0,0 +1,10 @@
+//go:build !unix && !windows
+
+package GMSFS
+
+import "os"
+
+// fileIdentity is unknown on this platform, so every file looks unlinked
+func fileIdentity(name string, info os.FileInfo) (fileID, uint64, error) {
+	return fileID{}, 0, nil
+}
@@ -0,0 +1,17 @@
+//go:build unix
+
+package GMSFS
+
+import (
+	"os"
+	"syscall"
+)
+
+// fileIdentity returns the device and inode of info and its hard link count
+func fileIdentity(name string, info os.FileInfo) (fileID, uint64, error) {
+	st, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return fileID{}, 0, nil
+	}
+	return fileID{device: uint64(st.Dev), inode: uint64(st.Ino)}, uint64(st.Nlink), nil
+}
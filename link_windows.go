@@ -0,0 +1,30 @@
+//go:build windows
+
+package GMSFS
+
+import (
+	"os"
+	"syscall"
+)
+
+// fileIdentity returns the volume and file index of name and its hard link count, which
+// Windows only reports for an open handle
+func fileIdentity(name string, info os.FileInfo) (fileID, uint64, error) {
+	path, err := syscall.UTF16PtrFromString(name)
+	if err != nil {
+		return fileID{}, 0, err
+	}
+	h, err := syscall.CreateFile(path, 0, syscall.FILE_SHARE_READ|syscall.FILE_SHARE_WRITE|syscall.FILE_SHARE_DELETE,
+		nil, syscall.OPEN_EXISTING, syscall.FILE_FLAG_BACKUP_SEMANTICS, 0)
+	if err != nil {
+		return fileID{}, 0, &os.PathError{Op: "CreateFile", Path: name, Err: err}
+	}
+	defer syscall.CloseHandle(h)
+
+	var d syscall.ByHandleFileInformation
+	if err := syscall.GetFileInformationByHandle(h, &d); err != nil {
+		return fileID{}, 0, &os.PathError{Op: "GetFileInformationByHandle", Path: name, Err: err}
+	}
+	id := fileID{device: uint64(d.VolumeSerialNumber), inode: uint64(d.FileIndexHigh)<<32 | uint64(d.FileIndexLow)}
+	return id, uint64(d.NumberOfLinks), nil
+}
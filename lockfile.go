@@ -0,0 +1,342 @@
+package GMSFS
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultLockFileTTL is the lifetime of a lock file taken with a ttl of 0
+const DefaultLockFileTTL = 30 * time.Second
+
+// ErrLockHeld is returned by AcquireLockFile while another holder has the lock file
+var ErrLockHeld = errors.New("lock file is held")
+
+// ErrPidFileRunning is returned by WritePidFile when the pid file names a running process
+var ErrPidFileRunning = errors.New("process in pid file is running")
+
+// LockFile is a lock held through a file naming its holder, which works between processes and
+// between hosts sharing a network filesystem, where flock often does not. The holder renews
+// the file every third of its TTL; a file not renewed within the TTL, or naming a process on
+// this host that no longer runs, is stale and taken over by the next taker.
+type LockFile struct {
+	name string
+	ttl  time.Duration
+	info lockInfo
+
+	stop   chan struct{}
+	done   chan struct{}
+	lost   chan struct{}
+	onLost func()
+	once   sync.Once
+}
+
+// lockInfo is the JSON content of a lock file
+type lockInfo struct {
+	ID      string    `json:"id"`
+	Host    string    `json:"host"`
+	PID     int       `json:"pid"`
+	Op      string    `json:"op,omitempty"`
+	Src     string    `json:"src,omitempty"`
+	Started time.Time `json:"started"`
+	Expires time.Time `json:"expires"`
+}
+
+func (i lockInfo) String() string {
+	s := fmt.Sprintf("pid %d on %s since %s", i.PID, i.Host, i.Started.Format(time.RFC3339))
+	if i.Op != "" {
+		s = fmt.Sprintf("%s from %s (%s)", i.Op, i.Src, s)
+	}
+	return s
+}
+
+// stale reports whether the lock is free for the taking: expired, or held by a process of
+// this host that has exited
+func (i lockInfo) stale(host string) bool {
+	if !time.Now().Before(i.Expires) {
+		return true
+	}
+	return i.Host == host && i.PID > 0 && i.PID != os.Getpid() && !processRunning(i.PID)
+}
+
+// AcquireLockFile takes the lock file name, failing with ErrLockHeld while another holder
+// has it. The lock lasts ttl (0 means DefaultLockFileTTL) and is renewed in the background
+// until Release.
+func AcquireLockFile(name string, ttl time.Duration) (*LockFile, error) {
+	name = cleanPath(name)
+	l := newLockFile(name, ttl, "", "")
+
+	holder, err := l.acquire(context.Background(), false)
+	if err == nil && holder != nil {
+		err = fmt.Errorf("%s: %w by %s", name, ErrLockHeld, holder)
+	}
+	if err != nil {
+		errorPrinter("AcquireLockFile: "+err.Error(), name)
+		return nil, err
+	}
+	l.start()
+	return l, nil
+}
+
+// AcquireLockFileContext is AcquireLockFile waiting for the lock until ctx ends
+func AcquireLockFileContext(ctx context.Context, name string, ttl time.Duration) (*LockFile, error) {
+	name = cleanPath(name)
+	l := newLockFile(name, ttl, "", "")
+
+	if _, err := l.acquire(ctx, true); err != nil {
+		errorPrinterCtx(ctx, "AcquireLockFileContext: "+err.Error(), name)
+		return nil, err
+	}
+	l.start()
+	return l, nil
+}
+
+func newLockFile(name string, ttl time.Duration, op string, src string) *LockFile {
+	host, _ := os.Hostname()
+	return &LockFile{
+		name: name,
+		ttl:  cmp.Or(ttl, DefaultLockFileTTL),
+		info: lockInfo{
+			ID:      fmt.Sprintf("%016x", rand.Uint64()),
+			Host:    host,
+			PID:     os.Getpid(),
+			Op:      op,
+			Src:     src,
+			Started: time.Now(),
+		},
+		lost: make(chan struct{}),
+	}
+}
+
+// Name returns the lock file
+func (l *LockFile) Name() string {
+	return l.name
+}
+
+// Lost is closed when the lock was taken over because renewing it failed for longer than
+// its TTL, after which the holder must stop relying on it
+func (l *LockFile) Lost() <-chan struct{} {
+	return l.lost
+}
+
+// Release stops renewing the lock and removes the file, unless someone took it over
+func (l *LockFile) Release() error {
+	var err error
+	l.once.Do(func() {
+		close(l.stop)
+		<-l.done
+		unregisterCloser(l)
+
+		current, rerr := readLockInfo(l.name)
+		if rerr != nil || current.ID != l.info.ID {
+			return
+		}
+		if err = os.Remove(l.name); err != nil {
+			errorPrinter("LockFile.Release: "+err.Error(), l.name)
+		}
+	})
+	return err
+}
+
+// Close is Release, so a LockFile can be released together with other io.Closers
+func (l *LockFile) Close() error {
+	return l.Release()
+}
+
+// acquire takes the lock, waiting for it while wait is set and ctx lasts, and otherwise
+// returns the current holder
+func (l *LockFile) acquire(ctx context.Context, wait bool) (*lockInfo, error) {
+	waiting := false
+	for delay := 50 * time.Millisecond; ; delay = min(delay*2, time.Second) {
+		holder, err := l.try()
+		if err != nil || holder == nil || !wait {
+			return holder, err
+		}
+
+		if !waiting {
+			infoPrinter("waiting for lock file held by "+holder.String(), l.name)
+			waiting = true
+		}
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
+
+// try creates the lock file, taking over a stale one, and returns the current holder when
+// the lock is held
+func (l *LockFile) try() (*lockInfo, error) {
+	var holder lockInfo
+	for attempt := 0; attempt < 3; attempt++ {
+		l.info.Expires = time.Now().Add(l.ttl)
+		content, err := json.Marshal(l.info)
+		if err != nil {
+			return nil, err
+		}
+
+		f, err := os.OpenFile(l.name, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+		if err == nil {
+			_, err = f.Write(content)
+			if cerr := f.Close(); err == nil {
+				err = cerr
+			}
+			if err != nil {
+				os.Remove(l.name)
+			}
+			return nil, err
+		}
+		if !os.IsExist(err) {
+			return nil, err
+		}
+
+		holder, err = readLockInfo(l.name)
+		if os.IsNotExist(err) {
+			continue // Released meanwhile
+		}
+		if err != nil {
+			return nil, err
+		}
+		if !holder.stale(l.info.Host) {
+			return &holder, nil
+		}
+
+		// Move the stale file aside, making sure it is still the one judged stale, as another
+		// taker may have replaced it in between
+		warnPrinter("taking over stale lock file of "+holder.String(), l.name)
+		aside := fmt.Sprintf("%s.stale-%s", l.name, l.info.ID)
+		if err := os.Rename(l.name, aside); err != nil {
+			continue
+		}
+		if moved, err := readLockInfo(aside); err == nil && moved.ID != holder.ID {
+			// Someone else's fresh lock; put it back unless yet another one appeared
+			os.Link(aside, l.name)
+		}
+		os.Remove(aside)
+	}
+	return &holder, nil
+}
+
+// readLockInfo reads a lock file. One that does not parse counts as just created by a
+// holder still writing it, until it is older than the default TTL.
+func readLockInfo(name string) (lockInfo, error) {
+	data, err := os.ReadFile(name)
+	if err != nil {
+		return lockInfo{}, err
+	}
+
+	var info lockInfo
+	if err := json.Unmarshal(data, &info); err != nil {
+		if fi, serr := os.Stat(name); serr == nil && time.Since(fi.ModTime()) < DefaultLockFileTTL {
+			info.Expires = fi.ModTime().Add(DefaultLockFileTTL)
+			return info, nil
+		}
+		return lockInfo{}, nil
+	}
+	return info, nil
+}
+
+// start renews the lock in the background until Release
+func (l *LockFile) start() {
+	l.stop = make(chan struct{})
+	l.done = make(chan struct{})
+	registerCloser(l)
+	go l.renew()
+}
+
+func (l *LockFile) renew() {
+	defer close(l.done)
+
+	ticker := time.NewTicker(l.ttl / 3)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-l.stop:
+			return
+		case <-ticker.C:
+		}
+
+		current, err := readLockInfo(l.name)
+		if (err == nil || os.IsNotExist(err)) && current.ID != l.info.ID {
+			errorPrinter("LockFile: lock taken over by "+current.String(), l.name)
+			close(l.lost)
+			if l.onLost != nil {
+				l.onLost()
+			}
+			return
+		}
+
+		l.info.Expires = time.Now().Add(l.ttl)
+		content, err := json.Marshal(l.info)
+		if err == nil {
+			err = writeFileAtomic(l.name, content, 0644)
+		}
+		if err != nil {
+			// The next tick tries again; the lock only goes once it expires
+			warnPrinter("LockFile (renew): "+err.Error(), l.name)
+		}
+	}
+}
+
+// WritePidFile writes the process id to name, failing with ErrPidFileRunning when the file
+// names another process that is still running. A stale file from a process that exited is
+// replaced. As process ids get reused, a stale file can occasionally look running.
+func WritePidFile(name string) error {
+	name = cleanPath(name)
+
+	pid, running, err := CheckPidFile(name)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		errorPrinter("WritePidFile: "+err.Error(), name)
+		return err
+	}
+	if running && pid != os.Getpid() {
+		err = fmt.Errorf("%s: %w: pid %d", name, ErrPidFileRunning, pid)
+		errorPrinter("WritePidFile: "+err.Error(), name)
+		return err
+	}
+
+	return writeFileAtomic(name, []byte(strconv.Itoa(os.Getpid())+"\n"), 0644)
+}
+
+// CheckPidFile returns the process id in name and whether that process is running. A file
+// that does not hold a process id is an error.
+func CheckPidFile(name string) (int, bool, error) {
+	name = cleanPath(name)
+
+	data, err := ReadFile(name)
+	if err != nil {
+		return 0, false, err
+	}
+	pid, err := strconv.Atoi(strings.TrimSpace(string(data)))
+	if err != nil || pid <= 0 {
+		return 0, false, fmt.Errorf("%s: not a pid file", name)
+	}
+	return pid, processRunning(pid), nil
+}
+
+// RemovePidFile removes name if it holds the id of this process, so a process that lost
+// its pid file to another instance does not delete the other's
+func RemovePidFile(name string) error {
+	name = cleanPath(name)
+
+	pid, _, err := CheckPidFile(name)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if pid != os.Getpid() {
+		return nil
+	}
+	return Delete(name)
+}
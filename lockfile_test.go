@@ -0,0 +1,236 @@
+package GMSFS
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// exitedPid returns the id of a process that has exited
+func exitedPid(t *testing.T) int {
+	t.Helper()
+	cmd := exec.Command(os.Args[0], "-test.run=^$")
+	if err := cmd.Run(); err != nil {
+		t.Fatal(err)
+	}
+	if processRunning(cmd.Process.Pid) {
+		t.Skip("exited processes look running on this platform")
+	}
+	return cmd.Process.Pid
+}
+
+// writeLockInfo writes a lock file held by someone else
+func writeLockInfo(t *testing.T, name string, info lockInfo) {
+	t.Helper()
+	content, err := json.Marshal(info)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(name, content, 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestLockFile(t *testing.T) {
+	name := filepath.Join(t.TempDir(), "job.lock")
+
+	l, err := AcquireLockFile(name, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if l.Name() != name {
+		t.Errorf("Name = %s", l.Name())
+	}
+	info, err := readLockInfo(name)
+	if err != nil || info.PID != os.Getpid() || info.ID != l.info.ID {
+		t.Errorf("lock file = %+v, %v", info, err)
+	}
+
+	if _, err := AcquireLockFile(name, 0); !errors.Is(err, ErrLockHeld) {
+		t.Errorf("second AcquireLockFile: %v", err)
+	}
+
+	if err := l.Release(); err != nil {
+		t.Fatal(err)
+	}
+	if err := l.Close(); err != nil {
+		t.Errorf("Close after Release: %v", err)
+	}
+	if _, err := os.Stat(name); !os.IsNotExist(err) {
+		t.Errorf("lock file after Release: %v", err)
+	}
+
+	l, err = AcquireLockFile(name, 0)
+	if err != nil {
+		t.Fatalf("AcquireLockFile after Release: %v", err)
+	}
+	l.Release()
+
+	if _, err := AcquireLockFile(filepath.Join(name, "missing", "x.lock"), 0); err == nil {
+		t.Error("AcquireLockFile in a missing directory succeeded")
+	}
+}
+
+func TestLockFileStale(t *testing.T) {
+	dir := t.TempDir()
+	host, _ := os.Hostname()
+
+	expired := filepath.Join(dir, "expired.lock")
+	writeLockInfo(t, expired, lockInfo{ID: "other", Host: "elsewhere", PID: 1, Expires: time.Now().Add(-time.Second)})
+	l, err := AcquireLockFile(expired, 0)
+	if err != nil {
+		t.Fatalf("taking over an expired lock: %v", err)
+	}
+	l.Release()
+
+	dead := filepath.Join(dir, "dead.lock")
+	writeLockInfo(t, dead, lockInfo{ID: "other", Host: host, PID: exitedPid(t), Expires: time.Now().Add(time.Hour)})
+	l, err = AcquireLockFile(dead, 0)
+	if err != nil {
+		t.Fatalf("taking over the lock of an exited process: %v", err)
+	}
+	l.Release()
+
+	// A live holder on another host keeps it however its pid looks here
+	remote := filepath.Join(dir, "remote.lock")
+	writeLockInfo(t, remote, lockInfo{ID: "other", Host: "elsewhere", PID: exitedPid(t), Expires: time.Now().Add(time.Hour)})
+	if _, err := AcquireLockFile(remote, 0); !errors.Is(err, ErrLockHeld) {
+		t.Errorf("AcquireLockFile of a remote holder's lock: %v", err)
+	}
+
+	// A file still being written counts as held
+	partial := filepath.Join(dir, "partial.lock")
+	if err := os.WriteFile(partial, []byte("{"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := AcquireLockFile(partial, 0); !errors.Is(err, ErrLockHeld) {
+		t.Errorf("AcquireLockFile of a partly written lock: %v", err)
+	}
+}
+
+func TestLockFileContext(t *testing.T) {
+	name := filepath.Join(t.TempDir(), "job.lock")
+	held, err := AcquireLockFile(name, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	if _, err := AcquireLockFileContext(ctx, name, 0); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("AcquireLockFileContext while held: %v", err)
+	}
+
+	time.AfterFunc(100*time.Millisecond, func() { held.Release() })
+	ctx, cancel = context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	l, err := AcquireLockFileContext(ctx, name, 0)
+	if err != nil {
+		t.Fatalf("AcquireLockFileContext after Release: %v", err)
+	}
+	l.Release()
+}
+
+func TestLockFileRenewAndLost(t *testing.T) {
+	name := filepath.Join(t.TempDir(), "job.lock")
+	l, err := AcquireLockFile(name, 300*time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	first, _ := readLockInfo(name)
+
+	// Take the lock over right after a renewal, so the next one is a full tick away and
+	// finds it taken rather than overwriting it
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		if info, _ := readLockInfo(name); info.Expires.After(first.Expires) {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("lock not renewed")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	other := name + ".other"
+	writeLockInfo(t, other, lockInfo{ID: "other", Host: "elsewhere", Expires: time.Now().Add(time.Hour)})
+	if err := os.Rename(other, name); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case <-l.Lost():
+	case <-time.After(5 * time.Second):
+		t.Fatal("Lost not closed after a takeover")
+	}
+
+	// Releasing a lost lock leaves the new holder's file
+	l.Release()
+	if info, err := readLockInfo(name); err != nil || info.ID != "other" {
+		t.Errorf("lock file after releasing a lost lock = %+v, %v", info, err)
+	}
+}
+
+func TestPidFile(t *testing.T) {
+	dir := t.TempDir()
+	name := filepath.Join(dir, "app.pid")
+
+	if _, _, err := CheckPidFile(name); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("CheckPidFile missing: %v", err)
+	}
+	if err := WritePidFile(name); err != nil {
+		t.Fatal(err)
+	}
+	if pid, running, err := CheckPidFile(name); err != nil || pid != os.Getpid() || !running {
+		t.Errorf("CheckPidFile = %d %v %v", pid, running, err)
+	}
+	if err := WritePidFile(name); err != nil {
+		t.Errorf("WritePidFile over our own: %v", err)
+	}
+
+	// Another running process keeps its file
+	if err := os.WriteFile(name, []byte(strconv.Itoa(os.Getppid())+"\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := WritePidFile(name); !errors.Is(err, ErrPidFileRunning) {
+		t.Errorf("WritePidFile over a running process: %v", err)
+	}
+	if err := RemovePidFile(name); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(name); err != nil {
+		t.Errorf("RemovePidFile removed another process's file: %v", err)
+	}
+
+	// An exited one's is replaced
+	if err := os.WriteFile(name, []byte(strconv.Itoa(exitedPid(t))), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := WritePidFile(name); err != nil {
+		t.Errorf("WritePidFile over an exited process: %v", err)
+	}
+	if err := RemovePidFile(name); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(name); !os.IsNotExist(err) {
+		t.Errorf("pid file after RemovePidFile: %v", err)
+	}
+	if err := RemovePidFile(name); err != nil {
+		t.Errorf("RemovePidFile missing: %v", err)
+	}
+
+	if err := os.WriteFile(name, []byte("nope"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := CheckPidFile(name); err == nil {
+		t.Error("CheckPidFile of garbage succeeded")
+	}
+	if err := WritePidFile(name); err == nil {
+		t.Error("WritePidFile over garbage succeeded")
+	}
+}
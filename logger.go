@@ -0,0 +1,152 @@
+package GMSFS
+
+import (
+	"context"
+	"log/slog"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Level is the severity of a log entry
+type Level int
+
+const (
+	LevelDebug Level = -4
+	LevelInfo  Level = 0
+	LevelWarn  Level = 4
+	LevelError Level = 8
+)
+
+func (l Level) String() string {
+	switch {
+	case l < LevelInfo:
+		return "DEBUG"
+	case l < LevelWarn:
+		return "INFO"
+	case l < LevelError:
+		return "WARN"
+	default:
+		return "ERROR"
+	}
+}
+
+// LogEntry is one message emitted by the package
+type LogEntry struct {
+	Time    time.Time
+	Level   Level
+	Message string
+	Path    string // File or directory the message is about, if any
+	Caller  string // Function that triggered the message
+
+	// CorrelationID ties the entry to a request, from the context given to a ...Context
+	// function; see WithCorrelationID
+	CorrelationID string
+}
+
+// Logger receives the package's log output
+type Logger interface {
+	Log(entry LogEntry)
+}
+
+// LoggerFunc adapts a plain function to the Logger interface
+type LoggerFunc func(entry LogEntry)
+
+func (f LoggerFunc) Log(entry LogEntry) {
+	f(entry)
+}
+
+type loggerHolder struct {
+	logger Logger
+}
+
+var currentLogger atomic.Pointer[loggerHolder]
+
+var minLogLevel atomic.Int64
+
+func init() {
+	currentLogger.Store(&loggerHolder{logger: DebugFileLogger{}})
+	minLogLevel.Store(int64(LevelDebug))
+}
+
+// SetLogger routes all package log output to logger. A nil logger discards everything.
+func SetLogger(logger Logger) {
+	currentLogger.Store(&loggerHolder{logger: logger})
+}
+
+// GetLogger returns the logger currently in use
+func GetLogger() Logger {
+	return currentLogger.Load().logger
+}
+
+// SetLogLevel drops entries below level before they reach the logger
+func SetLogLevel(level Level) {
+	minLogLevel.Store(int64(level))
+}
+
+// DebugFileLogger is the default logger: it appends to GMSFS.<timestamp>.log in the
+// working directory, but only while a file named GMSFS.Debug exists there. It looks for
+// that file at most once every debugFlagInterval.
+type DebugFileLogger struct{}
+
+// debugFlagInterval is how long DebugFileLogger trusts its last look for GMSFS.Debug
+const debugFlagInterval = time.Second
+
+// debugFlag caches whether GMSFS.Debug exists, so logging doesn't cost a stat per entry
+var debugFlag struct {
+	sync.Mutex
+	checked time.Time
+	on      bool
+}
+
+// debugEnabled reports whether GMSFS.Debug existed when last looked for
+func debugEnabled() bool {
+	debugFlag.Lock()
+	defer debugFlag.Unlock()
+	if now := time.Now(); now.Sub(debugFlag.checked) >= debugFlagInterval {
+		_, err := os.Stat("GMSFS.Debug")
+		debugFlag.on, debugFlag.checked = err == nil, now
+	}
+	return debugFlag.on
+}
+
+func (DebugFileLogger) Log(entry LogEntry) {
+	// Straight to the os package: going through the package's own functions would put the log
+	// under quotas and handle pooling, and log their failures back here
+	if !debugEnabled() {
+		return
+	}
+
+	message := entry.Message
+	if entry.CorrelationID != "" {
+		message = "[" + entry.CorrelationID + "] " + message
+	}
+	f, err := os.OpenFile("GMSFS."+entry.Time.Format(timeFlat)+".log", os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return
+	}
+	defer f.Close()
+	f.WriteString(message + " stacktrace:  (2):" + entry.Caller + "\r\n")
+}
+
+type slogLogger struct {
+	logger *slog.Logger
+}
+
+// NewSlogLogger forwards package log output to a structured slog.Logger
+func NewSlogLogger(logger *slog.Logger) Logger {
+	return slogLogger{logger: logger}
+}
+
+func (s slogLogger) Log(entry LogEntry) {
+	attrs := []slog.Attr{slog.String("caller", entry.Caller)}
+	if entry.Path != "" {
+		attrs = append(attrs, slog.String("path", entry.Path))
+	}
+	if entry.CorrelationID != "" {
+		attrs = append(attrs, slog.String("correlation_id", entry.CorrelationID))
+	}
+
+	s.logger.LogAttrs(context.Background(), slog.Level(entry.Level), entry.Message, attrs...)
+}
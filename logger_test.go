@@ -0,0 +1,62 @@
+package GMSFS
+
+import (
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+// forgetDebugFlag makes DebugFileLogger look for GMSFS.Debug on the next entry
+func forgetDebugFlag() {
+	debugFlag.Lock()
+	debugFlag.checked = time.Time{}
+	debugFlag.Unlock()
+}
+
+func TestDebugFileLogger(t *testing.T) {
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(t.TempDir()); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.Chdir(wd) })
+	forgetDebugFlag()
+	t.Cleanup(forgetDebugFlag)
+
+	now := time.Now()
+	name := "GMSFS." + now.Format(timeFlat) + ".log"
+	entry := LogEntry{Time: now, Message: "first", Caller: "caller"}
+
+	// Nothing is written without GMSFS.Debug
+	DebugFileLogger{}.Log(entry)
+	if _, err := os.Stat(name); !os.IsNotExist(err) {
+		t.Fatalf("log written without GMSFS.Debug: %v", err)
+	}
+
+	if err := os.WriteFile("GMSFS.Debug", nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	// The flag file is only looked for again once debugFlagInterval has passed
+	DebugFileLogger{}.Log(LogEntry{Time: now, Message: "unseen", Caller: "caller"})
+	if _, err := os.Stat(name); !os.IsNotExist(err) {
+		t.Fatalf("GMSFS.Debug seen before debugFlagInterval passed: %v", err)
+	}
+	forgetDebugFlag()
+
+	DebugFileLogger{}.Log(entry)
+	entry.Message, entry.CorrelationID = "second", "id"
+	DebugFileLogger{}.Log(entry)
+
+	data, err := os.ReadFile(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "first stacktrace:  (2):caller\r\n[id] second stacktrace:  (2):caller\r\n"
+	if !strings.Contains(string(data), want) {
+		t.Errorf("log = %q, want %q", data, want)
+	}
+}
@@ -0,0 +1,8 @@
+//go:build !windows
+
+package GMSFS
+
+// longPath only matters on Windows
+func longPath(p string) string {
+	return p
+}
@@ -0,0 +1,30 @@
+//go:build windows
+
+package GMSFS
+
+import (
+	"path/filepath"
+	"strings"
+)
+
+// maxShortPath is where Win32 calls start failing without the \\?\ prefix; directories are
+// limited to MAX_PATH minus room for an 8.3 file name, like package os assumes
+const maxShortPath = 248
+
+// longPath gives paths too long for the Win32 limit the \\?\ prefix, which lifts it but
+// turns off the usual path parsing, so the path is made absolute first. UNC paths become
+// \\?\UNC\server\share\... Short paths and ones already prefixed are left alone.
+func longPath(p string) string {
+	if len(p) < maxShortPath || strings.HasPrefix(p, `\\?\`) || strings.HasPrefix(p, `\\.\`) {
+		return p
+	}
+
+	abs, err := filepath.Abs(p)
+	if err != nil {
+		return p
+	}
+	if strings.HasPrefix(abs, `\\`) {
+		return `\\?\UNC\` + abs[2:]
+	}
+	return `\\?\` + abs
+}
@@ -0,0 +1,86 @@
+package GMSFS
+
+import (
+	"fmt"
+	"os"
+	"time"
+)
+
+// Manifest records the files of a tree at one point in time, e.g. the state a backup was
+// taken from. TarDirIncremental compares against one to archive only what changed.
+type Manifest struct {
+	Created time.Time                `json:"created"`
+	Files   map[string]ManifestEntry `json:"files"` // Keyed by slash-separated path relative to the tree
+}
+
+// ManifestEntry is one file, directory or symlink of a Manifest
+type ManifestEntry struct {
+	Size    int64       `json:"size"`
+	Mode    os.FileMode `json:"mode"`
+	ModTime time.Time   `json:"mtime"`
+	Link    string      `json:"link,omitempty"` // Symlink target
+}
+
+// BuildManifest records the tree below root. Symlinks are recorded, not followed.
+func BuildManifest(root string) (Manifest, error) {
+	root = cleanPath(root)
+	if err := requireLocal("manifest", root); err != nil {
+		errorPrinter("BuildManifest: "+err.Error(), root)
+		return Manifest{}, err
+	}
+
+	m, _, err := buildManifest(root, "", TarOptions{})
+	if err != nil {
+		errorPrinter("BuildManifest: "+err.Error(), root)
+	}
+	return m, err
+}
+
+// buildManifest records what walkArchive selects below root, along with the file infos
+func buildManifest(root string, skip string, opts TarOptions) (Manifest, map[string]os.FileInfo, error) {
+	m := Manifest{Created: time.Now(), Files: map[string]ManifestEntry{}}
+	infos := map[string]os.FileInfo{}
+
+	err := walkArchive(root, skip, opts, func(name string, rel string, info os.FileInfo) error {
+		entry := ManifestEntry{Mode: info.Mode(), ModTime: info.ModTime()}
+		switch {
+		case info.Mode()&os.ModeSymlink != 0:
+			target, err := os.Readlink(name)
+			if err != nil {
+				return err
+			}
+			entry.Link = target
+		case info.Mode().IsRegular():
+			entry.Size = info.Size()
+		case !info.IsDir():
+			return fmt.Errorf("manifest: %s: %w", name, ErrSpecialFile)
+		}
+
+		m.Files[rel] = entry
+		infos[rel] = info
+		return nil
+	})
+	return m, infos, err
+}
+
+// Changed reports whether e differs from the entry a previous manifest recorded
+func (e ManifestEntry) Changed(prev ManifestEntry) bool {
+	return e.Size != prev.Size || e.Mode != prev.Mode || !e.ModTime.Equal(prev.ModTime) || e.Link != prev.Link
+}
+
+// Save writes the manifest to name as JSON, replacing it atomically
+func (m Manifest) Save(name string) error {
+	return WriteJSON(name, m, 0644)
+}
+
+// LoadManifest reads a manifest written by Manifest.Save
+func LoadManifest(name string) (Manifest, error) {
+	var m Manifest
+	if err := ReadJSON(name, &m); err != nil {
+		return Manifest{}, err
+	}
+	if m.Files == nil {
+		m.Files = map[string]ManifestEntry{}
+	}
+	return m, nil
+}
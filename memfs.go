@@ -0,0 +1,606 @@
+package GMSFS
+
+import (
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// MemBackend is a Backend that keeps everything in memory, so tests of code using the
+// package don't touch the disk:
+//
+//	mem := GMSFS.NewMemBackend()
+//	GMSFS.RegisterBackend("mem", mem)
+//	mem.InjectFault(GMSFS.MemFault{Op: "write", Path: "/logs/*", Err: syscall.ENOSPC})
+//	err := GMSFS.Append("mem:/logs/app.log", []byte("line\n")) // fails with ENOSPC
+//
+// Errors are *os.PathError values wrapping the same errors the local filesystem returns.
+// Symlinks are not supported, so Lstat is Stat.
+type MemBackend struct {
+	mu       sync.Mutex
+	nodes    map[string]*memNode // Keyed by cleaned slash path
+	faults   []*MemFault
+	capacity int64 // 0 is unlimited
+	used     int64 // Content bytes of linked files
+}
+
+// MemFault makes matching MemBackend operations fail, see InjectFault
+type MemFault struct {
+	Op    string // open, read, write, sync, close, stat, readdir, mkdir, remove, rename, chmod or chtimes; empty matches every operation
+	Path  string // path.Match pattern for the slash path on the backend; empty matches every path
+	Err   error  // Returned wrapped in an *os.PathError, e.g. syscall.ENOSPC or syscall.EIO
+	Times int    // Fail this many times, then succeed again; 0 fails until ClearFaults
+}
+
+type memNode struct {
+	mode    os.FileMode
+	modTime time.Time
+	data    []byte
+	linked  bool // Still reachable by name; removed files stay usable through open handles
+}
+
+// NewMemBackend returns an empty in-memory filesystem containing only "/"
+func NewMemBackend() *MemBackend {
+	return &MemBackend{
+		nodes: map[string]*memNode{
+			"/": {mode: os.ModeDir | 0755, modTime: time.Now(), linked: true},
+		},
+	}
+}
+
+// InjectFault adds f. Faults are checked in the order they were added.
+func (m *MemBackend) InjectFault(f MemFault) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.faults = append(m.faults, &f)
+}
+
+// ClearFaults removes every injected fault
+func (m *MemBackend) ClearFaults() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.faults = nil
+}
+
+// SetCapacity limits the total size of file contents; writes that would exceed it fail with
+// syscall.ENOSPC. 0 removes the limit.
+func (m *MemBackend) SetCapacity(bytes int64) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.capacity = bytes
+}
+
+// Used returns the total size of file contents
+func (m *MemBackend) Used() int64 {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.used
+}
+
+// fault returns the error of the first fault matching op and name. Callers hold m.mu.
+func (m *MemBackend) fault(op string, name string) error {
+	for i, f := range m.faults {
+		if f.Op != "" && f.Op != op {
+			continue
+		}
+		if f.Path != "" {
+			if ok, _ := path.Match(f.Path, name); !ok {
+				continue
+			}
+		}
+
+		if f.Times > 0 {
+			f.Times--
+			if f.Times == 0 {
+				m.faults = append(m.faults[:i:i], m.faults[i+1:]...)
+			}
+		}
+		return &os.PathError{Op: op, Path: name, Err: f.Err}
+	}
+	return nil
+}
+
+// memPath cleans names for callers using the backend directly rather than through a scheme
+func memPath(name string) string {
+	return path.Clean("/" + filepath.ToSlash(name))
+}
+
+// parentDir checks that name's parent exists and is a directory. Callers hold m.mu.
+func (m *MemBackend) parentDir(op string, name string) error {
+	parent, ok := m.nodes[path.Dir(name)]
+	if !ok {
+		return &os.PathError{Op: op, Path: name, Err: os.ErrNotExist}
+	}
+	if !parent.mode.IsDir() {
+		return &os.PathError{Op: op, Path: name, Err: syscall.ENOTDIR}
+	}
+	return nil
+}
+
+// hasChildren reports whether the directory name contains anything. Callers hold m.mu.
+func (m *MemBackend) hasChildren(name string) bool {
+	prefix := strings.TrimSuffix(name, "/") + "/"
+	for p := range m.nodes {
+		if p != name && strings.HasPrefix(p, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// unlink drops a node from the tree. Callers hold m.mu.
+func (m *MemBackend) unlink(name string) {
+	if n, ok := m.nodes[name]; ok {
+		n.linked = false
+		m.used -= int64(len(n.data))
+		delete(m.nodes, name)
+	}
+}
+
+func (m *MemBackend) Open(name string) (File, error) {
+	return m.OpenFile(name, os.O_RDONLY, 0)
+}
+
+func (m *MemBackend) Create(name string) (File, error) {
+	return m.OpenFile(name, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0666)
+}
+
+func (m *MemBackend) OpenFile(name string, flag int, perm os.FileMode) (File, error) {
+	name = memPath(name)
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if err := m.fault("open", name); err != nil {
+		return nil, err
+	}
+
+	access := flag & (os.O_RDONLY | os.O_WRONLY | os.O_RDWR)
+	write := access == os.O_WRONLY || access == os.O_RDWR
+
+	n, ok := m.nodes[name]
+	switch {
+	case ok && flag&os.O_CREATE != 0 && flag&os.O_EXCL != 0:
+		return nil, &os.PathError{Op: "open", Path: name, Err: os.ErrExist}
+
+	case ok && n.mode.IsDir() && write:
+		return nil, &os.PathError{Op: "open", Path: name, Err: syscall.EISDIR}
+
+	case !ok && flag&os.O_CREATE == 0:
+		return nil, &os.PathError{Op: "open", Path: name, Err: os.ErrNotExist}
+
+	case !ok:
+		if err := m.parentDir("open", name); err != nil {
+			return nil, err
+		}
+		n = &memNode{mode: perm.Perm(), modTime: time.Now(), linked: true}
+		m.nodes[name] = n
+
+	case flag&os.O_TRUNC != 0 && write:
+		m.used -= int64(len(n.data))
+		n.data = nil
+		n.modTime = time.Now()
+	}
+
+	return &memFile{
+		m:      m,
+		name:   name,
+		node:   n,
+		read:   access == os.O_RDONLY || access == os.O_RDWR,
+		write:  write,
+		append: flag&os.O_APPEND != 0,
+	}, nil
+}
+
+// ReadFile lets readFile skip opening a handle
+func (m *MemBackend) ReadFile(name string) ([]byte, error) {
+	name = memPath(name)
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if err := m.fault("open", name); err != nil {
+		return nil, err
+	}
+	n, ok := m.nodes[name]
+	if !ok {
+		return nil, &os.PathError{Op: "open", Path: name, Err: os.ErrNotExist}
+	}
+	if n.mode.IsDir() {
+		return nil, &os.PathError{Op: "read", Path: name, Err: syscall.EISDIR}
+	}
+	if err := m.fault("read", name); err != nil {
+		return nil, err
+	}
+
+	return append([]byte(nil), n.data...), nil
+}
+
+func (m *MemBackend) Stat(name string) (os.FileInfo, error) {
+	name = memPath(name)
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if err := m.fault("stat", name); err != nil {
+		return nil, err
+	}
+	n, ok := m.nodes[name]
+	if !ok {
+		return nil, &os.PathError{Op: "stat", Path: name, Err: os.ErrNotExist}
+	}
+	return n.info(name), nil
+}
+
+func (m *MemBackend) Lstat(name string) (os.FileInfo, error) {
+	return m.Stat(name)
+}
+
+func (m *MemBackend) ReadDir(name string) ([]os.DirEntry, error) {
+	name = memPath(name)
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if err := m.fault("readdir", name); err != nil {
+		return nil, err
+	}
+	n, ok := m.nodes[name]
+	if !ok {
+		return nil, &os.PathError{Op: "readdir", Path: name, Err: os.ErrNotExist}
+	}
+	if !n.mode.IsDir() {
+		return nil, &os.PathError{Op: "readdir", Path: name, Err: syscall.ENOTDIR}
+	}
+
+	var entries []os.DirEntry
+	for p, child := range m.nodes {
+		if p != name && path.Dir(p) == name {
+			entries = append(entries, fs.FileInfoToDirEntry(child.info(p)))
+		}
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Name() < entries[j].Name() })
+	return entries, nil
+}
+
+func (m *MemBackend) Mkdir(name string, perm os.FileMode) error {
+	name = memPath(name)
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if err := m.fault("mkdir", name); err != nil {
+		return err
+	}
+	if _, ok := m.nodes[name]; ok {
+		return &os.PathError{Op: "mkdir", Path: name, Err: os.ErrExist}
+	}
+	if err := m.parentDir("mkdir", name); err != nil {
+		return err
+	}
+	m.nodes[name] = &memNode{mode: os.ModeDir | perm.Perm(), modTime: time.Now(), linked: true}
+	return nil
+}
+
+func (m *MemBackend) MkdirAll(name string, perm os.FileMode) error {
+	name = memPath(name)
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if err := m.fault("mkdir", name); err != nil {
+		return err
+	}
+
+	dir := "/"
+	for _, part := range strings.Split(strings.TrimPrefix(name, "/"), "/") {
+		if part == "" {
+			continue
+		}
+		dir = path.Join(dir, part)
+		if n, ok := m.nodes[dir]; ok {
+			if !n.mode.IsDir() {
+				return &os.PathError{Op: "mkdir", Path: dir, Err: syscall.ENOTDIR}
+			}
+			continue
+		}
+		m.nodes[dir] = &memNode{mode: os.ModeDir | perm.Perm(), modTime: time.Now(), linked: true}
+	}
+	return nil
+}
+
+func (m *MemBackend) Remove(name string) error {
+	name = memPath(name)
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if err := m.fault("remove", name); err != nil {
+		return err
+	}
+	n, ok := m.nodes[name]
+	if !ok {
+		return &os.PathError{Op: "remove", Path: name, Err: os.ErrNotExist}
+	}
+	if name == "/" {
+		return &os.PathError{Op: "remove", Path: name, Err: os.ErrPermission}
+	}
+	if n.mode.IsDir() && m.hasChildren(name) {
+		return &os.PathError{Op: "remove", Path: name, Err: syscall.ENOTEMPTY}
+	}
+	m.unlink(name)
+	return nil
+}
+
+func (m *MemBackend) RemoveAll(name string) error {
+	name = memPath(name)
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if err := m.fault("remove", name); err != nil {
+		return err
+	}
+
+	prefix := strings.TrimSuffix(name, "/") + "/"
+	for p := range m.nodes {
+		if p != "/" && (p == name || strings.HasPrefix(p, prefix)) {
+			m.unlink(p)
+		}
+	}
+	return nil
+}
+
+func (m *MemBackend) Rename(oldName string, newName string) error {
+	oldName = memPath(oldName)
+	newName = memPath(newName)
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if err := m.fault("rename", oldName); err != nil {
+		return err
+	}
+	linkErr := func(err error) error {
+		return &os.LinkError{Op: "rename", Old: oldName, New: newName, Err: err}
+	}
+
+	n, ok := m.nodes[oldName]
+	if !ok {
+		return linkErr(os.ErrNotExist)
+	}
+	if oldName == newName {
+		return nil
+	}
+	if oldName == "/" || strings.HasPrefix(newName, oldName+"/") {
+		return linkErr(syscall.EINVAL)
+	}
+	if err := m.parentDir("rename", newName); err != nil {
+		return linkErr(err.(*os.PathError).Err)
+	}
+
+	if target, ok := m.nodes[newName]; ok {
+		switch {
+		case n.mode.IsDir() && !target.mode.IsDir():
+			return linkErr(syscall.ENOTDIR)
+		case !n.mode.IsDir() && target.mode.IsDir():
+			return linkErr(syscall.EISDIR)
+		case target.mode.IsDir() && m.hasChildren(newName):
+			return linkErr(syscall.ENOTEMPTY)
+		}
+		m.unlink(newName)
+	}
+
+	prefix := oldName + "/"
+	for p, child := range m.nodes {
+		if p == oldName {
+			delete(m.nodes, p)
+			m.nodes[newName] = child
+		} else if strings.HasPrefix(p, prefix) {
+			delete(m.nodes, p)
+			m.nodes[newName+"/"+strings.TrimPrefix(p, prefix)] = child
+		}
+	}
+	return nil
+}
+
+func (m *MemBackend) Chmod(name string, mode os.FileMode) error {
+	name = memPath(name)
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if err := m.fault("chmod", name); err != nil {
+		return err
+	}
+	n, ok := m.nodes[name]
+	if !ok {
+		return &os.PathError{Op: "chmod", Path: name, Err: os.ErrNotExist}
+	}
+	n.mode = n.mode.Type() | mode.Perm()
+	return nil
+}
+
+func (m *MemBackend) Chtimes(name string, atime time.Time, mtime time.Time) error {
+	name = memPath(name)
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if err := m.fault("chtimes", name); err != nil {
+		return err
+	}
+	n, ok := m.nodes[name]
+	if !ok {
+		return &os.PathError{Op: "chtimes", Path: name, Err: os.ErrNotExist}
+	}
+	if !mtime.IsZero() {
+		n.modTime = mtime
+	}
+	return nil
+}
+
+func (n *memNode) info(name string) os.FileInfo {
+	return memFileInfo{name: path.Base(name), size: int64(len(n.data)), mode: n.mode, modTime: n.modTime}
+}
+
+type memFileInfo struct {
+	name    string
+	size    int64
+	mode    os.FileMode
+	modTime time.Time
+}
+
+func (fi memFileInfo) Name() string       { return fi.name }
+func (fi memFileInfo) Size() int64        { return fi.size }
+func (fi memFileInfo) Mode() os.FileMode  { return fi.mode }
+func (fi memFileInfo) ModTime() time.Time { return fi.modTime }
+func (fi memFileInfo) IsDir() bool        { return fi.mode.IsDir() }
+func (fi memFileInfo) Sys() any           { return nil }
+
+// memFile is an open MemBackend file
+type memFile struct {
+	m      *MemBackend
+	name   string
+	node   *memNode
+	offset int64
+	read   bool
+	write  bool
+	append bool
+	closed bool
+}
+
+func (f *memFile) Read(b []byte) (int, error) {
+	f.m.mu.Lock()
+	defer f.m.mu.Unlock()
+
+	if f.closed {
+		return 0, &os.PathError{Op: "read", Path: f.name, Err: os.ErrClosed}
+	}
+	if !f.read {
+		return 0, &os.PathError{Op: "read", Path: f.name, Err: os.ErrPermission}
+	}
+	if f.node.mode.IsDir() {
+		return 0, &os.PathError{Op: "read", Path: f.name, Err: syscall.EISDIR}
+	}
+	if err := f.m.fault("read", f.name); err != nil {
+		return 0, err
+	}
+
+	if f.offset >= int64(len(f.node.data)) {
+		return 0, io.EOF
+	}
+	n := copy(b, f.node.data[f.offset:])
+	f.offset += int64(n)
+	return n, nil
+}
+
+func (f *memFile) Write(b []byte) (int, error) {
+	f.m.mu.Lock()
+	defer f.m.mu.Unlock()
+
+	if f.closed {
+		return 0, &os.PathError{Op: "write", Path: f.name, Err: os.ErrClosed}
+	}
+	if !f.write {
+		return 0, &os.PathError{Op: "write", Path: f.name, Err: os.ErrPermission}
+	}
+	if err := f.m.fault("write", f.name); err != nil {
+		return 0, err
+	}
+
+	n := f.node
+	if f.append {
+		f.offset = int64(len(n.data))
+	}
+	end := f.offset + int64(len(b))
+	if grow := end - int64(len(n.data)); grow > 0 && n.linked {
+		if f.m.capacity > 0 && f.m.used+grow > f.m.capacity {
+			return 0, &os.PathError{Op: "write", Path: f.name, Err: syscall.ENOSPC}
+		}
+		f.m.used += grow
+	}
+
+	if end > int64(len(n.data)) {
+		if end > int64(cap(n.data)) {
+			data := make([]byte, end, 2*end)
+			copy(data, n.data)
+			n.data = data
+		} else {
+			n.data = n.data[:end]
+		}
+	}
+	copy(n.data[f.offset:], b)
+	f.offset = end
+	n.modTime = time.Now()
+	return len(b), nil
+}
+
+func (f *memFile) Seek(offset int64, whence int) (int64, error) {
+	f.m.mu.Lock()
+	defer f.m.mu.Unlock()
+
+	if f.closed {
+		return 0, &os.PathError{Op: "seek", Path: f.name, Err: os.ErrClosed}
+	}
+
+	switch whence {
+	case io.SeekCurrent:
+		offset += f.offset
+	case io.SeekEnd:
+		offset += int64(len(f.node.data))
+	case io.SeekStart:
+	default:
+		return 0, &os.PathError{Op: "seek", Path: f.name, Err: syscall.EINVAL}
+	}
+	if offset < 0 {
+		return 0, &os.PathError{Op: "seek", Path: f.name, Err: syscall.EINVAL}
+	}
+	f.offset = offset
+	return offset, nil
+}
+
+func (f *memFile) Stat() (os.FileInfo, error) {
+	f.m.mu.Lock()
+	defer f.m.mu.Unlock()
+
+	if f.closed {
+		return nil, &os.PathError{Op: "stat", Path: f.name, Err: os.ErrClosed}
+	}
+	if err := f.m.fault("stat", f.name); err != nil {
+		return nil, err
+	}
+	return f.node.info(f.name), nil
+}
+
+func (f *memFile) Sync() error {
+	f.m.mu.Lock()
+	defer f.m.mu.Unlock()
+
+	if f.closed {
+		return &os.PathError{Op: "sync", Path: f.name, Err: os.ErrClosed}
+	}
+	return f.m.fault("sync", f.name)
+}
+
+func (f *memFile) Close() error {
+	f.m.mu.Lock()
+	defer f.m.mu.Unlock()
+
+	if f.closed {
+		return &os.PathError{Op: "close", Path: f.name, Err: os.ErrClosed}
+	}
+	f.closed = true
+	return f.m.fault("close", f.name)
+}
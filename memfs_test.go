@@ -0,0 +1,209 @@
+package GMSFS
+
+import (
+	"errors"
+	"io"
+	"os"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// writeMem creates name on m with content
+func writeMem(t *testing.T, m *MemBackend, name string, content string) {
+	t.Helper()
+	f, err := m.Create(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.Write([]byte(content)); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestMemBackendFiles(t *testing.T) {
+	m := NewMemBackend()
+	if err := m.MkdirAll("/a/b", 0755); err != nil {
+		t.Fatal(err)
+	}
+	writeMem(t, m, "/a/b/f.txt", "hello world")
+
+	f, err := m.OpenFile("/a/b/f.txt", os.O_RDWR, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.Seek(6, io.SeekStart); err != nil {
+		t.Fatal(err)
+	}
+	f.Write([]byte("there"))
+	if _, err := f.Seek(-1, io.SeekStart); !errors.Is(err, syscall.EINVAL) {
+		t.Errorf("Seek before the start = %v", err)
+	}
+	f.Close()
+	if _, err := f.Write([]byte("x")); !errors.Is(err, os.ErrClosed) {
+		t.Errorf("Write after Close = %v", err)
+	}
+	if data, err := m.ReadFile("/a/b/f.txt"); err != nil || string(data) != "hello there" {
+		t.Errorf("ReadFile = %q, %v", data, err)
+	}
+
+	mtime := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	if err := m.Chtimes("/a/b/f.txt", mtime, mtime); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Chmod("/a/b/f.txt", 0600); err != nil {
+		t.Fatal(err)
+	}
+	if info, err := m.Stat("a/b/f.txt"); err != nil || !info.ModTime().Equal(mtime) || info.Mode() != 0600 || info.Size() != 11 {
+		t.Errorf("Stat = %v, %v", info, err)
+	}
+
+	tests := []struct {
+		name string
+		err  error
+		fn   func() error
+	}{
+		{"open missing", os.ErrNotExist, func() error { _, err := m.Open("/missing"); return err }},
+		{"create exclusive", os.ErrExist, func() error {
+			_, err := m.OpenFile("/a/b/f.txt", os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+			return err
+		}},
+		{"write a directory", syscall.EISDIR, func() error { _, err := m.OpenFile("/a", os.O_WRONLY, 0); return err }},
+		{"create without parent", os.ErrNotExist, func() error { _, err := m.Create("/x/y"); return err }},
+		{"create below a file", syscall.ENOTDIR, func() error { _, err := m.Create("/a/b/f.txt/y"); return err }},
+		{"mkdir existing", os.ErrExist, func() error { return m.Mkdir("/a", 0755) }},
+		{"readdir a file", syscall.ENOTDIR, func() error { _, err := m.ReadDir("/a/b/f.txt"); return err }},
+		{"remove non-empty", syscall.ENOTEMPTY, func() error { return m.Remove("/a") }},
+		{"remove root", os.ErrPermission, func() error { return m.Remove("/") }},
+		{"rename into itself", syscall.EINVAL, func() error { return m.Rename("/a", "/a/b/c") }},
+		{"rename file over directory", syscall.EISDIR, func() error { return m.Rename("/a/b/f.txt", "/a") }},
+		{"read write-only", os.ErrPermission, func() error {
+			f, err := m.OpenFile("/a/b/f.txt", os.O_WRONLY, 0)
+			if err != nil {
+				return err
+			}
+			defer f.Close()
+			_, err = f.Read(make([]byte, 1))
+			return err
+		}},
+	}
+	for _, tt := range tests {
+		if err := tt.fn(); !errors.Is(err, tt.err) {
+			t.Errorf("%s = %v, want %v", tt.name, err, tt.err)
+		}
+	}
+}
+
+func TestMemBackendTree(t *testing.T) {
+	m := NewMemBackend()
+	m.MkdirAll("/src/sub", 0755)
+	writeMem(t, m, "/src/b.txt", "b")
+	writeMem(t, m, "/src/a.txt", "a")
+	writeMem(t, m, "/src/sub/c.txt", "c")
+
+	entries, err := m.ReadDir("/src")
+	if err != nil || len(entries) != 3 || entries[0].Name() != "a.txt" || !entries[2].IsDir() {
+		t.Fatalf("ReadDir = %v, %v", entries, err)
+	}
+
+	if err := m.Rename("/src", "/dst"); err != nil {
+		t.Fatal(err)
+	}
+	if data, err := m.ReadFile("/dst/sub/c.txt"); err != nil || string(data) != "c" {
+		t.Errorf("moved child = %q, %v", data, err)
+	}
+	if _, err := m.Stat("/src/a.txt"); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("old child = %v", err)
+	}
+
+	if err := m.RemoveAll("/dst"); err != nil {
+		t.Fatal(err)
+	}
+	if entries, _ := m.ReadDir("/"); len(entries) != 0 || m.Used() != 0 {
+		t.Errorf("after RemoveAll: %v, %d bytes used", entries, m.Used())
+	}
+}
+
+func TestMemBackendFaults(t *testing.T) {
+	m := NewMemBackend()
+	m.InjectFault(MemFault{Op: "write", Path: "/logs/*", Err: syscall.EIO, Times: 2})
+	m.Mkdir("/logs", 0755)
+
+	f, err := m.Create("/logs/app.log")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	for i := 0; i < 2; i++ {
+		var pathErr *os.PathError
+		if _, err := f.Write([]byte("x")); !errors.As(err, &pathErr) || !errors.Is(err, syscall.EIO) {
+			t.Errorf("write %d = %v, want EIO", i, err)
+		}
+	}
+	if _, err := f.Write([]byte("x")); err != nil {
+		t.Errorf("write after the fault ran out = %v", err)
+	}
+
+	// Faults only match their operation and path
+	m.InjectFault(MemFault{Op: "stat", Path: "/logs/*.log", Err: syscall.EACCES})
+	if _, err := m.Stat("/logs/app.log"); !errors.Is(err, syscall.EACCES) {
+		t.Errorf("Stat = %v, want EACCES", err)
+	}
+	if _, err := m.Stat("/logs"); err != nil {
+		t.Errorf("Stat of another path = %v", err)
+	}
+	m.ClearFaults()
+	if _, err := m.Stat("/logs/app.log"); err != nil {
+		t.Errorf("Stat after ClearFaults = %v", err)
+	}
+
+	// Through the package, as the MemBackend doc shows
+	if err := RegisterBackend("memfault", m); err != nil {
+		t.Fatal(err)
+	}
+	defer UnregisterBackend("memfault")
+	m.InjectFault(MemFault{Op: "write", Path: "/logs/*", Err: syscall.ENOSPC})
+	if err := Append("memfault:/logs/app.log", []byte("line\n")); !errors.Is(err, syscall.ENOSPC) {
+		t.Errorf("Append = %v, want ENOSPC", err)
+	}
+}
+
+func TestMemBackendCapacity(t *testing.T) {
+	m := NewMemBackend()
+	m.SetCapacity(10)
+	writeMem(t, m, "/a", "12345678")
+
+	f, err := m.OpenFile("/a", os.O_WRONLY|os.O_APPEND, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.Write([]byte("abc")); !errors.Is(err, syscall.ENOSPC) {
+		t.Errorf("write over capacity = %v", err)
+	}
+	if _, err := f.Write([]byte("ab")); err != nil {
+		t.Errorf("write up to capacity = %v", err)
+	}
+	f.Close()
+	if m.Used() != 10 {
+		t.Errorf("used = %d, want 10", m.Used())
+	}
+
+	// A removed file keeps its open handle working but no longer counts
+	f, err = m.Open("/a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if err := m.Remove("/a"); err != nil {
+		t.Fatal(err)
+	}
+	if data, err := io.ReadAll(f); err != nil || len(data) != 10 || m.Used() != 0 {
+		t.Errorf("read after Remove = %q, %v, used %d", data, err, m.Used())
+	}
+
+	m.SetCapacity(0)
+	writeMem(t, m, "/big", string(make([]byte, 100)))
+}
@@ -0,0 +1,141 @@
+package GMSFS
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"syscall"
+)
+
+// Move renames oldName to newName like Rename. When they are on different filesystems, where a
+// rename fails, it copies the file or tree next to newName, syncs it, renames the copy into
+// place and then removes oldName. Modes, modification times and symbolic links are preserved;
+// other special files make the fallback fail before anything is removed.
+func Move(oldName string, newName string) error {
+	oldName = cleanPath(oldName)
+	newName = cleanPath(newName)
+	if err := requireLocal("move", oldName, newName); err != nil {
+		errorPrinter("Move: "+err.Error(), oldName)
+		return opError("move", oldName, newName, err)
+	}
+
+	if oldName == newName {
+		return nil
+	}
+
+	closeAppendHandlesUnder(oldName)
+	closeAppendHandlesUnder(newName)
+	m := quotaMoving(oldName, newName)
+
+	err := os.Rename(oldName, newName)
+	if err == nil {
+		invalidateStatTree(oldName)
+		invalidateStatTree(newName)
+		m.done()
+		moveTags(oldName, newName)
+		return nil
+	}
+	if !isCrossDevice(err) {
+		errorPrinter("Move (os.Rename): "+err.Error(), oldName)
+		return opError("move", oldName, newName, err)
+	}
+
+	if err := moveAcross(oldName, newName); err != nil {
+		errorPrinter("Move: "+err.Error(), oldName)
+		return opError("move", oldName, newName, err)
+	}
+	m.done()
+	moveTags(oldName, newName)
+	return nil
+}
+
+// isCrossDevice reports whether a rename failed because the paths are on different filesystems
+func isCrossDevice(err error) bool {
+	if runtime.GOOS == "windows" {
+		// ERROR_NOT_SAME_DEVICE
+		var errno syscall.Errno
+		return errors.As(err, &errno) && errno == 17
+	}
+	return errors.Is(err, syscall.EXDEV)
+}
+
+func moveAcross(oldName string, newName string) error {
+	info, err := os.Lstat(oldName)
+	if err != nil {
+		return err
+	}
+
+	// The copy is made on the destination filesystem, so the final rename is atomic
+	tmp, err := os.MkdirTemp(filepath.Dir(newName), "."+filepath.Base(newName)+".move*")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(tmp)
+
+	staged := filepath.Join(tmp, filepath.Base(newName))
+	if err := moveCopy(oldName, staged, info); err != nil {
+		return err
+	}
+
+	if err := os.Rename(staged, newName); err != nil {
+		return err
+	}
+	invalidateStatTree(newName)
+
+	if info.IsDir() {
+		err = os.RemoveAll(oldName)
+	} else {
+		err = os.Remove(oldName)
+	}
+	invalidateStatTree(oldName)
+	if err != nil {
+		return fmt.Errorf("moved to %s but removing the source failed: %w", newName, err)
+	}
+	return nil
+}
+
+// moveCopy copies src to dst, directory contents first so directory times are kept
+func moveCopy(src string, dst string, info os.FileInfo) error {
+	switch mode := info.Mode(); {
+	case mode&os.ModeSymlink != 0:
+		target, err := os.Readlink(src)
+		if err != nil {
+			return err
+		}
+		return os.Symlink(target, dst)
+
+	case mode.IsDir():
+		if err := os.Mkdir(dst, 0700); err != nil {
+			return err
+		}
+		entries, err := os.ReadDir(src)
+		if err != nil {
+			return err
+		}
+		for _, entry := range entries {
+			child, err := entry.Info()
+			if err != nil {
+				return err
+			}
+			if err := moveCopy(filepath.Join(src, entry.Name()), filepath.Join(dst, entry.Name()), child); err != nil {
+				return err
+			}
+		}
+		if err := os.Chmod(dst, mode); err != nil {
+			return err
+		}
+
+	case mode.IsRegular():
+		if err := copyFile(context.Background(), src, dst, nil); err != nil {
+			return err
+		}
+
+	default:
+		return fmt.Errorf("cannot move special file %s across filesystems", src)
+	}
+
+	return os.Chtimes(dst, info.ModTime(), info.ModTime())
+}
@@ -0,0 +1,23 @@
+package GMSFS
+
+import "syscall"
+
+// Filesystem magic numbers from statfs(2) where inotify misses changes made by other hosts
+var networkFSTypes = map[uint32]bool{
+	0x6969:     true, // NFS
+	0x517B:     true, // SMB
+	0xFF534D42: true, // CIFS
+	0xFE534D42: true, // SMB2
+	0x564C:     true, // NCP
+	0x47504653: true, // GPFS
+	0x00C36400: true, // CephFS
+}
+
+// networkFS reports whether path is on a network filesystem
+func networkFS(path string) bool {
+	var st syscall.Statfs_t
+	if err := syscall.Statfs(path, &st); err != nil {
+		return false
+	}
+	return networkFSTypes[uint32(st.Type)]
+}
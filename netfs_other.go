@@ -0,0 +1,8 @@
+//go:build !linux
+
+package GMSFS
+
+// networkFS reports whether path is on a network filesystem; only detected on Linux
+func networkFS(path string) bool {
+	return false
+}
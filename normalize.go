@@ -0,0 +1,101 @@
+package GMSFS
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"sync/atomic"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+// UnicodeForm is a Unicode normalization form for path names, see SetUnicodeNormalization
+type UnicodeForm string
+
+const (
+	FormNone UnicodeForm = ""    // Names are used byte for byte
+	FormNFC  UnicodeForm = "nfc" // Composed, as Linux and Windows applications usually write names
+	FormNFD  UnicodeForm = "nfd" // Decomposed, as macOS (HFS+, and many apps on APFS) writes names
+)
+
+// unicodeForm is the form of SetUnicodeNormalization; nil leaves names alone
+var unicodeForm atomic.Pointer[norm.Form]
+
+// SetUnicodeNormalization makes every operation convert path names to form, so "café" typed
+// on Linux and the decomposed "café" a Mac wrote into a synced folder name the same file.
+// Names already on disk are found in whichever form they were written: when the converted
+// name does not exist, each element is looked up among the directory entries that normalize
+// to it, combined with SetCaseInsensitive when that is on. New files get names in form.
+// Listings return names as they are on disk. FormNone, the default, turns it off.
+func SetUnicodeNormalization(form UnicodeForm) error {
+	var f *norm.Form
+	switch form {
+	case FormNone:
+	case FormNFC:
+		f = new(norm.Form)
+		*f = norm.NFC
+	case FormNFD:
+		f = new(norm.Form)
+		*f = norm.NFD
+	default:
+		return fmt.Errorf("unknown unicode normalization %q", form)
+	}
+
+	if old := unicodeForm.Swap(f); (old == nil) != (f == nil) || (old != nil && *old != *f) {
+		FlushStatCache()
+	}
+	return nil
+}
+
+// UnicodeNormalization returns the form set with SetUnicodeNormalization
+func UnicodeNormalization() UnicodeForm {
+	f := unicodeForm.Load()
+	switch {
+	case f == nil:
+		return FormNone
+	case *f == norm.NFD:
+		return FormNFD
+	default:
+		return FormNFC
+	}
+}
+
+// normalizePath converts the local path name to the chosen form and, when that does not
+// exist, to the differently normalized name that does, as far as it exists
+func normalizePath(name string) string {
+	f := unicodeForm.Load()
+	if f == nil || isASCII(name) {
+		return name
+	}
+
+	converted := f.String(name)
+	if _, err := os.Lstat(converted); err == nil || !os.IsNotExist(err) {
+		return converted
+	}
+	resolved, err := resolveName(converted, caseInsensitive.Load())
+	if err != nil && !os.IsNotExist(err) {
+		return converted
+	}
+	return resolved
+}
+
+// sameName reports whether the names a and b are equal under the normalization option, and
+// regardless of case when fold is set
+func sameName(a string, b string, fold bool) bool {
+	if f := unicodeForm.Load(); f != nil {
+		a, b = f.String(a), f.String(b)
+	}
+	if fold {
+		return strings.EqualFold(a, b)
+	}
+	return a == b
+}
+
+func isASCII(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if s[i] >= 0x80 {
+			return false
+		}
+	}
+	return true
+}
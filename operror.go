@@ -0,0 +1,116 @@
+package GMSFS
+
+import (
+	"errors"
+	"os"
+	"syscall"
+)
+
+// OpError is the error the file operations of the package return: the operation, the path it
+// failed on, the destination for operations with two paths (copies, moves, renames and links)
+// and the underlying error, e.g. "copy /in/a.txt -> /out/a.txt: no space left on device".
+//
+// Test it with errors.Is (errors.Is(err, fs.ErrNotExist)) rather than os.IsNotExist, which
+// only looks inside the os error types. errors.As still finds an *os.PathError or, for two
+// paths, an *os.LinkError, built from the OpError, so code written for those keeps working.
+type OpError struct {
+	op   string
+	path string
+	dest string
+	err  error
+}
+
+func (e *OpError) Error() string {
+	if e.dest != "" {
+		return e.op + " " + e.path + " -> " + e.dest + ": " + e.err.Error()
+	}
+	return e.op + " " + e.path + ": " + e.err.Error()
+}
+
+// Op returns the operation, e.g. "copy", "read" or "mkdir"
+func (e *OpError) Op() string {
+	return e.op
+}
+
+// Path returns the path the operation failed on; for copies, moves and renames the source.
+// For operations on a tree it is the entry that failed rather than the top of the tree.
+func (e *OpError) Path() string {
+	return e.path
+}
+
+// Dest returns the destination of a copy, move, rename or link, and "" for other operations
+func (e *OpError) Dest() string {
+	return e.dest
+}
+
+// Errno returns the system error number behind the failure, or 0 when it did not come from
+// the operating system
+func (e *OpError) Errno() syscall.Errno {
+	var errno syscall.Errno
+	if errors.As(e.err, &errno) {
+		return errno
+	}
+	return 0
+}
+
+func (e *OpError) Unwrap() error {
+	return e.err
+}
+
+// As lets errors.As fill in an *os.PathError or *os.LinkError from e
+func (e *OpError) As(target any) bool {
+	switch t := target.(type) {
+	case **os.PathError:
+		if e.dest == "" {
+			*t = &os.PathError{Op: e.op, Path: e.path, Err: e.err}
+			return true
+		}
+	case **os.LinkError:
+		if e.dest != "" {
+			*t = &os.LinkError{Op: e.op, Old: e.path, New: e.dest, Err: e.err}
+			return true
+		}
+	}
+	return false
+}
+
+// opError wraps a failure of the operation op on path (and dest) in an OpError. An error that
+// already carries an OpError, from a deeper and more precise operation, is returned as it is.
+// The os error types are unwrapped when they name the same paths, so they aren't repeated in
+// the message; a path below a single path, from an operation on a tree, becomes the Path.
+func opError(op string, path string, dest string, err error) error {
+	if err == nil {
+		return nil
+	}
+	var oe *OpError
+	if errors.As(err, &oe) {
+		return err
+	}
+
+	e := &OpError{op: op, path: path, dest: dest, err: err}
+	switch inner := err.(type) {
+	case *os.PathError:
+		switch {
+		case samePath(inner.Path, path) || dest != "" && samePath(inner.Path, dest):
+			e.err = inner.Err
+		case dest == "":
+			if _, ok := relWithin(path, inner.Path); ok && isLocal(path) {
+				e.path, e.err = inner.Path, inner.Err
+			}
+		}
+	case *os.LinkError:
+		if samePath(inner.Old, path) && samePath(inner.New, dest) {
+			e.err = inner.Err
+		}
+	}
+	return e
+}
+
+// samePath reports whether p, as an os or backend error names it, is name
+func samePath(p string, name string) bool {
+	if p == name {
+		return true
+	}
+	_, bp := backendFor(name)
+	return p == bp
+}
@@ -0,0 +1,618 @@
+package GMSFS
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// Overlay is a Backend that layers a writable directory over read-only lower layers, so
+// defaults can ship embedded and be overridden on disk:
+//
+//	//go:embed assets
+//	var assets embed.FS
+//
+//	defaults, _ := fs.Sub(assets, "assets")
+//	o, err := GMSFS.NewOverlay("/var/lib/app/assets", defaults)
+//	GMSFS.RegisterBackend("assets", o)
+//	page, err := GMSFS.ReadFile("assets:/index.html") // The file on disk if there is one
+//
+// Names are looked up in the upper directory first, then in the lower layers in the order
+// given. Writes, Chmod and Chtimes copy a lower file up before changing it. Deleting
+// something that exists in a lower layer leaves a whiteout, a ".wh.<name>" file next to it in
+// the upper directory, and a directory recreated over a whiteout is marked opaque with a
+// ".wh..wh..opq" file so the lower content stays hidden. Names starting with ".wh." are
+// reserved. Renaming a directory that exists in a lower layer fails with syscall.EXDEV, as
+// on overlayfs; copy it instead.
+type Overlay struct {
+	mu    sync.Mutex // Serialises changes, which may copy files up
+	upper string
+	lower []fs.FS
+}
+
+const (
+	whiteoutPrefix = ".wh."
+	opaqueMarker   = ".wh..wh..opq"
+)
+
+// NewOverlay returns an Overlay writing to the directory upper, which is created when
+// missing, over the lower layers. Use os.DirFS for lower layers on disk.
+func NewOverlay(upper string, lower ...fs.FS) (*Overlay, error) {
+	if err := os.MkdirAll(upper, 0755); err != nil {
+		return nil, err
+	}
+	info, err := os.Stat(upper)
+	if err != nil {
+		return nil, err
+	}
+	if !info.IsDir() {
+		return nil, fmt.Errorf("NewOverlay: %s is not a directory", upper)
+	}
+	return &Overlay{upper: upper, lower: lower}, nil
+}
+
+// overlayFile is an open file from a lower layer
+type overlayFile struct {
+	fs.File
+	name string
+}
+
+func (f *overlayFile) Write([]byte) (int, error) {
+	return 0, &os.PathError{Op: "write", Path: f.name, Err: os.ErrPermission}
+}
+
+func (f *overlayFile) Seek(offset int64, whence int) (int64, error) {
+	if s, ok := f.File.(io.Seeker); ok {
+		return s.Seek(offset, whence)
+	}
+	return 0, &os.PathError{Op: "seek", Path: f.name, Err: errors.ErrUnsupported}
+}
+
+func (f *overlayFile) Sync() error { return nil }
+
+func (o *Overlay) upperPath(name string) string {
+	return filepath.Join(o.upper, filepath.FromSlash(name))
+}
+
+// lowerPath turns a backend path into an io/fs one
+func lowerPath(name string) string {
+	if name == "/" {
+		return "."
+	}
+	return strings.TrimPrefix(name, "/")
+}
+
+func whiteoutName(name string) string {
+	return path.Join(path.Dir(name), whiteoutPrefix+path.Base(name))
+}
+
+// overlayReserved reports whether name is a whiteout or opaque marker
+func overlayReserved(name string) bool {
+	return strings.HasPrefix(path.Base(name), whiteoutPrefix)
+}
+
+func (o *Overlay) upperExists(name string) bool {
+	_, err := os.Lstat(o.upperPath(name))
+	return err == nil
+}
+
+// overlayErr reports err against the backend path rather than the upper or lower one
+func overlayErr(op string, name string, err error) error {
+	var pe *fs.PathError
+	if errors.As(err, &pe) {
+		return &os.PathError{Op: op, Path: name, Err: pe.Err}
+	}
+	return err
+}
+
+// lowerHidden reports whether a whiteout or an opaque directory in the upper layer hides
+// name in the lower layers
+func (o *Overlay) lowerHidden(name string) bool {
+	dir := "/"
+	for _, part := range strings.Split(strings.TrimPrefix(name, "/"), "/") {
+		if part == "" {
+			continue
+		}
+		if o.upperExists(path.Join(dir, opaqueMarker)) || o.upperExists(path.Join(dir, whiteoutPrefix+part)) {
+			return true
+		}
+		dir = path.Join(dir, part)
+	}
+	return false
+}
+
+// lowerStat finds name in the first lower layer having it
+func (o *Overlay) lowerStat(name string) (fs.FileInfo, fs.FS, error) {
+	if !o.lowerHidden(name) {
+		for _, l := range o.lower {
+			if info, err := fs.Stat(l, lowerPath(name)); err == nil {
+				return info, l, nil
+			}
+		}
+	}
+	return nil, nil, &os.PathError{Op: "stat", Path: name, Err: os.ErrNotExist}
+}
+
+func (o *Overlay) stat(name string, lstat bool) (os.FileInfo, error) {
+	if overlayReserved(name) {
+		return nil, &os.PathError{Op: "stat", Path: name, Err: os.ErrNotExist}
+	}
+
+	stat := os.Stat
+	if lstat {
+		stat = os.Lstat
+	}
+	info, err := stat(o.upperPath(name))
+	if err == nil || !errors.Is(err, fs.ErrNotExist) {
+		return info, overlayErr("stat", name, err)
+	}
+
+	info, _, err = o.lowerStat(name)
+	return info, err
+}
+
+// copyUpParents makes sure the directories above name exist in the upper layer
+func (o *Overlay) copyUpParents(name string) error {
+	dir := "/"
+	for _, part := range strings.Split(strings.TrimPrefix(path.Dir(name), "/"), "/") {
+		if part == "" {
+			continue
+		}
+		dir = path.Join(dir, part)
+
+		if info, err := os.Stat(o.upperPath(dir)); err == nil {
+			if !info.IsDir() {
+				return &os.PathError{Op: "mkdir", Path: dir, Err: syscall.ENOTDIR}
+			}
+			continue
+		}
+		info, _, err := o.lowerStat(dir)
+		if err != nil {
+			return &os.PathError{Op: "open", Path: name, Err: os.ErrNotExist}
+		}
+		if !info.IsDir() {
+			return &os.PathError{Op: "mkdir", Path: dir, Err: syscall.ENOTDIR}
+		}
+		if err := os.Mkdir(o.upperPath(dir), info.Mode().Perm()|0700); err != nil && !os.IsExist(err) {
+			return overlayErr("mkdir", dir, err)
+		}
+	}
+	return nil
+}
+
+// copyUp copies name from the lower layer l to the upper one, without its content unless
+// content is set
+func (o *Overlay) copyUp(name string, info fs.FileInfo, l fs.FS, content bool) error {
+	if err := o.copyUpParents(name); err != nil {
+		return err
+	}
+	upper := o.upperPath(name)
+	if info.IsDir() {
+		if err := os.Mkdir(upper, info.Mode().Perm()|0700); err != nil && !os.IsExist(err) {
+			return overlayErr("mkdir", name, err)
+		}
+		return nil
+	}
+
+	out, err := os.OpenFile(upper, os.O_WRONLY|os.O_CREATE|os.O_EXCL, info.Mode().Perm())
+	if err != nil {
+		return overlayErr("open", name, err)
+	}
+	if content {
+		var in fs.File
+		in, err = l.Open(lowerPath(name))
+		if err == nil {
+			_, err = io.Copy(out, in)
+			in.Close()
+		}
+	}
+	if cerr := out.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(upper)
+		return overlayErr("copyup", name, err)
+	}
+	os.Chtimes(upper, info.ModTime(), info.ModTime())
+	return nil
+}
+
+// ensureUpper copies name up unless the upper layer already has it
+func (o *Overlay) ensureUpper(op string, name string) error {
+	if overlayReserved(name) {
+		return &os.PathError{Op: op, Path: name, Err: os.ErrNotExist}
+	}
+	if o.upperExists(name) {
+		return nil
+	}
+	info, l, err := o.lowerStat(name)
+	if err != nil {
+		return &os.PathError{Op: op, Path: name, Err: os.ErrNotExist}
+	}
+	return o.copyUp(name, info, l, true)
+}
+
+// whiteout hides name in the lower layers
+func (o *Overlay) whiteout(name string) error {
+	if err := o.copyUpParents(name); err != nil {
+		return err
+	}
+	f, err := os.Create(o.upperPath(whiteoutName(name)))
+	if err != nil {
+		return overlayErr("remove", name, err)
+	}
+	return f.Close()
+}
+
+// makeOpaque hides the lower content of the upper directory name
+func (o *Overlay) makeOpaque(name string) error {
+	f, err := os.Create(o.upperPath(path.Join(name, opaqueMarker)))
+	if err != nil {
+		return overlayErr("mkdir", name, err)
+	}
+	return f.Close()
+}
+
+func (o *Overlay) Open(name string) (File, error) {
+	return o.OpenFile(name, os.O_RDONLY, 0)
+}
+
+func (o *Overlay) Create(name string) (File, error) {
+	return o.OpenFile(name, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0666)
+}
+
+func (o *Overlay) OpenFile(name string, flag int, perm os.FileMode) (File, error) {
+	name = memPath(name)
+	if overlayReserved(name) {
+		return nil, &os.PathError{Op: "open", Path: name, Err: os.ErrInvalid}
+	}
+
+	if flag&(os.O_WRONLY|os.O_RDWR|os.O_CREATE|os.O_TRUNC|os.O_APPEND) == 0 {
+		f, err := os.Open(o.upperPath(name))
+		if err == nil {
+			return f, nil
+		}
+		if !errors.Is(err, fs.ErrNotExist) {
+			return nil, overlayErr("open", name, err)
+		}
+
+		_, l, err := o.lowerStat(name)
+		if err != nil {
+			return nil, &os.PathError{Op: "open", Path: name, Err: os.ErrNotExist}
+		}
+		lf, err := l.Open(lowerPath(name))
+		if err != nil {
+			return nil, overlayErr("open", name, err)
+		}
+		return &overlayFile{File: lf, name: name}, nil
+	}
+
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	if !o.upperExists(name) {
+		info, l, err := o.lowerStat(name)
+		switch {
+		case err == nil && flag&os.O_CREATE != 0 && flag&os.O_EXCL != 0:
+			return nil, &os.PathError{Op: "open", Path: name, Err: os.ErrExist}
+		case err == nil:
+			if err := o.copyUp(name, info, l, flag&os.O_TRUNC == 0); err != nil {
+				return nil, err
+			}
+		case flag&os.O_CREATE == 0:
+			return nil, &os.PathError{Op: "open", Path: name, Err: os.ErrNotExist}
+		default:
+			if err := o.copyUpParents(name); err != nil {
+				return nil, err
+			}
+			os.Remove(o.upperPath(whiteoutName(name)))
+		}
+	}
+
+	f, err := os.OpenFile(o.upperPath(name), flag, perm)
+	if err != nil {
+		return nil, overlayErr("open", name, err)
+	}
+	return f, nil
+}
+
+// ReadFile lets readFile skip opening a handle
+func (o *Overlay) ReadFile(name string) ([]byte, error) {
+	name = memPath(name)
+	if overlayReserved(name) {
+		return nil, &os.PathError{Op: "open", Path: name, Err: os.ErrNotExist}
+	}
+
+	data, err := os.ReadFile(o.upperPath(name))
+	if err == nil || !errors.Is(err, fs.ErrNotExist) {
+		return data, overlayErr("read", name, err)
+	}
+
+	_, l, err := o.lowerStat(name)
+	if err != nil {
+		return nil, &os.PathError{Op: "open", Path: name, Err: os.ErrNotExist}
+	}
+	data, err = fs.ReadFile(l, lowerPath(name))
+	return data, overlayErr("read", name, err)
+}
+
+func (o *Overlay) Stat(name string) (os.FileInfo, error) {
+	return o.stat(memPath(name), false)
+}
+
+func (o *Overlay) Lstat(name string) (os.FileInfo, error) {
+	return o.stat(memPath(name), true)
+}
+
+// ReadDir merges the directory across the layers, leaving out whited out names
+func (o *Overlay) ReadDir(name string) ([]os.DirEntry, error) {
+	name = memPath(name)
+	if overlayReserved(name) {
+		return nil, &os.PathError{Op: "readdir", Path: name, Err: os.ErrNotExist}
+	}
+
+	var entries []os.DirEntry
+	seen := map[string]bool{}
+	found, opaque := false, false
+
+	upper, err := os.ReadDir(o.upperPath(name))
+	switch {
+	case err == nil:
+		found = true
+		for _, e := range upper {
+			switch {
+			case e.Name() == opaqueMarker:
+				opaque = true
+			case overlayReserved(e.Name()):
+				seen[strings.TrimPrefix(e.Name(), whiteoutPrefix)] = true
+			default:
+				seen[e.Name()] = true
+				entries = append(entries, e)
+			}
+		}
+	case !errors.Is(err, fs.ErrNotExist):
+		return nil, overlayErr("readdir", name, err)
+	}
+
+	if !opaque && !o.lowerHidden(name) {
+		for _, l := range o.lower {
+			lower, err := fs.ReadDir(l, lowerPath(name))
+			if err != nil {
+				continue
+			}
+			found = true
+			for _, e := range lower {
+				if !seen[e.Name()] {
+					seen[e.Name()] = true
+					entries = append(entries, e)
+				}
+			}
+		}
+	}
+
+	if !found {
+		return nil, &os.PathError{Op: "readdir", Path: name, Err: os.ErrNotExist}
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Name() < entries[j].Name() })
+	return entries, nil
+}
+
+func (o *Overlay) Mkdir(name string, perm os.FileMode) error {
+	name = memPath(name)
+
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	return o.mkdir(name, perm)
+}
+
+// mkdir creates name in the upper layer. Callers hold o.mu.
+func (o *Overlay) mkdir(name string, perm os.FileMode) error {
+	if overlayReserved(name) {
+		return &os.PathError{Op: "mkdir", Path: name, Err: os.ErrInvalid}
+	}
+	if _, err := o.stat(name, true); err == nil {
+		return &os.PathError{Op: "mkdir", Path: name, Err: os.ErrExist}
+	}
+	if err := o.copyUpParents(name); err != nil {
+		return err
+	}
+
+	if err := os.Mkdir(o.upperPath(name), perm); err != nil {
+		return overlayErr("mkdir", name, err)
+	}
+	if wh := o.upperPath(whiteoutName(name)); os.Remove(wh) == nil {
+		return o.makeOpaque(name)
+	}
+	return nil
+}
+
+func (o *Overlay) MkdirAll(name string, perm os.FileMode) error {
+	name = memPath(name)
+
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	dir := "/"
+	for _, part := range strings.Split(strings.TrimPrefix(name, "/"), "/") {
+		if part == "" {
+			continue
+		}
+		dir = path.Join(dir, part)
+		if info, err := o.stat(dir, false); err == nil {
+			if !info.IsDir() {
+				return &os.PathError{Op: "mkdir", Path: dir, Err: syscall.ENOTDIR}
+			}
+			continue
+		}
+		if err := o.mkdir(dir, perm); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (o *Overlay) Remove(name string) error {
+	name = memPath(name)
+
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	info, err := o.stat(name, true)
+	if err != nil {
+		return &os.PathError{Op: "remove", Path: name, Err: os.ErrNotExist}
+	}
+	if name == "/" {
+		return &os.PathError{Op: "remove", Path: name, Err: os.ErrPermission}
+	}
+	if info.IsDir() {
+		if entries, err := o.ReadDir(name); err == nil && len(entries) > 0 {
+			return &os.PathError{Op: "remove", Path: name, Err: syscall.ENOTEMPTY}
+		}
+	}
+	return o.remove(name)
+}
+
+// remove deletes name from the upper layer and whites it out of the lower ones. Callers
+// hold o.mu.
+func (o *Overlay) remove(name string) error {
+	// The upper directory may still hold whiteouts, hence RemoveAll
+	if err := os.RemoveAll(o.upperPath(name)); err != nil {
+		return overlayErr("remove", name, err)
+	}
+	if _, _, err := o.lowerStat(name); err == nil {
+		return o.whiteout(name)
+	}
+	return nil
+}
+
+func (o *Overlay) RemoveAll(name string) error {
+	name = memPath(name)
+
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	if name != "/" {
+		if _, err := o.stat(name, true); err != nil {
+			return nil
+		}
+		return o.remove(name)
+	}
+
+	entries, err := os.ReadDir(o.upper)
+	if err != nil {
+		return overlayErr("remove", name, err)
+	}
+	for _, e := range entries {
+		if err := os.RemoveAll(filepath.Join(o.upper, e.Name())); err != nil {
+			return overlayErr("remove", path.Join("/", e.Name()), err)
+		}
+	}
+	return o.makeOpaque("/")
+}
+
+func (o *Overlay) Rename(oldName string, newName string) error {
+	oldName = memPath(oldName)
+	newName = memPath(newName)
+
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	linkErr := func(err error) error {
+		return &os.LinkError{Op: "rename", Old: oldName, New: newName, Err: err}
+	}
+
+	info, err := o.stat(oldName, true)
+	if err != nil {
+		return linkErr(os.ErrNotExist)
+	}
+	if oldName == newName {
+		return nil
+	}
+	if oldName == "/" || overlayReserved(newName) || strings.HasPrefix(newName, oldName+"/") {
+		return linkErr(syscall.EINVAL)
+	}
+
+	newLower, replacing := false, false
+	if target, err := o.stat(newName, true); err == nil {
+		switch {
+		case info.IsDir() && !target.IsDir():
+			return linkErr(syscall.ENOTDIR)
+		case !info.IsDir() && target.IsDir():
+			return linkErr(syscall.EISDIR)
+		case target.IsDir():
+			if entries, err := o.ReadDir(newName); err == nil && len(entries) > 0 {
+				return linkErr(syscall.ENOTEMPTY)
+			}
+		}
+		replacing = true
+	}
+	if _, _, err := o.lowerStat(newName); err == nil {
+		newLower = true
+	}
+
+	_, _, err = o.lowerStat(oldName)
+	oldLower := err == nil
+	if info.IsDir() && oldLower {
+		return linkErr(syscall.EXDEV)
+	}
+
+	if err := o.ensureUpper("rename", oldName); err != nil {
+		return err
+	}
+	if err := o.copyUpParents(newName); err != nil {
+		return err
+	}
+	if replacing {
+		if err := os.RemoveAll(o.upperPath(newName)); err != nil {
+			return overlayErr("rename", newName, err)
+		}
+	}
+	if err := os.Rename(o.upperPath(oldName), o.upperPath(newName)); err != nil {
+		return linkErr(errors.Unwrap(err))
+	}
+
+	hadWhiteout := os.Remove(o.upperPath(whiteoutName(newName))) == nil
+	if info.IsDir() && (newLower || hadWhiteout) {
+		if err := o.makeOpaque(newName); err != nil {
+			return err
+		}
+	}
+	if oldLower {
+		return o.whiteout(oldName)
+	}
+	return nil
+}
+
+func (o *Overlay) Chmod(name string, mode os.FileMode) error {
+	name = memPath(name)
+
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	if err := o.ensureUpper("chmod", name); err != nil {
+		return err
+	}
+	return overlayErr("chmod", name, os.Chmod(o.upperPath(name), mode))
+}
+
+func (o *Overlay) Chtimes(name string, atime time.Time, mtime time.Time) error {
+	name = memPath(name)
+
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	if err := o.ensureUpper("chtimes", name); err != nil {
+		return err
+	}
+	return overlayErr("chtimes", name, os.Chtimes(o.upperPath(name), atime, mtime))
+}
@@ -0,0 +1,291 @@
+package GMSFS
+
+import (
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"testing"
+	"testing/fstest"
+	"time"
+)
+
+// newTestOverlay layers a fresh upper directory over two in-memory lower layers
+func newTestOverlay(t *testing.T) (*Overlay, string) {
+	t.Helper()
+	upper := filepath.Join(t.TempDir(), "upper")
+	base := fstest.MapFS{
+		"a.txt":       {Data: []byte("base a"), Mode: 0644},
+		"b.txt":       {Data: []byte("base b"), Mode: 0644},
+		"dir/c.txt":   {Data: []byte("base c"), Mode: 0644},
+		"dir/sub/d":   {Data: []byte("base d"), Mode: 0644},
+		"only/e.txt":  {Data: []byte("base e"), Mode: 0644},
+		"lower/f.txt": {Data: []byte("base f"), Mode: 0644},
+	}
+	extra := fstest.MapFS{
+		"a.txt":     {Data: []byte("extra a"), Mode: 0644},
+		"dir/g.txt": {Data: []byte("extra g"), Mode: 0644},
+	}
+	o, err := NewOverlay(upper, base, extra)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return o, upper
+}
+
+func readOverlay(t *testing.T, o *Overlay, name string) string {
+	t.Helper()
+	f, err := o.Open(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	data, err := io.ReadAll(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
+
+func overlayNames(t *testing.T, o *Overlay, dir string) string {
+	t.Helper()
+	entries, err := o.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, e := range entries {
+		names = append(names, e.Name())
+	}
+	return strings.Join(names, ",")
+}
+
+func TestOverlayLookup(t *testing.T) {
+	o, upper := newTestOverlay(t)
+	writeTestTree(t, upper, map[string]string{"b.txt": "upper b", "dir/h.txt": "upper h"})
+
+	for name, want := range map[string]string{"/a.txt": "base a", "/b.txt": "upper b", "/dir/g.txt": "extra g", "/dir/h.txt": "upper h"} {
+		if got := readOverlay(t, o, name); got != want {
+			t.Errorf("%s = %q, want %q", name, got, want)
+		}
+	}
+	if got := overlayNames(t, o, "/dir"); got != "c.txt,g.txt,h.txt,sub" {
+		t.Errorf("ReadDir(/dir) = %s", got)
+	}
+	if _, err := o.Stat("/missing"); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("Stat missing: %v", err)
+	}
+
+	f, err := o.Open("/a.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.Write([]byte("x")); !errors.Is(err, os.ErrPermission) {
+		t.Errorf("Write to a lower file: %v", err)
+	}
+	f.Close()
+}
+
+func TestOverlayCopyUp(t *testing.T) {
+	o, upper := newTestOverlay(t)
+
+	f, err := o.OpenFile("/dir/c.txt", os.O_WRONLY|os.O_APPEND, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.Write([]byte(" more")); err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+	if data, err := os.ReadFile(filepath.Join(upper, "dir", "c.txt")); err != nil || string(data) != "base c more" {
+		t.Errorf("copied up = %q, %v", data, err)
+	}
+
+	f, err = o.OpenFile("/b.txt", os.O_WRONLY|os.O_TRUNC, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+	if got := readOverlay(t, o, "/b.txt"); got != "" {
+		t.Errorf("truncated copy-up = %q", got)
+	}
+
+	if err := o.Chmod("/only/e.txt", 0600); err != nil {
+		t.Fatal(err)
+	}
+	if info, err := os.Stat(filepath.Join(upper, "only", "e.txt")); err != nil || info.Mode().Perm() != 0600 {
+		t.Errorf("Chmod copy-up = %v, %v", info, err)
+	}
+	mtime := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	if err := o.Chtimes("/lower/f.txt", mtime, mtime); err != nil {
+		t.Fatal(err)
+	}
+	if info, err := o.Stat("/lower/f.txt"); err != nil || !info.ModTime().Equal(mtime) {
+		t.Errorf("Chtimes copy-up = %v, %v", info, err)
+	}
+	if got := readOverlay(t, o, "/lower/f.txt"); got != "base f" {
+		t.Errorf("content after Chtimes = %q", got)
+	}
+
+	if _, err := o.OpenFile("/a.txt", os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644); !errors.Is(err, os.ErrExist) {
+		t.Errorf("exclusive create over a lower file: %v", err)
+	}
+	if err := o.Chmod("/missing", 0600); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("Chmod missing: %v", err)
+	}
+}
+
+func TestOverlayWhiteouts(t *testing.T) {
+	o, upper := newTestOverlay(t)
+
+	if err := o.Remove("/dir/c.txt"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(upper, "dir", ".wh.c.txt")); err != nil {
+		t.Errorf("no whiteout: %v", err)
+	}
+	if _, err := o.Stat("/dir/c.txt"); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("Stat removed file: %v", err)
+	}
+	if got := overlayNames(t, o, "/dir"); got != "g.txt,sub" {
+		t.Errorf("ReadDir after Remove = %s", got)
+	}
+
+	// Recreating the file clears the whiteout
+	f, err := o.Create("/dir/c.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+	if got := overlayNames(t, o, "/dir"); got != "c.txt,g.txt,sub" {
+		t.Errorf("ReadDir after Create = %s", got)
+	}
+
+	if err := o.Remove("/dir"); !errors.Is(err, syscall.ENOTEMPTY) {
+		t.Errorf("Remove non-empty dir: %v", err)
+	}
+	if err := o.Remove("/"); err == nil {
+		t.Error("Remove / succeeded")
+	}
+
+	// A directory recreated over a removed one starts empty
+	if err := o.RemoveAll("/dir"); err != nil {
+		t.Fatal(err)
+	}
+	if err := o.Mkdir("/dir", 0755); err != nil {
+		t.Fatal(err)
+	}
+	if got := overlayNames(t, o, "/dir"); got != "" {
+		t.Errorf("ReadDir of opaque dir = %s", got)
+	}
+	if _, err := o.Stat("/dir/sub/d"); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("Stat below opaque dir: %v", err)
+	}
+
+	if err := o.RemoveAll("/"); err != nil {
+		t.Fatal(err)
+	}
+	if got := overlayNames(t, o, "/"); got != "" {
+		t.Errorf("ReadDir(/) after RemoveAll = %s", got)
+	}
+}
+
+func TestOverlayReservedNames(t *testing.T) {
+	o, _ := newTestOverlay(t)
+	if err := o.Remove("/a.txt"); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := o.Create("/.wh.x"); !errors.Is(err, os.ErrInvalid) {
+		t.Errorf("Create reserved name: %v", err)
+	}
+	if err := o.Mkdir("/.wh.d", 0755); !errors.Is(err, os.ErrInvalid) {
+		t.Errorf("Mkdir reserved name: %v", err)
+	}
+	if _, err := o.Stat("/.wh.a.txt"); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("Stat whiteout: %v", err)
+	}
+	if err := o.Rename("/b.txt", "/.wh.b.txt"); !errors.Is(err, syscall.EINVAL) {
+		t.Errorf("Rename to reserved name: %v", err)
+	}
+	if got := overlayNames(t, o, "/"); strings.Contains(got, ".wh.") {
+		t.Errorf("ReadDir shows markers: %s", got)
+	}
+}
+
+func TestOverlayRename(t *testing.T) {
+	o, upper := newTestOverlay(t)
+
+	if err := o.Rename("/b.txt", "/dir/b2.txt"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := o.Stat("/b.txt"); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("old name after Rename: %v", err)
+	}
+	if got := readOverlay(t, o, "/dir/b2.txt"); got != "base b" {
+		t.Errorf("renamed = %q", got)
+	}
+
+	if err := o.Rename("/only", "/moved"); !errors.Is(err, syscall.EXDEV) {
+		t.Errorf("Rename lower dir: %v", err)
+	}
+	if err := o.Rename("/missing", "/x"); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("Rename missing: %v", err)
+	}
+	if err := o.Rename("/a.txt", "/dir"); !errors.Is(err, syscall.EISDIR) {
+		t.Errorf("Rename file over dir: %v", err)
+	}
+
+	// Upper-only directories move freely
+	if err := o.MkdirAll("/new/sub", 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := o.Rename("/new", "/newer"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(upper, "newer", "sub")); err != nil {
+		t.Errorf("renamed upper dir: %v", err)
+	}
+	if err := o.Rename("/newer", "/newer/sub/x"); !errors.Is(err, syscall.EINVAL) {
+		t.Errorf("Rename into itself: %v", err)
+	}
+}
+
+func TestNewOverlayErrors(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "file")
+	if err := os.WriteFile(file, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := NewOverlay(file); err == nil {
+		t.Error("NewOverlay on a file succeeded")
+	}
+	if _, err := NewOverlay(filepath.Join(file, "below")); err == nil {
+		t.Error("NewOverlay below a file succeeded")
+	}
+}
+
+func TestOverlayBackend(t *testing.T) {
+	o, _ := newTestOverlay(t)
+	if err := RegisterBackend("ovl", o); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { UnregisterBackend("ovl") })
+
+	if data, err := ReadFile("ovl:/a.txt"); err != nil || string(data) != "base a" {
+		t.Errorf("ReadFile = %q, %v", data, err)
+	}
+	if err := WriteFile("ovl:/a.txt", []byte("new a"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if data, err := ReadFile("ovl:/a.txt"); err != nil || string(data) != "new a" {
+		t.Errorf("ReadFile after WriteFile = %q, %v", data, err)
+	}
+	if err := Remove("ovl:/dir/g.txt"); err != nil {
+		t.Fatal(err)
+	}
+	if FileExists("ovl:/dir/g.txt") {
+		t.Error("removed lower file still exists")
+	}
+}
@@ -0,0 +1,10 @@
+//go:build !unix
+
+package GMSFS
+
+import "os"
+
+// chownLike is a no-op where files have no Unix owner
+func chownLike(name string, info os.FileInfo) (bool, error) {
+	return false, nil
+}
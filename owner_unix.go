@@ -0,0 +1,21 @@
+//go:build unix
+
+package GMSFS
+
+import (
+	"os"
+	"syscall"
+)
+
+// chownLike gives name the uid and gid of info when running as root, which is needed to hand
+// files to other users, and reports whether it changed anything
+func chownLike(name string, info os.FileInfo) (bool, error) {
+	st, ok := info.Sys().(*syscall.Stat_t)
+	if !ok || os.Geteuid() != 0 {
+		return false, nil
+	}
+	if err := os.Lchown(name, int(st.Uid), int(st.Gid)); err != nil {
+		return false, err
+	}
+	return true, nil
+}
@@ -0,0 +1,194 @@
+package GMSFS
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// ErrInvariant is wrapped by the errors of the Check functions when the package breaks one
+// of its path invariants
+var ErrInvariant = errors.New("path invariant violated")
+
+// pathSeeds are the inputs path handling usually trips over, the start of every PathCorpus
+var pathSeeds = []string{
+	"", ".", "..", "/", "//", `\`, "a", "a/b", "a/../b", "../a", "a/..", "./a", "a/./b", "a//b",
+	"a/b/", `a\b`, `..\a`, `a\..\..\b`, "/a/../../b", "....", ".../a", "a/...",
+	"C:", `C:\`, "C:a", `C:\a\..\..`, "c:/a", `\\server\share\a`, `\\?\C:\a`, `\\.\pipe\a`,
+	"a:b", "a:b:c", "tag:/a", "tag:C:\\a", "s3:bucket/key", "file:///a",
+	"con", "NUL", "aux.txt", "COM1", "a.", "a ", " a", "~", "~user/a",
+	"*", "?", "[a-", "{a,b}", "%2e%2e/a", "a\x00b",
+	"é", "e\u0301", "İ", "ß", "ǅ", "🙂", "\u202e", "\ufeff",
+	strings.Repeat("a", 255), strings.Repeat("a/", 200),
+}
+
+// pathFragments are the pieces PathCorpus joins into random paths
+var pathFragments = []string{
+	"", ".", "..", "...", "a", "A", "dir", "file.txt", ".hidden", "a:b", "C:", "con",
+	"a.", "a ", "*", "?", "[", "é", "e\u0301", "İ", "🙂", "\x00", strings.Repeat("x", 100),
+}
+
+// pathSeparators are the separators PathCorpus joins with
+var pathSeparators = []string{"/", `\`, "//", "/./", "/../"}
+
+// PathCorpus returns n paths mixing the usual troublemakers (".." elements, drive letters,
+// colons, reserved Windows names, combining characters, NUL bytes, overlong names) for
+// seeding fuzzers:
+//
+//	for _, p := range GMSFS.PathCorpus(1, 200) {
+//		f.Add(p)
+//	}
+//	f.Fuzz(func(t *testing.T, p string) {
+//		if err := GMSFS.CheckPathInvariants(dir, p); err != nil {
+//			t.Fatal(err)
+//		}
+//	})
+//
+// The same seed gives the same corpus.
+func PathCorpus(seed uint64, n int) []string {
+	r := rand.New(rand.NewPCG(seed, seed^0x9e3779b97f4a7c15))
+
+	corpus := make([]string, 0, n)
+	for _, p := range pathSeeds {
+		if len(corpus) == n {
+			return corpus
+		}
+		corpus = append(corpus, p)
+	}
+
+	for len(corpus) < n {
+		var b strings.Builder
+		if r.IntN(4) == 0 {
+			b.WriteString(pathSeparators[r.IntN(2)])
+		}
+		for i, parts := 0, 1+r.IntN(6); i < parts; i++ {
+			if i > 0 {
+				b.WriteString(pathSeparators[r.IntN(len(pathSeparators))])
+			}
+			b.WriteString(pathFragments[r.IntN(len(pathFragments))])
+		}
+		corpus = append(corpus, b.String())
+	}
+	return corpus
+}
+
+// CheckCleanIdempotent verifies that cleaning the path p the way every package function does
+// gives a path that cleans to itself
+func CheckCleanIdempotent(p string) error {
+	once := cleanPath(p)
+	if twice := cleanPath(once); twice != once {
+		return fmt.Errorf("%w: %q cleans to %q, which cleans to %q", ErrInvariant, p, once, twice)
+	}
+	return nil
+}
+
+// CheckCanonicalIdempotent verifies that canonicalizing p, as GlobOptions.Canonicalize does,
+// gives a path that canonicalizes to itself
+func CheckCanonicalIdempotent(p string) error {
+	once := realMatchPath(cleanPath(p))
+	if twice := realMatchPath(once); twice != once {
+		return fmt.Errorf("%w: %q canonicalizes to %q, which canonicalizes to %q", ErrInvariant, p, once, twice)
+	}
+	return nil
+}
+
+// CheckContainment verifies that whatever SafeJoin, SafeJoinResolved and a Root accept for
+// the user supplied userPath stays within base. Refusing a path is never a violation. base
+// has to exist for the resolving checks to run.
+func CheckContainment(base string, userPath string) error {
+	if joined, err := SafeJoin(base, userPath); err == nil {
+		if !IsWithin(base, joined) {
+			return fmt.Errorf("%w: SafeJoin(%q, %q) = %q is outside the base", ErrInvariant, base, userPath, joined)
+		}
+		if cleaned := cleanPath(joined); !IsWithin(base, cleaned) {
+			return fmt.Errorf("%w: SafeJoin(%q, %q) = %q cleans to %q outside the base", ErrInvariant, base, userPath, joined, cleaned)
+		}
+	}
+
+	root, err := NewRoot(base)
+	if err != nil {
+		return nil
+	}
+	if resolved, err := SafeJoinResolved(base, userPath); err == nil && !IsWithinResolved(root.Name(), resolved) {
+		return fmt.Errorf("%w: SafeJoinResolved(%q, %q) = %q is outside the base", ErrInvariant, base, userPath, resolved)
+	}
+	if resolved, err := root.Path(userPath); err == nil && !IsWithinResolved(root.Name(), resolved) {
+		return fmt.Errorf("%w: Root(%q).Path(%q) = %q is outside the root", ErrInvariant, base, userPath, resolved)
+	}
+	return nil
+}
+
+// CheckRenameRoundTrip creates the file name in the existing directory dir, renames it away
+// and back with Rename, and verifies each step is visible through FileExists and that the
+// content survives. Names that are not a single new element of dir, or that the filesystem
+// refuses, are skipped. The file is removed again.
+func CheckRenameRoundTrip(dir string, name string) error {
+	p, err := SafeJoin(dir, name)
+	if err != nil || filepath.Dir(p) != cleanPath(dir) || filepath.Base(p) != name {
+		return nil
+	}
+	if _, err := os.Lstat(p); err == nil || !os.IsNotExist(err) {
+		return nil
+	}
+
+	content := []byte("GMSFS rename round trip " + name)
+	f, err := os.OpenFile(p, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+	if err != nil {
+		return nil
+	}
+	_, err = f.Write(content)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	defer os.Remove(p)
+	if err != nil {
+		return err
+	}
+
+	aside := filepath.Join(dir, fmt.Sprintf(".gmsfs-rename-%x", rand.Uint64()))
+	defer os.Remove(aside)
+
+	if !FileExists(p) {
+		return fmt.Errorf("%w: %q does not exist after creating it", ErrInvariant, p)
+	}
+	if err := Rename(p, aside); err != nil {
+		return err
+	}
+	if FileExists(p) {
+		return fmt.Errorf("%w: %q still exists after renaming it to %q", ErrInvariant, p, aside)
+	}
+	if !FileExists(aside) {
+		return fmt.Errorf("%w: %q does not exist after renaming %q to it", ErrInvariant, aside, p)
+	}
+	if err := Rename(aside, p); err != nil {
+		return err
+	}
+	if FileExists(aside) || !FileExists(p) {
+		return fmt.Errorf("%w: renaming %q back to %q left the wrong name behind", ErrInvariant, aside, p)
+	}
+
+	got, err := ReadFile(p)
+	if err != nil {
+		return err
+	}
+	if !bytes.Equal(got, content) {
+		return fmt.Errorf("%w: content of %q changed across renames", ErrInvariant, p)
+	}
+	return nil
+}
+
+// CheckPathInvariants runs the checks that only read the filesystem for p, treating it both
+// as a path of its own and as a user supplied path below base
+func CheckPathInvariants(base string, p string) error {
+	if err := CheckCleanIdempotent(p); err != nil {
+		return err
+	}
+	if err := CheckCanonicalIdempotent(p); err != nil {
+		return err
+	}
+	return CheckContainment(base, p)
+}
@@ -0,0 +1,208 @@
+package GMSFS
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// Chmod changes the mode of name, following symbolic links
+func Chmod(name string, mode os.FileMode) error {
+	name = cleanPath(name)
+	b, p := backendFor(name)
+	err := b.Chmod(p, mode)
+	if err != nil {
+		errorPrinter("Chmod: "+err.Error(), name)
+		return opError("chmod", name, "", err)
+	}
+	invalidateStat(name)
+
+	return nil
+}
+
+// Chown changes the numeric uid and gid of name, following symbolic links; -1 keeps a value
+func Chown(name string, uid int, gid int) error {
+	name = cleanPath(name)
+	if err := requireLocal("chown", name); err != nil {
+		errorPrinter("Chown: "+err.Error(), name)
+		return opError("chown", name, "", err)
+	}
+
+	err := os.Chown(name, uid, gid)
+	if err != nil {
+		errorPrinter("Chown: "+err.Error(), name)
+		return opError("chown", name, "", err)
+	}
+	invalidateStat(name)
+
+	return nil
+}
+
+// Chtimes changes the access and modification times of name; a zero time is left unchanged
+func Chtimes(name string, atime time.Time, mtime time.Time) error {
+	name = cleanPath(name)
+	b, p := backendFor(name)
+	err := b.Chtimes(p, atime, mtime)
+	if err != nil {
+		errorPrinter("Chtimes: "+err.Error(), name)
+		return opError("chtimes", name, "", err)
+	}
+	invalidateStat(name)
+
+	return nil
+}
+
+// Touch creates name as an empty file if it doesn't exist, and otherwise sets its access and
+// modification times to now
+func Touch(name string) error {
+	name = cleanPath(name)
+	simulateOp()
+
+	// Checked first, as object stores can create objects but not change their times
+	b, p := backendFor(name)
+	_, err := b.Stat(p)
+	switch {
+	case errors.Is(err, os.ErrNotExist):
+		err = touchCreate(b, p, name)
+	case err == nil:
+		now := time.Now()
+		err = b.Chtimes(p, now, now)
+	}
+	if err != nil {
+		errorPrinter("Touch: "+err.Error(), name)
+		return opError("touch", name, "", err)
+	}
+	invalidateStat(name)
+
+	return nil
+}
+
+// touchCreate creates the missing file p on b, known as name, for Touch
+func touchCreate(b Backend, p string, name string) error {
+	delta, err := quotaReplace(b, p, name, 0)
+	if err != nil {
+		return err
+	}
+	f, err := b.OpenFile(p, os.O_WRONLY|os.O_CREATE, 0644)
+	if err == nil {
+		err = f.Close()
+	}
+	if err != nil {
+		quotaRelease(name, delta)
+	}
+	return err
+}
+
+// Truncate changes the size of the file name to size, dropping what is past it or extending it
+// with zeros
+func Truncate(name string, size int64) error {
+	name = cleanPath(name)
+	if err := requireLocal("truncate", name); err != nil {
+		errorPrinter("Truncate: "+err.Error(), name)
+		return opError("truncate", name, "", err)
+	}
+	if err := guardSpecialFile("truncate", name); err != nil {
+		errorPrinter("Truncate: "+err.Error(), name)
+		return opError("truncate", name, "", err)
+	}
+	simulateOp()
+
+	// Only a file that exists is truncated, so quotaReplace never counts a new one
+	delta := DirUsage{}
+	if _, err := os.Stat(name); err == nil {
+		if delta, err = quotaReplace(LocalBackend{}, name, name, size); err != nil {
+			errorPrinter("Truncate: "+err.Error(), name)
+			return opError("truncate", name, "", err)
+		}
+	}
+	err := os.Truncate(name, size)
+	invalidateStat(name)
+	if err != nil {
+		quotaRelease(name, delta)
+		errorPrinter("Truncate: "+err.Error(), name)
+		return opError("truncate", name, "", err)
+	}
+
+	return nil
+}
+
+// ChmodRecursive sets fileMode on every file and dirMode on every directory at and below path.
+// Symbolic links are neither changed nor followed. A directory whose new mode does not let its
+// owner list it is changed after its contents. It stops at the first error.
+func ChmodRecursive(path string, fileMode os.FileMode, dirMode os.FileMode) error {
+	path = cleanPath(path)
+	if err := requireLocal("chmod", path); err != nil {
+		errorPrinter("ChmodRecursive: "+err.Error(), path)
+		return opError("chmod", path, "", err)
+	}
+
+	defer invalidateStatTree(path)
+
+	err := chmodTree(path, fileMode, dirMode)
+	if err != nil {
+		errorPrinter("ChmodRecursive: "+err.Error(), path)
+	}
+	return opError("chmod", path, "", err)
+}
+
+func chmodTree(path string, fileMode os.FileMode, dirMode os.FileMode) error {
+	info, err := os.Lstat(path)
+	if err != nil {
+		return err
+	}
+
+	switch {
+	case info.Mode()&os.ModeSymlink != 0:
+		return nil
+	case !info.IsDir():
+		return os.Chmod(path, fileMode)
+	}
+
+	// Opening up a directory has to come first so its entries can be read
+	listable := dirMode&0500 == 0500
+	if listable {
+		if err := os.Chmod(path, dirMode); err != nil {
+			return err
+		}
+	}
+
+	entries, err := os.ReadDir(path)
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		if err := chmodTree(filepath.Join(path, entry.Name()), fileMode, dirMode); err != nil {
+			return err
+		}
+	}
+
+	if !listable {
+		return os.Chmod(path, dirMode)
+	}
+	return nil
+}
+
+// ChownRecursive sets the numeric uid and gid of path and everything below it; -1 keeps a
+// value. Symbolic links are changed themselves rather than their targets. It stops at the
+// first error.
+func ChownRecursive(path string, uid int, gid int) error {
+	path = cleanPath(path)
+	if err := requireLocal("chown", path); err != nil {
+		errorPrinter("ChownRecursive: "+err.Error(), path)
+		return opError("chown", path, "", err)
+	}
+
+	defer invalidateStatTree(path)
+
+	err := filepath.WalkDir(path, func(name string, d os.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		return os.Lchown(name, uid, gid)
+	})
+	if err != nil {
+		errorPrinter("ChownRecursive: "+err.Error(), path)
+	}
+	return opError("chown", path, "", err)
+}
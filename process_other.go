@@ -0,0 +1,9 @@
+//go:build !unix && !windows
+
+package GMSFS
+
+// processRunning reports whether a process with id pid exists; without a way to tell here,
+// every process counts as running, so only expiry makes lock files stale
+func processRunning(pid int) bool {
+	return true
+}
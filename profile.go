@@ -0,0 +1,224 @@
+package GMSFS
+
+import (
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+// Profile holds options that apply to every path below a prefix, see SetProfile
+type Profile struct {
+	Retries          int           // Retry reads, writes and copies failing with a transient error this many times
+	Durable          bool          // Flush written files and their directory to stable storage
+	StatCacheTTL     time.Duration // Stat cache lifetime for these paths; 0 keeps the global TTL
+	NoStatCache      bool          // Never cache Stat results for these paths
+	ReadBytesPerSec  int64         // Cap on content read below the prefix; 0 is unlimited
+	WriteBytesPerSec int64         // Cap on content written below the prefix; 0 is unlimited
+}
+
+// profileEntry is a registered profile with the bandwidth state it shares between calls
+type profileEntry struct {
+	prefix string
+	Profile
+	read  pacer
+	write pacer
+}
+
+var (
+	profilesMu sync.Mutex
+	profiles   atomic.Pointer[[]*profileEntry] // Longest prefix first
+)
+
+// SetProfile applies p to prefix and everything below it, e.g. a strict durable profile for
+// "/data" and a fast uncached one for "/tmp". When prefixes nest, the longest one wins.
+// Prefixes are compared with the cleaned path as passed to each call, so a relative
+// path doesn't match an absolute prefix.
+func SetProfile(prefix string, p Profile) {
+	prefix = cleanPath(prefix)
+
+	profilesMu.Lock()
+	defer profilesMu.Unlock()
+
+	var list []*profileEntry
+	if cur := profiles.Load(); cur != nil {
+		for _, e := range *cur {
+			if e.prefix != prefix {
+				list = append(list, e)
+			}
+		}
+	}
+	list = append(list, &profileEntry{prefix: prefix, Profile: p})
+	sort.Slice(list, func(i, j int) bool { return len(list[i].prefix) > len(list[j].prefix) })
+	profiles.Store(&list)
+
+	// Cached entries were stored under the old TTL
+	invalidateStatTree(prefix)
+}
+
+// RemoveProfile drops the profile set for prefix
+func RemoveProfile(prefix string) {
+	prefix = cleanPath(prefix)
+
+	profilesMu.Lock()
+	defer profilesMu.Unlock()
+
+	cur := profiles.Load()
+	if cur == nil {
+		return
+	}
+	var list []*profileEntry
+	for _, e := range *cur {
+		if e.prefix != prefix {
+			list = append(list, e)
+		}
+	}
+	if len(list) == 0 {
+		profiles.Store(nil)
+	} else {
+		profiles.Store(&list)
+	}
+	invalidateStatTree(prefix)
+}
+
+// ProfileFor returns the profile that applies to name, if any
+func ProfileFor(name string) (Profile, bool) {
+	if e := profileFor(name); e != nil {
+		return e.Profile, true
+	}
+	return Profile{}, false
+}
+
+// profileFor finds the entry with the longest prefix containing name
+func profileFor(name string) *profileEntry {
+	list := profiles.Load()
+	if list == nil {
+		return nil
+	}
+
+	name = filepath.Clean(name)
+	for _, e := range *list {
+		if hasPathPrefix(name, e.prefix) {
+			return e
+		}
+	}
+	return nil
+}
+
+// hasPathPrefix reports whether the cleaned path name is prefix or below it
+func hasPathPrefix(name string, prefix string) bool {
+	return name == prefix || strings.HasPrefix(name, prefix) &&
+		(strings.HasSuffix(prefix, string(os.PathSeparator)) || name[len(prefix)] == os.PathSeparator)
+}
+
+// statTTL is the Stat cache lifetime under the profile
+func (e *profileEntry) statTTL() time.Duration {
+	ttl := time.Duration(statCacheTTL.Load())
+	if e == nil {
+		return ttl
+	}
+	if e.NoStatCache {
+		return 0
+	}
+	if e.StatCacheTTL > 0 {
+		return e.StatCacheTTL
+	}
+	return ttl
+}
+
+// retry runs fn until it succeeds, fails permanently or runs out of retries
+func (e *profileEntry) retry(fn func() error) error {
+	err := fn()
+	if e == nil {
+		return err
+	}
+	for attempt := 1; err != nil && attempt <= e.Retries && congestionError(err); attempt++ {
+		time.Sleep(retryDelay(attempt))
+		err = fn()
+	}
+	return err
+}
+
+func (e *profileEntry) throttleRead(n int) {
+	if e != nil {
+		e.read.wait(int64(n), e.ReadBytesPerSec)
+	}
+}
+
+func (e *profileEntry) throttleWrite(n int) {
+	if e != nil {
+		e.write.wait(int64(n), e.WriteBytesPerSec)
+	}
+}
+
+// reader caps r at the profile's read bandwidth
+func (e *profileEntry) reader(r io.Reader) io.Reader {
+	if e == nil || e.ReadBytesPerSec <= 0 {
+		return r
+	}
+	return &profileReader{r: r, e: e}
+}
+
+// writer caps w at the profile's write bandwidth
+func (e *profileEntry) writer(w io.Writer) io.Writer {
+	if e == nil || e.WriteBytesPerSec <= 0 {
+		return w
+	}
+	return &profileWriter{w: w, e: e}
+}
+
+type profileReader struct {
+	r io.Reader
+	e *profileEntry
+}
+
+func (r *profileReader) Read(b []byte) (int, error) {
+	n, err := r.r.Read(b)
+	r.e.throttleRead(n)
+	return n, err
+}
+
+type profileWriter struct {
+	w io.Writer
+	e *profileEntry
+}
+
+func (w *profileWriter) Write(b []byte) (int, error) {
+	w.e.throttleWrite(len(b))
+	return w.w.Write(b)
+}
+
+// syncDir flushes a directory so new entries survive a crash. Not every platform can open
+// directories for syncing, so failures to do so are ignored.
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return nil
+	}
+	defer d.Close()
+
+	if err := d.Sync(); err != nil && !os.IsPermission(err) && !errors.Is(err, syscall.EINVAL) {
+		return err
+	}
+	return nil
+}
+
+// profilePrefixes lists the prefixes that have a profile
+func profilePrefixes() []string {
+	list := profiles.Load()
+	if list == nil {
+		return nil
+	}
+
+	prefixes := make([]string, 0, len(*list))
+	for _, e := range *list {
+		prefixes = append(prefixes, e.prefix)
+	}
+	return prefixes
+}
@@ -0,0 +1,127 @@
+package GMSFS
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// ProgressFunc receives the bytes copied so far, the total to copy and the file in progress
+type ProgressFunc func(copiedBytes int64, totalBytes int64, currentPath string)
+
+// Progress is reported at most this often while a file is copied, and once when it completes
+const progressInterval = 100 * time.Millisecond
+
+type copyProgress struct {
+	fn ProgressFunc
+
+	mu     sync.Mutex
+	total  int64
+	copied int64
+	last   time.Time
+}
+
+func newCopyProgress(fn ProgressFunc, total int64) *copyProgress {
+	if fn == nil {
+		return nil
+	}
+	return &copyProgress{fn: fn, total: total, last: time.Now()}
+}
+
+// reader counts what is read from r towards the progress; a nil progress returns r itself so
+// io.Copy keeps its fast paths
+func (p *copyProgress) reader(path string, r io.Reader) io.Reader {
+	if p == nil {
+		return r
+	}
+	return &progressReader{r: r, p: p, path: path}
+}
+
+func (p *copyProgress) add(path string, n int64, force bool) {
+	if p == nil {
+		return
+	}
+
+	p.mu.Lock()
+	p.copied += n
+	now := time.Now()
+	if !force && now.Sub(p.last) < progressInterval {
+		p.mu.Unlock()
+		return
+	}
+	p.last = now
+	copied, total := p.copied, p.total
+	p.mu.Unlock()
+
+	p.fn(copied, total, path)
+}
+
+// done reports a finished file; skipped files count with their full size so the copy ends at the total
+func (p *copyProgress) done(path string, skipped int64) {
+	p.add(path, skipped, true)
+}
+
+type progressReader struct {
+	r    io.Reader
+	p    *copyProgress
+	path string
+}
+
+func (r *progressReader) Read(b []byte) (int, error) {
+	n, err := r.r.Read(b)
+	if n > 0 {
+		r.p.add(r.path, int64(n), false)
+	}
+	return n, err
+}
+
+// treeSize sums the sizes of the regular files CopyDir would copy below root, through links
+// to files and directories when follow is set
+func treeSize(root string, follow bool) int64 {
+	info, err := os.Stat(root)
+	if err != nil {
+		return 0
+	}
+	return sizeBelow(root, info, follow, nil)
+}
+
+func sizeBelow(dir string, info os.FileInfo, follow bool, ancestors []os.FileInfo) int64 {
+	for _, a := range ancestors {
+		if os.SameFile(a, info) {
+			return 0
+		}
+	}
+	ancestors = append(ancestors, info)
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return 0
+	}
+
+	var total int64
+	for _, entry := range entries {
+		name := filepath.Join(dir, entry.Name())
+		var info os.FileInfo
+		if entry.Type()&os.ModeSymlink != 0 {
+			if !follow {
+				continue
+			}
+			info, err = os.Stat(name)
+		} else {
+			info, err = entry.Info()
+		}
+		if err != nil {
+			continue
+		}
+
+		switch {
+		case info.IsDir():
+			total += sizeBelow(name, info, follow, ancestors)
+		case info.Mode().IsRegular():
+			total += info.Size()
+		}
+	}
+	return total
+}
@@ -0,0 +1,562 @@
+package GMSFS
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ErrQuotaExceeded is returned when a write would take a directory past its Quota
+var ErrQuotaExceeded = errors.New("quota exceeded")
+
+// Quota caps the bytes and files stored below a directory, see SetQuota
+type Quota struct {
+	MaxBytes int64
+	MaxFiles int64 // 0 for no limit on the number of files
+
+	// OnExceeded is called when a write would take dir past MaxBytes or MaxFiles, with the bytes
+	// in use and the bytes the write adds. It may delete files to make room; dir is then counted
+	// again and the write goes ahead if it fits. Without it such writes fail with
+	// ErrQuotaExceeded.
+	OnExceeded func(dir string, used int64, need int64)
+
+	// Check, when set, is called before every write that adds bytes or files below dir, with
+	// what is in use and what the write adds, and fails the write with the error it returns.
+	// It is the place for policies the limits don't cover, e.g. per tenant plans or alerts.
+	Check func(dir string, used DirUsage, add DirUsage) error
+}
+
+// quotaEntry is a prefix whose usage is tracked, and its quota if it has one
+type quotaEntry struct {
+	dir     string
+	key     string // dir as the stat cache keys it, which lookups compare
+	quota   *Quota
+	tracked bool // Registered by TrackUsage, so it stays when the quota is removed
+
+	mu   sync.Mutex
+	used DirUsage // Bytes and Files; Dirs is not kept up to date
+}
+
+var (
+	quotasMu sync.Mutex
+	quotas   atomic.Pointer[[]*quotaEntry] // Longest directory first
+)
+
+// SetQuota limits the bytes stored below dir to q.MaxBytes and, when set, the files to
+// q.MaxFiles, e.g. for a bounded cache or upload directory. dir is counted once with DirSize;
+// from then on WriteFile, Append, Create, OpenFile, Touch, Truncate, CopyFile and tree copies
+// check the quota, and the other operations of the package keep the count up to date as
+// TrackUsage describes. Quotas may nest, and a write must fit all that contain it.
+// Directories are compared by absolute path, so a quota set on a relative dir covers the
+// absolute names below it and the other way around.
+func SetQuota(dir string, q Quota) error {
+	dir = cleanPath(dir)
+	if q.MaxBytes < 0 || q.MaxFiles < 0 {
+		return opError("quota", dir, "", errors.New("negative limit"))
+	}
+	return setQuotaEntry(dir, func(e *quotaEntry) { e.quota = &q })
+}
+
+// RemoveQuota drops the quota set for dir. Its usage is still tracked if TrackUsage asked for it.
+func RemoveQuota(dir string) {
+	updateQuotaEntry(cleanPath(dir), func(e *quotaEntry) bool {
+		e.quota = nil
+		return e.tracked
+	})
+}
+
+// TrackUsage keeps count of the bytes and files below prefix, for Usage, without limiting them,
+// so the data directories of many tenants can be accounted for without walking them. prefix is
+// counted once with DirSize. From then on the operations of the package update the count as
+// they go: writes, appends, creates, truncates, removes, renames, moves, copies and links.
+// Bytes written through handles from Create and OpenFile, and changes made by other processes,
+// are only seen by RecountQuota or the walks SetUsageReconcile schedules.
+func TrackUsage(prefix string) error {
+	return setQuotaEntry(cleanPath(prefix), func(e *quotaEntry) { e.tracked = true })
+}
+
+// UntrackUsage stops tracking prefix. Its quota, if it has one, keeps it tracked.
+func UntrackUsage(prefix string) {
+	updateQuotaEntry(cleanPath(prefix), func(e *quotaEntry) bool {
+		e.tracked = false
+		return e.quota != nil
+	})
+}
+
+// Usage returns the bytes and files counted below prefix, which must be tracked by TrackUsage
+// or have a quota
+func Usage(prefix string) (DirUsage, bool) {
+	e := quotaEntryFor(cleanPath(prefix))
+	if e == nil {
+		return DirUsage{}, false
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.used, true
+}
+
+// QuotaUsage returns the bytes tracked below dir and its quota, if it has one
+func QuotaUsage(dir string) (used int64, q Quota, ok bool) {
+	e := quotaEntryFor(cleanPath(dir))
+	if e == nil || e.quota == nil {
+		return 0, Quota{}, false
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.used.Bytes, *e.quota, true
+}
+
+// RecountQuota counts dir, which has a quota or is tracked, again, after changes made outside
+// the package
+func RecountQuota(dir string) error {
+	e := quotaEntryFor(cleanPath(dir))
+	if e == nil {
+		return opError("quota", cleanPath(dir), "", errors.New("no quota set"))
+	}
+	return e.recount()
+}
+
+// setQuotaEntry counts dir and registers it, with set applied to it and to an entry it
+// replaces
+func setQuotaEntry(dir string, set func(e *quotaEntry)) error {
+	used, err := quotaCount(dir)
+	if err != nil {
+		errorPrinter("SetQuota: "+err.Error(), dir)
+		return err
+	}
+
+	quotasMu.Lock()
+	defer quotasMu.Unlock()
+
+	e := &quotaEntry{dir: dir, key: statCacheKey(dir), used: used}
+	var list []*quotaEntry
+	if cur := quotas.Load(); cur != nil {
+		for _, old := range *cur {
+			if old.key == e.key {
+				e.quota, e.tracked = old.quota, old.tracked
+				continue
+			}
+			list = append(list, old)
+		}
+	}
+	set(e)
+	list = append(list, e)
+	sort.Slice(list, func(i, j int) bool { return len(list[i].key) > len(list[j].key) })
+	quotas.Store(&list)
+	return nil
+}
+
+// updateQuotaEntry applies update to a copy of the entry for dir, dropping the entry when
+// update returns false
+func updateQuotaEntry(dir string, update func(e *quotaEntry) bool) {
+	quotasMu.Lock()
+	defer quotasMu.Unlock()
+
+	cur := quotas.Load()
+	if cur == nil {
+		return
+	}
+	key := statCacheKey(dir)
+	var list []*quotaEntry
+	for _, old := range *cur {
+		if old.key != key {
+			list = append(list, old)
+			continue
+		}
+		old.mu.Lock()
+		e := &quotaEntry{dir: old.dir, key: key, quota: old.quota, tracked: old.tracked, used: old.used}
+		old.mu.Unlock()
+		if update(e) {
+			list = append(list, e)
+		}
+	}
+	if len(list) == 0 {
+		quotas.Store(nil)
+	} else {
+		quotas.Store(&list)
+	}
+}
+
+func quotaEntryFor(dir string) *quotaEntry {
+	if list := quotas.Load(); list != nil {
+		key := statCacheKey(dir)
+		for _, e := range *list {
+			if e.key == key {
+				return e
+			}
+		}
+	}
+	return nil
+}
+
+// quotasFor returns the quotas containing name
+func quotasFor(name string) []*quotaEntry {
+	list := quotas.Load()
+	if list == nil {
+		return nil
+	}
+
+	name = statCacheKey(name)
+	var found []*quotaEntry
+	for _, e := range *list {
+		if hasPathPrefix(name, e.key) {
+			found = append(found, e)
+		}
+	}
+	return found
+}
+
+// quotaCount returns the bytes and files below dir; a directory that doesn't exist yet holds
+// none. Files soft deleted next to where they were are left out, as Delete already took them
+// off, and so are tag sidecars, as writing them is not counted either.
+func quotaCount(dir string) (DirUsage, error) {
+	usage, err := DirSizeWithOptions(dir, DirSizeOptions{Exclude: []string{softDeleteDir, tagSidecar}})
+	if errors.Is(err, os.ErrNotExist) {
+		return DirUsage{}, nil
+	}
+	usage.Dirs = 0
+	return usage, err
+}
+
+func (e *quotaEntry) recount() error {
+	used, err := quotaCount(e.dir)
+	if err != nil {
+		return err
+	}
+	e.mu.Lock()
+	e.used = used
+	e.mu.Unlock()
+	return nil
+}
+
+// quotaReserve adds delta for a write to name to every quota containing it, failing with
+// ErrQuotaExceeded, or the error of a Check, when one of them has no room even after its
+// OnExceeded
+func quotaReserve(name string, delta DirUsage) error {
+	entries := quotasFor(name)
+	for i, e := range entries {
+		if err := e.reserve(delta); err != nil {
+			for _, done := range entries[:i] {
+				done.add(negUsage(delta))
+			}
+			return err
+		}
+	}
+	return nil
+}
+
+// quotaReplace reserves room for replacing the file p on b, known as name, with size bytes,
+// returning the growth to release if the write fails
+func quotaReplace(b Backend, p string, name string, size int64) (DirUsage, error) {
+	if quotasFor(name) == nil {
+		return DirUsage{}, nil
+	}
+	delta := DirUsage{Bytes: size, Files: 1}
+	if info, err := b.Stat(p); err == nil {
+		delta.Files = 0
+		if info.Mode().IsRegular() {
+			delta.Bytes -= info.Size()
+		}
+	}
+	return delta, quotaReserve(name, delta)
+}
+
+// quotaCopying reserves room for copying src over dst, returning the growth to release if the
+// copy fails
+func quotaCopying(src string, dst string) (DirUsage, error) {
+	if quotasFor(dst) == nil {
+		return DirUsage{}, nil
+	}
+	var size int64
+	sb, sp := backendFor(src)
+	if info, err := sb.Stat(sp); err == nil {
+		size = info.Size()
+	}
+	db, dp := backendFor(dst)
+	return quotaReplace(db, dp, dst, size)
+}
+
+// quotaAppending reserves room for appending n bytes to name, and a file if it is new
+func quotaAppending(name string, n int64) (DirUsage, error) {
+	if quotasFor(name) == nil {
+		return DirUsage{}, nil
+	}
+	delta := DirUsage{Bytes: n}
+	b, p := backendFor(name)
+	if _, err := b.Stat(p); errors.Is(err, fs.ErrNotExist) {
+		delta.Files = 1
+	}
+	return delta, quotaReserve(name, delta)
+}
+
+// quotaOpening reserves the file OpenFile with flag may create at name, and takes off what
+// O_TRUNC drops, returning the change to release if the open fails
+func quotaOpening(name string, flag int) (DirUsage, error) {
+	if quotasFor(name) == nil || flag&(os.O_CREATE|os.O_TRUNC) == 0 {
+		return DirUsage{}, nil
+	}
+	info, err := os.Stat(cleanPath(name))
+	switch {
+	case errors.Is(err, fs.ErrNotExist) && flag&os.O_CREATE != 0:
+		delta := DirUsage{Files: 1}
+		return delta, quotaReserve(name, delta)
+	case err == nil && info.Mode().IsRegular() && flag&os.O_TRUNC != 0 && flag&(os.O_WRONLY|os.O_RDWR) != 0:
+		delta := DirUsage{Bytes: -info.Size()}
+		quotaAdd(name, delta)
+		return delta, nil
+	}
+	return DirUsage{}, nil
+}
+
+// quotaLinking reserves room for newName as a hard link to oldName, counted like a copy as
+// DirSize counts it
+func quotaLinking(oldName string, newName string) (DirUsage, error) {
+	if quotasFor(newName) == nil {
+		return DirUsage{}, nil
+	}
+	delta, _ := quotaStat(LocalBackend{}, oldName)
+	return delta, quotaReserve(newName, delta)
+}
+
+// quotaPlacing reserves room for a new entry at name counting usage, net of the entry it
+// replaces, returning the change to release if it isn't made. Once the old entry is removed,
+// only usage is left to release.
+func quotaPlacing(name string, usage DirUsage) (DirUsage, error) {
+	if quotasFor(name) == nil {
+		return DirUsage{}, nil
+	}
+	old, _ := quotaStat(LocalBackend{}, name)
+	delta := DirUsage{Bytes: usage.Bytes - old.Bytes, Files: usage.Files - old.Files}
+	return delta, quotaReserve(name, delta)
+}
+
+// quotaRemoving returns what the entry p on b, known as name, counts for, for quotaRelease once
+// it is removed
+func quotaRemoving(b Backend, p string, name string) DirUsage {
+	if quotasFor(name) == nil {
+		return DirUsage{}
+	}
+	usage, _ := quotaStat(b, p)
+	return usage
+}
+
+// quotaStat returns what the entry p on b counts for, and whether it is a directory, whose
+// contents only a walk can count
+func quotaStat(b Backend, p string) (DirUsage, bool) {
+	info, err := b.Lstat(p)
+	switch {
+	case err != nil:
+		return DirUsage{}, false
+	case info.IsDir():
+		return DirUsage{}, true
+	case info.Mode().IsRegular():
+		return DirUsage{Bytes: info.Size(), Files: 1}, false
+	}
+	return DirUsage{Files: 1}, false
+}
+
+// quotaRelease takes back what quotaReserve added, for a write that failed or a file removed
+func quotaRelease(name string, delta DirUsage) {
+	quotaAdd(name, negUsage(delta))
+}
+
+// quotaAdd changes the usage of every quota containing name by delta, without checking limits
+func quotaAdd(name string, delta DirUsage) {
+	if delta == (DirUsage{}) {
+		return
+	}
+	for _, e := range quotasFor(name) {
+		e.mu.Lock()
+		e.used.Bytes = max(e.used.Bytes+delta.Bytes, 0)
+		e.used.Files = max(e.used.Files+delta.Files, 0)
+		e.mu.Unlock()
+	}
+}
+
+// quotaMove is what renaming or moving an entry carries from the quotas of the old name to
+// those of the new one
+type quotaMove struct {
+	oldName  string
+	newName  string
+	moved    DirUsage
+	replaced DirUsage
+	dir      bool
+
+	uncounted bool // The old name is a temporary file the quotas never counted
+}
+
+// quotaMoving looks at oldName and newName before they are renamed, returning nil when
+// neither is tracked
+func quotaMoving(oldName string, newName string) *quotaMove {
+	if quotasFor(oldName) == nil && quotasFor(newName) == nil {
+		return nil
+	}
+	m := &quotaMove{oldName: oldName, newName: newName}
+	ob, op := backendFor(oldName)
+	m.moved, m.dir = quotaStat(ob, op)
+	nb, np := backendFor(newName)
+	m.replaced, _ = quotaStat(nb, np)
+	return m
+}
+
+// quotaReplacing is quotaMoving for renaming the temporary file tmp over name
+func quotaReplacing(tmp string, name string) *quotaMove {
+	if quotasFor(name) == nil {
+		return nil
+	}
+	m := quotaMoving(tmp, name)
+	m.uncounted = true
+	return m
+}
+
+// done moves the usage once the rename succeeded; a directory is counted again on both sides
+func (m *quotaMove) done() {
+	if m == nil {
+		return
+	}
+	if m.dir {
+		quotaRecount(m.oldName)
+		quotaRecount(m.newName)
+		return
+	}
+	if !m.uncounted {
+		quotaAdd(m.oldName, negUsage(m.moved))
+	}
+	quotaAdd(m.newName, DirUsage{Bytes: m.moved.Bytes - m.replaced.Bytes, Files: m.moved.Files - m.replaced.Files})
+}
+
+// quotaRecount counts every quota containing name or below it again, after removing a tree
+func quotaRecount(name string) {
+	list := quotas.Load()
+	if list == nil {
+		return
+	}
+
+	name = statCacheKey(name)
+	for _, e := range *list {
+		if !hasPathPrefix(name, e.key) && !hasPathPrefix(e.key, name) {
+			continue
+		}
+		if err := e.recount(); err != nil {
+			warnPrinter("Quota: "+err.Error(), e.dir)
+		}
+	}
+}
+
+func negUsage(u DirUsage) DirUsage {
+	return DirUsage{Bytes: -u.Bytes, Files: -u.Files, Dirs: -u.Dirs}
+}
+
+func (e *quotaEntry) reserve(delta DirUsage) error {
+	if e.quota == nil {
+		e.add(delta)
+		return nil
+	}
+	if check := e.quota.Check; check != nil && (delta.Bytes > 0 || delta.Files > 0) {
+		e.mu.Lock()
+		used := e.used
+		e.mu.Unlock()
+		if err := check(e.dir, used, delta); err != nil {
+			return err
+		}
+	}
+
+	if e.add(delta) {
+		return nil
+	}
+	if e.quota.OnExceeded == nil {
+		return ErrQuotaExceeded
+	}
+
+	// Called without the lock, as cleaning up goes through Remove
+	e.mu.Lock()
+	used := e.used.Bytes
+	e.mu.Unlock()
+	e.quota.OnExceeded(e.dir, used, delta.Bytes)
+	if err := e.recount(); err != nil {
+		warnPrinter("Quota: "+err.Error(), e.dir)
+	}
+	if !e.add(delta) {
+		return ErrQuotaExceeded
+	}
+	return nil
+}
+
+// add changes the usage by delta, which may be negative, refusing increases that don't fit
+func (e *quotaEntry) add(delta DirUsage) bool {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if q := e.quota; q != nil {
+		if delta.Bytes > 0 && e.used.Bytes+delta.Bytes > q.MaxBytes {
+			return false
+		}
+		if delta.Files > 0 && q.MaxFiles > 0 && e.used.Files+delta.Files > q.MaxFiles {
+			return false
+		}
+	}
+	e.used.Bytes = max(e.used.Bytes+delta.Bytes, 0)
+	e.used.Files = max(e.used.Files+delta.Files, 0)
+	return true
+}
+
+// usageReconciler counts every tracked prefix again at an interval, see SetUsageReconcile
+type usageReconciler struct {
+	stop chan struct{}
+	done chan struct{}
+	once sync.Once
+}
+
+var reconciler atomic.Pointer[usageReconciler]
+
+// SetUsageReconcile walks every tracked prefix and quota directory each interval, correcting
+// the counts for bytes written through handles and changes by other processes. 0 stops it.
+func SetUsageReconcile(interval time.Duration) {
+	var r *usageReconciler
+	if interval > 0 {
+		r = &usageReconciler{stop: make(chan struct{}), done: make(chan struct{})}
+	}
+	if old := reconciler.Swap(r); old != nil {
+		old.Close()
+	}
+	if r == nil {
+		return
+	}
+	registerCloser(r)
+	go r.run(interval)
+}
+
+func (r *usageReconciler) run(interval time.Duration) {
+	defer close(r.done)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-r.stop:
+			return
+		case <-ticker.C:
+			if list := quotas.Load(); list != nil {
+				for _, e := range *list {
+					if err := e.recount(); err != nil {
+						warnPrinter("Usage reconcile: "+err.Error(), e.dir)
+					}
+				}
+			}
+		}
+	}
+}
+
+func (r *usageReconciler) Close() error {
+	r.once.Do(func() {
+		reconciler.CompareAndSwap(r, nil)
+		unregisterCloser(r)
+		close(r.stop)
+		<-r.done
+	})
+	return nil
+}
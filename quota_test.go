@@ -0,0 +1,165 @@
+package GMSFS
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// setTestQuota sets q on dir for the rest of the test
+func setTestQuota(t *testing.T, dir string, q Quota) {
+	t.Helper()
+	if err := SetQuota(dir, q); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { RemoveQuota(dir) })
+}
+
+// chdir changes the working directory to dir for the rest of the test
+func chdir(t *testing.T, dir string) {
+	t.Helper()
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.Chdir(wd) })
+}
+
+func checkUsage(t *testing.T, dir string, want DirUsage) {
+	t.Helper()
+	if got, ok := Usage(dir); !ok || got != want {
+		t.Errorf("usage of %s = %+v, %v, want %+v", dir, got, ok, want)
+	}
+}
+
+func TestQuotaLimits(t *testing.T) {
+	dir := t.TempDir()
+	setTestQuota(t, dir, Quota{MaxBytes: 10, MaxFiles: 2})
+	a, b, c := filepath.Join(dir, "a"), filepath.Join(dir, "b"), filepath.Join(dir, "c")
+
+	if err := WriteFile(a, make([]byte, 6), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := WriteFile(b, make([]byte, 6), 0644); !errors.Is(err, ErrQuotaExceeded) {
+		t.Errorf("WriteFile over MaxBytes = %v", err)
+	}
+	if err := Append(a, make([]byte, 4)); err != nil {
+		t.Errorf("Append up to MaxBytes = %v", err)
+	}
+	checkUsage(t, dir, DirUsage{Bytes: 10, Files: 1})
+
+	if err := WriteFile(a, make([]byte, 2), 0644); err != nil {
+		t.Errorf("WriteFile shrinking a file = %v", err)
+	}
+	if err := Touch(b); err != nil {
+		t.Fatal(err)
+	}
+	if err := Touch(c); !errors.Is(err, ErrQuotaExceeded) {
+		t.Errorf("Touch over MaxFiles = %v", err)
+	}
+	checkUsage(t, dir, DirUsage{Bytes: 2, Files: 2})
+
+	if err := Remove(a); err != nil {
+		t.Fatal(err)
+	}
+	checkUsage(t, dir, DirUsage{Bytes: 0, Files: 1})
+	if _, err := os.Stat(c); !os.IsNotExist(err) {
+		t.Errorf("refused file created: %v", err)
+	}
+
+	if err := SetQuota(dir, Quota{MaxBytes: -1}); err == nil {
+		t.Error("SetQuota with a negative limit succeeded")
+	}
+	if err := RecountQuota(t.TempDir()); err == nil {
+		t.Error("RecountQuota without a quota succeeded")
+	}
+}
+
+func TestQuotaNested(t *testing.T) {
+	dir := t.TempDir()
+	sub := filepath.Join(dir, "sub")
+	setTestQuota(t, dir, Quota{MaxBytes: 10})
+	setTestQuota(t, sub, Quota{MaxBytes: 100})
+
+	if err := WriteFile(filepath.Join(sub, "a"), make([]byte, 20), 0644); !errors.Is(err, ErrQuotaExceeded) {
+		t.Errorf("WriteFile over the outer quota = %v", err)
+	}
+	if err := MkdirAll(sub, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := WriteFile(filepath.Join(sub, "a"), make([]byte, 5), 0644); err != nil {
+		t.Fatal(err)
+	}
+	checkUsage(t, dir, DirUsage{Bytes: 5, Files: 1})
+	checkUsage(t, sub, DirUsage{Bytes: 5, Files: 1})
+}
+
+func TestQuotaHooks(t *testing.T) {
+	dir := t.TempDir()
+	old := filepath.Join(dir, "old")
+	if err := os.WriteFile(old, make([]byte, 8), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	var exceeded []int64
+	setTestQuota(t, dir, Quota{MaxBytes: 10, OnExceeded: func(d string, used int64, need int64) {
+		exceeded = append(exceeded, used, need)
+		Remove(old)
+	}})
+	if err := WriteFile(filepath.Join(dir, "new"), make([]byte, 5), 0644); err != nil {
+		t.Fatalf("WriteFile after OnExceeded made room = %v", err)
+	}
+	if len(exceeded) != 2 || exceeded[0] != 8 || exceeded[1] != 5 {
+		t.Errorf("OnExceeded called with %v", exceeded)
+	}
+
+	refuse := errors.New("plan limit")
+	setTestQuota(t, dir, Quota{MaxBytes: 1000, Check: func(d string, used DirUsage, add DirUsage) error {
+		if add.Files > 0 {
+			return refuse
+		}
+		return nil
+	}})
+	if err := WriteFile(filepath.Join(dir, "another"), nil, 0644); !errors.Is(err, refuse) {
+		t.Errorf("WriteFile refused by Check = %v", err)
+	}
+	if err := WriteFile(filepath.Join(dir, "new"), make([]byte, 50), 0644); err != nil {
+		t.Errorf("WriteFile allowed by Check = %v", err)
+	}
+}
+
+func TestQuotaRelativePaths(t *testing.T) {
+	base := t.TempDir()
+	chdir(t, base)
+	if err := os.Mkdir("q", 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join("q", "big"), make([]byte, 8), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	// Set relative, looked up absolute, and the other way around
+	setTestQuota(t, "q", Quota{MaxBytes: 10})
+	abs := filepath.Join(base, "q")
+	if err := WriteFile(filepath.Join(abs, "x"), make([]byte, 5), 0644); !errors.Is(err, ErrQuotaExceeded) {
+		t.Errorf("WriteFile by absolute name = %v", err)
+	}
+	checkUsage(t, abs, DirUsage{Bytes: 8, Files: 1})
+
+	// An uncleaned name still finds the file O_TRUNC empties
+	f, err := OpenFile("q/.//big", os.O_WRONLY|os.O_TRUNC, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+	checkUsage(t, "./q/", DirUsage{Bytes: 0, Files: 1})
+
+	RemoveQuota(abs)
+	if _, ok := Usage("q"); ok {
+		t.Error("quota set relative not removed by its absolute name")
+	}
+}
@@ -0,0 +1,235 @@
+package GMSFS
+
+import (
+	"context"
+	"errors"
+	"io"
+	"io/fs"
+	"iter"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+// dirBatch is the number of entries ReadDirIter reads from the directory at a time
+const dirBatch = 1024
+
+// ReadDirIter returns an iterator over the entries of dir, for range loops over directories
+// too large for ReadDir, which reads and sorts all of them first. Entries are read a batch at
+// a time as the loop goes, in the order the filesystem returns them, and the directory is
+// closed when the loop ends. Entries removed while the loop runs are skipped. An error is
+// yielded once, with an empty FileInfo, as the last element.
+//
+//	for info, err := range GMSFS.ReadDirIter("/var/spool/queue") {
+//		if err != nil {
+//			return err
+//		}
+//		...
+//	}
+func ReadDirIter(dir string) iter.Seq2[FileInfo, error] {
+	return ReadDirIterContext(context.Background(), dir)
+}
+
+// ReadDirIterContext is ReadDirIter ending with ctx.Err() when ctx ends
+func ReadDirIterContext(ctx context.Context, dir string) iter.Seq2[FileInfo, error] {
+	return func(yield func(FileInfo, error) bool) {
+		err := readDirBatches(ctx, dir, func(entries []fs.DirEntry) bool {
+			for _, entry := range entries {
+				info, err := dirEntryInfo(entry)
+				if errors.Is(err, fs.ErrNotExist) {
+					continue
+				}
+				if err != nil {
+					yield(FileInfo{}, opError("readdir", filepath.Join(dir, entry.Name()), "", err))
+					return false
+				}
+				if !yield(info, nil) {
+					return false
+				}
+			}
+			return true
+		})
+		if err != nil {
+			errorPrinterCtx(ctx, "ReadDirIter: "+err.Error(), dir)
+			yield(FileInfo{}, opError("readdir", dir, "", err))
+		}
+	}
+}
+
+// DirSort is the order of the entries ReadDirFiltered returns
+type DirSort int
+
+const (
+	SortByName    DirSort = iota // Byte order of the names, as ReadDir
+	SortBySize                   // Smallest first, by name within a size
+	SortByModTime                // Oldest first, by name within a time
+	SortNone                     // The order the filesystem returns them in
+)
+
+// ReadDirOptions selects and orders the entries of ReadDirFiltered
+type ReadDirOptions struct {
+	Pattern string              // Only names matching this glob, e.g. "*.{log,txt}"
+	Exclude []string            // Skip names matching any of these globs
+	Filter  func(FileInfo) bool // Only entries it returns true for, asked after the globs
+
+	Sort       DirSort
+	Descending bool // Reverse the order of Sort, except SortNone
+
+	// NamesOnly skips the stat of every entry, for callers that only need names: the FileInfo
+	// holds Name, IsDir, IsSymlink and the type bits of Mode, also when given to Filter. It
+	// cannot be combined with SortBySize or SortByModTime.
+	NamesOnly bool
+}
+
+// ReadDirFiltered is ReadDir returning only the entries opts selects, in the order it asks for.
+// Names are matched before entries are stat'ed, so globs also save the stat of what they skip.
+func ReadDirFiltered(dir string, opts ReadDirOptions) ([]FileInfo, error) {
+	var patterns []string
+	if opts.Pattern != "" {
+		var err error
+		if patterns, err = expandBraces(opts.Pattern); err != nil {
+			return nil, opError("readdir", dir, "", err)
+		}
+	}
+	var exclude []string
+	for _, pattern := range opts.Exclude {
+		expanded, err := expandBraces(pattern)
+		if err != nil {
+			return nil, opError("readdir", dir, "", err)
+		}
+		exclude = append(exclude, expanded...)
+	}
+	for _, p := range append(patterns, exclude...) {
+		if _, err := matchName(p, "", false); err != nil {
+			return nil, opError("readdir", dir, "", err)
+		}
+	}
+	if opts.NamesOnly && (opts.Sort == SortBySize || opts.Sort == SortByModTime) {
+		return nil, opError("readdir", dir, "", errors.New("sorting by size or time needs the stat NamesOnly skips"))
+	}
+
+	var infos []FileInfo
+	var entryErr error
+	err := readDirBatches(context.Background(), dir, func(entries []fs.DirEntry) bool {
+		for _, entry := range entries {
+			name := entry.Name()
+			if patterns != nil && !matchAny(patterns, name) || matchAny(exclude, name) {
+				continue
+			}
+
+			info := FileInfo{
+				Exists:    true,
+				Mode:      entry.Type(),
+				IsDir:     entry.IsDir(),
+				Name:      name,
+				IsSymlink: entry.Type()&os.ModeSymlink != 0,
+			}
+			if !opts.NamesOnly {
+				var err error
+				info, err = dirEntryInfo(entry)
+				if errors.Is(err, fs.ErrNotExist) {
+					continue
+				}
+				if err != nil {
+					entryErr = opError("readdir", filepath.Join(dir, name), "", err)
+					return false
+				}
+			}
+
+			if opts.Filter == nil || opts.Filter(info) {
+				infos = append(infos, info)
+			}
+		}
+		return true
+	})
+	if err != nil {
+		errorPrinter("ReadDirFiltered: "+err.Error(), dir)
+		return nil, opError("readdir", dir, "", err)
+	}
+	if entryErr != nil {
+		return nil, entryErr
+	}
+
+	if opts.Sort != SortNone {
+		sort.Slice(infos, func(i, j int) bool {
+			if opts.Descending {
+				i, j = j, i
+			}
+			a, b := infos[i], infos[j]
+			switch {
+			case opts.Sort == SortBySize && a.Size != b.Size:
+				return a.Size < b.Size
+			case opts.Sort == SortByModTime && !a.LastModified.Equal(b.LastModified):
+				return a.LastModified.Before(b.LastModified)
+			}
+			return a.Name < b.Name
+		})
+	}
+
+	return infos, nil
+}
+
+// readDirBatches hands the entries of dir to fn a batch at a time until fn returns false.
+// Backends whose files cannot list a directory in parts give all entries in one batch.
+func readDirBatches(ctx context.Context, dir string, fn func(entries []fs.DirEntry) bool) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	simulateOp()
+	b, p := backendFor(dir)
+
+	acquireFDs(1)
+	f, err := b.Open(p)
+	if err != nil {
+		releaseFDs(1)
+		return err
+	}
+	rd, ok := f.(interface {
+		ReadDir(n int) ([]fs.DirEntry, error)
+	})
+	if !ok {
+		f.Close()
+		releaseFDs(1)
+		entries, err := b.ReadDir(p)
+		if err != nil {
+			return err
+		}
+		fn(entries)
+		return nil
+	}
+	defer releaseFDs(1)
+	defer f.Close()
+
+	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		entries, err := rd.ReadDir(dirBatch)
+		if len(entries) > 0 && !fn(entries) {
+			return nil
+		}
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// dirEntryInfo is the FileInfo of a directory entry, without following symlinks
+func dirEntryInfo(entry fs.DirEntry) (FileInfo, error) {
+	info, err := entry.Info()
+	if err != nil {
+		return FileInfo{}, err
+	}
+	return FileInfo{
+		Exists:       true,
+		Size:         info.Size(),
+		Mode:         info.Mode(),
+		LastModified: info.ModTime(),
+		IsDir:        info.IsDir(),
+		Name:         info.Name(),
+		IsSymlink:    info.Mode()&os.ModeSymlink != 0,
+	}, nil
+}
@@ -0,0 +1,165 @@
+package GMSFS
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Reconfigure switches the running package to c, e.g. after an operator edited the config
+// file. It compares c with CurrentConfig, applies it, and logs every changed setting at info
+// level through the (possibly new) logger. Operations in flight finish under the settings
+// they started with. An invalid c changes nothing.
+func Reconfigure(c Config) error {
+	configMu.Lock()
+	defer configMu.Unlock()
+
+	if err := c.Validate(); err != nil {
+		errorPrinter("Reconfigure: "+err.Error(), "")
+		return err
+	}
+
+	changes := configChanges(CurrentConfig(), c)
+	if err := applyConfig(c); err != nil {
+		errorPrinter("Reconfigure: "+err.Error(), "")
+		return err
+	}
+
+	for _, change := range changes {
+		infoPrinter("Reconfigure: "+change, "")
+	}
+	return nil
+}
+
+// CurrentConfig returns the settings in effect, including changes made with the Set
+// functions since the last ApplyConfig. Log is as last applied from a Config; SetLogger
+// doesn't show up in it.
+func CurrentConfig() Config {
+	cfg := Config{
+		StatCacheTTL:         Duration(StatCacheTTL()),
+		AppendIdleTimeout:    Duration(AppendIdleTimeout()),
+		SpecialFileGuard:     SpecialFileGuard(),
+		StripColonPrefix:     StripColonPrefix(),
+		CaseInsensitive:      CaseInsensitive(),
+		UnicodeNormalization: UnicodeNormalization(),
+		RecentErrors:         RecentErrorsSize(),
+		ReportLegacyCalls:    ReportLegacyCalls(),
+	}
+	if budget := FDBudget(); budget != defaultFDBudget() {
+		cfg.FDBudget = budget
+	}
+
+	configLog.mu.Lock()
+	cfg.Log = configLog.cfg
+	configLog.mu.Unlock()
+
+	slow := SlowDisk()
+	cfg.SlowDisk = SlowDiskConfig{
+		Latency:          Duration(slow.Latency),
+		Jitter:           Duration(slow.Jitter),
+		ReadBytesPerSec:  slow.ReadBytesPerSec,
+		WriteBytesPerSec: slow.WriteBytesPerSec,
+	}
+
+	cfg.Backends = appliedBackends()
+
+	for _, prefix := range profilePrefixes() {
+		p, ok := ProfileFor(prefix)
+		if !ok {
+			continue
+		}
+		if cfg.Profiles == nil {
+			cfg.Profiles = map[string]ProfileConfig{}
+		}
+		cfg.Profiles[prefix] = ProfileConfig{
+			Retries:          p.Retries,
+			Durable:          p.Durable,
+			StatCacheTTL:     Duration(p.StatCacheTTL),
+			NoStatCache:      p.NoStatCache,
+			ReadBytesPerSec:  p.ReadBytesPerSec,
+			WriteBytesPerSec: p.WriteBytesPerSec,
+		}
+	}
+
+	return cfg
+}
+
+// configChanges describes every setting that differs between old and new, named by its
+// config file key, e.g. "stat_cache_ttl: 2s -> 10s"
+func configChanges(old Config, new Config) []string {
+	old.Log = old.Log.normalized()
+	new.Log = new.Log.normalized()
+	old.Profiles = cleanProfileKeys(old.Profiles)
+	new.Profiles = cleanProfileKeys(new.Profiles)
+
+	var changes []string
+	diffValues("", reflect.ValueOf(old), reflect.ValueOf(new), &changes)
+	return changes
+}
+
+func diffValues(name string, old reflect.Value, new reflect.Value, changes *[]string) {
+	switch old.Kind() {
+	case reflect.Struct:
+		if _, ok := old.Interface().(time.Time); ok {
+			break
+		}
+		for i := 0; i < old.NumField(); i++ {
+			field := old.Type().Field(i)
+			key, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+			if key == "" || key == "-" {
+				key = field.Name
+			}
+			if name != "" {
+				key = name + "." + key
+			}
+			diffValues(key, old.Field(i), new.Field(i), changes)
+		}
+		return
+
+	case reflect.Map:
+		keys := map[string]reflect.Value{}
+		for _, k := range old.MapKeys() {
+			keys[fmt.Sprint(k.Interface())] = k
+		}
+		for _, k := range new.MapKeys() {
+			keys[fmt.Sprint(k.Interface())] = k
+		}
+		sorted := make([]string, 0, len(keys))
+		for k := range keys {
+			sorted = append(sorted, k)
+		}
+		sort.Strings(sorted)
+
+		for _, k := range sorted {
+			key := name + "[" + k + "]"
+			ov, nv := old.MapIndex(keys[k]), new.MapIndex(keys[k])
+			switch {
+			case !ov.IsValid():
+				*changes = append(*changes, key+": added")
+			case !nv.IsValid():
+				*changes = append(*changes, key+": removed")
+			default:
+				diffValues(key, ov, nv, changes)
+			}
+		}
+		return
+	}
+
+	if !reflect.DeepEqual(old.Interface(), new.Interface()) {
+		*changes = append(*changes, fmt.Sprintf("%s: %v -> %v", name, old.Interface(), new.Interface()))
+	}
+}
+
+// cleanProfileKeys cleans prefixes the way SetProfile does, so "/data/" and "/data" compare equal
+func cleanProfileKeys(profiles map[string]ProfileConfig) map[string]ProfileConfig {
+	if len(profiles) == 0 {
+		return nil
+	}
+	cleaned := make(map[string]ProfileConfig, len(profiles))
+	for prefix, p := range profiles {
+		cleaned[cleanPath(prefix)] = p
+	}
+	return cleaned
+}
@@ -0,0 +1,473 @@
+package GMSFS
+
+import (
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultRemoteCacheTTL is how long a CachingBackend serves a cached file before checking the
+// remote copy again
+const DefaultRemoteCacheTTL = time.Minute
+
+// CacheOptions configures NewCachingBackend
+type CacheOptions struct {
+	Dir     string        // Local directory holding the cached files, created when missing
+	TTL     time.Duration // Serve cached files this long without asking the remote; defaults to DefaultRemoteCacheTTL
+	MaxSize int64         // Bytes kept in Dir, evicting the least recently used files beyond; 0 is unlimited
+
+	// WriteBack makes writes land in Dir and reach the remote in the background, see Flush
+	WriteBack bool
+}
+
+// CachingBackend is a Backend fronting a remote one, such as an S3Backend, with a local
+// directory: files that are read are pulled into the directory and served from there until
+// they expire, when they are checked against the remote and pulled again only if they
+// changed. Directory listings and Stat go to the remote; writes, renames and removals go to
+// the remote and drop what they touch from the cache.
+//
+//	s3, _ := GMSFS.NewS3Backend(opts)
+//	cached, _ := GMSFS.NewCachingBackend(s3, GMSFS.CacheOptions{Dir: "/var/cache/app", MaxSize: 10 << 30})
+//	GMSFS.RegisterBackend("s3", cached)
+//	local, err := GMSFS.LocalPath("s3:/bucket/models/big.bin") // For code that needs a real file
+//
+// The cache mirrors the remote tree below Dir, so a restart reuses what was pulled before
+// after checking it once.
+//
+// With CacheOptions.WriteBack, files written through the backend are written in Dir and
+// uploaded by a background goroutine, which retries failed uploads until they succeed. The
+// files waiting for upload are recorded in a journal in Dir, so a restart picks them up again;
+// until they are uploaded they are never evicted and Stat and ReadDir show the local version.
+// Close stops the uploads.
+type CachingBackend struct {
+	remote    Backend
+	dir       string
+	ttl       time.Duration
+	maxSize   int64
+	writeBack bool
+
+	mu      sync.Mutex
+	entries map[string]*remoteCacheEntry
+	size    int64
+	pulling map[string]chan struct{} // Closed when the pull of a name ends
+
+	uploadMu  sync.Mutex // Held by an upload and by removals, which must not race one
+	journalMu sync.Mutex
+	kick      chan struct{}
+	stop      chan struct{}
+	stopped   chan struct{}
+	closeOnce sync.Once
+}
+
+// remoteCacheEntry is a file present in the cache directory
+type remoteCacheEntry struct {
+	size    int64
+	modTime time.Time // Of the remote file
+	checked time.Time // Last time the remote was known to match
+	used    time.Time
+
+	dirty   bool // Written locally and not uploaded yet
+	writers int  // Handles open for writing
+	gen     int  // Bumped by every write, so an upload knows whether it is still current
+}
+
+// NewCachingBackend returns a CachingBackend for remote keeping its files in opts.Dir
+func NewCachingBackend(remote Backend, opts CacheOptions) (*CachingBackend, error) {
+	if remote == nil {
+		return nil, fmt.Errorf("NewCachingBackend: nil remote backend")
+	}
+	if opts.Dir == "" {
+		return nil, fmt.Errorf("NewCachingBackend: no cache directory")
+	}
+	if err := os.MkdirAll(opts.Dir, 0755); err != nil {
+		return nil, err
+	}
+
+	c := &CachingBackend{
+		remote:    remote,
+		dir:       opts.Dir,
+		ttl:       opts.TTL,
+		maxSize:   opts.MaxSize,
+		writeBack: opts.WriteBack,
+		entries:   map[string]*remoteCacheEntry{},
+		pulling:   map[string]chan struct{}{},
+	}
+	if c.ttl <= 0 {
+		c.ttl = DefaultRemoteCacheTTL
+	}
+
+	// Files left by an earlier run count against MaxSize and are checked before their first use
+	err := filepath.WalkDir(c.dir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		if strings.HasPrefix(d.Name(), ".") && strings.Contains(d.Name(), ".tmp") {
+			os.Remove(p) // Download cut short
+			return nil
+		}
+		if p == c.journalPath() {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil || !info.Mode().IsRegular() {
+			return nil
+		}
+		rel, _ := filepath.Rel(c.dir, p)
+		c.entries[memPath(filepath.ToSlash(rel))] = &remoteCacheEntry{size: info.Size(), modTime: info.ModTime(), used: info.ModTime()}
+		c.size += info.Size()
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if c.writeBack {
+		if err := c.loadJournal(); err != nil {
+			return nil, err
+		}
+		c.startUploads()
+	}
+	c.evict("")
+	return c, nil
+}
+
+// Remote returns the backend behind the cache
+func (c *CachingBackend) Remote() Backend {
+	return c.remote
+}
+
+// Size returns the bytes currently cached
+func (c *CachingBackend) Size() int64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.size
+}
+
+// LocalPath pulls name into the cache if needed and returns the local file holding it. The
+// file stays valid until it is evicted or name changes through the backend.
+func (c *CachingBackend) LocalPath(name string) (string, error) {
+	name = memPath(name)
+	if err := c.pull(name); err != nil {
+		return "", err
+	}
+	return c.localPath(name), nil
+}
+
+// Invalidate drops name from the cache, so the next read pulls it again
+func (c *CachingBackend) Invalidate(name string) {
+	c.drop(memPath(name), false)
+}
+
+func (c *CachingBackend) localPath(name string) string {
+	return filepath.Join(c.dir, filepath.FromSlash(name))
+}
+
+// pull makes the cached copy of name current, downloading it when missing, changed or
+// expired and changed. Concurrent pulls of one name share a download.
+func (c *CachingBackend) pull(name string) error {
+	for {
+		c.mu.Lock()
+		if wait, ok := c.pulling[name]; ok {
+			c.mu.Unlock()
+			<-wait
+			continue
+		}
+		e := c.entries[name]
+		if e != nil && (e.dirty || e.writers > 0 || time.Since(e.checked) < c.ttl) {
+			e.used = time.Now()
+			c.mu.Unlock()
+			return nil
+		}
+		wait := make(chan struct{})
+		c.pulling[name] = wait
+		c.mu.Unlock()
+
+		err := c.refresh(name, e)
+
+		c.mu.Lock()
+		delete(c.pulling, name)
+		close(wait)
+		c.mu.Unlock()
+		return err
+	}
+}
+
+// refresh checks the remote copy of name against the cached entry e, which may be nil, and
+// downloads it when they differ; the caller holds the pull of name
+func (c *CachingBackend) refresh(name string, e *remoteCacheEntry) error {
+	info, err := c.remote.Stat(name)
+	if err != nil {
+		if os.IsNotExist(err) {
+			c.drop(name, false)
+			return err
+		}
+		if _, serr := os.Stat(c.localPath(name)); e != nil && serr == nil {
+			// Keep serving what we have while the remote is unreachable
+			warnPrinter("CachingBackend: serving cached copy: "+err.Error(), name)
+			return nil
+		}
+		return err
+	}
+	if info.IsDir() {
+		return &os.PathError{Op: "open", Path: name, Err: fmt.Errorf("is a directory")}
+	}
+
+	if e != nil && e.size == info.Size() && e.modTime.Equal(info.ModTime()) {
+		if _, err := os.Stat(c.localPath(name)); err == nil {
+			c.mu.Lock()
+			e.checked, e.used = time.Now(), time.Now()
+			c.mu.Unlock()
+			return nil
+		}
+	}
+
+	if err := c.download(name, info); err != nil {
+		return err
+	}
+	c.evict(name)
+	return nil
+}
+
+// download copies the remote file into the cache through a temporary file
+func (c *CachingBackend) download(name string, info os.FileInfo) error {
+	local := c.localPath(name)
+	if err := os.MkdirAll(filepath.Dir(local), 0755); err != nil {
+		return err
+	}
+
+	src, err := c.remote.Open(name)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	acquireFDs(1)
+	defer releaseFDs(1)
+	tmp, err := os.CreateTemp(filepath.Dir(local), "."+filepath.Base(local)+".tmp*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	n, err := io.Copy(tmp, src)
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Chtimes(tmp.Name(), time.Now(), info.ModTime())
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), local)
+	}
+	if err != nil {
+		return &os.PathError{Op: "cache", Path: name, Err: err}
+	}
+
+	now := time.Now()
+	c.mu.Lock()
+	if old, ok := c.entries[name]; ok {
+		c.size -= old.size
+	}
+	c.entries[name] = &remoteCacheEntry{size: n, modTime: info.ModTime(), checked: now, used: now}
+	c.size += n
+	c.mu.Unlock()
+	return nil
+}
+
+// evict removes the least recently used files until the cache fits MaxSize, sparing keep
+func (c *CachingBackend) evict(keep string) {
+	if c.maxSize <= 0 {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.size <= c.maxSize {
+		return
+	}
+
+	names := make([]string, 0, len(c.entries))
+	for name := range c.entries {
+		e := c.entries[name]
+		if _, busy := c.pulling[name]; !busy && name != keep && !e.dirty && e.writers == 0 {
+			names = append(names, name)
+		}
+	}
+	sort.Slice(names, func(i, j int) bool { return c.entries[names[i]].used.Before(c.entries[names[j]].used) })
+
+	for _, name := range names {
+		if c.size <= c.maxSize {
+			break
+		}
+		if err := os.Remove(c.localPath(name)); err != nil && !os.IsNotExist(err) {
+			// Still open on Windows; try again on the next eviction
+			continue
+		}
+		c.size -= c.entries[name].size
+		delete(c.entries, name)
+	}
+}
+
+// drop removes name, and with tree everything below it, from the cache
+func (c *CachingBackend) drop(name string, tree bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for n, e := range c.entries {
+		if within(n, name, tree) {
+			os.Remove(c.localPath(n))
+			c.size -= e.size
+			delete(c.entries, n)
+		}
+	}
+}
+
+// within reports whether n is name or, with tree, below it
+func within(n string, name string, tree bool) bool {
+	return n == name || tree && (name == "/" || strings.HasPrefix(n, name+"/"))
+}
+
+func (c *CachingBackend) Open(name string) (File, error) {
+	return c.OpenFile(name, os.O_RDONLY, 0)
+}
+
+func (c *CachingBackend) Create(name string) (File, error) {
+	return c.OpenFile(name, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0666)
+}
+
+// OpenFile serves reads from the cache and sends writes to the remote, or with write-back
+// to the cache
+func (c *CachingBackend) OpenFile(name string, flag int, perm os.FileMode) (File, error) {
+	name = memPath(name)
+	if flagWrites(flag) && c.writeBack {
+		return c.openWriteBack(name, flag, perm)
+	}
+	if flagWrites(flag) {
+		c.drop(name, false)
+		return c.remote.OpenFile(name, flag, perm)
+	}
+
+	if err := c.pull(name); err != nil {
+		return nil, err
+	}
+	f, err := os.Open(c.localPath(name))
+	if err != nil {
+		return nil, &os.PathError{Op: "open", Path: name, Err: err}
+	}
+	return f, nil
+}
+
+// ReadFile lets readFile skip opening a handle
+func (c *CachingBackend) ReadFile(name string) ([]byte, error) {
+	name = memPath(name)
+	if err := c.pull(name); err != nil {
+		return nil, err
+	}
+	data, err := os.ReadFile(c.localPath(name))
+	if err != nil {
+		return nil, &os.PathError{Op: "read", Path: name, Err: err}
+	}
+	return data, nil
+}
+
+func (c *CachingBackend) Stat(name string) (os.FileInfo, error) {
+	if info, ok := c.localInfo(memPath(name)); ok {
+		return info, nil
+	}
+	return c.remote.Stat(name)
+}
+
+func (c *CachingBackend) Lstat(name string) (os.FileInfo, error) {
+	if info, ok := c.localInfo(memPath(name)); ok {
+		return info, nil
+	}
+	return c.remote.Lstat(name)
+}
+
+func (c *CachingBackend) ReadDir(name string) ([]os.DirEntry, error) {
+	entries, err := c.remote.ReadDir(name)
+	if !c.writeBack || err != nil && !os.IsNotExist(err) {
+		return entries, err
+	}
+	return c.mergePending(memPath(name), entries, err)
+}
+
+func (c *CachingBackend) Mkdir(name string, perm os.FileMode) error {
+	return c.remote.Mkdir(name, perm)
+}
+
+func (c *CachingBackend) MkdirAll(name string, perm os.FileMode) error {
+	return c.remote.MkdirAll(name, perm)
+}
+
+func (c *CachingBackend) Remove(name string) error {
+	c.uploadMu.Lock()
+	defer c.uploadMu.Unlock()
+
+	pending := c.dropPending(memPath(name), false)
+	err := c.remote.Remove(name)
+	if os.IsNotExist(err) && pending {
+		return nil // Never uploaded
+	}
+	return err
+}
+
+func (c *CachingBackend) RemoveAll(name string) error {
+	c.uploadMu.Lock()
+	defer c.uploadMu.Unlock()
+
+	c.dropPending(memPath(name), true)
+	return c.remote.RemoveAll(name)
+}
+
+// Rename, Chmod and Chtimes upload pending writes first, so the remote has what they act on
+
+func (c *CachingBackend) Rename(oldName string, newName string) error {
+	if err := c.settle(memPath(oldName), true); err != nil {
+		return err
+	}
+	c.uploadMu.Lock()
+	defer c.uploadMu.Unlock()
+
+	c.dropPending(memPath(oldName), true)
+	c.dropPending(memPath(newName), true)
+	return c.remote.Rename(oldName, newName)
+}
+
+func (c *CachingBackend) Chmod(name string, mode os.FileMode) error {
+	if err := c.settle(memPath(name), false); err != nil {
+		return err
+	}
+	return c.remote.Chmod(name, mode)
+}
+
+func (c *CachingBackend) Chtimes(name string, atime time.Time, mtime time.Time) error {
+	if err := c.settle(memPath(name), false); err != nil {
+		return err
+	}
+	c.drop(memPath(name), false)
+	return c.remote.Chtimes(name, atime, mtime)
+}
+
+// LocalPath returns a file on the local filesystem holding name: name itself for local paths,
+// and the cached copy for paths on a CachingBackend, which is pulled first if needed. Paths
+// on other backends fail.
+func LocalPath(name string) (string, error) {
+	b, p := backendFor(name)
+	switch b := b.(type) {
+	case LocalBackend:
+		return p, nil
+	case *CachingBackend:
+		local, err := b.LocalPath(p)
+		if err != nil {
+			errorPrinter("LocalPath: "+err.Error(), name)
+		}
+		return local, err
+	}
+	return "", &os.PathError{Op: "localpath", Path: name, Err: errNotLocal}
+}
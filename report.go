@@ -0,0 +1,284 @@
+package GMSFS
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// ReportFormat is an output format of Report.Write
+type ReportFormat string
+
+const (
+	ReportText ReportFormat = "text" // Aligned columns and a summary, for people and tickets
+	ReportJSON ReportFormat = "json" // The Report as one JSON document
+	ReportCSV  ReportFormat = "csv"  // One row per entry with a header row, for spreadsheets and pipelines
+)
+
+// Change is what a Report entry says happened to a path
+type Change string
+
+const (
+	ChangeAdded    Change = "added"    // Only on the new side
+	ChangeRemoved  Change = "removed"  // Only on the old side
+	ChangeModified Change = "modified" // On both sides with different content or attributes
+	ChangeType     Change = "type"     // A file on one side, a directory or symlink on the other
+	ChangeCopied   Change = "copied"   // Copied by a sync
+	ChangeSkipped  Change = "skipped"  // Left alone by a sync, being up to date
+	ChangeError    Change = "error"    // Could not be compared or copied
+)
+
+// Report lists the differences DiffDirs or VerifyManifest found, or what SyncDirWithReport did
+type Report struct {
+	Op      string        `json:"op"`
+	Old     string        `json:"old"` // DiffDirs' a, the manifest or the sync source
+	New     string        `json:"new"` // DiffDirs' b, the verified root or the sync destination
+	Created time.Time     `json:"created"`
+	Entries []ReportEntry `json:"entries"` // Sorted by path
+	Stats   *Stats        `json:"stats,omitempty"`
+}
+
+// ReportEntry is one path of a Report
+type ReportEntry struct {
+	Path   string `json:"path"` // Slash-separated, relative to the compared trees
+	Change Change `json:"change"`
+	Size   int64  `json:"size,omitempty"`
+	Detail string `json:"detail,omitempty"` // What differs, or the error
+}
+
+// Count returns the number of entries with the change c
+func (r Report) Count(c Change) int {
+	n := 0
+	for _, e := range r.Entries {
+		if e.Change == c {
+			n++
+		}
+	}
+	return n
+}
+
+// Changed reports whether the report has entries other than copies and skips, i.e. whether
+// the compared trees differ or something failed
+func (r Report) Changed() bool {
+	for _, e := range r.Entries {
+		if e.Change != ChangeCopied && e.Change != ChangeSkipped {
+			return true
+		}
+	}
+	return false
+}
+
+// Write renders the report to w in format
+func (r Report) Write(w io.Writer, format ReportFormat) error {
+	switch format {
+	case ReportJSON:
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(r)
+
+	case ReportCSV:
+		cw := csv.NewWriter(w)
+		cw.Write([]string{"path", "change", "size", "detail"})
+		for _, e := range r.Entries {
+			cw.Write([]string{e.Path, string(e.Change), strconv.FormatInt(e.Size, 10), e.Detail})
+		}
+		cw.Flush()
+		return cw.Error()
+
+	case ReportText, "":
+		tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+		fmt.Fprintf(tw, "%s %s -> %s at %s\n", r.Op, r.Old, r.New, r.Created.Format(time.RFC3339))
+		for _, e := range r.Entries {
+			fmt.Fprintf(tw, "%s\t%s\t%s\n", e.Change, e.Path, e.Detail)
+		}
+		if err := tw.Flush(); err != nil {
+			return err
+		}
+
+		var summary []string
+		for _, c := range []Change{ChangeAdded, ChangeRemoved, ChangeModified, ChangeType, ChangeCopied, ChangeSkipped, ChangeError} {
+			if n := r.Count(c); n > 0 {
+				summary = append(summary, fmt.Sprintf("%d %s", n, c))
+			}
+		}
+		if len(summary) == 0 {
+			summary = []string{"no differences"}
+		}
+		_, err := fmt.Fprintln(w, strings.Join(summary, ", "))
+		return err
+	}
+	return fmt.Errorf("unknown report format %q", format)
+}
+
+// DiffDirs compares the trees a and b and reports what was added to, removed from and
+// modified in b relative to a. Files of equal size with different modification times are
+// compared by content, so a copy made without PreserveTimes does not show as modified.
+// Directory times are ignored; symlinks compare by target.
+func DiffDirs(a string, b string) (Report, error) {
+	a = cleanPath(a)
+	b = cleanPath(b)
+	if err := requireLocal("diff", a, b); err != nil {
+		errorPrinter("DiffDirs: "+err.Error(), a)
+		return Report{}, err
+	}
+
+	old, _, err := buildManifest(a, "", TarOptions{})
+	if err != nil {
+		errorPrinter("DiffDirs: "+err.Error(), a)
+		return Report{}, err
+	}
+	cur, _, err := buildManifest(b, "", TarOptions{})
+	if err != nil {
+		errorPrinter("DiffDirs: "+err.Error(), b)
+		return Report{}, err
+	}
+
+	r := Report{Op: "DiffDirs", Old: a, New: b, Created: time.Now()}
+	r.Entries = diffManifests(old, cur, func(rel string, was ManifestEntry, is ManifestEntry) (string, error) {
+		if detail := entryDiff(was, is); detail != "" || !was.Mode.IsRegular() || was.ModTime.Equal(is.ModTime) {
+			return detail, nil
+		}
+		same, err := sameContent(os.DirFS(a), rel, filepath.Join(b, filepath.FromSlash(rel)))
+		if err != nil || same {
+			return "", err
+		}
+		return "content", nil
+	})
+	return r, nil
+}
+
+// VerifyManifest compares the tree at root with a manifest of it, e.g. one saved with a
+// backup, and reports the paths added, removed or modified since. Files compare by size,
+// mode and modification time, as a manifest holds no content hashes.
+func VerifyManifest(root string, m Manifest) (Report, error) {
+	root = cleanPath(root)
+	if err := requireLocal("verify", root); err != nil {
+		errorPrinter("VerifyManifest: "+err.Error(), root)
+		return Report{}, err
+	}
+
+	cur, _, err := buildManifest(root, "", TarOptions{})
+	if err != nil {
+		errorPrinter("VerifyManifest: "+err.Error(), root)
+		return Report{}, err
+	}
+
+	r := Report{Op: "VerifyManifest", Old: "manifest of " + m.Created.Format(time.RFC3339), New: root, Created: time.Now()}
+	r.Entries = diffManifests(m, cur, func(rel string, was ManifestEntry, is ManifestEntry) (string, error) {
+		detail := entryDiff(was, is)
+		if detail == "" && was.Mode.IsRegular() && !was.ModTime.Equal(is.ModTime) {
+			detail = "mtime"
+		}
+		return detail, nil
+	})
+	return r, nil
+}
+
+// diffManifests lists the differences from old to cur; differ describes how an entry on both
+// sides with the same type differs, or returns "" when it does not
+func diffManifests(old Manifest, cur Manifest, differ func(rel string, was ManifestEntry, is ManifestEntry) (string, error)) []ReportEntry {
+	entries := []ReportEntry{}
+	for rel, was := range old.Files {
+		is, ok := cur.Files[rel]
+		switch {
+		case !ok:
+			entries = append(entries, ReportEntry{Path: rel, Change: ChangeRemoved, Size: was.Size})
+		case was.Mode.Type() != is.Mode.Type():
+			entries = append(entries, ReportEntry{Path: rel, Change: ChangeType, Size: is.Size, Detail: typeName(was.Mode) + " -> " + typeName(is.Mode)})
+		default:
+			detail, err := differ(rel, was, is)
+			if err != nil {
+				entries = append(entries, ReportEntry{Path: rel, Change: ChangeError, Detail: err.Error()})
+			} else if detail != "" {
+				entries = append(entries, ReportEntry{Path: rel, Change: ChangeModified, Size: is.Size, Detail: detail})
+			}
+		}
+	}
+	for rel, is := range cur.Files {
+		if _, ok := old.Files[rel]; !ok {
+			entries = append(entries, ReportEntry{Path: rel, Change: ChangeAdded, Size: is.Size})
+		}
+	}
+
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Path < entries[j].Path })
+	return entries
+}
+
+// entryDiff names the attributes other than file times that differ between two entries of
+// the same type
+func entryDiff(was ManifestEntry, is ManifestEntry) string {
+	switch {
+	case was.Link != is.Link:
+		return "target " + was.Link + " -> " + is.Link
+	case was.Size != is.Size:
+		return fmt.Sprintf("size %d -> %d", was.Size, is.Size)
+	case was.Mode != is.Mode:
+		return "mode " + was.Mode.String() + " -> " + is.Mode.String()
+	}
+	return ""
+}
+
+func typeName(mode os.FileMode) string {
+	switch {
+	case mode.IsDir():
+		return "directory"
+	case mode&os.ModeSymlink != 0:
+		return "symlink"
+	}
+	return "file"
+}
+
+// SyncDirWithReport is SyncDirWithOptions reporting every file it copied, skipped or failed
+// on. Events given in opts still receive every event.
+func SyncDirWithReport(src string, dst string, opts CopyOptions) (Report, error) {
+	src = cleanPath(src)
+	dst = cleanPath(dst)
+
+	r := Report{Op: "SyncDir", Old: src, New: dst, Created: time.Now(), Entries: []ReportEntry{}}
+	forward := opts.Events
+	events := make(chan JobEvent, 64)
+	opts.Events = events
+
+	collected := make(chan struct{})
+	go func() {
+		defer close(collected)
+		for ev := range events {
+			if forward != nil {
+				forward <- ev
+			}
+
+			rel, err := filepath.Rel(src, ev.Path)
+			if err != nil {
+				rel = ev.Path
+			}
+			entry := ReportEntry{Path: filepath.ToSlash(rel), Size: ev.Bytes}
+			switch {
+			case ev.Type == JobFileDone && ev.Skipped:
+				entry.Change = ChangeSkipped
+			case ev.Type == JobFileDone:
+				entry.Change = ChangeCopied
+			case ev.Type == JobError:
+				entry.Change, entry.Detail = ChangeError, ev.Err.Error()
+			default:
+				continue
+			}
+			r.Entries = append(r.Entries, entry)
+		}
+	}()
+
+	stats, err := SyncDirWithOptions(src, dst, opts)
+	close(events)
+	<-collected
+
+	sort.SliceStable(r.Entries, func(i, j int) bool { return r.Entries[i].Path < r.Entries[j].Path })
+	r.Stats = &stats
+	return r, err
+}
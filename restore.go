@@ -0,0 +1,298 @@
+package GMSFS
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// RestoreConflict decides what RestoreArchive does with a file of the archive whose path is
+// taken in the destination
+type RestoreConflict int
+
+const (
+	RestoreFail      RestoreConflict = iota // Restore nothing when any file is in the way
+	RestoreSkip                             // Keep the existing file and leave the archived one out
+	RestoreOverwrite                        // Replace the existing file
+	RestoreKeepBoth                         // Restore next to it as "name (restored).ext", numbered when taken
+)
+
+// RestorePolicy adjusts RestoreArchive
+type RestorePolicy struct {
+	Conflict RestoreConflict
+
+	// Paths restores only the entries matching one of these globs, matched like
+	// TarOptions.Include; a directory selects everything below it. Empty restores all.
+	Paths  []string
+	DryRun bool // Only report what would be restored and what is in the way
+}
+
+// RestoreReport lists what RestoreArchive did, or would do, by slash-separated archive path
+type RestoreReport struct {
+	Restored  []string          // Files written to their own path, replaced ones included
+	Conflicts []string          // Files whose path was taken, whatever Conflict did about them
+	Renamed   map[string]string // Files restored elsewhere by RestoreKeepBoth, to the path used
+	Missing   []string          // Paths that matched no entry of the archive
+}
+
+// RestoreArchive extracts a tar or zip archive into dstDir, which may hold live data, without
+// blindly replacing it: every file of the archive is checked against the destination before
+// anything is written, and policy.Conflict decides what happens to those in the way. With
+// RestoreFail the report lists the conflicts and the error matches os.ErrExist. Directories
+// are merged with existing ones. Archives are checked for unsafe paths as by Unzip and
+// ReadTar, and a tar archive is read twice.
+func RestoreArchive(archive string, dstDir string, policy RestorePolicy) (RestoreReport, error) {
+	archive = cleanPath(archive)
+	dstDir = cleanPath(dstDir)
+	if err := requireLocal("restore", archive, dstDir); err != nil {
+		errorPrinter("RestoreArchive: "+err.Error(), archive)
+		return RestoreReport{}, err
+	}
+	if err := validateArchiveGlobs(policy.Paths, nil); err != nil {
+		return RestoreReport{}, err
+	}
+
+	_, j := startJob(context.Background(), "RestoreArchive", archive, dstDir)
+	defer j.done()
+
+	acquireFDs(2)
+	defer releaseFDs(2)
+
+	// The archive's own paths, which renamed files must not take
+	names := map[string]bool{}
+	// Where each selected entry goes; selected files left out of it are skipped
+	targets := map[string]string{}
+	used := make([]bool, len(policy.Paths))
+	var files []string
+	err := walkRestore(archive, dstDir, false, nil, func(rel string, dir bool, _ func(string) error) error {
+		names[rel] = true
+		if !restoreSelected(rel, policy.Paths, used) {
+			return nil
+		}
+		if dir {
+			targets[rel] = rel
+		} else {
+			files = append(files, rel)
+		}
+		return nil
+	})
+	if err != nil {
+		errorPrinter("RestoreArchive: "+err.Error(), archive)
+		return RestoreReport{}, err
+	}
+
+	report := RestoreReport{Renamed: map[string]string{}}
+	for i, p := range policy.Paths {
+		if !used[i] {
+			report.Missing = append(report.Missing, p)
+		}
+	}
+	for _, rel := range files {
+		if _, ok := targets[rel]; ok {
+			continue
+		}
+		_, err := os.Lstat(filepath.Join(dstDir, filepath.FromSlash(rel)))
+		if os.IsNotExist(err) {
+			targets[rel] = rel
+			report.Restored = append(report.Restored, rel)
+			continue
+		}
+		if err != nil {
+			errorPrinter("RestoreArchive: "+err.Error(), dstDir)
+			return report, err
+		}
+
+		report.Conflicts = append(report.Conflicts, rel)
+		switch policy.Conflict {
+		case RestoreOverwrite:
+			targets[rel] = rel
+			report.Restored = append(report.Restored, rel)
+		case RestoreKeepBoth:
+			to := restoreName(dstDir, rel, names)
+			names[to] = true
+			targets[rel] = to
+			report.Renamed[rel] = to
+		}
+	}
+
+	if policy.Conflict == RestoreFail && len(report.Conflicts) > 0 {
+		err := &os.PathError{Op: "restore", Path: dstDir, Err: fmt.Errorf("%d files of the archive are in the way: %w", len(report.Conflicts), os.ErrExist)}
+		errorPrinter("RestoreArchive: "+err.Error(), dstDir)
+		return report, err
+	}
+	if policy.DryRun {
+		return report, nil
+	}
+
+	if err := os.MkdirAll(dstDir, 0755); err != nil {
+		errorPrinter("RestoreArchive (os.MkdirAll): "+err.Error(), dstDir)
+		return report, err
+	}
+	defer invalidateStatTree(dstDir)
+
+	// A path the archive holds twice is restored from its first entry
+	done := map[string]bool{}
+	err = walkRestore(archive, dstDir, policy.Conflict == RestoreOverwrite, report.Renamed, func(rel string, dir bool, extract func(string) error) error {
+		to, ok := targets[rel]
+		if !ok || done[rel] && !dir {
+			return nil
+		}
+		done[rel] = true
+		if !dir {
+			j.add(1, 0)
+		}
+		return extract(to)
+	})
+	if err != nil {
+		errorPrinter("RestoreArchive: "+err.Error(), archive)
+		return report, err
+	}
+
+	return report, nil
+}
+
+// walkRestore calls fn with the cleaned path of every entry of archive, whether it is a
+// directory, and a function extracting it to another path under dstDir during the call. Hard
+// links to entries in renamed point at where those were restored.
+func walkRestore(archive string, dstDir string, overwrite bool, renamed map[string]string, fn func(rel string, dir bool, extract func(to string) error) error) error {
+	simulateOp()
+	in, err := os.Open(archive)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	magic := make([]byte, 4)
+	n, _ := io.ReadFull(in, magic)
+	if string(magic[:n]) == "PK\x03\x04" || string(magic[:n]) == "PK\x05\x06" {
+		return walkRestoreZip(archive, dstDir, overwrite, fn)
+	}
+	if _, err := in.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+
+	dr, err := decompressReader(simulatedReader(in))
+	if err != nil {
+		return err
+	}
+	defer dr.Close()
+
+	opts := TarOptions{Overwrite: overwrite}
+	var dirs []*tar.Header
+	tr := tar.NewReader(dr)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		if header.Typeflag == tar.TypeXGlobalHeader || header.Typeflag == tar.TypeXHeader {
+			continue
+		}
+		rel, err := archiveEntryPath(header.Name)
+		if err != nil {
+			return err
+		}
+		if rel == "." {
+			continue
+		}
+
+		err = fn(rel, header.Typeflag == tar.TypeDir, func(to string) error {
+			header.Name = to
+			if header.Typeflag == tar.TypeLink {
+				if old, err := archiveEntryPath(header.Linkname); err == nil && renamed[old] != "" {
+					header.Linkname = renamed[old]
+				}
+			}
+			return untarEntry(tr, header, dstDir, opts, &dirs)
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	untarDirAttrs(dstDir, dirs)
+	return nil
+}
+
+// walkRestoreZip is walkRestore for zip archives
+func walkRestoreZip(archive string, dstDir string, overwrite bool, fn func(rel string, dir bool, extract func(to string) error) error) error {
+	zr, err := zip.OpenReader(archive)
+	if err != nil {
+		return err
+	}
+	defer zr.Close()
+
+	opts := ZipOptions{Overwrite: overwrite}
+	for _, f := range zr.File {
+		rel, err := archiveEntryPath(f.Name)
+		if err != nil {
+			return err
+		}
+		if rel == "." {
+			continue
+		}
+
+		err = fn(rel, f.Mode().IsDir(), func(to string) error {
+			f.Name = to
+			return unzipEntry(f, dstDir, opts)
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// restoreSelected reports whether rel, or a directory above it, matches one of paths, and
+// marks the ones it matches in used
+func restoreSelected(rel string, paths []string, used []bool) bool {
+	if len(paths) == 0 {
+		return true
+	}
+
+	selected := false
+	for i, p := range paths {
+		for dir := rel; dir != "."; dir = path.Dir(dir) {
+			if archiveMatch(dir, []string{p}) {
+				used[i] = true
+				selected = true
+				break
+			}
+		}
+	}
+	return selected
+}
+
+// restoreName returns a free path next to rel for RestoreKeepBoth: "report (restored).txt",
+// then "report (restored 2).txt" and so on, avoiding the paths in taken
+func restoreName(dstDir string, rel string, taken map[string]bool) string {
+	dir, base := path.Split(rel)
+	ext := path.Ext(base)
+	stem := strings.TrimSuffix(base, ext)
+	if stem == "" {
+		stem, ext = base, ""
+	}
+
+	for n := 1; ; n++ {
+		suffix := " (restored)"
+		if n > 1 {
+			suffix = fmt.Sprintf(" (restored %d)", n)
+		}
+		name := dir + stem + suffix + ext
+		if taken[name] {
+			continue
+		}
+		if _, err := os.Lstat(filepath.Join(dstDir, filepath.FromSlash(name))); os.IsNotExist(err) {
+			return name
+		}
+	}
+}
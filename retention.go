@@ -0,0 +1,98 @@
+package GMSFS
+
+import (
+	"errors"
+	"os"
+	"sort"
+	"time"
+)
+
+// DeleteOlderThan deletes the files directly in dir whose names match pattern (a glob like
+// "*.log" or "*.{tmp,bak}"; "" matches all) and that were last modified more than age ago,
+// and returns the deleted paths. Subdirectories are left alone. A file that cannot be
+// deleted does not stop the others; the errors are returned together.
+func DeleteOlderThan(dir string, pattern string, age time.Duration) ([]string, error) {
+	files, err := retentionCandidates(dir, pattern)
+	if err != nil {
+		errorPrinter("DeleteOlderThan: "+err.Error(), dir)
+		return nil, err
+	}
+
+	cutoff := time.Now().Add(-age)
+	var old []string
+	for _, f := range files {
+		if f.mtime.Before(cutoff) {
+			old = append(old, f.path)
+		}
+	}
+	return deleteAll(old)
+}
+
+// Prune keeps the keep most recently modified files directly in dir whose names match
+// pattern and deletes the rest, returning the deleted paths, oldest first. Like
+// DeleteOlderThan it skips subdirectories and carries on past files it cannot delete.
+func Prune(dir string, keep int, pattern string) ([]string, error) {
+	files, err := retentionCandidates(dir, pattern)
+	if err != nil {
+		errorPrinter("Prune: "+err.Error(), dir)
+		return nil, err
+	}
+	if keep < 0 {
+		keep = 0
+	}
+	if len(files) <= keep {
+		return nil, nil
+	}
+
+	var old []string
+	for _, f := range files[:len(files)-keep] {
+		old = append(old, f.path)
+	}
+	return deleteAll(old)
+}
+
+type retentionFile struct {
+	path  string
+	mtime time.Time
+}
+
+// retentionCandidates lists the regular files in dir matching pattern, oldest first
+func retentionCandidates(dir string, pattern string) ([]retentionFile, error) {
+	dir = cleanPath(dir)
+	if err := requireLocal("prune", dir); err != nil {
+		return nil, err
+	}
+	if pattern == "" {
+		pattern = "*"
+	}
+
+	matches, err := FindFilesInDir(dir, pattern)
+	if err != nil {
+		return nil, err
+	}
+
+	var files []retentionFile
+	for _, m := range matches {
+		info, err := os.Lstat(m)
+		if err != nil || !info.Mode().IsRegular() {
+			continue
+		}
+		files = append(files, retentionFile{path: m, mtime: info.ModTime()})
+	}
+	sort.SliceStable(files, func(i, j int) bool { return files[i].mtime.Before(files[j].mtime) })
+	return files, nil
+}
+
+// deleteAll deletes names, returning the ones it deleted and the errors of the others
+func deleteAll(names []string) ([]string, error) {
+	var deleted []string
+	var errs []error
+	for _, name := range names {
+		if err := Delete(name); err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		deleted = append(deleted, name)
+	}
+	return deleted, errors.Join(errs...)
+}
@@ -0,0 +1,131 @@
+package GMSFS
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+
+	cmap "github.com/orcaman/concurrent-map/v2"
+)
+
+// RetrieveFunc materializes the file a stub stands for: it replaces the stub at name with the
+// content stub.Location points at, e.g. by fetching it from a remote system or an archive.
+// RecallStub does this for the stubs tiering leaves. fn must not read name through the
+// package, which would wait for fn itself.
+type RetrieveFunc func(ctx context.Context, name string, stub Stub) error
+
+var retrievers = cmap.New[RetrieveFunc]()
+
+// retrieving holds the retrievals in progress, so concurrent readers of a stub wait for one
+var retrieving = struct {
+	mu    sync.Mutex
+	calls map[string]*retrieveCall
+}{calls: map[string]*retrieveCall{}}
+
+type retrieveCall struct {
+	done chan struct{}
+	err  error
+}
+
+// RegisterRetriever makes Open, OpenFile and ReadFile hand stubs whose Location starts with
+// prefix to fn, and then serve the content fn put in their place, so callers never see the
+// stub. The longest matching prefix wins; "" matches every stub. Without a retriever for a
+// stub ReadFile reads the archived copy through as SetTierRules describes, and Open and
+// OpenFile return the stub itself. While any retriever is registered, Open and OpenFile stat
+// the file first to look for stubs.
+func RegisterRetriever(prefix string, fn RetrieveFunc) {
+	retrievers.Set(prefix, fn)
+}
+
+// UnregisterRetriever removes the retriever registered for prefix
+func UnregisterRetriever(prefix string) {
+	retrievers.Remove(prefix)
+}
+
+// RecallStub is a RetrieveFunc putting back the archived copy of a tiering stub, as
+// RecallFile does
+func RecallStub(ctx context.Context, name string, stub Stub) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return RecallFile(name)
+}
+
+// retrieverFor returns the retriever for a stub at location
+func retrieverFor(location string) (RetrieveFunc, bool) {
+	if retrievers.IsEmpty() {
+		return nil, false
+	}
+	var fn RetrieveFunc
+	best := -1
+	for item := range retrievers.IterBuffered() {
+		if len(item.Key) > best && strings.HasPrefix(location, item.Key) {
+			fn, best = item.Val, len(item.Key)
+		}
+	}
+	return fn, best >= 0
+}
+
+// retrieveIfStub materializes name, about to be opened, if it is a stub with a retriever
+func retrieveIfStub(ctx context.Context, name string) error {
+	if retrievers.IsEmpty() {
+		return nil
+	}
+	info, err := os.Stat(name)
+	if err != nil || !info.Mode().IsRegular() || info.Size() > maxStubSize || info.Size() < int64(len(stubMagic)) {
+		return nil
+	}
+	stub, ok, err := ReadStub(name)
+	if err != nil || !ok {
+		return nil
+	}
+	fn, ok := retrieverFor(stub.Location)
+	if !ok {
+		return nil
+	}
+	return retrieveStub(ctx, name, fn)
+}
+
+// retrieveStub runs fn for the stub at name, once for all callers asking at the same time,
+// and checks that it replaced the stub
+func retrieveStub(ctx context.Context, name string, fn RetrieveFunc) error {
+	retrieving.mu.Lock()
+	if call, ok := retrieving.calls[name]; ok {
+		retrieving.mu.Unlock()
+		select {
+		case <-call.done:
+			return call.err
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	call := &retrieveCall{done: make(chan struct{})}
+	retrieving.calls[name] = call
+	retrieving.mu.Unlock()
+
+	// A retrieval that just finished may have materialized name since the caller read the stub
+	var err error
+	if current, still, _ := ReadStub(name); still {
+		err = fn(ctx, name, current)
+		invalidateStatTree(name)
+		if err == nil {
+			if _, still, _ := ReadStub(name); still {
+				err = fmt.Errorf("retriever for %s left the stub in place", current.Location)
+			}
+		}
+		if err != nil {
+			errorPrinterCtx(ctx, "Retrieve: "+err.Error(), name)
+		} else {
+			infoPrinter("Retrieve: materialized from "+current.Location, name)
+		}
+	}
+
+	retrieving.mu.Lock()
+	delete(retrieving.calls, name)
+	retrieving.mu.Unlock()
+	call.err = err
+	close(call.done)
+	return err
+}
@@ -0,0 +1,281 @@
+package GMSFS
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+)
+
+// ErrPathEscapes is returned by Root methods for paths that resolve outside the root
+var ErrPathEscapes = errors.New("path escapes root")
+
+// maxRootLinks bounds the symlinks followed while resolving one path, like the kernel's ELOOP
+const maxRootLinks = 40
+
+// Root confines the package operations to one directory, for paths coming from users:
+//
+//	root, err := GMSFS.NewRoot("/srv/uploads")
+//	data, err := root.ReadFile(r.URL.Query().Get("file")) // "../../etc/passwd" fails with ErrPathEscapes
+//
+// Names are relative to the root; absolute names, ".." climbing above it and symlinks
+// pointing outside it are refused with ErrPathEscapes. Symlinks within the root work.
+// Resolution happens before each operation, so a process that can change the tree at the
+// same time could still swap a directory for a symlink in between; Root protects against
+// hostile names, not hostile neighbours.
+type Root struct {
+	dir string // Absolute, with symlinks resolved
+}
+
+// NewRoot returns a Root for the local directory dir
+func NewRoot(dir string) (*Root, error) {
+	dir = cleanPath(dir)
+	if err := requireLocal("root", dir); err != nil {
+		return nil, err
+	}
+
+	abs, err := filepath.Abs(dir)
+	if err == nil {
+		abs, err = filepath.EvalSymlinks(abs)
+	}
+	if err != nil {
+		errorPrinter("NewRoot: "+err.Error(), dir)
+		return nil, err
+	}
+	info, err := os.Stat(abs)
+	if err != nil {
+		errorPrinter("NewRoot (os.Stat): "+err.Error(), dir)
+		return nil, err
+	}
+	if !info.IsDir() {
+		return nil, fmt.Errorf("NewRoot: %s is not a directory", dir)
+	}
+
+	return &Root{dir: abs}, nil
+}
+
+// Name returns the directory of the root, absolute and with symlinks resolved
+func (r *Root) Name() string {
+	return r.dir
+}
+
+// Path resolves name to a path on the local filesystem, for package functions Root does not
+// mirror. The result only stays inside the root while the tree is left alone.
+func (r *Root) Path(name string) (string, error) {
+	return r.resolve("resolve", name, true)
+}
+
+// resolve maps name onto the root, following symlinks as long as they stay inside it. The
+// final element is followed only when follow is set, so Remove and Rename act on links
+// themselves.
+func (r *Root) resolve(op string, name string, follow bool) (string, error) {
+	escapes := func() (string, error) {
+		err := &os.PathError{Op: op, Path: name, Err: ErrPathEscapes}
+		errorPrinter("Root: "+err.Error(), r.dir)
+		return "", err
+	}
+
+	if strings.Contains(name, "\x00") {
+		return "", &os.PathError{Op: op, Path: name, Err: os.ErrInvalid}
+	}
+	if filepath.IsAbs(name) || filepath.VolumeName(name) != "" || strings.HasPrefix(filepath.ToSlash(name), "/") {
+		return escapes()
+	}
+
+	resolved := r.dir
+	parts := splitRootPath(name)
+	links := 0
+	for len(parts) > 0 {
+		part := parts[0]
+		parts = parts[1:]
+
+		switch part {
+		case "", ".":
+			continue
+		case "..":
+			if resolved == r.dir {
+				return escapes()
+			}
+			resolved = filepath.Dir(resolved)
+			continue
+		}
+
+		next := filepath.Join(resolved, part)
+		info, err := os.Lstat(next)
+		if err != nil || info.Mode()&os.ModeSymlink == 0 || (len(parts) == 0 && !follow) {
+			// Missing elements are taken as they are; whatever creates them stays inside
+			resolved = next
+			continue
+		}
+
+		links++
+		if links > maxRootLinks {
+			return "", &os.PathError{Op: op, Path: name, Err: syscall.ELOOP}
+		}
+		target, err := os.Readlink(next)
+		if err != nil {
+			return "", &os.PathError{Op: op, Path: name, Err: err}
+		}
+		if filepath.IsAbs(target) {
+			rel, ok := relWithin(r.dir, target)
+			if !ok {
+				return escapes()
+			}
+			resolved, target = r.dir, rel
+		}
+		parts = append(splitRootPath(target), parts...)
+	}
+
+	if colonEscapes(resolved) {
+		return escapes()
+	}
+	return resolved, nil
+}
+
+func splitRootPath(name string) []string {
+	return strings.Split(filepath.ToSlash(name), "/")
+}
+
+// resolveChange resolves a name about to be removed or renamed, refusing the root itself
+func (r *Root) resolveChange(op string, name string) (string, error) {
+	p, err := r.resolve(op, name, false)
+	if err == nil && p == r.dir {
+		return "", &os.PathError{Op: op, Path: name, Err: os.ErrPermission}
+	}
+	return p, err
+}
+
+func (r *Root) Open(name string) (*os.File, error) {
+	p, err := r.resolve("open", name, true)
+	if err != nil {
+		return nil, err
+	}
+	return Open(p)
+}
+
+func (r *Root) OpenFile(name string, flag int, perm os.FileMode) (*os.File, error) {
+	p, err := r.resolve("open", name, true)
+	if err != nil {
+		return nil, err
+	}
+	return OpenFile(p, flag, perm)
+}
+
+func (r *Root) Create(name string) (*os.File, error) {
+	p, err := r.resolve("open", name, true)
+	if err != nil {
+		return nil, err
+	}
+	return Create(p)
+}
+
+func (r *Root) ReadFile(name string) ([]byte, error) {
+	p, err := r.resolve("open", name, true)
+	if err != nil {
+		return nil, err
+	}
+	return ReadFile(p)
+}
+
+func (r *Root) WriteFile(name string, content []byte, perm os.FileMode) error {
+	p, err := r.resolve("open", name, true)
+	if err != nil {
+		return err
+	}
+	return WriteFile(p, content, perm)
+}
+
+func (r *Root) Append(name string, content []byte) error {
+	p, err := r.resolve("open", name, true)
+	if err != nil {
+		return err
+	}
+	return Append(p, content)
+}
+
+func (r *Root) FileExists(name string) bool {
+	p, err := r.resolve("stat", name, true)
+	return err == nil && FileExists(p)
+}
+
+func (r *Root) Stat(name string) (FileInfo, error) {
+	p, err := r.resolve("stat", name, true)
+	if err != nil {
+		return FileInfo{}, err
+	}
+	return Stat(p)
+}
+
+func (r *Root) ReadDir(name string) ([]FileInfo, error) {
+	p, err := r.resolve("readdir", name, true)
+	if err != nil {
+		return nil, err
+	}
+	return ReadDir(p)
+}
+
+func (r *Root) Mkdir(name string, perm os.FileMode) error {
+	p, err := r.resolve("mkdir", name, false)
+	if err != nil {
+		return err
+	}
+	return Mkdir(p, perm)
+}
+
+func (r *Root) MkdirAll(name string, perm os.FileMode) error {
+	p, err := r.resolve("mkdir", name, true)
+	if err != nil {
+		return err
+	}
+	return MkdirAll(p, perm)
+}
+
+func (r *Root) Delete(name string) error {
+	p, err := r.resolveChange("remove", name)
+	if err != nil {
+		return err
+	}
+	return Delete(p)
+}
+
+func (r *Root) Remove(name string) error {
+	p, err := r.resolveChange("remove", name)
+	if err != nil {
+		return err
+	}
+	return Remove(p)
+}
+
+func (r *Root) RemoveAll(name string) error {
+	p, err := r.resolveChange("remove", name)
+	if err != nil {
+		return err
+	}
+	return RemoveAll(p)
+}
+
+func (r *Root) Rename(oldName string, newName string) error {
+	oldPath, err := r.resolveChange("rename", oldName)
+	if err != nil {
+		return err
+	}
+	newPath, err := r.resolveChange("rename", newName)
+	if err != nil {
+		return err
+	}
+	return Rename(oldPath, newPath)
+}
+
+func (r *Root) CopyFile(src string, dst string) error {
+	srcPath, err := r.resolve("open", src, true)
+	if err != nil {
+		return err
+	}
+	dstPath, err := r.resolve("open", dst, true)
+	if err != nil {
+		return err
+	}
+	return CopyFile(srcPath, dstPath)
+}
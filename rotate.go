@@ -0,0 +1,257 @@
+package GMSFS
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// RotateOptions decides when a RotatingWriter starts a new file and what it keeps of the old
+// ones. With neither MaxSize nor MaxAge set, files only rotate through Rotate.
+type RotateOptions struct {
+	MaxSize  int64         // Rotate before a write would take the file past this many bytes
+	MaxAge   time.Duration // Rotate once the file was started this long ago
+	Keep     int           // Rotated files to keep, oldest removed first; 0 keeps all
+	Compress bool          // Gzip rotated files, in the background
+}
+
+// RotatingWriter is an io.WriteCloser appending to a log file through Append, so writes share
+// its pooled handle, profiles and throttling, and moving the file aside when it gets too big
+// or too old. Rotated files are named after the minute the file was started, in the format
+// of the debug log, e.g. app.log becomes app.20240131_1405.log, or app.20240131_1405.1.log
+// when that name is taken.
+type RotatingWriter struct {
+	name string
+	opts RotateOptions
+
+	mu      sync.Mutex
+	size    int64
+	started time.Time
+	closed  bool
+
+	bg   sync.WaitGroup
+	bgMu sync.Mutex // Serializes compressing and pruning
+}
+
+// NewRotatingWriter returns a writer appending to name. An existing file is continued; as
+// its start is not recorded, its age counts from its last modification.
+func NewRotatingWriter(name string, opts RotateOptions) (*RotatingWriter, error) {
+	name = cleanPath(name)
+	if err := requireLocal("rotate", name); err != nil {
+		errorPrinter("NewRotatingWriter: "+err.Error(), name)
+		return nil, err
+	}
+	if opts.MaxSize < 0 || opts.MaxAge < 0 || opts.Keep < 0 {
+		return nil, fmt.Errorf("NewRotatingWriter: negative option in %+v", opts)
+	}
+
+	w := &RotatingWriter{name: name, opts: opts, started: time.Now()}
+	if info, err := os.Stat(name); err == nil {
+		w.size = info.Size()
+		w.started = info.ModTime()
+	} else if !os.IsNotExist(err) {
+		errorPrinter("NewRotatingWriter: "+err.Error(), name)
+		return nil, err
+	}
+
+	registerCloser(w)
+	return w, nil
+}
+
+// Name returns the file being written
+func (w *RotatingWriter) Name() string {
+	return w.name
+}
+
+// Write appends p to the file, rotating it first when MaxSize or MaxAge says so. A single
+// write larger than MaxSize goes to a file of its own.
+func (w *RotatingWriter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.closed {
+		return 0, &os.PathError{Op: "write", Path: w.name, Err: os.ErrClosed}
+	}
+
+	if w.size > 0 && w.due(len(p)) {
+		if err := w.rotate(); err != nil {
+			return 0, err
+		}
+	}
+
+	if err := Append(w.name, p); err != nil {
+		return 0, err
+	}
+	if w.size == 0 {
+		w.started = time.Now()
+	}
+	w.size += int64(len(p))
+	return len(p), nil
+}
+
+// Rotate moves the current file aside now, unless it is empty
+func (w *RotatingWriter) Rotate() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.closed {
+		return &os.PathError{Op: "rotate", Path: w.name, Err: os.ErrClosed}
+	}
+	if w.size == 0 {
+		return nil
+	}
+	return w.rotate()
+}
+
+// Close closes the file and waits for rotated files still being compressed
+func (w *RotatingWriter) Close() error {
+	w.mu.Lock()
+	w.closed = true
+	closeAppendHandle(w.name)
+	w.mu.Unlock()
+
+	unregisterCloser(w)
+	w.bg.Wait()
+	return nil
+}
+
+// due reports whether the file must rotate before n more bytes are written
+func (w *RotatingWriter) due(n int) bool {
+	if w.opts.MaxSize > 0 && w.size+int64(n) > w.opts.MaxSize {
+		return true
+	}
+	return w.opts.MaxAge > 0 && time.Since(w.started) >= w.opts.MaxAge
+}
+
+// rotate renames the file aside and hands it to the background for compressing and pruning;
+// the caller holds w.mu
+func (w *RotatingWriter) rotate() error {
+	aside := w.rotatedName()
+	if err := Rename(w.name, aside); err != nil {
+		errorPrinter("RotatingWriter: "+err.Error(), w.name)
+		return err
+	}
+	w.size = 0
+	w.started = time.Now()
+
+	if !w.opts.Compress && w.opts.Keep == 0 {
+		return nil
+	}
+	w.bg.Add(1)
+	go func() {
+		defer w.bg.Done()
+		w.bgMu.Lock()
+		defer w.bgMu.Unlock()
+
+		if w.opts.Compress {
+			if _, err := CompressFile(aside, CompressionGzip); err != nil {
+				warnPrinter("RotatingWriter (compress): "+err.Error(), aside)
+			}
+		}
+		if w.opts.Keep > 0 {
+			w.prune()
+		}
+	}()
+	return nil
+}
+
+// rotatedName returns a name for the current file in the debug log's timeFlat format, numbered
+// past the files already rotated within the same minute so the order of names stays the order
+// of rotation
+func (w *RotatingWriter) rotatedName() string {
+	dir, stem, ext := w.parts()
+	stamp := w.started.Format(timeFlat)
+
+	seq := 0
+	found, _ := w.rotated()
+	for _, r := range found {
+		if r.stamp == stamp {
+			seq = r.seq + 1
+		}
+	}
+
+	name := stem + "." + stamp
+	if seq > 0 {
+		name += "." + strconv.Itoa(seq)
+	}
+	return filepath.Join(dir, name+ext)
+}
+
+// parts splits the file name into its directory, stem and extension
+func (w *RotatingWriter) parts() (string, string, string) {
+	dir, base := filepath.Split(w.name)
+	ext := filepath.Ext(base)
+	if ext == base {
+		ext = "" // Dot files like .log have no extension
+	}
+	return dir, strings.TrimSuffix(base, ext), ext
+}
+
+// Rotated returns the rotated files of the writer, oldest first
+func (w *RotatingWriter) Rotated() ([]string, error) {
+	found, err := w.rotated()
+	if err != nil {
+		return nil, err
+	}
+
+	names := make([]string, len(found))
+	for i, r := range found {
+		names[i] = r.name
+	}
+	return names, nil
+}
+
+type rotatedFile struct {
+	name  string
+	stamp string
+	seq   int
+}
+
+// rotated lists the rotated files, oldest first
+func (w *RotatingWriter) rotated() ([]rotatedFile, error) {
+	dir, stem, ext := w.parts()
+	pattern := regexp.MustCompile("^" + regexp.QuoteMeta(stem) + `\.(\d{8}_\d{4})(?:\.(\d+))?` + regexp.QuoteMeta(ext) + `(?:\.gz)?$`)
+
+	entries, err := os.ReadDir(filepath.Clean(dir))
+	if err != nil {
+		return nil, err
+	}
+
+	var found []rotatedFile
+	for _, e := range entries {
+		m := pattern.FindStringSubmatch(e.Name())
+		if m == nil || !e.Type().IsRegular() {
+			continue
+		}
+		seq, _ := strconv.Atoi(m[2])
+		found = append(found, rotatedFile{name: filepath.Join(dir, e.Name()), stamp: m[1], seq: seq})
+	}
+	sort.Slice(found, func(i, j int) bool {
+		if found[i].stamp != found[j].stamp {
+			return found[i].stamp < found[j].stamp
+		}
+		return found[i].seq < found[j].seq
+	})
+	return found, nil
+}
+
+// prune removes the oldest rotated files beyond Keep
+func (w *RotatingWriter) prune() {
+	names, err := w.Rotated()
+	if err != nil {
+		warnPrinter("RotatingWriter (prune): "+err.Error(), w.name)
+		return
+	}
+	for len(names) > w.opts.Keep {
+		if err := Delete(names[0]); err != nil {
+			warnPrinter("RotatingWriter (prune): "+err.Error(), names[0])
+		}
+		names = names[1:]
+	}
+}
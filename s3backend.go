@@ -0,0 +1,918 @@
+package GMSFS
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// S3Options configures an S3Backend. Empty credentials are taken from AWS_ACCESS_KEY_ID,
+// AWS_SECRET_ACCESS_KEY and AWS_SESSION_TOKEN; without any, requests are sent unsigned.
+type S3Options struct {
+	Endpoint        string // e.g. "http://localhost:9000"; defaults to AWS in Region
+	Region          string // Defaults to AWS_REGION, then "us-east-1"
+	AccessKeyID     string
+	SecretAccessKey string
+	SessionToken    string
+	PathStyle       bool         // Put the bucket in the URL path rather than the host name, as most S3-compatible servers expect
+	Client          *http.Client // Defaults to http.DefaultClient
+
+	// Credentials supplies the keys in place of the three fields above, e.g. IAMCredentials.
+	// The backend caches them and asks again before they expire.
+	Credentials CredentialsProvider
+}
+
+// S3Backend serves S3 buckets as a Backend, signing requests with AWS Signature Version 4.
+// After RegisterBackend("s3", b) the path "s3:bucket/dir/file.txt" is the object
+// "dir/file.txt" in "bucket", and "s3:" lists the buckets.
+//
+// Directories are key prefixes; Mkdir writes an empty "dir/" marker object so empty ones
+// exist too. Files opened for writing are buffered in memory and uploaded on Sync and Close.
+// Rename copies and deletes, so it is not atomic. S3 has no modes or settable mtimes, so
+// Chmod and Chtimes fail with errors.ErrUnsupported.
+//
+// CopyFile between the local filesystem and S3 moves files larger than DefaultS3PartSize
+// with the parallel, resumable transfers of Upload and Download.
+type S3Backend struct {
+	opts   S3Options
+	host   string // Endpoint host, for virtual-hosted style URLs
+	scheme string
+	creds  *credentialCache
+}
+
+// S3Error is an error response from the S3 API
+type S3Error struct {
+	StatusCode int
+	Code       string // e.g. "NoSuchBucket" or "SlowDown"
+	Message    string
+}
+
+func (e *S3Error) Error() string {
+	if e.Code == "" {
+		return "s3: " + http.StatusText(e.StatusCode)
+	}
+	return "s3: " + e.Code + ": " + e.Message
+}
+
+// Timeout reports server-side failures worth retrying, such as SlowDown
+func (e *S3Error) Timeout() bool {
+	return e.StatusCode == http.StatusInternalServerError || e.StatusCode == http.StatusServiceUnavailable
+}
+
+// NewS3Backend checks opts and returns the backend
+func NewS3Backend(opts S3Options) (*S3Backend, error) {
+	if opts.Region == "" {
+		opts.Region = os.Getenv("AWS_REGION")
+	}
+	if opts.Region == "" {
+		opts.Region = "us-east-1"
+	}
+	if opts.AccessKeyID == "" && opts.SecretAccessKey == "" && opts.Credentials == nil {
+		opts.AccessKeyID = os.Getenv("AWS_ACCESS_KEY_ID")
+		opts.SecretAccessKey = os.Getenv("AWS_SECRET_ACCESS_KEY")
+		opts.SessionToken = os.Getenv("AWS_SESSION_TOKEN")
+	}
+	if opts.Credentials == nil {
+		opts.Credentials = StaticCredentials(Credentials{AccessKeyID: opts.AccessKeyID, SecretAccessKey: opts.SecretAccessKey, SessionToken: opts.SessionToken})
+	}
+	if opts.Endpoint == "" {
+		opts.Endpoint = "https://s3." + opts.Region + ".amazonaws.com"
+	}
+	if opts.Client == nil {
+		opts.Client = http.DefaultClient
+	}
+
+	u, err := url.Parse(opts.Endpoint)
+	if err != nil {
+		return nil, fmt.Errorf("NewS3Backend: %w", err)
+	}
+	if u.Scheme != "http" && u.Scheme != "https" || u.Host == "" {
+		return nil, fmt.Errorf("NewS3Backend: endpoint %q is not an http or https URL", opts.Endpoint)
+	}
+
+	return &S3Backend{opts: opts, host: u.Host, scheme: u.Scheme, creds: &credentialCache{provider: opts.Credentials}}, nil
+}
+
+// s3Split turns a backend path into bucket and key
+func s3Split(name string) (string, string) {
+	bucket, key, _ := strings.Cut(strings.TrimPrefix(memPath(name), "/"), "/")
+	return bucket, key
+}
+
+func (b *S3Backend) objectURL(bucket string, key string, query url.Values) string {
+	var u string
+	switch {
+	case bucket == "":
+		u = b.scheme + "://" + b.host + "/"
+	case b.opts.PathStyle:
+		u = b.scheme + "://" + b.host + "/" + bucket + s3EscapePath(key)
+	default:
+		u = b.scheme + "://" + bucket + "." + b.host + s3EscapePath(key)
+	}
+	if len(query) > 0 {
+		u += "?" + s3CanonicalQuery(query)
+	}
+	return u
+}
+
+// do sends a signed request. Error statuses are returned as errors for name, with the
+// response body closed; otherwise the caller closes it.
+func (b *S3Backend) do(op string, name string, method string, bucket string, key string, query url.Values, header http.Header, body []byte) (*http.Response, error) {
+	return b.doContext(context.Background(), op, name, method, bucket, key, query, header, body)
+}
+
+// doContext is do with a request ending when ctx ends. A request refused for expired
+// credentials is sent once more with fresh ones.
+func (b *S3Backend) doContext(ctx context.Context, op string, name string, method string, bucket string, key string, query url.Values, header http.Header, body []byte) (*http.Response, error) {
+	for attempt := 1; ; attempt++ {
+		creds, err := b.creds.get(ctx)
+		if err != nil {
+			return nil, &os.PathError{Op: op, Path: name, Err: fmt.Errorf("credentials: %w", err)}
+		}
+
+		req, err := http.NewRequestWithContext(ctx, method, b.objectURL(bucket, key, query), bytes.NewReader(body))
+		if err != nil {
+			return nil, &os.PathError{Op: op, Path: name, Err: err}
+		}
+		for k, v := range header {
+			req.Header[k] = v
+		}
+		b.sign(req, body, creds, time.Now())
+
+		resp, err := b.opts.Client.Do(req)
+		if err != nil {
+			return nil, &os.PathError{Op: op, Path: name, Err: err}
+		}
+		if resp.StatusCode < 300 {
+			return resp, nil
+		}
+
+		data, _ := io.ReadAll(io.LimitReader(resp.Body, 64<<10))
+		resp.Body.Close()
+		resp.Body = io.NopCloser(bytes.NewReader(data))
+		if attempt == 1 && s3ExpiredCredentials(data) {
+			b.creds.expire()
+			continue
+		}
+		return nil, &os.PathError{Op: op, Path: name, Err: s3ResponseError(resp)}
+	}
+}
+
+// s3ExpiredCredentials reports whether an error response body rejects the credentials as expired
+func s3ExpiredCredentials(data []byte) bool {
+	var body s3Error
+	if xml.Unmarshal(data, &body) != nil {
+		return false
+	}
+	switch body.Code {
+	case "ExpiredToken", "ExpiredTokenException", "TokenRefreshRequired":
+		return true
+	}
+	return false
+}
+
+// s3ResponseError maps an error response to the error the os package would return
+func s3ResponseError(resp *http.Response) error {
+	switch resp.StatusCode {
+	case http.StatusNotFound:
+		return os.ErrNotExist
+	case http.StatusForbidden:
+		return os.ErrPermission
+	case http.StatusRequestedRangeNotSatisfiable:
+		return io.EOF
+	}
+
+	e := &S3Error{StatusCode: resp.StatusCode}
+	var body s3Error
+	if data, _ := io.ReadAll(io.LimitReader(resp.Body, 64<<10)); xml.Unmarshal(data, &body) == nil {
+		e.Code, e.Message = body.Code, body.Message
+	}
+	return e
+}
+
+// sign adds the AWS Signature Version 4 headers for body to req
+func (b *S3Backend) sign(req *http.Request, body []byte, creds Credentials, now time.Time) {
+	if creds.AccessKeyID == "" {
+		return
+	}
+
+	sum := sha256.Sum256(body)
+	payloadHash := hex.EncodeToString(sum[:])
+	amzDate := now.UTC().Format("20060102T150405Z")
+	date := amzDate[:8]
+
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+	if creds.SessionToken != "" {
+		req.Header.Set("X-Amz-Security-Token", creds.SessionToken)
+	}
+
+	headers := map[string]string{"host": req.URL.Host}
+	for k, v := range req.Header {
+		k = strings.ToLower(k)
+		if strings.HasPrefix(k, "x-amz-") || k == "content-type" || k == "content-md5" || k == "range" {
+			headers[k] = strings.TrimSpace(strings.Join(v, ","))
+		}
+	}
+	names := make([]string, 0, len(headers))
+	for k := range headers {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+
+	var canonicalHeaders strings.Builder
+	for _, k := range names {
+		canonicalHeaders.WriteString(k + ":" + headers[k] + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		s3EscapePath(strings.TrimPrefix(req.URL.Path, "/")),
+		s3CanonicalQuery(req.URL.Query()),
+		canonicalHeaders.String(),
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+
+	scope := date + "/" + b.opts.Region + "/s3/aws4_request"
+	crHash := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(crHash[:])
+
+	key := []byte("AWS4" + creds.SecretAccessKey)
+	for _, part := range []string{date, b.opts.Region, "s3", "aws4_request"} {
+		key = hmacSHA256(key, part)
+	}
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	req.Header.Set("Authorization", "AWS4-HMAC-SHA256 Credential="+creds.AccessKeyID+"/"+scope+
+		", SignedHeaders="+signedHeaders+", Signature="+signature)
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
+
+// s3EscapePath encodes a key the way SigV4 expects: everything but unreserved characters
+// and slashes, with a leading slash
+func s3EscapePath(key string) string {
+	return "/" + s3Escape(key, false)
+}
+
+// s3Escape percent-encodes everything but RFC 3986 unreserved characters, and slashes unless
+// encodeSlash is set
+func s3Escape(s string, encodeSlash bool) string {
+	var sb strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case c >= 'A' && c <= 'Z', c >= 'a' && c <= 'z', c >= '0' && c <= '9',
+			c == '-', c == '_', c == '.', c == '~', c == '/' && !encodeSlash:
+			sb.WriteByte(c)
+		default:
+			fmt.Fprintf(&sb, "%%%02X", c)
+		}
+	}
+	return sb.String()
+}
+
+// s3CanonicalQuery sorts and encodes query parameters
+func s3CanonicalQuery(query url.Values) string {
+	keys := make([]string, 0, len(query))
+	for k := range query {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	var parts []string
+	for _, k := range keys {
+		values := append([]string(nil), query[k]...)
+		sort.Strings(values)
+		for _, v := range values {
+			parts = append(parts, s3Escape(k, true)+"="+s3Escape(v, true))
+		}
+	}
+	return strings.Join(parts, "&")
+}
+
+// list calls fn for every page of objects and common prefixes below prefix
+func (b *S3Backend) list(name string, bucket string, prefix string, delimiter string, maxKeys int, fn func(*s3ListBucketResult) bool) error {
+	token := ""
+	for {
+		query := url.Values{"list-type": {"2"}, "prefix": {prefix}}
+		if delimiter != "" {
+			query.Set("delimiter", delimiter)
+		}
+		if maxKeys > 0 {
+			query.Set("max-keys", strconv.Itoa(maxKeys))
+		}
+		if token != "" {
+			query.Set("continuation-token", token)
+		}
+
+		resp, err := b.do("readdir", name, http.MethodGet, bucket, "", query, nil, nil)
+		if err != nil {
+			return err
+		}
+		var page s3ListBucketResult
+		err = xml.NewDecoder(resp.Body).Decode(&page)
+		resp.Body.Close()
+		if err != nil {
+			return &os.PathError{Op: "readdir", Path: name, Err: err}
+		}
+
+		if !fn(&page) || !page.IsTruncated || page.NextContinuationToken == "" {
+			return nil
+		}
+		token = page.NextContinuationToken
+	}
+}
+
+// hasPrefix reports whether any object lies below the directory key
+func (b *S3Backend) hasPrefix(name string, bucket string, key string) (bool, error) {
+	found := false
+	err := b.list(name, bucket, key+"/", "", 1, func(page *s3ListBucketResult) bool {
+		found = len(page.Contents) > 0
+		return false
+	})
+	return found, err
+}
+
+// head returns the object's info, or nil when there is no such object
+func (b *S3Backend) head(name string, bucket string, key string) (os.FileInfo, error) {
+	resp, err := b.do("stat", name, http.MethodHead, bucket, key, nil, nil, nil)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	resp.Body.Close()
+
+	modTime, _ := http.ParseTime(resp.Header.Get("Last-Modified"))
+	return memFileInfo{name: path.Base(key), size: resp.ContentLength, mode: 0644, modTime: modTime}, nil
+}
+
+func (b *S3Backend) Stat(name string) (os.FileInfo, error) {
+	bucket, key := s3Split(name)
+	switch {
+	case bucket == "":
+		return memFileInfo{name: "/", mode: os.ModeDir | 0755}, nil
+
+	case key == "":
+		resp, err := b.do("stat", name, http.MethodHead, bucket, "", nil, nil, nil)
+		if err != nil {
+			return nil, err
+		}
+		resp.Body.Close()
+		return memFileInfo{name: bucket, mode: os.ModeDir | 0755}, nil
+	}
+
+	info, err := b.head(name, bucket, key)
+	if err != nil || info != nil {
+		return info, err
+	}
+
+	// No object, but a directory marker or objects below the prefix make a directory
+	marker, err := b.head(name, bucket, key+"/")
+	if err != nil {
+		return nil, err
+	}
+	if marker != nil {
+		return memFileInfo{name: path.Base(key), mode: os.ModeDir | 0755, modTime: marker.ModTime()}, nil
+	}
+	found, err := b.hasPrefix(name, bucket, key)
+	if err != nil {
+		return nil, err
+	}
+	if found {
+		return memFileInfo{name: path.Base(key), mode: os.ModeDir | 0755}, nil
+	}
+	return nil, &os.PathError{Op: "stat", Path: name, Err: os.ErrNotExist}
+}
+
+func (b *S3Backend) Lstat(name string) (os.FileInfo, error) {
+	return b.Stat(name)
+}
+
+func (b *S3Backend) ReadDir(name string) ([]os.DirEntry, error) {
+	bucket, key := s3Split(name)
+	if bucket == "" {
+		return b.listBuckets(name)
+	}
+
+	prefix := ""
+	if key != "" {
+		prefix = key + "/"
+	}
+
+	var entries []os.DirEntry
+	var marker bool
+	err := b.list(name, bucket, prefix, "/", 0, func(page *s3ListBucketResult) bool {
+		for _, p := range page.CommonPrefixes {
+			dir := strings.TrimSuffix(strings.TrimPrefix(p.Prefix, prefix), "/")
+			if dir != "" {
+				entries = append(entries, fs.FileInfoToDirEntry(memFileInfo{name: dir, mode: os.ModeDir | 0755}))
+			}
+		}
+		for _, o := range page.Contents {
+			if o.Key == prefix {
+				marker = true
+				continue
+			}
+			modTime, _ := time.Parse(time.RFC3339, o.LastModified)
+			entries = append(entries, fs.FileInfoToDirEntry(memFileInfo{name: strings.TrimPrefix(o.Key, prefix), size: o.Size, mode: 0644, modTime: modTime}))
+		}
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if len(entries) == 0 && !marker && key != "" {
+		// An empty listing is a missing directory unless it is a file
+		if info, err := b.head(name, bucket, key); err == nil && info != nil {
+			return nil, &os.PathError{Op: "readdir", Path: name, Err: syscall.ENOTDIR}
+		}
+		return nil, &os.PathError{Op: "readdir", Path: name, Err: os.ErrNotExist}
+	}
+
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Name() < entries[j].Name() })
+	return entries, nil
+}
+
+func (b *S3Backend) listBuckets(name string) ([]os.DirEntry, error) {
+	resp, err := b.do("readdir", name, http.MethodGet, "", "", nil, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var result s3ListAllMyBucketsResult
+	if err := xml.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return nil, &os.PathError{Op: "readdir", Path: name, Err: err}
+	}
+
+	entries := make([]os.DirEntry, 0, len(result.Buckets))
+	for _, bucket := range result.Buckets {
+		created, _ := time.Parse(time.RFC3339, bucket.CreationDate)
+		entries = append(entries, fs.FileInfoToDirEntry(memFileInfo{name: bucket.Name, mode: os.ModeDir | 0755, modTime: created}))
+	}
+	return entries, nil
+}
+
+func (b *S3Backend) Mkdir(name string, perm os.FileMode) error {
+	bucket, key := s3Split(name)
+	if key == "" {
+		return &os.PathError{Op: "mkdir", Path: name, Err: os.ErrExist}
+	}
+	if _, err := b.Stat(name); err == nil {
+		return &os.PathError{Op: "mkdir", Path: name, Err: os.ErrExist}
+	}
+	return b.put("mkdir", name, bucket, key+"/", nil)
+}
+
+func (b *S3Backend) MkdirAll(name string, perm os.FileMode) error {
+	bucket, key := s3Split(name)
+	if key == "" {
+		return nil
+	}
+	info, err := b.Stat(name)
+	if err == nil {
+		if !info.IsDir() {
+			return &os.PathError{Op: "mkdir", Path: name, Err: syscall.ENOTDIR}
+		}
+		return nil
+	}
+	return b.put("mkdir", name, bucket, key+"/", nil)
+}
+
+func (b *S3Backend) put(op string, name string, bucket string, key string, content []byte) error {
+	resp, err := b.do(op, name, http.MethodPut, bucket, key, nil, nil, content)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+func (b *S3Backend) delete(name string, bucket string, key string) error {
+	resp, err := b.do("remove", name, http.MethodDelete, bucket, key, nil, nil, nil)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+func (b *S3Backend) Remove(name string) error {
+	bucket, key := s3Split(name)
+	if key == "" {
+		return &os.PathError{Op: "remove", Path: name, Err: os.ErrPermission}
+	}
+
+	info, err := b.Stat(name)
+	if err != nil {
+		return err
+	}
+	if !info.IsDir() {
+		return b.delete(name, bucket, key)
+	}
+
+	empty := true
+	err = b.list(name, bucket, key+"/", "", 2, func(page *s3ListBucketResult) bool {
+		for _, o := range page.Contents {
+			if o.Key != key+"/" {
+				empty = false
+			}
+		}
+		return false
+	})
+	if err != nil {
+		return err
+	}
+	if !empty {
+		return &os.PathError{Op: "remove", Path: name, Err: syscall.ENOTEMPTY}
+	}
+	return b.delete(name, bucket, key+"/")
+}
+
+func (b *S3Backend) RemoveAll(name string) error {
+	bucket, key := s3Split(name)
+	if key == "" {
+		return &os.PathError{Op: "remove", Path: name, Err: os.ErrPermission}
+	}
+
+	keys, err := b.keysBelow(name, bucket, key)
+	if err != nil {
+		return err
+	}
+	for _, k := range keys {
+		if err := b.delete(name, bucket, k); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// keysBelow lists the object key itself, if it exists, and every object under key/
+func (b *S3Backend) keysBelow(name string, bucket string, key string) ([]string, error) {
+	var keys []string
+	if info, err := b.head(name, bucket, key); err != nil {
+		return nil, err
+	} else if info != nil {
+		keys = append(keys, key)
+	}
+
+	err := b.list(name, bucket, key+"/", "", 0, func(page *s3ListBucketResult) bool {
+		for _, o := range page.Contents {
+			keys = append(keys, o.Key)
+		}
+		return true
+	})
+	return keys, err
+}
+
+// Rename copies every object of oldName to newName, then deletes the originals
+func (b *S3Backend) Rename(oldName string, newName string) error {
+	oldBucket, oldKey := s3Split(oldName)
+	newBucket, newKey := s3Split(newName)
+	linkErr := func(err error) error {
+		var pe *os.PathError
+		if errors.As(err, &pe) {
+			err = pe.Err
+		}
+		return &os.LinkError{Op: "rename", Old: oldName, New: newName, Err: err}
+	}
+	if oldKey == "" || newKey == "" {
+		return linkErr(os.ErrPermission)
+	}
+	if oldBucket == newBucket && strings.HasPrefix(newKey, oldKey+"/") {
+		return linkErr(syscall.EINVAL)
+	}
+
+	keys, err := b.keysBelow(oldName, oldBucket, oldKey)
+	if err != nil {
+		return linkErr(err)
+	}
+	if len(keys) == 0 {
+		return linkErr(os.ErrNotExist)
+	}
+
+	for _, k := range keys {
+		header := http.Header{"X-Amz-Copy-Source": {"/" + oldBucket + "/" + s3Escape(k, false)}}
+		resp, err := b.do("rename", oldName, http.MethodPut, newBucket, newKey+strings.TrimPrefix(k, oldKey), nil, header, nil)
+		if err != nil {
+			return linkErr(err)
+		}
+		resp.Body.Close()
+	}
+	for _, k := range keys {
+		if err := b.delete(oldName, oldBucket, k); err != nil {
+			return linkErr(err)
+		}
+	}
+	return nil
+}
+
+func (b *S3Backend) Chmod(name string, mode os.FileMode) error {
+	return &os.PathError{Op: "chmod", Path: name, Err: errors.ErrUnsupported}
+}
+
+func (b *S3Backend) Chtimes(name string, atime time.Time, mtime time.Time) error {
+	return &os.PathError{Op: "chtimes", Path: name, Err: errors.ErrUnsupported}
+}
+
+// ReadFile lets readFile fetch the object in one request
+func (b *S3Backend) ReadFile(name string) ([]byte, error) {
+	bucket, key := s3Split(name)
+	if key == "" {
+		return nil, &os.PathError{Op: "read", Path: name, Err: syscall.EISDIR}
+	}
+
+	resp, err := b.do("open", name, http.MethodGet, bucket, key, nil, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	content, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, &os.PathError{Op: "read", Path: name, Err: err}
+	}
+	return content, nil
+}
+
+func (b *S3Backend) Open(name string) (File, error) {
+	return b.OpenFile(name, os.O_RDONLY, 0)
+}
+
+func (b *S3Backend) Create(name string) (File, error) {
+	return b.OpenFile(name, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0666)
+}
+
+// OpenFile streams objects opened read-only with ranged GETs. Other modes buffer the whole
+// object in memory and upload it on Sync and Close.
+func (b *S3Backend) OpenFile(name string, flag int, perm os.FileMode) (File, error) {
+	bucket, key := s3Split(name)
+	access := flag & (os.O_RDONLY | os.O_WRONLY | os.O_RDWR)
+
+	info, err := b.Stat(name)
+	exists := err == nil
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+	switch {
+	case exists && flag&os.O_CREATE != 0 && flag&os.O_EXCL != 0:
+		return nil, &os.PathError{Op: "open", Path: name, Err: os.ErrExist}
+	case exists && info.IsDir() && access != os.O_RDONLY:
+		return nil, &os.PathError{Op: "open", Path: name, Err: syscall.EISDIR}
+	case !exists && (flag&os.O_CREATE == 0 || access == os.O_RDONLY):
+		return nil, &os.PathError{Op: "open", Path: name, Err: os.ErrNotExist}
+	}
+
+	if access == os.O_RDONLY {
+		return &s3Reader{b: b, name: name, bucket: bucket, key: key, info: info}, nil
+	}
+
+	f := &s3Writer{b: b, name: name, bucket: bucket, key: key, read: access == os.O_RDWR, append: flag&os.O_APPEND != 0}
+	switch {
+	case !exists || flag&os.O_TRUNC != 0:
+		f.dirty = true // Create or empty the object even if nothing is written
+	default:
+		if f.data, err = b.ReadFile(name); err != nil {
+			return nil, err
+		}
+	}
+	return f, nil
+}
+
+// s3Reader reads an object with ranged GETs, reopening the stream after a Seek
+type s3Reader struct {
+	b      *S3Backend
+	name   string
+	bucket string
+	key    string
+	info   os.FileInfo
+
+	mu     sync.Mutex
+	body   io.ReadCloser
+	offset int64
+	closed bool
+}
+
+func (f *s3Reader) Read(p []byte) (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.closed {
+		return 0, &os.PathError{Op: "read", Path: f.name, Err: os.ErrClosed}
+	}
+	if f.info.IsDir() {
+		return 0, &os.PathError{Op: "read", Path: f.name, Err: syscall.EISDIR}
+	}
+	if f.offset >= f.info.Size() {
+		return 0, io.EOF
+	}
+
+	if f.body == nil {
+		header := http.Header{"Range": {"bytes=" + strconv.FormatInt(f.offset, 10) + "-"}}
+		resp, err := f.b.do("read", f.name, http.MethodGet, f.bucket, f.key, nil, header, nil)
+		if err != nil {
+			return 0, err
+		}
+		f.body = resp.Body
+	}
+
+	n, err := f.body.Read(p)
+	f.offset += int64(n)
+	if err != nil && err != io.EOF {
+		err = &os.PathError{Op: "read", Path: f.name, Err: err}
+	}
+	return n, err
+}
+
+func (f *s3Reader) Write(p []byte) (int, error) {
+	return 0, &os.PathError{Op: "write", Path: f.name, Err: os.ErrPermission}
+}
+
+func (f *s3Reader) Seek(offset int64, whence int) (int64, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	switch whence {
+	case io.SeekCurrent:
+		offset += f.offset
+	case io.SeekEnd:
+		offset += f.info.Size()
+	case io.SeekStart:
+	default:
+		return 0, &os.PathError{Op: "seek", Path: f.name, Err: syscall.EINVAL}
+	}
+	if offset < 0 {
+		return 0, &os.PathError{Op: "seek", Path: f.name, Err: syscall.EINVAL}
+	}
+
+	if offset != f.offset && f.body != nil {
+		f.body.Close()
+		f.body = nil
+	}
+	f.offset = offset
+	return offset, nil
+}
+
+func (f *s3Reader) Stat() (os.FileInfo, error) { return f.info, nil }
+
+func (f *s3Reader) Sync() error { return nil }
+
+func (f *s3Reader) Close() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.closed {
+		return &os.PathError{Op: "close", Path: f.name, Err: os.ErrClosed}
+	}
+	f.closed = true
+	if f.body != nil {
+		return f.body.Close()
+	}
+	return nil
+}
+
+// s3Writer holds an object in memory until it is uploaded by Sync or Close
+type s3Writer struct {
+	b      *S3Backend
+	name   string
+	bucket string
+	key    string
+	read   bool
+	append bool
+
+	mu      sync.Mutex
+	data    []byte
+	offset  int64
+	dirty   bool
+	modTime time.Time
+	closed  bool
+}
+
+func (f *s3Writer) Read(p []byte) (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.closed {
+		return 0, &os.PathError{Op: "read", Path: f.name, Err: os.ErrClosed}
+	}
+	if !f.read {
+		return 0, &os.PathError{Op: "read", Path: f.name, Err: os.ErrPermission}
+	}
+	if f.offset >= int64(len(f.data)) {
+		return 0, io.EOF
+	}
+	n := copy(p, f.data[f.offset:])
+	f.offset += int64(n)
+	return n, nil
+}
+
+func (f *s3Writer) Write(p []byte) (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.closed {
+		return 0, &os.PathError{Op: "write", Path: f.name, Err: os.ErrClosed}
+	}
+	if f.append {
+		f.offset = int64(len(f.data))
+	}
+	if end := f.offset + int64(len(p)); end > int64(len(f.data)) {
+		f.data = append(f.data, make([]byte, end-int64(len(f.data)))...)
+	}
+	copy(f.data[f.offset:], p)
+	f.offset += int64(len(p))
+	f.dirty = true
+	f.modTime = time.Now()
+	return len(p), nil
+}
+
+func (f *s3Writer) Seek(offset int64, whence int) (int64, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	switch whence {
+	case io.SeekCurrent:
+		offset += f.offset
+	case io.SeekEnd:
+		offset += int64(len(f.data))
+	case io.SeekStart:
+	default:
+		return 0, &os.PathError{Op: "seek", Path: f.name, Err: syscall.EINVAL}
+	}
+	if offset < 0 {
+		return 0, &os.PathError{Op: "seek", Path: f.name, Err: syscall.EINVAL}
+	}
+	f.offset = offset
+	return offset, nil
+}
+
+func (f *s3Writer) Stat() (os.FileInfo, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return memFileInfo{name: path.Base(f.key), size: int64(len(f.data)), mode: 0644, modTime: f.modTime}, nil
+}
+
+// Sync uploads the object if it changed since the last upload
+func (f *s3Writer) Sync() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.closed {
+		return &os.PathError{Op: "sync", Path: f.name, Err: os.ErrClosed}
+	}
+	return f.upload()
+}
+
+func (f *s3Writer) Close() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.closed {
+		return &os.PathError{Op: "close", Path: f.name, Err: os.ErrClosed}
+	}
+	f.closed = true
+	return f.upload()
+}
+
+func (f *s3Writer) upload() error {
+	if !f.dirty {
+		return nil
+	}
+	data := f.data
+	if data == nil {
+		data = []byte{}
+	}
+	if err := f.b.put("write", f.name, f.bucket, f.key, data); err != nil {
+		return err
+	}
+	f.dirty = false
+	return nil
+}
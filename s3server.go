@@ -0,0 +1,354 @@
+package GMSFS
+
+import (
+	"encoding/base64"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"net/http"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const s3Namespace = "http://s3.amazonaws.com/doc/2006-03-01/"
+
+// S3Server exposes a local directory as a single read-only bucket over a path-style
+// subset of the S3 REST API (ListBuckets, ListObjects, HeadBucket, GetObject, HeadObject).
+// Request signatures are not verified, so put it behind your own authentication. Objects
+// reached through symlinks are served as long as the links stay below Root; listings leave
+// linked directories out.
+type S3Server struct {
+	Root   string
+	Bucket string
+}
+
+// NewS3Server serves root as the bucket named bucket
+func NewS3Server(root string, bucket string) *S3Server {
+	return &S3Server{Root: cleanPath(root), Bucket: bucket}
+}
+
+type s3Owner struct {
+	ID          string
+	DisplayName string
+}
+
+type s3Bucket struct {
+	Name         string
+	CreationDate string
+}
+
+type s3ListAllMyBucketsResult struct {
+	XMLName xml.Name   `xml:"ListAllMyBucketsResult"`
+	Xmlns   string     `xml:"xmlns,attr"`
+	Owner   s3Owner    `xml:"Owner"`
+	Buckets []s3Bucket `xml:"Buckets>Bucket"`
+}
+
+type s3Object struct {
+	Key          string
+	LastModified string
+	ETag         string
+	Size         int64
+	StorageClass string
+}
+
+type s3CommonPrefix struct {
+	Prefix string
+}
+
+type s3ListBucketResult struct {
+	XMLName               xml.Name         `xml:"ListBucketResult"`
+	Xmlns                 string           `xml:"xmlns,attr"`
+	Name                  string           `xml:"Name"`
+	Prefix                string           `xml:"Prefix"`
+	Delimiter             string           `xml:"Delimiter,omitempty"`
+	MaxKeys               int              `xml:"MaxKeys"`
+	KeyCount              int              `xml:"KeyCount,omitempty"`
+	IsTruncated           bool             `xml:"IsTruncated"`
+	Marker                string           `xml:"Marker,omitempty"`
+	NextMarker            string           `xml:"NextMarker,omitempty"`
+	ContinuationToken     string           `xml:"ContinuationToken,omitempty"`
+	NextContinuationToken string           `xml:"NextContinuationToken,omitempty"`
+	StartAfter            string           `xml:"StartAfter,omitempty"`
+	Contents              []s3Object       `xml:"Contents"`
+	CommonPrefixes        []s3CommonPrefix `xml:"CommonPrefixes"`
+}
+
+type s3Error struct {
+	XMLName  xml.Name `xml:"Error"`
+	Code     string   `xml:"Code"`
+	Message  string   `xml:"Message"`
+	Resource string   `xml:"Resource"`
+}
+
+// s3Entry is one listing result: either an object or a common prefix
+type s3Entry struct {
+	key    string
+	prefix bool
+	info   FileInfo
+}
+
+func (s *S3Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		s3WriteError(w, r, http.StatusMethodNotAllowed, "MethodNotAllowed", "this server is read-only")
+		return
+	}
+
+	bucket, key, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/"), "/")
+	switch {
+	case bucket == "":
+		s.listBuckets(w, r)
+	case bucket != s.Bucket:
+		s3WriteError(w, r, http.StatusNotFound, "NoSuchBucket", "the specified bucket does not exist")
+	case key == "" && r.Method == http.MethodHead:
+		w.WriteHeader(http.StatusOK)
+	case key == "":
+		s.listObjects(w, r)
+	default:
+		s.getObject(w, r, key)
+	}
+}
+
+func (s *S3Server) listBuckets(w http.ResponseWriter, r *http.Request) {
+	created := time.Now()
+	if info, err := Stat(s.Root); err == nil {
+		created = info.LastModified
+	}
+
+	s3WriteXML(w, s3ListAllMyBucketsResult{
+		Xmlns:   s3Namespace,
+		Owner:   s3Owner{ID: "gmsfs", DisplayName: "gmsfs"},
+		Buckets: []s3Bucket{{Name: s.Bucket, CreationDate: created.UTC().Format(time.RFC3339)}},
+	})
+}
+
+func (s *S3Server) listObjects(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	prefix := q.Get("prefix")
+	delimiter := q.Get("delimiter")
+	v2 := q.Get("list-type") == "2"
+
+	maxKeys := 1000
+	if mk := q.Get("max-keys"); mk != "" {
+		n, err := strconv.Atoi(mk)
+		if err != nil || n < 0 {
+			s3WriteError(w, r, http.StatusBadRequest, "InvalidArgument", "invalid max-keys")
+			return
+		}
+		maxKeys = min(n, 1000)
+	}
+
+	after := q.Get("marker")
+	if v2 {
+		after = q.Get("start-after")
+		if token := q.Get("continuation-token"); token != "" {
+			raw, err := base64.StdEncoding.DecodeString(token)
+			if err != nil {
+				s3WriteError(w, r, http.StatusBadRequest, "InvalidArgument", "invalid continuation token")
+				return
+			}
+			after = string(raw)
+		}
+	}
+
+	// Nothing to return, and no key to continue after
+	var entries []s3Entry
+	if maxKeys > 0 {
+		var err error
+		entries, err = s.collect(prefix, delimiter)
+		if err != nil {
+			errorPrinter("S3Server (collect): "+err.Error(), prefix)
+			s3WriteError(w, r, http.StatusInternalServerError, "InternalError", err.Error())
+			return
+		}
+	}
+
+	result := s3ListBucketResult{
+		Xmlns:     s3Namespace,
+		Name:      s.Bucket,
+		Prefix:    prefix,
+		Delimiter: delimiter,
+		MaxKeys:   maxKeys,
+	}
+	if v2 {
+		result.ContinuationToken = q.Get("continuation-token")
+		result.StartAfter = q.Get("start-after")
+	} else {
+		result.Marker = q.Get("marker")
+	}
+
+	count := 0
+	last := ""
+	for _, e := range entries {
+		if e.key <= after {
+			continue
+		}
+		if count == maxKeys {
+			result.IsTruncated = true
+			break
+		}
+		if e.prefix {
+			result.CommonPrefixes = append(result.CommonPrefixes, s3CommonPrefix{Prefix: e.key})
+		} else {
+			result.Contents = append(result.Contents, s3Object{
+				Key:          e.key,
+				LastModified: e.info.LastModified.UTC().Format(time.RFC3339),
+				ETag:         fileETag(e.info),
+				Size:         e.info.Size,
+				StorageClass: "STANDARD",
+			})
+		}
+		last = e.key
+		count++
+	}
+
+	if result.IsTruncated {
+		if v2 {
+			result.NextContinuationToken = base64.StdEncoding.EncodeToString([]byte(last))
+		} else {
+			result.NextMarker = last
+		}
+	}
+	if v2 {
+		result.KeyCount = count
+	}
+
+	s3WriteXML(w, result)
+}
+
+// collect lists the keys matching prefix, folding them into common prefixes at delimiter
+func (s *S3Server) collect(prefix string, delimiter string) ([]s3Entry, error) {
+	// Only the directory holding the prefix can contain matching keys
+	baseDir := ""
+	if i := strings.LastIndex(prefix, "/"); i >= 0 {
+		baseDir = prefix[:i+1]
+	}
+
+	dir, err := s.resolve(baseDir)
+	if errors.Is(err, ErrPathEscapes) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var entries []s3Entry
+	seen := map[string]bool{}
+	var walk func(dir string, keyPrefix string) error
+	walk = func(dir string, keyPrefix string) error {
+		infos, err := ReadDir(dir)
+		if err != nil {
+			return err
+		}
+
+		for _, info := range infos {
+			key := keyPrefix + info.Name
+			if info.IsDir {
+				key += "/"
+			}
+			// Skip subtrees that can never match the prefix
+			if !strings.HasPrefix(key, prefix) && !strings.HasPrefix(prefix, key) {
+				continue
+			}
+
+			if delimiter != "" && strings.HasPrefix(key, prefix) {
+				if i := strings.Index(key[len(prefix):], delimiter); i >= 0 {
+					common := key[:len(prefix)+i+len(delimiter)]
+					if !seen[common] {
+						seen[common] = true
+						entries = append(entries, s3Entry{key: common, prefix: true})
+					}
+					continue
+				}
+			}
+
+			if info.IsDir {
+				if err := walk(filepath.Join(dir, info.Name), key); err != nil {
+					return err
+				}
+				continue
+			}
+			if info.Mode.IsRegular() && strings.HasPrefix(key, prefix) {
+				entries = append(entries, s3Entry{key: key, info: info})
+			}
+		}
+		return nil
+	}
+
+	if err := walk(dir, baseDir); err != nil {
+		if FileExists(dir) {
+			return nil, err
+		}
+		return nil, nil
+	}
+
+	sort.Slice(entries, func(i, j int) bool { return entries[i].key < entries[j].key })
+	return entries, nil
+}
+
+// resolve maps a key onto the root, following symlinks only as long as they stay inside it
+func (s *S3Server) resolve(key string) (string, error) {
+	root, err := NewRoot(s.Root)
+	if err != nil {
+		return "", err
+	}
+	return root.Path(key)
+}
+
+func (s *S3Server) getObject(w http.ResponseWriter, r *http.Request, key string) {
+	name, err := s.resolve(key)
+	if err != nil || strings.HasSuffix(key, "/") {
+		s3WriteError(w, r, http.StatusNotFound, "NoSuchKey", "the specified key does not exist")
+		return
+	}
+
+	info, err := Stat(name)
+	if err != nil || !info.Mode.IsRegular() {
+		s3WriteError(w, r, http.StatusNotFound, "NoSuchKey", "the specified key does not exist")
+		return
+	}
+
+	file, err := Open(name)
+	if err != nil {
+		s3WriteError(w, r, http.StatusInternalServerError, "InternalError", err.Error())
+		return
+	}
+	defer file.Close()
+
+	w.Header().Set("ETag", fileETag(info))
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("Accept-Ranges", "bytes")
+	http.ServeContent(w, r, info.Name, info.LastModified, file)
+}
+
+// fileETag derives a strong validator from size and modification time without reading the file
+func fileETag(info FileInfo) string {
+	return fmt.Sprintf("\"%x-%x\"", info.LastModified.UnixNano(), info.Size)
+}
+
+func s3WriteXML(w http.ResponseWriter, v any) {
+	data, err := xml.Marshal(v)
+	if err != nil {
+		errorPrinter("S3Server (xml.Marshal): "+err.Error(), "")
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/xml")
+	w.Write([]byte(xml.Header))
+	w.Write(data)
+}
+
+func s3WriteError(w http.ResponseWriter, r *http.Request, status int, code string, message string) {
+	data, _ := xml.Marshal(s3Error{Code: code, Message: message, Resource: r.URL.Path})
+
+	w.Header().Set("Content-Type", "application/xml")
+	w.WriteHeader(status)
+	if r.Method != http.MethodHead {
+		w.Write([]byte(xml.Header))
+		w.Write(data)
+	}
+}
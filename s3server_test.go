@@ -0,0 +1,209 @@
+package GMSFS
+
+import (
+	"encoding/xml"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// newTestS3Server serves a directory holding a few objects as the bucket "b"; outside is a
+// directory next to it the server must not reach
+func newTestS3Server(t *testing.T) (srv *httptest.Server, root string, outside string) {
+	t.Helper()
+	base := t.TempDir()
+	root = filepath.Join(base, "root")
+	outside = filepath.Join(base, "outside")
+	writeTestTree(t, root, map[string]string{"a.txt": "alpha", "logs/1.log": "one", "logs/2.log": "two", "logs/old/3.log": "three"})
+	writeTestTree(t, outside, map[string]string{"secret.txt": "secret"})
+
+	srv = httptest.NewServer(NewS3Server(root, "b"))
+	t.Cleanup(srv.Close)
+	return srv, root, outside
+}
+
+// s3Get requests path from srv and returns the status and body
+func s3Get(t *testing.T, srv *httptest.Server, method string, path string) (int, string) {
+	t.Helper()
+	req, err := http.NewRequest(method, srv.URL+path, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return resp.StatusCode, string(body)
+}
+
+// s3List lists the bucket with query and decodes the result
+func s3List(t *testing.T, srv *httptest.Server, query string) s3ListBucketResult {
+	t.Helper()
+	status, body := s3Get(t, srv, http.MethodGet, "/b?"+query)
+	if status != http.StatusOK {
+		t.Fatalf("list %q: status %d: %s", query, status, body)
+	}
+	var result s3ListBucketResult
+	if err := xml.Unmarshal([]byte(body), &result); err != nil {
+		t.Fatal(err)
+	}
+	return result
+}
+
+func listedKeys(result s3ListBucketResult) string {
+	var keys []string
+	for _, o := range result.Contents {
+		keys = append(keys, o.Key)
+	}
+	for _, p := range result.CommonPrefixes {
+		keys = append(keys, p.Prefix)
+	}
+	return strings.Join(keys, ",")
+}
+
+func TestS3ListObjects(t *testing.T) {
+	srv, _, _ := newTestS3Server(t)
+
+	tests := []struct {
+		query string
+		want  string
+	}{
+		{"", "a.txt,logs/1.log,logs/2.log,logs/old/3.log"},
+		{"delimiter=/", "a.txt,logs/"},
+		{"prefix=logs/&delimiter=/", "logs/1.log,logs/2.log,logs/old/"},
+		{"prefix=logs/1", "logs/1.log"},
+		{"prefix=nothing/", ""},
+		{"prefix=../", ""},
+		{"marker=logs/1.log", "logs/2.log,logs/old/3.log"},
+	}
+	for _, tt := range tests {
+		if got := listedKeys(s3List(t, srv, tt.query)); got != tt.want {
+			t.Errorf("list %q = %s, want %s", tt.query, got, tt.want)
+		}
+	}
+}
+
+func TestS3ListPages(t *testing.T) {
+	srv, _, _ := newTestS3Server(t)
+
+	var keys []string
+	query := "list-type=2&max-keys=3"
+	for {
+		result := s3List(t, srv, query)
+		keys = append(keys, listedKeys(result))
+		if !result.IsTruncated {
+			break
+		}
+		if result.NextContinuationToken == "" {
+			t.Fatal("truncated page without a continuation token")
+		}
+		query = "list-type=2&max-keys=3&continuation-token=" + result.NextContinuationToken
+	}
+	if got := strings.Join(keys, "|"); got != "a.txt,logs/1.log,logs/2.log|logs/old/3.log" {
+		t.Errorf("pages = %s", got)
+	}
+
+	result := s3List(t, srv, "list-type=2&max-keys=0")
+	if result.IsTruncated || result.NextContinuationToken != "" || listedKeys(result) != "" {
+		t.Errorf("max-keys=0 = %+v", result)
+	}
+
+	for _, query := range []string{"max-keys=-1", "max-keys=x", "list-type=2&continuation-token=%25"} {
+		if status, _ := s3Get(t, srv, http.MethodGet, "/b?"+query); status != http.StatusBadRequest {
+			t.Errorf("list %q: status %d, want 400", query, status)
+		}
+	}
+}
+
+func TestS3GetObject(t *testing.T) {
+	srv, _, _ := newTestS3Server(t)
+
+	if status, body := s3Get(t, srv, http.MethodGet, "/b/logs/1.log"); status != http.StatusOK || body != "one" {
+		t.Errorf("GET = %d %q", status, body)
+	}
+	if status, body := s3Get(t, srv, http.MethodHead, "/b/a.txt"); status != http.StatusOK || body != "" {
+		t.Errorf("HEAD = %d %q", status, body)
+	}
+
+	req, _ := http.NewRequest(http.MethodGet, srv.URL+"/b/a.txt", nil)
+	req.Header.Set("Range", "bytes=1-2")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusPartialContent || string(body) != "lp" {
+		t.Errorf("range GET = %d %q", resp.StatusCode, body)
+	}
+
+	tests := []struct {
+		method string
+		path   string
+		status int
+	}{
+		{http.MethodGet, "/b/missing", http.StatusNotFound},
+		{http.MethodGet, "/b/logs/", http.StatusNotFound},
+		{http.MethodGet, "/b/logs", http.StatusNotFound},
+		{http.MethodGet, "/other/a.txt", http.StatusNotFound},
+		{http.MethodPut, "/b/a.txt", http.StatusMethodNotAllowed},
+		{http.MethodHead, "/b", http.StatusOK},
+	}
+	for _, tt := range tests {
+		if status, _ := s3Get(t, srv, tt.method, tt.path); status != tt.status {
+			t.Errorf("%s %s = %d, want %d", tt.method, tt.path, status, tt.status)
+		}
+	}
+}
+
+func TestS3ListBuckets(t *testing.T) {
+	srv, _, _ := newTestS3Server(t)
+
+	status, body := s3Get(t, srv, http.MethodGet, "/")
+	var result s3ListAllMyBucketsResult
+	if err := xml.Unmarshal([]byte(body), &result); err != nil || status != http.StatusOK {
+		t.Fatalf("ListBuckets = %d, %v", status, err)
+	}
+	if len(result.Buckets) != 1 || result.Buckets[0].Name != "b" {
+		t.Errorf("buckets = %+v", result.Buckets)
+	}
+}
+
+func TestS3RefusesSymlinkEscapes(t *testing.T) {
+	srv, root, outside := newTestS3Server(t)
+	requireSymlinks(t)
+	if err := os.Symlink(outside, filepath.Join(root, "out")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(filepath.Join(outside, "secret.txt"), filepath.Join(root, "secret.txt")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink("a.txt", filepath.Join(root, "alias.txt")); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, method := range []string{http.MethodGet, http.MethodHead} {
+		for _, path := range []string{"/b/out/secret.txt", "/b/secret.txt", "/b/../outside/secret.txt"} {
+			if status, _ := s3Get(t, srv, method, path); status != http.StatusNotFound {
+				t.Errorf("%s %s = %d, want 404", method, path, status)
+			}
+		}
+	}
+	if got := listedKeys(s3List(t, srv, "prefix=out/")); got != "" {
+		t.Errorf("list through a link leaving the root = %s", got)
+	}
+
+	// Links that stay inside are followed
+	if status, body := s3Get(t, srv, http.MethodGet, "/b/alias.txt"); status != http.StatusOK || body != "alpha" {
+		t.Errorf("GET through a link inside the root = %d %q", status, body)
+	}
+}
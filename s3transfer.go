@@ -0,0 +1,635 @@
+package GMSFS
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultS3PartSize is the part size of multipart transfers. Files larger than one part are
+// copied between the local filesystem and an S3Backend as multipart transfers.
+const DefaultS3PartSize = 16 << 20
+
+// DefaultS3Concurrency is how many parts of a multipart transfer are in flight at once
+const DefaultS3Concurrency = 4
+
+const (
+	s3MinPartSize     = 5 << 20 // Smallest part S3 accepts, but for the last
+	s3MaxParts        = 10000
+	s3PartAttempts    = 4
+	uploadStateSuffix = ".gmsfs-upload"   // Beside the local file while an upload is incomplete
+	downloadSuffix    = ".gmsfs-download" // The partial download, with its state in downloadSuffix+".json"
+)
+
+// S3TransferOptions tunes S3Backend.Upload and S3Backend.Download
+type S3TransferOptions struct {
+	PartSize    int64 // Defaults to DefaultS3PartSize; raised as needed to stay within 10000 parts
+	Concurrency int   // Defaults to DefaultS3Concurrency
+}
+
+// Upload copies the local file local to the object name as a multipart upload, sending parts
+// in parallel, each with its MD5 in Content-MD5 so S3 rejects parts damaged on the way.
+// Files of at most one part are sent with a single PUT.
+//
+// Progress is recorded in a "<local>.gmsfs-upload" file as parts complete, so when an upload
+// is interrupted, calling Upload again with the same file and object sends only the missing
+// parts, as long as the file did not change. Interrupted uploads left for good keep their
+// parts stored in the bucket until a lifecycle rule for incomplete multipart uploads removes
+// them.
+func (b *S3Backend) Upload(ctx context.Context, local string, name string, opts S3TransferOptions) error {
+	ctx, j := startJob(ctx, "Upload", local, name)
+	defer j.done()
+
+	err := b.upload(ctx, cleanPath(local), memPath(name), opts, nil, j)
+	if err != nil {
+		errorPrinterCtx(ctx, "S3Backend.Upload: "+err.Error(), local)
+		return err
+	}
+	j.add(1, 0)
+	return nil
+}
+
+// Download copies the object name to the local file local, fetching parts in parallel with
+// ranged GETs that all require the object's ETag, so an object replaced meanwhile fails the
+// download instead of mixing versions. The parts are written into "<local>.gmsfs-download",
+// which replaces local once complete; the MD5 of every part is recorded beside it, so an
+// interrupted download resumes with the parts whose content still checks out. When the
+// object was uploaded in parts of the same size, its ETag is verified as well.
+func (b *S3Backend) Download(ctx context.Context, name string, local string, opts S3TransferOptions) error {
+	ctx, j := startJob(ctx, "Download", name, local)
+	defer j.done()
+
+	err := b.download(ctx, memPath(name), cleanPath(local), opts, nil, j)
+	if err != nil {
+		errorPrinterCtx(ctx, "S3Backend.Download: "+err.Error(), local)
+		return err
+	}
+	invalidateStat(local)
+	j.add(1, 0)
+	return nil
+}
+
+// partSize returns the part size for size bytes
+func (opts S3TransferOptions) partSize(size int64) int64 {
+	part := opts.PartSize
+	if part <= 0 {
+		part = DefaultS3PartSize
+	}
+	part = max(part, s3MinPartSize)
+	if parts := (size + part - 1) / part; parts > s3MaxParts {
+		part = (size + s3MaxParts - 1) / s3MaxParts
+	}
+	return part
+}
+
+func (opts S3TransferOptions) concurrency() int {
+	if opts.Concurrency <= 0 {
+		return DefaultS3Concurrency
+	}
+	return opts.Concurrency
+}
+
+// s3UploadState is the content of an upload state file
+type s3UploadState struct {
+	Bucket   string         `json:"bucket"`
+	Key      string         `json:"key"`
+	UploadID string         `json:"upload_id"`
+	Size     int64          `json:"size"`
+	ModTime  int64          `json:"mod_time"` // UnixNano of the local file
+	PartSize int64          `json:"part_size"`
+	Parts    map[int]string `json:"parts"` // ETag by part number
+}
+
+// s3DownloadState is the content of a download state file
+type s3DownloadState struct {
+	Bucket   string         `json:"bucket"`
+	Key      string         `json:"key"`
+	ETag     string         `json:"etag"`
+	Size     int64          `json:"size"`
+	PartSize int64          `json:"part_size"`
+	Parts    map[int]string `json:"parts"` // Hex MD5 by part number
+}
+
+type s3InitiateResult struct {
+	UploadID string `xml:"UploadId"`
+}
+
+type s3CompletePart struct {
+	PartNumber int    `xml:"PartNumber"`
+	ETag       string `xml:"ETag"`
+}
+
+type s3CompleteUpload struct {
+	XMLName xml.Name         `xml:"CompleteMultipartUpload"`
+	Parts   []s3CompletePart `xml:"Part"`
+}
+
+type s3ListPartsResult struct {
+	Parts                []s3CompletePart `xml:"Part"`
+	IsTruncated          bool             `xml:"IsTruncated"`
+	NextPartNumberMarker int              `xml:"NextPartNumberMarker"`
+}
+
+// transferState guards a state file shared by the part workers
+type transferState struct {
+	mu   sync.Mutex
+	name string
+	v    any
+}
+
+// save writes the state; a failure only costs the resume, so it is logged and ignored
+func (s *transferState) save() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	WriteJSONWithOptions(s.name, s.v, 0644, JSONOptions{Compact: true})
+}
+
+// loadTransferState decodes a state file into v, reporting whether there was a usable one
+func loadTransferState(name string, v any) bool {
+	data, err := os.ReadFile(name)
+	return err == nil && json.Unmarshal(data, v) == nil
+}
+
+func (s *s3UploadState) usable() bool {
+	if s.Parts == nil {
+		s.Parts = map[int]string{}
+	}
+	return s.UploadID != ""
+}
+
+func (s *s3DownloadState) usable() bool {
+	if s.Parts == nil {
+		s.Parts = map[int]string{}
+	}
+	return s.ETag != ""
+}
+
+// upload is Upload reporting to progress and j, either of which may be nil
+func (b *S3Backend) upload(ctx context.Context, local string, name string, opts S3TransferOptions, progress *copyProgress, j *job) error {
+	bucket, key := s3Split(name)
+	if key == "" {
+		return &os.PathError{Op: "upload", Path: name, Err: os.ErrInvalid}
+	}
+
+	acquireFDs(1)
+	defer releaseFDs(1)
+	f, err := os.Open(local)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+
+	size := info.Size()
+	partSize := opts.partSize(size)
+	if size <= partSize {
+		data, err := io.ReadAll(f)
+		if err != nil {
+			return err
+		}
+		if err := b.putPart(ctx, name, bucket, key, nil, data); err != nil {
+			return err
+		}
+		progress.add(local, size, false)
+		j.add(0, size)
+		return nil
+	}
+
+	stateName := local + uploadStateSuffix
+	var state s3UploadState
+	if loadTransferState(stateName, &state) && state.usable() {
+		if state.Bucket == bucket && state.Key == key && state.Size == size && state.ModTime == info.ModTime().UnixNano() && state.PartSize == partSize {
+			if err := b.checkParts(ctx, name, &state); err != nil {
+				warnPrinter("S3Backend.Upload: starting over: "+err.Error(), local)
+				state.UploadID = ""
+			}
+		} else {
+			b.abortUpload(name, state.Bucket, state.Key, state.UploadID)
+			state.UploadID = ""
+		}
+	}
+	if state.UploadID == "" {
+		id, err := b.initiateUpload(ctx, name, bucket, key)
+		if err != nil {
+			return err
+		}
+		state = s3UploadState{Bucket: bucket, Key: key, UploadID: id, Size: size, ModTime: info.ModTime().UnixNano(), PartSize: partSize, Parts: map[int]string{}}
+	}
+	saved := &transferState{name: stateName, v: &state}
+	saved.save()
+
+	parts := int((size + partSize - 1) / partSize)
+	var todo []int
+	for n := 1; n <= parts; n++ {
+		if _, ok := state.Parts[n]; ok {
+			done := min(partSize, size-int64(n-1)*partSize)
+			progress.add(local, done, false)
+			j.add(0, done)
+		} else {
+			todo = append(todo, n)
+		}
+	}
+
+	err = runParts(ctx, todo, opts.concurrency(), partSize, func(n int, buf []byte) error {
+		off := int64(n-1) * partSize
+		chunk := buf[:min(partSize, size-off)]
+		if _, err := f.ReadAt(chunk, off); err != nil && err != io.EOF {
+			return err
+		}
+		query := url.Values{"partNumber": {strconv.Itoa(n)}, "uploadId": {state.UploadID}}
+		etag, err := b.putPartETag(ctx, name, bucket, key, query, chunk)
+		if err != nil {
+			return err
+		}
+
+		saved.mu.Lock()
+		state.Parts[n] = etag
+		saved.mu.Unlock()
+		saved.save()
+		progress.add(local, int64(len(chunk)), false)
+		j.add(0, int64(len(chunk)))
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	if err := b.completeUpload(ctx, name, &state); err != nil {
+		return err
+	}
+	os.Remove(stateName)
+	return nil
+}
+
+// runParts calls fn for the parts in todo from up to concurrency goroutines, each with a
+// buffer of partSize bytes, retrying failed parts. The first error that persists stops the
+// others and is returned.
+func runParts(ctx context.Context, todo []int, concurrency int, partSize int64, fn func(n int, buf []byte) error) error {
+	ctx, cancel := context.WithCancelCause(ctx)
+	defer cancel(nil)
+
+	next := make(chan int)
+	var wg sync.WaitGroup
+	for range min(concurrency, len(todo)) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			buf := make([]byte, partSize)
+			for n := range next {
+				err := fn(n, buf)
+				for attempt := 1; err != nil && attempt < s3PartAttempts && ctx.Err() == nil; attempt++ {
+					warnPrinter(fmt.Sprintf("S3 transfer: part %d, attempt %d: %v", n, attempt, err), "")
+					select {
+					case <-ctx.Done():
+					case <-time.After(retryDelay(attempt)):
+						err = fn(n, buf)
+					}
+				}
+				if err != nil {
+					cancel(err)
+				}
+			}
+		}()
+	}
+
+feed:
+	for _, n := range todo {
+		select {
+		case next <- n:
+		case <-ctx.Done():
+			break feed
+		}
+	}
+	close(next)
+	wg.Wait()
+	return context.Cause(ctx)
+}
+
+// putPart stores data as the object, or with query as a part of it, with its Content-MD5
+func (b *S3Backend) putPart(ctx context.Context, name string, bucket string, key string, query url.Values, data []byte) error {
+	_, err := b.putPartETag(ctx, name, bucket, key, query, data)
+	return err
+}
+
+// putPartETag is putPart returning the ETag, checked against the MD5 when it is one
+func (b *S3Backend) putPartETag(ctx context.Context, name string, bucket string, key string, query url.Values, data []byte) (string, error) {
+	sum := md5.Sum(data)
+	header := http.Header{"Content-Md5": {base64.StdEncoding.EncodeToString(sum[:])}}
+	resp, err := b.doContext(ctx, "write", name, http.MethodPut, bucket, key, query, header, data)
+	if err != nil {
+		return "", err
+	}
+	resp.Body.Close()
+
+	etag := resp.Header.Get("ETag")
+	if plain := strings.Trim(etag, `"`); isHexMD5(plain) && plain != hex.EncodeToString(sum[:]) {
+		return "", &os.PathError{Op: "write", Path: name, Err: fmt.Errorf("checksum mismatch: sent %x, stored %s", sum, plain)}
+	}
+	return etag, nil
+}
+
+func isHexMD5(s string) bool {
+	if len(s) != 2*md5.Size {
+		return false
+	}
+	_, err := hex.DecodeString(s)
+	return err == nil
+}
+
+func (b *S3Backend) initiateUpload(ctx context.Context, name string, bucket string, key string) (string, error) {
+	resp, err := b.doContext(ctx, "write", name, http.MethodPost, bucket, key, url.Values{"uploads": {""}}, nil, nil)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	var result s3InitiateResult
+	if err := xml.NewDecoder(resp.Body).Decode(&result); err != nil || result.UploadID == "" {
+		return "", &os.PathError{Op: "write", Path: name, Err: fmt.Errorf("s3: no upload ID: %v", err)}
+	}
+	return result.UploadID, nil
+}
+
+// checkParts keeps the parts of state the server still has with the same ETag; an error
+// means the upload is gone
+func (b *S3Backend) checkParts(ctx context.Context, name string, state *s3UploadState) error {
+	stored := map[int]string{}
+	marker := 0
+	for {
+		query := url.Values{"uploadId": {state.UploadID}}
+		if marker > 0 {
+			query.Set("part-number-marker", strconv.Itoa(marker))
+		}
+		resp, err := b.doContext(ctx, "write", name, http.MethodGet, state.Bucket, state.Key, query, nil, nil)
+		if err != nil {
+			return err
+		}
+		var page s3ListPartsResult
+		err = xml.NewDecoder(resp.Body).Decode(&page)
+		resp.Body.Close()
+		if err != nil {
+			return &os.PathError{Op: "write", Path: name, Err: err}
+		}
+		for _, p := range page.Parts {
+			stored[p.PartNumber] = p.ETag
+		}
+		if !page.IsTruncated || page.NextPartNumberMarker <= marker {
+			break
+		}
+		marker = page.NextPartNumberMarker
+	}
+
+	for n, etag := range state.Parts {
+		if stored[n] != etag {
+			delete(state.Parts, n)
+		}
+	}
+	return nil
+}
+
+func (b *S3Backend) completeUpload(ctx context.Context, name string, state *s3UploadState) error {
+	var doc s3CompleteUpload
+	for n, etag := range state.Parts {
+		doc.Parts = append(doc.Parts, s3CompletePart{PartNumber: n, ETag: etag})
+	}
+	sort.Slice(doc.Parts, func(i, j int) bool { return doc.Parts[i].PartNumber < doc.Parts[j].PartNumber })
+	body, err := xml.Marshal(doc)
+	if err != nil {
+		return err
+	}
+
+	query := url.Values{"uploadId": {state.UploadID}}
+	resp, err := b.doContext(ctx, "write", name, http.MethodPost, state.Bucket, state.Key, query, nil, body)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	// S3 may report a failed completion in a 200 response
+	data, _ := io.ReadAll(io.LimitReader(resp.Body, 64<<10))
+	var failure s3Error
+	if xml.Unmarshal(data, &failure) == nil && failure.Code != "" {
+		return &os.PathError{Op: "write", Path: name, Err: &S3Error{StatusCode: resp.StatusCode, Code: failure.Code, Message: failure.Message}}
+	}
+	return nil
+}
+
+// abortUpload discards an upload that is not resumed, so its parts stop costing storage
+func (b *S3Backend) abortUpload(name string, bucket string, key string, id string) {
+	if id == "" {
+		return
+	}
+	resp, err := b.do("write", name, http.MethodDelete, bucket, key, url.Values{"uploadId": {id}}, nil, nil)
+	if err != nil {
+		warnPrinter("S3Backend.Upload (abort): "+err.Error(), name)
+		return
+	}
+	resp.Body.Close()
+}
+
+// download is Download reporting to progress and j, either of which may be nil
+func (b *S3Backend) download(ctx context.Context, name string, local string, opts S3TransferOptions, progress *copyProgress, j *job) error {
+	bucket, key := s3Split(name)
+	if key == "" {
+		return &os.PathError{Op: "open", Path: name, Err: os.ErrInvalid}
+	}
+
+	resp, err := b.doContext(ctx, "open", name, http.MethodHead, bucket, key, nil, nil, nil)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	size, etag := resp.ContentLength, resp.Header.Get("ETag")
+	if size < 0 {
+		return &os.PathError{Op: "open", Path: name, Err: errors.New("s3: no content length")}
+	}
+	partSize := opts.partSize(size)
+
+	tmpName := local + downloadSuffix
+	stateName := tmpName + ".json"
+	var state s3DownloadState
+	resumed := loadTransferState(stateName, &state) && state.usable() &&
+		state.Bucket == bucket && state.Key == key && state.ETag == etag && state.Size == size && state.PartSize == partSize
+	if !resumed {
+		state = s3DownloadState{Bucket: bucket, Key: key, ETag: etag, Size: size, PartSize: partSize, Parts: map[int]string{}}
+	}
+
+	acquireFDs(1)
+	defer releaseFDs(1)
+	flag := os.O_RDWR | os.O_CREATE
+	if !resumed {
+		flag |= os.O_TRUNC
+	}
+	f, err := os.OpenFile(tmpName, flag, 0644)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if err := f.Truncate(size); err != nil {
+		return err
+	}
+	saved := &transferState{name: stateName, v: &state}
+	saved.save()
+
+	parts := int((size + partSize - 1) / partSize)
+	var todo []int
+	check := make([]byte, 0)
+	for n := 1; n <= parts; n++ {
+		if sum, ok := state.Parts[n]; ok {
+			// Only parts whose bytes on disk still match are kept
+			off := int64(n-1) * partSize
+			length := min(partSize, size-off)
+			if int64(cap(check)) < length {
+				check = make([]byte, length)
+			}
+			chunk := check[:length]
+			if _, err := f.ReadAt(chunk, off); err == nil || err == io.EOF {
+				if got := md5.Sum(chunk); hex.EncodeToString(got[:]) == sum {
+					progress.add(local, length, false)
+					j.add(0, length)
+					continue
+				}
+			}
+			delete(state.Parts, n)
+		}
+		todo = append(todo, n)
+	}
+	check = nil
+
+	err = runParts(ctx, todo, opts.concurrency(), partSize, func(n int, buf []byte) error {
+		off := int64(n-1) * partSize
+		chunk := buf[:min(partSize, size-off)]
+		header := http.Header{"Range": {fmt.Sprintf("bytes=%d-%d", off, off+int64(len(chunk))-1)}}
+		if etag != "" {
+			header.Set("If-Match", etag)
+		}
+		resp, err := b.doContext(ctx, "read", name, http.MethodGet, bucket, key, nil, header, nil)
+		if err != nil {
+			return err
+		}
+		_, err = io.ReadFull(resp.Body, chunk)
+		resp.Body.Close()
+		if err != nil {
+			return &os.PathError{Op: "read", Path: name, Err: err}
+		}
+		if _, err := f.WriteAt(chunk, off); err != nil {
+			return err
+		}
+
+		sum := md5.Sum(chunk)
+		saved.mu.Lock()
+		state.Parts[n] = hex.EncodeToString(sum[:])
+		saved.mu.Unlock()
+		saved.save()
+		progress.add(local, int64(len(chunk)), false)
+		j.add(0, int64(len(chunk)))
+		return nil
+	})
+	if err != nil {
+		var s3err *S3Error
+		if errors.As(err, &s3err) && s3err.StatusCode == http.StatusPreconditionFailed {
+			// Replaced meanwhile: the parts are of no use for the new version
+			f.Close()
+			os.Remove(tmpName)
+			os.Remove(stateName)
+			return &os.PathError{Op: "read", Path: name, Err: errors.New("object changed during download")}
+		}
+		return err
+	}
+
+	if err := verifyMultipartETag(etag, state.Parts, parts); err != nil {
+		f.Close()
+		os.Remove(tmpName)
+		os.Remove(stateName)
+		return &os.PathError{Op: "read", Path: name, Err: err}
+	}
+	if err := f.Sync(); err != nil {
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmpName, local); err != nil {
+		return err
+	}
+	os.Remove(stateName)
+	return nil
+}
+
+// verifyMultipartETag checks etag against the part MD5s where it is comparable: the MD5 of a
+// single-part object, or the MD5 of the part MD5s of an object uploaded in the same parts
+func verifyMultipartETag(etag string, sums map[int]string, parts int) error {
+	etag = strings.Trim(etag, `"`)
+	want, count, multipart := strings.Cut(etag, "-")
+	if !isHexMD5(want) {
+		return nil
+	}
+
+	var got string
+	switch {
+	case !multipart && parts == 1:
+		got = sums[1]
+	case multipart && count == strconv.Itoa(parts):
+		var all bytes.Buffer
+		for n := 1; n <= parts; n++ {
+			sum, _ := hex.DecodeString(sums[n])
+			all.Write(sum)
+		}
+		sum := md5.Sum(all.Bytes())
+		got = hex.EncodeToString(sum[:])
+	default:
+		return nil // Other part layout; nothing to compare
+	}
+	if got != want {
+		return fmt.Errorf("checksum mismatch: ETag %s, downloaded %s", etag, got)
+	}
+	return nil
+}
+
+// copyMultipart copies src to dst with a multipart transfer when one side is local, the
+// other an S3Backend and the file larger than a part; it reports whether it did
+func copyMultipart(ctx context.Context, src string, dst string, progress *copyProgress) (bool, error) {
+	sb, sp := backendFor(src)
+	db, dp := backendFor(dst)
+
+	if s3, ok := db.(*S3Backend); ok {
+		if _, local := sb.(LocalBackend); !local {
+			return false, nil
+		}
+		if info, err := os.Stat(sp); err != nil || info.Size() <= DefaultS3PartSize {
+			return false, nil
+		}
+		return true, s3.upload(ctx, sp, dp, S3TransferOptions{}, progress, nil)
+	}
+
+	if s3, ok := sb.(*S3Backend); ok {
+		if _, local := db.(LocalBackend); !local {
+			return false, nil
+		}
+		if info, err := s3.Stat(sp); err != nil || info.IsDir() || info.Size() <= DefaultS3PartSize {
+			return false, nil
+		}
+		err := s3.download(ctx, sp, dp, S3TransferOptions{}, progress, nil)
+		invalidateStat(dst)
+		return true, err
+	}
+	return false, nil
+}
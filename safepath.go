@@ -0,0 +1,114 @@
+package GMSFS
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// SafeJoin joins a user supplied relative path onto base. Absolute paths, drive letters and
+// ".." elements are refused with ErrPathEscapes rather than cleaned away, so a request for
+// "../etc/passwd" fails loudly instead of quietly reading something else. Symlinks are not
+// looked at; use SafeJoinResolved or a Root when the tree may contain links pointing out.
+func SafeJoin(base string, userPath string) (string, error) {
+	if err := checkUserPath("join", userPath); err != nil {
+		return "", err
+	}
+	return filepath.Join(cleanPath(base), filepath.FromSlash(userPath)), nil
+}
+
+// SafeJoinResolved is SafeJoin that also follows the symlinks along the joined path, failing
+// with ErrPathEscapes when one leads outside base. base has to exist; the result is below
+// base with its symlinks resolved too.
+func SafeJoinResolved(base string, userPath string) (string, error) {
+	if err := checkUserPath("join", userPath); err != nil {
+		return "", err
+	}
+	r, err := NewRoot(base)
+	if err != nil {
+		return "", err
+	}
+	return r.resolve("join", userPath, true)
+}
+
+// checkUserPath refuses the parts of a user supplied path SafeJoin does not accept
+func checkUserPath(op string, userPath string) error {
+	if strings.Contains(userPath, "\x00") {
+		return &os.PathError{Op: op, Path: userPath, Err: os.ErrInvalid}
+	}
+	slashed := strings.ReplaceAll(userPath, `\`, "/")
+	if filepath.IsAbs(userPath) || filepath.VolumeName(userPath) != "" || strings.HasPrefix(slashed, "/") || colonEscapes(userPath) {
+		return &os.PathError{Op: op, Path: userPath, Err: ErrPathEscapes}
+	}
+	for _, part := range strings.Split(slashed, "/") {
+		if part == ".." {
+			return &os.PathError{Op: op, Path: userPath, Err: ErrPathEscapes}
+		}
+	}
+	return nil
+}
+
+// colonEscapes reports whether cleanPath would cut p at a colon and so take it elsewhere,
+// see SetStripColonPrefix
+func colonEscapes(p string) bool {
+	return stripColonPrefix.Load() && stripColon(p) != p
+}
+
+// IsWithin reports whether path is base or below it, comparing the cleaned absolute paths
+// without looking at symlinks
+func IsWithin(base string, path string) bool {
+	base, err := filepath.Abs(cleanPath(base))
+	if err != nil {
+		return false
+	}
+	path, err = filepath.Abs(cleanPath(path))
+	if err != nil {
+		return false
+	}
+	_, ok := relWithin(base, path)
+	return ok
+}
+
+// IsWithinResolved is IsWithin after following the symlinks of both paths, as far as they
+// exist
+func IsWithinResolved(base string, path string) bool {
+	base, err := evalExisting(cleanPath(base))
+	if err != nil {
+		return false
+	}
+	path, err = evalExisting(cleanPath(path))
+	if err != nil {
+		return false
+	}
+	_, ok := relWithin(base, path)
+	return ok
+}
+
+// evalExisting makes p absolute and resolves the symlinks of its longest existing ancestor
+func evalExisting(p string) (string, error) {
+	p, err := filepath.Abs(p)
+	if err != nil {
+		return "", err
+	}
+
+	rest := ""
+	for dir := p; ; dir = filepath.Dir(dir) {
+		resolved, err := filepath.EvalSymlinks(dir)
+		if err == nil {
+			return filepath.Join(resolved, rest), nil
+		}
+		if !os.IsNotExist(err) || filepath.Dir(dir) == dir {
+			return "", err
+		}
+		rest = filepath.Join(filepath.Base(dir), rest)
+	}
+}
+
+// relWithin returns the part of the absolute path p below base
+func relWithin(base string, p string) (string, bool) {
+	rel, err := filepath.Rel(base, filepath.Clean(p))
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", false
+	}
+	return rel, true
+}
@@ -0,0 +1,132 @@
+package GMSFS
+
+import (
+	"io"
+	"math/rand/v2"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// SlowDiskOptions describes simulated storage for SetSlowDisk
+type SlowDiskOptions struct {
+	Latency          time.Duration // Added to every filesystem operation
+	Jitter           time.Duration // Random extra latency of up to this much
+	ReadBytesPerSec  int64         // Shared cap on content read; 0 is unlimited
+	WriteBytesPerSec int64         // Shared cap on content written; 0 is unlimited
+}
+
+type slowDisk struct {
+	opts  SlowDiskOptions
+	read  pacer
+	write pacer
+}
+
+var simulated atomic.Pointer[slowDisk]
+
+// SetSlowDisk makes the package behave like slow storage, for reproducing production
+// behaviour during development: operations wait for the latency and content moves no faster
+// than the bandwidth caps, shared by all goroutines. Data read and written through an
+// *os.File returned by Open, Create or OpenFile is not slowed down. The zero value turns the
+// simulation off.
+func SetSlowDisk(opts SlowDiskOptions) {
+	if opts == (SlowDiskOptions{}) {
+		simulated.Store(nil)
+		return
+	}
+	simulated.Store(&slowDisk{opts: opts})
+}
+
+// SlowDisk returns the current simulation settings
+func SlowDisk() SlowDiskOptions {
+	if s := simulated.Load(); s != nil {
+		return s.opts
+	}
+	return SlowDiskOptions{}
+}
+
+// simulateOp waits for the simulated latency of one operation
+func simulateOp() {
+	s := simulated.Load()
+	if s == nil {
+		return
+	}
+
+	delay := s.opts.Latency
+	if s.opts.Jitter > 0 {
+		delay += rand.N(s.opts.Jitter)
+	}
+	if delay > 0 {
+		time.Sleep(delay)
+	}
+}
+
+// simulateRead waits until n bytes fit the read bandwidth
+func simulateRead(n int) {
+	if s := simulated.Load(); s != nil {
+		s.read.wait(int64(n), s.opts.ReadBytesPerSec)
+	}
+}
+
+// simulateWrite waits until n bytes fit the write bandwidth
+func simulateWrite(n int) {
+	if s := simulated.Load(); s != nil {
+		s.write.wait(int64(n), s.opts.WriteBytesPerSec)
+	}
+}
+
+// simulatedReader slows r down to the read bandwidth while the simulation is on
+func simulatedReader(r io.Reader) io.Reader {
+	if s := simulated.Load(); s == nil || s.opts.ReadBytesPerSec <= 0 {
+		return r
+	}
+	return &slowReader{r: r}
+}
+
+// simulatedWriter slows w down to the write bandwidth while the simulation is on
+func simulatedWriter(w io.Writer) io.Writer {
+	if s := simulated.Load(); s == nil || s.opts.WriteBytesPerSec <= 0 {
+		return w
+	}
+	return &slowWriter{w: w}
+}
+
+type slowReader struct{ r io.Reader }
+
+func (r *slowReader) Read(b []byte) (int, error) {
+	n, err := r.r.Read(b)
+	simulateRead(n)
+	return n, err
+}
+
+type slowWriter struct{ w io.Writer }
+
+func (w *slowWriter) Write(b []byte) (int, error) {
+	simulateWrite(len(b))
+	return w.w.Write(b)
+}
+
+// pacer books transfers back to back on a shared timeline, so concurrent transfers share
+// the bandwidth instead of each getting all of it
+type pacer struct {
+	mu   sync.Mutex
+	next time.Time
+}
+
+func (p *pacer) wait(n int64, perSec int64) {
+	if n <= 0 || perSec <= 0 {
+		return
+	}
+	d := time.Duration(float64(n) / float64(perSec) * float64(time.Second))
+
+	p.mu.Lock()
+	now := time.Now()
+	if p.next.Before(now) {
+		p.next = now
+	}
+	p.next = p.next.Add(d)
+	until := p.next
+	p.mu.Unlock()
+
+	time.Sleep(time.Until(until))
+}
@@ -0,0 +1,463 @@
+package GMSFS
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+// DefaultSoftDeleteGrace is how long soft deleted files can be restored unless
+// SoftDeleteOptions.Grace says otherwise
+const DefaultSoftDeleteGrace = 24 * time.Hour
+
+// softDeleteDir is the directory next to deleted files they are moved into when no TrashDir
+// is set; the name is reserved, as Tiering and quotas skip directories called so
+const softDeleteDir = ".gmsfs-deleted"
+
+// trashOrigin is the file in each trash entry holding the path the entry was deleted from
+const trashOrigin = ".origin"
+
+// SoftDeleteOptions configures SetSoftDelete
+type SoftDeleteOptions struct {
+	Grace    time.Duration // How long deleted files can be restored; defaults to DefaultSoftDeleteGrace
+	TrashDir string        // Move deleted files below this directory instead of next to them
+	Roots    []string      // Trees the reaper also searches for files set aside next to them before this process started
+	Interval time.Duration // Time between reaper passes; defaults to a tenth of Grace, from a second to an hour
+}
+
+// DeletedFile is a soft deleted file or directory waiting for the reaper
+type DeletedFile struct {
+	Path    string    // Where it was deleted from
+	Marked  string    // Where it is now
+	Deleted time.Time // When it was deleted; the reaper removes it Grace later
+}
+
+// softDeleter is the soft delete mode in effect and its reaper
+type softDeleter struct {
+	opts SoftDeleteOptions
+
+	mu   sync.Mutex
+	dirs map[string]struct{} // Trash directories next to files this process set aside
+
+	stop chan struct{}
+	done chan struct{}
+	once sync.Once
+}
+
+var softDelete atomic.Pointer[softDeleter]
+
+// SetSoftDelete makes Delete and Remove of local paths set files aside rather than removing
+// them: they are moved into a ".gmsfs-deleted" directory next to them, or below TrashDir when
+// set, each deletion in an entry of its own recording where it came from, and a background
+// reaper removes them for good once opts.Grace has passed. Until then Undelete brings them
+// back, tags included, which gives automated cleanup jobs an undo window. Files set aside
+// still use space, but not quota, and Tiering leaves them alone. The reaper only removes entries it finds
+// complete, so nothing the package did not set aside is removed. RemoveAll and paths on
+// registered backends are not affected. Calling it again replaces the options.
+func SetSoftDelete(opts SoftDeleteOptions) error {
+	if opts.Grace < 0 || opts.Interval < 0 {
+		return fmt.Errorf("SetSoftDelete: negative duration")
+	}
+	if opts.Grace == 0 {
+		opts.Grace = DefaultSoftDeleteGrace
+	}
+	if opts.Interval == 0 {
+		opts.Interval = min(max(opts.Grace/10, time.Second), time.Hour)
+	}
+	if opts.TrashDir != "" {
+		trash, err := filepath.Abs(cleanPath(opts.TrashDir))
+		if err == nil {
+			err = requireLocal("softdelete", trash)
+		}
+		if err == nil {
+			err = os.MkdirAll(trash, 0700)
+		}
+		if err != nil {
+			errorPrinter("SetSoftDelete: "+err.Error(), opts.TrashDir)
+			return err
+		}
+		opts.TrashDir = trash
+	}
+	for i, root := range opts.Roots {
+		opts.Roots[i] = cleanPath(root)
+	}
+
+	d := &softDeleter{opts: opts, dirs: map[string]struct{}{}, stop: make(chan struct{}), done: make(chan struct{})}
+	if old := softDelete.Swap(d); old != nil {
+		old.Close()
+		old.mu.Lock()
+		for dir := range old.dirs {
+			d.dirs[dir] = struct{}{}
+		}
+		old.mu.Unlock()
+	}
+	registerCloser(d)
+	go d.run()
+	return nil
+}
+
+// DisableSoftDelete makes Delete and Remove remove files again and stops the reaper. Files
+// already set aside stay where they are.
+func DisableSoftDelete() {
+	if d := softDelete.Swap(nil); d != nil {
+		d.Close()
+	}
+}
+
+// DeletedFiles returns the soft deleted files known to the reaper, oldest first
+func DeletedFiles() ([]DeletedFile, error) {
+	d := softDelete.Load()
+	if d == nil {
+		return nil, nil
+	}
+	return d.list()
+}
+
+// Undelete restores the most recent soft deleted file or directory deleted from name. It fails
+// with fs.ErrExist when name exists again and fs.ErrNotExist when there is nothing to restore.
+func Undelete(name string) error {
+	name = cleanPath(name)
+	d := softDelete.Load()
+	if d == nil {
+		return opError("undelete", name, "", errors.New("soft delete is not enabled"))
+	}
+	abs, err := filepath.Abs(name)
+	if err != nil {
+		return opError("undelete", name, "", err)
+	}
+
+	files, err := d.list()
+	if err != nil {
+		errorPrinter("Undelete: "+err.Error(), name)
+		return opError("undelete", name, "", err)
+	}
+	for i := len(files) - 1; i >= 0; i-- {
+		f := files[i]
+		if f.Path != abs {
+			continue
+		}
+		if _, err := os.Lstat(name); err == nil {
+			return opError("undelete", name, "", fs.ErrExist)
+		}
+
+		// Back under the quotas of name, which did not count it while it was set aside
+		usage, dir := quotaStat(LocalBackend{}, f.Marked)
+		if err := quotaReserve(name, usage); err != nil {
+			errorPrinter("Undelete: "+err.Error(), name)
+			return opError("undelete", name, "", err)
+		}
+		if err := renameAside(f.Marked, name); err != nil {
+			quotaRelease(name, usage)
+			errorPrinter("Undelete: "+err.Error(), name)
+			return opError("undelete", f.Marked, name, err)
+		}
+		if dir {
+			quotaRecount(name)
+		}
+		invalidateStatTree(name)
+		moveTags(f.Marked, name)
+
+		entry := filepath.Dir(f.Marked)
+		os.Remove(filepath.Join(entry, trashOrigin))
+		os.Remove(entry)
+		if d.opts.TrashDir == "" {
+			// Gone once the last file set aside there is
+			os.Remove(filepath.Dir(entry))
+		}
+		infoPrinter("Undelete: restored", name)
+		return nil
+	}
+	return opError("undelete", name, "", fs.ErrNotExist)
+}
+
+// ReapDeleted runs a reaper pass now, removing the soft deleted files whose grace period is
+// over, and returns where they were deleted from
+func ReapDeleted() ([]string, error) {
+	d := softDelete.Load()
+	if d == nil {
+		return nil, nil
+	}
+	return d.reap()
+}
+
+func (d *softDeleter) run() {
+	defer close(d.done)
+
+	ticker := time.NewTicker(d.opts.Interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-d.stop:
+			return
+		case <-ticker.C:
+			d.reap()
+		}
+	}
+}
+
+// Close stops the reaper and, when it is the one in effect, soft delete mode
+func (d *softDeleter) Close() error {
+	d.once.Do(func() {
+		softDelete.CompareAndSwap(d, nil)
+		unregisterCloser(d)
+		close(d.stop)
+		<-d.done
+	})
+	return nil
+}
+
+// removeFile removes the file p on b, known as name, or sets it aside in soft delete mode
+func removeFile(b Backend, p string, name string) error {
+	if d := softDelete.Load(); d != nil && isLocal(name) {
+		return d.mark(p)
+	}
+	return b.Remove(p)
+}
+
+// mark sets name aside instead of removing it
+func (d *softDeleter) mark(name string) error {
+	abs, err := filepath.Abs(name)
+	if err != nil {
+		return err
+	}
+	info, err := os.Lstat(abs)
+	if err != nil {
+		return err
+	}
+	if info.IsDir() {
+		// Like Remove, leave directories with something in them alone
+		entries, err := os.ReadDir(abs)
+		if err != nil {
+			return err
+		}
+		if len(entries) > 0 {
+			return &os.PathError{Op: "remove", Path: abs, Err: syscall.ENOTEMPTY}
+		}
+	}
+
+	trash := d.opts.TrashDir
+	if trash == "" {
+		trash = filepath.Join(filepath.Dir(abs), softDeleteDir)
+		if filepath.Base(abs) == softDeleteDir {
+			// Only ever empty here, see above
+			return os.Remove(abs)
+		}
+		if err := os.Mkdir(trash, 0700); err != nil && !errors.Is(err, fs.ErrExist) {
+			return err
+		}
+		d.mu.Lock()
+		d.dirs[trash] = struct{}{}
+		d.mu.Unlock()
+	}
+
+	// Each deletion gets its own entry, named by the time, holding the file and where it was
+	entry := ""
+	for n := time.Now().UnixNano(); ; n++ {
+		entry = filepath.Join(trash, strconv.FormatInt(n, 10))
+		err := os.Mkdir(entry, 0700)
+		if err == nil {
+			break
+		}
+		if !errors.Is(err, fs.ErrExist) {
+			return err
+		}
+	}
+	marked := filepath.Join(entry, filepath.Base(abs))
+	err = os.WriteFile(filepath.Join(entry, trashOrigin), []byte(abs), 0600)
+	if err == nil {
+		err = renameAside(abs, marked)
+	}
+	if err != nil {
+		os.Remove(filepath.Join(entry, trashOrigin))
+		os.Remove(entry)
+		return err
+	}
+	// The caller takes the file off the quotas and the Stat cache, as for a removal
+	if filepath.Base(abs) != tagSidecar {
+		moveTags(abs, marked)
+	}
+	return nil
+}
+
+// renameAside moves a file into or out of the trash, without the quota accounting of Move
+func renameAside(oldName string, newName string) error {
+	err := os.Rename(oldName, newName)
+	if err != nil && isCrossDevice(err) {
+		err = moveAcross(oldName, newName)
+	}
+	return err
+}
+
+// list finds the files set aside, oldest first
+func (d *softDeleter) list() ([]DeletedFile, error) {
+	var files []DeletedFile
+	var errs []error
+
+	if d.opts.TrashDir != "" {
+		found, err := listTrash(d.opts.TrashDir)
+		if err != nil {
+			return nil, err
+		}
+		files = found
+	} else {
+		d.mu.Lock()
+		trashes := map[string]bool{}
+		for dir := range d.dirs {
+			trashes[dir] = true
+		}
+		d.mu.Unlock()
+
+		for _, root := range d.opts.Roots {
+			err := filepath.WalkDir(root, func(p string, e fs.DirEntry, err error) error {
+				if err != nil {
+					if !errors.Is(err, fs.ErrNotExist) {
+						errs = append(errs, err)
+					}
+					return nil
+				}
+				if e.IsDir() && e.Name() == softDeleteDir {
+					if abs, err := filepath.Abs(p); err == nil {
+						trashes[abs] = true
+					}
+					return filepath.SkipDir
+				}
+				return nil
+			})
+			if err != nil {
+				errs = append(errs, err)
+			}
+		}
+
+		for trash := range trashes {
+			found, err := listTrash(trash)
+			if err != nil && !errors.Is(err, fs.ErrNotExist) {
+				errs = append(errs, err)
+			}
+			files = append(files, found...)
+		}
+	}
+
+	sort.Slice(files, func(i, j int) bool { return files[i].Deleted.Before(files[j].Deleted) })
+	return files, errors.Join(errs...)
+}
+
+// listTrash lists the entries of a trash directory that mark made; anything else in it is left
+// out, and so never reaped
+func listTrash(trash string) ([]DeletedFile, error) {
+	entries, err := os.ReadDir(trash)
+	if err != nil {
+		return nil, err
+	}
+
+	var files []DeletedFile
+	var errs []error
+	for _, e := range entries {
+		n, err := strconv.ParseInt(e.Name(), 10, 64)
+		if err != nil || !e.IsDir() {
+			continue
+		}
+		entry := filepath.Join(trash, e.Name())
+		origin, err := os.ReadFile(filepath.Join(entry, trashOrigin))
+		if errors.Is(err, fs.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		path := string(origin)
+		if !filepath.IsAbs(path) {
+			continue
+		}
+		marked := filepath.Join(entry, filepath.Base(path))
+		if !trashEntry(entry, marked) {
+			continue
+		}
+		files = append(files, DeletedFile{Path: path, Marked: marked, Deleted: time.Unix(0, n)})
+	}
+	return files, errors.Join(errs...)
+}
+
+// trashEntry reports whether entry holds what mark put there, the origin, the file marked and
+// the tags it took along, and nothing else
+func trashEntry(entry string, marked string) bool {
+	entries, err := os.ReadDir(entry)
+	if err != nil {
+		return false
+	}
+	for _, e := range entries {
+		switch e.Name() {
+		case trashOrigin, tagSidecar, filepath.Base(marked):
+		default:
+			return false
+		}
+	}
+	_, err = os.Lstat(marked)
+	return err == nil
+}
+
+// isSoftDeleteDir reports whether a directory met walking a tree is where files next to it
+// were set aside
+func isSoftDeleteDir(e fs.DirEntry) bool {
+	return e.IsDir() && e.Name() == softDeleteDir
+}
+
+// reap removes what has been set aside longer than the grace period
+func (d *softDeleter) reap() ([]string, error) {
+	files, err := d.list()
+	if err != nil {
+		warnPrinter("Soft delete reaper: "+err.Error(), "")
+	}
+
+	cutoff := time.Now().Add(-d.opts.Grace)
+	var reaped []string
+	var errs []error
+	for _, f := range files {
+		if !f.Deleted.Before(cutoff) {
+			continue
+		}
+		// Checked again right before, as the entry is removed with all it holds
+		entry := filepath.Dir(f.Marked)
+		if !trashEntry(entry, f.Marked) {
+			continue
+		}
+		if err := os.RemoveAll(entry); err != nil {
+			errorPrinter("Soft delete reaper: "+err.Error(), f.Marked)
+			errs = append(errs, err)
+			continue
+		}
+		reaped = append(reaped, f.Path)
+	}
+	if len(reaped) > 0 {
+		infoPrinter("Soft delete reaper: removed "+strconv.Itoa(len(reaped))+" files", "")
+	}
+
+	// Trash directories with nothing left to reap need not be read again, and go when empty
+	if d.opts.TrashDir == "" {
+		pending := map[string]bool{}
+		for _, f := range files {
+			if f.Deleted.Before(cutoff) {
+				continue
+			}
+			pending[filepath.Dir(filepath.Dir(f.Marked))] = true
+		}
+		d.mu.Lock()
+		for dir := range d.dirs {
+			if !pending[dir] {
+				delete(d.dirs, dir)
+				os.Remove(dir)
+			}
+		}
+		d.mu.Unlock()
+	}
+
+	return reaped, errors.Join(append(errs, err)...)
+}
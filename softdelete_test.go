@@ -0,0 +1,234 @@
+package GMSFS
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// enableSoftDelete turns soft delete on for the test with a grace period the test can wait out
+func enableSoftDelete(t *testing.T, opts SoftDeleteOptions) {
+	t.Helper()
+	opts.Grace = 10 * time.Millisecond
+	opts.Interval = time.Hour
+	if err := SetSoftDelete(opts); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(DisableSoftDelete)
+}
+
+func TestSoftDeleteUndelete(t *testing.T) {
+	dir := t.TempDir()
+	enableSoftDelete(t, SoftDeleteOptions{})
+	name := filepath.Join(dir, "report.txt")
+	if err := os.WriteFile(name, []byte("data"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := Delete(name); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(name); !os.IsNotExist(err) {
+		t.Fatalf("deleted file still there: %v", err)
+	}
+	files, err := DeletedFiles()
+	if err != nil || len(files) != 1 || files[0].Path != name {
+		t.Fatalf("DeletedFiles = %+v, %v", files, err)
+	}
+
+	if err := Undelete(name); err != nil {
+		t.Fatal(err)
+	}
+	if data, err := os.ReadFile(name); err != nil || string(data) != "data" {
+		t.Errorf("restored %q, %v", data, err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, softDeleteDir)); !os.IsNotExist(err) {
+		t.Errorf("empty trash directory left behind: %v", err)
+	}
+}
+
+func TestSoftDeleteReapsOnlyItsOwn(t *testing.T) {
+	dir := t.TempDir()
+	// Names the earlier in-place marks would have taken for deleted files
+	userFile := filepath.Join(dir, "report.deleted-2024")
+	userDir := filepath.Join(dir, "photos.deleted-1700000000")
+	// Something in the trash directory that mark did not put there
+	stray := filepath.Join(dir, softDeleteDir, "1", "keep.txt")
+	for _, p := range []string{userFile, filepath.Join(userDir, "a.jpg"), stray} {
+		if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(p, nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	enableSoftDelete(t, SoftDeleteOptions{Roots: []string{dir}})
+
+	gone := filepath.Join(dir, "gone.txt")
+	if err := os.WriteFile(gone, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := Delete(gone); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(20 * time.Millisecond)
+
+	reaped, err := ReapDeleted()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(reaped) != 1 || reaped[0] != gone {
+		t.Errorf("ReapDeleted = %v, want [%s]", reaped, gone)
+	}
+	for _, p := range []string{userFile, userDir, stray} {
+		if _, err := os.Stat(p); err != nil {
+			t.Errorf("reaper removed %s: %v", p, err)
+		}
+	}
+}
+
+func TestSoftDeleteTrashDir(t *testing.T) {
+	dir := t.TempDir()
+	trash := filepath.Join(t.TempDir(), "trash")
+	enableSoftDelete(t, SoftDeleteOptions{TrashDir: trash})
+	name := filepath.Join(dir, "a.txt")
+	if err := os.WriteFile(name, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := Delete(name); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, softDeleteDir)); !os.IsNotExist(err) {
+		t.Errorf("trash directory made next to the file: %v", err)
+	}
+	time.Sleep(20 * time.Millisecond)
+
+	reaped, err := ReapDeleted()
+	if err != nil || len(reaped) != 1 {
+		t.Errorf("ReapDeleted = %v, %v", reaped, err)
+	}
+	if entries, err := os.ReadDir(trash); err != nil || len(entries) != 0 {
+		t.Errorf("trash holds %v, %v", entries, err)
+	}
+}
+
+func TestSoftDeleteKeepsTags(t *testing.T) {
+	dir := t.TempDir()
+	enableSoftDelete(t, SoftDeleteOptions{})
+	name := filepath.Join(dir, "a.txt")
+	if err := os.WriteFile(name, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := Tag(name, "owner", "ops"); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := Delete(name); err != nil {
+		t.Fatal(err)
+	}
+	if files, err := DeletedFiles(); err != nil || len(files) != 1 {
+		t.Fatalf("DeletedFiles = %+v, %v", files, err)
+	}
+	if err := Undelete(name); err != nil {
+		t.Fatal(err)
+	}
+	if tags, err := GetTags(name); err != nil || tags["owner"] != "ops" {
+		t.Errorf("tags after Undelete = %v, %v", tags, err)
+	}
+
+	if err := Delete(name); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(20 * time.Millisecond)
+	reaped, err := ReapDeleted()
+	if err != nil || len(reaped) != 1 {
+		t.Errorf("ReapDeleted = %v, %v", reaped, err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, softDeleteDir)); !os.IsNotExist(err) {
+		t.Errorf("trash directory left behind: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, tagSidecar)); !os.IsNotExist(err) {
+		t.Errorf("tag sidecar left behind: %v", err)
+	}
+}
+
+func TestSoftDeleteQuota(t *testing.T) {
+	for _, layout := range []string{"in place", "trash dir"} {
+		t.Run(layout, func(t *testing.T) {
+			dir := t.TempDir()
+			opts := SoftDeleteOptions{}
+			if layout == "trash dir" {
+				opts.TrashDir = filepath.Join(t.TempDir(), "trash")
+			}
+			enableSoftDelete(t, opts)
+			for _, base := range []string{"a", "b"} {
+				if err := os.WriteFile(filepath.Join(dir, base), make([]byte, 100), 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if err := Tag(filepath.Join(dir, "a"), "k", "v"); err != nil {
+				t.Fatal(err)
+			}
+			if err := SetQuota(dir, Quota{MaxBytes: 1000}); err != nil {
+				t.Fatal(err)
+			}
+			t.Cleanup(func() { RemoveQuota(dir) })
+
+			check := func(when string, want DirUsage) {
+				t.Helper()
+				if got, _ := Usage(dir); got != want {
+					t.Errorf("%s: usage = %+v, want %+v", when, got, want)
+				}
+				if err := RecountQuota(dir); err != nil {
+					t.Fatal(err)
+				}
+				if got, _ := Usage(dir); got != want {
+					t.Errorf("%s: recounted usage = %+v, want %+v", when, got, want)
+				}
+			}
+			check("before", DirUsage{Bytes: 200, Files: 2})
+
+			if err := Remove(filepath.Join(dir, "a")); err != nil {
+				t.Fatal(err)
+			}
+			check("after Remove", DirUsage{Bytes: 100, Files: 1})
+
+			if err := Undelete(filepath.Join(dir, "a")); err != nil {
+				t.Fatal(err)
+			}
+			check("after Undelete", DirUsage{Bytes: 200, Files: 2})
+		})
+	}
+}
+
+func TestUndeleteOverQuota(t *testing.T) {
+	dir := t.TempDir()
+	enableSoftDelete(t, SoftDeleteOptions{})
+	name := filepath.Join(dir, "a")
+	if err := os.WriteFile(name, make([]byte, 100), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := SetQuota(dir, Quota{MaxBytes: 150}); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { RemoveQuota(dir) })
+
+	if err := Delete(name); err != nil {
+		t.Fatal(err)
+	}
+	if err := WriteFile(filepath.Join(dir, "b"), make([]byte, 100), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := Undelete(name); !errors.Is(err, ErrQuotaExceeded) {
+		t.Fatalf("Undelete = %v, want ErrQuotaExceeded", err)
+	}
+	if files, err := DeletedFiles(); err != nil || len(files) != 1 {
+		t.Errorf("DeletedFiles after a refused Undelete = %+v, %v", files, err)
+	}
+	if got, _ := Usage(dir); got != (DirUsage{Bytes: 100, Files: 1}) {
+		t.Errorf("usage = %+v", got)
+	}
+}
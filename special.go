@@ -0,0 +1,48 @@
+package GMSFS
+
+import (
+	"errors"
+	"os"
+	"sync/atomic"
+)
+
+// ErrSpecialFile is returned when the special file guard stops a read or copy of a device
+// file, named pipe or socket
+var ErrSpecialFile = errors.New("special file")
+
+var specialFileGuard atomic.Bool
+
+// SetSpecialFileGuard makes ReadFile, CopyFile and the directory copies refuse device files,
+// named pipes and sockets with ErrSpecialFile instead of blocking on them or reading endless
+// data. Copies can still allow them with CopyOptions.AllowSpecialFiles. Disabled by default.
+func SetSpecialFileGuard(enabled bool) {
+	specialFileGuard.Store(enabled)
+}
+
+// SpecialFileGuard reports whether the special file guard is enabled
+func SpecialFileGuard() bool {
+	return specialFileGuard.Load()
+}
+
+// isSpecialMode reports whether mode is a device, named pipe or socket
+func isSpecialMode(mode os.FileMode) bool {
+	return mode&(os.ModeDevice|os.ModeCharDevice|os.ModeNamedPipe|os.ModeSocket) != 0
+}
+
+// guardSpecialFile fails for special files while the guard is enabled. It runs before the file is
+// opened because opening a named pipe already blocks.
+func guardSpecialFile(op string, name string) error {
+	if !specialFileGuard.Load() {
+		return nil
+	}
+	b, p := backendFor(name)
+	info, err := b.Stat(p)
+	if err != nil {
+		// Left to the operation itself to report
+		return nil
+	}
+	if isSpecialMode(info.Mode()) {
+		return &os.PathError{Op: op, Path: name, Err: ErrSpecialFile}
+	}
+	return nil
+}
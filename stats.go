@@ -0,0 +1,129 @@
+package GMSFS
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// Stats summarises a directory job for logging
+type Stats struct {
+	Files    int64 // Files copied or removed; links count as files
+	Dirs     int64 // Directories copied (created or merged into) or removed
+	Bytes    int64 // Content copied or freed
+	Skipped  int64 // Files left alone because the destination was up to date or kept
+	Errors   int64
+	Duration time.Duration
+}
+
+// Throughput returns the bytes handled per second
+func (s Stats) Throughput() float64 {
+	if s.Duration <= 0 {
+		return 0
+	}
+	return float64(s.Bytes) / s.Duration.Seconds()
+}
+
+func (s Stats) String() string {
+	return fmt.Sprintf("%d files, %d dirs, %s in %s (%s/s), %d skipped, %d errors",
+		s.Files, s.Dirs, formatBytes(float64(s.Bytes)), s.Duration.Round(time.Millisecond),
+		formatBytes(s.Throughput()), s.Skipped, s.Errors)
+}
+
+// formatBytes renders a byte count with a binary unit, e.g. "1.5 MiB"
+func formatBytes(n float64) string {
+	const units = "KMGTPE"
+	if n < 1024 {
+		return fmt.Sprintf("%.0f B", n)
+	}
+	i := -1
+	for n >= 1024 && i < len(units)-1 {
+		n /= 1024
+		i++
+	}
+	return fmt.Sprintf("%.1f %ciB", n, units[i])
+}
+
+// RemoveAllWithStats is RemoveAll returning what was removed. Like RemoveAll it removes as
+// much as it can and returns the first error, and a missing path is not an error.
+func RemoveAllWithStats(path string) (Stats, error) {
+	path = cleanPath(path)
+	if err := requireLocal("remove", path); err != nil {
+		errorPrinter("RemoveAllWithStats: "+err.Error(), path)
+		return Stats{}, err
+	}
+
+	start := time.Now()
+
+	simulateOp()
+	closeAppendHandlesUnder(path)
+
+	_, j := startJob(context.Background(), "RemoveAll", path, "")
+	defer j.done()
+
+	var stats Stats
+	var errs []error
+	removeTree(path, &stats, &errs, j)
+	stats.Duration = time.Since(start)
+
+	// The same bookkeeping as RemoveAll
+	invalidateStatTree(path)
+	quotaRecount(path)
+	if len(errs) == 0 {
+		dropTags(path)
+		forgetTemp(path)
+	}
+
+	if len(errs) > 0 {
+		errorPrinter("RemoveAllWithStats: "+errs[0].Error(), path)
+		return stats, errs[0]
+	}
+	return stats, nil
+}
+
+// removeTree removes path bottom-up, counting what goes
+func removeTree(path string, stats *Stats, errs *[]error, j *job) {
+	info, err := os.Lstat(path)
+	if os.IsNotExist(err) {
+		return
+	}
+	if err != nil {
+		stats.Errors++
+		*errs = append(*errs, err)
+		return
+	}
+
+	if info.IsDir() {
+		entries, err := os.ReadDir(path)
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			stats.Errors++
+			*errs = append(*errs, err)
+		}
+		for _, entry := range entries {
+			removeTree(filepath.Join(path, entry.Name()), stats, errs, j)
+		}
+	}
+
+	if err := os.Remove(path); err != nil {
+		if !os.IsNotExist(err) {
+			stats.Errors++
+			*errs = append(*errs, err)
+		}
+		return
+	}
+
+	if info.IsDir() {
+		stats.Dirs++
+	} else {
+		stats.Files++
+		if info.Mode().IsRegular() {
+			stats.Bytes += info.Size()
+			j.add(1, info.Size())
+		} else {
+			j.add(1, 0)
+		}
+	}
+}
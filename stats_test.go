@@ -0,0 +1,47 @@
+package GMSFS
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestRemoveAllWithStats(t *testing.T) {
+	dir := t.TempDir()
+	writeTestTree(t, dir, map[string]string{"keep.txt": "1234", "sub/a.txt": "12345", "sub/deep/b.txt": "123"})
+	sub := filepath.Join(dir, "sub")
+	if err := Tag(sub, "k", "v"); err != nil {
+		t.Fatal(err)
+	}
+	if err := SetQuota(dir, Quota{MaxBytes: 1000}); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { RemoveQuota(dir) })
+
+	stats, err := RemoveAllWithStats(sub)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.Files != 2 || stats.Dirs != 2 || stats.Bytes != 8 || stats.Errors != 0 {
+		t.Errorf("stats = %+v", stats)
+	}
+	if _, err := Stat(sub); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("Stat after removal = %v", err)
+	}
+
+	// Quota and tags are updated as RemoveAll does
+	if got, _ := Usage(dir); got != (DirUsage{Bytes: 4, Files: 1}) {
+		t.Errorf("usage = %+v, want 4 bytes in 1 file", got)
+	}
+	if err := os.Mkdir(sub, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if tags, err := GetTags(sub); err != nil || len(tags) != 0 {
+		t.Errorf("tags of a new directory in its place = %v, %v", tags, err)
+	}
+
+	if stats, err := RemoveAllWithStats(filepath.Join(dir, "missing")); err != nil || stats.Files != 0 {
+		t.Errorf("missing path = %+v, %v", stats, err)
+	}
+}
@@ -0,0 +1,94 @@
+package GMSFS
+
+import (
+	"os"
+	"path/filepath"
+)
+
+// Lstat is Stat without following a final symbolic link, which is reported with IsSymlink.
+// Results are not cached.
+func Lstat(name string) (FileInfo, error) {
+	name = cleanPath(name)
+
+	b, p := backendFor(name)
+	stat, err := b.Lstat(p)
+	if err != nil {
+		return FileInfo{}, opError("lstat", name, "", err)
+	}
+
+	return FileInfo{
+		Exists:       true,
+		Size:         stat.Size(),
+		Mode:         stat.Mode(),
+		LastModified: stat.ModTime(),
+		IsDir:        stat.IsDir(),
+		Name:         filepath.Base(name),
+		IsSymlink:    stat.Mode()&os.ModeSymlink != 0,
+	}, nil
+}
+
+// Symlink creates link as a symbolic link to target. target is stored as given, so a
+// relative target is resolved from the link's directory.
+func Symlink(target string, link string) error {
+	link = cleanPath(link)
+	if err := requireLocal("symlink", link); err != nil {
+		errorPrinter("Symlink: "+err.Error(), link)
+		return opError("symlink", target, link, err)
+	}
+
+	delta := DirUsage{Files: 1}
+	if err := quotaReserve(link, delta); err != nil {
+		errorPrinter("Symlink: "+err.Error(), link)
+		return opError("symlink", target, link, err)
+	}
+
+	err := os.Symlink(target, link)
+	if err != nil {
+		quotaRelease(link, delta)
+		errorPrinter("Symlink: "+err.Error(), link)
+		return opError("symlink", target, link, err)
+	}
+	invalidateStat(link)
+
+	return nil
+}
+
+// Readlink returns the target stored in the symbolic link name
+func Readlink(name string) (string, error) {
+	name = cleanPath(name)
+	if err := requireLocal("readlink", name); err != nil {
+		errorPrinter("Readlink: "+err.Error(), name)
+		return "", opError("readlink", name, "", err)
+	}
+
+	target, err := os.Readlink(name)
+	if err != nil {
+		errorPrinter("Readlink: "+err.Error(), name)
+		return "", opError("readlink", name, "", err)
+	}
+
+	return target, nil
+}
+
+// ResolvePath returns the absolute path of name with every symbolic link resolved, so two
+// names for the same file resolve to the same path. The file must exist.
+func ResolvePath(name string) (string, error) {
+	name = cleanPath(name)
+	if err := requireLocal("resolve", name); err != nil {
+		errorPrinter("ResolvePath: "+err.Error(), name)
+		return "", opError("resolve", name, "", err)
+	}
+
+	abs, err := filepath.Abs(name)
+	if err != nil {
+		errorPrinter("ResolvePath (filepath.Abs): "+err.Error(), name)
+		return "", opError("resolve", name, "", err)
+	}
+	resolved, err := filepath.EvalSymlinks(abs)
+	if err != nil {
+		errorPrinter("ResolvePath (filepath.EvalSymlinks): "+err.Error(), name)
+		return "", opError("resolve", name, "", err)
+	}
+
+	return resolved, nil
+}
@@ -0,0 +1,83 @@
+package GMSFS
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// DestinationLockMode decides what a directory copy does when another one, in this or
+// another process or host, is writing to the same destination
+type DestinationLockMode int
+
+const (
+	DestLockNone DestinationLockMode = iota // Don't coordinate
+	DestLockWait                            // Queue until the other copy is done
+	DestLockFail                            // Fail at once with ErrDestinationBusy
+)
+
+// DefaultDestinationLease is how long a destination lease lasts without renewal. Leases are
+// renewed at a third of it, so a copy whose process died blocks others for at most this long.
+const DefaultDestinationLease = DefaultLockFileTTL
+
+// ErrDestinationBusy is returned by directory copies with DestLockFail when another copy
+// holds the destination
+var ErrDestinationBusy = errors.New("destination is being written by another copy")
+
+// ErrLeaseLost ends a directory copy whose destination lease was taken over, because it
+// could not be renewed in time
+var ErrLeaseLost = errors.New("destination lease lost")
+
+// destLease is a held lease on a destination directory: a LockFile next to the destination,
+// named ".<name>.gmsfs-lock", so it exists before the destination does and is not part of
+// the tree
+type destLease struct {
+	lock   *LockFile
+	cancel context.CancelCauseFunc
+}
+
+// leaseName returns the lease file of the destination dst
+func leaseName(dst string) string {
+	return filepath.Join(filepath.Dir(dst), "."+filepath.Base(dst)+".gmsfs-lock")
+}
+
+// acquireDestLease takes the lease on dst according to mode and returns a context that is
+// cancelled with ErrLeaseLost when the lease is lost. A nil lease means mode is DestLockNone.
+func acquireDestLease(ctx context.Context, op string, src string, dst string, mode DestinationLockMode, ttl time.Duration) (*destLease, context.Context, error) {
+	if mode == DestLockNone {
+		return nil, ctx, nil
+	}
+
+	if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+		return nil, ctx, err
+	}
+
+	lock := newLockFile(leaseName(dst), ttl, op, src)
+	holder, err := lock.acquire(ctx, mode == DestLockWait)
+	if err != nil {
+		return nil, ctx, err
+	}
+	if holder != nil {
+		return nil, ctx, fmt.Errorf("%s: %w: %s", dst, ErrDestinationBusy, holder)
+	}
+
+	l := &destLease{lock: lock}
+	ctx, l.cancel = context.WithCancelCause(ctx)
+	lock.onLost = func() { l.cancel(ErrLeaseLost) }
+	lock.start()
+	return l, ctx, nil
+}
+
+// release gives up the lease
+func (l *destLease) release() {
+	if l == nil {
+		return
+	}
+	if err := l.lock.Release(); err != nil {
+		warnPrinter("Destination lease: "+err.Error(), l.lock.Name())
+	}
+	l.cancel(nil)
+}
@@ -0,0 +1,268 @@
+package GMSFS
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"maps"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+)
+
+// tagSidecar is the file in each directory holding the tags of its entries
+const tagSidecar = ".gmsfs-tags.json"
+
+// tagsMu serializes the read-modify-write of sidecars within this process
+var tagsMu sync.Mutex
+
+// tagIndex is the content of a sidecar: entry name to its tags
+type tagIndex map[string]map[string]string
+
+// Tag sets the tag key of name to value, for cataloging files without a database. Tags live
+// in a ".gmsfs-tags.json" sidecar next to the tagged entry rather than in extended
+// attributes, so they survive filesystems and tools that drop xattrs, and they follow the
+// entry through Rename, Move, CopyFile and Delete done with this package.
+func Tag(name string, key string, value string) error {
+	name = cleanPath(name)
+	if err := requireLocal("tag", name); err != nil {
+		errorPrinter("Tag: "+err.Error(), name)
+		return err
+	}
+	if key == "" {
+		return &os.PathError{Op: "tag", Path: name, Err: fmt.Errorf("empty tag key")}
+	}
+	if _, err := os.Lstat(name); err != nil {
+		errorPrinter("Tag: "+err.Error(), name)
+		return err
+	}
+
+	err := updateTags(filepath.Dir(name), func(idx tagIndex) {
+		base := filepath.Base(name)
+		if idx[base] == nil {
+			idx[base] = map[string]string{}
+		}
+		idx[base][key] = value
+	})
+	if err != nil {
+		errorPrinter("Tag: "+err.Error(), name)
+	}
+	return err
+}
+
+// Untag removes the tag key from name. Removing a tag that is not set is not an error.
+func Untag(name string, key string) error {
+	name = cleanPath(name)
+	if err := requireLocal("untag", name); err != nil {
+		errorPrinter("Untag: "+err.Error(), name)
+		return err
+	}
+
+	err := updateTags(filepath.Dir(name), func(idx tagIndex) {
+		base := filepath.Base(name)
+		delete(idx[base], key)
+		if len(idx[base]) == 0 {
+			delete(idx, base)
+		}
+	})
+	if err != nil {
+		errorPrinter("Untag: "+err.Error(), name)
+	}
+	return err
+}
+
+// GetTags returns the tags of name, empty when it has none
+func GetTags(name string) (map[string]string, error) {
+	name = cleanPath(name)
+	if err := requireLocal("tags", name); err != nil {
+		errorPrinter("GetTags: "+err.Error(), name)
+		return nil, err
+	}
+
+	tagsMu.Lock()
+	idx, err := readTags(filepath.Dir(name))
+	tagsMu.Unlock()
+	if err != nil {
+		errorPrinter("GetTags: "+err.Error(), name)
+		return nil, err
+	}
+
+	tags := maps.Clone(idx[filepath.Base(name)])
+	if tags == nil {
+		tags = map[string]string{}
+	}
+	return tags, nil
+}
+
+// FindByTag returns the sorted paths under root whose tag key is value, or that have the tag
+// key at all when value is empty. Entries listed in a sidecar that no longer exist, e.g.
+// after being deleted by another tool, are left out.
+func FindByTag(root string, key string, value string) ([]string, error) {
+	root = cleanPath(root)
+	if err := requireLocal("tags", root); err != nil {
+		errorPrinter("FindByTag: "+err.Error(), root)
+		return nil, err
+	}
+
+	found := []string{}
+	err := filepath.WalkDir(root, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() || d.Name() != tagSidecar {
+			return nil
+		}
+
+		dir := filepath.Dir(p)
+		tagsMu.Lock()
+		idx, err := readTags(dir)
+		tagsMu.Unlock()
+		if err != nil {
+			warnPrinter("FindByTag: "+err.Error(), p)
+			return nil
+		}
+		for base, tags := range idx {
+			v, ok := tags[key]
+			if !ok || value != "" && v != value {
+				continue
+			}
+			name := filepath.Join(dir, base)
+			if _, err := os.Lstat(name); err == nil {
+				found = append(found, name)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		errorPrinter("FindByTag: "+err.Error(), root)
+		return nil, err
+	}
+
+	sort.Strings(found)
+	return found, nil
+}
+
+// readTags reads the sidecar of dir; a missing one is an empty index. The caller holds tagsMu.
+func readTags(dir string) (tagIndex, error) {
+	data, err := os.ReadFile(filepath.Join(dir, tagSidecar))
+	if os.IsNotExist(err) {
+		return tagIndex{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	idx := tagIndex{}
+	if err := json.Unmarshal(data, &idx); err != nil {
+		return nil, fmt.Errorf("%s: %w", filepath.Join(dir, tagSidecar), err)
+	}
+	return idx, nil
+}
+
+// updateTags applies change to the sidecar of dir and writes it back, removing it once empty
+func updateTags(dir string, change func(tagIndex)) error {
+	tagsMu.Lock()
+	defer tagsMu.Unlock()
+
+	idx, err := readTags(dir)
+	if err != nil {
+		return err
+	}
+	change(idx)
+
+	sidecar := filepath.Join(dir, tagSidecar)
+	if len(idx) == 0 {
+		if err := os.Remove(sidecar); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		invalidateStat(sidecar)
+		return nil
+	}
+
+	content, err := json.MarshalIndent(idx, "", "  ")
+	if err != nil {
+		return err
+	}
+	err = writeUncountedFile(sidecar, content, 0644)
+	invalidateStat(sidecar)
+	return err
+}
+
+// hasTagSidecar reports whether the directory of name has a sidecar, so the hooks below cost
+// a single stat when tags are not used
+func hasTagSidecar(name string) bool {
+	if !isLocal(name) {
+		return false
+	}
+	_, err := os.Lstat(filepath.Join(filepath.Dir(name), tagSidecar))
+	return err == nil
+}
+
+// moveTags carries the tags of oldName over to newName after a rename. The tags of entries
+// inside a renamed directory need no update, their sidecars moving with it.
+func moveTags(oldName string, newName string) {
+	if !hasTagSidecar(oldName) && !hasTagSidecar(newName) {
+		return
+	}
+
+	var tags map[string]string
+	err := updateTags(filepath.Dir(oldName), func(idx tagIndex) {
+		base := filepath.Base(oldName)
+		tags = idx[base]
+		delete(idx, base)
+	})
+	if err == nil {
+		// The entry newName replaced loses its tags either way
+		err = updateTags(filepath.Dir(newName), func(idx tagIndex) {
+			base := filepath.Base(newName)
+			if tags == nil {
+				delete(idx, base)
+			} else {
+				idx[base] = tags
+			}
+		})
+	}
+	if err != nil {
+		warnPrinter("Tags (rename): "+err.Error(), oldName)
+	}
+}
+
+// copyTags gives dst the tags of src after a file copy
+func copyTags(src string, dst string) {
+	if !hasTagSidecar(src) && !hasTagSidecar(dst) {
+		return
+	}
+
+	tagsMu.Lock()
+	idx, err := readTags(filepath.Dir(src))
+	tagsMu.Unlock()
+	if err == nil {
+		tags := maps.Clone(idx[filepath.Base(src)])
+		err = updateTags(filepath.Dir(dst), func(idx tagIndex) {
+			base := filepath.Base(dst)
+			if tags == nil {
+				delete(idx, base)
+			} else {
+				idx[base] = tags
+			}
+		})
+	}
+	if err != nil {
+		warnPrinter("Tags (copy): "+err.Error(), dst)
+	}
+}
+
+// dropTags forgets the tags of name after it was deleted
+func dropTags(name string) {
+	if !hasTagSidecar(name) {
+		return
+	}
+
+	err := updateTags(filepath.Dir(name), func(idx tagIndex) {
+		delete(idx, filepath.Base(name))
+	})
+	if err != nil {
+		warnPrinter("Tags (delete): "+err.Error(), name)
+	}
+}
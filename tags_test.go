@@ -0,0 +1,153 @@
+package GMSFS
+
+import (
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+func checkTags(t *testing.T, name string, want map[string]string) {
+	t.Helper()
+	got, err := GetTags(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !maps.Equal(got, want) {
+		t.Errorf("GetTags(%s) = %v, want %v", filepath.Base(name), got, want)
+	}
+}
+
+func TestTags(t *testing.T) {
+	dir := t.TempDir()
+	writeTestTree(t, dir, map[string]string{"a.txt": "a", "b.txt": "b", "sub/c.txt": "c"})
+	a, b, c := filepath.Join(dir, "a.txt"), filepath.Join(dir, "b.txt"), filepath.Join(dir, "sub", "c.txt")
+
+	checkTags(t, a, map[string]string{})
+	for _, tag := range []struct{ name, key, value string }{
+		{a, "owner", "ops"}, {a, "tier", "hot"}, {b, "tier", "cold"}, {c, "tier", "hot"}, {filepath.Join(dir, "sub"), "kind", "dir"},
+	} {
+		if err := Tag(tag.name, tag.key, tag.value); err != nil {
+			t.Fatal(err)
+		}
+	}
+	checkTags(t, a, map[string]string{"owner": "ops", "tier": "hot"})
+
+	found, err := FindByTag(dir, "tier", "hot")
+	if err != nil || !slices.Equal(found, []string{a, c}) {
+		t.Errorf("FindByTag(tier=hot) = %v, %v", found, err)
+	}
+	if found, _ := FindByTag(dir, "tier", ""); len(found) != 3 {
+		t.Errorf("FindByTag(tier) = %v", found)
+	}
+	if found, _ := FindByTag(dir, "kind", "dir"); !slices.Equal(found, []string{filepath.Join(dir, "sub")}) {
+		t.Errorf("FindByTag(kind=dir) = %v", found)
+	}
+
+	if err := Untag(a, "tier"); err != nil {
+		t.Fatal(err)
+	}
+	if err := Untag(a, "never-set"); err != nil {
+		t.Errorf("Untag of an unset key: %v", err)
+	}
+	checkTags(t, a, map[string]string{"owner": "ops"})
+
+	// The sidecar goes once nothing in the directory is tagged
+	Untag(a, "owner")
+	Untag(b, "tier")
+	Untag(filepath.Join(dir, "sub"), "kind")
+	if _, err := os.Stat(filepath.Join(dir, tagSidecar)); !os.IsNotExist(err) {
+		t.Errorf("sidecar left behind: %v", err)
+	}
+}
+
+func TestTagErrors(t *testing.T) {
+	dir := t.TempDir()
+	writeTestTree(t, dir, map[string]string{"a.txt": "a"})
+	a := filepath.Join(dir, "a.txt")
+
+	if err := Tag(filepath.Join(dir, "missing"), "k", "v"); !os.IsNotExist(err) {
+		t.Errorf("Tag missing file: %v", err)
+	}
+	if err := Tag(a, "", "v"); err == nil {
+		t.Error("Tag with an empty key succeeded")
+	}
+	registerTestBackend(t, "tagmem")
+	if err := Tag("tagmem:/a", "k", "v"); err == nil {
+		t.Error("Tag on a backend succeeded")
+	}
+
+	if err := os.WriteFile(filepath.Join(dir, tagSidecar), []byte("{broken"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := GetTags(a); err == nil {
+		t.Error("GetTags with a corrupt sidecar succeeded")
+	}
+	if err := Tag(a, "k", "v"); err == nil {
+		t.Error("Tag with a corrupt sidecar succeeded")
+	}
+	// FindByTag skips the corrupt sidecar rather than failing
+	if found, err := FindByTag(dir, "k", ""); err != nil || len(found) != 0 {
+		t.Errorf("FindByTag = %v, %v", found, err)
+	}
+	if _, err := FindByTag(filepath.Join(dir, "missing"), "k", ""); err == nil {
+		t.Error("FindByTag of a missing root succeeded")
+	}
+}
+
+func TestTagsFollowFiles(t *testing.T) {
+	dir := t.TempDir()
+	writeTestTree(t, dir, map[string]string{"a.txt": "a", "b.txt": "b", "sub/c.txt": "c"})
+	a, b := filepath.Join(dir, "a.txt"), filepath.Join(dir, "b.txt")
+	for _, name := range []string{a, b, filepath.Join(dir, "sub", "c.txt")} {
+		if err := Tag(name, "name", filepath.Base(name)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	moved := filepath.Join(dir, "sub", "moved.txt")
+	if err := Rename(a, moved); err != nil {
+		t.Fatal(err)
+	}
+	checkTags(t, moved, map[string]string{"name": "a.txt"})
+	checkTags(t, a, map[string]string{})
+
+	copied := filepath.Join(dir, "copied.txt")
+	if err := CopyFile(b, copied); err != nil {
+		t.Fatal(err)
+	}
+	checkTags(t, copied, map[string]string{"name": "b.txt"})
+	checkTags(t, b, map[string]string{"name": "b.txt"})
+
+	// Replacing a tagged file with an untagged one drops the old tags
+	if err := WriteFile(a, []byte("new"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := Rename(a, copied); err != nil {
+		t.Fatal(err)
+	}
+	checkTags(t, copied, map[string]string{})
+
+	if err := Delete(b); err != nil {
+		t.Fatal(err)
+	}
+	if err := WriteFile(b, []byte("again"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	checkTags(t, b, map[string]string{})
+
+	// Entries in a renamed directory keep theirs through its sidecar
+	if err := Move(filepath.Join(dir, "sub"), filepath.Join(dir, "sub2")); err != nil {
+		t.Fatal(err)
+	}
+	checkTags(t, filepath.Join(dir, "sub2", "c.txt"), map[string]string{"name": "c.txt"})
+	checkTags(t, filepath.Join(dir, "sub2", "moved.txt"), map[string]string{"name": "a.txt"})
+
+	if err := RemoveAll(filepath.Join(dir, "sub2")); err != nil {
+		t.Fatal(err)
+	}
+	if found, err := FindByTag(dir, "name", ""); err != nil || len(found) != 0 {
+		t.Errorf("FindByTag after deletes = %v, %v", found, err)
+	}
+}
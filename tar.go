@@ -0,0 +1,536 @@
+package GMSFS
+
+import (
+	"archive/tar"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// TarOptions adjusts TarDir, WriteTar, UntarDir and ReadTar
+type TarOptions struct {
+	Compression Compression // Empty picks it from the archive name (.tar.gz, .tgz, .tar.zst); streams default to none
+	Level       int         // Compression level, 0 for the default; gzip accepts 1-9 and zstd 1-22
+	Include     []string    // Only files matching one of these globs; empty means all files
+	Exclude     []string    // Skip files and directories matching any of these globs
+	Overwrite   bool        // Let extraction replace existing files
+}
+
+// TarDir writes the contents of src to the tar archive dstTar, see WriteTar
+func TarDir(src string, dstTar string, opts TarOptions) error {
+	src = cleanPath(src)
+	dstTar = cleanPath(dstTar)
+	if err := requireLocal("tar", src, dstTar); err != nil {
+		errorPrinter("TarDir: "+err.Error(), src)
+		return err
+	}
+
+	if opts.Compression == "" {
+		opts.Compression = compressionForName(dstTar)
+	}
+
+	_, j := startJob(context.Background(), "TarDir", src, dstTar)
+	defer j.done()
+
+	// The archive and the entry being read
+	acquireFDs(2)
+	defer releaseFDs(2)
+
+	return createArchive("TarDir", dstTar, func(w io.Writer) error {
+		return writeTar(w, src, dstTar, opts)
+	})
+}
+
+// createArchive creates the file dst and has write fill it, removing it again on failure
+func createArchive(op string, dst string, write func(w io.Writer) error) (err error) {
+	simulateOp()
+	out, err := os.Create(dst)
+	if err != nil {
+		errorPrinter(op+" (os.Create): "+err.Error(), dst)
+		return err
+	}
+	defer func() {
+		if cerr := out.Close(); err == nil {
+			err = cerr
+		}
+		invalidateStat(dst)
+		if err != nil {
+			os.Remove(dst)
+		}
+	}()
+
+	return write(simulatedWriter(out))
+}
+
+// WriteTar streams the contents of src as a tar archive to w, e.g. straight into an upload.
+// Entry names are relative to src with forward slashes; modes, mtimes and symlinks are kept.
+// Globs work as for ZipDir.
+func WriteTar(w io.Writer, src string, opts TarOptions) error {
+	acquireFDs(1)
+	defer releaseFDs(1)
+
+	return writeTar(w, cleanPath(src), "", opts)
+}
+
+// writeTar archives src to w, leaving out skip, the archive itself when it's written inside src
+func writeTar(w io.Writer, src string, skip string, opts TarOptions) error {
+	if err := validateArchiveGlobs(opts.Include, opts.Exclude); err != nil {
+		return err
+	}
+
+	cw, err := compressWriter(w, opts.Compression, opts.Level)
+	if err != nil {
+		return err
+	}
+	tw := tar.NewWriter(cw)
+
+	err = walkArchive(src, skip, opts, func(name string, rel string, info os.FileInfo) error {
+		return tarEntry(tw, name, rel, info)
+	})
+	if err == nil {
+		err = tw.Close()
+	}
+	if cerr := cw.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		errorPrinter("WriteTar: "+err.Error(), src)
+	}
+	return err
+}
+
+// walkArchive calls fn for the files and directories below src that opts selects, leaving
+// out skip. rel is slash-separated and relative to src.
+func walkArchive(src string, skip string, opts TarOptions, fn func(name string, rel string, info os.FileInfo) error) error {
+	return filepath.WalkDir(src, func(name string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if name == src || name == skip {
+			return nil
+		}
+
+		rel, err := filepath.Rel(src, name)
+		if err != nil {
+			return err
+		}
+		rel = filepath.ToSlash(rel)
+
+		if archiveMatch(rel, opts.Exclude) {
+			if d.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !d.IsDir() && !archiveIncluded(rel, opts.Include) {
+			return nil
+		}
+		if d.IsDir() && len(opts.Include) > 0 {
+			return nil
+		}
+
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		return fn(name, rel, info)
+	})
+}
+
+// tarEntry adds one file, directory or symlink to the archive
+func tarEntry(tw *tar.Writer, name string, rel string, info os.FileInfo) error {
+	var link string
+	if info.Mode()&os.ModeSymlink != 0 {
+		target, err := os.Readlink(name)
+		if err != nil {
+			return err
+		}
+		link = filepath.ToSlash(target)
+	} else if !info.IsDir() && !info.Mode().IsRegular() {
+		return fmt.Errorf("tar: %s: %w", name, ErrSpecialFile)
+	}
+
+	header, err := tar.FileInfoHeader(info, link)
+	if err != nil {
+		return err
+	}
+	header.Name = rel
+	if info.IsDir() {
+		header.Name += "/"
+	}
+	// PAX keeps sub-second mtimes; access and change times would only make archives differ
+	header.Format = tar.FormatPAX
+	header.AccessTime = time.Time{}
+	header.ChangeTime = time.Time{}
+
+	if err := tw.WriteHeader(header); err != nil {
+		return err
+	}
+	if !info.Mode().IsRegular() {
+		return nil
+	}
+
+	simulateOp()
+	in, err := os.Open(name)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	_, err = io.Copy(tw, simulatedReader(in))
+	return err
+}
+
+// UntarDir extracts the tar archive srcTar into dstDir, see ReadTar
+func UntarDir(srcTar string, dstDir string, opts TarOptions) error {
+	srcTar = cleanPath(srcTar)
+	dstDir = cleanPath(dstDir)
+	if err := requireLocal("untar", srcTar, dstDir); err != nil {
+		errorPrinter("UntarDir: "+err.Error(), srcTar)
+		return err
+	}
+
+	_, j := startJob(context.Background(), "UntarDir", srcTar, dstDir)
+	defer j.done()
+
+	acquireFDs(2)
+	defer releaseFDs(2)
+
+	simulateOp()
+	in, err := os.Open(srcTar)
+	if err != nil {
+		errorPrinter("UntarDir (os.Open): "+err.Error(), srcTar)
+		return err
+	}
+	defer in.Close()
+
+	return readTar(simulatedReader(in), dstDir, opts, nil)
+}
+
+// ReadTar extracts a tar stream into dstDir. Gzip and zstd compression are detected from the
+// stream itself. Entries that would land outside dstDir are refused with ErrUnsafePath, as
+// for Unzip, and existing files are an error unless opts.Overwrite is set. Modes and mtimes
+// are restored, directories' after their contents.
+func ReadTar(r io.Reader, dstDir string, opts TarOptions) error {
+	acquireFDs(1)
+	defer releaseFDs(1)
+
+	return readTar(r, cleanPath(dstDir), opts, nil)
+}
+
+// readTar extracts r into dstDir, passing PAX global headers to global when it is set
+func readTar(r io.Reader, dstDir string, opts TarOptions, global func(*tar.Header) error) error {
+	if err := validateArchiveGlobs(opts.Include, opts.Exclude); err != nil {
+		return err
+	}
+
+	dr, err := decompressReader(r)
+	if err != nil {
+		errorPrinter("ReadTar: "+err.Error(), dstDir)
+		return err
+	}
+	defer dr.Close()
+
+	if err := os.MkdirAll(dstDir, 0755); err != nil {
+		errorPrinter("ReadTar (os.MkdirAll): "+err.Error(), dstDir)
+		return err
+	}
+	defer invalidateStatTree(dstDir)
+
+	var dirs []*tar.Header
+	tr := tar.NewReader(dr)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		switch {
+		case err != nil:
+		case header.Typeflag == tar.TypeXGlobalHeader && global != nil:
+			err = global(header)
+		default:
+			err = untarEntry(tr, header, dstDir, opts, &dirs)
+		}
+		if err != nil {
+			errorPrinter("ReadTar: "+err.Error(), dstDir)
+			return err
+		}
+	}
+
+	untarDirAttrs(dstDir, dirs)
+	return nil
+}
+
+// untarDirAttrs applies the modes and mtimes of the directories untarEntry collected, deepest
+// first, so restoring a parent's mtime isn't undone by its children
+func untarDirAttrs(dstDir string, dirs []*tar.Header) {
+	for i := len(dirs) - 1; i >= 0; i-- {
+		target := filepath.Join(dstDir, filepath.FromSlash(dirs[i].Name))
+		os.Chmod(target, dirs[i].FileInfo().Mode())
+		os.Chtimes(target, dirs[i].ModTime, dirs[i].ModTime)
+	}
+}
+
+// untarEntry extracts one entry if the options select it; directories are collected in dirs
+// to have their attributes applied at the end
+func untarEntry(tr *tar.Reader, header *tar.Header, dstDir string, opts TarOptions, dirs *[]*tar.Header) error {
+	switch header.Typeflag {
+	case tar.TypeXGlobalHeader, tar.TypeXHeader:
+		return nil
+	}
+
+	rel, err := archiveEntryPath(header.Name)
+	if err != nil {
+		return err
+	}
+	if rel == "." || archiveExcludedPath(rel, opts.Exclude) {
+		return nil
+	}
+	if header.Typeflag != tar.TypeDir && !archiveIncluded(rel, opts.Include) {
+		return nil
+	}
+	if err := archiveParentsSafe(dstDir, rel); err != nil {
+		return err
+	}
+
+	target := filepath.Join(dstDir, filepath.FromSlash(rel))
+	mode := header.FileInfo().Mode()
+
+	if header.Typeflag == tar.TypeDir {
+		if err := os.MkdirAll(target, 0700); err != nil {
+			return err
+		}
+		header.Name = rel
+		*dirs = append(*dirs, header)
+		return nil
+	}
+
+	if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+		return err
+	}
+	if err := archiveReplace(target, opts.Overwrite); err != nil {
+		return err
+	}
+
+	switch header.Typeflag {
+	case tar.TypeSymlink:
+		if err := archiveLinkSafe(dstDir, rel, header.Linkname); err != nil {
+			return err
+		}
+		return os.Symlink(filepath.FromSlash(header.Linkname), target)
+
+	case tar.TypeLink:
+		old, err := archiveEntryPath(header.Linkname)
+		if err != nil {
+			return err
+		}
+		if err := archiveParentsSafe(dstDir, old); err != nil {
+			return err
+		}
+		return os.Link(filepath.Join(dstDir, filepath.FromSlash(old)), target)
+
+	case tar.TypeReg:
+	default:
+		return fmt.Errorf("tar: %s: %w", header.Name, ErrSpecialFile)
+	}
+
+	simulateOp()
+	out, err := os.OpenFile(target, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, mode.Perm())
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(simulatedWriter(out), tr)
+	if cerr := out.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return err
+	}
+
+	// Bypass the umask and restore setuid, setgid and sticky bits
+	if err := os.Chmod(target, mode); err != nil {
+		return err
+	}
+	return os.Chtimes(target, header.ModTime, header.ModTime)
+}
+
+// PAX global header records of incremental archives
+const (
+	paxIncremental = "GMSFS.incremental" // Creation time of the base manifest
+	paxDeleted     = "GMSFS.deleted"     // JSON list of paths to remove before extracting
+)
+
+// TarDirIncremental writes what changed in src since base to the tar archive dstTar and
+// returns the manifest of src to pass as base next time. Directories are always archived,
+// files and symlinks when they are new or their size, mode, mtime or target changed. Paths
+// that base has and src no longer has are listed in the archive, so ApplyIncremental removes
+// them. An empty base gives a full archive to start a chain with. opts works as for TarDir
+// and should stay the same along a chain. UntarDir extracts an incremental archive's files
+// but ignores its deletions.
+func TarDirIncremental(src string, base Manifest, dstTar string, opts TarOptions) (Manifest, error) {
+	src = cleanPath(src)
+	dstTar = cleanPath(dstTar)
+	if err := requireLocal("tar", src, dstTar); err != nil {
+		errorPrinter("TarDirIncremental: "+err.Error(), src)
+		return Manifest{}, err
+	}
+	if err := validateArchiveGlobs(opts.Include, opts.Exclude); err != nil {
+		return Manifest{}, err
+	}
+	if opts.Compression == "" {
+		opts.Compression = compressionForName(dstTar)
+	}
+
+	_, j := startJob(context.Background(), "TarDirIncremental", src, dstTar)
+	defer j.done()
+
+	m, infos, err := buildManifest(src, dstTar, opts)
+	if err != nil {
+		errorPrinter("TarDirIncremental: "+err.Error(), src)
+		return Manifest{}, err
+	}
+
+	// Paths that changed between a directory and something else go too, so the new entry
+	// can take their place
+	var deleted []string
+	for rel, prev := range base.Files {
+		cur, ok := m.Files[rel]
+		if !ok || cur.Mode.IsDir() != prev.Mode.IsDir() {
+			deleted = append(deleted, rel)
+		}
+	}
+	sort.Strings(deleted)
+	deletedJSON, err := json.Marshal(deleted)
+	if err != nil {
+		return Manifest{}, err
+	}
+
+	rels := make([]string, 0, len(m.Files))
+	for rel := range m.Files {
+		rels = append(rels, rel)
+	}
+	sort.Strings(rels)
+
+	acquireFDs(2)
+	defer releaseFDs(2)
+
+	err = createArchive("TarDirIncremental", dstTar, func(w io.Writer) error {
+		cw, err := compressWriter(w, opts.Compression, opts.Level)
+		if err != nil {
+			return err
+		}
+		tw := tar.NewWriter(cw)
+
+		err = tw.WriteHeader(&tar.Header{
+			Typeflag: tar.TypeXGlobalHeader,
+			Format:   tar.FormatPAX,
+			PAXRecords: map[string]string{
+				paxIncremental: base.Created.UTC().Format(time.RFC3339Nano),
+				paxDeleted:     string(deletedJSON),
+			},
+		})
+		for _, rel := range rels {
+			if err != nil {
+				break
+			}
+			cur := m.Files[rel]
+			if prev, ok := base.Files[rel]; ok && !cur.Mode.IsDir() && !cur.Changed(prev) {
+				continue
+			}
+			err = tarEntry(tw, filepath.Join(src, filepath.FromSlash(rel)), rel, infos[rel])
+			if err == nil && !cur.Mode.IsDir() {
+				j.add(1, cur.Size)
+			}
+		}
+
+		if err == nil {
+			err = tw.Close()
+		}
+		if cerr := cw.Close(); err == nil {
+			err = cerr
+		}
+		return err
+	})
+	if err != nil {
+		errorPrinter("TarDirIncremental: "+err.Error(), src)
+		return Manifest{}, err
+	}
+	return m, nil
+}
+
+// ApplyIncremental restores a chain of archives written by TarDirIncremental into dstDir:
+// the full archive first, then the incremental ones in the order they were taken. Later
+// archives replace files of earlier ones, and paths deleted in between are removed again.
+func ApplyIncremental(dstDir string, archives ...string) error {
+	dstDir = cleanPath(dstDir)
+	if err := requireLocal("untar", dstDir); err != nil {
+		errorPrinter("ApplyIncremental: "+err.Error(), dstDir)
+		return err
+	}
+
+	_, j := startJob(context.Background(), "ApplyIncremental", strings.Join(archives, ", "), dstDir)
+	defer j.done()
+
+	acquireFDs(2)
+	defer releaseFDs(2)
+
+	for _, name := range archives {
+		name = cleanPath(name)
+		if err := requireLocal("untar", name); err != nil {
+			errorPrinter("ApplyIncremental: "+err.Error(), name)
+			return err
+		}
+
+		simulateOp()
+		in, err := os.Open(name)
+		if err != nil {
+			errorPrinter("ApplyIncremental (os.Open): "+err.Error(), name)
+			return err
+		}
+		err = readTar(simulatedReader(in), dstDir, TarOptions{Overwrite: true}, func(h *tar.Header) error {
+			return applyDeletions(dstDir, h)
+		})
+		in.Close()
+		if err != nil {
+			return err
+		}
+		j.add(1, 0)
+	}
+	return nil
+}
+
+// applyDeletions removes the paths an incremental archive's global header lists
+func applyDeletions(dstDir string, h *tar.Header) error {
+	list, ok := h.PAXRecords[paxDeleted]
+	if !ok {
+		return nil
+	}
+	var deleted []string
+	if err := json.Unmarshal([]byte(list), &deleted); err != nil {
+		return fmt.Errorf("tar: %s record: %w", paxDeleted, err)
+	}
+
+	for _, name := range deleted {
+		rel, err := archiveEntryPath(name)
+		if err != nil {
+			return err
+		}
+		if rel == "." {
+			continue
+		}
+		if err := archiveParentsSafe(dstDir, rel); err != nil {
+			return err
+		}
+		if err := os.RemoveAll(filepath.Join(dstDir, filepath.FromSlash(rel))); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,196 @@
+package GMSFS
+
+import (
+	"archive/tar"
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// writeTestTar returns a tar stream holding entries
+func writeTestTar(t *testing.T, entries []testEntry) *bytes.Buffer {
+	t.Helper()
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	for _, e := range entries {
+		h := &tar.Header{Name: e.name, Typeflag: tar.TypeReg, Mode: 0644, Size: int64(len(e.content))}
+		if e.link {
+			h = &tar.Header{Name: e.name, Typeflag: tar.TypeSymlink, Mode: 0777, Linkname: e.content}
+		}
+		if err := tw.WriteHeader(h); err != nil {
+			t.Fatal(err)
+		}
+		if !e.link {
+			if _, err := tw.Write([]byte(e.content)); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return &buf
+}
+
+// writeTestTree creates files below dir from a map of slash-separated names to contents
+func writeTestTree(t *testing.T, dir string, files map[string]string) {
+	t.Helper()
+	for name, content := range files {
+		p := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(p, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestTarRoundTrip(t *testing.T) {
+	for _, ext := range []string{".tar", ".tar.gz", ".tar.zst"} {
+		t.Run(ext, func(t *testing.T) {
+			src := t.TempDir()
+			writeTestTree(t, src, map[string]string{"a.txt": "a", "sub/b.log": "b", "sub/deep/c.txt": "c"})
+			mtime := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+			if err := os.Chtimes(filepath.Join(src, "a.txt"), mtime, mtime); err != nil {
+				t.Fatal(err)
+			}
+
+			archive := filepath.Join(t.TempDir(), "out"+ext)
+			if err := TarDir(src, archive, TarOptions{Exclude: []string{"*.log"}}); err != nil {
+				t.Fatal(err)
+			}
+			dst := t.TempDir()
+			if err := UntarDir(archive, dst, TarOptions{}); err != nil {
+				t.Fatal(err)
+			}
+
+			for name, want := range map[string]string{"a.txt": "a", "sub/deep/c.txt": "c"} {
+				if data, err := os.ReadFile(filepath.Join(dst, filepath.FromSlash(name))); err != nil || string(data) != want {
+					t.Errorf("%s = %q, %v", name, data, err)
+				}
+			}
+			if _, err := os.Stat(filepath.Join(dst, "sub", "b.log")); !os.IsNotExist(err) {
+				t.Errorf("excluded file extracted: %v", err)
+			}
+			if info, err := os.Stat(filepath.Join(dst, "a.txt")); err != nil || !info.ModTime().Equal(mtime) {
+				t.Errorf("mtime = %v, %v, want %v", info, err, mtime)
+			}
+
+			if err := UntarDir(archive, dst, TarOptions{}); !errors.Is(err, os.ErrExist) {
+				t.Errorf("UntarDir over existing files = %v, want ErrExist", err)
+			}
+		})
+	}
+}
+
+func TestReadTarRefusesUnsafeEntries(t *testing.T) {
+	requireSymlinks(t)
+	tests := []struct {
+		name    string
+		entries []testEntry
+	}{
+		{"parent", []testEntry{{name: "../evil.txt"}}},
+		{"absolute link", []testEntry{{name: "l", content: "/etc", link: true}}},
+		{"climbing link", []testEntry{{name: "sub/l", content: "../../x", link: true}}},
+		{"link then ..", []testEntry{
+			{name: "s1", content: ".", link: true},
+			{name: "s2", content: "s1/..", link: true},
+		}},
+		{"link made later", []testEntry{
+			{name: "s2", content: "d/..", link: true},
+			{name: "d", content: ".", link: true},
+		}},
+		{"through a link", []testEntry{
+			{name: "s1", content: ".", link: true},
+			{name: "s1/x.txt", content: "x"},
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			base := t.TempDir()
+			dst := filepath.Join(base, "dst")
+			if err := ReadTar(writeTestTar(t, tt.entries), dst, TarOptions{}); !errors.Is(err, ErrUnsafePath) {
+				t.Errorf("ReadTar = %v, want ErrUnsafePath", err)
+			}
+			if entries, _ := os.ReadDir(base); len(entries) != 1 {
+				t.Errorf("extracted next to the destination: %v", entries)
+			}
+		})
+	}
+}
+
+func TestReadTarLinkChain(t *testing.T) {
+	requireSymlinks(t)
+	dst := t.TempDir()
+	stream := writeTestTar(t, []testEntry{
+		{name: "lib.so.1.2", content: "lib"},
+		{name: "lib.so.1", content: "lib.so.1.2", link: true},
+		{name: "lib.so", content: "lib.so.1", link: true},
+		{name: "sub/up", content: "../lib.so", link: true},
+	})
+	if err := ReadTar(stream, dst, TarOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	if data, err := os.ReadFile(filepath.Join(dst, "sub", "up")); err != nil || string(data) != "lib" {
+		t.Errorf("sub/up = %q, %v", data, err)
+	}
+}
+
+func TestApplyIncremental(t *testing.T) {
+	src := t.TempDir()
+	archives := t.TempDir()
+	writeTestTree(t, src, map[string]string{"keep.txt": "1", "gone.txt": "1", "change.txt": "1"})
+
+	full := filepath.Join(archives, "0.tar")
+	m, err := TarDirIncremental(src, Manifest{}, full, TarOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := os.Remove(filepath.Join(src, "gone.txt")); err != nil {
+		t.Fatal(err)
+	}
+	writeTestTree(t, src, map[string]string{"change.txt": "22", "new.txt": "3"})
+	incr := filepath.Join(archives, "1.tar")
+	if _, err := TarDirIncremental(src, m, incr, TarOptions{}); err != nil {
+		t.Fatal(err)
+	}
+
+	dst := t.TempDir()
+	if err := ApplyIncremental(dst, full, incr); err != nil {
+		t.Fatal(err)
+	}
+	for name, want := range map[string]string{"keep.txt": "1", "change.txt": "22", "new.txt": "3"} {
+		if data, err := os.ReadFile(filepath.Join(dst, name)); err != nil || string(data) != want {
+			t.Errorf("%s = %q, %v", name, data, err)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(dst, "gone.txt")); !os.IsNotExist(err) {
+		t.Errorf("deleted file restored: %v", err)
+	}
+}
+
+func TestApplyIncrementalRefusesLinkChain(t *testing.T) {
+	requireSymlinks(t)
+	base := t.TempDir()
+	dst := filepath.Join(base, "dst")
+	archive := filepath.Join(t.TempDir(), "0.tar")
+	stream := writeTestTar(t, []testEntry{
+		{name: "s1", content: ".", link: true},
+		{name: "s2", content: "s1/..", link: true},
+	})
+	if err := os.WriteFile(archive, stream.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := ApplyIncremental(dst, archive); !errors.Is(err, ErrUnsafePath) {
+		t.Errorf("ApplyIncremental = %v, want ErrUnsafePath", err)
+	}
+	if _, err := os.Lstat(filepath.Join(dst, "s2")); !os.IsNotExist(err) {
+		t.Errorf("s2 extracted: %v", err)
+	}
+}
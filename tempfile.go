@@ -0,0 +1,131 @@
+package GMSFS
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// tempPath is a file or directory made by TempFile or TempDir, removed by Close unless the
+// caller removed it first
+type tempPath struct {
+	name string
+	file *os.File // Open file of TempFile, closed before removing
+}
+
+var temps = struct {
+	mu    sync.Mutex
+	paths map[string]*tempPath
+}{paths: map[string]*tempPath{}}
+
+// TempFile creates a new file in dir, os.TempDir() when empty, opened for reading and writing,
+// with a name made from pattern as os.CreateTemp does. The file is removed by Close, so
+// nothing is left behind on shutdown; remove it with Remove or Delete once done with it.
+func TempFile(dir string, pattern string) (*os.File, error) {
+	dir = tempParent(dir)
+	if err := requireLocal("create", dir); err != nil {
+		return nil, err
+	}
+	simulateOp()
+
+	f, err := os.CreateTemp(dir, pattern)
+	if err != nil {
+		errorPrinter("TempFile: "+err.Error(), dir)
+		return nil, opError("create", dir, "", err)
+	}
+	registerTemp(&tempPath{name: f.Name(), file: f})
+	return f, nil
+}
+
+// TempDir creates a new directory in dir, os.TempDir() when empty, named from pattern as
+// os.MkdirTemp does, and returns its path. It is removed with its contents by Close; remove it
+// with RemoveAll once done with it, or use WithTempDir.
+func TempDir(dir string, pattern string) (string, error) {
+	dir = tempParent(dir)
+	if err := requireLocal("mkdir", dir); err != nil {
+		return "", err
+	}
+	simulateOp()
+
+	name, err := os.MkdirTemp(dir, pattern)
+	if err != nil {
+		errorPrinter("TempDir: "+err.Error(), dir)
+		return "", opError("mkdir", dir, "", err)
+	}
+	registerTemp(&tempPath{name: name})
+	return name, nil
+}
+
+// WithTempDir calls fn with a new temporary directory and removes the directory and what fn
+// left in it when fn returns or panics. It returns the error of fn, or of the removal.
+func WithTempDir(fn func(dir string) error) (err error) {
+	dir, err := TempDir("", "gmsfs-*")
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if rerr := RemoveAll(dir); rerr != nil {
+			errorPrinter("WithTempDir: "+rerr.Error(), dir)
+			if err == nil {
+				err = rerr
+			}
+		}
+	}()
+	return fn(dir)
+}
+
+func tempParent(dir string) string {
+	if dir == "" {
+		return os.TempDir()
+	}
+	return cleanPath(dir)
+}
+
+func registerTemp(t *tempPath) {
+	temps.mu.Lock()
+	temps.paths[t.name] = t
+	temps.mu.Unlock()
+	registerCloser(t)
+}
+
+// forgetTemp drops the temporary paths at or below name, which the caller removed
+func forgetTemp(name string) {
+	temps.mu.Lock()
+	if len(temps.paths) == 0 {
+		temps.mu.Unlock()
+		return
+	}
+	name = filepath.Clean(name)
+	var gone []*tempPath
+	for p, t := range temps.paths {
+		if hasPathPrefix(p, name) {
+			delete(temps.paths, p)
+			gone = append(gone, t)
+		}
+	}
+	temps.mu.Unlock()
+
+	for _, t := range gone {
+		unregisterCloser(t)
+	}
+}
+
+// Close removes the temporary file or directory
+func (t *tempPath) Close() error {
+	temps.mu.Lock()
+	delete(temps.paths, t.name)
+	temps.mu.Unlock()
+	unregisterCloser(t)
+
+	if t.file != nil {
+		t.file.Close()
+	}
+	err := os.RemoveAll(t.name)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		errorPrinter("Temp cleanup: "+err.Error(), t.name)
+		return opError("remove", t.name, "", err)
+	}
+	invalidateStatTree(t.name)
+	return nil
+}
@@ -0,0 +1,99 @@
+package GMSFS
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/template"
+)
+
+// TemplateSuffix is dropped from output names by RenderTemplateTree
+const TemplateSuffix = ".tmpl"
+
+// RenderTemplateFile executes the text/template at tmplPath with data and atomically writes
+// the result to dst. Referencing a missing map key is an error rather than "<no value>".
+func RenderTemplateFile(tmplPath string, dst string, data any, perm os.FileMode) error {
+	tmplPath = cleanPath(tmplPath)
+
+	content, err := ReadFile(tmplPath)
+	if err != nil {
+		return err
+	}
+
+	var out bytes.Buffer
+	if err := renderTemplate(tmplPath, content, data, &out); err != nil {
+		errorPrinter("RenderTemplateFile: "+err.Error(), tmplPath)
+		return err
+	}
+
+	return writeFileAtomic(dst, out.Bytes(), perm)
+}
+
+// RenderTemplateTree renders every file below srcDir to the same relative path below dstDir,
+// keeping file modes and dropping a ".tmpl" suffix from names. Every file is rendered before
+// anything is written, so a template error leaves dstDir untouched.
+func RenderTemplateTree(srcDir string, dstDir string, data any) error {
+	srcDir = cleanPath(srcDir)
+	dstDir = cleanPath(dstDir)
+
+	type rendered struct {
+		dst     string
+		mode    os.FileMode
+		isDir   bool
+		content []byte
+	}
+	var outputs []rendered
+
+	err := Walk(srcDir, func(name string, info FileInfo) error {
+		rel, err := filepath.Rel(srcDir, name)
+		if err != nil {
+			return err
+		}
+		dst := filepath.Join(dstDir, strings.TrimSuffix(rel, TemplateSuffix))
+
+		if info.IsDir {
+			outputs = append(outputs, rendered{dst: dst, mode: info.Mode.Perm(), isDir: true})
+			return nil
+		}
+		if !info.Mode.IsRegular() {
+			return nil
+		}
+
+		content, err := ReadFile(name)
+		if err != nil {
+			return err
+		}
+		var out bytes.Buffer
+		if err := renderTemplate(name, content, data, &out); err != nil {
+			return err
+		}
+		outputs = append(outputs, rendered{dst: dst, mode: info.Mode.Perm(), content: out.Bytes()})
+		return nil
+	})
+	if err != nil {
+		errorPrinter("RenderTemplateTree: "+err.Error(), srcDir)
+		return err
+	}
+
+	for _, o := range outputs {
+		if o.isDir {
+			err = MkdirAll(o.dst, o.mode)
+		} else {
+			err = writeFileAtomic(o.dst, o.content, o.mode)
+		}
+		if err != nil {
+			errorPrinter("RenderTemplateTree: "+err.Error(), o.dst)
+			return err
+		}
+	}
+	return nil
+}
+
+func renderTemplate(name string, content []byte, data any, out *bytes.Buffer) error {
+	tmpl, err := template.New(filepath.Base(name)).Option("missingkey=error").Parse(string(content))
+	if err != nil {
+		return err
+	}
+	return tmpl.Execute(out, data)
+}
@@ -0,0 +1,599 @@
+package GMSFS
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// stubMagic starts every stub file tiering leaves behind
+const stubMagic = "GMSFS-STUB 1\n"
+
+// maxStubSize is the largest file looked at as a possible stub
+const maxStubSize = 64 << 10
+
+// ErrNotStub is returned by RecallFile for a file that is not a stub, or not one left under
+// the Root of a known tiering rule
+var ErrNotStub = errors.New("not a stub")
+
+// maxStubPrealloc bounds the buffer ReadFile sizes up front from a stub's Size
+const maxStubPrealloc = 64 << 20
+
+// tierRules holds the rules whose stubs are read through, see SetTierRules
+var tierRules = struct {
+	mu    sync.Mutex
+	rules atomic.Pointer[[]TierRule]
+}{}
+
+// Stub describes a file that tiering moved to an archive, as recorded in the small file left
+// at its old path
+type Stub struct {
+	Location    string      `json:"location"` // The archived copy, local or on a registered backend
+	Compression Compression `json:"compression,omitempty"`
+	Size        int64       `json:"size"` // Of the original content
+	ModTime     time.Time   `json:"mod_time"`
+	Mode        os.FileMode `json:"mode"`
+	SHA256      string      `json:"sha256"` // Of the original content, hex encoded
+	Archived    time.Time   `json:"archived"`
+}
+
+// TierRule moves files that have gone cold to cheaper storage, leaving a stub in their place
+// that ReadFile reads through
+type TierRule struct {
+	Root      string        // Local tree the rule applies to, e.g. /data
+	Pattern   string        // Glob for the path below Root or the base name, as in archives; empty matches all
+	OlderThan time.Duration // Files not modified for this long are moved
+	Archive   string        // Where the files go, keeping their path below Root; local or on a registered backend
+	Compress  Compression   // Compress the archived copies; empty keeps them as they are
+}
+
+// problems returns what is wrong with r, or nil
+func (r TierRule) problems() error {
+	switch {
+	case r.Root == "" || r.Archive == "":
+		return errors.New("Root and Archive are required")
+	case r.OlderThan < 0:
+		return errors.New("negative OlderThan")
+	case isLocal(r.Archive) && hasPathPrefix(cleanPath(r.Archive), cleanPath(r.Root)):
+		return errors.New("Archive is inside Root")
+	}
+	switch r.Compress {
+	case "", CompressionNone, CompressionGzip, CompressionZstd:
+	default:
+		return fmt.Errorf("unsupported compression %q", r.Compress)
+	}
+	patterns, err := expandBraces(r.Pattern)
+	if err != nil {
+		return fmt.Errorf("bad pattern %q: %w", r.Pattern, err)
+	}
+	for _, p := range patterns {
+		if _, err := path.Match(p, ""); err != nil {
+			return fmt.Errorf("bad pattern %q: %w", r.Pattern, err)
+		}
+	}
+	return requireLocal("tier", cleanPath(r.Root))
+}
+
+// Tiering runs tiering rules on a schedule, see StartTiering
+type Tiering struct {
+	rules    []TierRule
+	interval time.Duration
+
+	ctx    context.Context
+	cancel context.CancelFunc
+	once   sync.Once
+	wg     sync.WaitGroup
+}
+
+// StartTiering runs a pass of rules, as RunTiering does, every interval (an hour when 0) until
+// Close. Failures are logged and retried on the next pass.
+func StartTiering(rules []TierRule, interval time.Duration) (*Tiering, error) {
+	for _, r := range rules {
+		if err := r.problems(); err != nil {
+			return nil, fmt.Errorf("StartTiering: %s: %w", r.Root, err)
+		}
+	}
+	addTierRules(rules)
+	if interval <= 0 {
+		interval = time.Hour
+	}
+
+	t := &Tiering{rules: append([]TierRule(nil), rules...), interval: interval}
+	t.ctx, t.cancel = context.WithCancel(context.Background())
+	registerCloser(t)
+	t.wg.Add(1)
+	go t.run()
+	return t, nil
+}
+
+func (t *Tiering) run() {
+	defer t.wg.Done()
+
+	ticker := time.NewTicker(t.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-t.ctx.Done():
+			return
+		case <-ticker.C:
+			stats, err := RunTiering(t.ctx, t.rules)
+			if err != nil && t.ctx.Err() == nil {
+				warnPrinter("Tiering: "+err.Error(), "")
+			}
+			if stats.Files > 0 {
+				infoPrinter(fmt.Sprintf("Tiering: archived %d files, %d bytes", stats.Files, stats.Bytes), "")
+			}
+		}
+	}
+}
+
+// Close stops the schedule, interrupting a pass in progress
+func (t *Tiering) Close() error {
+	t.once.Do(func() {
+		t.cancel()
+		t.wg.Wait()
+		unregisterCloser(t)
+	})
+	return nil
+}
+
+// RunTiering applies rules once: every regular file below a rule's Root that matches its
+// Pattern and was last modified more than OlderThan ago is copied to Archive, compressed if
+// asked, and replaced by a stub keeping its mode and modification time. A file that changes
+// while it is copied is left alone. Stubs, symlinks and files soft deleted next to where they
+// were are skipped. Each rule shows up in Jobs while it runs.
+func RunTiering(ctx context.Context, rules []TierRule) (Stats, error) {
+	start := time.Now()
+	var stats Stats
+	var errs []error
+	for _, r := range rules {
+		if err := r.problems(); err != nil {
+			return stats, fmt.Errorf("RunTiering: %s: %w", r.Root, err)
+		}
+		addTierRules([]TierRule{r})
+		s, err := tierTree(ctx, r)
+		stats.Files += s.Files
+		stats.Bytes += s.Bytes
+		stats.Skipped += s.Skipped
+		stats.Errors += s.Errors
+		if err != nil {
+			errs = append(errs, err)
+		}
+		if ctx.Err() != nil {
+			break
+		}
+	}
+	stats.Duration = time.Since(start)
+	return stats, errors.Join(errs...)
+}
+
+func tierTree(ctx context.Context, r TierRule) (Stats, error) {
+	root := cleanPath(r.Root)
+	ctx, j := startJob(ctx, "Tiering", root, r.Archive)
+	defer j.done()
+
+	var stats Stats
+	var errs []error
+	cutoff := time.Now().Add(-r.OlderThan)
+	err := filepath.WalkDir(root, func(name string, d fs.DirEntry, err error) error {
+		if err != nil {
+			errs = append(errs, err)
+			return nil
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if isSoftDeleteDir(d) {
+			return filepath.SkipDir
+		}
+		if !d.Type().IsRegular() {
+			return nil
+		}
+		rel, ok := relWithin(root, name)
+		if !ok || r.Pattern != "" && !archiveMatch(filepath.ToSlash(rel), []string{r.Pattern}) {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil || !info.ModTime().Before(cutoff) {
+			return nil
+		}
+		if _, ok, _ := ReadStub(name); ok {
+			return nil
+		}
+
+		archived, err := tierFile(ctx, name, joinName(r.Archive, rel), r.Compress, info)
+		switch {
+		case err != nil:
+			errorPrinterCtx(ctx, "Tiering: "+err.Error(), name)
+			errs = append(errs, opError("tier", name, "", err))
+			stats.Errors++
+		case archived:
+			stats.Files++
+			stats.Bytes += info.Size()
+			j.add(1, 0)
+		default:
+			stats.Skipped++
+		}
+		return nil
+	})
+	if err != nil {
+		errs = append(errs, err)
+	}
+	return stats, errors.Join(errs...)
+}
+
+// tierFile copies name to loc and replaces it with a stub, reporting false when name changed
+// meanwhile
+func tierFile(ctx context.Context, name string, loc string, c Compression, info os.FileInfo) (bool, error) {
+	switch c {
+	case CompressionGzip:
+		loc += ".gz"
+	case CompressionZstd:
+		loc += ".zst"
+	default:
+		c = ""
+	}
+
+	sum, err := writeArchived(ctx, name, loc, c)
+	if err != nil {
+		return false, err
+	}
+
+	// Appends after the copy would be lost under the stub
+	closeAppendHandle(name)
+	now, err := os.Stat(name)
+	if err != nil || now.Size() != info.Size() || !now.ModTime().Equal(info.ModTime()) {
+		Remove(loc)
+		return false, nil
+	}
+
+	stub := Stub{
+		Location:    loc,
+		Compression: c,
+		Size:        info.Size(),
+		ModTime:     info.ModTime(),
+		Mode:        info.Mode(),
+		SHA256:      sum,
+		Archived:    time.Now(),
+	}
+	data, err := json.Marshal(stub)
+	if err != nil {
+		return false, err
+	}
+	err = writeFileAtomic(name, append(append([]byte(stubMagic), data...), '\n'), info.Mode().Perm())
+	if err == nil {
+		err = os.Chtimes(name, info.ModTime(), info.ModTime())
+		invalidateStat(name)
+	}
+	return err == nil, err
+}
+
+// writeArchived copies name to loc, compressed with c, and returns the SHA-256 of the content
+func writeArchived(ctx context.Context, name string, loc string, c Compression) (string, error) {
+	if err := MkdirAll(parentDir(loc), 0755); err != nil {
+		return "", err
+	}
+
+	in, err := os.Open(name)
+	if err != nil {
+		return "", err
+	}
+	defer in.Close()
+
+	out, err := OpenHandle(loc, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return "", err
+	}
+	cw, err := compressWriter(out, c, 0)
+	if err != nil {
+		out.Close()
+		return "", err
+	}
+
+	h := sha256.New()
+	_, err = copyChunks(ctx, cw, io.TeeReader(in, h), true)
+	if cerr := cw.Close(); err == nil {
+		err = cerr
+	}
+	if cerr := out.Close(); err == nil {
+		err = cerr
+	}
+	invalidateStat(loc)
+	if err != nil {
+		Remove(loc)
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// joinName joins rel, a native relative path, onto base, which may be on a backend
+func joinName(base string, rel string) string {
+	if isLocal(base) {
+		return filepath.Join(cleanPath(base), rel)
+	}
+	return strings.TrimSuffix(base, "/") + "/" + filepath.ToSlash(rel)
+}
+
+// SetTierRules makes ReadFile and RecallFile read through the stubs rules leave, in a process
+// that reads tiered trees without running tiering itself; RunTiering and StartTiering add
+// their own rules. Only a stub under the Root of a rule is read through, and only from inside
+// that rule's Archive: other files that look like stubs are read as they are, and a stub
+// under a Root pointing elsewhere fails with ErrPathEscapes, so a stub anyone could write
+// does not read files they could not. Calling it again replaces the rules.
+func SetTierRules(rules []TierRule) error {
+	for _, r := range rules {
+		if err := r.problems(); err != nil {
+			return fmt.Errorf("SetTierRules: %s: %w", r.Root, err)
+		}
+	}
+	tierRules.mu.Lock()
+	defer tierRules.mu.Unlock()
+	list := append([]TierRule(nil), rules...)
+	tierRules.rules.Store(&list)
+	return nil
+}
+
+// addTierRules adds the rules not known yet to those SetTierRules set
+func addTierRules(rules []TierRule) {
+	tierRules.mu.Lock()
+	defer tierRules.mu.Unlock()
+	var list []TierRule
+	if cur := tierRules.rules.Load(); cur != nil {
+		list = append(list, *cur...)
+	}
+	for _, r := range rules {
+		known := false
+		for _, k := range list {
+			if cleanPath(k.Root) == cleanPath(r.Root) && cleanPath(k.Archive) == cleanPath(r.Archive) {
+				known = true
+				break
+			}
+		}
+		if !known {
+			list = append(list, r)
+		}
+	}
+	tierRules.rules.Store(&list)
+}
+
+// stubTarget returns where the archived copy of the stub s at name is read from, and false
+// when no tiering rule covers name so the stub is to be read as an ordinary file. A Location
+// outside the rule's Archive, a symlink in the archive leading out of it included, fails with
+// ErrPathEscapes.
+func stubTarget(name string, s Stub) (string, bool, error) {
+	list := tierRules.rules.Load()
+	if list == nil || !isLocal(name) {
+		return "", false, nil
+	}
+	abs, err := filepath.Abs(cleanPath(name))
+	if err != nil {
+		return "", false, err
+	}
+
+	for _, r := range *list {
+		root, err := filepath.Abs(cleanPath(r.Root))
+		if err != nil || !hasPathPrefix(abs, root) {
+			continue
+		}
+
+		if s.Size < 0 {
+			return "", false, fmt.Errorf("bad stub: negative size")
+		}
+		escapes := &os.PathError{Op: "read", Path: s.Location, Err: ErrPathEscapes}
+		archive, loc := cleanPath(r.Archive), cleanPath(s.Location)
+		if !isLocal(archive) {
+			if isLocal(loc) || loc == archive || !strings.HasPrefix(loc, strings.TrimSuffix(archive, "/")+"/") {
+				return "", false, escapes
+			}
+			return loc, true, nil
+		}
+		if !isLocal(loc) {
+			return "", false, escapes
+		}
+		archive, err = filepath.Abs(archive)
+		if err == nil {
+			loc, err = filepath.Abs(loc)
+		}
+		if err != nil {
+			return "", false, err
+		}
+		rel, ok := relWithin(archive, loc)
+		if !ok || rel == "." {
+			return "", false, escapes
+		}
+		ar, err := NewRoot(archive)
+		if err != nil {
+			return "", false, err
+		}
+		loc, err = ar.Path(rel)
+		if err != nil {
+			return "", false, err
+		}
+		return loc, true, nil
+	}
+	return "", false, nil
+}
+
+// ReadStub returns the stub name holds, and false when name is an ordinary file
+func ReadStub(name string) (Stub, bool, error) {
+	name = cleanPath(name)
+	b, p := backendFor(name)
+	info, err := b.Stat(p)
+	if err != nil {
+		return Stub{}, false, opError("stat", name, "", err)
+	}
+	if !info.Mode().IsRegular() || info.Size() > maxStubSize {
+		return Stub{}, false, nil
+	}
+
+	f, err := b.Open(p)
+	if err != nil {
+		return Stub{}, false, opError("open", name, "", err)
+	}
+	defer f.Close()
+	data, err := io.ReadAll(io.LimitReader(f, maxStubSize))
+	if err != nil {
+		return Stub{}, false, opError("read", name, "", err)
+	}
+	return parseStub(data)
+}
+
+// parseStub reads a stub from the content of a file, reporting false for other content
+func parseStub(data []byte) (Stub, bool, error) {
+	if len(data) > maxStubSize || !bytes.HasPrefix(data, []byte(stubMagic)) {
+		return Stub{}, false, nil
+	}
+	var s Stub
+	if err := json.Unmarshal(data[len(stubMagic):], &s); err != nil {
+		return Stub{}, false, fmt.Errorf("bad stub: %w", err)
+	}
+	return s, true, nil
+}
+
+// open opens the archived copy at loc, as stubTarget returned it, decompressed and checked
+// against the stub as it is read. Archived copies are regular files, so anything else is
+// refused, before opening as the guard does and again on what was opened. Reads stop one byte
+// past Size, which is enough to tell a longer copy from the right one.
+func (s Stub) open(loc string) (io.ReadCloser, error) {
+	b, p := backendFor(loc)
+	info, err := b.Stat(p)
+	if err != nil {
+		return nil, err
+	}
+	if err := stubRegular(loc, info); err != nil {
+		return nil, err
+	}
+	f, err := b.Open(p)
+	if err != nil {
+		return nil, err
+	}
+	if info, err = f.Stat(); err == nil {
+		err = stubRegular(loc, info)
+	}
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+
+	r := &stubReader{r: f, closers: []io.Closer{f}, s: s, h: sha256.New()}
+	if s.Compression != "" && s.Compression != CompressionNone {
+		d, err := decompressReader(f)
+		if err != nil {
+			f.Close()
+			return nil, err
+		}
+		r.r = d
+		r.closers = append(r.closers, d)
+	}
+	r.r = io.LimitReader(r.r, s.Size+1)
+	return r, nil
+}
+
+// stubRegular fails for an archived copy at loc that is not a regular file
+func stubRegular(loc string, info os.FileInfo) error {
+	switch {
+	case isSpecialMode(info.Mode()):
+		return &os.PathError{Op: "read", Path: loc, Err: ErrSpecialFile}
+	case !info.Mode().IsRegular():
+		return &os.PathError{Op: "read", Path: loc, Err: errors.New("archived copy is not a regular file")}
+	}
+	return nil
+}
+
+// stubReader fails the read that ends the archived content if it doesn't match the stub
+type stubReader struct {
+	r       io.Reader
+	closers []io.Closer // The file, and the decompressor reading it
+	s       Stub
+	h       hash.Hash
+	n       int64
+}
+
+func (r *stubReader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	r.h.Write(p[:n])
+	r.n += int64(n)
+	if err == io.EOF && (r.n != r.s.Size || hex.EncodeToString(r.h.Sum(nil)) != r.s.SHA256) {
+		return n, fmt.Errorf("archived copy %s does not match its stub", r.s.Location)
+	}
+	return n, err
+}
+
+func (r *stubReader) Close() error {
+	var errs []error
+	for i := len(r.closers) - 1; i >= 0; i-- {
+		errs = append(errs, r.closers[i].Close())
+	}
+	return errors.Join(errs...)
+}
+
+// readStubContent returns the archived content at loc of the stub data read from name, for
+// ReadFile
+func readStubContent(ctx context.Context, name string, s Stub, loc string) ([]byte, error) {
+	r, err := s.open(loc)
+	if err != nil {
+		errorPrinterCtx(ctx, "ReadFile (stub): "+err.Error(), s.Location)
+		return nil, err
+	}
+	defer r.Close()
+
+	content := bytes.NewBuffer(make([]byte, 0, min(s.Size, maxStubPrealloc)))
+	if _, err := copyChunks(ctx, content, r, false); err != nil {
+		errorPrinterCtx(ctx, "ReadFile (stub): "+err.Error(), name)
+		return nil, err
+	}
+	return content.Bytes(), nil
+}
+
+// RecallFile puts the archived content of the stub name back in its place, with the mode and
+// modification time it had when archived. The archived copy is kept.
+func RecallFile(name string) error {
+	name = cleanPath(name)
+	if err := requireLocal("recall", name); err != nil {
+		errorPrinter("RecallFile: "+err.Error(), name)
+		return opError("recall", name, "", err)
+	}
+	s, ok, err := ReadStub(name)
+	var loc string
+	if err == nil && ok {
+		loc, ok, err = stubTarget(name, s)
+	}
+	if err == nil && !ok {
+		err = ErrNotStub
+	}
+	if err != nil {
+		errorPrinter("RecallFile: "+err.Error(), name)
+		return opError("recall", name, "", err)
+	}
+
+	r, err := s.open(loc)
+	if err == nil {
+		defer r.Close()
+		err = writeAtomic(name, s.Mode.Perm(), func(w io.Writer) error {
+			_, err := io.Copy(w, r)
+			return err
+		})
+	}
+	if err == nil {
+		err = Chtimes(name, s.ModTime, s.ModTime)
+	}
+	if err != nil {
+		errorPrinter("RecallFile: "+err.Error(), name)
+		return opError("recall", name, "", err)
+	}
+	return nil
+}
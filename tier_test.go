@@ -0,0 +1,150 @@
+package GMSFS
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// tierTestTree tiers a file of a fresh tree, returning the rule, the stub and its content
+func tierTestTree(t *testing.T) (TierRule, string, string) {
+	t.Helper()
+	base := t.TempDir()
+	r := TierRule{Root: filepath.Join(base, "data"), Archive: filepath.Join(base, "archive")}
+	name := filepath.Join(r.Root, "cold.txt")
+	content := "archived content"
+	if err := os.MkdirAll(r.Root, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(name, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	old := time.Now().Add(-time.Hour)
+	if err := os.Chtimes(name, old, old); err != nil {
+		t.Fatal(err)
+	}
+
+	t.Cleanup(func() { SetTierRules(nil) })
+	stats, err := RunTiering(context.Background(), []TierRule{r})
+	if err != nil || stats.Files != 1 {
+		t.Fatalf("RunTiering = %+v, %v", stats, err)
+	}
+	return r, name, content
+}
+
+// writeStub replaces name with a stub pointing at loc
+func writeStub(t *testing.T, name string, s Stub) {
+	t.Helper()
+	data, err := json.Marshal(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(name, append([]byte(stubMagic), data...), 0644); err != nil {
+		t.Fatal(err)
+	}
+	invalidateStat(name)
+}
+
+func TestTieringReadThrough(t *testing.T) {
+	_, name, content := tierTestTree(t)
+
+	got, err := ReadFile(name)
+	if err != nil || string(got) != content {
+		t.Fatalf("ReadFile = %q, %v", got, err)
+	}
+
+	// A reader that only knows the rules from SetTierRules, not from running them
+	rules := *tierRules.rules.Load()
+	SetTierRules(nil)
+	if got, _ := ReadFile(name); !strings.HasPrefix(string(got), stubMagic) {
+		t.Errorf("stub read through without a rule: %q", got)
+	}
+	if err := RecallFile(name); !errors.Is(err, ErrNotStub) {
+		t.Errorf("RecallFile without a rule = %v, want ErrNotStub", err)
+	}
+	if err := SetTierRules(rules); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := ReadFile(name); err != nil || string(got) != content {
+		t.Errorf("ReadFile = %q, %v", got, err)
+	}
+}
+
+func TestTieringRefusesForeignStubs(t *testing.T) {
+	r, name, _ := tierTestTree(t)
+	stub, ok, err := ReadStub(name)
+	if err != nil || !ok {
+		t.Fatalf("ReadStub = %v, %v", ok, err)
+	}
+	secret := filepath.Join(filepath.Dir(r.Root), "secret.txt")
+	if err := os.WriteFile(secret, []byte("secret"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	// A stub outside every Root is just a file
+	outside := filepath.Join(filepath.Dir(r.Root), "stub.txt")
+	writeStub(t, outside, Stub{Location: secret, Size: 6})
+	if got, err := ReadFile(outside); err != nil || !strings.HasPrefix(string(got), stubMagic) {
+		t.Errorf("ReadFile of a stub outside the roots = %q, %v", got, err)
+	}
+
+	locations := []string{secret, "/dev/zero", filepath.Join(r.Archive, "..", "secret.txt"), r.Archive}
+	link := filepath.Join(r.Archive, "link.txt")
+	if err := os.Symlink(secret, link); err == nil {
+		locations = append(locations, link)
+	}
+	for _, loc := range locations {
+		s := stub
+		s.Location, s.Size = loc, 6
+		writeStub(t, name, s)
+		if got, err := ReadFile(name); !errors.Is(err, ErrPathEscapes) {
+			t.Errorf("ReadFile of a stub pointing at %s = %q, %v, want ErrPathEscapes", loc, got, err)
+		}
+		if err := RecallFile(name); !errors.Is(err, ErrPathEscapes) {
+			t.Errorf("RecallFile of a stub pointing at %s = %v, want ErrPathEscapes", loc, err)
+		}
+	}
+}
+
+func TestTieringBoundsArchivedReads(t *testing.T) {
+	r, name, content := tierTestTree(t)
+	stub, _, _ := ReadStub(name)
+
+	// An archived copy longer than the stub says is not read to its end
+	long := filepath.Join(r.Archive, "long.txt")
+	if err := os.WriteFile(long, []byte(content+strings.Repeat("x", 1<<20)), 0644); err != nil {
+		t.Fatal(err)
+	}
+	s := stub
+	s.Location = long
+	writeStub(t, name, s)
+	if got, err := ReadFile(name); err == nil || len(got) > 0 {
+		t.Errorf("ReadFile of a longer copy = %d bytes, %v", len(got), err)
+	}
+
+	// Nor is one that is no regular file
+	fifo := filepath.Join(r.Archive, "fifo")
+	if err := Mkfifo(fifo, 0644); err != nil {
+		t.Skip(err)
+	}
+	s.Location = fifo
+	writeStub(t, name, s)
+	done := make(chan error, 1)
+	go func() {
+		_, err := ReadFile(name)
+		done <- err
+	}()
+	select {
+	case err := <-done:
+		if !errors.Is(err, ErrSpecialFile) {
+			t.Errorf("ReadFile of a stub pointing at a fifo = %v, want ErrSpecialFile", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("ReadFile of a stub pointing at a fifo blocked")
+	}
+}
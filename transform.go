@@ -0,0 +1,160 @@
+package GMSFS
+
+import (
+	"io"
+	"mime"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	cmap "github.com/orcaman/concurrent-map/v2"
+)
+
+// TransformFunc rewrites a file while CopyDir or SyncDir copies it. src is the source path,
+// r yields the source content and everything written to w becomes the destination content.
+type TransformFunc func(src string, r io.Reader, w io.Writer) error
+
+var transforms = cmap.New[[]TransformFunc]()
+
+// RegisterTransform adds fn to the pipeline for key, which is either a file extension
+// (".jpg") or a MIME type ("image/jpeg", or "image/*" for a whole family). Hooks run in
+// registration order, extension hooks before MIME hooks.
+func RegisterTransform(key string, fn TransformFunc) {
+	key = transformKey(key)
+	transforms.Upsert(key, []TransformFunc{fn}, func(exist bool, current []TransformFunc, add []TransformFunc) []TransformFunc {
+		if !exist {
+			return add
+		}
+		return append(append([]TransformFunc(nil), current...), add...)
+	})
+}
+
+// UnregisterTransforms removes every hook registered for key
+func UnregisterTransforms(key string) {
+	transforms.Remove(transformKey(key))
+}
+
+func transformKey(key string) string {
+	key = strings.ToLower(strings.TrimSpace(key))
+	if !strings.Contains(key, "/") && !strings.HasPrefix(key, ".") {
+		key = "." + key
+	}
+	return key
+}
+
+// transformsFor returns the hooks that apply to name
+func transformsFor(name string) []TransformFunc {
+	if transforms.IsEmpty() {
+		return nil
+	}
+
+	ext := strings.ToLower(filepath.Ext(name))
+	if ext == "" {
+		return nil
+	}
+
+	var fns []TransformFunc
+	if hooks, ok := transforms.Get(ext); ok {
+		fns = append(fns, hooks...)
+	}
+
+	mimeType, _, _ := strings.Cut(mime.TypeByExtension(ext), ";")
+	if mimeType != "" {
+		if hooks, ok := transforms.Get(mimeType); ok {
+			fns = append(fns, hooks...)
+		}
+		family, _, _ := strings.Cut(mimeType, "/")
+		if hooks, ok := transforms.Get(family + "/*"); ok {
+			fns = append(fns, hooks...)
+		}
+	}
+
+	return fns
+}
+
+// transformFile copies src to dst through the hooks, keeping the source mode like CopyFile
+func transformFile(src string, dst string, fns []TransformFunc, progress *copyProgress) (err error) {
+	acquireFDs(2)
+	defer releaseFDs(2)
+
+	in, err := os.Open(src)
+	if err != nil {
+		errorPrinter("transformFile (os.Open): "+err.Error(), src)
+		return err
+	}
+	defer in.Close()
+
+	si, err := in.Stat()
+	if err != nil {
+		return err
+	}
+
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, si.Mode().Perm())
+	if err != nil {
+		errorPrinter("transformFile (os.OpenFile): "+err.Error(), dst)
+		return err
+	}
+	defer invalidateStat(dst)
+	defer func() {
+		if e := out.Close(); e != nil && err == nil {
+			err = e
+		}
+		if err != nil {
+			os.Remove(dst)
+		}
+	}()
+
+	if err = runTransforms(src, progress.reader(src, in), out, fns); err != nil {
+		errorPrinter("transformFile: "+err.Error(), src)
+		return err
+	}
+
+	if err = out.Sync(); err != nil {
+		return err
+	}
+	return os.Chmod(dst, si.Mode())
+}
+
+// runTransforms chains the hooks with pipes so content is streamed rather than buffered
+func runTransforms(src string, r io.Reader, w io.Writer, fns []TransformFunc) error {
+	if len(fns) == 1 {
+		return fns[0](src, r, w)
+	}
+
+	var wg sync.WaitGroup
+	errs := make([]error, len(fns))
+	for i, fn := range fns {
+		var next io.Reader
+		var out io.Writer = w
+		var pw *io.PipeWriter
+		if i < len(fns)-1 {
+			var pr *io.PipeReader
+			pr, pw = io.Pipe()
+			next, out = pr, pw
+		}
+
+		wg.Add(1)
+		go func(i int, fn TransformFunc, r io.Reader, out io.Writer, pw *io.PipeWriter) {
+			defer wg.Done()
+			errs[i] = fn(src, r, out)
+			if pw != nil {
+				pw.CloseWithError(errs[i])
+			}
+			// Unblock the previous stage if this one stopped reading early
+			if pr, ok := r.(*io.PipeReader); ok {
+				pr.CloseWithError(errs[i])
+			}
+		}(i, fn, r, out, pw)
+
+		r = next
+	}
+	wg.Wait()
+
+	for _, err := range errs {
+		if err != nil && err != io.ErrClosedPipe {
+			return err
+		}
+	}
+	return nil
+}
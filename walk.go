@@ -0,0 +1,62 @@
+package GMSFS
+
+import (
+	"io/fs"
+	"path/filepath"
+)
+
+// WalkFunc is called by Walk for every file and directory it visits
+type WalkFunc func(path string, info FileInfo) error
+
+// SkipDir returned from a WalkFunc skips the directory's contents, or the rest of the
+// parent directory when returned for a file
+var SkipDir = fs.SkipDir
+
+// SkipAll returned from a WalkFunc stops the walk without an error
+var SkipAll = fs.SkipAll
+
+// Walk visits root and everything below it in lexical order without collecting the tree in
+// memory. Symbolic links are reported but not followed. A directory that cannot be read stops
+// the walk with its error.
+func Walk(root string, fn WalkFunc) error {
+	info, err := Stat(root)
+	if err != nil {
+		errorPrinter("Walk (Stat): "+err.Error(), root)
+		return err
+	}
+
+	err = walk(root, info, fn)
+	if err == SkipDir || err == SkipAll {
+		return nil
+	}
+	return err
+}
+
+func walk(path string, info FileInfo, fn WalkFunc) error {
+	if err := fn(path, info); err != nil {
+		if err == SkipDir && info.IsDir {
+			return nil
+		}
+		return err
+	}
+	if !info.IsDir {
+		return nil
+	}
+
+	entries, err := ReadDir(path)
+	if err != nil {
+		errorPrinter("Walk (ReadDir): "+err.Error(), path)
+		return err
+	}
+
+	for _, entry := range entries {
+		if err := walk(filepath.Join(path, entry.Name), entry, fn); err != nil {
+			if err == SkipDir {
+				// Returned for a file: skip the rest of this directory
+				return nil
+			}
+			return err
+		}
+	}
+	return nil
+}
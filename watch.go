@@ -0,0 +1,407 @@
+package GMSFS
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// EventOp is the kind of change reported by a Watcher; debounced events may combine several
+type EventOp uint32
+
+const (
+	EventCreate EventOp = 1 << iota
+	EventWrite
+	EventRemove
+	EventRename
+	EventChmod
+)
+
+func (op EventOp) String() string {
+	var names []string
+	for _, n := range []struct {
+		op   EventOp
+		name string
+	}{{EventCreate, "CREATE"}, {EventWrite, "WRITE"}, {EventRemove, "REMOVE"}, {EventRename, "RENAME"}, {EventChmod, "CHMOD"}} {
+		if op&n.op != 0 {
+			names = append(names, n.name)
+		}
+	}
+	return strings.Join(names, "|")
+}
+
+// Has reports whether op includes other
+func (op EventOp) Has(other EventOp) bool {
+	return op&other != 0
+}
+
+// Event is a change to a watched path
+type Event struct {
+	Path string
+	Op   EventOp
+	Time time.Time
+}
+
+// WatchOptions configures Watch
+type WatchOptions struct {
+	Recursive bool          // Also watch subdirectories, including ones created later
+	Pattern   string        // Only report names matching this glob, e.g. "*.{log,txt}"
+	Debounce  time.Duration // Merge events for the same path arriving within this window
+
+	// Poll compares Stat/ReadDir snapshots every PollInterval instead of using OS notifications.
+	// Watch also polls when notifications cannot be set up or path is on a network filesystem.
+	Poll         bool
+	PollInterval time.Duration // Defaults to DefaultPollInterval
+
+	// MaxPollInterval lets polling back off per directory: one in which nothing changed is
+	// listed again after twice its last interval, up to MaxPollInterval, and after PollInterval
+	// once it changes. Every listing opens, stats and reads the whole directory, so backoff is
+	// what saves syscalls on large quiet trees, at the price of seeing changes up to
+	// MaxPollInterval late. Only while the Stat cache is on (it is off by default, see
+	// SetStatCacheTTL) are changes made through the package seen within PollInterval anyway, as
+	// they drop the directory from the cache. Defaults to PollInterval, without backoff.
+	MaxPollInterval time.Duration
+}
+
+// DefaultPollInterval is the snapshot interval of a polling Watcher
+const DefaultPollInterval = 2 * time.Second
+
+// Watcher delivers change events for a file or directory tree until it is closed
+type Watcher struct {
+	Events <-chan Event
+	Errors <-chan error
+
+	opts     WatchOptions
+	events   chan Event
+	errors   chan error
+	done     chan struct{}
+	closeErr error
+	once     sync.Once
+	wg       sync.WaitGroup
+
+	mu      sync.Mutex
+	pending map[string]*pendingEvent
+
+	// sendMu keeps Close from closing the channels under an in-flight send
+	sendMu sync.RWMutex
+	closed bool
+
+	fsw *fsnotify.Watcher
+
+	// Polling state, see watchpoll.go
+	root string
+	dirs map[string]*pollDir
+}
+
+type pendingEvent struct {
+	op    EventOp
+	timer *time.Timer
+}
+
+// Watch starts watching path (a file or a directory) for changes
+func Watch(path string, opts WatchOptions) (*Watcher, error) {
+	path = cleanPath(path)
+	if err := requireLocal("watch", path); err != nil {
+		errorPrinter("Watch: "+err.Error(), path)
+		return nil, err
+	}
+
+	patterns, err := expandBraces(opts.Pattern)
+	if err != nil {
+		return nil, err
+	}
+	for _, p := range patterns {
+		if _, err := matchName(p, "", false); err != nil {
+			return nil, err
+		}
+	}
+
+	var w *Watcher
+	if opts.Poll || networkFS(path) {
+		w, err = watchPoll(path, opts)
+	} else if w, err = watchNotify(path, opts); err != nil {
+		if _, serr := os.Stat(path); serr != nil {
+			errorPrinter("Watch: "+err.Error(), path)
+			return nil, err
+		}
+
+		// Out of inotify watches, or a filesystem without notification support
+		warnPrinter("Watch: falling back to polling: "+err.Error(), path)
+		w, err = watchPoll(path, opts)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	watchersMu.Lock()
+	watchers[w] = WatcherInfo{
+		Path:      path,
+		Recursive: opts.Recursive,
+		Pattern:   opts.Pattern,
+		Polling:   w.fsw == nil,
+		Started:   time.Now(),
+	}
+	watchersMu.Unlock()
+	return w, nil
+}
+
+// WatcherInfo describes an open Watcher, see DebugReport
+type WatcherInfo struct {
+	Path      string    `json:"path"`
+	Recursive bool      `json:"recursive"`
+	Pattern   string    `json:"pattern,omitempty"`
+	Polling   bool      `json:"polling"`
+	Started   time.Time `json:"started"`
+}
+
+var (
+	watchersMu sync.Mutex
+	watchers   = map[*Watcher]WatcherInfo{}
+)
+
+// activeWatchers lists the open watchers, oldest first
+func activeWatchers() []WatcherInfo {
+	watchersMu.Lock()
+	list := make([]WatcherInfo, 0, len(watchers))
+	for _, info := range watchers {
+		list = append(list, info)
+	}
+	watchersMu.Unlock()
+
+	sort.Slice(list, func(i, j int) bool { return list[i].Started.Before(list[j].Started) })
+	return list
+}
+
+func watchNotify(path string, opts WatchOptions) (*Watcher, error) {
+	fsw, err := fsnotify.NewWatcher()
+	if err != nil {
+		return nil, err
+	}
+
+	w := newWatcher(opts)
+	w.fsw = fsw
+
+	if err := w.add(path); err != nil {
+		fsw.Close()
+		return nil, err
+	}
+
+	w.wg.Add(1)
+	go w.run()
+	return w, nil
+}
+
+func newWatcher(opts WatchOptions) *Watcher {
+	w := &Watcher{
+		opts:    opts,
+		events:  make(chan Event, 64),
+		errors:  make(chan error, 8),
+		done:    make(chan struct{}),
+		pending: map[string]*pendingEvent{},
+	}
+	w.Events = w.events
+	w.Errors = w.errors
+	return w
+}
+
+// Close stops the watcher and closes its channels
+func (w *Watcher) Close() error {
+	w.once.Do(func() {
+		watchersMu.Lock()
+		delete(watchers, w)
+		watchersMu.Unlock()
+
+		close(w.done)
+		if w.fsw != nil {
+			w.closeErr = w.fsw.Close()
+		}
+		w.wg.Wait()
+
+		w.mu.Lock()
+		for path, p := range w.pending {
+			p.timer.Stop()
+			delete(w.pending, path)
+		}
+		w.mu.Unlock()
+
+		w.sendMu.Lock()
+		w.closed = true
+		close(w.events)
+		close(w.errors)
+		w.sendMu.Unlock()
+	})
+	return w.closeErr
+}
+
+// add registers path, and with Recursive every directory below it
+func (w *Watcher) add(path string) error {
+	info, err := Stat(path)
+	if err != nil {
+		return err
+	}
+	if !info.IsDir || !w.opts.Recursive {
+		return w.fsw.Add(path)
+	}
+
+	return Walk(path, func(name string, info FileInfo) error {
+		if info.IsDir {
+			return w.fsw.Add(name)
+		}
+		return nil
+	})
+}
+
+func (w *Watcher) run() {
+	defer w.wg.Done()
+
+	for {
+		select {
+		case <-w.done:
+			return
+		case err, ok := <-w.fsw.Errors:
+			if !ok {
+				return
+			}
+			w.sendError(err)
+		case ev, ok := <-w.fsw.Events:
+			if !ok {
+				return
+			}
+			w.handle(ev)
+		}
+	}
+}
+
+func (w *Watcher) handle(ev fsnotify.Event) {
+	var op EventOp
+	if ev.Has(fsnotify.Create) {
+		op |= EventCreate
+	}
+	if ev.Has(fsnotify.Write) {
+		op |= EventWrite
+	}
+	if ev.Has(fsnotify.Remove) {
+		op |= EventRemove
+	}
+	if ev.Has(fsnotify.Rename) {
+		op |= EventRename
+	}
+	if ev.Has(fsnotify.Chmod) {
+		op |= EventChmod
+	}
+	if op == 0 {
+		return
+	}
+
+	// Changes made outside the package must not be served stale from the stat cache
+	invalidateStat(ev.Name)
+
+	if op.Has(EventCreate) && w.opts.Recursive {
+		if info, err := os.Stat(ev.Name); err == nil && info.IsDir() {
+			w.addCreatedDir(ev.Name)
+		}
+	}
+
+	w.emit(ev.Name, op)
+}
+
+// addCreatedDir watches a new subdirectory and reports entries that appeared in it before the watch was in place
+func (w *Watcher) addCreatedDir(dir string) {
+	Walk(dir, func(name string, info FileInfo) error {
+		if info.IsDir {
+			if err := w.fsw.Add(name); err != nil {
+				w.sendError(err)
+			}
+		}
+		if name != dir {
+			w.emit(name, EventCreate)
+		}
+		return nil
+	})
+}
+
+// emit filters an event and sends it, either directly or after the debounce window
+func (w *Watcher) emit(path string, op EventOp) {
+	if w.opts.Pattern != "" {
+		// Watch checked the pattern
+		patterns, _ := expandBraces(w.opts.Pattern)
+		if !matchAny(patterns, filepath.Base(path)) {
+			return
+		}
+	}
+
+	if w.opts.Debounce <= 0 {
+		w.send(Event{Path: path, Op: op, Time: time.Now()})
+		return
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if p, ok := w.pending[path]; ok {
+		p.op |= op
+		p.timer.Reset(w.opts.Debounce)
+		return
+	}
+
+	w.pending[path] = &pendingEvent{
+		op:    op,
+		timer: time.AfterFunc(w.opts.Debounce, func() { w.flush(path) }),
+	}
+}
+
+func (w *Watcher) flush(path string) {
+	w.mu.Lock()
+	p, ok := w.pending[path]
+	if ok {
+		delete(w.pending, path)
+	}
+	w.mu.Unlock()
+
+	if ok {
+		w.send(Event{Path: path, Op: p.op, Time: time.Now()})
+	}
+}
+
+func (w *Watcher) send(ev Event) {
+	w.sendMu.RLock()
+	defer w.sendMu.RUnlock()
+	if w.closed {
+		return
+	}
+
+	select {
+	case w.events <- ev:
+	case <-w.done:
+	}
+}
+
+func (w *Watcher) sendError(err error) {
+	errorPrinter("Watcher: "+err.Error(), "")
+
+	w.sendMu.RLock()
+	defer w.sendMu.RUnlock()
+	if w.closed {
+		return
+	}
+
+	select {
+	case w.errors <- err:
+	default:
+		// Nobody is reading errors; they are logged above
+	}
+}
+
+func matchAny(patterns []string, name string) bool {
+	for _, p := range patterns {
+		if matched, _ := matchName(p, name, false); matched {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,252 @@
+package GMSFS
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+type pollEntry struct {
+	mode    os.FileMode
+	size    int64
+	modTime time.Time
+}
+
+// pollDir is a directory a polling Watcher lists: the entries seen in it, and when it is due
+// again. The root's pollDir also holds the root itself, which may be a file.
+type pollDir struct {
+	entries  map[string]pollEntry
+	interval time.Duration
+	due      time.Time
+
+	// The last listing put the directory in the Stat cache; an operation of the package
+	// changing it drops it from there, which makes it due at once. Never set while the cache
+	// is off, its default.
+	cached bool
+}
+
+func watchPoll(path string, opts WatchOptions) (*Watcher, error) {
+	if opts.PollInterval <= 0 {
+		opts.PollInterval = DefaultPollInterval
+	}
+	if opts.MaxPollInterval < opts.PollInterval {
+		opts.MaxPollInterval = opts.PollInterval
+	}
+
+	w := newWatcher(opts)
+	w.root = path
+
+	if _, err := os.Stat(path); err != nil {
+		errorPrinter("Watch (os.Stat): "+err.Error(), path)
+		return nil, err
+	}
+	w.dirs = map[string]*pollDir{path: {interval: opts.PollInterval}}
+	if err := w.poll(time.Now(), false); err != nil {
+		errorPrinter("Watch (scan): "+err.Error(), path)
+		return nil, err
+	}
+
+	w.wg.Add(1)
+	go w.runPoll()
+	return w, nil
+}
+
+func (w *Watcher) runPoll() {
+	defer w.wg.Done()
+
+	ticker := time.NewTicker(w.opts.PollInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-w.done:
+			return
+		case now := <-ticker.C:
+			if err := w.poll(now, true); err != nil {
+				w.sendError(err)
+			}
+		}
+	}
+}
+
+// poll lists the directories that are due, and the ones found below them, reporting the
+// changes unless it takes the first snapshot
+func (w *Watcher) poll(now time.Time, report bool) error {
+	var due []string
+	for dir, d := range w.dirs {
+		if !now.Before(d.due) || d.cached && !fileCache.Has(statCacheKey(dir)) {
+			due = append(due, dir)
+		}
+	}
+	sort.Strings(due)
+
+	var errs []error
+	for len(due) > 0 {
+		dir := due[0]
+		due = due[1:]
+		// Gone if a directory above it was removed earlier in this pass
+		d, ok := w.dirs[dir]
+		if !ok {
+			continue
+		}
+		added, err := w.pollDir(dir, d, now, report)
+		if err != nil {
+			errs = append(errs, err)
+		}
+		due = append(due, added...)
+	}
+	return errors.Join(errs...)
+}
+
+// pollDir lists dir, emits the changes since its last listing and schedules the next one: at
+// PollInterval after a change, otherwise at twice the last interval up to MaxPollInterval. It
+// returns the subdirectories it started to follow.
+func (w *Watcher) pollDir(dir string, d *pollDir, now time.Time, report bool) ([]string, error) {
+	next, info, err := w.list(dir)
+	if err != nil {
+		d.due = now.Add(d.interval)
+		return nil, err
+	}
+
+	ops := map[string]EventOp{}
+	var added []string
+	for name, cur := range next {
+		prev, existed := d.entries[name]
+
+		var op EventOp
+		switch {
+		case !existed:
+			op = EventCreate
+		case prev.mode.Type() != cur.mode.Type():
+			op = EventRemove | EventCreate
+		default:
+			// A directory's mtime changes with its entries, which are reported themselves
+			if !cur.mode.IsDir() && (prev.size != cur.size || !prev.modTime.Equal(cur.modTime)) {
+				op |= EventWrite
+			}
+			if prev.mode.Perm() != cur.mode.Perm() {
+				op |= EventChmod
+			}
+		}
+		if op != 0 {
+			ops[name] = op
+		}
+
+		if w.opts.Recursive && name != w.root && cur.mode.IsDir() && (!existed || !prev.mode.IsDir()) {
+			w.dirs[name] = &pollDir{interval: w.opts.PollInterval}
+			added = append(added, name)
+		}
+	}
+	for name, prev := range d.entries {
+		cur, exists := next[name]
+		if !exists {
+			ops[name] = EventRemove
+		}
+		if prev.mode.IsDir() && (!exists || !cur.mode.IsDir()) {
+			w.dropDirs(name, ops)
+		}
+	}
+	d.entries = next
+
+	if len(ops) > 0 {
+		d.interval = w.opts.PollInterval
+	} else {
+		d.interval = min(2*d.interval, w.opts.MaxPollInterval)
+	}
+	d.due = now.Add(d.interval)
+
+	paths := make([]string, 0, len(ops))
+	for name := range ops {
+		paths = append(paths, name)
+	}
+	sort.Strings(paths)
+	for _, name := range paths {
+		invalidateStat(name)
+		if report {
+			w.emit(name, ops[name])
+		}
+	}
+
+	// Put last, as invalidating the entries above drops their directory as well
+	d.cached = false
+	if info != nil && info.Mode()&os.ModeSymlink == 0 {
+		statCachePut(dir, FileInfo{
+			Exists:       true,
+			Size:         info.Size(),
+			Mode:         info.Mode(),
+			LastModified: info.ModTime(),
+			IsDir:        info.IsDir(),
+			Name:         filepath.Base(dir),
+		})
+		d.cached = fileCache.Has(statCacheKey(dir))
+	}
+	return added, nil
+}
+
+// list returns the entries of dir and its own metadata; the Stat cache is bypassed so changes
+// made elsewhere are seen. The root's list also holds the root itself.
+func (w *Watcher) list(dir string) (map[string]pollEntry, os.FileInfo, error) {
+	entries := map[string]pollEntry{}
+	if dir == w.root {
+		info, err := os.Lstat(dir)
+		if os.IsNotExist(err) {
+			return entries, nil, nil
+		}
+		if err != nil {
+			return nil, nil, err
+		}
+		entries[dir] = pollEntryOf(info)
+		if !info.IsDir() {
+			return entries, info, nil
+		}
+	}
+
+	// A directory removed or replaced since its parent was listed is reported with the parent
+	f, err := os.Open(dir)
+	if os.IsNotExist(err) {
+		return entries, nil, nil
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return nil, nil, err
+	}
+	if !info.IsDir() {
+		return entries, nil, nil
+	}
+
+	list, err := f.ReadDir(-1)
+	if err != nil {
+		return nil, nil, err
+	}
+	for _, e := range list {
+		// Entries removed during the listing are reported on the next one
+		if info, err := e.Info(); err == nil {
+			entries[filepath.Join(dir, e.Name())] = pollEntryOf(info)
+		}
+	}
+	return entries, info, nil
+}
+
+// dropDirs stops following dir and the directories below it, which are gone, and adds removal
+// events for what was seen in them to ops
+func (w *Watcher) dropDirs(dir string, ops map[string]EventOp) {
+	for name, d := range w.dirs {
+		if name == w.root || !hasPathPrefix(name, dir) {
+			continue
+		}
+		for entry := range d.entries {
+			ops[entry] = EventRemove
+		}
+		delete(w.dirs, name)
+	}
+}
+
+func pollEntryOf(info os.FileInfo) pollEntry {
+	return pollEntry{mode: info.Mode(), size: info.Size(), modTime: info.ModTime()}
+}
@@ -0,0 +1,69 @@
+package GMSFS
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// waitEvent returns the next event of w for name, failing after timeout
+func waitEvent(t *testing.T, w *Watcher, name string, timeout time.Duration) Event {
+	t.Helper()
+	deadline := time.After(timeout)
+	for {
+		select {
+		case ev := <-w.Events:
+			if ev.Path == name {
+				return ev
+			}
+		case err := <-w.Errors:
+			t.Fatal(err)
+		case <-deadline:
+			t.Fatalf("no event for %s within %s", name, timeout)
+		}
+	}
+}
+
+func TestWatchPoll(t *testing.T) {
+	dir := t.TempDir()
+	w, err := Watch(dir, WatchOptions{Poll: true, PollInterval: 20 * time.Millisecond})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+
+	name := filepath.Join(dir, "a.txt")
+	if err := os.WriteFile(name, []byte("a"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if ev := waitEvent(t, w, name, 2*time.Second); ev.Op&EventCreate == 0 {
+		t.Errorf("event = %v, want create", ev.Op)
+	}
+	if err := os.Remove(name); err != nil {
+		t.Fatal(err)
+	}
+	if ev := waitEvent(t, w, name, 2*time.Second); ev.Op&EventRemove == 0 {
+		t.Errorf("event = %v, want remove", ev.Op)
+	}
+}
+
+func TestWatchPollBackoffWithStatCache(t *testing.T) {
+	SetStatCacheTTL(time.Minute)
+	defer SetStatCacheTTL(DefaultStatCacheTTL)
+	dir := t.TempDir()
+	w, err := Watch(dir, WatchOptions{Poll: true, PollInterval: 20 * time.Millisecond, MaxPollInterval: time.Hour})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+
+	// Quiet long enough to back off beyond a second, a write through the package is still
+	// seen at the next tick
+	time.Sleep(700 * time.Millisecond)
+	name := filepath.Join(dir, "a.txt")
+	if err := WriteFile(name, []byte("a"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	waitEvent(t, w, name, 400*time.Millisecond)
+}
@@ -0,0 +1,132 @@
+package GMSFS
+
+import (
+	"errors"
+	"os"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// DefaultAdaptiveWorkers caps adaptive copies when CopyOptions.Workers is not set
+const DefaultAdaptiveWorkers = 32
+
+// workerLimit bounds the copies in flight. An adaptive limit starts at one and grows while the
+// storage keeps up: it doubles every round until the first sign of congestion, then grows by
+// one per round and halves when latency climbs well above the best seen or an operation fails
+// for lack of resources (AIMD, as in TCP congestion control).
+type workerLimit struct {
+	mu       sync.Mutex
+	cond     *sync.Cond
+	adaptive bool
+	max      int
+	limit    float64
+	inFlight int
+
+	slowStart bool
+	samples   int
+	smoothed  float64 // Moving average of the latency per operation and MiB, in seconds
+	baseline  float64 // Best latency seen, drifting up slowly so it follows the storage
+	holdoff   int     // Completions to ignore after a decrease, as they started at the old limit
+}
+
+func newWorkerLimit(max int, adaptive bool) *workerLimit {
+	l := &workerLimit{adaptive: adaptive, max: max, limit: float64(max)}
+	if adaptive {
+		l.limit = 1
+		l.slowStart = true
+	}
+	l.cond = sync.NewCond(&l.mu)
+	return l
+}
+
+// acquire waits for a free slot
+func (l *workerLimit) acquire() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	for l.inFlight >= int(l.limit) {
+		l.cond.Wait()
+	}
+	l.inFlight++
+}
+
+// release frees a slot and feeds the outcome of the operation into the limit; operations that
+// did no real work, such as skipped files, only count when they fail
+func (l *workerLimit) release(elapsed time.Duration, size int64, err error, worked bool) {
+	l.mu.Lock()
+	l.inFlight--
+	if l.adaptive {
+		switch {
+		case err == nil && worked:
+			l.sample(elapsed, size)
+		case congestionError(err):
+			l.decrease()
+		}
+	}
+	l.mu.Unlock()
+	l.cond.Broadcast()
+}
+
+// current returns the number of operations allowed in flight
+func (l *workerLimit) current() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return int(l.limit)
+}
+
+func (l *workerLimit) sample(elapsed time.Duration, size int64) {
+	// Large files take longer without the storage being any slower
+	cost := elapsed.Seconds() / (1 + float64(size)/(1<<20))
+
+	if l.samples == 0 {
+		l.smoothed, l.baseline = cost, cost
+	} else {
+		l.smoothed += (cost - l.smoothed) * 0.2
+	}
+	l.samples++
+
+	if cost < l.baseline {
+		l.baseline = cost
+	} else {
+		l.baseline += (cost - l.baseline) * 0.01
+	}
+
+	if l.holdoff > 0 {
+		l.holdoff--
+		return
+	}
+	if l.samples >= 8 && l.smoothed > 2*l.baseline {
+		l.decrease()
+		return
+	}
+
+	if l.slowStart {
+		l.limit++
+	} else {
+		l.limit += 1 / l.limit
+	}
+	l.limit = min(l.limit, float64(l.max))
+}
+
+func (l *workerLimit) decrease() {
+	if l.holdoff > 0 {
+		return
+	}
+	l.slowStart = false
+	l.limit = max(1, l.limit/2)
+	l.holdoff = l.inFlight + int(l.limit)
+	l.smoothed = (l.smoothed + l.baseline) / 2
+}
+
+// congestionError reports whether err means the storage or the process is overloaded, as
+// opposed to a problem with the file itself
+func congestionError(err error) bool {
+	for _, target := range []error{syscall.EMFILE, syscall.ENFILE, syscall.EAGAIN, syscall.EBUSY, syscall.EIO, syscall.ENOMEM, syscall.ETIMEDOUT, os.ErrDeadlineExceeded} {
+		if errors.Is(err, target) {
+			return true
+		}
+	}
+	var timeout interface{ Timeout() bool }
+	return errors.As(err, &timeout) && timeout.Timeout()
+}
@@ -0,0 +1,422 @@
+package GMSFS
+
+import (
+	"context"
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+// writeBackJournal is the file in the cache directory listing the files waiting for upload
+const writeBackJournal = ".gmsfs-writeback.json"
+
+// Delays between attempts of a failing upload
+const (
+	minUploadRetry = time.Second
+	maxUploadRetry = time.Minute
+)
+
+// writeBackFile is a cached file open for writing; closing it queues the upload
+type writeBackFile struct {
+	*os.File
+	c      *CachingBackend
+	name   string
+	entry  *remoteCacheEntry
+	closed bool
+}
+
+// openWriteBack opens the cached copy of name for writing, pulling it first unless it is
+// truncated anyway
+func (c *CachingBackend) openWriteBack(name string, flag int, perm os.FileMode) (File, error) {
+	if flag&os.O_EXCL != 0 {
+		if _, err := c.Stat(name); err == nil {
+			return nil, &os.PathError{Op: "open", Path: name, Err: os.ErrExist}
+		} else if !os.IsNotExist(err) {
+			return nil, err
+		}
+	} else if flag&os.O_TRUNC == 0 {
+		if err := c.pull(name); err != nil && !(os.IsNotExist(err) && flag&os.O_CREATE != 0) {
+			return nil, err
+		}
+	}
+
+	// Claim the name so no pull replaces the file under the writer
+	c.mu.Lock()
+	for {
+		wait, ok := c.pulling[name]
+		if !ok {
+			break
+		}
+		c.mu.Unlock()
+		<-wait
+		c.mu.Lock()
+	}
+	e := c.entries[name]
+	if e == nil {
+		e = &remoteCacheEntry{}
+		c.entries[name] = e
+	}
+	e.writers++
+	e.used = time.Now()
+	c.mu.Unlock()
+
+	local := c.localPath(name)
+	err := os.MkdirAll(filepath.Dir(local), 0755)
+	var f *os.File
+	if err == nil {
+		acquireFDs(1)
+		f, err = os.OpenFile(local, flag, perm)
+		if err != nil {
+			releaseFDs(1)
+		}
+	}
+	if err != nil {
+		c.written(name, e, false)
+		return nil, cachePathError("open", name, err)
+	}
+	return &writeBackFile{File: f, c: c, name: name, entry: e}, nil
+}
+
+// Close syncs the file, so the journal never lists content that is not on disk, and queues
+// its upload
+func (f *writeBackFile) Close() error {
+	if f.closed {
+		return &os.PathError{Op: "close", Path: f.name, Err: os.ErrClosed}
+	}
+	f.closed = true
+
+	err := f.File.Sync()
+	if cerr := f.File.Close(); err == nil {
+		err = cerr
+	}
+	releaseFDs(1)
+	f.c.written(f.name, f.entry, err == nil)
+	return err
+}
+
+// written ends a writer of name, marking the entry for upload when the write went through
+func (c *CachingBackend) written(name string, e *remoteCacheEntry, ok bool) {
+	info, serr := os.Stat(c.localPath(name))
+
+	c.mu.Lock()
+	e.writers--
+	if c.entries[name] != e {
+		c.mu.Unlock() // Removed while open
+		return
+	}
+	if serr != nil {
+		if e.writers == 0 && !e.dirty {
+			c.size -= e.size
+			delete(c.entries, name)
+		}
+		c.mu.Unlock()
+		return
+	}
+	c.size += info.Size() - e.size
+	e.size = info.Size()
+	if ok {
+		e.dirty = true
+		e.gen++
+	}
+	c.mu.Unlock()
+
+	if ok {
+		c.saveJournal()
+		c.kickUploads()
+	}
+	c.evict(name)
+}
+
+// cachePathError reports err, met on the cached copy of name, under the backend name
+func cachePathError(op string, name string, err error) error {
+	var pe *os.PathError
+	if errors.As(err, &pe) {
+		err = pe.Err
+	}
+	return &os.PathError{Op: op, Path: name, Err: err}
+}
+
+// localInfo returns the local version of name when it has writes the remote lacks
+func (c *CachingBackend) localInfo(name string) (os.FileInfo, bool) {
+	if !c.writeBack {
+		return nil, false
+	}
+	c.mu.Lock()
+	e := c.entries[name]
+	local := e != nil && (e.dirty || e.writers > 0)
+	c.mu.Unlock()
+	if !local {
+		return nil, false
+	}
+	info, err := os.Stat(c.localPath(name))
+	return info, err == nil
+}
+
+// mergePending adds the files of dir waiting for upload to the remote listing; err is the
+// remote's, which only stands when no such files exist
+func (c *CachingBackend) mergePending(dir string, entries []os.DirEntry, err error) ([]os.DirEntry, error) {
+	listed := map[string]bool{}
+	for _, e := range entries {
+		listed[e.Name()] = true
+	}
+
+	c.mu.Lock()
+	var extra []string
+	for n, e := range c.entries {
+		if (e.dirty || e.writers > 0) && path.Dir(n) == dir && !listed[path.Base(n)] {
+			extra = append(extra, n)
+		}
+	}
+	c.mu.Unlock()
+
+	for _, n := range extra {
+		if info, serr := os.Stat(c.localPath(n)); serr == nil {
+			entries = append(entries, fs.FileInfoToDirEntry(info))
+		}
+	}
+	if len(entries) == 0 && err != nil {
+		return nil, err
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Name() < entries[j].Name() })
+	return entries, nil
+}
+
+// dropPending is drop also cancelling uploads; it reports whether one was cancelled
+func (c *CachingBackend) dropPending(name string, tree bool) bool {
+	c.mu.Lock()
+	pending := false
+	for n, e := range c.entries {
+		if within(n, name, tree) && e.dirty {
+			pending = true
+		}
+	}
+	c.mu.Unlock()
+
+	c.drop(name, tree)
+	if pending {
+		c.saveJournal()
+	}
+	return pending
+}
+
+// pendingUploads returns the files waiting for upload at or below name, skipping the ones
+// still open for writing, which are queued when closed
+func (c *CachingBackend) pendingUploads(name string, tree bool) []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	var names []string
+	for n, e := range c.entries {
+		if e.dirty && e.writers == 0 && within(n, name, tree) {
+			names = append(names, n)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// settle uploads the files waiting for upload at or below name
+func (c *CachingBackend) settle(name string, tree bool) error {
+	var errs []error
+	for _, n := range c.pendingUploads(name, tree) {
+		if err := c.upload(n); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// upload copies the cached file name to the remote. A write landing meanwhile keeps the file
+// queued for another upload.
+func (c *CachingBackend) upload(name string) error {
+	c.uploadMu.Lock()
+	defer c.uploadMu.Unlock()
+
+	c.mu.Lock()
+	e := c.entries[name]
+	if e == nil || !e.dirty || e.writers > 0 {
+		c.mu.Unlock()
+		return nil
+	}
+	gen := e.gen
+	c.mu.Unlock()
+
+	local := c.localPath(name)
+	acquireFDs(1)
+	src, err := os.Open(local)
+	if err != nil {
+		releaseFDs(1)
+		return cachePathError("upload", name, err)
+	}
+	err = func() error {
+		defer releaseFDs(1)
+		defer src.Close()
+
+		info, err := src.Stat()
+		if err != nil {
+			return err
+		}
+		dst, err := c.remote.OpenFile(name, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, info.Mode().Perm())
+		if err != nil {
+			return err
+		}
+		_, err = io.Copy(dst, src)
+		if cerr := dst.Close(); err == nil {
+			err = cerr
+		}
+		return err
+	}()
+	if err != nil {
+		return err
+	}
+
+	info, err := c.remote.Stat(name)
+	if err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	current := c.entries[name] == e && e.gen == gen
+	if current {
+		// The copy now matches the remote, like a downloaded one
+		os.Chtimes(local, time.Now(), info.ModTime())
+		e.dirty = false
+		e.modTime = info.ModTime()
+		e.checked = time.Now()
+	}
+	c.mu.Unlock()
+
+	if current {
+		c.saveJournal()
+	}
+	return nil
+}
+
+// Flush uploads the files waiting for upload, retrying failed uploads until they succeed or
+// ctx ends, and then returns ctx.Err() joined with the last upload error. Files still open
+// for writing are not waited for. Without write-back it returns nil.
+func (c *CachingBackend) Flush(ctx context.Context) error {
+	retry := minUploadRetry
+	for {
+		err := c.settle("/", true)
+		if err == nil && len(c.pendingUploads("/", true)) == 0 {
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			return errors.Join(ctx.Err(), err)
+		case <-time.After(retry):
+		}
+		retry = min(2*retry, maxUploadRetry)
+	}
+}
+
+// startUploads runs the background uploader until Close
+func (c *CachingBackend) startUploads() {
+	c.kick = make(chan struct{}, 1)
+	c.stop = make(chan struct{})
+	c.stopped = make(chan struct{})
+	registerCloser(c)
+	go c.uploadLoop()
+	c.kickUploads()
+}
+
+func (c *CachingBackend) kickUploads() {
+	select {
+	case c.kick <- struct{}{}:
+	default:
+	}
+}
+
+func (c *CachingBackend) uploadLoop() {
+	defer close(c.stopped)
+
+	retry := minUploadRetry
+	var wait <-chan time.Time
+	for {
+		select {
+		case <-c.stop:
+			return
+		case <-c.kick:
+		case <-wait:
+		}
+		wait = nil
+
+		if err := c.settle("/", true); err != nil {
+			warnPrinter("CachingBackend (upload): "+err.Error(), c.dir)
+			wait = time.After(retry)
+			retry = min(2*retry, maxUploadRetry)
+			continue
+		}
+		retry = minUploadRetry
+	}
+}
+
+// Close stops the background uploads. Files still waiting for upload stay in the journal
+// and are uploaded by the next CachingBackend on the same directory; call Flush first to
+// upload them now.
+func (c *CachingBackend) Close() error {
+	if !c.writeBack {
+		return nil
+	}
+	c.closeOnce.Do(func() {
+		unregisterCloser(c)
+		close(c.stop)
+		<-c.stopped
+	})
+	return nil
+}
+
+func (c *CachingBackend) journalPath() string {
+	return filepath.Join(c.dir, writeBackJournal)
+}
+
+// loadJournal marks the files an earlier run left waiting for upload
+func (c *CachingBackend) loadJournal() error {
+	var names []string
+	if _, err := os.Stat(c.journalPath()); os.IsNotExist(err) {
+		return nil
+	}
+	if err := ReadJSON(c.journalPath(), &names); err != nil {
+		return err
+	}
+	for _, name := range names {
+		e := c.entries[memPath(name)]
+		if e == nil {
+			warnPrinter("CachingBackend: pending upload lost from the cache", name)
+			continue
+		}
+		e.dirty = true
+	}
+	return nil
+}
+
+// saveJournal records the files waiting for upload, removing the journal when there are none
+func (c *CachingBackend) saveJournal() {
+	c.journalMu.Lock()
+	defer c.journalMu.Unlock()
+
+	c.mu.Lock()
+	names := []string{}
+	for n, e := range c.entries {
+		if e.dirty {
+			names = append(names, n)
+		}
+	}
+	c.mu.Unlock()
+	sort.Strings(names)
+
+	if len(names) == 0 {
+		if err := os.Remove(c.journalPath()); err != nil && !os.IsNotExist(err) {
+			warnPrinter("CachingBackend (journal): "+err.Error(), c.journalPath())
+		}
+		return
+	}
+	// WriteJSON logs failures; the uploads go on from memory
+	WriteJSON(c.journalPath(), names, 0644)
+}
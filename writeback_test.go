@@ -0,0 +1,221 @@
+package GMSFS
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// newTestWriteBack returns a write-back cache in dir over remote, closed at the end of the test
+func newTestWriteBack(t *testing.T, remote *MemBackend, dir string, maxSize int64) *CachingBackend {
+	t.Helper()
+	c, err := NewCachingBackend(remote, CacheOptions{Dir: dir, MaxSize: maxSize, WriteBack: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { c.Close() })
+	return c
+}
+
+// blockUploads makes every upload below /up fail until ClearFaults
+func blockUploads(m *MemBackend) {
+	m.InjectFault(MemFault{Op: "open", Path: "/up/*", Err: syscall.EIO})
+}
+
+func writeCached(t *testing.T, c *CachingBackend, name string, content string) {
+	t.Helper()
+	f, err := c.Create(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := io.WriteString(f, content); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func flushTest(t *testing.T, c *CachingBackend) {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := c.Flush(ctx); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestWriteBack(t *testing.T) {
+	remote := NewMemBackend()
+	if err := remote.MkdirAll("/up", 0755); err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	c := newTestWriteBack(t, remote, dir, 0)
+	blockUploads(remote)
+
+	writeCached(t, c, "/up/a.txt", "alpha")
+	if _, err := remote.Stat("/up/a.txt"); !os.IsNotExist(err) {
+		t.Errorf("remote has the file before its upload: %v", err)
+	}
+
+	// Until then the backend shows the local version
+	if info, err := c.Stat("/up/a.txt"); err != nil || info.Size() != 5 {
+		t.Errorf("Stat = %v, %v", info, err)
+	}
+	if entries, err := c.ReadDir("/up"); err != nil || len(entries) != 1 || entries[0].Name() != "a.txt" {
+		t.Errorf("ReadDir = %v, %v", entries, err)
+	}
+	if data, err := c.ReadFile("/up/a.txt"); err != nil || string(data) != "alpha" {
+		t.Errorf("ReadFile = %q, %v", data, err)
+	}
+	var journal []string
+	if err := ReadJSON(filepath.Join(dir, writeBackJournal), &journal); err != nil || len(journal) != 1 || journal[0] != "/up/a.txt" {
+		t.Errorf("journal = %v, %v", journal, err)
+	}
+
+	remote.ClearFaults()
+	flushTest(t, c)
+	if data, err := remote.ReadFile("/up/a.txt"); err != nil || string(data) != "alpha" {
+		t.Errorf("remote after Flush = %q, %v", data, err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, writeBackJournal)); !os.IsNotExist(err) {
+		t.Errorf("journal after Flush: %v", err)
+	}
+
+	// Appending pulls the remote content first
+	f, err := c.OpenFile("/up/a.txt", os.O_WRONLY|os.O_APPEND, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	io.WriteString(f, " beta")
+	f.Close()
+	flushTest(t, c)
+	if data, _ := remote.ReadFile("/up/a.txt"); string(data) != "alpha beta" {
+		t.Errorf("remote after append = %q", data)
+	}
+
+	if _, err := c.OpenFile("/up/a.txt", os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644); !os.IsExist(err) {
+		t.Errorf("exclusive create of an existing file: %v", err)
+	}
+	if _, err := c.OpenFile("/up/missing", os.O_WRONLY, 0); !os.IsNotExist(err) {
+		t.Errorf("open of a missing file for writing: %v", err)
+	}
+}
+
+func TestWriteBackFlushError(t *testing.T) {
+	remote := NewMemBackend()
+	remote.MkdirAll("/up", 0755)
+	c := newTestWriteBack(t, remote, t.TempDir(), 0)
+	blockUploads(remote)
+
+	writeCached(t, c, "/up/a.txt", "alpha")
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	err := c.Flush(ctx)
+	if !errors.Is(err, context.DeadlineExceeded) || !errors.Is(err, syscall.EIO) {
+		t.Errorf("Flush with a failing remote: %v", err)
+	}
+
+	// Chmod needs the file on the remote, so it fails with the upload
+	if err := c.Chmod("/up/a.txt", 0600); !errors.Is(err, syscall.EIO) {
+		t.Errorf("Chmod with a failing upload: %v", err)
+	}
+}
+
+func TestWriteBackJournalSurvivesRestart(t *testing.T) {
+	remote := NewMemBackend()
+	remote.MkdirAll("/up", 0755)
+	dir := t.TempDir()
+	c := newTestWriteBack(t, remote, dir, 0)
+	blockUploads(remote)
+
+	writeCached(t, c, "/up/a.txt", "alpha")
+	writeCached(t, c, "/up/b.txt", "beta")
+	if err := c.Close(); err != nil {
+		t.Fatal(err)
+	}
+	remote.ClearFaults()
+
+	c = newTestWriteBack(t, remote, dir, 0)
+	flushTest(t, c)
+	for name, want := range map[string]string{"/up/a.txt": "alpha", "/up/b.txt": "beta"} {
+		if data, err := remote.ReadFile(name); err != nil || string(data) != want {
+			t.Errorf("remote %s after restart = %q, %v", name, data, err)
+		}
+	}
+
+	// A journal that does not parse fails the next start
+	if err := os.WriteFile(filepath.Join(dir, writeBackJournal), []byte("["), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := NewCachingBackend(remote, CacheOptions{Dir: dir, WriteBack: true}); err == nil {
+		t.Error("NewCachingBackend with a corrupt journal succeeded")
+	}
+}
+
+func TestWriteBackRemoveAndRename(t *testing.T) {
+	remote := NewMemBackend()
+	remote.MkdirAll("/up", 0755)
+	dir := t.TempDir()
+	c := newTestWriteBack(t, remote, dir, 0)
+	blockUploads(remote)
+
+	// Removing a file never uploaded cancels its upload
+	writeCached(t, c, "/up/gone.txt", "gone")
+	if err := c.Remove("/up/gone.txt"); err != nil {
+		t.Errorf("Remove of a pending file: %v", err)
+	}
+	if err := c.Remove("/up/gone.txt"); !os.IsNotExist(err) {
+		t.Errorf("second Remove: %v", err)
+	}
+	remote.ClearFaults()
+	flushTest(t, c)
+	if _, err := remote.Stat("/up/gone.txt"); !os.IsNotExist(err) {
+		t.Errorf("removed file uploaded: %v", err)
+	}
+
+	// Rename uploads first, so the remote renames the new content
+	blockUploads(remote)
+	writeCached(t, c, "/up/old.txt", "content")
+	remote.ClearFaults()
+	if err := c.Rename("/up/old.txt", "/up/new.txt"); err != nil {
+		t.Fatal(err)
+	}
+	if data, err := remote.ReadFile("/up/new.txt"); err != nil || string(data) != "content" {
+		t.Errorf("remote after Rename = %q, %v", data, err)
+	}
+	if _, err := c.Stat("/up/old.txt"); !os.IsNotExist(err) {
+		t.Errorf("old name after Rename: %v", err)
+	}
+}
+
+func TestWriteBackKeepsPendingFiles(t *testing.T) {
+	remote := NewMemBackend()
+	remote.MkdirAll("/up", 0755)
+	dir := t.TempDir()
+	c := newTestWriteBack(t, remote, dir, 4)
+	blockUploads(remote)
+
+	writeCached(t, c, "/up/a.txt", "alpha")
+	writeCached(t, c, "/up/b.txt", "beta")
+	for _, name := range []string{"a.txt", "b.txt"} {
+		if _, err := os.Stat(filepath.Join(dir, "up", name)); err != nil {
+			t.Errorf("pending %s evicted: %v", name, err)
+		}
+	}
+
+	// Once uploaded they are ordinary cached files again and make way
+	remote.ClearFaults()
+	flushTest(t, c)
+	writeCached(t, c, "/up/c.txt", "c")
+	flushTest(t, c)
+	if size := c.Size(); size > 4 {
+		t.Errorf("Size after uploads = %d, want at most 4", size)
+	}
+}
@@ -0,0 +1,433 @@
+package GMSFS
+
+import (
+	"archive/zip"
+	"compress/flate"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// ErrUnsafePath is returned for archive entries that would land outside the destination
+var ErrUnsafePath = errors.New("unsafe path in archive")
+
+// ZipOptions adjusts ZipDir and Unzip
+type ZipOptions struct {
+	Include   []string // Only files matching one of these globs; empty means all files
+	Exclude   []string // Skip files and directories matching any of these globs
+	Level     int      // Deflate level from 1 (fastest) to 9 (smallest); 0 uses the default
+	Store     bool     // Store entries without compression
+	Overwrite bool     // Let Unzip replace existing files
+}
+
+// ZipDir writes the contents of src to the zip archive dstZip. Entry names are relative to
+// src and use forward slashes. Globs are matched against both the relative path and the base
+// name, so "*.log" and "logs/*" both work; an excluded directory is skipped entirely.
+// Symlinks are stored as links.
+func ZipDir(src string, dstZip string, opts ZipOptions) (err error) {
+	src = cleanPath(src)
+	dstZip = cleanPath(dstZip)
+	if err := requireLocal("zip", src, dstZip); err != nil {
+		errorPrinter("ZipDir: "+err.Error(), src)
+		return err
+	}
+
+	if err := validateArchiveGlobs(opts.Include, opts.Exclude); err != nil {
+		return err
+	}
+	level := flate.DefaultCompression
+	if opts.Level != 0 {
+		if opts.Level < flate.BestSpeed || opts.Level > flate.BestCompression {
+			return fmt.Errorf("zip: compression level %d out of range 1-9", opts.Level)
+		}
+		level = opts.Level
+	}
+
+	_, j := startJob(context.Background(), "ZipDir", src, dstZip)
+	defer j.done()
+
+	// The archive and the entry being read
+	acquireFDs(2)
+	defer releaseFDs(2)
+
+	simulateOp()
+	out, err := os.Create(dstZip)
+	if err != nil {
+		errorPrinter("ZipDir (os.Create): "+err.Error(), dstZip)
+		return err
+	}
+	defer func() {
+		if cerr := out.Close(); err == nil {
+			err = cerr
+		}
+		invalidateStat(dstZip)
+		if err != nil {
+			os.Remove(dstZip)
+		}
+	}()
+
+	zw := zip.NewWriter(simulatedWriter(out))
+	zw.RegisterCompressor(zip.Deflate, func(w io.Writer) (io.WriteCloser, error) {
+		return flate.NewWriter(w, level)
+	})
+
+	err = filepath.WalkDir(src, func(name string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if name == src {
+			return nil
+		}
+		// Don't archive the archive when it is written inside src
+		if name == dstZip {
+			return nil
+		}
+
+		rel, err := filepath.Rel(src, name)
+		if err != nil {
+			return err
+		}
+		rel = filepath.ToSlash(rel)
+
+		if archiveMatch(rel, opts.Exclude) {
+			if d.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !d.IsDir() && !archiveIncluded(rel, opts.Include) {
+			return nil
+		}
+		// Directories are implied by their files when only some files are included
+		if d.IsDir() && len(opts.Include) > 0 {
+			return nil
+		}
+
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		return zipEntry(zw, name, rel, info, opts)
+	})
+	if err != nil {
+		errorPrinter("ZipDir: "+err.Error(), src)
+		return err
+	}
+
+	if err = zw.Close(); err != nil {
+		errorPrinter("ZipDir (Close): "+err.Error(), dstZip)
+	}
+	return err
+}
+
+// zipEntry adds one file, directory or symlink to the archive
+func zipEntry(zw *zip.Writer, name string, rel string, info os.FileInfo, opts ZipOptions) error {
+	header, err := zip.FileInfoHeader(info)
+	if err != nil {
+		return err
+	}
+	header.Name = rel
+
+	switch {
+	case info.IsDir():
+		header.Name += "/"
+		header.Method = zip.Store
+		_, err = zw.CreateHeader(header)
+		return err
+
+	case info.Mode()&os.ModeSymlink != 0:
+		target, err := os.Readlink(name)
+		if err != nil {
+			return err
+		}
+		header.Method = zip.Store
+		w, err := zw.CreateHeader(header)
+		if err != nil {
+			return err
+		}
+		_, err = io.WriteString(w, filepath.ToSlash(target))
+		return err
+
+	case !info.Mode().IsRegular():
+		return fmt.Errorf("zip: %s: %w", name, ErrSpecialFile)
+	}
+
+	if opts.Store {
+		header.Method = zip.Store
+	} else {
+		header.Method = zip.Deflate
+	}
+	w, err := zw.CreateHeader(header)
+	if err != nil {
+		return err
+	}
+
+	simulateOp()
+	in, err := os.Open(name)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	_, err = io.Copy(w, simulatedReader(in))
+	return err
+}
+
+// Unzip extracts the zip archive srcZip into dstDir. Entries with absolute paths or ".."
+// components, and symlinks pointing outside dstDir, are refused with ErrUnsafePath before
+// anything is written for them. Existing files are an error unless opts.Overwrite is set.
+func Unzip(srcZip string, dstDir string, opts ZipOptions) error {
+	srcZip = cleanPath(srcZip)
+	dstDir = cleanPath(dstDir)
+	if err := requireLocal("unzip", srcZip, dstDir); err != nil {
+		errorPrinter("Unzip: "+err.Error(), srcZip)
+		return err
+	}
+
+	if err := validateArchiveGlobs(opts.Include, opts.Exclude); err != nil {
+		return err
+	}
+
+	_, j := startJob(context.Background(), "Unzip", srcZip, dstDir)
+	defer j.done()
+
+	acquireFDs(2)
+	defer releaseFDs(2)
+
+	simulateOp()
+	zr, err := zip.OpenReader(srcZip)
+	if err != nil {
+		errorPrinter("Unzip (zip.OpenReader): "+err.Error(), srcZip)
+		return err
+	}
+	defer zr.Close()
+
+	if err := os.MkdirAll(dstDir, 0755); err != nil {
+		errorPrinter("Unzip (os.MkdirAll): "+err.Error(), dstDir)
+		return err
+	}
+	defer invalidateStatTree(dstDir)
+
+	for _, f := range zr.File {
+		if err := unzipEntry(f, dstDir, opts); err != nil {
+			errorPrinter("Unzip: "+err.Error(), srcZip)
+			return err
+		}
+	}
+
+	return nil
+}
+
+// unzipEntry extracts one entry if the options select it
+func unzipEntry(f *zip.File, dstDir string, opts ZipOptions) error {
+	rel, err := archiveEntryPath(f.Name)
+	if err != nil {
+		return err
+	}
+	if rel == "." {
+		return nil
+	}
+
+	if archiveExcludedPath(rel, opts.Exclude) {
+		return nil
+	}
+	mode := f.Mode()
+	if !mode.IsDir() && !archiveIncluded(rel, opts.Include) {
+		return nil
+	}
+
+	if err := archiveParentsSafe(dstDir, rel); err != nil {
+		return err
+	}
+
+	target := filepath.Join(dstDir, filepath.FromSlash(rel))
+	if mode.IsDir() {
+		return os.MkdirAll(target, mode.Perm()|0700)
+	}
+	if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+		return err
+	}
+	if err := archiveReplace(target, opts.Overwrite); err != nil {
+		return err
+	}
+
+	rc, err := f.Open()
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+
+	if mode&os.ModeSymlink != 0 {
+		link, err := io.ReadAll(io.LimitReader(rc, 4096))
+		if err != nil {
+			return err
+		}
+		if err := archiveLinkSafe(dstDir, rel, string(link)); err != nil {
+			return err
+		}
+		return os.Symlink(filepath.FromSlash(string(link)), target)
+	}
+	if !mode.IsRegular() {
+		return fmt.Errorf("unzip: %s: %w", f.Name, ErrSpecialFile)
+	}
+
+	simulateOp()
+	out, err := os.OpenFile(target, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, mode.Perm())
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(simulatedWriter(out), rc)
+	if cerr := out.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return err
+	}
+
+	if !f.Modified.IsZero() {
+		os.Chtimes(target, f.Modified, f.Modified)
+	}
+	return nil
+}
+
+// archiveReplace clears an existing destination file when overwriting is allowed
+func archiveReplace(target string, overwrite bool) error {
+	info, err := os.Lstat(target)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if !overwrite || info.IsDir() {
+		return &os.PathError{Op: "extract", Path: target, Err: os.ErrExist}
+	}
+	return os.Remove(target)
+}
+
+// archiveEntryPath cleans an entry name and refuses names that escape the destination
+func archiveEntryPath(name string) (string, error) {
+	slashed := strings.ReplaceAll(name, `\`, "/")
+	if path.IsAbs(slashed) || filepath.IsAbs(name) || filepath.VolumeName(name) != "" ||
+		(len(slashed) >= 2 && slashed[1] == ':') {
+		return "", fmt.Errorf("%w: %s", ErrUnsafePath, name)
+	}
+
+	rel := path.Clean(slashed)
+	if rel == ".." || strings.HasPrefix(rel, "../") {
+		return "", fmt.Errorf("%w: %s", ErrUnsafePath, name)
+	}
+	return rel, nil
+}
+
+// archiveParentsSafe refuses to extract through a symlink, so links from the archive (or
+// already in the destination) can't redirect later entries elsewhere
+func archiveParentsSafe(dstDir string, rel string) error {
+	dir := dstDir
+	parts := strings.Split(rel, "/")
+	for _, part := range parts[:len(parts)-1] {
+		dir = filepath.Join(dir, part)
+		info, err := os.Lstat(dir)
+		if os.IsNotExist(err) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if info.Mode()&os.ModeSymlink != 0 {
+			return fmt.Errorf("%w: %s passes through symlink %s", ErrUnsafePath, rel, dir)
+		}
+	}
+	return nil
+}
+
+// archiveLinkSafe refuses symlink targets that resolve outside dstDir, following the links
+// already there as Root does. Targets may only climb with leading "..": in one like "d/.."
+// the climb starts wherever d points, which a link extracted later can move outside.
+func archiveLinkSafe(dstDir string, rel string, link string) error {
+	link = strings.ReplaceAll(link, `\`, "/")
+	unsafe := fmt.Errorf("%w: %s -> %s", ErrUnsafePath, rel, link)
+	if link == "" || path.IsAbs(link) || filepath.IsAbs(link) || (len(link) >= 2 && link[1] == ':') {
+		return unsafe
+	}
+
+	named := false
+	for _, part := range strings.Split(link, "/") {
+		switch part {
+		case "", ".":
+		case "..":
+			if named {
+				return unsafe
+			}
+		default:
+			named = true
+		}
+	}
+
+	root, err := NewRoot(dstDir)
+	if err != nil {
+		return err
+	}
+	if _, err := root.resolve("extract", path.Join(path.Dir(rel), link), true); err != nil {
+		if errors.Is(err, ErrPathEscapes) {
+			return unsafe
+		}
+		return err
+	}
+	return nil
+}
+
+// archiveIncluded reports whether a slash-separated relative path passes the include globs
+func archiveIncluded(rel string, include []string) bool {
+	return len(include) == 0 || archiveMatch(rel, include)
+}
+
+// archiveExcludedPath reports whether rel or one of its parent directories is excluded
+func archiveExcludedPath(rel string, exclude []string) bool {
+	for dir := rel; dir != "."; dir = path.Dir(dir) {
+		if archiveMatch(dir, exclude) {
+			return true
+		}
+	}
+	return false
+}
+
+// archiveMatch reports whether a slash-separated relative path or its base name matches a glob
+func archiveMatch(rel string, patterns []string) bool {
+	base := path.Base(rel)
+	for _, pattern := range patterns {
+		// Malformed patterns were refused by validateArchiveGlobs and match nothing
+		expanded, _ := expandBraces(pattern)
+		for _, p := range expanded {
+			if matched, _ := path.Match(p, rel); matched {
+				return true
+			}
+			if matched, _ := path.Match(p, base); matched {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// validateArchiveGlobs rejects malformed patterns up front instead of silently matching nothing
+func validateArchiveGlobs(include []string, exclude []string) error {
+	for _, pattern := range append(append([]string(nil), include...), exclude...) {
+		expanded, err := expandBraces(pattern)
+		if err != nil {
+			return fmt.Errorf("bad archive pattern %q: %w", pattern, err)
+		}
+		for _, p := range expanded {
+			if _, err := path.Match(p, ""); err != nil {
+				return fmt.Errorf("bad archive pattern %q: %w", pattern, err)
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,165 @@
+package GMSFS
+
+import (
+	"archive/zip"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// testEntry is an entry of a test archive; a link is a symlink to its content
+type testEntry struct {
+	name    string
+	content string
+	link    bool
+}
+
+// requireSymlinks skips the test where symlinks can't be made, e.g. on Windows without the
+// privilege
+func requireSymlinks(t *testing.T) {
+	t.Helper()
+	if err := os.Symlink(".", filepath.Join(t.TempDir(), "link")); err != nil {
+		t.Skip(err)
+	}
+}
+
+// writeTestZip writes entries to a new zip archive and returns its name
+func writeTestZip(t *testing.T, entries []testEntry) string {
+	t.Helper()
+	name := filepath.Join(t.TempDir(), "test.zip")
+	out, err := os.Create(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer out.Close()
+
+	zw := zip.NewWriter(out)
+	for _, e := range entries {
+		h := &zip.FileHeader{Name: e.name, Method: zip.Store}
+		h.SetMode(0644)
+		if e.link {
+			h.SetMode(os.ModeSymlink | 0777)
+		}
+		w, err := zw.CreateHeader(h)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := w.Write([]byte(e.content)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return name
+}
+
+func TestZipRoundTrip(t *testing.T) {
+	src := t.TempDir()
+	for name, content := range map[string]string{"a.txt": "a", "sub/b.log": "b", "sub/deep/c.txt": "c"} {
+		p := filepath.Join(src, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(p, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	archive := filepath.Join(t.TempDir(), "out.zip")
+	if err := ZipDir(src, archive, ZipOptions{Exclude: []string{"*.log"}}); err != nil {
+		t.Fatal(err)
+	}
+
+	dst := t.TempDir()
+	if err := Unzip(archive, dst, ZipOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	for name, want := range map[string]string{"a.txt": "a", "sub/deep/c.txt": "c"} {
+		if data, err := os.ReadFile(filepath.Join(dst, filepath.FromSlash(name))); err != nil || string(data) != want {
+			t.Errorf("%s = %q, %v", name, data, err)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(dst, "sub", "b.log")); !os.IsNotExist(err) {
+		t.Errorf("excluded file extracted: %v", err)
+	}
+
+	// Existing files stay unless Overwrite is set
+	if err := Unzip(archive, dst, ZipOptions{}); !errors.Is(err, os.ErrExist) {
+		t.Errorf("Unzip over existing files = %v, want ErrExist", err)
+	}
+	if err := Unzip(archive, dst, ZipOptions{Overwrite: true}); err != nil {
+		t.Errorf("Unzip with Overwrite = %v", err)
+	}
+
+	if err := ZipDir(src, archive, ZipOptions{Level: 12}); err == nil {
+		t.Error("ZipDir with level 12 succeeded")
+	}
+}
+
+func TestUnzipRefusesUnsafeEntries(t *testing.T) {
+	requireSymlinks(t)
+	tests := []struct {
+		name    string
+		entries []testEntry
+	}{
+		{"parent", []testEntry{{name: "../evil.txt"}}},
+		{"absolute link", []testEntry{{name: "l", content: "/etc", link: true}}},
+		{"climbing link", []testEntry{{name: "sub/l", content: "../../x", link: true}}},
+		{"link then ..", []testEntry{
+			{name: "s1", content: ".", link: true},
+			{name: "s2", content: "s1/..", link: true},
+		}},
+		{"link made later", []testEntry{
+			{name: "s2", content: "d/..", link: true},
+			{name: "d", content: ".", link: true},
+		}},
+		{"through a link", []testEntry{
+			{name: "s1", content: ".", link: true},
+			{name: "s1/x.txt", content: "x"},
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			base := t.TempDir()
+			dst := filepath.Join(base, "dst")
+			err := Unzip(writeTestZip(t, tt.entries), dst, ZipOptions{})
+			if !errors.Is(err, ErrUnsafePath) {
+				t.Errorf("Unzip = %v, want ErrUnsafePath", err)
+			}
+			if entries, _ := os.ReadDir(base); len(entries) != 1 {
+				t.Errorf("extracted next to the destination: %v", entries)
+			}
+		})
+	}
+}
+
+func TestUnzipRefusesLinkThroughExistingLink(t *testing.T) {
+	dst := t.TempDir()
+	outside := t.TempDir()
+	requireSymlinks(t)
+	if err := os.Symlink(outside, filepath.Join(dst, "ext")); err != nil {
+		t.Fatal(err)
+	}
+	archive := writeTestZip(t, []testEntry{{name: "l", content: "ext/secret", link: true}})
+	if err := Unzip(archive, dst, ZipOptions{}); !errors.Is(err, ErrUnsafePath) {
+		t.Errorf("Unzip = %v, want ErrUnsafePath", err)
+	}
+}
+
+func TestUnzipLinkChain(t *testing.T) {
+	requireSymlinks(t)
+	dst := t.TempDir()
+	archive := writeTestZip(t, []testEntry{
+		{name: "lib.so.1.2", content: "lib"},
+		{name: "lib.so.1", content: "lib.so.1.2", link: true},
+		{name: "lib.so", content: "lib.so.1", link: true},
+		{name: "sub/up", content: "../lib.so", link: true},
+	})
+	if err := Unzip(archive, dst, ZipOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	if data, err := os.ReadFile(filepath.Join(dst, "sub", "up")); err != nil || string(data) != "lib" {
+		t.Errorf("sub/up = %q, %v", data, err)
+	}
+}